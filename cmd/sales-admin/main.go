@@ -2,20 +2,22 @@ package main
 
 import (
 	"context"
-	"crypto/rand"
-	"crypto/rsa"
-	"crypto/x509"
-	"encoding/pem"
+	"crypto"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"net/url"
 	"os"
 	"time"
 
+	"github.com/arammikayelyan/garagesale/internal/fix"
 	"github.com/arammikayelyan/garagesale/internal/platform/auth"
 	"github.com/arammikayelyan/garagesale/internal/platform/conf"
 	"github.com/arammikayelyan/garagesale/internal/platform/database"
+	"github.com/arammikayelyan/garagesale/internal/platform/mail"
 	"github.com/arammikayelyan/garagesale/internal/schema"
 	"github.com/arammikayelyan/garagesale/internal/user"
+	"github.com/google/uuid"
 	"github.com/pkg/errors"
 )
 
@@ -36,7 +38,25 @@ func run() error {
 			Name       string `conf:"default:postgres"`
 			DisableTLS bool   `conf:"default:false"`
 		}
-		Args conf.Args
+		Mail struct {
+			Provider     string `conf:"default:log,help:log or smtp"`
+			SMTPAddr     string `conf:"default:localhost:25"`
+			SMTPUser     string
+			SMTPPassword string        `conf:"noprint"`
+			From         string        `conf:"default:Garage Sale <noreply@localhost>"`
+			VerifyURL    string        `conf:"default:http://localhost:8000/verify-email,help:page users verify their email address on"`
+			VerifyTTL    time.Duration `conf:"default:72h,help:how long email verification links stay valid"`
+		}
+		Keys struct {
+			Retire    time.Duration `conf:"default:2h,help:how long rotated out signing keys still verify tokens"`
+			Algorithm string        `conf:"default:RS256,help:algorithm generated keys sign with: RS256 or ES256 or EdDSA"`
+		}
+		Fix struct {
+			Apply bool   `conf:"default:false,help:commit a fix instead of only reporting what it would change"`
+			Actor string `conf:"help:who applies a fix; recorded in its audit entry"`
+		}
+		Against int `conf:"help:schema version to check compatibility with"`
+		Args    conf.Args
 	}
 
 	if err := conf.Parse(os.Args[1:], "SALES", &cfg); err != nil {
@@ -58,6 +78,7 @@ func run() error {
 		Host:       cfg.DB.Host,
 		Name:       cfg.DB.Name,
 		DisableTLS: cfg.DB.DisableTLS,
+		AppName:    "sales-admin",
 	}
 
 	var err error
@@ -71,8 +92,56 @@ func run() error {
 	case "useradd":
 		err = useradd(dbConfig, cfg.Args.Num(1), cfg.Args.Num(2))
 
+	case "users":
+		switch cfg.Args.Num(1) {
+		case "import":
+			var mailer mail.Mailer
+			mailer, err = createMail(cfg.Mail.Provider, cfg.Mail.SMTPAddr, cfg.Mail.SMTPUser, cfg.Mail.SMTPPassword, cfg.Mail.From)
+			if err == nil {
+				err = usersImport(dbConfig, mailer, cfg.Mail.VerifyURL, cfg.Mail.VerifyTTL, cfg.Args.Num(2))
+			}
+		default:
+			err = errors.New("users command must be followed by import")
+		}
+
+	case "schema":
+		switch cfg.Args.Num(1) {
+		case "check":
+			err = schemaCheck(dbConfig, cfg.Against)
+		default:
+			err = errors.New("schema command must be followed by check")
+		}
+
 	case "keygen":
-		err = keygen(cfg.Args.Num(1))
+		err = keygen(cfg.Args.Num(1), cfg.Keys.Algorithm)
+
+	case "keys":
+		switch cfg.Args.Num(1) {
+		case "rotate":
+			err = keysRotate(dbConfig, cfg.Keys.Retire, cfg.Keys.Algorithm, cfg.Args.Num(2))
+		default:
+			err = errors.New("keys command must be followed by rotate")
+		}
+
+	case "fix":
+		var f fix.Fix
+		switch cfg.Args.Num(1) {
+		case "reassign-products":
+			var productIDs []string
+			if len(cfg.Args) > 4 {
+				productIDs = cfg.Args[4:]
+			}
+			f = fix.ReassignProducts(cfg.Args.Num(2), cfg.Args.Num(3), productIDs)
+		case "merge-users":
+			f = fix.MergeUsers(cfg.Args.Num(2), cfg.Args.Num(3))
+		case "rollups":
+			f = fix.RecomputeRollups()
+		default:
+			err = errors.New("fix command must be followed by reassign-products, merge-users or rollups")
+		}
+		if f != nil {
+			err = runFix(dbConfig, f, cfg.Fix.Apply, cfg.Fix.Actor)
+		}
 
 	default:
 		errors.New("Must specify a command")
@@ -100,6 +169,36 @@ func migrate(cfg database.Config) error {
 	return nil
 }
 
+// schemaCheck reports whether this binary and one built at another schema
+// version can share the same database during a blue/green rollout.
+func schemaCheck(cfg database.Config, against int) error {
+	if against == 0 {
+		return errors.New("schema check requires --against=<version>")
+	}
+
+	db, err := database.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	problems, err := schema.Check(context.Background(), db, against)
+	if err != nil {
+		return errors.Wrap(err, "checking schema")
+	}
+
+	if len(problems) == 0 {
+		fmt.Printf("Schema versions %d and %d are compatible\n", schema.Latest(), against)
+		return nil
+	}
+
+	fmt.Printf("Schema versions %d and %d are incompatible:\n", schema.Latest(), against)
+	for _, p := range problems {
+		fmt.Println("  " + p.String())
+	}
+	return errors.Errorf("found %d schema incompatibilities", len(problems))
+}
+
 func seed(cfg database.Config) error {
 	db, err := database.Open(cfg)
 	if err != nil {
@@ -145,7 +244,7 @@ func useradd(cfg database.Config, email, password string) error {
 		Email:           email,
 		Password:        password,
 		PasswordConfirm: password,
-		Roles:           []string{auth.RoleAdmin, auth.RoleUser},
+		Roles:           []auth.Role{auth.RoleAdmin, auth.RoleUser},
 	}
 
 	u, err := user.Create(ctx, db, nu, time.Now())
@@ -153,20 +252,118 @@ func useradd(cfg database.Config, email, password string) error {
 		return err
 	}
 
+	// The operator vouches for the address so there is nothing to verify.
+	if err := user.MarkVerified(ctx, db, u.ID, time.Now()); err != nil {
+		return err
+	}
+
 	fmt.Println("User created with id:", u.ID)
 	return nil
 }
 
-// keygen creates an x509 private key for signing auth tokens.
-func keygen(path string) error {
+// usersImport creates the users listed in a CSV file and emails each their
+// temporary password with a link to verify their address.
+func usersImport(cfg database.Config, mailer mail.Mailer, verifyURL string, verifyTTL time.Duration, path string) error {
+	if path == "" {
+		return errors.New("users import must be called with the path of a CSV file")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "opening import file")
+	}
+	defer f.Close()
+
+	rows, failed, err := user.ParseImport(f)
+	if err != nil {
+		return errors.Wrapf(err, "parsing %s", path)
+	}
+
+	db, err := database.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+
+	created, failedRows, err := user.Import(ctx, db, "", rows, time.Now())
+	failed = append(failed, failedRows...)
+
+	for _, imp := range created {
+		token, err := user.RequestVerification(ctx, db, &imp.User, verifyTTL, time.Now())
+		if err == nil {
+			link := verifyURL + "?" + url.Values{"token": {token}}.Encode()
+			err = mailer.Send(ctx, user.Welcome(imp, link))
+		}
+		if err != nil {
+			fmt.Printf("Created %s but could not send the welcome email: %v\n", imp.User.Email, err)
+			continue
+		}
+		fmt.Printf("Created %s\n", imp.User.Email)
+	}
+	for _, f := range failed {
+		fmt.Printf("Skipped line %d %s: %s\n", f.Line, f.Email, f.Reason)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Imported %d users, skipped %d\n", len(created), len(failed))
+	return nil
+}
+
+func runFix(cfg database.Config, f fix.Fix, apply bool, actor string) error {
+	db, err := database.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	r, err := fix.Run(context.Background(), db, f, apply, actor, time.Now())
+	if err != nil {
+		return err
+	}
+
+	verb := "Would change"
+	if apply {
+		verb = "Changed"
+	}
+	fmt.Printf("Fix %s %v\n", r.Kind, r.Args)
+	for _, c := range r.Changes {
+		fmt.Printf("  %s %d %s\n", verb, c.Rows, c.What)
+	}
+	if !apply {
+		fmt.Println("Dry run: nothing was changed. Run again with --fix-apply and --fix-actor to apply it.")
+	}
+	return nil
+}
+
+func createMail(provider, addr, username, password, from string) (mail.Mailer, error) {
+	switch provider {
+	case "log":
+		return mail.Logger{Log: log.New(os.Stdout, "", 0)}, nil
+	case "smtp":
+		return mail.NewSMTP(addr, username, password, from)
+	default:
+		return nil, errors.Errorf("unknown mail provider %q", provider)
+	}
+}
+
+// keygen creates an x509 private key for signing auth tokens with algorithm.
+func keygen(path, algorithm string) error {
 	if path == "" {
 		return errors.New("keygen missing argument for key path")
 	}
 
-	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	key, err := auth.GenerateKey(algorithm)
 	if err != nil {
 		return errors.Wrap(err, "generating keys")
 	}
+	contents, err := auth.MarshalPrivateKey(key)
+	if err != nil {
+		return err
+	}
 
 	file, err := os.Create(path)
 	if err != nil {
@@ -174,12 +371,7 @@ func keygen(path string) error {
 	}
 	defer file.Close()
 
-	block := pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(key),
-	}
-
-	if err := pem.Encode(file, &block); err != nil {
+	if _, err := file.Write(contents); err != nil {
 		return errors.Wrap(err, "encoding to private key")
 	}
 
@@ -188,3 +380,40 @@ func keygen(path string) error {
 	}
 	return nil
 }
+
+// keysRotate stores a new signing key in the database for APIs loading their
+// keys from there. The key is read from path when one is given, so the key of
+// a file can be carried over, and generated for algorithm otherwise.
+func keysRotate(cfg database.Config, retire time.Duration, algorithm, path string) error {
+	var key crypto.Signer
+	if path != "" {
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return errors.Wrap(err, "reading private key")
+		}
+		key, err = auth.ParsePrivateKey(contents)
+		if err != nil {
+			return errors.Wrap(err, "parsing private key")
+		}
+	} else {
+		var err error
+		key, err = auth.GenerateKey(algorithm)
+		if err != nil {
+			return errors.Wrap(err, "generating key")
+		}
+	}
+
+	db, err := database.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	kid := uuid.New().String()
+	if err := user.RotateSigningKey(context.Background(), db, kid, key, retire, time.Now()); err != nil {
+		return err
+	}
+
+	fmt.Printf("Signing key %s is now active; previous keys retire in %v\n", kid, retire)
+	return nil
+}
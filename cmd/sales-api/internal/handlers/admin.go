@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/arammikayelyan/garagesale/internal/fraud"
+	"github.com/arammikayelyan/garagesale/internal/moderation"
+	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/arammikayelyan/garagesale/internal/platform/cache"
+	"github.com/arammikayelyan/garagesale/internal/platform/web"
+	"github.com/arammikayelyan/garagesale/internal/report"
+	"github.com/arammikayelyan/garagesale/internal/upload"
+	"github.com/go-chi/chi"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// statsWindows are the windows the admin dashboard can ask stats for.
+var statsWindows = map[string]time.Duration{
+	"24h": 24 * time.Hour,
+	"7d":  7 * 24 * time.Hour,
+	"30d": 30 * 24 * time.Hour,
+	"90d": 90 * 24 * time.Hour,
+}
+
+// Admin has handler methods backing the internal admin UI.
+type Admin struct {
+	DB    *sqlx.DB
+	Cache *cache.Cache
+
+	// FlagsPage sizes the pages of content flags.
+	FlagsPage web.PageSize
+}
+
+// Stats returns the platform KPIs over the window query parameter which
+// defaults to 7d. Results are cached briefly so the dashboard does not run
+// the aggregate queries on every refresh.
+func (a *Admin) Stats(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.admin.Stats")
+	defer span.End()
+
+	window := r.URL.Query().Get("window")
+	if window == "" {
+		window = "7d"
+	}
+	d, ok := statsWindows[window]
+	if !ok {
+		err := errors.New("window must be one of 24h, 7d, 30d or 90d")
+		return web.NewRequestError(err, http.StatusBadRequest)
+	}
+
+	key := "stats:" + window
+	if st, ok := a.Cache.Get(key); ok {
+		return web.Respond(ctx, w, st, http.StatusOK)
+	}
+
+	now := web.Now(ctx)
+	st, err := report.PlatformStats(ctx, a.DB, now.Add(-d), now)
+	if err != nil {
+		return errors.Wrap(err, "computing platform stats")
+	}
+	a.Cache.Set(key, st)
+
+	return web.Respond(ctx, w, st, http.StatusOK)
+}
+
+// ContentFlags returns a page of content which was saved despite violating
+// the moderation rules, newest first.
+func (a *Admin) ContentFlags(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	page, err := web.ParsePage(r, a.FlagsPage)
+	if err != nil {
+		return err
+	}
+
+	list, err := moderation.ListFlags(ctx, a.DB, page.Limit, page.Offset)
+	if err != nil {
+		return errors.Wrap(err, "listing content flags")
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// InfectedUploads returns a page of the uploaded files the malware scanner
+// rejected, newest first.
+func (a *Admin) InfectedUploads(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	page, err := web.ParsePage(r, a.FlagsPage)
+	if err != nil {
+		return err
+	}
+
+	list, err := upload.ListInfected(ctx, a.DB, page.Limit, page.Offset)
+	if err != nil {
+		return errors.Wrap(err, "listing infected uploads")
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// FraudReviews returns a page of the checkouts held for review, oldest
+// first.
+func (a *Admin) FraudReviews(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	page, err := web.ParsePage(r, a.FlagsPage)
+	if err != nil {
+		return err
+	}
+
+	list, err := fraud.ListReviews(ctx, a.DB, page.Limit, page.Offset)
+	if err != nil {
+		return errors.Wrap(err, "listing fraud reviews")
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// ReviewFraud approves or denies a checkout held for review. The buyer of an
+// approved checkout may check the product out once without being screened.
+func (a *Admin) ReviewFraud(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.admin.ReviewFraud")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	var nr fraud.NewReview
+	if err := web.Decode(r, &nr); err != nil {
+		return errors.Wrap(err, "decoding review")
+	}
+
+	id := chi.URLParam(r, "id")
+	d, err := fraud.Review(ctx, a.DB, claims, id, nr, web.Now(ctx))
+	if err != nil {
+		switch err {
+		case fraud.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case fraud.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		case fraud.ErrReviewed:
+			return web.NewRequestError(err, http.StatusConflict)
+		default:
+			return errors.Wrapf(err, "reviewing decision %q", id)
+		}
+	}
+
+	return web.Respond(ctx, w, d, http.StatusOK)
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/arammikayelyan/garagesale/internal/client"
+	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/arammikayelyan/garagesale/internal/platform/web"
+	"github.com/go-chi/chi"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// grantClientCredentials is the only OAuth 2 grant type the token endpoint
+// supports.
+const grantClientCredentials = "client_credentials"
+
+// Clients has handler methods for services calling the API with client
+// credentials and for admins registering them.
+type Clients struct {
+	DB            *sqlx.DB
+	authenticator *auth.Authenticator
+}
+
+// oauthError is an error response of the token endpoint in the form of RFC
+// 6749 section 5.2 which OAuth 2 client libraries understand.
+type oauthError struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+// Token issues a short lived token to a service for the client_credentials
+// grant of OAuth 2. The client id and secret come in Basic auth or as the
+// client_id and client_secret form parameters; scope optionally narrows the
+// token down to some of the scopes of the client.
+func (c *Clients) Token(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.client.Token")
+	defer span.End()
+
+	w.Header().Set("Cache-Control", "no-store")
+
+	if err := r.ParseForm(); err != nil {
+		return web.Respond(ctx, w, oauthError{"invalid_request", err.Error()}, http.StatusBadRequest)
+	}
+	if gt := r.PostForm.Get("grant_type"); gt != grantClientCredentials {
+		return web.Respond(ctx, w, oauthError{"unsupported_grant_type", "grant_type must be " + grantClientCredentials}, http.StatusBadRequest)
+	}
+
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+
+	scopes, err := auth.ParseScopes(r.PostForm.Get("scope"))
+	if err != nil {
+		return web.Respond(ctx, w, oauthError{"invalid_scope", err.Error()}, http.StatusBadRequest)
+	}
+
+	claims, err := client.Authenticate(ctx, c.DB, id, secret, scopes, c.authenticator.ServiceTTL(), web.Now(ctx))
+	if err != nil {
+		switch err {
+		case client.ErrAuthenticationFailure:
+			return web.Respond(ctx, w, oauthError{"invalid_client", err.Error()}, http.StatusUnauthorized)
+		case client.ErrInvalidScope:
+			return web.Respond(ctx, w, oauthError{"invalid_scope", err.Error()}, http.StatusBadRequest)
+		default:
+			return errors.Wrap(err, "authenticating client")
+		}
+	}
+
+	var tkn struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int    `json:"expires_in"`
+		Scope       string `json:"scope"`
+	}
+	tkn.AccessToken, err = c.authenticator.GenerateToken(claims)
+	if err != nil {
+		return errors.Wrap(err, "generating token")
+	}
+	tkn.TokenType = "Bearer"
+	tkn.ExpiresIn = int(c.authenticator.ServiceTTL().Seconds())
+	tkn.Scope = strings.Join(claims.Scopes, " ")
+
+	return web.Respond(ctx, w, tkn, http.StatusOK)
+}
+
+// List returns the services registered in the caller's tenant.
+func (c *Clients) List(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.client.List")
+	defer span.End()
+
+	list, err := client.List(ctx, c.DB)
+	if err != nil {
+		return errors.Wrap(err, "listing clients")
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// Register decodes the body of a request to register a service. The response
+// holds the client secret, which can not be retrieved again.
+func (c *Clients) Register(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.client.Register")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	var nc client.NewClient
+	if err := web.Decode(r, &nc); err != nil {
+		return errors.Wrap(err, "decoding new client")
+	}
+
+	reg, err := client.Register(ctx, c.DB, claims, nc, web.Now(ctx))
+	if err != nil {
+		switch err {
+		case client.ErrUnknownScope:
+			return fieldError("scopes", err)
+		default:
+			return errors.Wrap(err, "registering client")
+		}
+	}
+
+	return web.Respond(ctx, w, reg, http.StatusCreated)
+}
+
+// Delete removes a service identified by an ID in the request URL so it can
+// not obtain tokens anymore.
+func (c *Clients) Delete(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.client.Delete")
+	defer span.End()
+
+	id := chi.URLParam(r, "id")
+	if err := client.Delete(ctx, c.DB, id); err != nil {
+		switch err {
+		case client.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case client.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrapf(err, "deleting client %q", id)
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
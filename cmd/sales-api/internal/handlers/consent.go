@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/arammikayelyan/garagesale/internal/consent"
+	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/arammikayelyan/garagesale/internal/platform/web"
+	"github.com/go-chi/chi"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// consentCookieAge is how long browsers keep the consent cookie of anonymous
+// visitors.
+const consentCookieAge = 365 * 24 * time.Hour
+
+// Consents has handler methods for the cookie and tracking choices of
+// visitors and users.
+type Consents struct {
+	DB *sqlx.DB
+}
+
+// Create records the choices of an anonymous visitor under a new id. The id
+// is returned and set in the consent cookie so later requests of the visitor
+// are attributed to it.
+func (c *Consents) Create(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.consent.Create")
+	defer span.End()
+
+	var ch consent.Choices
+	if err := web.Decode(r, &ch); err != nil {
+		return errors.Wrap(err, "decoding consent")
+	}
+
+	cs, err := consent.Create(ctx, c.DB, ch, web.Now(ctx))
+	if err != nil {
+		return errors.Wrap(err, "creating consent")
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     consent.Cookie,
+		Value:    cs.ID,
+		Path:     "/",
+		MaxAge:   int(consentCookieAge.Seconds()),
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return web.Respond(ctx, w, cs, http.StatusCreated)
+}
+
+// Retrieve returns the choices of an anonymous visitor identified by an ID in
+// the request URL.
+func (c *Consents) Retrieve(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.consent.Retrieve")
+	defer span.End()
+
+	id := chi.URLParam(r, "id")
+	cs, err := consent.Retrieve(ctx, c.DB, id)
+	if err != nil {
+		return consentError(err, id)
+	}
+
+	return web.Respond(ctx, w, cs, http.StatusOK)
+}
+
+// Update replaces the choices of an anonymous visitor identified by an ID in
+// the request URL.
+func (c *Consents) Update(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.consent.Update")
+	defer span.End()
+
+	var ch consent.Choices
+	if err := web.Decode(r, &ch); err != nil {
+		return errors.Wrap(err, "decoding consent")
+	}
+
+	id := chi.URLParam(r, "id")
+	cs, err := consent.Update(ctx, c.DB, id, ch, web.Now(ctx))
+	if err != nil {
+		return consentError(err, id)
+	}
+
+	return web.Respond(ctx, w, cs, http.StatusOK)
+}
+
+// Mine returns the choices of the caller.
+func (c *Consents) Mine(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.consent.Mine")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	cs, err := consent.RetrieveForUser(ctx, c.DB, claims.Subject)
+	if err != nil {
+		return consentError(err, claims.Subject)
+	}
+
+	return web.Respond(ctx, w, cs, http.StatusOK)
+}
+
+// SaveMine records the choices of the caller, replacing earlier ones.
+func (c *Consents) SaveMine(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.consent.SaveMine")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	var ch consent.Choices
+	if err := web.Decode(r, &ch); err != nil {
+		return errors.Wrap(err, "decoding consent")
+	}
+
+	cs, err := consent.SaveForUser(ctx, c.DB, claims.Subject, ch, web.Now(ctx))
+	if err != nil {
+		return errors.Wrapf(err, "saving consent of user %q", claims.Subject)
+	}
+
+	return web.Respond(ctx, w, cs, http.StatusOK)
+}
+
+// consentError translates the known errors of the consent package to request
+// errors with a matching status code.
+func consentError(err error, id string) error {
+	switch err {
+	case consent.ErrNotFound:
+		return web.NewRequestError(err, http.StatusNotFound)
+	case consent.ErrInvalidID:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	default:
+		return errors.Wrapf(err, "looking for consent %q", id)
+	}
+}
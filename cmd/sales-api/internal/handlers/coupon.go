@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/arammikayelyan/garagesale/internal/coupon"
+	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/arammikayelyan/garagesale/internal/platform/web"
+	"github.com/go-chi/chi"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// Coupons has handler methods for sellers managing their discount codes.
+type Coupons struct {
+	DB *sqlx.DB
+}
+
+// List returns the coupons created by the caller.
+func (c *Coupons) List(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	list, err := coupon.List(ctx, c.DB, claims.Subject)
+	if err != nil {
+		return errors.Wrap(err, "listing coupons")
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// Retrieve returns a single coupon identified by an ID in the request URL.
+func (c *Coupons) Retrieve(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id := chi.URLParam(r, "id")
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	cp, err := coupon.Retrieve(ctx, c.DB, claims, id)
+	if err != nil {
+		return couponError(err, id)
+	}
+
+	return web.Respond(ctx, w, cp, http.StatusOK)
+}
+
+// Create decodes the body of a request to create a new coupon for the
+// caller's products.
+func (c *Coupons) Create(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	var nc coupon.NewCoupon
+	if err := web.Decode(r, &nc); err != nil {
+		return errors.Wrap(err, "decoding new coupon")
+	}
+
+	cp, err := coupon.Create(ctx, c.DB, claims, nc, web.Now(ctx))
+	if err != nil {
+		switch err {
+		case coupon.ErrCodeTaken:
+			return fieldError("code", err)
+		case coupon.ErrInvalidValue:
+			return fieldError("value", err)
+		default:
+			return errors.Wrap(err, "creating coupon")
+		}
+	}
+
+	return web.Respond(ctx, w, cp, http.StatusCreated)
+}
+
+// Update decodes the body of a request to change a coupon identified by an ID
+// in the request URL.
+func (c *Coupons) Update(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id := chi.URLParam(r, "id")
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	var uc coupon.UpdateCoupon
+	if err := web.Decode(r, &uc); err != nil {
+		return errors.Wrap(err, "decoding coupon update")
+	}
+
+	cp, err := coupon.Update(ctx, c.DB, claims, id, uc, web.Now(ctx))
+	if err != nil {
+		return couponError(err, id)
+	}
+
+	return web.Respond(ctx, w, cp, http.StatusOK)
+}
+
+// Delete removes a coupon identified by an ID in the request URL.
+func (c *Coupons) Delete(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id := chi.URLParam(r, "id")
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	if err := coupon.Delete(ctx, c.DB, claims, id); err != nil {
+		return couponError(err, id)
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// couponError translates the known errors of the coupon package to request
+// errors with a matching status code.
+func couponError(err error, id string) error {
+	switch err {
+	case coupon.ErrNotFound:
+		return web.NewRequestError(err, http.StatusNotFound)
+	case coupon.ErrInvalidID:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	case coupon.ErrForbidden:
+		return web.NewRequestError(err, http.StatusForbidden)
+	case coupon.ErrInvalidValue:
+		return fieldError("value", err)
+	default:
+		return errors.Wrapf(err, "coupon %q", id)
+	}
+}
@@ -0,0 +1,263 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/arammikayelyan/garagesale/internal/event"
+	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/arammikayelyan/garagesale/internal/platform/web"
+	"github.com/arammikayelyan/garagesale/internal/product"
+	"github.com/go-chi/chi"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// defaultRadiusKM is used when a client filters events by location without
+// specifying how far it is willing to travel.
+const defaultRadiusKM = 25
+
+// Event has handler methods for dealing with garage sale events.
+type Event struct {
+	DB *sqlx.DB
+
+	// Signals adds the interest in products to them.
+	Signals *Signals
+}
+
+// List returns upcoming events. When the lat and lng query parameters are
+// provided only events within radius_km of that point are returned.
+func (e *Event) List(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.event.List")
+	defer span.End()
+
+	near, err := parseNear(r)
+	if err != nil {
+		return web.NewRequestError(err, http.StatusBadRequest)
+	}
+
+	list, err := event.ListUpcoming(ctx, e.DB, web.Now(ctx), near)
+	if err != nil {
+		return errors.Wrap(err, "listing events")
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// Retrieve returns a single event.
+func (e *Event) Retrieve(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id := chi.URLParam(r, "id")
+
+	ev, err := event.Retrieve(ctx, e.DB, id)
+	if err != nil {
+		switch err {
+		case event.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case event.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrapf(err, "looking for event %q", id)
+		}
+	}
+
+	return web.Respond(ctx, w, ev, http.StatusOK)
+}
+
+// Create decodes a JSON document from a POST request and schedules a new
+// event owned by the caller.
+func (e *Event) Create(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	var ne event.NewEvent
+	if err := web.Decode(r, &ne); err != nil {
+		return err
+	}
+
+	ev, err := event.Create(ctx, e.DB, claims, ne, web.Now(ctx))
+	if err != nil {
+		return err
+	}
+
+	return web.Respond(ctx, w, ev, http.StatusCreated)
+}
+
+// ListProducts returns all products offered at an event along with how much
+// interest they get.
+func (e *Event) ListProducts(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id := chi.URLParam(r, "id")
+
+	ids, err := event.ProductIDs(ctx, e.DB, id)
+	if err != nil {
+		switch err {
+		case event.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrapf(err, "listing products for event %q", id)
+		}
+	}
+
+	list, err := product.RetrieveMany(ctx, e.DB, ids)
+	if err != nil {
+		return errors.Wrapf(err, "listing products for event %q", id)
+	}
+
+	if err := localize(ctx, e.DB, w, r, list); err != nil {
+		return err
+	}
+	if err := e.Signals.attach(ctx, list); err != nil {
+		return errors.Wrap(err, "counting interest")
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// AddProduct attaches one of the caller's products to an event.
+func (e *Event) AddProduct(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id := chi.URLParam(r, "id")
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	var nep event.NewEventProduct
+	if err := web.Decode(r, &nep); err != nil {
+		return err
+	}
+
+	if err := event.AddProduct(ctx, e.DB, claims, id, nep.ProductID); err != nil {
+		return eventError(err, id)
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// RemoveProduct detaches a product from an event.
+func (e *Event) RemoveProduct(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id := chi.URLParam(r, "id")
+	productID := chi.URLParam(r, "productID")
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	if err := event.RemoveProduct(ctx, e.DB, claims, id, productID); err != nil {
+		return eventError(err, id)
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// Calendar returns a single event as an iCalendar document so it can be
+// imported into calendar applications.
+func (e *Event) Calendar(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id := chi.URLParam(r, "id")
+
+	ev, err := event.Retrieve(ctx, e.DB, id)
+	if err != nil {
+		return eventError(err, id)
+	}
+
+	return respondICal(ctx, w, ev.Name, []event.Event{*ev})
+}
+
+// Feed returns all upcoming events as an iCalendar feed buyers can subscribe
+// to in their calendar applications.
+func (e *Event) Feed(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.event.Feed")
+	defer span.End()
+
+	list, err := event.ListUpcoming(ctx, e.DB, web.Now(ctx), nil)
+	if err != nil {
+		return errors.Wrap(err, "listing events")
+	}
+
+	return respondICal(ctx, w, "Garage sales", list)
+}
+
+// SellerFeed returns the upcoming events of a single seller as an iCalendar
+// feed. The ID of the seller is part of the request URL.
+func (e *Event) SellerFeed(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.event.SellerFeed")
+	defer span.End()
+
+	id := chi.URLParam(r, "id")
+
+	list, err := event.ListUpcomingByUser(ctx, e.DB, web.Now(ctx), id)
+	if err != nil {
+		switch err {
+		case event.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrapf(err, "listing events for seller %q", id)
+		}
+	}
+
+	return respondICal(ctx, w, "Garage sales", list)
+}
+
+// respondICal renders events as an iCalendar document and sends it to the
+// client.
+func respondICal(ctx context.Context, w http.ResponseWriter, name string, events []event.Event) error {
+	var buf bytes.Buffer
+	if err := event.WriteICal(&buf, name, events, web.Now(ctx)); err != nil {
+		return errors.Wrap(err, "generating calendar")
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	return web.RespondRaw(ctx, w, buf.Bytes(), "text/calendar; charset=utf-8", http.StatusOK)
+}
+
+// eventError translates the known errors of the event package to request
+// errors with a matching status code.
+func eventError(err error, id string) error {
+	switch err {
+	case event.ErrNotFound, event.ErrProductNotFound:
+		return web.NewRequestError(err, http.StatusNotFound)
+	case event.ErrInvalidID:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	case event.ErrForbidden:
+		return web.NewRequestError(err, http.StatusForbidden)
+	default:
+		return errors.Wrapf(err, "event %q", id)
+	}
+}
+
+// parseNear reads the optional location filter from the query string.
+func parseNear(r *http.Request) (*event.Near, error) {
+	q := r.URL.Query()
+	if q.Get("lat") == "" && q.Get("lng") == "" {
+		return nil, nil
+	}
+
+	lat, err := strconv.ParseFloat(q.Get("lat"), 64)
+	if err != nil || lat < -90 || lat > 90 {
+		return nil, errors.New("lat must be a number between -90 and 90")
+	}
+	lng, err := strconv.ParseFloat(q.Get("lng"), 64)
+	if err != nil || lng < -180 || lng > 180 {
+		return nil, errors.New("lng must be a number between -180 and 180")
+	}
+
+	radius := float64(defaultRadiusKM)
+	if v := q.Get("radius_km"); v != "" {
+		radius, err = strconv.ParseFloat(v, 64)
+		if err != nil || radius <= 0 {
+			return nil, errors.New("radius_km must be a positive number")
+		}
+	}
+
+	near := event.Near{
+		Latitude:  lat,
+		Longitude: lng,
+		RadiusKM:  radius,
+	}
+	return &near, nil
+}
@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/arammikayelyan/garagesale/internal/export"
+	"github.com/arammikayelyan/garagesale/internal/mid"
+	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/arammikayelyan/garagesale/internal/platform/web"
+	"github.com/arammikayelyan/garagesale/internal/product"
+	"github.com/arammikayelyan/garagesale/internal/user"
+	"github.com/go-chi/chi"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// Export kinds.
+const (
+	exportProducts = "products"
+	exportSales    = "sales"
+	exportAudit    = "audit"
+)
+
+// newExport is what a client sends to request an export. Filter holds the
+// query parameters the matching listing takes, such as from, to and status
+// for sales.
+type newExport struct {
+	Kind   string            `json:"kind" validate:"required,oneof=products sales audit"`
+	Filter map[string]string `json:"filter"`
+}
+
+// Exports has the handlers of exports produced in the background.
+type Exports struct {
+	DB     *sqlx.DB
+	Runner *export.Runner
+	Sales  *Sales
+}
+
+// register makes the runner produce every kind of export.
+func (ex *Exports) register() {
+	ex.Runner.Register(exportProducts, ex.products)
+	ex.Runner.Register(exportSales, ex.sales)
+	ex.Runner.Register(exportAudit, ex.audit)
+}
+
+// Start requests an export. Admins export everyone's data and may export the
+// audit trail while sellers export their own products and sales. The
+// response points to where the export can be followed.
+func (ex *Exports) Start(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.export.Start")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	var ne newExport
+	if err := web.Decode(r, &ne); err != nil {
+		return errors.Wrap(err, "decoding new export")
+	}
+
+	admin := claims.HasRole(auth.RoleAdmin)
+	if ne.Kind == exportAudit && !admin {
+		return mid.ErrForbidden
+	}
+
+	params := make(url.Values)
+	for k, v := range ne.Filter {
+		params.Set(k, v)
+	}
+
+	// Filters are checked now so a bad one fails the request rather than
+	// the export.
+	var err error
+	switch ne.Kind {
+	case exportSales:
+		_, err = saleFilterOf(params)
+	default:
+		_, _, err = rangeOf(params)
+	}
+	if err != nil {
+		return fieldError("filter", err)
+	}
+
+	e, err := ex.Runner.Start(ctx, claims.Subject, admin, ne.Kind, params, web.Now(ctx))
+	if err != nil {
+		switch err {
+		case export.ErrUnknownKind:
+			return fieldError("kind", err)
+		default:
+			return errors.Wrap(err, "starting export")
+		}
+	}
+
+	w.Header().Set("Location", "/v1/exports/"+e.ID)
+	return web.Respond(ctx, w, e, http.StatusAccepted)
+}
+
+// Retrieve returns the status of an export identified by an ID in the request
+// URL. Once it is done the response holds a signed URL it can be downloaded
+// from without authenticating.
+func (ex *Exports) Retrieve(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.export.Retrieve")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	id := chi.URLParam(r, "id")
+
+	e, err := ex.Runner.Retrieve(ctx, id, claims.Subject, claims.HasRole(auth.RoleAdmin), "/v1/exports", web.Now(ctx))
+	if err != nil {
+		switch err {
+		case export.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		default:
+			return errors.Wrapf(err, "reading export %q", id)
+		}
+	}
+
+	return web.Respond(ctx, w, e, http.StatusOK)
+}
+
+// Download streams a finished export. It is authorized by the signature in
+// the URL Retrieve handed out rather than a token.
+func (ex *Exports) Download(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.export.Download")
+	defer span.End()
+
+	id := chi.URLParam(r, "id")
+
+	unix, err := strconv.ParseInt(r.URL.Query().Get("expires"), 10, 64)
+	if err != nil {
+		return web.NewRequestError(export.ErrInvalidSignature, http.StatusForbidden)
+	}
+	expires := time.Unix(unix, 0)
+
+	rc, err := ex.Runner.Open(ctx, id, expires, r.URL.Query().Get("signature"), web.Now(ctx))
+	if err != nil {
+		switch err {
+		case export.ErrInvalidSignature:
+			return web.NewRequestError(err, http.StatusForbidden)
+		case export.ErrNotReady:
+			return web.NewRequestError(err, http.StatusNotFound)
+		default:
+			return errors.Wrapf(err, "downloading export %q", id)
+		}
+	}
+	defer rc.Close()
+
+	write := func(out io.Writer) error {
+		_, err := io.Copy(out, rc)
+		return err
+	}
+
+	return web.RespondStream(ctx, w, "text/csv; charset=utf-8", "export-"+id+".csv", http.StatusOK, write)
+}
+
+// productColumns is the header row of the products export.
+var productColumns = []string{
+	"product_id", "name", "category", "cost", "quantity", "sold", "revenue", "seller_id", "date_created",
+}
+
+// products produces the products export of the tenant of the export. Sellers
+// export their own products.
+func (ex *Exports) products(ctx context.Context, out io.Writer, e export.Export) (int, error) {
+	list, err := product.List(ctx, ex.DB)
+	if err != nil {
+		return 0, errors.Wrap(err, "listing products")
+	}
+
+	cw := csv.NewWriter(out)
+	cw.Write(productColumns)
+
+	var n int
+	for _, p := range list {
+		if !e.Admin && p.UserID != e.UserID {
+			continue
+		}
+		n++
+		cw.Write([]string{
+			p.ID,
+			p.Name,
+			p.Category,
+			strconv.Itoa(p.Cost),
+			strconv.Itoa(p.Quantity),
+			strconv.Itoa(p.Sold),
+			strconv.Itoa(p.Revenue),
+			p.UserID,
+			p.DateCreated.UTC().Format(time.RFC3339),
+		})
+	}
+
+	cw.Flush()
+	return n, cw.Error()
+}
+
+// sales produces the sales export in the format of Sales.Export.
+func (ex *Exports) sales(ctx context.Context, out io.Writer, e export.Export) (int, error) {
+	filter, err := saleFilterOf(e.Query())
+	if err != nil {
+		return 0, err
+	}
+	if filter.Status == "" {
+		filter.Status = product.SalePaid
+	}
+
+	var sellerID string
+	if !e.Admin {
+		sellerID = e.UserID
+	}
+
+	return ex.Sales.writeCSV(ctx, out, sellerID, filter, true)
+}
+
+// auditColumns is the header row of the audit export.
+var auditColumns = []string{"date", "action", "actor_id", "user_id", "detail"}
+
+// audit produces the export of the audit trail of user administration.
+func (ex *Exports) audit(ctx context.Context, out io.Writer, e export.Export) (int, error) {
+	if !e.Admin {
+		return 0, errors.New("only admins may export the audit trail")
+	}
+
+	from, to, err := rangeOf(e.Query())
+	if err != nil {
+		return 0, err
+	}
+
+	cw := csv.NewWriter(out)
+	cw.Write(auditColumns)
+
+	var n int
+	err = user.StreamAudit(ctx, ex.DB, from, to, func(ae user.AuditEvent) error {
+		n++
+		return cw.Write([]string{
+			ae.Date.UTC().Format(time.RFC3339),
+			ae.Action,
+			ae.ActorID,
+			ae.UserID,
+			ae.Detail,
+		})
+	})
+	if err != nil {
+		return n, err
+	}
+
+	cw.Flush()
+	return n, cw.Error()
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"github.com/arammikayelyan/garagesale/internal/platform/web"
+	"github.com/arammikayelyan/garagesale/internal/product"
+)
+
+// products is a list of products rendered without reflection when the JSON
+// fast path is on. GET /v1/products spends most of its time encoding them.
+type products []product.Product
+
+// AppendJSON implements web.Appender.
+func (ps products) AppendJSON(dst []byte, o web.JSONOptions) []byte {
+	dst = append(dst, '[')
+	for i := range ps {
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		dst = appendProduct(dst, &ps[i], o)
+	}
+	return append(dst, ']')
+}
+
+// appendProduct renders p with the fields and in the order of its struct
+// tags.
+func appendProduct(dst []byte, p *product.Product, o web.JSONOptions) []byte {
+	dst = append(dst, `{"id":`...)
+	dst = web.AppendString(dst, p.ID)
+	dst = append(dst, `,"name":`...)
+	dst = web.AppendString(dst, p.Name)
+	dst = append(dst, `,"description":`...)
+	dst = web.AppendString(dst, p.Description)
+	if p.Language != "" {
+		dst = append(dst, `,"language":`...)
+		dst = web.AppendString(dst, p.Language)
+	}
+	dst = append(dst, `,"category":`...)
+	dst = web.AppendString(dst, p.Category)
+	if p.Tags != nil || !o.OmitNulls || o.EmptyCollections {
+		dst = append(dst, `,"tags":`...)
+		dst = web.AppendStrings(dst, p.Tags, o)
+	}
+	dst = append(dst, `,"cost":`...)
+	dst = web.AppendInt(dst, p.Cost)
+	dst = append(dst, `,"quantity":`...)
+	dst = web.AppendInt(dst, p.Quantity)
+	dst = append(dst, `,"sold":`...)
+	dst = web.AppendInt(dst, p.Sold)
+	dst = append(dst, `,"revenue":`...)
+	dst = web.AppendInt(dst, p.Revenue)
+	dst = append(dst, `,"user_id":`...)
+	dst = web.AppendString(dst, p.UserID)
+	dst = append(dst, `,"date_created":`...)
+	dst = web.AppendTime(dst, p.DateCreated)
+	dst = append(dst, `,"date_updated":`...)
+	dst = web.AppendTime(dst, p.DateUpdated)
+	return append(dst, '}')
+}
+
+// sales is a list of sales rendered without reflection when the JSON fast
+// path is on.
+type sales []product.Sale
+
+// AppendJSON implements web.Appender.
+func (ss sales) AppendJSON(dst []byte, o web.JSONOptions) []byte {
+	dst = append(dst, '[')
+	for i := range ss {
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		dst = appendSale(dst, &ss[i], o)
+	}
+	return append(dst, ']')
+}
+
+// appendSale renders s with the fields and in the order of its struct tags.
+func appendSale(dst []byte, s *product.Sale, o web.JSONOptions) []byte {
+	dst = append(dst, `{"id":`...)
+	dst = web.AppendString(dst, s.ID)
+	dst = append(dst, `,"product_id":`...)
+	dst = web.AppendString(dst, s.ProductID)
+	dst = append(dst, `,"quantity":`...)
+	dst = web.AppendInt(dst, s.Quantity)
+	dst = append(dst, `,"paid":`...)
+	dst = web.AppendInt(dst, s.Paid)
+	if s.CouponID != nil {
+		dst = append(dst, `,"coupon_id":`...)
+		dst = web.AppendString(dst, *s.CouponID)
+	}
+	dst = append(dst, `,"discount":`...)
+	dst = web.AppendInt(dst, s.Discount)
+	dst = append(dst, `,"currency":`...)
+	dst = web.AppendString(dst, s.Currency)
+	dst = append(dst, `,"status":`...)
+	dst = web.AppendString(dst, string(s.Status))
+	for _, f := range [...]struct {
+		name string
+		val  *string
+	}{
+		{`,"buyer_id":`, s.BuyerID},
+		{`,"buyer_name":`, s.BuyerName},
+		{`,"buyer_email":`, s.BuyerEmail},
+	} {
+		if f.val == nil && o.OmitNulls {
+			continue
+		}
+		dst = append(dst, f.name...)
+		dst = web.AppendStringPtr(dst, f.val)
+	}
+	dst = append(dst, `,"date_created":`...)
+	dst = web.AppendTime(dst, s.DateCreated)
+	dst = append(dst, `,"date_updated":`...)
+	dst = web.AppendTime(dst, s.DateUpdated)
+	return append(dst, '}')
+}
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/arammikayelyan/garagesale/internal/platform/web"
+	"github.com/arammikayelyan/garagesale/internal/product"
+)
+
+// jsonOptions are the combinations of options the encoders must honour.
+var jsonOptions = []web.JSONOptions{
+	{},
+	{EmptyCollections: true},
+	{ZeroNumbers: true},
+	{OmitNulls: true},
+	{EmptyCollections: true, ZeroNumbers: true},
+	{EmptyCollections: true, ZeroNumbers: true, OmitNulls: true},
+}
+
+func fixtureProducts(n int) []product.Product {
+	created := time.Date(2019, 1, 1, 0, 0, 1, 500, time.UTC)
+	list := make([]product.Product, n)
+	for i := range list {
+		list[i] = product.Product{
+			ID:          fmt.Sprintf("a2b0639f-2cc6-44b8-b97b-%012d", i),
+			Name:        fmt.Sprintf("Comic Books #%d", i),
+			Description: "Mint condition <b>\"first\"</b> issues & more\n\tline\u2028sep \xff",
+			Category:    "comics",
+			Tags:        []string{"comics", "vintage"},
+			Cost:        50,
+			Quantity:    42,
+			Sold:        i,
+			Revenue:     i * 50,
+			UserID:      "5cf37266-3473-4006-984f-9325122678b7",
+			DateCreated: created,
+			DateUpdated: created.Add(time.Duration(i) * time.Minute),
+		}
+	}
+	list[0].Tags = nil
+	list[0].Language = "hy"
+	return list
+}
+
+func fixtureSales(n int) []product.Sale {
+	created := time.Date(2019, 1, 1, 0, 0, 1, 0, time.FixedZone("AMT", 4*3600))
+	coupon := "SPRING"
+	name := "Ani"
+	list := make([]product.Sale, n)
+	for i := range list {
+		list[i] = product.Sale{
+			ID:          fmt.Sprintf("98b6d4b8-f04b-4c79-8c2e-%012d", i),
+			ProductID:   "a2b0639f-2cc6-44b8-b97b-15d69dbb511e",
+			Quantity:    2,
+			Paid:        90,
+			Discount:    10,
+			Currency:    "USD",
+			Status:      product.SalePaid,
+			DateCreated: created,
+			DateUpdated: created,
+		}
+	}
+	list[0].CouponID = &coupon
+	list[0].BuyerName = &name
+	return list
+}
+
+// TestAppendersMatchMarshal checks the hand written encoders render exactly
+// what the reflective encoder does.
+func TestAppendersMatchMarshal(t *testing.T) {
+	values := map[string]web.Appender{
+		"products": products(fixtureProducts(3)),
+		"sales":    sales(fixtureSales(3)),
+		"empty":    products{},
+	}
+
+	for name, val := range values {
+		for _, o := range jsonOptions {
+			want, err := o.Marshal(val)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fast := o
+			fast.FastPath = true
+			got, err := fast.Marshal(val)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("%s with %+v:\ngot:\n%s\nwant:\n%s", name, o, got, want)
+			}
+		}
+	}
+}
+
+// The options the API responds with.
+var apiJSON = web.JSONOptions{EmptyCollections: true, ZeroNumbers: true}
+
+func benchmarkMarshal(b *testing.B, val interface{}, o web.JSONOptions) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := o.Marshal(val); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkProductsReflect(b *testing.B) {
+	benchmarkMarshal(b, products(fixtureProducts(100)), apiJSON)
+}
+
+func BenchmarkProductsStdlib(b *testing.B) {
+	benchmarkMarshal(b, fixtureProducts(100), web.JSONOptions{})
+}
+
+func BenchmarkProductsFastPath(b *testing.B) {
+	o := apiJSON
+	o.FastPath = true
+	benchmarkMarshal(b, products(fixtureProducts(100)), o)
+}
+
+func BenchmarkSalesReflect(b *testing.B) {
+	benchmarkMarshal(b, sales(fixtureSales(100)), apiJSON)
+}
+
+func BenchmarkSalesFastPath(b *testing.B) {
+	o := apiJSON
+	o.FastPath = true
+	benchmarkMarshal(b, sales(fixtureSales(100)), o)
+}
@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/arammikayelyan/garagesale/internal/moderation"
+	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/arammikayelyan/garagesale/internal/platform/blob"
+	"github.com/arammikayelyan/garagesale/internal/platform/web"
+	"github.com/arammikayelyan/garagesale/internal/product"
+	"github.com/arammikayelyan/garagesale/internal/upload"
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// Images configures the pictures of products.
+type Images struct {
+	// Store keeps the contents of the pictures.
+	Store blob.Store
+
+	// MatchDistance is how many bits the perceptual hashes of two pictures
+	// may differ by for them to be taken as the same picture. Negative turns
+	// duplicate detection off.
+	MatchDistance int
+}
+
+// maxImageSize is the largest picture accepted by AddImage.
+const maxImageSize = 10 << 20
+
+// AddImage stores the picture in the request body as a picture of the product
+// in the request URL once it passed the malware scan. Pictures looking like those of another seller's products
+// are flagged for moderation as the listing may be a scam.
+func (p *Product) AddImage(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.product.AddImage")
+	defer span.End()
+
+	id := chi.URLParam(r, "id")
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxImageSize))
+	if err != nil {
+		err := fmt.Errorf("image must be at most %d bytes", maxImageSize)
+		return web.NewRequestError(err, http.StatusRequestEntityTooLarge)
+	}
+
+	if err := p.Uploads.Check(ctx, p.DB, "product_image", claims.Subject, data, web.Now(ctx)); err != nil {
+		switch err {
+		case upload.ErrInfected:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return err
+		}
+	}
+
+	img, err := product.AddImage(ctx, p.DB, p.Images.Store, claims, id, data, web.Now(ctx))
+	if err != nil {
+		return imageError(err, id)
+	}
+
+	if p.Images.MatchDistance >= 0 {
+		matches, err := product.ImageMatches(ctx, p.DB, *img, p.Images.MatchDistance)
+		if err != nil {
+			p.Log.Printf("matching image %s : %v", img.ID, err)
+		}
+
+		var reasons []string
+		for _, m := range matches {
+			reasons = append(reasons, fmt.Sprintf("image %s matches image %s of product %s of another seller", img.ID, m.ImageID, m.ProductID))
+		}
+		if len(reasons) > 0 {
+			p.flag(ctx, "product", id, []moderation.Violation{{Field: "images", Reasons: reasons}})
+		}
+	}
+
+	return web.Respond(ctx, w, img, http.StatusCreated)
+}
+
+// ListImages returns the pictures of the product in the request URL.
+func (p *Product) ListImages(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.product.ListImages")
+	defer span.End()
+
+	id := chi.URLParam(r, "id")
+
+	list, err := product.ListImages(ctx, p.DB, id)
+	if err != nil {
+		return imageError(err, id)
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// Image returns the contents of a picture of the product in the request URL.
+func (p *Product) Image(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.product.Image")
+	defer span.End()
+
+	id := chi.URLParam(r, "id")
+
+	img, rc, err := product.OpenImage(ctx, p.DB, p.Images.Store, id, chi.URLParam(r, "imageID"))
+	if err != nil {
+		return imageError(err, id)
+	}
+	defer rc.Close()
+
+	write := func(out io.Writer) error {
+		_, err := io.Copy(out, rc)
+		return err
+	}
+	return web.RespondStream(ctx, w, img.ContentType, "", http.StatusOK, write)
+}
+
+// DeleteImage removes a picture of the product in the request URL.
+func (p *Product) DeleteImage(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.product.DeleteImage")
+	defer span.End()
+
+	id := chi.URLParam(r, "id")
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	if err := product.DeleteImage(ctx, p.DB, p.Images.Store, claims, id, chi.URLParam(r, "imageID")); err != nil {
+		return imageError(err, id)
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// imageError translates the errors of managing pictures to request errors
+// with a matching status code.
+func imageError(err error, id string) error {
+	switch err {
+	case product.ErrNotFound, product.ErrImageNotFound:
+		return web.NewRequestError(err, http.StatusNotFound)
+	case product.ErrInvalidID, product.ErrUnsupportedImage:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	case product.ErrForbidden:
+		return web.NewRequestError(err, http.StatusForbidden)
+	default:
+		return errors.Wrapf(err, "managing images of product %q", id)
+	}
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/arammikayelyan/garagesale/internal/platform/cache"
+	"github.com/arammikayelyan/garagesale/internal/platform/web"
+	"github.com/arammikayelyan/garagesale/internal/product"
+	"github.com/arammikayelyan/garagesale/internal/tenant"
+	"github.com/go-chi/chi"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// Signals adds the interest in products to their payloads. Counting is cached
+// as popular products are read often, and the tenant of each seller decides
+// with the interest_signals feature whether its products show it.
+type Signals struct {
+	DB      *sqlx.DB
+	Cache   *cache.Cache
+	Tenants *tenant.Config
+}
+
+// attach sets the Interest of the products in list whose tenant shows it.
+func (s *Signals) attach(ctx context.Context, list []product.Product) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Signals.attach")
+	defer span.End()
+
+	interest := make(map[string]product.Interest, len(list))
+	var missing []string
+	for _, p := range list {
+		if in, ok := s.Cache.Get(p.ID); ok {
+			interest[p.ID] = in.(product.Interest)
+			continue
+		}
+		missing = append(missing, p.ID)
+	}
+	if len(missing) > 0 {
+		fresh, err := product.RetrieveInterest(ctx, s.DB, missing, web.Now(ctx))
+		if err != nil {
+			return err
+		}
+		for _, in := range fresh {
+			interest[in.ProductID] = in
+			s.Cache.Set(in.ProductID, in)
+		}
+	}
+
+	for i := range list {
+		in, ok := interest[list[i].ID]
+		if !ok {
+			continue
+		}
+		settings, err := s.Tenants.For(ctx, in.TenantID)
+		if err != nil {
+			return errors.Wrap(err, "reading tenant settings")
+		}
+		if settings.Enabled(tenant.FeatureInterestSignals) {
+			list[i].Interest = &in
+		}
+	}
+
+	return nil
+}
+
+// Watch adds the product in the request URL to the watch list of the caller.
+// The seller hears about new watchers in their digest.
+func (p *Product) Watch(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id := chi.URLParam(r, "id")
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	added, err := product.Watch(ctx, p.DB, claims, id, web.Now(ctx))
+	if err != nil {
+		return watchError(err, id)
+	}
+
+	if added && p.Notifier != nil {
+		if err := p.notifyWatch(ctx, claims, id); err != nil {
+			p.Log.Printf("notifying seller of watch of product %s : %v", id, err)
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// Unwatch removes the product in the request URL from the watch list of the
+// caller.
+func (p *Product) Unwatch(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id := chi.URLParam(r, "id")
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	if err := product.Unwatch(ctx, p.DB, claims, id); err != nil {
+		return watchError(err, id)
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// watchError translates the errors of watching products to request errors
+// with a matching status code.
+func watchError(err error, id string) error {
+	switch err {
+	case product.ErrNotFound:
+		return web.NewRequestError(err, http.StatusNotFound)
+	case product.ErrInvalidID:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	default:
+		return errors.Wrapf(err, "watching product %q", id)
+	}
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/arammikayelyan/garagesale/internal/platform/web"
+	"github.com/arammikayelyan/garagesale/internal/user"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// mfaIssuer names the service in authenticator apps.
+const mfaIssuer = "Garage Sale"
+
+// mfaHeader carries the two-factor code when requesting a token.
+const mfaHeader = "X-MFA-Code"
+
+// EnrollMFA starts setting up two-factor authentication for the caller. The
+// response holds the TOTP secret and recovery codes, which are not shown
+// again. Two-factor authentication is enabled by ConfirmMFA.
+func (u *Users) EnrollMFA(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.user.EnrollMFA")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	e, err := user.EnrollMFA(ctx, u.DB, claims.Subject, mfaIssuer, web.Now(ctx))
+	if err != nil {
+		return mfaError(err)
+	}
+
+	return web.Respond(ctx, w, e, http.StatusCreated)
+}
+
+// ConfirmMFA enables two-factor authentication for the caller once they
+// send a code generated with the secret they enrolled.
+func (u *Users) ConfirmMFA(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.user.ConfirmMFA")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	var mc user.MFACode
+	if err := web.Decode(r, &mc); err != nil {
+		return errors.Wrap(err, "decoding mfa code")
+	}
+
+	if err := user.ConfirmMFA(ctx, u.DB, claims.Subject, mc.Code, u.Lockout, web.Now(ctx)); err != nil {
+		return mfaError(err)
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// DisableMFA turns two-factor authentication off for the caller. The body
+// holds a current code or a recovery code.
+func (u *Users) DisableMFA(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.user.DisableMFA")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	var mc user.MFACode
+	if err := web.Decode(r, &mc); err != nil {
+		return errors.Wrap(err, "decoding mfa code")
+	}
+
+	if err := user.DisableMFA(ctx, u.DB, claims.Subject, mc.Code, u.Lockout, web.Now(ctx)); err != nil {
+		return mfaError(err)
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// mfaError translates errors of two-factor authentication to responses.
+func mfaError(err error) error {
+	switch err {
+	case user.ErrNotFound:
+		return web.NewRequestError(err, http.StatusNotFound)
+	case user.ErrMFAEnabled:
+		return web.NewRequestError(err, http.StatusConflict)
+	case user.ErrMFANotEnrolled:
+		return web.NewRequestError(err, http.StatusNotFound)
+	case user.ErrInvalidMFACode:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	case user.ErrAccountLocked:
+		return web.NewRequestError(err, http.StatusLocked)
+	default:
+		return errors.Wrap(err, "managing two-factor authentication")
+	}
+}
@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/arammikayelyan/garagesale/internal/notification"
+	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/arammikayelyan/garagesale/internal/platform/web"
+	"github.com/go-chi/chi"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// Notifications has handler methods for users managing how they are notified.
+type Notifications struct {
+	DB   *sqlx.DB
+	Page web.PageSize
+}
+
+// ListChannels returns the notification channels configured by the caller.
+func (n *Notifications) ListChannels(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	list, err := notification.ListChannels(ctx, n.DB, claims.Subject)
+	if err != nil {
+		return errors.Wrap(err, "listing notification channels")
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// SetChannel configures the delivery address of a notification channel for
+// the caller and whether it is enabled. The channel is part of the request URL.
+func (n *Notifications) SetChannel(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ch := notification.Channel(chi.URLParam(r, "channel"))
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	var uc notification.UpdateChannel
+	if err := web.Decode(r, &uc); err != nil {
+		return err
+	}
+
+	cs, err := notification.SetChannel(ctx, n.DB, claims.Subject, ch, uc, web.Now(ctx))
+	if err != nil {
+		switch err {
+		case notification.ErrUnknownChannel:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case notification.ErrInvalidAddress:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrapf(err, "setting notification channel %q", ch)
+		}
+	}
+
+	return web.Respond(ctx, w, cs, http.StatusOK)
+}
+
+// ListDevices returns the devices the caller registered for push
+// notifications.
+func (n *Notifications) ListDevices(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	list, err := notification.ListDevices(ctx, n.DB, claims.Subject)
+	if err != nil {
+		return errors.Wrap(err, "listing devices")
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// RegisterDevice decodes a JSON document describing a device token and the
+// events it opts in to and registers it for the caller.
+func (n *Notifications) RegisterDevice(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	var nd notification.NewDevice
+	if err := web.Decode(r, &nd); err != nil {
+		return err
+	}
+
+	d, err := notification.RegisterDevice(ctx, n.DB, claims.Subject, nd, web.Now(ctx))
+	if err != nil {
+		switch err {
+		case notification.ErrUnknownEvent:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrap(err, "registering device")
+		}
+	}
+
+	return web.Respond(ctx, w, d, http.StatusCreated)
+}
+
+// UnregisterDevice removes a device token of the caller. The token is part of
+// the request URL.
+func (n *Notifications) UnregisterDevice(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	token := chi.URLParam(r, "token")
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	if err := notification.UnregisterDevice(ctx, n.DB, claims.Subject, token); err != nil {
+		switch err {
+		case notification.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		default:
+			return errors.Wrap(err, "unregistering device")
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// Preferences returns which channels the caller is notified on for every
+// event.
+func (n *Notifications) Preferences(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	p, err := notification.RetrievePreferences(ctx, n.DB, claims.Subject)
+	if err != nil {
+		return errors.Wrap(err, "retrieving notification preferences")
+	}
+
+	return web.Respond(ctx, w, p, http.StatusOK)
+}
+
+// UpdatePreferences decodes a JSON object mapping events to channels and
+// replaces the caller's preferences for those events.
+func (n *Notifications) UpdatePreferences(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	var update notification.Preferences
+	if err := web.Decode(r, &update); err != nil {
+		return err
+	}
+
+	p, err := notification.UpdatePreferences(ctx, n.DB, claims.Subject, update, web.Now(ctx))
+	if err != nil {
+		switch err {
+		case notification.ErrUnknownEvent, notification.ErrUnknownChannel:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrap(err, "updating notification preferences")
+		}
+	}
+
+	return web.Respond(ctx, w, p, http.StatusOK)
+}
+
+// ListInApp returns a page of the caller's in-app notifications.
+func (n *Notifications) ListInApp(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	page, err := web.ParsePage(r, n.Page)
+	if err != nil {
+		return err
+	}
+
+	list, err := notification.ListInApp(ctx, n.DB, claims.Subject, page.Limit, page.Offset)
+	if err != nil {
+		return errors.Wrap(err, "listing notifications")
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// Digest returns how often the caller receives the digest of low priority
+// notifications.
+func (n *Notifications) Digest(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	ds, err := notification.RetrieveDigest(ctx, n.DB, claims.Subject)
+	if err != nil {
+		return errors.Wrap(err, "retrieving digest setting")
+	}
+
+	return web.Respond(ctx, w, ds, http.StatusOK)
+}
+
+// UpdateDigest sets how often the caller receives the digest of low priority
+// notifications.
+func (n *Notifications) UpdateDigest(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	var ds notification.DigestSetting
+	if err := web.Decode(r, &ds); err != nil {
+		return err
+	}
+
+	if err := notification.UpdateDigest(ctx, n.DB, claims.Subject, ds); err != nil {
+		return errors.Wrap(err, "updating digest setting")
+	}
+
+	return web.Respond(ctx, w, ds, http.StatusOK)
+}
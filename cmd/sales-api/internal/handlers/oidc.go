@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"strings"
+
+	"github.com/arammikayelyan/garagesale/internal/platform/oidc"
+	"github.com/arammikayelyan/garagesale/internal/platform/web"
+	"github.com/arammikayelyan/garagesale/internal/user"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// oidcCookie holds the state and nonce of a sign in with the OpenID Connect
+// provider between the login and the callback.
+const oidcCookie = "oidc_state"
+
+// OIDCLogin sends the user to the OpenID Connect provider to sign in. The
+// provider sends them back to the callback.
+func (u *Users) OIDCLogin(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.user.OIDCLogin")
+	defer span.End()
+
+	state, err := randomString()
+	if err != nil {
+		return err
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookie,
+		Value:    state + "." + nonce,
+		Path:     "/",
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return web.Redirect(ctx, w, r, u.OIDC.AuthURL(state, nonce), http.StatusFound)
+}
+
+// OIDCCallback completes a sign in with the OpenID Connect provider. The
+// identity it returns is mapped to a local user who is issued our tokens.
+// Users with two-factor authentication enabled send their code in the
+// X-MFA-Code header as when signing in with a password.
+func (u *Users) OIDCCallback(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.user.OIDCCallback")
+	defer span.End()
+
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		err := errors.Errorf("provider refused sign in: %s", e)
+		return web.NewRequestError(err, http.StatusUnauthorized)
+	}
+
+	cookie, err := r.Cookie(oidcCookie)
+	if err != nil {
+		err := errors.New("sign in was not started or has expired")
+		return web.NewRequestError(err, http.StatusBadRequest)
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcCookie, Path: "/", MaxAge: -1})
+
+	parts := strings.SplitN(cookie.Value, ".", 2)
+	if len(parts) != 2 || subtle.ConstantTimeCompare([]byte(parts[0]), []byte(q.Get("state"))) != 1 {
+		err := errors.New("state does not match")
+		return web.NewRequestError(err, http.StatusBadRequest)
+	}
+
+	now := web.Now(ctx)
+	id, err := u.OIDC.Exchange(ctx, q.Get("code"), parts[1], now)
+	if err != nil {
+		if errors.Cause(err) == oidc.ErrInvalidToken {
+			return web.NewRequestError(oidc.ErrInvalidToken, http.StatusUnauthorized)
+		}
+		return errors.Wrap(err, "exchanging code")
+	}
+
+	claims, err := user.AuthenticateExternal(ctx, u.DB, now, u.authenticator.AccessTTL(), u.Lockout, id, r.Header.Get(mfaHeader))
+	if err != nil {
+		switch err {
+		case user.ErrAuthenticationFailure, user.ErrMFARequired, user.ErrInvalidMFACode:
+			return web.NewRequestError(err, http.StatusUnauthorized)
+		case user.ErrIdentityConflict:
+			return web.NewRequestError(err, http.StatusConflict)
+		case user.ErrAccountLocked:
+			return web.NewRequestError(err, http.StatusLocked)
+		case user.ErrAccountDeactivated:
+			return web.NewRequestError(err, http.StatusForbidden)
+		default:
+			return errors.Wrap(err, "authenticating external identity")
+		}
+	}
+
+	refresh, err := user.IssueRefresh(ctx, u.DB, &claims, deviceOf(r), u.authenticator.RefreshTTL(), now)
+	if err != nil {
+		return errors.Wrap(err, "issuing refresh token")
+	}
+
+	return u.respondToken(ctx, w, claims, refresh)
+}
+
+// randomString generates a value which can not be guessed.
+func randomString() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "generating random value")
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,277 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/arammikayelyan/garagesale/internal/fraud"
+	"github.com/arammikayelyan/garagesale/internal/payment"
+	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/arammikayelyan/garagesale/internal/platform/web"
+	"github.com/arammikayelyan/garagesale/internal/product"
+	"github.com/arammikayelyan/garagesale/internal/tenant"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// maxWebhookSize is the largest webhook payload accepted from a provider.
+const maxWebhookSize = 1 << 16
+
+// Payments has handler methods for collecting payments for products.
+type Payments struct {
+	DB       *sqlx.DB
+	Log      *log.Logger
+	Provider payment.Provider
+
+	// Tenants gives the currency and commission of the buyer's tenant.
+	Tenants *tenant.Config
+
+	// Products is used to notify sellers about paid sales.
+	Products *Product
+
+	// Risk screens checkouts for fraud.
+	Risk Risk
+
+	// RestockChargebacks puts the units of sales reversed by a chargeback
+	// back into stock.
+	RestockChargebacks bool
+}
+
+// Risk is how checkouts are screened for fraud. Checkouts are not screened
+// without a Scorer. CountryHeader names the request header a proxy or CDN
+// puts the country of the client in, such as CF-IPCountry.
+type Risk struct {
+	Scorer        fraud.Scorer
+	Thresholds    fraud.Thresholds
+	CountryHeader string
+}
+
+// ErrCheckoutRejected is returned when fraud screening rejects a checkout.
+var ErrCheckoutRejected = errors.New("checkout was rejected")
+
+// Checkout starts paying for some quantity of a product at its listed cost.
+// A pending sale holds the units while the client completes the payment with
+// the provider using the returned session. The sale is marked paid or
+// cancelled once the provider reports the outcome.
+func (p *Payments) Checkout(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.payment.Checkout")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	var c payment.Checkout
+	if err := web.Decode(r, &c); err != nil {
+		return errors.Wrap(err, "decoding checkout")
+	}
+
+	settings, err := p.Tenants.For(ctx, claims.TenantID)
+	if err != nil {
+		return errors.Wrap(err, "reading tenant settings")
+	}
+
+	prod, err := product.Retrieve(ctx, p.DB, c.ProductID)
+	if err != nil {
+		switch err {
+		case product.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case product.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrapf(err, "looking for product %q", c.ProductID)
+		}
+	}
+
+	key := r.Header.Get("Idempotency-Key")
+	if len(key) > maxIdempotencyKey {
+		err := errors.Errorf("Idempotency-Key must be at most %d characters", maxIdempotencyKey)
+		return web.NewRequestError(err, http.StatusBadRequest)
+	}
+
+	ns := product.NewSale{
+		Quantity: c.Quantity,
+		Paid:     prod.Cost * c.Quantity,
+		Currency: settings.Currency,
+		BuyerID:  &claims.Subject,
+	}
+
+	// Checkouts held for review are accepted without reserving any units;
+	// the buyer checks out again once an admin approved it.
+	if d := p.screen(ctx, r, claims, ns, prod.ID); d != nil && !d.Allowed() {
+		if d.Action != fraud.ActionReview {
+			return web.NewRequestError(ErrCheckoutRejected, http.StatusForbidden)
+		}
+		held := struct {
+			ReviewID string `json:"review_id"`
+			Status   string `json:"status"`
+		}{d.ID, fraud.ActionReview}
+		return web.Respond(ctx, w, held, http.StatusAccepted)
+	}
+	sale, _, err := product.ReserveSale(ctx, p.DB, ns, prod.ID, key, web.Now(ctx))
+	if err != nil {
+		switch err {
+		case product.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case product.ErrInsufficientStock:
+			return fieldError("quantity", err)
+		case product.ErrKeyReused:
+			return web.NewRequestError(err, http.StatusUnprocessableEntity)
+		default:
+			return errors.Wrapf(err, "reserving sale of product %q", prod.ID)
+		}
+	}
+
+	commission := int(math.Round(float64(sale.Paid) * settings.CommissionRate / 100))
+	in := payment.Intent{
+		Amount:   sale.Paid,
+		Currency: strings.ToLower(settings.Currency),
+		Metadata: map[string]string{
+			"product_id": prod.ID,
+			"sale_id":    sale.ID,
+			"commission": strconv.Itoa(commission),
+		},
+		IdempotencyKey: sale.ID,
+	}
+
+	ses, err := p.Provider.CreateIntent(ctx, in)
+	if err != nil {
+		if _, cerr := product.CancelSale(ctx, p.DB, prod.ID, sale.ID, web.Now(ctx)); cerr != nil {
+			p.Log.Printf("releasing sale %s : %v", sale.ID, cerr)
+		}
+		return errors.Wrapf(err, "creating payment for product %q", prod.ID)
+	}
+
+	return web.Respond(ctx, w, ses, http.StatusCreated)
+}
+
+// screen asks the Scorer how risky a checkout is and returns the decision
+// taken. It returns nil when checkouts are not screened or screening failed,
+// which lets the checkout through rather than blocking every sale.
+func (p *Payments) screen(ctx context.Context, r *http.Request, claims auth.Claims, ns product.NewSale, productID string) *fraud.Decision {
+	if p.Risk.Scorer == nil {
+		return nil
+	}
+
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	o := fraud.Order{
+		UserID:    claims.Subject,
+		ProductID: productID,
+		Quantity:  ns.Quantity,
+		Amount:    ns.Paid,
+		Currency:  ns.Currency,
+		IP:        ip,
+	}
+	if p.Risk.CountryHeader != "" {
+		o.Country = strings.ToUpper(r.Header.Get(p.Risk.CountryHeader))
+	}
+
+	d, err := fraud.Assess(ctx, p.DB, p.Risk.Scorer, p.Risk.Thresholds, o, web.Now(ctx))
+	if err != nil {
+		p.Log.Printf("screening checkout of product %s : %v", productID, err)
+		return nil
+	}
+	return d
+}
+
+// Webhook receives payment events from the provider. A successful payment
+// marks its pending sale paid while a cancelled one cancels the sale releasing
+// the units. A disputed payment reverses its paid sale and charges the amount
+// back to the seller. Redelivered events have no further effect.
+func (p *Payments) Webhook(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.payment.Webhook")
+	defer span.End()
+
+	payload, err := ioutil.ReadAll(io.LimitReader(r.Body, maxWebhookSize))
+	if err != nil {
+		return errors.Wrap(err, "reading webhook")
+	}
+
+	ev, err := p.Provider.ParseEvent(payload, r.Header)
+	if err != nil {
+		switch err {
+		case payment.ErrInvalidSignature:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrap(err, "parsing webhook")
+		}
+	}
+
+	received := struct {
+		Received bool `json:"received"`
+	}{true}
+
+	saleID := ev.Metadata["sale_id"]
+
+	switch ev.Type {
+	case payment.EventSucceeded:
+		sale, changed, err := product.MarkSalePaid(ctx, p.DB, saleID, ev.IntentID, ev.Amount, web.Now(ctx))
+		if err != nil {
+			switch err {
+			case product.ErrSaleNotFound, product.ErrInvalidID, product.ErrInvalidTransition:
+
+				// Redelivering the event will not help so it is acknowledged
+				// and the payment is left for someone to refund.
+				p.Log.Printf("payment %s for sale %q needs a refund : %v", ev.IntentID, saleID, err)
+				return web.Respond(ctx, w, received, http.StatusOK)
+			default:
+				return errors.Wrapf(err, "recording payment %q", ev.IntentID)
+			}
+		}
+
+		if changed {
+			if err := p.Products.notifySale(ctx, sale); err != nil {
+				p.Log.Printf("notifying seller of sale %s : %v", sale.ID, err)
+			}
+		}
+
+	case payment.EventCancelled:
+		if _, err := product.CancelSale(ctx, p.DB, ev.Metadata["product_id"], saleID, web.Now(ctx)); err != nil {
+			switch err {
+			case product.ErrSaleNotFound, product.ErrInvalidID, product.ErrInvalidTransition:
+				p.Log.Printf("payment %s was cancelled for sale %q : %v", ev.IntentID, saleID, err)
+			default:
+				return errors.Wrapf(err, "cancelling sale for payment %q", ev.IntentID)
+			}
+		}
+
+	case payment.EventDisputed:
+		nc := product.NewChargeback{
+			ID:        ev.DisputeID,
+			PaymentID: ev.IntentID,
+			Amount:    ev.Amount,
+			Currency:  ev.Currency,
+			Reason:    ev.Reason,
+		}
+		sale, changed, err := product.ReverseSale(ctx, p.DB, nc, p.RestockChargebacks, web.Now(ctx))
+		if err != nil {
+			switch err {
+			case product.ErrSaleNotFound, product.ErrInvalidTransition:
+				p.Log.Printf("dispute %s of payment %s could not reverse its sale : %v", ev.DisputeID, ev.IntentID, err)
+				return web.Respond(ctx, w, received, http.StatusOK)
+			default:
+				return errors.Wrapf(err, "reversing sale for dispute %q", ev.DisputeID)
+			}
+		}
+
+		if changed {
+			if err := p.Products.notifyReversal(ctx, sale); err != nil {
+				p.Log.Printf("notifying seller of reversed sale %s : %v", sale.ID, err)
+			}
+		}
+	}
+
+	return web.Respond(ctx, w, received, http.StatusOK)
+}
@@ -1,40 +1,300 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/arammikayelyan/garagesale/internal/coupon"
+	"github.com/arammikayelyan/garagesale/internal/enrich"
+	"github.com/arammikayelyan/garagesale/internal/label"
+	"github.com/arammikayelyan/garagesale/internal/moderation"
+	"github.com/arammikayelyan/garagesale/internal/notification"
 	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/arammikayelyan/garagesale/internal/platform/cache"
 	"github.com/arammikayelyan/garagesale/internal/platform/web"
 	"github.com/arammikayelyan/garagesale/internal/product"
+	"github.com/arammikayelyan/garagesale/internal/recommend"
+	"github.com/arammikayelyan/garagesale/internal/templates"
+	"github.com/arammikayelyan/garagesale/internal/tenant"
+	"github.com/arammikayelyan/garagesale/internal/upload"
+	"github.com/arammikayelyan/garagesale/internal/user"
 	"github.com/go-chi/chi"
+	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
 	"go.opencensus.io/trace"
 )
 
+// Pages sets the size of the pages of each paginated listing, so costly
+// listings can be capped lower than the rest.
+type Pages struct {
+	Users         web.PageSize
+	Sales         web.PageSize
+	Products      web.PageSize
+	Notifications web.PageSize
+	ContentFlags  web.PageSize
+
+	// Cursors signs the cursors of the listings paginated by keyset.
+	Cursors *web.Cursors
+}
+
+// maxIdempotencyKey is the longest Idempotency-Key header accepted.
+const maxIdempotencyKey = 255
+
 // Product has handler methods for dealing with products
 type Product struct {
-	DB  *sqlx.DB
-	Log *log.Logger
+	DB         *sqlx.DB
+	Log        *log.Logger
+	Notifier   *notification.Notifier
+	Templates  *templates.Store
+	Moderation *moderation.Filter
+
+	// Stock briefly caches availability as product pages poll it often.
+	Stock *cache.Cache
+
+	// Tenants gives the locale of sellers for their notifications.
+	Tenants *tenant.Config
+
+	// Speller corrects searches with few matches.
+	Speller *product.Speller
+
+	// Images keeps the pictures of products.
+	Images Images
+
+	// Uploads scans uploaded files for malware.
+	Uploads *upload.Screen
+
+	// Cursors reads and makes the cursors of the sales listing.
+	Cursors *web.Cursors
+
+	// Signals adds the interest in a product to it.
+	Signals *Signals
+
+	// Recommendations records the purchases of registered buyers as
+	// feedback for recommending products.
+	Recommendations *Recommendations
+
+	// SalesPage and SearchPage size the pages of the sales of a product and
+	// of search results.
+	SalesPage  web.PageSize
+	SearchPage web.PageSize
+
+	// Enricher is asked for suggested content for every new product. It is
+	// optional.
+	Enricher enrich.Enricher
 }
 
-// List returns all products as a list from DB
+// suggestTimeout bounds how long an enrichment service may take to suggest
+// content for a new product.
+const suggestTimeout = time.Minute
+
+// productIDs reads the comma separated ids query parameter, dropping repeated
+// IDs. Asking for more than max products at once is a bad request.
+func productIDs(r *http.Request, max int) ([]string, error) {
+	seen := make(map[string]bool)
+	var ids []string
+	for _, id := range strings.Split(r.URL.Query().Get("ids"), ",") {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	if len(ids) > max {
+		err := errors.Errorf("at most %d ids may be asked for at once", max)
+		return nil, web.NewRequestError(err, http.StatusBadRequest)
+	}
+	return ids, nil
+}
+
+// List returns all products as a list from DB. When the ids query parameter
+// is provided as a comma separated list only those products are returned, up
+// to the most a page of search results holds.
 func (p *Product) List(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 	ctx, span := trace.StartSpan(ctx, "handlers.product.List")
 	defer span.End()
 
+	if r.URL.Query().Get("ids") != "" {
+		ids, err := productIDs(r, p.SearchPage.Max)
+		if err != nil {
+			return err
+		}
+		list, err := product.RetrieveMany(ctx, p.DB, ids)
+		if err != nil {
+			switch err {
+			case product.ErrInvalidID:
+				return web.NewRequestError(err, http.StatusBadRequest)
+			default:
+				return errors.Wrap(err, "looking for products")
+			}
+		}
+
+		if err := localize(ctx, p.DB, w, r, list); err != nil {
+			return err
+		}
+
+		return web.Respond(ctx, w, list, http.StatusOK)
+	}
+
+	since, ok, err := lastSync(r)
+	if err != nil {
+		return web.NewRequestError(err, http.StatusBadRequest)
+	}
+	if ok {
+		return p.listSince(ctx, w, r, since)
+	}
+
 	list, err := product.List(ctx, p.DB)
 	if err != nil {
 		return err
 	}
 
+	if err := localize(ctx, p.DB, w, r, list); err != nil {
+		return err
+	}
+
+	return web.Respond(ctx, w, products(list), http.StatusOK)
+}
+
+// Search returns a page of the products matching the q query parameter, best
+// matches first, with the matching words highlighted. The matches are also
+// counted by category and price range so storefronts can offer them as
+// filters. The category, min_cost and max_cost parameters narrow the matches
+// down. When there are few matches the response suggests a correction of
+// misspelled words, whose results are returned instead with autocorrect=true
+// if they are more.
+func (p *Product) Search(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.product.Search")
+	defer span.End()
+
+	page, err := web.ParsePage(r, p.SearchPage)
+	if err != nil {
+		return err
+	}
+
+	q := r.URL.Query()
+	sq := product.SearchQuery{
+		Text:     q.Get("q"),
+		Category: q.Get("category"),
+		Limit:    page.Limit,
+		Offset:   page.Offset,
+	}
+	for _, c := range []struct {
+		name string
+		dst  *int
+	}{{"min_cost", &sq.MinCost}, {"max_cost", &sq.MaxCost}} {
+		v := q.Get(c.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fieldError(c.name, errors.New("must be a non-negative number"))
+		}
+		*c.dst = n
+	}
+
+	var autocorrect bool
+	if v := q.Get("autocorrect"); v != "" {
+		autocorrect, err = strconv.ParseBool(v)
+		if err != nil {
+			return fieldError("autocorrect", errors.New("must be true or false"))
+		}
+	}
+
+	res, err := product.Search(ctx, p.DB, sq)
+	if err != nil {
+		switch err {
+		case product.ErrEmptySearch:
+			return fieldError("q", err)
+		default:
+			return errors.Wrap(err, "searching products")
+		}
+	}
+
+	if p.Speller != nil && res.Total < fewMatches {
+		text, ok, err := p.Speller.Correct(ctx, sq.Text)
+		if err != nil {
+			return errors.Wrap(err, "correcting search")
+		}
+		if ok && autocorrect {
+			sq.Text = text
+			alt, err := product.Search(ctx, p.DB, sq)
+			if err != nil {
+				return errors.Wrap(err, "searching products")
+			}
+			if alt.Total > res.Total {
+				res = alt
+				res.Corrected = true
+			}
+		}
+		res.DidYouMean = text
+	}
+
+	return web.Respond(ctx, w, res, http.StatusOK)
+}
+
+// listSince responds with the products updated after since, or with 304 Not
+// Modified when there are none. The time of the newest update is returned in
+// X-Last-Sync for the client to send on its next request.
+func (p *Product) listSince(ctx context.Context, w http.ResponseWriter, r *http.Request, since time.Time) error {
+	list, err := product.ListSince(ctx, p.DB, since)
+	if err != nil {
+		return err
+	}
+
+	if len(list) == 0 {
+		w.Header().Set("X-Last-Sync", since.UTC().Format(time.RFC3339Nano))
+		return web.Respond(ctx, w, nil, http.StatusNotModified)
+	}
+
+	last := since
+	for _, prod := range list {
+		if prod.DateUpdated.After(last) {
+			last = prod.DateUpdated
+		}
+	}
+	w.Header().Set("X-Last-Sync", last.UTC().Format(time.RFC3339Nano))
+	w.Header().Set("Last-Modified", last.UTC().Format(http.TimeFormat))
+
+	if err := localize(ctx, p.DB, w, r, list); err != nil {
+		return err
+	}
+
 	return web.Respond(ctx, w, list, http.StatusOK)
 }
 
-// Retrieve returns a single product from DB
+// lastSync reads when the client last synced from the X-Last-Sync header, an
+// RFC 3339 time, or else from If-Modified-Since. It reports false when the
+// client sent neither.
+func lastSync(r *http.Request) (time.Time, bool, error) {
+	if v := r.Header.Get("X-Last-Sync"); v != "" {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return time.Time{}, false, errors.New("X-Last-Sync must be an RFC 3339 time")
+		}
+		return t, true, nil
+	}
+
+	if v := r.Header.Get("If-Modified-Since"); v != "" {
+		t, err := http.ParseTime(v)
+		if err != nil {
+			return time.Time{}, false, errors.New("If-Modified-Since must be an HTTP date")
+		}
+		return t, true, nil
+	}
+
+	return time.Time{}, false, nil
+}
+
+// Retrieve returns a single product from DB along with how much interest it
+// gets. Views by anyone but its seller are counted.
 func (p *Product) Retrieve(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 	id := chi.URLParam(r, "id")
 
@@ -51,7 +311,88 @@ func (p *Product) Retrieve(ctx context.Context, w http.ResponseWriter, r *http.R
 		}
 	}
 
-	return web.Respond(ctx, w, prod, http.StatusOK)
+	// Sellers looking at their own products are not counted as views.
+	if claims, ok := ctx.Value(auth.Key).(auth.Claims); !ok || claims.Subject != prod.UserID {
+		if err := product.RecordView(ctx, p.DB, prod.ID, web.Now(ctx)); err != nil {
+			p.Log.Printf("recording view of product %s : %v", prod.ID, err)
+		}
+	}
+
+	list := []product.Product{*prod}
+	if err := localize(ctx, p.DB, w, r, list); err != nil {
+		return err
+	}
+	if list[0].Language != "" {
+		w.Header().Set("Content-Language", list[0].Language)
+	}
+	if err := p.Signals.attach(ctx, list); err != nil {
+		return errors.Wrap(err, "counting interest")
+	}
+
+	return web.Respond(ctx, w, list[0], http.StatusOK)
+}
+
+// Labels returns a printable PDF sheet of price tags for the products listed
+// in the ids query parameter. The layout query parameter selects the label
+// paper the sheet is laid out for. Sellers may only print their own products.
+func (p *Product) Labels(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.product.Labels")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	if r.URL.Query().Get("ids") == "" {
+		err := errors.New("ids query parameter is required")
+		return web.NewRequestError(err, http.StatusBadRequest)
+	}
+	ids, err := productIDs(r, p.SearchPage.Max)
+	if err != nil {
+		return err
+	}
+
+	layout := r.URL.Query().Get("layout")
+	if layout == "" {
+		layout = label.DefaultLayout
+	}
+	if _, ok := label.Layouts[layout]; !ok {
+		return web.NewRequestError(label.ErrUnknownLayout, http.StatusBadRequest)
+	}
+
+	list, err := product.RetrieveMany(ctx, p.DB, ids)
+	if err != nil {
+		switch err {
+		case product.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrap(err, "looking for products")
+		}
+	}
+
+	tags := make([]label.Tag, len(list))
+	for i, prod := range list {
+		ok, err := claims.Can(ctx, auth.ActionRead, auth.Resource{Kind: "product", ID: prod.ID, Owner: prod.UserID})
+		if err != nil {
+			return errors.Wrap(err, "authorizing")
+		}
+		if !ok {
+			return web.NewRequestError(product.ErrForbidden, http.StatusForbidden)
+		}
+		tags[i] = label.Tag{
+			Name:  prod.Name,
+			Price: prod.Cost,
+			Code:  prod.ID,
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := label.Render(&buf, layout, tags); err != nil {
+		return errors.Wrap(err, "rendering labels")
+	}
+
+	return web.RespondRaw(ctx, w, buf.Bytes(), "application/pdf", http.StatusOK)
 }
 
 // Create decode a JSON document from a POST request and create a new Product
@@ -69,11 +410,27 @@ func (p *Product) Create(ctx context.Context, w http.ResponseWriter, r *http.Req
 		return err
 	}
 
-	prod, err := product.Create(ctx, p.DB, claims, np, time.Now())
+	violations, err := moderate(ctx, p.Moderation, map[string]string{
+		"name":        np.Name,
+		"description": np.Description,
+	})
 	if err != nil {
 		return err
 	}
 
+	prod, err := product.Create(ctx, p.DB, claims, np, web.Now(ctx))
+	if err != nil {
+		return err
+	}
+
+	p.flag(ctx, "product", prod.ID, violations)
+
+	// Suggestions are generated in the background so a slow enrichment
+	// service does not hold up the seller.
+	if p.Enricher != nil {
+		go p.suggest(*prod, web.Now(ctx))
+	}
+
 	return web.Respond(ctx, w, prod, http.StatusCreated)
 }
 
@@ -92,7 +449,19 @@ func (p *Product) Update(ctx context.Context, w http.ResponseWriter, r *http.Req
 		return errors.Wrap(err, "decoding product update")
 	}
 
-	if err := product.Update(ctx, p.DB, claims, id, update, time.Now()); err != nil {
+	fields := make(map[string]string)
+	if update.Name != nil {
+		fields["name"] = *update.Name
+	}
+	if update.Description != nil {
+		fields["description"] = *update.Description
+	}
+	violations, err := moderate(ctx, p.Moderation, fields)
+	if err != nil {
+		return err
+	}
+
+	if err := product.Update(ctx, p.DB, claims, id, update, web.Now(ctx)); err != nil {
 		switch err {
 		case product.ErrNotFound:
 			return web.NewRequestError(err, http.StatusNotFound)
@@ -105,6 +474,8 @@ func (p *Product) Update(ctx context.Context, w http.ResponseWriter, r *http.Req
 		}
 	}
 
+	p.flag(ctx, "product", id, violations)
+
 	return web.Respond(ctx, w, nil, http.StatusNoContent)
 }
 
@@ -121,11 +492,69 @@ func (p *Product) Delete(ctx context.Context, w http.ResponseWriter, r *http.Req
 		}
 	}
 
+	// The product is gone either way; pictures left behind are pruned the
+	// next time a product is deleted.
+	if err := product.PruneImages(ctx, p.DB, p.Images.Store); err != nil {
+		p.Log.Printf("pruning images of product %s : %v", id, err)
+	}
+
 	return web.Respond(ctx, w, nil, http.StatusNoContent)
 }
 
+// fewMatches is how many matches a search needs for its spelling to be
+// trusted.
+const fewMatches = 3
+
+// spellingTTL is how long the words searches are corrected against are kept
+// before being read again.
+const spellingTTL = 10 * time.Minute
+
+// availabilityTTL is how long availability may be served from cache.
+const availabilityTTL = time.Second
+
+// tenantKey prefixes a cache key with the tenant of the caller so what is
+// cached for one tenant is never served to another.
+func tenantKey(ctx context.Context, key string) string {
+	claims, _ := ctx.Value(auth.Key).(auth.Claims)
+	return claims.TenantID + ":" + key
+}
+
+// Availability returns just the stock of a product identified by an ID in the
+// request URL. Responses may be up to availabilityTTL old.
+func (p *Product) Availability(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.product.Availability")
+	defer span.End()
+
+	id := chi.URLParam(r, "id")
+
+	w.Header().Set("Cache-Control", "private, max-age=1")
+
+	key := tenantKey(ctx, id)
+	if a, ok := p.Stock.Get(key); ok {
+		return web.Respond(ctx, w, a, http.StatusOK)
+	}
+
+	a, err := product.RetrieveAvailability(ctx, p.DB, id)
+	if err != nil {
+		switch err {
+		case product.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case product.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrapf(err, "looking for availability of product %q", id)
+		}
+	}
+	p.Stock.Set(key, a)
+
+	return web.Respond(ctx, w, a, http.StatusOK)
+}
+
 // AddSale creates a new Sale for a particular product. It looks for a JSON
 // object in the request body. The full model is returned to the caller.
+//
+// Clients may send an Idempotency-Key header so retrying a request whose
+// response got lost does not record the sale twice.
 func (p *Product) AddSale(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 	var ns product.NewSale
 	if err := web.Decode(r, &ns); err != nil {
@@ -134,23 +563,596 @@ func (p *Product) AddSale(ctx context.Context, w http.ResponseWriter, r *http.Re
 
 	productID := chi.URLParam(r, "id")
 
-	sale, err := product.AddSale(ctx, p.DB, ns, productID, time.Now())
+	key := r.Header.Get("Idempotency-Key")
+	if len(key) > maxIdempotencyKey {
+		err := errors.Errorf("Idempotency-Key must be at most %d characters", maxIdempotencyKey)
+		return web.NewRequestError(err, http.StatusBadRequest)
+	}
+
+	sale, created, err := product.AddSale(ctx, p.DB, ns, productID, key, web.Now(ctx))
 	if err != nil {
-		return errors.Wrap(err, "adding new sale")
+		switch err {
+		case product.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case product.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		case product.ErrInsufficientStock:
+			return fieldError("quantity", err)
+		case product.ErrKeyReused:
+			return web.NewRequestError(err, http.StatusUnprocessableEntity)
+		case coupon.ErrNotFound, coupon.ErrExpired, coupon.ErrExhausted:
+			return fieldError("coupon", err)
+		default:
+			return errors.Wrap(err, "adding new sale")
+		}
+	}
+
+	// A replayed request gets the original sale back without notifying the
+	// seller a second time.
+	if !created {
+		return web.Respond(ctx, w, sale, http.StatusOK)
+	}
+
+	// Let the seller know about the sale. A failed notification must not fail
+	// the sale which has already been recorded.
+	if err := p.notifySale(ctx, sale); err != nil {
+		p.Log.Printf("notifying seller of sale %s : %v", sale.ID, err)
+	}
+	if sale.BuyerID != nil && p.Recommendations != nil {
+		if err := p.Recommendations.record(ctx, *sale.BuyerID, sale.ProductID, recommend.KindPurchase); err != nil {
+			p.Log.Printf("recording purchase of sale %s : %v", sale.ID, err)
+		}
 	}
 
 	return web.Respond(ctx, w, sale, http.StatusCreated)
 }
 
-// ListSales gets all sales for a particular product
+// notifySale lets the owner of the sold product know about a sale.
+func (p *Product) notifySale(ctx context.Context, sale *product.Sale) error {
+	return p.notifySeller(ctx, sale, templates.SaleRecorded, notification.EventSaleRecorded, "Sale recorded")
+}
+
+// notifyReversal lets the owner of the sold product know a sale was reversed
+// by a chargeback.
+func (p *Product) notifyReversal(ctx context.Context, sale *product.Sale) error {
+	return p.notifySeller(ctx, sale, templates.SaleReversed, notification.EventSaleReversed, "Sale reversed")
+}
+
+// notifySeller renders the template tmpl about a sale and sends it to the
+// owner of the sold product as event.
+func (p *Product) notifySeller(ctx context.Context, sale *product.Sale, tmpl, event, subject string) error {
+	prod, err := product.Retrieve(ctx, p.DB, sale.ProductID)
+	if err != nil {
+		return err
+	}
+
+	tenantID, locale, err := p.sellerTenant(ctx, prod.UserID)
+	if err != nil {
+		return err
+	}
+
+	data := struct {
+		product.Sale
+		ProductName string
+		Locale      string
+	}{*sale, prod.Name, locale}
+
+	body, err := p.Templates.RenderFor(ctx, tenantID, tmpl, data)
+	if err != nil {
+		return err
+	}
+
+	m := notification.Message{
+		Event:   event,
+		Subject: subject,
+		Body:    string(body),
+	}
+	return p.Notifier.Notify(ctx, prod.UserID, m)
+}
+
+// notifyWatch lets the owner of the product id know watcher started
+// watching it. It is of little value on its own so it waits for the digest
+// of the owner. Sellers watching their own products are not told.
+func (p *Product) notifyWatch(ctx context.Context, watcher auth.Claims, id string) error {
+	prod, err := product.Retrieve(ctx, p.DB, id)
+	if err != nil {
+		return err
+	}
+	if prod.UserID == watcher.Subject {
+		return nil
+	}
+
+	tenantID, locale, err := p.sellerTenant(ctx, prod.UserID)
+	if err != nil {
+		return err
+	}
+
+	data := struct {
+		ProductName string
+		Locale      string
+	}{prod.Name, locale}
+
+	body, err := p.Templates.RenderFor(ctx, tenantID, templates.ProductWatched, data)
+	if err != nil {
+		return err
+	}
+
+	m := notification.Message{
+		Event:    notification.EventProductWatched,
+		Subject:  "New watcher",
+		Body:     string(body),
+		Priority: notification.PriorityLow,
+	}
+	return p.Notifier.Notify(ctx, prod.UserID, m)
+}
+
+// sellerTenant gives the tenant of the seller userID along with its locale.
+// Messages to the seller are rendered with the templates of their tenant
+// even when the request is made by someone else, such as a payment provider.
+func (p *Product) sellerTenant(ctx context.Context, userID string) (tenantID, locale string, err error) {
+	seller, err := user.Retrieve(ctx, p.DB, userID)
+	if err != nil {
+		return "", "", err
+	}
+	if seller.TenantID != nil {
+		tenantID = *seller.TenantID
+	}
+	settings, err := p.Tenants.For(ctx, tenantID)
+	if err != nil {
+		return "", "", err
+	}
+	return tenantID, settings.Locale, nil
+}
+
+// ListSales gets sales for a particular product a page at a time. The optional
+// from and to query parameters are RFC3339 timestamps limiting the sales to a
+// date range and limit and offset select the page. The page may also start
+// past the sale with the ID in the after query parameter, or where the cursor
+// of the previous page in the X-Next-Cursor header left off.
 func (p *Product) ListSales(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 	id := chi.URLParam(r, "id")
 
-	list, err := product.ListSales(ctx, p.DB, id)
+	filter, err := parseSaleFilter(r)
+	if err != nil {
+		return web.NewRequestError(err, http.StatusBadRequest)
+	}
 
+	page, err := web.ParsePage(r, p.SalesPage)
 	if err != nil {
+		return err
+	}
+	filter.Limit = page.Limit
+	filter.Offset = page.Offset
+	if page.Cursor != "" {
+		filter.Past = new(product.Key)
+		if err := p.Cursors.Decode(listingProductSales, page.Cursor, filter.Past); err != nil {
+			return err
+		}
+	}
+
+	list, err := product.ListSales(ctx, p.DB, id, filter)
+	if err != nil {
+		if err == product.ErrSaleNotFound {
+			return web.NewRequestError(errUnknownAfter, http.StatusBadRequest)
+		}
 		return errors.Wrapf(err, "getting sales list")
 	}
 
+	if len(list) == page.Limit {
+		last := list[len(list)-1]
+		if err := nextCursor(w, p.Cursors, listingProductSales, product.Key{Date: last.DateCreated, ID: last.ID}); err != nil {
+			return err
+		}
+	}
+
+	return web.Respond(ctx, w, sales(list), http.StatusOK)
+}
+
+// UpdateSale decodes the body of a request to correct an existing sale. The
+// IDs of the product and the sale are part of the request URL.
+func (p *Product) UpdateSale(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id := chi.URLParam(r, "id")
+	saleID := chi.URLParam(r, "saleID")
+
+	var update product.SaleUpdate
+	if err := web.Decode(r, &update); err != nil {
+		return errors.Wrap(err, "decoding sale update")
+	}
+
+	sale, err := product.UpdateSale(ctx, p.DB, id, saleID, update, web.Now(ctx))
+	if err != nil {
+		switch err {
+		case product.ErrNotFound, product.ErrSaleNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case product.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		case product.ErrInsufficientStock:
+			return fieldError("quantity", err)
+		default:
+			return errors.Wrapf(err, "updating sale %q", saleID)
+		}
+	}
+
+	return web.Respond(ctx, w, sale, http.StatusOK)
+}
+
+// DeleteSale removes a single sale identified by IDs in the request URL.
+func (p *Product) DeleteSale(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id := chi.URLParam(r, "id")
+	saleID := chi.URLParam(r, "saleID")
+
+	if err := product.DeleteSale(ctx, p.DB, id, saleID); err != nil {
+		switch err {
+		case product.ErrSaleNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case product.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrapf(err, "deleting sale %q", saleID)
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// CancelSale cancels a sale identified by IDs in the request URL. Its units
+// become available again.
+func (p *Product) CancelSale(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id := chi.URLParam(r, "id")
+	saleID := chi.URLParam(r, "saleID")
+
+	sale, err := product.CancelSale(ctx, p.DB, id, saleID, web.Now(ctx))
+	if err != nil {
+		switch err {
+		case product.ErrSaleNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case product.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		case product.ErrInvalidTransition:
+			return web.NewRequestError(err, http.StatusConflict)
+		default:
+			return errors.Wrapf(err, "cancelling sale %q", saleID)
+		}
+	}
+
+	return web.Respond(ctx, w, sale, http.StatusOK)
+}
+
+// ListTranslations returns every translation of a product.
+func (p *Product) ListTranslations(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id := chi.URLParam(r, "id")
+
+	list, err := product.ListTranslations(ctx, p.DB, id)
+	if err != nil {
+		switch err {
+		case product.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case product.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrapf(err, "listing translations of product %q", id)
+		}
+	}
+
 	return web.Respond(ctx, w, list, http.StatusOK)
 }
+
+// SetTranslation adds or replaces the translation of a product into the
+// language in the request URL.
+func (p *Product) SetTranslation(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id := chi.URLParam(r, "id")
+	lang := chi.URLParam(r, "lang")
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	var ut product.UpdateTranslation
+	if err := web.Decode(r, &ut); err != nil {
+		return errors.Wrap(err, "decoding translation")
+	}
+
+	violations, err := moderate(ctx, p.Moderation, map[string]string{
+		"name":        ut.Name,
+		"description": ut.Description,
+	})
+	if err != nil {
+		return err
+	}
+
+	t, err := product.SetTranslation(ctx, p.DB, claims, id, lang, ut, web.Now(ctx))
+	if err != nil {
+		return translationError(err, id)
+	}
+
+	p.flag(ctx, "product_translation", id+"/"+t.Language, violations)
+
+	return web.Respond(ctx, w, t, http.StatusOK)
+}
+
+// DeleteTranslation removes the translation of a product into the language
+// in the request URL.
+func (p *Product) DeleteTranslation(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id := chi.URLParam(r, "id")
+	lang := chi.URLParam(r, "lang")
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	if err := product.DeleteTranslation(ctx, p.DB, claims, id, lang); err != nil {
+		return translationError(err, id)
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// translationError translates the errors of managing translations to request
+// errors with a matching status code.
+func translationError(err error, id string) error {
+	switch err {
+	case product.ErrNotFound, product.ErrTranslationNotFound:
+		return web.NewRequestError(err, http.StatusNotFound)
+	case product.ErrInvalidID, product.ErrInvalidLanguage:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	case product.ErrForbidden:
+		return web.NewRequestError(err, http.StatusForbidden)
+	default:
+		return errors.Wrapf(err, "translating product %q", id)
+	}
+}
+
+// localize replaces the content of products with the translations best
+// matching the Accept-Language header of the request.
+func localize(ctx context.Context, db *sqlx.DB, w http.ResponseWriter, r *http.Request, list []product.Product) error {
+	w.Header().Add("Vary", "Accept-Language")
+
+	if err := product.Localize(ctx, db, list, web.AcceptLanguages(r)); err != nil {
+		return errors.Wrap(err, "localizing products")
+	}
+	return nil
+}
+
+// Suggestion returns the content suggested for a product by the enrichment
+// service.
+func (p *Product) Suggestion(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id := chi.URLParam(r, "id")
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	sg, err := product.RetrieveSuggestion(ctx, p.DB, claims, id)
+	if err != nil {
+		return suggestionError(err, id)
+	}
+
+	return web.Respond(ctx, w, sg, http.StatusOK)
+}
+
+// AcceptSuggestion applies the selected parts of the suggested content to a
+// product. Sending no fields accepts the whole suggestion.
+func (p *Product) AcceptSuggestion(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id := chi.URLParam(r, "id")
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	var sa product.SuggestionAccept
+	if err := web.Decode(r, &sa); err != nil {
+		return errors.Wrap(err, "decoding accepted suggestion")
+	}
+
+	if err := product.AcceptSuggestion(ctx, p.DB, claims, id, sa, web.Now(ctx)); err != nil {
+		return suggestionError(err, id)
+	}
+
+	prod, err := product.Retrieve(ctx, p.DB, id)
+	if err != nil {
+		return errors.Wrapf(err, "looking for product %q", id)
+	}
+
+	return web.Respond(ctx, w, prod, http.StatusOK)
+}
+
+// DismissSuggestion discards the suggested content for a product.
+func (p *Product) DismissSuggestion(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id := chi.URLParam(r, "id")
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	if err := product.DismissSuggestion(ctx, p.DB, claims, id); err != nil {
+		return suggestionError(err, id)
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// suggestionError translates the errors of handling suggestions to request
+// errors with a matching status code.
+func suggestionError(err error, id string) error {
+	switch err {
+	case product.ErrNotFound, product.ErrNoSuggestion:
+		return web.NewRequestError(err, http.StatusNotFound)
+	case product.ErrInvalidID:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	case product.ErrForbidden:
+		return web.NewRequestError(err, http.StatusForbidden)
+	default:
+		return errors.Wrapf(err, "suggestion for product %q", id)
+	}
+}
+
+// suggest asks the enricher for content for a new product and stores it for
+// the seller to review. The suggestion is dated now, the time of the request
+// which created the product, as the request context is gone by the time the
+// enricher answers.
+func (p *Product) suggest(prod product.Product, now time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), suggestTimeout)
+	defer cancel()
+
+	sg, err := p.Enricher.Suggest(ctx, prod)
+	if err != nil {
+		p.Log.Printf("suggesting content for product %s : %v", prod.ID, err)
+		return
+	}
+
+	sg.ProductID = prod.ID
+	sg.DateCreated = now
+	if err := product.SaveSuggestion(ctx, p.DB, *sg); err != nil {
+		p.Log.Printf("saving suggestion for product %s : %v", prod.ID, err)
+	}
+}
+
+// flag records the violations of content which was saved anyway. A failure
+// is logged rather than failing the request since the content is saved.
+func (p *Product) flag(ctx context.Context, subjectType, subjectID string, violations []moderation.Violation) {
+	if len(violations) == 0 {
+		return
+	}
+	if err := moderation.RecordFlags(ctx, p.DB, subjectType, subjectID, violations, web.Now(ctx)); err != nil {
+		p.Log.Printf("flagging %s %s : %v", subjectType, subjectID, err)
+	}
+}
+
+// moderate screens user supplied text keyed by the name of its field. When the
+// filter rejects violations they are returned as a validation error, otherwise
+// they are returned so they can be flagged once the content is saved.
+func moderate(ctx context.Context, f *moderation.Filter, fields map[string]string) ([]moderation.Violation, error) {
+	violations, err := f.Check(ctx, fields)
+	if err != nil {
+		return nil, errors.Wrap(err, "moderating content")
+	}
+	if len(violations) == 0 || !f.Rejects() {
+		return violations, nil
+	}
+
+	fes := make([]web.FieldError, len(violations))
+	for i, v := range violations {
+		fes[i] = web.FieldError{Field: v.Field, Error: strings.Join(v.Reasons, ", ")}
+	}
+	return nil, &web.Error{
+		Err:    errors.New("content was rejected by moderation"),
+		Status: http.StatusBadRequest,
+		Fields: fes,
+	}
+}
+
+// fieldError reports err as a validation error of a single field such as a
+// sale quantity exceeding the available stock.
+func fieldError(field string, err error) error {
+	return &web.Error{
+		Err:    errors.New("field validation error"),
+		Status: http.StatusBadRequest,
+		Fields: []web.FieldError{
+			{Field: field, Error: err.Error()},
+		},
+	}
+}
+
+// parseSaleFilter reads the sales listing filters from the query string.
+func parseSaleFilter(r *http.Request) (product.SaleFilter, error) {
+	return saleFilterOf(r.URL.Query())
+}
+
+// saleFilterOf reads the sales listing filters from query parameters.
+func saleFilterOf(q url.Values) (product.SaleFilter, error) {
+	from, to, err := rangeOf(q)
+	if err != nil {
+		return product.SaleFilter{}, err
+	}
+
+	var status product.SaleStatus
+	if v := q.Get("status"); v != "" {
+		status, err = product.ParseSaleStatus(v)
+		if err != nil {
+			return product.SaleFilter{}, err
+		}
+	}
+
+	buyerID := q.Get("buyer_id")
+	if buyerID != "" {
+		if _, err := uuid.Parse(buyerID); err != nil {
+			return product.SaleFilter{}, errors.New("buyer_id must be a UUID")
+		}
+	}
+
+	after := q.Get("after")
+	if after != "" {
+		if _, err := uuid.Parse(after); err != nil {
+			return product.SaleFilter{}, errors.New("after must be a sale ID")
+		}
+	}
+
+	filter := product.SaleFilter{
+		From:       from,
+		To:         to,
+		Status:     status,
+		BuyerID:    buyerID,
+		BuyerEmail: q.Get("buyer_email"),
+		After:      after,
+	}
+	return filter, nil
+}
+
+// Listings paginated by keyset. Their cursors are bound to them so one is
+// refused by the others.
+const (
+	listingProducts     = "products"
+	listingProductSales = "product_sales"
+	listingSales        = "sales"
+	listingUsers        = "users"
+	listingDormantUsers = "dormant_users"
+	listingRoleChanges  = "role_changes"
+)
+
+// nextCursor sets the X-Next-Cursor header to a cursor for the page of listing
+// following the one which ended at key. It is only called for full pages as
+// shorter ones are the last.
+func nextCursor(w http.ResponseWriter, cursors *web.Cursors, listing string, key interface{}) error {
+	c, err := cursors.Encode(listing, key)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("X-Next-Cursor", c)
+	return nil
+}
+
+// errUnknownAfter is reported when a sales listing is to continue past a sale
+// which does not exist.
+var errUnknownAfter = errors.New("after is not a known sale")
+
+// parseRange reads the optional from and to query parameters as RFC3339
+// timestamps. Missing parameters are returned as zero times.
+func parseRange(r *http.Request) (from, to time.Time, err error) {
+	return rangeOf(r.URL.Query())
+}
+
+// rangeOf reads the optional from and to parameters of q as parseRange does.
+func rangeOf(q url.Values) (from, to time.Time, err error) {
+	if v := q.Get("from"); v != "" {
+		from, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return from, to, errors.New("from must be an RFC3339 timestamp")
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		to, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return from, to, errors.New("to must be an RFC3339 timestamp")
+		}
+	}
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		return from, to, errors.New("from must be before to")
+	}
+
+	return from, to, nil
+}
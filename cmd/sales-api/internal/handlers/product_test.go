@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestProductIDs(t *testing.T) {
+	tests := []struct {
+		ids  string
+		want []string
+		ok   bool
+	}{
+		{"a,b,c", []string{"a", "b", "c"}, true},
+		{"a,b,a,a,c", []string{"a", "b", "c"}, true},
+		{"a,a,a,a,a,a,a", []string{"a"}, true},
+		{"a,b,c,d", nil, false},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/v1/products?ids="+tt.ids, nil)
+		got, err := productIDs(r, 3)
+		if (err == nil) != tt.ok {
+			t.Errorf("ids %q: error %v, want ok %v", tt.ids, err, tt.ok)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ids %q: got %q, want %q", tt.ids, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,358 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/arammikayelyan/garagesale/internal/platform/web"
+	"github.com/arammikayelyan/garagesale/internal/product"
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// ProductV2 has the handlers of the v2 products API. It shares the product
+// store and the moderation of v1 and adapts products to the v2 schema.
+type ProductV2 struct {
+	V1   *Product
+	Page web.PageSize
+}
+
+// Product statuses of the v2 schema.
+const (
+	statusAvailable = "available"
+	statusSoldOut   = "sold_out"
+)
+
+// money is an amount in the smallest unit of a currency.
+type money struct {
+	Amount   int    `json:"amount" validate:"gte=0"`
+	Currency string `json:"currency" validate:"omitempty,len=3,alpha"`
+}
+
+// productV2 is a product in the v2 schema.
+type productV2 struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Language    string    `json:"language,omitempty"`
+	Status      string    `json:"status"`
+	Categories  []string  `json:"categories"`
+	Tags        []string  `json:"tags"`
+	Price       money     `json:"price"`
+	Quantity    int       `json:"quantity"`
+	Sold        int       `json:"sold"`
+	Revenue     money     `json:"revenue"`
+	SellerID    string    `json:"seller_id"`
+	DateCreated time.Time `json:"date_created"`
+	DateUpdated time.Time `json:"date_updated"`
+}
+
+// newProductV2 is what is required to create a product in the v2 schema. A
+// product has at most one category for now.
+type newProductV2 struct {
+	Name        string   `json:"name" validate:"required"`
+	Description string   `json:"description"`
+	Categories  []string `json:"categories" validate:"max=1"`
+	Tags        []string `json:"tags"`
+	Price       money    `json:"price"`
+	Quantity    int      `json:"quantity" validate:"gte=1"`
+}
+
+// updateProductV2 is what may be changed of a product in the v2 schema.
+type updateProductV2 struct {
+	Name        *string   `json:"name"`
+	Description *string   `json:"description"`
+	Categories  *[]string `json:"categories" validate:"omitempty,max=1"`
+	Tags        *[]string `json:"tags"`
+	Price       *money    `json:"price"`
+	Quantity    *int      `json:"quantity" validate:"omitempty,gte=1"`
+}
+
+// envelope wraps a page of a v2 listing.
+type envelope struct {
+	Data interface{} `json:"data"`
+	Page pageInfo    `json:"page"`
+}
+
+// pageInfo describes the page in an envelope.
+type pageInfo struct {
+	Limit      int    `json:"limit"`
+	Offset     int    `json:"offset"`
+	Total      int    `json:"total"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// currency is the currency prices are in.
+func (p *ProductV2) currency() string {
+	return strings.ToUpper(p.V1.Tenants.Defaults().Currency)
+}
+
+// toV2 adapts a stored product to the v2 schema.
+func (p *ProductV2) toV2(prod product.Product) productV2 {
+	status := statusAvailable
+	if prod.Quantity-prod.Sold <= 0 {
+		status = statusSoldOut
+	}
+
+	categories := []string{}
+	if prod.Category != "" {
+		categories = append(categories, prod.Category)
+	}
+
+	cur := p.currency()
+	return productV2{
+		ID:          prod.ID,
+		Name:        prod.Name,
+		Description: prod.Description,
+		Language:    prod.Language,
+		Status:      status,
+		Categories:  categories,
+		Tags:        prod.Tags,
+		Price:       money{Amount: prod.Cost, Currency: cur},
+		Quantity:    prod.Quantity,
+		Sold:        prod.Sold,
+		Revenue:     money{Amount: prod.Revenue, Currency: cur},
+		SellerID:    prod.UserID,
+		DateCreated: prod.DateCreated,
+		DateUpdated: prod.DateUpdated,
+	}
+}
+
+// checkCurrency rejects prices which are not in the currency products are
+// sold in.
+func (p *ProductV2) checkCurrency(m money) error {
+	if m.Currency != "" && !strings.EqualFold(m.Currency, p.currency()) {
+		return fieldError("price.currency", errors.Errorf("prices must be in %s", p.currency()))
+	}
+	return nil
+}
+
+// List returns a page of products wrapped in an envelope. Full pages carry a
+// next_cursor which the cursor query parameter continues from.
+func (p *ProductV2) List(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.product.v2.List")
+	defer span.End()
+
+	page, err := web.ParsePage(r, p.Page)
+	if err != nil {
+		return err
+	}
+
+	var past *product.Key
+	if page.Cursor != "" {
+		past = new(product.Key)
+		if err := p.V1.Cursors.Decode(listingProducts, page.Cursor, past); err != nil {
+			return err
+		}
+	}
+
+	list, total, err := product.ListPage(ctx, p.V1.DB, page.Limit, page.Offset, past)
+	if err != nil {
+		return errors.Wrap(err, "listing products")
+	}
+
+	if err := localize(ctx, p.V1.DB, w, r, list); err != nil {
+		return err
+	}
+
+	data := make([]productV2, len(list))
+	for i, prod := range list {
+		data[i] = p.toV2(prod)
+	}
+
+	env := envelope{
+		Data: data,
+		Page: pageInfo{Limit: page.Limit, Offset: page.Offset, Total: total},
+	}
+	if len(list) == page.Limit {
+		last := list[len(list)-1]
+		env.Page.NextCursor, err = p.V1.Cursors.Encode(listingProducts, product.Key{Date: last.DateCreated, ID: last.ID})
+		if err != nil {
+			return err
+		}
+	}
+
+	return web.Respond(ctx, w, env, http.StatusOK)
+}
+
+// Retrieve returns a single product identified by an ID in the request URL.
+func (p *ProductV2) Retrieve(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.product.v2.Retrieve")
+	defer span.End()
+
+	id := chi.URLParam(r, "id")
+
+	prod, err := p.retrieve(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	list := []product.Product{*prod}
+	if err := localize(ctx, p.V1.DB, w, r, list); err != nil {
+		return err
+	}
+	if list[0].Language != "" {
+		w.Header().Set("Content-Language", list[0].Language)
+	}
+
+	return web.Respond(ctx, w, p.toV2(list[0]), http.StatusOK)
+}
+
+// Create adds a product sold by the user making the request.
+func (p *ProductV2) Create(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.product.v2.Create")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	var np newProductV2
+	if err := web.Decode(r, &np); err != nil {
+		return errors.Wrap(err, "decoding new product")
+	}
+	if err := p.checkCurrency(np.Price); err != nil {
+		return err
+	}
+
+	violations, err := moderate(ctx, p.V1.Moderation, map[string]string{
+		"name":        np.Name,
+		"description": np.Description,
+	})
+	if err != nil {
+		return err
+	}
+
+	v1 := product.NewProduct{
+		Name:        np.Name,
+		Description: np.Description,
+		Tags:        np.Tags,
+		Cost:        np.Price.Amount,
+		Quantity:    np.Quantity,
+	}
+	if len(np.Categories) > 0 {
+		v1.Category = np.Categories[0]
+	}
+
+	prod, err := product.Create(ctx, p.V1.DB, claims, v1, web.Now(ctx))
+	if err != nil {
+		return errors.Wrap(err, "creating product")
+	}
+
+	p.V1.flag(ctx, "product", prod.ID, violations)
+	if p.V1.Enricher != nil {
+		go p.V1.suggest(*prod, web.Now(ctx))
+	}
+
+	return web.Respond(ctx, w, p.toV2(*prod), http.StatusCreated)
+}
+
+// Update changes a product identified by an ID in the request URL and returns
+// it as it is now.
+func (p *ProductV2) Update(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.product.v2.Update")
+	defer span.End()
+
+	id := chi.URLParam(r, "id")
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	var up updateProductV2
+	if err := web.Decode(r, &up); err != nil {
+		return errors.Wrap(err, "decoding product update")
+	}
+
+	v1 := product.UpdateProduct{
+		Name:        up.Name,
+		Description: up.Description,
+		Tags:        up.Tags,
+		Quantity:    up.Quantity,
+	}
+	if up.Categories != nil {
+		category := ""
+		if len(*up.Categories) > 0 {
+			category = (*up.Categories)[0]
+		}
+		v1.Category = &category
+	}
+	if up.Price != nil {
+		if err := p.checkCurrency(*up.Price); err != nil {
+			return err
+		}
+		v1.Cost = &up.Price.Amount
+	}
+
+	fields := make(map[string]string)
+	if up.Name != nil {
+		fields["name"] = *up.Name
+	}
+	if up.Description != nil {
+		fields["description"] = *up.Description
+	}
+	violations, err := moderate(ctx, p.V1.Moderation, fields)
+	if err != nil {
+		return err
+	}
+
+	if err := product.Update(ctx, p.V1.DB, claims, id, v1, web.Now(ctx)); err != nil {
+		return productError(err, id)
+	}
+
+	p.V1.flag(ctx, "product", id, violations)
+
+	prod, err := p.retrieve(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return web.Respond(ctx, w, p.toV2(*prod), http.StatusOK)
+}
+
+// Delete removes a product identified by an ID in the request URL.
+func (p *ProductV2) Delete(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.product.v2.Delete")
+	defer span.End()
+
+	id := chi.URLParam(r, "id")
+
+	if err := product.Delete(ctx, p.V1.DB, id); err != nil {
+		return productError(err, id)
+	}
+
+	if err := product.PruneImages(ctx, p.V1.DB, p.V1.Images.Store); err != nil {
+		p.V1.Log.Printf("pruning images of product %s : %v", id, err)
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// retrieve reads a product translating errors to responses.
+func (p *ProductV2) retrieve(ctx context.Context, id string) (*product.Product, error) {
+	prod, err := product.Retrieve(ctx, p.V1.DB, id)
+	if err != nil {
+		return nil, productError(err, id)
+	}
+	return prod, nil
+}
+
+// productError translates errors of the product store to responses.
+func productError(err error, id string) error {
+	switch err {
+	case product.ErrNotFound:
+		return web.NewRequestError(err, http.StatusNotFound)
+	case product.ErrInvalidID:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	case product.ErrForbidden:
+		return web.NewRequestError(err, http.StatusForbidden)
+	default:
+		return errors.Wrapf(err, "managing product %q", id)
+	}
+}
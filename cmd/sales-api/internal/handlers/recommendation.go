@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/arammikayelyan/garagesale/internal/platform/web"
+	"github.com/arammikayelyan/garagesale/internal/product"
+	"github.com/arammikayelyan/garagesale/internal/recommend"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// recommendationPage sizes the recommendations of a user. They are never
+// paged through so the offset is ignored.
+var recommendationPage = web.PageSize{Default: 20, Max: 100}
+
+// Recommendations has handler methods for suggesting products to users and
+// recording how they react to them.
+type Recommendations struct {
+	DB          *sqlx.DB
+	Log         *log.Logger
+	Recommender recommend.Recommender
+}
+
+// List returns the products recommended to the caller, best matches first.
+func (rc *Recommendations) List(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Recommendations.List")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	page, err := web.ParsePage(r, recommendationPage)
+	if err != nil {
+		return web.NewRequestError(err, http.StatusBadRequest)
+	}
+
+	recs, err := rc.Recommender.Recommend(ctx, claims.Subject, page.Limit)
+	if err != nil {
+		return errors.Wrap(err, "recommending products")
+	}
+
+	ids := make([]string, len(recs))
+	for i, rec := range recs {
+		ids[i] = rec.ProductID
+	}
+	prods, err := product.RetrieveMany(ctx, rc.DB, ids)
+	if err != nil {
+		return errors.Wrap(err, "retrieving recommended products")
+	}
+	byID := make(map[string]*product.Product, len(prods))
+	for i := range prods {
+		byID[prods[i].ID] = &prods[i]
+	}
+
+	// An external recommender may know of products deleted since; they are
+	// left out.
+	list := []recommend.Recommendation{}
+	for _, rec := range recs {
+		if rec.Product = byID[rec.ProductID]; rec.Product != nil {
+			list = append(list, rec)
+		}
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// Click records that the caller clicked on a recommended product.
+func (rc *Recommendations) Click(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Recommendations.Click")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	var nc recommend.NewClick
+	if err := web.Decode(r, &nc); err != nil {
+		return errors.Wrap(err, "decoding click")
+	}
+
+	if err := rc.record(ctx, claims.Subject, nc.ProductID, recommend.KindClick); err != nil {
+		switch err {
+		case product.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case product.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrapf(err, "recording click on product %q", nc.ProductID)
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// record stores feedback of the user with the id userID and passes it on to
+// recommenders learning from it. Failing to pass it on is only logged as the
+// feedback is stored to train on later.
+func (rc *Recommendations) record(ctx context.Context, userID, productID, kind string) error {
+	f, err := recommend.RecordFeedback(ctx, rc.DB, userID, productID, kind, web.Now(ctx))
+	if err != nil {
+		return err
+	}
+
+	if l, ok := rc.Recommender.(recommend.Learner); ok {
+		if err := l.Learn(ctx, *f); err != nil {
+			rc.Log.Printf("sending %s feedback %s : %v", kind, f.ID, err)
+		}
+	}
+	return nil
+}
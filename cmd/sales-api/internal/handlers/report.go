@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/arammikayelyan/garagesale/internal/exchange"
+	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/arammikayelyan/garagesale/internal/platform/web"
+	"github.com/arammikayelyan/garagesale/internal/report"
+	"github.com/arammikayelyan/garagesale/internal/tenant"
+	"github.com/go-chi/chi"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// Report has handler methods for business reports.
+type Report struct {
+	DB *sqlx.DB
+
+	// Rates fetches the exchange rates needed to convert reports. Reports
+	// can only be converted with rates already stored when it is nil.
+	Rates *exchange.Rates
+
+	// Tenants gives the currency reports of tenant users are converted to
+	// when they do not ask for one.
+	Tenants *tenant.Config
+
+	// Jobs computes reports in the background. Reports can only be computed
+	// within a request when it is nil.
+	Jobs *report.Runner
+}
+
+// Revenue returns revenue and units sold per product bucketed by the group_by
+// query parameter. Admins see every product while sellers only see their own.
+// Sales in other currencies are converted when the currency parameter asks
+// for the report in a single currency.
+func (rp *Report) Revenue(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.report.Revenue")
+	defer span.End()
+
+	filter, err := reportFilter(ctx, r)
+	if err != nil {
+		return err
+	}
+
+	groupBy := r.URL.Query().Get("group_by")
+	if groupBy == "" {
+		groupBy = report.GroupByDay
+	}
+
+	currency, err := rp.currency(ctx, r, filter)
+	if err != nil {
+		return err
+	}
+
+	list, err := report.Revenue(ctx, rp.DB, groupBy, currency, filter)
+	if err != nil {
+		switch err {
+		case report.ErrInvalidGrouping, report.ErrInvalidCurrency:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		case report.ErrMissingRate:
+			return web.NewRequestError(err, http.StatusServiceUnavailable)
+		default:
+			return errors.Wrap(err, "computing revenue report")
+		}
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// StartRevenue starts computing the revenue report Revenue gives in the
+// background, for date ranges too large to compute within a request. The
+// from parameter is required. The response points to where the progress and
+// result of the job can be followed.
+func (rp *Report) StartRevenue(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.report.StartRevenue")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	filter, err := reportFilter(ctx, r)
+	if err != nil {
+		return err
+	}
+
+	groupBy := r.URL.Query().Get("group_by")
+	if groupBy == "" {
+		groupBy = report.GroupByDay
+	}
+
+	currency, err := rp.currency(ctx, r, filter)
+	if err != nil {
+		return err
+	}
+
+	job, err := rp.Jobs.Start(ctx, groupBy, currency, filter, claims.Subject, web.Now(ctx))
+	if err != nil {
+		switch err {
+		case report.ErrInvalidGrouping, report.ErrInvalidCurrency, report.ErrMissingFrom:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrap(err, "starting revenue report")
+		}
+	}
+
+	w.Header().Set("Location", "/v1/reports/jobs/"+job.ID)
+	return web.Respond(ctx, w, job, http.StatusAccepted)
+}
+
+// Job returns the progress of a report job identified by an ID in the request
+// URL, along with the report once it is done. Users see their own jobs and
+// admins see every job.
+func (rp *Report) Job(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.report.Job")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	id := chi.URLParam(r, "id")
+
+	job, err := rp.Jobs.Job(ctx, id, claims.Subject, claims.HasRole(auth.RoleAdmin))
+	if err != nil {
+		switch err {
+		case report.ErrJobNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		default:
+			return errors.Wrapf(err, "reading report job %q", id)
+		}
+	}
+
+	return web.Respond(ctx, w, job, http.StatusOK)
+}
+
+// currency gives the currency a report is asked for in, which defaults to
+// the currency of the caller's tenant, and makes sure the exchange rates for
+// converting the sales matching filter to it are stored.
+func (rp *Report) currency(ctx context.Context, r *http.Request, filter report.Filter) (string, error) {
+	currency := strings.ToUpper(r.URL.Query().Get("currency"))
+	if claims, ok := ctx.Value(auth.Key).(auth.Claims); ok && currency == "" && claims.TenantID != "" {
+		settings, err := rp.Tenants.For(ctx, claims.TenantID)
+		if err != nil {
+			return "", errors.Wrap(err, "reading tenant settings")
+		}
+		currency = strings.ToUpper(settings.Currency)
+	}
+	if currency != "" && rp.Rates != nil {
+		days, err := report.RateDays(ctx, rp.DB, currency, filter)
+		if err != nil {
+			return "", errors.Wrap(err, "listing sale currencies")
+		}
+		for _, d := range days {
+			if err := rp.Rates.Ensure(ctx, d.Day, d.Currency); err != nil {
+				return "", errors.Wrap(err, "fetching exchange rates")
+			}
+		}
+	}
+	return currency, nil
+}
+
+// maxTopProducts caps the limit of the top products report.
+const maxTopProducts = 100
+
+// TopProducts ranks products by units sold or revenue over the period query
+// parameter, 30d by default, counting back from now. The by parameter picks
+// the measure and limit how many products are returned. Admins see every
+// product while sellers only see their own.
+func (rp *Report) TopProducts(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.report.TopProducts")
+	defer span.End()
+
+	filter, err := reportFilter(ctx, r)
+	if err != nil {
+		return err
+	}
+
+	q := r.URL.Query()
+
+	period := q.Get("period")
+	if period == "" {
+		period = "30d"
+	}
+	d, err := parsePeriod(period)
+	if err != nil {
+		return web.NewRequestError(err, http.StatusBadRequest)
+	}
+	filter.From = web.Now(ctx).Add(-d)
+	filter.To = time.Time{}
+
+	by := q.Get("by")
+	if by == "" {
+		by = report.RankByUnits
+	}
+
+	limit := 10
+	if v := q.Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxTopProducts {
+			err := errors.Errorf("limit must be a number between 1 and %d", maxTopProducts)
+			return web.NewRequestError(err, http.StatusBadRequest)
+		}
+	}
+
+	list, err := report.TopProducts(ctx, rp.DB, by, limit, filter)
+	if err != nil {
+		switch err {
+		case report.ErrInvalidRanking:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrap(err, "computing top products")
+		}
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// parsePeriod parses a length of time given in days such as 30d or as a Go
+// duration such as 12h.
+func parsePeriod(s string) (time.Duration, error) {
+	err := errors.New("period must be a number of days such as 30d or a duration such as 12h")
+
+	if strings.HasSuffix(s, "d") {
+		days, convErr := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if convErr != nil || days < 1 {
+			return 0, err
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+
+	d, parseErr := time.ParseDuration(s)
+	if parseErr != nil || d <= 0 {
+		return 0, err
+	}
+	return d, nil
+}
+
+// reportFilter reads the date range of a report from the query string and
+// scopes it to the caller's products unless the caller is an admin.
+func reportFilter(ctx context.Context, r *http.Request) (report.Filter, error) {
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return report.Filter{}, web.NewShutdownError("auth claim is not in context")
+	}
+
+	from, to, err := parseRange(r)
+	if err != nil {
+		return report.Filter{}, web.NewRequestError(err, http.StatusBadRequest)
+	}
+
+	filter := report.Filter{
+		From: from,
+		To:   to,
+	}
+	if !claims.HasRole(auth.RoleAdmin) {
+		filter.UserID = claims.Subject
+	}
+
+	return filter, nil
+}
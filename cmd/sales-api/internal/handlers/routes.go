@@ -1,35 +1,378 @@
 package handlers
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
+	"time"
 
+	"github.com/arammikayelyan/garagesale/internal/consent"
+	"github.com/arammikayelyan/garagesale/internal/enrich"
+	"github.com/arammikayelyan/garagesale/internal/exchange"
+	"github.com/arammikayelyan/garagesale/internal/export"
 	"github.com/arammikayelyan/garagesale/internal/mid"
+	"github.com/arammikayelyan/garagesale/internal/moderation"
+	"github.com/arammikayelyan/garagesale/internal/notification"
+	"github.com/arammikayelyan/garagesale/internal/payment"
 	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/arammikayelyan/garagesale/internal/platform/cache"
+	"github.com/arammikayelyan/garagesale/internal/platform/clock"
+	"github.com/arammikayelyan/garagesale/internal/platform/oidc"
 	"github.com/arammikayelyan/garagesale/internal/platform/web"
+	"github.com/arammikayelyan/garagesale/internal/product"
+	"github.com/arammikayelyan/garagesale/internal/receipt"
+	"github.com/arammikayelyan/garagesale/internal/recommend"
+	"github.com/arammikayelyan/garagesale/internal/report"
+	"github.com/arammikayelyan/garagesale/internal/templates"
+	"github.com/arammikayelyan/garagesale/internal/tenant"
+	"github.com/arammikayelyan/garagesale/internal/upload"
+	"github.com/arammikayelyan/garagesale/internal/usage"
+	"github.com/arammikayelyan/garagesale/internal/user"
 	"github.com/jmoiron/sqlx"
 )
 
+// Config is what the API is built from. The parts documented as optional
+// turn off the routes needing them when left nil.
+type Config struct {
+	Shutdown      chan os.Signal
+	Log           *log.Logger
+	Clock         clock.Clock
+	DB            *sqlx.DB
+	Authenticator *auth.Authenticator
+	Notifier      *notification.Notifier
+	Templates     *templates.Store
+	Moderation    *moderation.Filter
+	Enricher      enrich.Enricher
+	Recommender   recommend.Recommender
+	Tenants       *tenant.Config
+	Rates         *exchange.Rates
+	Risk          Risk
+	AccountMail   AccountMail
+	Lockout       user.Lockout
+	Pages         Pages
+	Suggestions   Suggestions
+	Images        Images
+	Uploads       *upload.Screen
+
+	// Payments takes payments when set. RestockChargebacks puts the stock
+	// of charged back sales back on sale.
+	Payments           payment.Provider
+	RestockChargebacks bool
+
+	// ResetLimit is how many password reset links one address may ask for
+	// per ResetWindow. Zero is unlimited.
+	ResetLimit  int
+	ResetWindow time.Duration
+
+	// TaxRate is the sales tax in percent included in the amounts paid.
+	TaxRate float64
+
+	// InterestTTL is how long the interest in products is cached.
+	InterestTTL time.Duration
+
+	// Meter, Tracker, Reports, Exports and OIDC are optional.
+	Meter   *usage.Meter
+	Tracker *consent.Tracker
+	Reports *report.Runner
+	Exports *export.Runner
+	OIDC    *oidc.Provider
+
+	// Bots guards the public pages and Compress compresses responses. They
+	// are optional.
+	Bots     web.Middleware
+	Compress web.Middleware
+
+	// Hooks are registered on the App.
+	Hooks []web.Hook
+
+	// Credentials, Policy and SummedSales are put in the context of every
+	// request. Passwords follow user.DefaultCredentials when Credentials is
+	// nil and roles decide when Policy is nil.
+	Credentials *user.Credentials
+	Policy      auth.Policy
+	SummedSales bool
+}
+
 // API constructs a handler that knows about all API routes
-func API(shutdown chan os.Signal, log *log.Logger, db *sqlx.DB, authenticator *auth.Authenticator) http.Handler {
-	app := web.NewApp(shutdown, log, mid.Logger(log), mid.Errors(log), mid.Metrics(), mid.Panics())
+func API(cfg Config) *web.App {
+	// Compression wraps everything but the request ID so error responses are
+	// compressed too.
+	mw := []web.Middleware{mid.RequestID()}
+	if cfg.Compress != nil {
+		mw = append(mw, cfg.Compress)
+	}
+	mw = append(mw, mid.Logger(cfg.Log))
+	if cfg.Tracker != nil {
+		mw = append(mw, mid.Analytics(cfg.Tracker))
+	}
+	mw = append(mw, mid.Errors(cfg.Log), mid.Metrics())
+	if cfg.Meter != nil {
+		mw = append(mw, mid.Usage(cfg.Meter))
+	}
+	mw = append(mw, mid.Panics(), settings(cfg))
 
-	c := Check{DB: db}
+	app := web.NewApp(cfg.Shutdown, cfg.Log, mw...)
+	for _, h := range cfg.Hooks {
+		app.Register(h)
+	}
+
+	app.SetClock(cfg.Clock)
+
+	// Validation messages default to the language of the tenant.
+	app.SetTenantLocales(func(ctx context.Context, tenantID string) string {
+		s, err := cfg.Tenants.For(ctx, tenantID)
+		if err != nil {
+			return ""
+		}
+		return s.Locale
+	})
+
+	// Clients get [] rather than null for empty lists and 0 rather than null
+	// for missing amounts.
+	app.SetJSONOptions(web.JSONOptions{
+		EmptyCollections: true,
+		ZeroNumbers:      true,
+	})
+
+	c := Check{DB: cfg.DB}
 	app.Handle(http.MethodGet, "/v1/health", c.Health)
 
-	u := Users{DB: db, authenticator: authenticator}
+	u := Users{DB: cfg.DB, Log: cfg.Log, Mail: cfg.AccountMail, Lockout: cfg.Lockout, OIDC: cfg.OIDC, Page: keyset(cfg.Pages.Users), Cursors: cfg.Pages.Cursors, Uploads: cfg.Uploads, authenticator: cfg.Authenticator}
 	app.Handle(http.MethodGet, "/v1/users/token", u.Token)
+	app.Handle(http.MethodPost, "/v1/users/token/refresh", u.Refresh)
+	if cfg.OIDC != nil {
+		app.Handle(http.MethodGet, "/v1/users/oidc/login", u.OIDCLogin)
+		app.Handle(http.MethodGet, "/v1/users/oidc/callback", u.OIDCCallback)
+	}
+	app.Handle(http.MethodPost, "/v1/users/logout", u.Logout, mid.Authenticate(cfg.Authenticator))
+	app.Handle(http.MethodPost, "/v1/users/password/forgot", u.ForgotPassword, mid.RateLimit(cfg.ResetLimit, cfg.ResetWindow))
+	app.Handle(http.MethodPost, "/v1/users/password/reset", u.ResetPassword)
+	app.Handle(http.MethodPost, "/v1/users/verify", u.Verify)
+	app.Handle(http.MethodPost, "/v1/users/verify/resend", u.ResendVerification)
+	app.Handle(http.MethodGet, "/v1/users/me", u.Me, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeAccount))
+	app.Handle(http.MethodPut, "/v1/users/me", u.UpdateMe, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeAccount), mid.NotImpersonated())
+	app.Handle(http.MethodPut, "/v1/users/me/password", u.ChangePassword, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeAccount), mid.NotImpersonated())
+	app.Handle(http.MethodGet, "/v1/users/me/sessions", u.Sessions, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeAccount), mid.NotImpersonated())
+	app.Handle(http.MethodDelete, "/v1/users/me/sessions/{id}", u.RevokeSession, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeAccount), mid.NotImpersonated())
+	app.Handle(http.MethodPost, "/v1/users/me/mfa", u.EnrollMFA, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeAccount), mid.NotImpersonated())
+	app.Handle(http.MethodPost, "/v1/users/me/mfa/confirm", u.ConfirmMFA, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeAccount), mid.NotImpersonated())
+	app.Handle(http.MethodDelete, "/v1/users/me/mfa", u.DisableMFA, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeAccount), mid.NotImpersonated())
+	app.Handle(http.MethodGet, "/v1/users", u.List, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeAdmin), mid.HasRole(auth.RoleAdmin))
+	app.Handle(http.MethodPost, "/v1/users", u.Create, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeAdmin), mid.HasRole(auth.RoleAdmin))
+	app.Handle(http.MethodPost, "/v1/users/import", u.Import, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeAdmin), mid.HasRole(auth.RoleAdmin))
+	app.Handle(http.MethodGet, "/v1/users/{id}", u.Retrieve, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeAdmin), mid.HasRole(auth.RoleAdmin))
+	app.Handle(http.MethodPut, "/v1/users/{id}", u.Update, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeAdmin), mid.HasRole(auth.RoleAdmin))
+	app.Handle(http.MethodDelete, "/v1/users/{id}", u.Delete, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeAdmin), mid.HasRole(auth.RoleAdmin))
+	app.Handle(http.MethodPost, "/v1/users/{id}/reactivate", u.Reactivate, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeAdmin), mid.HasRole(auth.RoleAdmin))
+	app.Handle(http.MethodPost, "/v1/users/{id}/merge", u.Merge, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeAdmin), mid.HasRole(auth.RoleAdmin))
+	app.Handle(http.MethodPut, "/v1/users/{id}/roles", u.SetRoles, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeAdmin), mid.HasRole(auth.RoleAdmin))
+	app.Handle(http.MethodGet, "/v1/users/{id}/roles/history", u.RoleChanges, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeAdmin), mid.HasRole(auth.RoleAdmin))
+	app.Handle(http.MethodPost, "/v1/users/{id}/impersonate", u.Impersonate, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeAdmin), mid.HasRole(auth.RoleAdmin))
+
+	cs := Consents{DB: cfg.DB}
+	app.Handle(http.MethodPost, "/v1/consents", cs.Create)
+	app.Handle(http.MethodGet, "/v1/consents/{id}", cs.Retrieve)
+	app.Handle(http.MethodPut, "/v1/consents/{id}", cs.Update)
+	app.Handle(http.MethodGet, "/v1/users/me/consent", cs.Mine, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeAccount))
+	app.Handle(http.MethodPut, "/v1/users/me/consent", cs.SaveMine, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeAccount))
+
+	n := Notifications{DB: cfg.DB, Page: cfg.Pages.Notifications}
+	app.Handle(http.MethodGet, "/v1/users/me/channels", n.ListChannels, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeAccount))
+	app.Handle(http.MethodPut, "/v1/users/me/channels/{channel}", n.SetChannel, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeAccount))
+	app.Handle(http.MethodGet, "/v1/users/me/devices", n.ListDevices, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeAccount))
+	app.Handle(http.MethodPost, "/v1/users/me/devices", n.RegisterDevice, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeAccount))
+	app.Handle(http.MethodDelete, "/v1/users/me/devices/{token}", n.UnregisterDevice, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeAccount))
+	app.Handle(http.MethodGet, "/v1/users/me/notification-preferences", n.Preferences, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeAccount))
+	app.Handle(http.MethodPut, "/v1/users/me/notification-preferences", n.UpdatePreferences, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeAccount))
+	app.Handle(http.MethodGet, "/v1/users/me/notification-preferences/digest", n.Digest, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeAccount))
+	app.Handle(http.MethodPut, "/v1/users/me/notification-preferences/digest", n.UpdateDigest, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeAccount))
+	app.Handle(http.MethodGet, "/v1/users/me/notifications", n.ListInApp, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeAccount))
+
+	rc := Recommendations{DB: cfg.DB, Log: cfg.Log, Recommender: cfg.Recommender}
+	app.Handle(http.MethodGet, "/v1/users/me/recommendations", rc.List, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeAccount))
+	app.Handle(http.MethodPost, "/v1/users/me/recommendations/clicks", rc.Click, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeAccount))
+
+	signals := Signals{DB: cfg.DB, Cache: cache.New(cfg.InterestTTL), Tenants: cfg.Tenants}
+
+	p := Product{
+		DB:              cfg.DB,
+		Log:             cfg.Log,
+		Notifier:        cfg.Notifier,
+		Templates:       cfg.Templates,
+		Moderation:      cfg.Moderation,
+		Enricher:        cfg.Enricher,
+		Stock:           cache.New(availabilityTTL),
+		Tenants:         cfg.Tenants,
+		Speller:         product.NewSpeller(cfg.DB, spellingTTL),
+		Images:          cfg.Images,
+		Uploads:         cfg.Uploads,
+		Signals:         &signals,
+		Recommendations: &rc,
+		SalesPage:       keyset(cfg.Pages.Sales),
+		Cursors:         cfg.Pages.Cursors,
+		SearchPage:      cfg.Pages.Products,
+	}
+	app.Handle(http.MethodGet, "/v1/products", p.List, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeProductsRead))
+	app.Handle(http.MethodPost, "/v1/products", p.Create, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeProductsWrite))
+	app.Handle(http.MethodGet, "/v1/products/labels", p.Labels, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeProductsRead))
+	app.Handle(http.MethodGet, "/v1/products/search", p.Search, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeProductsRead))
+	app.Handle(http.MethodGet, "/v1/products/{id}", p.Retrieve, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeProductsRead))
+	app.Handle(http.MethodGet, "/v1/products/{id}/availability", p.Availability, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeProductsRead))
+	app.Handle(http.MethodPut, "/v1/products/{id}", p.Update, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeProductsWrite))
+	app.Handle(http.MethodDelete, "/v1/products/{id}", p.Delete, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeProductsWrite), mid.HasRole(auth.RoleAdmin))
 
-	p := Product{DB: db, Log: log}
-	app.Handle(http.MethodGet, "/v1/products", p.List, mid.Authenticate(authenticator))
-	app.Handle(http.MethodPost, "/v1/products", p.Create, mid.Authenticate(authenticator))
-	app.Handle(http.MethodGet, "/v1/products/{id}", p.Retrieve, mid.Authenticate(authenticator))
-	app.Handle(http.MethodPut, "/v1/products/{id}", p.Update, mid.Authenticate(authenticator))
-	app.Handle(http.MethodDelete, "/v1/products/{id}", p.Delete, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
+	app.Handle(http.MethodPost, "/v1/products/{id}/watch", p.Watch, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeAccount))
+	app.Handle(http.MethodDelete, "/v1/products/{id}/watch", p.Unwatch, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeAccount))
 
-	app.Handle(http.MethodPost, "/v1/products/{id}/sales", p.AddSale, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
-	app.Handle(http.MethodGet, "/v1/products/{id}/sales", p.ListSales, mid.Authenticate(authenticator))
+	app.Handle(http.MethodGet, "/v1/products/{id}/images", p.ListImages, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeProductsRead))
+	app.Handle(http.MethodPost, "/v1/products/{id}/images", p.AddImage, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeProductsWrite))
+	app.HandleStream(http.MethodGet, "/v1/products/{id}/images/{imageID}", p.Image, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeProductsRead))
+	app.Handle(http.MethodDelete, "/v1/products/{id}/images/{imageID}", p.DeleteImage, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeProductsWrite))
+
+	app.Handle(http.MethodGet, "/v1/products/{id}/translations", p.ListTranslations, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeProductsRead))
+	app.Handle(http.MethodPut, "/v1/products/{id}/translations/{lang}", p.SetTranslation, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeProductsWrite))
+	app.Handle(http.MethodDelete, "/v1/products/{id}/translations/{lang}", p.DeleteTranslation, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeProductsWrite))
+
+	app.Handle(http.MethodGet, "/v1/products/{id}/suggestion", p.Suggestion, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeProductsRead))
+	app.Handle(http.MethodPost, "/v1/products/{id}/suggestion/accept", p.AcceptSuggestion, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeProductsWrite))
+	app.Handle(http.MethodDelete, "/v1/products/{id}/suggestion", p.DismissSuggestion, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeProductsWrite))
+
+	app.Handle(http.MethodPost, "/v1/products/{id}/sales", p.AddSale, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeSalesWrite), mid.HasRole(auth.RoleAdmin))
+	app.Handle(http.MethodGet, "/v1/products/{id}/sales", p.ListSales, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeSalesRead))
+	app.Handle(http.MethodPut, "/v1/products/{id}/sales/{saleID}", p.UpdateSale, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeSalesWrite), mid.HasRole(auth.RoleAdmin))
+	app.Handle(http.MethodDelete, "/v1/products/{id}/sales/{saleID}", p.DeleteSale, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeSalesWrite), mid.HasRole(auth.RoleAdmin))
+	app.Handle(http.MethodPost, "/v1/products/{id}/sales/{saleID}/cancel", p.CancelSale, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeSalesWrite), mid.HasRole(auth.RoleAdmin))
+
+	sr := Search{DB: cfg.DB, Cache: cache.New(cfg.Suggestions.CacheTTL), CacheTTL: cfg.Suggestions.CacheTTL}
+	app.Handle(http.MethodGet, "/v1/search/suggest", sr.Suggest, mid.RateLimit(cfg.Suggestions.RateLimit, cfg.Suggestions.Window), mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeProductsRead))
+
+	// The v2 products API shares the store of v1 with a revised schema.
+	p2 := ProductV2{V1: &p, Page: keyset(cfg.Pages.Products)}
+	app.Handle(http.MethodGet, "/v2/products", p2.List, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeProductsRead))
+	app.Handle(http.MethodPost, "/v2/products", p2.Create, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeProductsWrite))
+	app.Handle(http.MethodGet, "/v2/products/{id}", p2.Retrieve, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeProductsRead))
+	app.Handle(http.MethodPut, "/v2/products/{id}", p2.Update, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeProductsWrite))
+	app.Handle(http.MethodDelete, "/v2/products/{id}", p2.Delete, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeProductsWrite), mid.HasRole(auth.RoleAdmin))
+
+	s := Sales{
+		DB:      cfg.DB,
+		TaxRate: cfg.TaxRate,
+		Receipts: map[string]receipt.Renderer{
+			"html": receipt.HTML{Templates: cfg.Templates},
+			"pdf":  receipt.PDF{},
+		},
+		Page:    keyset(cfg.Pages.Sales),
+		Cursors: cfg.Pages.Cursors,
+	}
+	app.Handle(http.MethodGet, "/v1/sales", s.List, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeSalesRead))
+	app.HandleStream(http.MethodGet, "/v1/sales/export", s.Export, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeSalesRead))
+	app.Handle(http.MethodGet, "/v1/sales/{id}/receipt", s.Receipt, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeSalesRead))
+
+	// Exports are produced in the background when a runner is provided. The
+	// download is authorized by its signed URL.
+	if cfg.Exports != nil {
+		ex := Exports{
+			DB:     cfg.DB,
+			Runner: cfg.Exports,
+			Sales:  &s,
+		}
+		ex.register()
+		app.Handle(http.MethodPost, "/v1/exports", ex.Start, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeReportsRead))
+		app.Handle(http.MethodGet, "/v1/exports/{id}", ex.Retrieve, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeReportsRead))
+		app.HandleStream(http.MethodGet, "/v1/exports/{id}/download", ex.Download)
+	}
+
+	// Payments are only taken when a provider is configured.
+	if cfg.Payments != nil {
+		pay := Payments{DB: cfg.DB, Log: cfg.Log, Provider: cfg.Payments, Tenants: cfg.Tenants, Products: &p, Risk: cfg.Risk, RestockChargebacks: cfg.RestockChargebacks}
+		app.Handle(http.MethodPost, "/v1/payments/checkout", pay.Checkout, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeSalesWrite))
+		app.Handle(http.MethodPost, "/v1/webhooks/stripe", pay.Webhook)
+	}
+
+	cp := Coupons{DB: cfg.DB}
+	app.Handle(http.MethodGet, "/v1/coupons", cp.List, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeCouponsRead))
+	app.Handle(http.MethodPost, "/v1/coupons", cp.Create, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeCouponsWrite))
+	app.Handle(http.MethodGet, "/v1/coupons/{id}", cp.Retrieve, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeCouponsRead))
+	app.Handle(http.MethodPut, "/v1/coupons/{id}", cp.Update, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeCouponsWrite))
+	app.Handle(http.MethodDelete, "/v1/coupons/{id}", cp.Delete, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeCouponsWrite))
+
+	rp := Report{
+		DB:      cfg.DB,
+		Rates:   cfg.Rates,
+		Tenants: cfg.Tenants,
+		Jobs:    cfg.Reports,
+	}
+	app.Handle(http.MethodGet, "/v1/reports/revenue", rp.Revenue, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeReportsRead))
+	app.Handle(http.MethodGet, "/v1/reports/top-products", rp.TopProducts, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeReportsRead))
+	if cfg.Reports != nil {
+		app.Handle(http.MethodPost, "/v1/reports/revenue/jobs", rp.StartRevenue, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeReportsRead))
+		app.Handle(http.MethodGet, "/v1/reports/jobs/{id}", rp.Job, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeReportsRead))
+	}
+
+	e := Event{DB: cfg.DB, Signals: &signals}
+	app.Handle(http.MethodGet, "/v1/public/events", e.List, cfg.Bots)
+	app.Handle(http.MethodGet, "/v1/public/events/feed.ics", e.Feed, cfg.Bots)
+	app.Handle(http.MethodGet, "/v1/public/sellers/{id}/events/feed.ics", e.SellerFeed, cfg.Bots)
+	app.Handle(http.MethodGet, "/v1/public/events/{id}", e.Retrieve, cfg.Bots)
+	app.Handle(http.MethodGet, "/v1/public/events/{id}/products", e.ListProducts, cfg.Bots)
+	app.Handle(http.MethodGet, "/v1/public/events/{id}/calendar.ics", e.Calendar, cfg.Bots)
+	app.Handle(http.MethodPost, "/v1/events", e.Create, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeEventsWrite))
+	app.Handle(http.MethodPost, "/v1/events/{id}/products", e.AddProduct, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeEventsWrite))
+	app.Handle(http.MethodDelete, "/v1/events/{id}/products/{productID}", e.RemoveProduct, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeEventsWrite))
+
+	a := Admin{DB: cfg.DB, Cache: cache.New(time.Minute), FlagsPage: cfg.Pages.ContentFlags}
+	app.Handle(http.MethodGet, "/v1/admin/stats", a.Stats, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeAdmin), mid.HasRole(auth.RoleAdmin), mid.Untenanted())
+	app.Handle(http.MethodGet, "/v1/admin/content-flags", a.ContentFlags, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeAdmin), mid.HasRole(auth.RoleAdmin), mid.Untenanted())
+	app.Handle(http.MethodGet, "/v1/admin/infected-uploads", a.InfectedUploads, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeAdmin), mid.HasRole(auth.RoleAdmin), mid.Untenanted())
+	app.Handle(http.MethodGet, "/v1/admin/fraud-reviews", a.FraudReviews, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeAdmin), mid.HasRole(auth.RoleAdmin), mid.Untenanted())
+	app.Handle(http.MethodPost, "/v1/admin/fraud-reviews/{id}", a.ReviewFraud, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeAdmin), mid.HasRole(auth.RoleAdmin), mid.Untenanted())
+
+	cl := Clients{DB: cfg.DB, authenticator: cfg.Authenticator}
+	app.Handle(http.MethodPost, "/v1/oauth/token", cl.Token)
+	app.Handle(http.MethodGet, "/v1/admin/clients", cl.List, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeAdmin), mid.HasRole(auth.RoleAdmin), mid.Untenanted())
+	app.Handle(http.MethodPost, "/v1/admin/clients", cl.Register, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeAdmin), mid.HasRole(auth.RoleAdmin), mid.Untenanted())
+	app.Handle(http.MethodDelete, "/v1/admin/clients/{id}", cl.Delete, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeAdmin), mid.HasRole(auth.RoleAdmin), mid.Untenanted())
+
+	tn := Tenants{DB: cfg.DB, Log: cfg.Log, Users: &u, Tenants: cfg.Tenants}
+	app.Handle(http.MethodPost, "/v1/admin/tenants", tn.Provision, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeAdmin), mid.HasRole(auth.RoleAdmin), mid.Untenanted())
+	app.Handle(http.MethodGet, "/v1/admin/tenants/{id}/settings", tn.Settings, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeAdmin), mid.HasRole(auth.RoleAdmin))
+	app.Handle(http.MethodPut, "/v1/admin/tenants/{id}/settings", tn.UpdateSettings, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeAdmin), mid.HasRole(auth.RoleAdmin))
+	app.Handle(http.MethodGet, "/v1/admin/tenants/{id}/usage", tn.Usage, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeAdmin), mid.HasRole(auth.RoleAdmin))
+	app.HandleStream(http.MethodGet, "/v1/admin/tenants/usage/export", tn.UsageExport, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeAdmin), mid.HasRole(auth.RoleAdmin), mid.Untenanted())
+
+	t := Templates{Store: cfg.Templates}
+	app.Handle(http.MethodGet, "/v1/admin/templates", t.List, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeAdmin), mid.HasRole(auth.RoleAdmin))
+	app.Handle(http.MethodGet, "/v1/admin/templates/{name}", t.Retrieve, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeAdmin), mid.HasRole(auth.RoleAdmin))
+	app.Handle(http.MethodPut, "/v1/admin/templates/{name}", t.Override, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeAdmin), mid.HasRole(auth.RoleAdmin))
+	app.Handle(http.MethodDelete, "/v1/admin/templates/{name}", t.Reset, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeAdmin), mid.HasRole(auth.RoleAdmin))
+	app.Handle(http.MethodPost, "/v1/admin/templates/{name}/preview", t.Preview, mid.Authenticate(cfg.Authenticator), mid.HasScope(auth.ScopeAdmin), mid.HasRole(auth.RoleAdmin))
 
 	return app
 }
+
+// keyset marks the page size of a listing paginated by keyset so it accepts
+// cursors.
+func keyset(size web.PageSize) web.PageSize {
+	size.Keyset = true
+	return size
+}
+
+// settings puts the Credentials, Policy and SummedSales of cfg in the context
+// of each request for the packages reading them there.
+func settings(cfg Config) web.Middleware {
+	return func(after web.Handler) web.Handler {
+		return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			if cfg.Credentials != nil {
+				ctx = user.WithCredentials(ctx, *cfg.Credentials)
+			}
+			if cfg.Policy != nil {
+				ctx = auth.WithPolicy(ctx, cfg.Policy)
+			}
+			if cfg.SummedSales {
+				ctx = product.WithSummedSales(ctx)
+			}
+			return after(ctx, w, r)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/arammikayelyan/garagesale/internal/platform/web"
+	"github.com/arammikayelyan/garagesale/internal/product"
+	"github.com/arammikayelyan/garagesale/internal/receipt"
+	"github.com/go-chi/chi"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// Sales has handler methods for dealing with sales across products.
+type Sales struct {
+	DB *sqlx.DB
+
+	// TaxRate is the sales tax in percent included in the amounts paid. It
+	// is used to break the tax out in exports.
+	TaxRate float64
+
+	// Receipts maps a format name such as "pdf" to the renderer producing it.
+	// The first format listed in receiptFormats that exists is the default.
+	Receipts map[string]receipt.Renderer
+
+	Page web.PageSize
+
+	// Cursors reads and makes the cursors of the sales listing.
+	Cursors *web.Cursors
+}
+
+// receiptFormats lists the receipt formats in order of preference along with
+// the media type a client asks for them with.
+var receiptFormats = []struct {
+	name      string
+	mediaType string
+}{
+	{"html", "text/html"},
+	{"pdf", "application/pdf"},
+}
+
+// List returns a page of sales across all products along with the product
+// names. Admins see every sale while sellers only see sales of their own
+// products. The from, to, after, limit, offset and cursor query parameters
+// are supported, the cursor of the next page being in X-Next-Cursor.
+func (s *Sales) List(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.sale.List")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	filter, err := parseSaleFilter(r)
+	if err != nil {
+		return web.NewRequestError(err, http.StatusBadRequest)
+	}
+
+	page, err := web.ParsePage(r, s.Page)
+	if err != nil {
+		return err
+	}
+	filter.Limit = page.Limit
+	filter.Offset = page.Offset
+	if page.Cursor != "" {
+		filter.Past = new(product.Key)
+		if err := s.Cursors.Decode(listingSales, page.Cursor, filter.Past); err != nil {
+			return err
+		}
+	}
+
+	var sellerID string
+	if !claims.HasRole(auth.RoleAdmin) {
+		sellerID = claims.Subject
+	}
+
+	list, err := product.ListAllSales(ctx, s.DB, sellerID, filter)
+	if err != nil {
+		if err == product.ErrSaleNotFound {
+			return web.NewRequestError(errUnknownAfter, http.StatusBadRequest)
+		}
+		return errors.Wrap(err, "listing sales")
+	}
+
+	if len(list) == page.Limit {
+		last := list[len(list)-1]
+		if err := nextCursor(w, s.Cursors, listingSales, product.Key{Date: last.DateCreated, ID: last.ID}); err != nil {
+			return err
+		}
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// exportColumns is the header row of the sales export.
+var exportColumns = []string{
+	"sale_id", "date", "product_id", "product_name", "quantity",
+	"discount", "paid", "tax", "net", "status", "buyer_name", "buyer_email",
+}
+
+// Export streams the sales as a CSV document for accounting. Admins get every
+// sale while sellers only get sales of their own products. The from, to and
+// status query parameters are supported; only paid sales are exported unless
+// a status is provided.
+//
+// Sales are exported in a stable order so an export which was cut short can
+// be resumed by repeating the request with the sale_id of the last row
+// received in the after query parameter. The header row is left out then so
+// the parts join into one document.
+func (s *Sales) Export(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.sale.Export")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	filter, err := parseSaleFilter(r)
+	if err != nil {
+		return web.NewRequestError(err, http.StatusBadRequest)
+	}
+	if filter.Status == "" {
+		filter.Status = product.SalePaid
+	}
+
+	var sellerID string
+	if !claims.HasRole(auth.RoleAdmin) {
+		sellerID = claims.Subject
+	}
+
+	filename := "sales-" + web.Now(ctx).UTC().Format("20060102") + ".csv"
+	write := func(out io.Writer) error {
+		_, err := s.writeCSV(ctx, out, sellerID, filter, filter.After == "")
+		if err == product.ErrSaleNotFound {
+			return web.NewRequestError(errUnknownAfter, http.StatusBadRequest)
+		}
+		return err
+	}
+
+	return web.RespondStream(ctx, w, "text/csv; charset=utf-8", filename, http.StatusOK, write)
+}
+
+// writeCSV writes the sales matching filter to out in the export format,
+// starting with the header row when header is set. It returns the number of
+// sales written.
+func (s *Sales) writeCSV(ctx context.Context, out io.Writer, sellerID string, filter product.SaleFilter, header bool) (int, error) {
+	cw := csv.NewWriter(out)
+	if header {
+		cw.Write(exportColumns)
+	}
+
+	var n int
+	err := product.StreamAllSales(ctx, s.DB, sellerID, filter, func(sd product.SaleDetail) error {
+		n++
+		tax := int(math.Round(float64(sd.Paid) * s.TaxRate / (100 + s.TaxRate)))
+		return cw.Write([]string{
+			sd.ID,
+			sd.DateCreated.UTC().Format(time.RFC3339),
+			sd.ProductID,
+			sd.ProductName,
+			strconv.Itoa(sd.Quantity),
+			strconv.Itoa(sd.Discount),
+			strconv.Itoa(sd.Paid),
+			strconv.Itoa(tax),
+			strconv.Itoa(sd.Paid - tax),
+			string(sd.Status),
+			deref(sd.BuyerName),
+			deref(sd.BuyerEmail),
+		})
+	})
+	if err != nil {
+		if err == product.ErrSaleNotFound {
+			return n, err
+		}
+		return n, errors.Wrap(err, "exporting sales")
+	}
+
+	cw.Flush()
+	return n, cw.Error()
+}
+
+// deref returns the value of an optional string or an empty string.
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// Receipt renders the receipt of a sale. The format query parameter selects
+// the format, otherwise it is negotiated with the Accept header.
+func (s *Sales) Receipt(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.sale.Receipt")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	renderer, err := s.receiptRenderer(r)
+	if err != nil {
+		return err
+	}
+
+	id := chi.URLParam(r, "id")
+
+	rec, err := receipt.Retrieve(ctx, s.DB, claims, id)
+	if err != nil {
+		switch err {
+		case receipt.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case receipt.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		case receipt.ErrForbidden:
+			return web.NewRequestError(err, http.StatusForbidden)
+		default:
+			return errors.Wrapf(err, "receipt for sale %q", id)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := renderer.Render(ctx, &buf, *rec); err != nil {
+		return errors.Wrapf(err, "rendering receipt for sale %q", id)
+	}
+
+	return web.RespondRaw(ctx, w, buf.Bytes(), renderer.ContentType(), http.StatusOK)
+}
+
+// receiptRenderer picks the renderer for the format requested by the client.
+func (s *Sales) receiptRenderer(r *http.Request) (receipt.Renderer, error) {
+	if format := r.URL.Query().Get("format"); format != "" {
+		renderer, ok := s.Receipts[format]
+		if !ok {
+			return nil, web.NewRequestError(errors.Errorf("unsupported receipt format %q", format), http.StatusBadRequest)
+		}
+		return renderer, nil
+	}
+
+	accept := r.Header.Get("Accept")
+	for _, f := range receiptFormats {
+		if renderer, ok := s.Receipts[f.name]; ok && strings.Contains(accept, f.mediaType) {
+			return renderer, nil
+		}
+	}
+	for _, f := range receiptFormats {
+		if renderer, ok := s.Receipts[f.name]; ok {
+			return renderer, nil
+		}
+	}
+
+	return nil, web.NewShutdownError("no receipt renderers configured")
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/arammikayelyan/garagesale/internal/platform/cache"
+	"github.com/arammikayelyan/garagesale/internal/platform/web"
+	"github.com/arammikayelyan/garagesale/internal/product"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// maxCompletions is how many completions Suggest returns.
+const maxCompletions = 10
+
+// Suggestions configures the typeahead of search boxes. Completions are
+// cached for CacheTTL on the server and clients. Each address may ask for
+// RateLimit completions per Window.
+type Suggestions struct {
+	CacheTTL  time.Duration
+	RateLimit int
+	Window    time.Duration
+}
+
+// Search has the handlers of searching across the store.
+type Search struct {
+	DB       *sqlx.DB
+	Cache    *cache.Cache
+	CacheTTL time.Duration
+}
+
+// Suggest completes the search text in the q query parameter with product
+// names and categories as it is typed.
+func (s *Search) Suggest(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.search.Suggest")
+	defer span.End()
+
+	text := strings.ToLower(strings.Join(strings.Fields(r.URL.Query().Get("q")), " "))
+
+	key := tenantKey(ctx, text)
+
+	var list []product.Completion
+	if v, ok := s.Cache.Get(key); ok {
+		list = v.([]product.Completion)
+	} else {
+		var err error
+		list, err = product.Complete(ctx, s.DB, text, maxCompletions)
+		if err != nil {
+			return errors.Wrap(err, "completing search")
+		}
+		s.Cache.Set(key, list)
+	}
+
+	w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(s.CacheTTL.Seconds())))
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/arammikayelyan/garagesale/internal/platform/web"
+	"github.com/arammikayelyan/garagesale/internal/user"
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// Sessions lists the devices the caller is signed in on.
+func (u *Users) Sessions(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.user.Sessions")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	list, err := user.Sessions(ctx, u.DB, claims.Subject, claims.SessionID, web.Now(ctx))
+	if err != nil {
+		return errors.Wrap(err, "listing sessions")
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// RevokeSession signs the caller out of the session identified by an ID in
+// the request URL.
+func (u *Users) RevokeSession(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.user.RevokeSession")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	id := chi.URLParam(r, "id")
+
+	if err := user.RevokeSession(ctx, u.DB, u.authenticator.Revocations(), claims.Subject, id, web.Now(ctx)); err != nil {
+		switch err {
+		case user.ErrSessionNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		default:
+			return errors.Wrapf(err, "revoking session %q", id)
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// deviceOf describes the client making a request.
+func deviceOf(r *http.Request) user.Device {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return user.Device{UserAgent: r.UserAgent(), IP: ip}
+}
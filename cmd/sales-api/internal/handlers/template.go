@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/arammikayelyan/garagesale/internal/platform/web"
+	"github.com/arammikayelyan/garagesale/internal/templates"
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+)
+
+// Templates has handler methods for admins managing the templates used for
+// emails, messages and documents. Admins of a tenant manage the templates of
+// their tenant and the operators of the platform those of everyone else.
+type Templates struct {
+	Store *templates.Store
+}
+
+// List returns every template along with its override, if any.
+func (t *Templates) List(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	list, err := t.Store.List(ctx)
+	if err != nil {
+		return errors.Wrap(err, "listing templates")
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// Retrieve returns a single template identified by the name in the URL.
+func (t *Templates) Retrieve(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	name := chi.URLParam(r, "name")
+
+	tmpl, err := t.Store.Retrieve(ctx, name)
+	if err != nil {
+		return templateError(err, name)
+	}
+
+	return web.Respond(ctx, w, tmpl, http.StatusOK)
+}
+
+// Override replaces a template with the source provided in the request body.
+// The change takes effect without a redeploy.
+func (t *Templates) Override(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	name := chi.URLParam(r, "name")
+
+	var ut templates.UpdateTemplate
+	if err := web.Decode(r, &ut); err != nil {
+		return err
+	}
+
+	if err := t.Store.Override(ctx, name, ut, web.Now(ctx)); err != nil {
+		return templateError(err, name)
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// Reset removes the override of a template restoring its default.
+func (t *Templates) Reset(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	name := chi.URLParam(r, "name")
+
+	if err := t.Store.Reset(ctx, name); err != nil {
+		return templateError(err, name)
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// Preview renders a template with the data provided in the request body. A
+// source may be provided to try out changes before saving them.
+func (t *Templates) Preview(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	name := chi.URLParam(r, "name")
+
+	var p templates.Preview
+	if err := web.Decode(r, &p); err != nil {
+		return err
+	}
+
+	out, err := t.Store.Preview(ctx, name, p)
+	if err != nil {
+		return templateError(err, name)
+	}
+
+	contentType := "text/plain; charset=utf-8"
+	if strings.HasSuffix(name, ".html") {
+		contentType = "text/html; charset=utf-8"
+	}
+
+	return web.RespondRaw(ctx, w, out, contentType, http.StatusOK)
+}
+
+// templateError translates the known errors of the templates package to
+// request errors with a matching status code.
+func templateError(err error, name string) error {
+	switch errors.Cause(err) {
+	case templates.ErrNotFound:
+		return web.NewRequestError(err, http.StatusNotFound)
+	case templates.ErrInvalid:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	default:
+		return errors.Wrapf(err, "template %q", name)
+	}
+}
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/arammikayelyan/garagesale/internal/mid"
+	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/arammikayelyan/garagesale/internal/platform/web"
+	"github.com/arammikayelyan/garagesale/internal/tenant"
+	"github.com/arammikayelyan/garagesale/internal/usage"
+	"github.com/arammikayelyan/garagesale/internal/user"
+	"github.com/go-chi/chi"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// Tenants has handlers for provisioning tenants and billing their usage.
+type Tenants struct {
+	DB      *sqlx.DB
+	Log     *log.Logger
+	Users   *Users
+	Tenants *tenant.Config
+}
+
+// Provision creates a tenant with its categories, first admin and templates.
+// The admin is sent a link to verify their email address.
+func (t *Tenants) Provision(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.tenant.Provision")
+	defer span.End()
+
+	var nt tenant.NewTenant
+	if err := web.Decode(r, &nt); err != nil {
+		return errors.Wrap(err, "decoding new tenant")
+	}
+
+	p, err := tenant.Provision(ctx, t.DB, nt, web.Now(ctx))
+	if err != nil {
+		if weak, ok := err.(*user.WeakPasswordError); ok {
+			return passwordError("admin.password", weak)
+		}
+		switch err {
+		case tenant.ErrInvalidSlug, tenant.ErrSlugTaken:
+			return fieldError("slug", err)
+		case user.ErrEmailTaken:
+			return fieldError("admin.email", err)
+		default:
+			return errors.Wrap(err, "provisioning tenant")
+		}
+	}
+
+	if err := t.Users.sendVerification(ctx, &p.Admin); err != nil {
+		t.Log.Printf("sending verification to user %s : %v", p.Admin.ID, err)
+	}
+
+	return web.Respond(ctx, w, p, http.StatusCreated)
+}
+
+// Settings returns the settings of the tenant in the request URL with its
+// overrides applied. Admins of a tenant may only read those of their own.
+func (t *Tenants) Settings(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.tenant.Settings")
+	defer span.End()
+
+	id := chi.URLParam(r, "id")
+	if err := ownTenant(ctx, id); err != nil {
+		return err
+	}
+
+	s, err := t.Tenants.For(ctx, id)
+	if err != nil {
+		switch err {
+		case tenant.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		default:
+			return errors.Wrapf(err, "reading settings of tenant %q", id)
+		}
+	}
+
+	return web.Respond(ctx, w, s, http.StatusOK)
+}
+
+// UpdateSettings overrides settings of the tenant in the request URL. Admins
+// of a tenant may only change those of their own.
+func (t *Tenants) UpdateSettings(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.tenant.UpdateSettings")
+	defer span.End()
+
+	id := chi.URLParam(r, "id")
+	if err := ownTenant(ctx, id); err != nil {
+		return err
+	}
+
+	var us tenant.UpdateSettings
+	if err := web.Decode(r, &us); err != nil {
+		return errors.Wrap(err, "decoding tenant settings")
+	}
+
+	s, err := t.Tenants.Update(ctx, id, us, web.Now(ctx))
+	if err != nil {
+		switch err {
+		case tenant.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		default:
+			return errors.Wrapf(err, "updating settings of tenant %q", id)
+		}
+	}
+
+	return web.Respond(ctx, w, s, http.StatusOK)
+}
+
+// Usage returns the daily usage of the tenant in the request URL. The
+// optional from and to query parameters limit the days returned. Admins of a
+// tenant may only read the usage of their own.
+func (t *Tenants) Usage(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.tenant.Usage")
+	defer span.End()
+
+	id := chi.URLParam(r, "id")
+	if err := ownTenant(ctx, id); err != nil {
+		return err
+	}
+
+	from, to, err := parseRange(r)
+	if err != nil {
+		return web.NewRequestError(err, http.StatusBadRequest)
+	}
+
+	list, err := usage.List(ctx, t.DB, id, from, to)
+	if err != nil {
+		return errors.Wrapf(err, "listing usage of tenant %q", id)
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// ownTenant fails unless the caller may manage the tenant id: operators of
+// the platform may manage any tenant and admins of a tenant only their own.
+func ownTenant(ctx context.Context, id string) error {
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+	if claims.TenantID != "" && claims.TenantID != id {
+		return mid.ErrForbidden
+	}
+	return nil
+}
+
+// invoiceColumns is the header of the usage export.
+var invoiceColumns = []string{
+	"tenant_id", "tenant_name", "month", "api_calls", "storage_bytes", "gmv", "currency",
+}
+
+// UsageExport streams the usage of every tenant over the month in the month
+// query parameter, formatted YYYY-MM, as CSV to invoice them from. It
+// defaults to the previous month.
+func (t *Tenants) UsageExport(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.tenant.UsageExport")
+	defer span.End()
+
+	now := web.Now(ctx).UTC()
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -1, 0)
+	if v := r.URL.Query().Get("month"); v != "" {
+		m, err := time.Parse("2006-01", v)
+		if err != nil {
+			return web.NewRequestError(errors.New("month must be formatted YYYY-MM"), http.StatusBadRequest)
+		}
+		month = m
+	}
+
+	lines, err := usage.Invoice(ctx, t.DB, month)
+	if err != nil {
+		return errors.Wrap(err, "reading tenant usage")
+	}
+
+	filename := "usage-" + month.Format("2006-01") + ".csv"
+	write := func(out io.Writer) error {
+		cw := csv.NewWriter(out)
+		cw.Write(invoiceColumns)
+		for _, l := range lines {
+			cw.Write([]string{
+				l.TenantID,
+				l.TenantName,
+				month.Format("2006-01"),
+				strconv.FormatInt(l.APICalls, 10),
+				strconv.FormatInt(l.StorageBytes, 10),
+				strconv.FormatInt(l.GMV, 10),
+				l.Currency,
+			})
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+
+	return web.RespondStream(ctx, w, "text/csv; charset=utf-8", filename, http.StatusOK, write)
+}
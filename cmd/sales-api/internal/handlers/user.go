@@ -1,25 +1,72 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
+	"github.com/arammikayelyan/garagesale/internal/fix"
 	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/arammikayelyan/garagesale/internal/platform/mail"
+	"github.com/arammikayelyan/garagesale/internal/platform/oidc"
 	"github.com/arammikayelyan/garagesale/internal/platform/web"
+	"github.com/arammikayelyan/garagesale/internal/upload"
 	"github.com/arammikayelyan/garagesale/internal/user"
+	"github.com/go-chi/chi"
 	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
 	"go.opencensus.io/trace"
 )
 
+// AccountMail configures the emails sent to users about their account. Links
+// point to ResetURL and VerifyURL with the token appended as the token query
+// parameter and stay valid for ResetTTL and VerifyTTL.
+type AccountMail struct {
+	Mailer    mail.Mailer
+	ResetURL  string
+	ResetTTL  time.Duration
+	VerifyURL string
+	VerifyTTL time.Duration
+}
+
+// link gives the URL a token is sent to users in.
+func link(base, token string) string {
+	return base + "?" + url.Values{"token": {token}}.Encode()
+}
+
 type Users struct {
-	DB            *sqlx.DB
+	DB      *sqlx.DB
+	Log     *log.Logger
+	Mail    AccountMail
+	Lockout user.Lockout
+
+	// OIDC is the OpenID Connect provider users may sign in with. It is
+	// optional.
+	OIDC *oidc.Provider
+
+	Page web.PageSize
+
+	// Cursors reads and makes the cursors of the user listing.
+	Cursors *web.Cursors
+
+	// Uploads scans imported files for malware.
+	Uploads *upload.Screen
+
 	authenticator *auth.Authenticator
 }
 
 // Token generates an authentication token for a user. The client must include
 // an email and password for the request using HTTP Basic Auth. The user will
-// be identified by email and authenticated by their password.
+// be identified by email and authenticated by their password. Users with
+// two-factor authentication enabled also send a TOTP or recovery code in the
+// X-MFA-Code header. The scope query parameter restricts the token to a space
+// separated list of scopes, such as for handing it to another service.
 func (u *Users) Token(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 
 	ctx, span := trace.StartSpan(ctx, "handlers.user.token")
@@ -35,24 +82,743 @@ func (u *Users) Token(ctx context.Context, w http.ResponseWriter, r *http.Reques
 		return web.NewRequestError(err, http.StatusUnauthorized)
 	}
 
-	claims, err := user.Authenticate(ctx, u.DB, v.Start, email, pass)
+	scopes, err := auth.ParseScopes(r.URL.Query().Get("scope"))
+	if err != nil {
+		return fieldError("scope", err)
+	}
+
+	claims, err := user.Authenticate(ctx, u.DB, v.Start, u.authenticator.AccessTTL(), u.Lockout, email, pass, r.Header.Get(mfaHeader))
 	if err != nil {
 		switch err {
-		case user.ErrAuthenticationFailure:
+		case user.ErrAuthenticationFailure, user.ErrMFARequired, user.ErrInvalidMFACode:
 			return web.NewRequestError(err, http.StatusUnauthorized)
+		case user.ErrAccountLocked:
+			return web.NewRequestError(err, http.StatusLocked)
+		case user.ErrAccountDeactivated:
+			return web.NewRequestError(err, http.StatusForbidden)
 
 		default:
 			return errors.Wrap(err, "authenticating")
 		}
 	}
 
+	// Refreshing would give back an unrestricted token so scoped tokens
+	// come without a refresh token.
+	if len(scopes) > 0 {
+		claims.Scopes = scopes
+		return u.respondToken(ctx, w, claims, "")
+	}
+
+	refresh, err := user.IssueRefresh(ctx, u.DB, &claims, deviceOf(r), u.authenticator.RefreshTTL(), v.Start)
+	if err != nil {
+		return errors.Wrap(err, "issuing refresh token")
+	}
+
+	return u.respondToken(ctx, w, claims, refresh)
+}
+
+// Refresh exchanges a refresh token for a new access token. The refresh token
+// is rotated: the response holds a new one and the old one is spent.
+func (u *Users) Refresh(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.user.Refresh")
+	defer span.End()
+
+	var rr user.RefreshRequest
+	if err := web.Decode(r, &rr); err != nil {
+		return errors.Wrap(err, "decoding refresh request")
+	}
+
+	claims, refresh, err := user.Refresh(ctx, u.DB, rr.RefreshToken, u.authenticator.AccessTTL(), u.authenticator.RefreshTTL(), web.Now(ctx))
+	if err != nil {
+		switch err {
+		case user.ErrInvalidRefreshToken:
+			return web.NewRequestError(err, http.StatusUnauthorized)
+		default:
+			return errors.Wrap(err, "refreshing token")
+		}
+	}
+
+	return u.respondToken(ctx, w, claims, refresh)
+}
+
+// Logout revokes the token the request was authenticated with so it is
+// rejected from now on even though it has not expired. The session the token
+// belongs to ends too so its refresh token can not mint new ones.
+func (u *Users) Logout(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.user.Logout")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	if err := u.authenticator.Revoke(ctx, claims); err != nil {
+		switch err {
+		case auth.ErrNotRevocable:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrap(err, "revoking token")
+		}
+	}
+
+	if claims.SessionID != "" {
+		if err := user.EndSession(ctx, u.DB, claims.SessionID); err != nil && err != user.ErrSessionNotFound {
+			return errors.Wrapf(err, "ending session %q", claims.SessionID)
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// respondToken sends a signed access token for claims along with a refresh
+// token when there is one.
+func (u *Users) respondToken(ctx context.Context, w http.ResponseWriter, claims auth.Claims, refresh string) error {
 	var tkn struct {
-		Token string `json:"token"`
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token,omitempty"`
+		ExpiresIn    int    `json:"expires_in"`
 	}
+
+	var err error
 	tkn.Token, err = u.authenticator.GenerateToken(claims)
 	if err != nil {
 		return errors.Wrap(err, "generating token")
 	}
+	tkn.RefreshToken = refresh
+	tkn.ExpiresIn = int(u.authenticator.AccessTTL().Seconds())
 
 	return web.Respond(ctx, w, tkn, http.StatusOK)
 }
+
+// List returns a page of users. Support staff narrow it down with q, which
+// matches part of an email or name, and role. When last_login_before is given
+// only the users who have not signed in since then are listed, to find
+// dormant accounts. Full pages have a cursor to the next in the X-Next-Cursor
+// header.
+func (u *Users) List(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.user.List")
+	defer span.End()
+
+	page, err := web.ParsePage(r, u.Page)
+	if err != nil {
+		return err
+	}
+
+	q := r.URL.Query()
+	filter := user.Filter{Search: strings.TrimSpace(q.Get("q"))}
+	if v := q.Get("role"); v != "" {
+		filter.Role, err = auth.ParseRole(v)
+		if err != nil {
+			return web.NewRequestError(err, http.StatusBadRequest)
+		}
+	}
+	if v := q.Get("last_login_before"); v != "" {
+		filter.LastLoginBefore, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return web.NewRequestError(errors.New("last_login_before must be an RFC3339 timestamp"), http.StatusBadRequest)
+		}
+	}
+
+	listing := listingUsers
+	if !filter.LastLoginBefore.IsZero() {
+		listing = listingDormantUsers
+	}
+	if page.Cursor != "" {
+		filter.Past = new(user.Key)
+		if err := u.Cursors.Decode(listing, page.Cursor, filter.Past); err != nil {
+			return err
+		}
+	}
+
+	list, err := user.List(ctx, u.DB, filter, page.Limit, page.Offset)
+	if err != nil {
+		return errors.Wrap(err, "listing users")
+	}
+
+	if len(list) == page.Limit {
+		last := list[len(list)-1]
+		key := user.Key{LastLogin: last.LastLogin, Email: last.Email, ID: last.ID}
+		if err := nextCursor(w, u.Cursors, listing, key); err != nil {
+			return err
+		}
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// Retrieve returns a single user identified by an ID in the request URL.
+func (u *Users) Retrieve(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.user.Retrieve")
+	defer span.End()
+
+	id := chi.URLParam(r, "id")
+
+	usr, err := user.Retrieve(ctx, u.DB, id)
+	if err != nil {
+		return userError(err, id)
+	}
+
+	return web.Respond(ctx, w, usr, http.StatusOK)
+}
+
+// Me returns the user making the request.
+func (u *Users) Me(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.user.Me")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	usr, err := user.Retrieve(ctx, u.DB, claims.Subject)
+	if err != nil {
+		return userError(err, claims.Subject)
+	}
+
+	return web.Respond(ctx, w, usr, http.StatusOK)
+}
+
+// UpdateMe changes the profile of the user making the request.
+func (u *Users) UpdateMe(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.user.UpdateMe")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	var up user.UpdateProfile
+	if err := web.Decode(r, &up); err != nil {
+		return errors.Wrap(err, "decoding profile")
+	}
+
+	usr, err := user.SaveProfile(ctx, u.DB, claims.Subject, up, web.Now(ctx))
+	if err != nil {
+		return userError(err, claims.Subject)
+	}
+
+	return web.Respond(ctx, w, usr, http.StatusOK)
+}
+
+// Create decodes the body of a request to create a new user. The user belongs
+// to the tenant of the caller so admins of a tenant can not create users of
+// the platform.
+func (u *Users) Create(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.user.Create")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	var nu user.NewUser
+	if err := web.Decode(r, &nu); err != nil {
+		return errors.Wrap(err, "decoding new user")
+	}
+	nu.TenantID = claims.TenantID
+
+	usr, err := user.Create(ctx, u.DB, nu, web.Now(ctx))
+	if err != nil {
+		return userError(err, "")
+	}
+
+	if err := u.sendVerification(ctx, usr); err != nil {
+		u.Log.Printf("sending verification to user %s : %v", usr.ID, err)
+	}
+
+	return web.Respond(ctx, w, usr, http.StatusCreated)
+}
+
+// Update decodes the body of a request to update an existing user. The ID of
+// the user is part of the request URL.
+func (u *Users) Update(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.user.Update")
+	defer span.End()
+
+	id := chi.URLParam(r, "id")
+
+	var upd user.UpdateUser
+	if err := web.Decode(r, &upd); err != nil {
+		return errors.Wrap(err, "decoding user update")
+	}
+
+	if err := user.Update(ctx, u.DB, id, upd, web.Now(ctx)); err != nil {
+		return userError(err, id)
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// Delete deactivates a single user identified by an ID in the request URL. The
+// user is kept so their sales and history stay intact; with hide_products=true
+// in the query their products are also left out of listings.
+func (u *Users) Delete(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.user.Delete")
+	defer span.End()
+
+	id := chi.URLParam(r, "id")
+
+	hide := r.URL.Query().Get("hide_products") == "true"
+
+	if err := user.Deactivate(ctx, u.DB, u.authenticator.Revocations(), id, hide, web.Now(ctx)); err != nil {
+		return userError(err, id)
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// Reactivate lets a user deactivated by Delete sign in again.
+func (u *Users) Reactivate(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.user.Reactivate")
+	defer span.End()
+
+	id := chi.URLParam(r, "id")
+
+	if err := user.Reactivate(ctx, u.DB, id, web.Now(ctx)); err != nil {
+		return userError(err, id)
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// ChangePassword replaces the caller's password. The current password must be
+// provided along with the new one. All of the caller's sessions end, so
+// refresh tokens issued for the old password stop working.
+func (u *Users) ChangePassword(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.user.ChangePassword")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	var cp user.ChangePasswordRequest
+	if err := web.Decode(r, &cp); err != nil {
+		return errors.Wrap(err, "decoding password change")
+	}
+
+	if err := user.ChangePassword(ctx, u.DB, u.authenticator.Revocations(), claims.Subject, cp, u.Lockout, web.Now(ctx)); err != nil {
+		if weak, ok := err.(*user.WeakPasswordError); ok {
+			return passwordError("new_password", weak)
+		}
+		switch err {
+		case user.ErrAuthenticationFailure:
+			return fieldError("current_password", errors.New("current password is incorrect"))
+		case user.ErrAccountLocked:
+			return web.NewRequestError(err, http.StatusLocked)
+		default:
+			return userError(err, claims.Subject)
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// maxImportSize is the largest CSV file accepted by Import.
+const maxImportSize = 10 << 20
+
+// importResult reports the outcome of a bulk import.
+type importResult struct {
+	Created []importedUser       `json:"created"`
+	Failed  []user.ImportFailure `json:"failed"`
+}
+
+// importedUser is a user created by an import. Emailed is false when the
+// welcome email could not be sent; the user can still reset their password.
+type importedUser struct {
+	ID      string `json:"id"`
+	Email   string `json:"email"`
+	Emailed bool   `json:"emailed"`
+}
+
+// Import creates users in bulk from a CSV body with name, email and optional
+// roles columns. Every user gets a temporary password sent in a welcome email.
+// The file is scanned for malware first. Users belong to the tenant of the
+// caller.
+func (u *Users) Import(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.user.Import")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxImportSize))
+	if err != nil {
+		err := fmt.Errorf("import must be at most %d bytes", maxImportSize)
+		return web.NewRequestError(err, http.StatusRequestEntityTooLarge)
+	}
+
+	if err := u.Uploads.Check(ctx, u.DB, "user_import", claims.Subject, data, web.Now(ctx)); err != nil {
+		switch err {
+		case upload.ErrInfected:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return err
+		}
+	}
+
+	rows, failed, err := user.ParseImport(bytes.NewReader(data))
+	if err != nil {
+		return web.NewRequestError(err, http.StatusBadRequest)
+	}
+
+	created, failedRows, err := user.Import(ctx, u.DB, claims.TenantID, rows, web.Now(ctx))
+	failed = append(failed, failedRows...)
+	if err != nil {
+		return errors.Wrap(err, "importing users")
+	}
+
+	res := importResult{Failed: failed}
+	for _, imp := range created {
+		iu := importedUser{ID: imp.User.ID, Email: imp.User.Email}
+		token, err := user.RequestVerification(ctx, u.DB, &imp.User, u.Mail.VerifyTTL, web.Now(ctx))
+		if err == nil {
+			err = u.Mail.Mailer.Send(ctx, user.Welcome(imp, link(u.Mail.VerifyURL, token)))
+		}
+		if err != nil {
+			u.Log.Printf("welcoming user %s : %v", imp.User.ID, err)
+		}
+		iu.Emailed = err == nil
+		res.Created = append(res.Created, iu)
+	}
+
+	return web.Respond(ctx, w, res, http.StatusOK)
+}
+
+// ForgotPassword emails a password reset link to the address in the request.
+// The response is the same whether or not a user has the address so it can not
+// be used to find out who has an account. Even its timing gives nothing away
+// as the link is issued and sent after responding.
+func (u *Users) ForgotPassword(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.user.ForgotPassword")
+	defer span.End()
+
+	var fp user.ForgotPassword
+	if err := web.Decode(r, &fp); err != nil {
+		return errors.Wrap(err, "decoding forgotten password")
+	}
+
+	go u.sendReset(fp.Email, web.Now(ctx))
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// resetTimeout bounds issuing and sending a password reset link once the
+// request asking for it is answered.
+const resetTimeout = time.Minute
+
+// sendReset issues a password reset link for the user with email and mails it
+// to them. Failures are only logged as the client was answered already.
+func (u *Users) sendReset(email string, now time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), resetTimeout)
+	defer cancel()
+
+	usr, token, err := user.RequestReset(ctx, u.DB, email, u.Mail.ResetTTL, now)
+	if err != nil {
+		if err != user.ErrNotFound {
+			u.Log.Printf("requesting password reset : %v", err)
+		}
+		return
+	}
+
+	m := mail.Message{
+		To:      usr.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nFollow this link within %v to choose a new password:\n\n%s\n\nIf you did not ask to reset your password you can ignore this email.\n",
+			usr.Name, u.Mail.ResetTTL, link(u.Mail.ResetURL, token)),
+	}
+	if err := u.Mail.Mailer.Send(ctx, m); err != nil {
+		u.Log.Printf("sending password reset to user %s : %v", usr.ID, err)
+	}
+}
+
+// ResetPassword sets a new password using a token sent by ForgotPassword.
+func (u *Users) ResetPassword(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.user.ResetPassword")
+	defer span.End()
+
+	var pr user.PasswordReset
+	if err := web.Decode(r, &pr); err != nil {
+		return errors.Wrap(err, "decoding password reset")
+	}
+
+	if err := user.ResetPassword(ctx, u.DB, u.authenticator.Revocations(), pr, web.Now(ctx)); err != nil {
+		if weak, ok := err.(*user.WeakPasswordError); ok {
+			return passwordError("new_password", weak)
+		}
+		switch err {
+		case user.ErrInvalidResetToken:
+			return fieldError("token", err)
+		default:
+			return errors.Wrap(err, "resetting password")
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// Verify marks the email address of a user as verified using a token sent by
+// email when their account was created.
+func (u *Users) Verify(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.user.Verify")
+	defer span.End()
+
+	var ve user.Verification
+	if err := web.Decode(r, &ve); err != nil {
+		return errors.Wrap(err, "decoding verification")
+	}
+
+	if err := user.Verify(ctx, u.DB, ve.Token, web.Now(ctx)); err != nil {
+		switch err {
+		case user.ErrInvalidVerifyToken:
+			return fieldError("token", err)
+		default:
+			return errors.Wrap(err, "verifying email")
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// ResendVerification emails a new verification link to the address in the
+// request. Like ForgotPassword it answers the same way for every address.
+func (u *Users) ResendVerification(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.user.ResendVerification")
+	defer span.End()
+
+	var fp user.ForgotPassword
+	if err := web.Decode(r, &fp); err != nil {
+		return errors.Wrap(err, "decoding verification request")
+	}
+
+	usr, err := user.RetrieveByEmail(ctx, u.DB, fp.Email)
+	if err != nil {
+		if err == user.ErrNotFound {
+			return web.Respond(ctx, w, nil, http.StatusNoContent)
+		}
+		return err
+	}
+
+	if err := u.sendVerification(ctx, usr); err != nil && err != user.ErrAlreadyVerified {
+		return err
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// sendVerification emails a link verifying the address of usr.
+func (u *Users) sendVerification(ctx context.Context, usr *user.User) error {
+	token, err := user.RequestVerification(ctx, u.DB, usr, u.Mail.VerifyTTL, web.Now(ctx))
+	if err != nil {
+		return err
+	}
+
+	m := mail.Message{
+		To:      usr.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nFollow this link within %v to verify your email address and activate your account:\n\n%s\n",
+			usr.Name, u.Mail.VerifyTTL, link(u.Mail.VerifyURL, token)),
+	}
+	if err := u.Mail.Mailer.Send(ctx, m); err != nil {
+		return errors.Wrap(err, "sending verification")
+	}
+
+	return nil
+}
+
+// SetRoles replaces the roles of the user in the request URL. The change is
+// recorded in the audit trail along with the admin who made it. Tokens already
+// issued keep their roles until they expire.
+func (u *Users) SetRoles(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.user.SetRoles")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	id := chi.URLParam(r, "id")
+
+	var ur user.UpdateRoles
+	if err := web.Decode(r, &ur); err != nil {
+		return errors.Wrap(err, "decoding roles")
+	}
+
+	rc, err := user.SetRoles(ctx, u.DB, id, ur.Roles, claims.Subject, web.Now(ctx))
+	if err != nil {
+		switch err {
+		case user.ErrLastAdmin:
+			return fieldError("roles", err)
+		default:
+			return userError(err, id)
+		}
+	}
+
+	u.Log.Printf("audit : user %s changed roles of user %s from %v to %v", rc.ChangedBy, rc.UserID, rc.OldRoles, rc.NewRoles)
+
+	return web.Respond(ctx, w, rc, http.StatusOK)
+}
+
+// Merge merges the user in the request URL into the one given in the body,
+// moving what they sell, bought and watch and deactivating them. Everything
+// happens in one transaction which is audited as a data fix. A dry run
+// reports what would move without keeping any of it.
+func (u *Users) Merge(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.user.Merge")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	id := chi.URLParam(r, "id")
+
+	var nm user.NewMerge
+	if err := web.Decode(r, &nm); err != nil {
+		return errors.Wrap(err, "decoding merge")
+	}
+
+	report, err := fix.Run(ctx, u.DB, fix.MergeUsers(id, nm.IntoID), !nm.DryRun, claims.Subject, web.Now(ctx))
+	if err != nil {
+		switch err {
+		case user.ErrSameUser, user.ErrTenantMismatch:
+			return fieldError("into_id", err)
+		default:
+			return userError(err, id)
+		}
+	}
+
+	if !nm.DryRun {
+		u.Log.Printf("audit : user %s merged user %s into user %s", claims.Subject, id, nm.IntoID)
+	}
+
+	return web.Respond(ctx, w, report, http.StatusOK)
+}
+
+// RoleChanges returns a page of the audit trail of role changes of the user in
+// the request URL.
+func (u *Users) RoleChanges(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.user.RoleChanges")
+	defer span.End()
+
+	id := chi.URLParam(r, "id")
+
+	page, err := web.ParsePage(r, u.Page)
+	if err != nil {
+		return err
+	}
+
+	var past *user.ChangeKey
+	if page.Cursor != "" {
+		past = new(user.ChangeKey)
+		if err := u.Cursors.Decode(listingRoleChanges, page.Cursor, past); err != nil {
+			return err
+		}
+	}
+
+	list, err := user.RoleChanges(ctx, u.DB, id, page.Limit, page.Offset, past)
+	if err != nil {
+		return userError(err, id)
+	}
+
+	if len(list) == page.Limit {
+		last := list[len(list)-1]
+		if err := nextCursor(w, u.Cursors, listingRoleChanges, user.ChangeKey{Date: last.DateCreated, ID: last.ID}); err != nil {
+			return err
+		}
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// impersonationTTL caps how long a token acting as another user is valid.
+const impersonationTTL = 15 * time.Minute
+
+// Impersonate issues the admin making the request a token acting as the user
+// in the request URL, to see what they see. The token names the admin in its
+// act claim, every request made with it is audited and no refresh token is
+// issued for it.
+func (u *Users) Impersonate(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.user.Impersonate")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claim is not in context")
+	}
+
+	id := chi.URLParam(r, "id")
+
+	ttl := impersonationTTL
+	if access := u.authenticator.AccessTTL(); access < ttl {
+		ttl = access
+	}
+
+	as, err := user.Impersonate(ctx, u.DB, claims.Subject, id, ttl, web.Now(ctx))
+	if err != nil {
+		switch err {
+		case user.ErrImpersonateAdmin:
+			return web.NewRequestError(err, http.StatusForbidden)
+		case user.ErrAccountDeactivated:
+			return web.NewRequestError(err, http.StatusConflict)
+		default:
+			return userError(err, id)
+		}
+	}
+
+	var tkn struct {
+		Token     string `json:"token"`
+		ExpiresIn int    `json:"expires_in"`
+	}
+	tkn.Token, err = u.authenticator.GenerateToken(as)
+	if err != nil {
+		return errors.Wrap(err, "generating token")
+	}
+	tkn.ExpiresIn = int(ttl.Seconds())
+
+	u.Log.Printf("audit : user %s started impersonating user %s until %s", claims.Subject, id, time.Unix(as.ExpiresAt, 0).UTC().Format(time.RFC3339))
+
+	return web.Respond(ctx, w, tkn, http.StatusOK)
+}
+
+// userError translates the errors of managing users to request errors with a
+// matching status code.
+func userError(err error, id string) error {
+	if weak, ok := err.(*user.WeakPasswordError); ok {
+		return passwordError("password", weak)
+	}
+
+	switch err {
+	case user.ErrNotFound:
+		return web.NewRequestError(err, http.StatusNotFound)
+	case user.ErrInvalidID:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	case user.ErrEmailTaken:
+		return fieldError("email", err)
+	default:
+		return errors.Wrapf(err, "managing user %q", id)
+	}
+}
+
+// passwordError reports each rule a new password given in field breaks as an
+// error of that field.
+func passwordError(field string, weak *user.WeakPasswordError) error {
+	fields := make([]web.FieldError, len(weak.Reasons))
+	for i, reason := range weak.Reasons {
+		fields[i] = web.FieldError{Field: field, Error: reason}
+	}
+	return &web.Error{
+		Err:    errors.New("field validation error"),
+		Status: http.StatusBadRequest,
+		Fields: fields,
+	}
+}
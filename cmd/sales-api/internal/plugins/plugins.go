@@ -0,0 +1,79 @@
+// Package plugins holds the request hooks compiled into the service which a
+// deployment can enable by name. A fork adds its own by placing a file in
+// this package which registers a plugin from init:
+//
+//	func init() {
+//		plugins.Register("eu-compliance", func(log *log.Logger) ([]web.Hook, error) {
+//			return []web.Hook{{Name: "eu-compliance", Stage: web.PreAuth, Func: addHeaders}}, nil
+//		})
+//	}
+package plugins
+
+import (
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/arammikayelyan/garagesale/internal/platform/web"
+	"github.com/pkg/errors"
+)
+
+// Plugin builds the hooks of a plugin once it is enabled.
+type Plugin func(log *log.Logger) ([]web.Hook, error)
+
+var (
+	mu      sync.Mutex
+	plugins = make(map[string]Plugin)
+)
+
+// Register makes a plugin available under name. It panics if a plugin with
+// the same name was registered.
+func Register(name string, p Plugin) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, ok := plugins[name]; ok {
+		panic("plugins: " + name + " registered twice")
+	}
+	plugins[name] = p
+}
+
+// Names lists the registered plugins.
+func Names() []string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	names := make([]string, 0, len(plugins))
+	for name := range plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Load builds the hooks of the named plugins in order.
+func Load(log *log.Logger, names []string) ([]web.Hook, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	var hooks []web.Hook
+	seen := make(map[string]bool)
+	for _, name := range names {
+		p, ok := plugins[name]
+		if !ok {
+			return nil, errors.Errorf("unknown plugin %q", name)
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		hs, err := p(log)
+		if err != nil {
+			return nil, errors.Wrapf(err, "loading plugin %q", name)
+		}
+		hooks = append(hooks, hs...)
+	}
+
+	return hooks, nil
+}
@@ -2,11 +2,10 @@ package main
 
 import (
 	"context"
-	"crypto/rsa"
+	"crypto/rand"
 	_ "expvar" // Register the /debug/vars handler
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net/http"
 	_ "net/http/pprof" // Register the /debug/pprof handlers
@@ -16,12 +15,23 @@ import (
 	"time"
 
 	"contrib.go.opencensus.io/exporter/zipkin"
-	"github.com/arammikayelyan/garagesale/cmd/sales-api/internal/handlers"
-	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/arammikayelyan/garagesale/cmd/sales-api/internal/plugins"
+	"github.com/arammikayelyan/garagesale/cmd/sales-api/salesapi"
+	"github.com/arammikayelyan/garagesale/internal/anomaly"
+	"github.com/arammikayelyan/garagesale/internal/cdc"
+	"github.com/arammikayelyan/garagesale/internal/export"
+	"github.com/arammikayelyan/garagesale/internal/notification"
+	"github.com/arammikayelyan/garagesale/internal/platform/blob"
+	"github.com/arammikayelyan/garagesale/internal/platform/clock"
 	"github.com/arammikayelyan/garagesale/internal/platform/conf"
 	"github.com/arammikayelyan/garagesale/internal/platform/database"
+	"github.com/arammikayelyan/garagesale/internal/platform/web"
+	"github.com/arammikayelyan/garagesale/internal/product"
+	"github.com/arammikayelyan/garagesale/internal/report"
 	"github.com/arammikayelyan/garagesale/internal/schema"
-	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/arammikayelyan/garagesale/internal/usage"
+	"github.com/arammikayelyan/garagesale/internal/warehouse"
+	"github.com/jmoiron/sqlx"
 	openzipkin "github.com/openzipkin/zipkin-go"
 	zipkinHTTP "github.com/openzipkin/zipkin-go/reporter/http"
 	"github.com/pkg/errors"
@@ -38,12 +48,14 @@ func run() error {
 	log := log.New(os.Stdout, "SALES : ", log.LstdFlags|log.Lmicroseconds|log.Lshortfile)
 
 	var cfg struct {
+		salesapi.Config
 		Web struct {
 			Address         string        `conf:"default:localhost:8000"`
 			Debug           string        `conf:"default:localhost:6060"`
 			ReadTimeout     time.Duration `conf:"default:5s"`
 			WriteTimeout    time.Duration `conf:"default:5s"`
 			ShutdownTimeout time.Duration `conf:"default:5s"`
+			Plugins         []string      `conf:"help:compiled in plugins to enable"`
 		}
 		DB struct {
 			User       string `conf:"default:postgres"`
@@ -51,11 +63,47 @@ func run() error {
 			Host       string `conf:"default:localhost"`
 			Name       string `conf:"default:postgres"`
 			DisableTLS bool   `conf:"default:false"`
+			AppName    string `conf:"default:sales-api,help:application_name of connections as pg_stat_activity shows it"`
+			TagTraces  bool   `conf:"default:true,help:tag connections with the trace ID of the request using them"`
+			Counters   bool   `conf:"default:true,help:read product sold and revenue from counters instead of summing sales"`
 		}
-		Auth struct {
-			PrivateKeyFile string `conf:"default:private.pem"`
-			KeyID          string `conf:"default:1"`
-			Algorithm      string `conf:"default:RS256"`
+		Notify struct {
+			DigestInterval time.Duration `conf:"default:1h"`
+		}
+		Anomaly struct {
+			Interval   time.Duration `conf:"default:1h"`
+			Period     time.Duration `conf:"default:24h"`
+			Baseline   int           `conf:"default:7"`
+			WebhookURL string
+			Thresholds map[string]float64
+		}
+		Warehouse struct {
+			Interval time.Duration `conf:"default:1h"`
+			Dir      string        `conf:"help:directory to export CSV files to, exports are off when empty"`
+		}
+		CDC struct {
+			Slot       string        `conf:"help:logical replication slot to capture changes from, capture is off when empty"`
+			Interval   time.Duration `conf:"default:5s"`
+			WebhookURL string        `conf:"help:event bus endpoint changes are published to, changes are logged when empty"`
+		}
+		Clock struct {
+			Source   string        `conf:"default:system,help:system or db"`
+			MaxDrift time.Duration `conf:"default:2s,help:drift from the database clock logged as a warning"`
+		}
+		Usage struct {
+			Interval time.Duration `conf:"default:5m,help:how often tenant usage is metered"`
+		}
+		Reports struct {
+			Workers  int           `conf:"default:4,help:report chunks computed in parallel"`
+			CacheTTL time.Duration `conf:"default:10m,help:how long finished background reports are kept"`
+		}
+		Exports struct {
+			Dir        string        `conf:"default:exports,help:directory finished exports are stored in"`
+			SigningKey string        `conf:"noprint,help:key download URLs are signed with; random per process when empty"`
+			URLTTL     time.Duration `conf:"default:15m,help:how long download URLs stay valid"`
+			Retention  time.Duration `conf:"default:24h,help:how long finished exports are kept"`
+			Workers    int           `conf:"default:2"`
+			Interval   time.Duration `conf:"default:5s,help:how often pending exports are looked for"`
 		}
 		Trace struct {
 			URL         string  `conf:"default:http://localhost:9411/api/v2/spans"`
@@ -94,12 +142,21 @@ func run() error {
 		Password:   cfg.DB.Password,
 		Name:       cfg.DB.Name,
 		DisableTLS: cfg.DB.DisableTLS,
+		AppName:    cfg.DB.AppName,
+		TagTraces:  cfg.DB.TagTraces,
 	})
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer db.Close()
 
+	// Check the host clock against the database and follow the database
+	// clock when it is the trusted source.
+	clk, err := createClock(db, cfg.Clock.Source, cfg.Clock.MaxDrift, log)
+	if err != nil {
+		return errors.Wrap(err, "constructing clock")
+	}
+
 	// """"""""""""""""""""""""""""
 	// Start Tracing Support
 	closer, err := registerTracer(
@@ -114,14 +171,10 @@ func run() error {
 	defer closer()
 
 	// """"""""""""""""""""""""""
-	// Initialize authentication
-	authenticator, err := createAuth(
-		cfg.Auth.PrivateKeyFile,
-		cfg.Auth.KeyID,
-		cfg.Auth.Algorithm,
-	)
+	// Initialize notifications
+	notifier, err := salesapi.NewNotifier(cfg.Config, db, log)
 	if err != nil {
-		return errors.Wrap(err, "constructing authentication")
+		return err
 	}
 
 	flag.Parse()
@@ -147,17 +200,122 @@ func run() error {
 		log.Printf("main : Debug service ended : %v", err)
 	}()
 
+	// Background jobs run until the service shuts down.
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	if !cfg.DB.Counters {
+		jobsCtx = product.WithSummedSales(jobsCtx)
+	}
+
+	// Start sending notification digests
+	go runDigests(jobsCtx, log, notifier, cfg.Notify.DigestInterval)
+
+	// Start watching business metrics for anomalies
+	thresholds := anomaly.DefaultThresholds
+	if len(cfg.Anomaly.Thresholds) > 0 {
+		thresholds = cfg.Anomaly.Thresholds
+	}
+	sinks := []anomaly.Sink{anomaly.Admins{DB: db, Notifier: notifier}}
+	if cfg.Anomaly.WebhookURL != "" {
+		sinks = append(sinks, anomaly.NewWebhook(cfg.Anomaly.WebhookURL))
+	}
+	detector, err := anomaly.NewDetector(db, log, cfg.Anomaly.Period, cfg.Anomaly.Baseline, thresholds, sinks...)
+	if err != nil {
+		return errors.Wrap(err, "constructing anomaly detector")
+	}
+	go runAnomalyChecks(jobsCtx, log, detector, cfg.Anomaly.Interval)
+
+	// Start exporting to the data warehouse
+	if cfg.Warehouse.Dir != "" {
+		exporter := warehouse.NewExporter(db, log, warehouse.Dir{Root: cfg.Warehouse.Dir})
+		go runExports(jobsCtx, log, exporter, cfg.Warehouse.Interval)
+	}
+
+	// Start capturing changes from the replication slot
+	if cfg.CDC.Slot != "" {
+		var pub cdc.Publisher = cdc.Logger{Log: log}
+		if cfg.CDC.WebhookURL != "" {
+			pub = cdc.NewWebhook(cfg.CDC.WebhookURL)
+		}
+		consumer := cdc.NewConsumer(db, cfg.CDC.Slot, pub)
+		if err := consumer.Setup(context.Background()); err != nil {
+			return errors.Wrap(err, "setting up change capture")
+		}
+		go runChangeCapture(jobsCtx, log, consumer, cfg.CDC.Interval)
+	}
+
+	// Start metering tenant usage
+	meter := usage.NewMeter(db)
+	metered := make(chan struct{})
+	go func() {
+		runMetering(jobsCtx, log, meter, cfg.Usage.Interval)
+		close(metered)
+	}()
+	defer func() {
+		stopJobs()
+		<-metered
+	}()
+
+	// Start computing large reports in the background
+	reports := report.NewRunner(db, cfg.Reports.Workers, cfg.Reports.CacheTTL)
+	go reports.Run(jobsCtx)
+
+	// Start producing exports in the background
+	signingKey := []byte(cfg.Exports.SigningKey)
+	if len(signingKey) == 0 {
+		signingKey = make([]byte, 32)
+		if _, err := rand.Read(signingKey); err != nil {
+			return errors.Wrap(err, "generating export signing key")
+		}
+		log.Println("main : No export signing key, download URLs end with the process")
+	}
+	exports := export.NewRunner(db, export.Config{
+		Store:     blob.Dir{Root: cfg.Exports.Dir},
+		Signer:    blob.Signer{Key: signingKey},
+		Workers:   cfg.Exports.Workers,
+		Retention: cfg.Exports.Retention,
+		URLTTL:    cfg.Exports.URLTTL,
+	})
+	go exports.Run(jobsCtx, cfg.Exports.Interval)
+
+	// Load the request hooks of the enabled plugins
+	hooks, err := plugins.Load(log, cfg.Web.Plugins)
+	if err != nil {
+		return errors.Wrap(err, "loading plugins")
+	}
+	if len(cfg.Web.Plugins) > 0 {
+		log.Printf("main : Enabled plugins %v", cfg.Web.Plugins)
+	}
+
 	// Make a channel for listening to interrupts or terminate signal from the OS.
 	// Use buffered channel because the signal package requires to.
 	shutdown := make(chan os.Signal, 1)
 	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
 
 	// Start API service
+	app, err := salesapi.New(cfg.Config, salesapi.Deps{
+		DB:          db,
+		Log:         log,
+		Shutdown:    shutdown,
+		Clock:       clk,
+		SummedSales: !cfg.DB.Counters,
+		Notifier:    notifier,
+		Meter:       meter,
+		Reports:     reports,
+		Exports:     exports,
+		Hooks:       hooks,
+	})
+	if err != nil {
+		return errors.Wrap(err, "constructing api")
+	}
 	api := &http.Server{
 		Addr:         cfg.Web.Address,
-		Handler:      handlers.API(shutdown, log, db, authenticator),
+		Handler:      app,
 		ReadTimeout:  cfg.Web.ReadTimeout,
 		WriteTimeout: cfg.Web.WriteTimeout,
+
+		// Streamed responses override WriteTimeout through the connection.
+		ConnContext: web.ConnContext,
 	}
 
 	// Make a channel to listen for errors coming from listener. Use a
@@ -197,21 +355,134 @@ func run() error {
 	return nil
 }
 
-func createAuth(privateKeyFile, keyID, algorithm string) (*auth.Authenticator, error) {
+func createClock(db *sqlx.DB, source string, maxDrift time.Duration, log *log.Logger) (clock.Clock, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-	keyContents, err := ioutil.ReadFile(privateKeyFile)
+	drift, err := clock.Drift(ctx, db, clock.System{})
 	if err != nil {
-		return nil, errors.Wrap(err, "reading auth private key")
+		log.Printf("main : measuring clock drift : %v", err)
+	}
+	if drift > maxDrift || drift < -maxDrift {
+		log.Printf("main : WARNING : host clock is %v off the database clock", -drift)
 	}
 
-	key, err := jwt.ParseRSAPrivateKeyFromPEM(keyContents)
-	if err != nil {
-		return nil, errors.Wrap(err, "parsing auth private key")
+	switch source {
+	case "system":
+		return clock.System{}, nil
+	case "db":
+		if err != nil {
+			return nil, errors.Wrap(err, "measuring offset to database clock")
+		}
+		return clock.Offset{Base: clock.System{}, D: drift}, nil
+	default:
+		return nil, errors.Errorf("unknown clock source %q", source)
+	}
+}
+
+// runDigests periodically sends the digests which are due until ctx is
+// cancelled.
+func runDigests(ctx context.Context, log *log.Logger, notifier *notification.Notifier, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			sent, err := notifier.SendDigests(ctx, now)
+			if err != nil {
+				log.Printf("main : sending digests : %v", err)
+				continue
+			}
+			if sent > 0 {
+				log.Printf("main : sent %d digests", sent)
+			}
+		}
+	}
+}
+
+// runAnomalyChecks periodically checks the business metrics until ctx is
+// cancelled.
+func runAnomalyChecks(ctx context.Context, log *log.Logger, detector *anomaly.Detector, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := detector.Check(ctx, now); err != nil {
+				log.Printf("main : checking for anomalies : %v", err)
+			}
+		}
+	}
+}
+
+// runExports periodically exports changed rows to the data warehouse until
+// ctx is cancelled.
+func runExports(ctx context.Context, log *log.Logger, exporter *warehouse.Exporter, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := exporter.Run(ctx, now); err != nil {
+				log.Printf("main : exporting to warehouse : %v", err)
+			}
+		}
+	}
+}
+
+// runChangeCapture periodically publishes the changes captured from the
+// replication slot until ctx is cancelled.
+func runChangeCapture(ctx context.Context, log *log.Logger, consumer *cdc.Consumer, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := consumer.Poll(ctx)
+			if err != nil {
+				log.Printf("main : capturing changes : %v", err)
+			}
+			if n > 0 {
+				log.Printf("main : published %d changes", n)
+			}
+		}
 	}
+}
 
-	public := auth.NewSimpleKeyLookupFunc(keyID, key.Public().(*rsa.PublicKey))
+// runMetering periodically records the usage of every tenant until ctx is
+// cancelled. API calls counted since the last run are recorded once more on
+// the way out so they are not lost.
+func runMetering(ctx context.Context, log *log.Logger, meter *usage.Meter, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 
-	return auth.NewAuthenticator(key, keyID, algorithm, public)
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := meter.Run(flushCtx, time.Now()); err != nil {
+				log.Printf("main : metering usage : %v", err)
+			}
+			return
+		case now := <-ticker.C:
+			if err := meter.Run(ctx, now); err != nil {
+				log.Printf("main : metering usage : %v", err)
+			}
+		}
+	}
 }
 
 func registerTracer(service, httpAddr, traceURL string, probability float64) (func() error, error) {
@@ -0,0 +1,178 @@
+package salesapi
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/arammikayelyan/garagesale/internal/fraud"
+	"github.com/arammikayelyan/garagesale/internal/moderation"
+	"github.com/arammikayelyan/garagesale/internal/notification"
+	"github.com/arammikayelyan/garagesale/internal/payment"
+	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/arammikayelyan/garagesale/internal/platform/mail"
+	"github.com/arammikayelyan/garagesale/internal/platform/push"
+	"github.com/arammikayelyan/garagesale/internal/platform/scan"
+	"github.com/arammikayelyan/garagesale/internal/platform/sms"
+	"github.com/arammikayelyan/garagesale/internal/recommend"
+	"github.com/arammikayelyan/garagesale/internal/user"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+func createAuth(db *sqlx.DB, source, privateKeyFile, keyID, algorithm string, refresh time.Duration) (*auth.Authenticator, error) {
+	var store auth.KeyStore
+	switch source {
+	case "file":
+		store = auth.FileKeys{File: privateKeyFile, KeyID: keyID}
+	case "db":
+		store = user.SigningKeys{DB: db}
+	default:
+		return nil, errors.Errorf("unknown key source %q", source)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return auth.NewStoreAuthenticator(ctx, store, algorithm, refresh)
+}
+
+func createSMS(log *log.Logger, provider, accountSID, authToken, from string) (sms.Sender, error) {
+	switch provider {
+	case "log":
+		return sms.Logger{Log: log}, nil
+	case "twilio":
+		return sms.NewTwilio(accountSID, authToken, from)
+	case "none":
+		return nil, nil
+	default:
+		return nil, errors.Errorf("unknown sms provider %q", provider)
+	}
+}
+
+func createMail(log *log.Logger, provider, addr, username, password, from string) (mail.Mailer, error) {
+	switch provider {
+	case "log":
+		return mail.Logger{Log: log}, nil
+	case "smtp":
+		return mail.NewSMTP(addr, username, password, from)
+	default:
+		return nil, errors.Errorf("unknown mail provider %q", provider)
+	}
+}
+
+func createPayment(provider, stripeSecretKey, stripeWebhookSecret string) (payment.Provider, error) {
+	switch provider {
+	case "none":
+		return nil, nil
+	case "stripe":
+		return payment.NewStripe(stripeSecretKey, stripeWebhookSecret)
+	default:
+		return nil, errors.Errorf("unknown payment provider %q", provider)
+	}
+}
+
+func createModeration(mode string, words []string, wordsFile, apiURL, apiKey string) (*moderation.Filter, error) {
+	if wordsFile != "" {
+		contents, err := ioutil.ReadFile(wordsFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading blocked words")
+		}
+		words = append(words, strings.Split(string(contents), "\n")...)
+	}
+
+	var checkers []moderation.Checker
+	if len(words) > 0 {
+		checkers = append(checkers, moderation.NewWordlist(words))
+	}
+	if apiURL != "" {
+		checkers = append(checkers, moderation.NewAPI(apiURL, apiKey))
+	}
+
+	return moderation.NewFilter(mode, checkers...)
+}
+
+func createScorer(db *sqlx.DB, provider string, window time.Duration, maxOrders int, newAccount time.Duration, apiURL, apiKey string) (fraud.Scorer, error) {
+	switch provider {
+	case "none":
+		return nil, nil
+	case "heuristics":
+		return fraud.Heuristics{DB: db, Window: window, MaxOrders: maxOrders, NewAccount: newAccount}, nil
+	case "api":
+		return fraud.NewAPI(apiURL, apiKey), nil
+	default:
+		return nil, errors.Errorf("unknown fraud provider %q", provider)
+	}
+}
+
+func createScanner(provider, clamAVAddr, apiURL, apiKey string) (scan.Scanner, error) {
+	switch provider {
+	case "none":
+		return nil, nil
+	case "clamav":
+		return scan.ClamAV{Addr: clamAVAddr}, nil
+	case "api":
+		return scan.NewAPI(apiURL, apiKey), nil
+	default:
+		return nil, errors.Errorf("unknown scan provider %q", provider)
+	}
+}
+
+func createRecommender(db *sqlx.DB, provider string, window time.Duration, url, feedbackURL, key string) (recommend.Recommender, error) {
+	switch provider {
+	case "sql":
+		return recommend.Popular{DB: db, Window: window}, nil
+	case "http":
+		if url == "" {
+			return nil, errors.New("http recommender needs a url")
+		}
+		return recommend.NewHTTP(url, feedbackURL, key), nil
+	default:
+		return nil, errors.Errorf("unknown recommend provider %q", provider)
+	}
+}
+
+func createPush(log *log.Logger, provider, fcmProjectID, fcmCredentials, apnsKeyFile, apnsKeyID, apnsTeamID, apnsTopic string, apnsSandbox bool) (map[notification.Platform]push.Sender, error) {
+	switch provider {
+	case "log":
+		return map[notification.Platform]push.Sender{
+			notification.PlatformAndroid: push.Logger{Log: log},
+			notification.PlatformIOS:     push.Logger{Log: log},
+		}, nil
+	case "none":
+		return nil, nil
+	case "live":
+	default:
+		return nil, errors.Errorf("unknown push provider %q", provider)
+	}
+
+	senders := make(map[notification.Platform]push.Sender)
+
+	if fcmProjectID != "" {
+		credentials, err := ioutil.ReadFile(fcmCredentials)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading fcm credentials")
+		}
+		fcm, err := push.NewFCM(fcmProjectID, credentials)
+		if err != nil {
+			return nil, err
+		}
+		senders[notification.PlatformAndroid] = fcm
+	}
+
+	if apnsKeyFile != "" {
+		key, err := ioutil.ReadFile(apnsKeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading apns key")
+		}
+		apns, err := push.NewAPNs(key, apnsKeyID, apnsTeamID, apnsTopic, apnsSandbox)
+		if err != nil {
+			return nil, err
+		}
+		senders[notification.PlatformIOS] = apns
+	}
+
+	return senders, nil
+}
@@ -0,0 +1,571 @@
+// Package salesapi builds the Garage Sale API. The sales-api command serves
+// it on its own and other programs can embed it in their process, under a
+// path prefix and with their own database, logger and authenticator.
+package salesapi
+
+import (
+	"context"
+	"crypto/rand"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+
+	"github.com/arammikayelyan/garagesale/cmd/sales-api/internal/handlers"
+	"github.com/arammikayelyan/garagesale/internal/consent"
+	"github.com/arammikayelyan/garagesale/internal/enrich"
+	"github.com/arammikayelyan/garagesale/internal/exchange"
+	"github.com/arammikayelyan/garagesale/internal/export"
+	"github.com/arammikayelyan/garagesale/internal/fraud"
+	"github.com/arammikayelyan/garagesale/internal/mid"
+	"github.com/arammikayelyan/garagesale/internal/notification"
+	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/arammikayelyan/garagesale/internal/platform/blob"
+	"github.com/arammikayelyan/garagesale/internal/platform/clock"
+	"github.com/arammikayelyan/garagesale/internal/platform/oidc"
+	"github.com/arammikayelyan/garagesale/internal/platform/redis"
+	"github.com/arammikayelyan/garagesale/internal/platform/web"
+	"github.com/arammikayelyan/garagesale/internal/report"
+	"github.com/arammikayelyan/garagesale/internal/templates"
+	"github.com/arammikayelyan/garagesale/internal/tenant"
+	"github.com/arammikayelyan/garagesale/internal/upload"
+	"github.com/arammikayelyan/garagesale/internal/usage"
+	"github.com/arammikayelyan/garagesale/internal/user"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// Config is the configuration of the API. It is tagged to be parsed with the
+// conf package and the sales-api command embeds it in its own configuration.
+type Config struct {
+	PathPrefix    string        `conf:"help:path the API is served under such as /sales"`
+	StreamTimeout time.Duration `conf:"default:10m,help:how long exports and other streamed responses may take to write"`
+	CompressMin   int           `conf:"default:1024,help:smallest response in bytes compressed for clients accepting gzip or deflate; 0 turns compression off"`
+	Auth          struct {
+		KeySource      string        `conf:"default:file,help:where signing keys are loaded from: file or db"`
+		KeyRefresh     time.Duration `conf:"default:1m,help:how often signing keys are reloaded to pick up rotations"`
+		PrivateKeyFile string        `conf:"default:private.pem"`
+		KeyID          string        `conf:"default:1"`
+		Algorithm      string        `conf:"default:RS256,help:RS256 or ES256 or EdDSA; must match the type of the signing key"`
+		Issuer         string        `conf:"help:iss claim of tokens; tokens with another are rejected"`
+		Audience       string        `conf:"help:aud claim of tokens; tokens with another are rejected"`
+		PolicyURL      string        `conf:"help:Open Policy Agent rule deciding who may change what; roles decide when empty"`
+		ResetTTL       time.Duration `conf:"default:1h,help:how long password reset links stay valid"`
+		ResetLimit     int           `conf:"default:5,help:password reset links one address may ask for per window; 0 is unlimited"`
+		ResetWindow    time.Duration `conf:"default:15m"`
+		VerifyTTL      time.Duration `conf:"default:72h,help:how long email verification links stay valid"`
+		AccessTTL      time.Duration `conf:"default:1h,help:how long access tokens stay valid"`
+		ClockSkew      time.Duration `conf:"default:30s,help:how far the clocks of hosts minting and checking tokens may be apart"`
+		RefreshTTL     time.Duration `conf:"default:720h,help:how long refresh tokens stay valid"`
+		ServiceTTL     time.Duration `conf:"default:15m,help:how long tokens issued to services with client credentials stay valid"`
+		LockoutAfter   int           `conf:"default:5,help:failed logins in a row which lock an account; 0 never locks"`
+		LockoutFor     time.Duration `conf:"default:15m,help:how long accounts stay locked"`
+		HashTime       uint32        `conf:"default:1,help:argon2id passes over memory when hashing passwords"`
+		HashMemory     uint32        `conf:"default:65536,help:argon2id memory in KiB when hashing passwords"`
+		HashThreads    uint8         `conf:"default:2,help:argon2id threads when hashing passwords"`
+
+		// OpenID Connect sign in is off unless an issuer is set.
+		OIDCIssuer       string `conf:"help:OpenID Connect provider users may sign in with"`
+		OIDCClientID     string
+		OIDCClientSecret string `conf:"noprint"`
+		OIDCRedirectURL  string `conf:"default:http://localhost:8000/v1/users/oidc/callback"`
+	}
+	Suggest struct {
+		CacheTTL  time.Duration `conf:"default:5m,help:how long search completions are cached"`
+		RateLimit int           `conf:"default:600,help:search completions one address may ask for per window; 0 is unlimited"`
+		Window    time.Duration `conf:"default:1m"`
+	}
+	Passwords struct {
+		MinLength  int    `conf:"default:8,help:shortest password accepted"`
+		Letter     bool   `conf:"default:true,help:require a letter in passwords"`
+		Upper      bool   `conf:"default:false,help:require an uppercase letter in passwords"`
+		Lower      bool   `conf:"default:false,help:require a lowercase letter in passwords"`
+		Digit      bool   `conf:"default:true,help:require a digit in passwords"`
+		Symbol     bool   `conf:"default:false,help:require a symbol in passwords"`
+		CommonFile string `conf:"help:file with one more common password to refuse per line"`
+	}
+	Pages struct {
+		Default          int    `conf:"default:100,help:rows a listing returns when the client asks for no number"`
+		Max              int    `conf:"default:1000,help:most rows a client may ask a listing for"`
+		MaxUsers         int    `conf:"help:most rows of the user listing; 0 uses max"`
+		MaxSales         int    `conf:"help:most rows of the sales listings; 0 uses max"`
+		MaxProducts      int    `conf:"help:most rows of the products listing; 0 uses max"`
+		MaxNotifications int    `conf:"help:most rows of the notifications listing; 0 uses max"`
+		MaxContentFlags  int    `conf:"help:most rows of the content flags listing; 0 uses max"`
+		CursorKey        string `conf:"noprint,help:key page cursors are signed with; random per process when empty"`
+	}
+	Images struct {
+		Dir           string `conf:"default:images,help:directory product pictures are stored in"`
+		MatchDistance int    `conf:"default:6,help:bits perceptual hashes may differ by for pictures to be flagged as duplicates; -1 is off"`
+	}
+	Scan struct {
+		Provider      string `conf:"default:none,help:malware scanner of uploads: none or clamav or api"`
+		ClamAVAddr    string `conf:"default:localhost:3310"`
+		APIURL        string
+		APIKey        string `conf:"noprint"`
+		QuarantineDir string `conf:"default:quarantine,help:directory infected uploads are kept in"`
+	}
+	Templates struct {
+		ReloadInterval time.Duration `conf:"default:30s"`
+	}
+	JSON struct {
+		FastPath bool `conf:"default:false,help:encode product and sale lists with hand written encoders"`
+	}
+	Moderation struct {
+		Mode      string `conf:"default:reject,help:reject or flag content violating the rules"`
+		Words     []string
+		WordsFile string `conf:"help:file with one blocked word per line"`
+		APIURL    string
+		APIKey    string `conf:"noprint"`
+	}
+	Bots struct {
+		Block       bool          `conf:"default:false,help:reject suspected bots instead of tagging them"`
+		MaxRequests int           `conf:"default:120"`
+		Window      time.Duration `conf:"default:1m"`
+		Honeypot    string        `conf:"default:website"`
+		Allow       []string      `conf:"help:addresses or CIDR ranges never treated as bots"`
+		AllowAgents []string      `conf:"default:Googlebot,help:user agents of welcome crawlers"`
+	}
+	Exchange struct {
+		URL string `conf:"help:Frankfurter compatible exchange rate API used to convert reports"`
+	}
+	Enrich struct {
+		URL string `conf:"help:service suggesting content for new products"`
+		Key string `conf:"noprint"`
+	}
+	Recommend struct {
+		Provider    string        `conf:"default:sql,help:recommender of products: sql or http"`
+		Window      time.Duration `conf:"default:720h,help:how far back sales make products popular for the sql recommender"`
+		URL         string        `conf:"help:service recommending products for the http recommender"`
+		FeedbackURL string        `conf:"help:service clicks and purchases are sent to for training"`
+		Key         string        `conf:"noprint"`
+	}
+	Redis struct {
+		Addr         string        `conf:"help:host:port of Redis; empty keeps revoked tokens in the database"`
+		Password     string        `conf:"noprint"`
+		DB           int           `conf:"default:0"`
+		Timeout      time.Duration `conf:"default:250ms,help:how long a Redis command may take"`
+		PoolSize     int           `conf:"default:10,help:idle connections kept to Redis"`
+		DenylistSize int           `conf:"default:10000,help:revoked tokens each instance remembers in memory"`
+	}
+	Analytics struct {
+		Track bool `conf:"default:false,help:record requests of visitors and users who consented to analytics"`
+	}
+	Interest struct {
+		Show     bool          `conf:"default:true,help:show watchers and views on products unless their tenant turns interest_signals off"`
+		CacheTTL time.Duration `conf:"default:1m,help:how long the interest in a product is cached"`
+	}
+	Tenant struct {
+		Locale         string        `conf:"default:en"`
+		CommissionRate float64       `conf:"default:0,help:commission in percent withheld from sellers"`
+		SettingsTTL    time.Duration `conf:"default:30s,help:how long tenant settings are cached"`
+	}
+	Payment struct {
+		Provider            string  `conf:"default:none,help:none or stripe"`
+		Currency            string  `conf:"default:usd"`
+		TaxRate             float64 `conf:"default:0,help:sales tax in percent included in prices"`
+		StripeSecretKey     string  `conf:"noprint"`
+		StripeWebhookSecret string  `conf:"noprint"`
+		RestockChargebacks  bool    `conf:"default:false,help:put the units of sales reversed by chargebacks back into stock"`
+	}
+	Fraud struct {
+		Provider      string        `conf:"default:none,help:fraud scorer of checkouts: none or heuristics or api"`
+		Review        int           `conf:"default:50,help:risk score from 0 to 100 holding checkouts for review; 0 is off"`
+		Reject        int           `conf:"default:80,help:risk score from 0 to 100 rejecting checkouts; 0 is off"`
+		Window        time.Duration `conf:"default:1h,help:window checkouts are counted over for velocity"`
+		MaxOrders     int           `conf:"default:5,help:checkouts within the window making a buyer suspicious; 0 is off"`
+		NewAccount    time.Duration `conf:"default:24h,help:age under which an account is suspicious; 0 is off"`
+		CountryHeader string        `conf:"help:request header holding the country of the client such as CF-IPCountry"`
+		APIURL        string
+		APIKey        string `conf:"noprint"`
+	}
+	SMS struct {
+		Provider         string `conf:"default:log"`
+		TwilioAccountSID string
+		TwilioAuthToken  string `conf:"noprint"`
+		From             string
+	}
+	Mail struct {
+		Provider     string `conf:"default:log,help:log or smtp"`
+		SMTPAddr     string `conf:"default:localhost:25"`
+		SMTPUser     string
+		SMTPPassword string `conf:"noprint"`
+		From         string `conf:"default:Garage Sale <noreply@localhost>"`
+		ResetURL     string `conf:"default:http://localhost:8000/reset-password,help:page users choose a new password on"`
+		VerifyURL    string `conf:"default:http://localhost:8000/verify-email,help:page users verify their email address on"`
+	}
+	Push struct {
+		Provider       string `conf:"default:log"`
+		FCMProjectID   string
+		FCMCredentials string `conf:"help:path to the service account JSON file"`
+		APNsKeyFile    string
+		APNsKeyID      string
+		APNsTeamID     string
+		APNsTopic      string
+		APNsSandbox    bool `conf:"default:false"`
+	}
+}
+
+// Deps are what the API runs on. DB, Log and Shutdown are required; the rest
+// are built from the Config when left nil.
+type Deps struct {
+	DB  *sqlx.DB
+	Log *log.Logger
+
+	// Shutdown is sent a signal when a handler finds an integrity issue and
+	// the process should stop.
+	Shutdown chan os.Signal
+
+	// Clock defaults to the system clock.
+	Clock clock.Clock
+
+	// Authenticator is used as given. When nil one is built from the Auth
+	// config with the token lifetimes and revocation list set.
+	Authenticator *auth.Authenticator
+
+	// Notifier is shared with the jobs sending digests and alerts.
+	Notifier *notification.Notifier
+
+	// Meter counts the API calls of tenants. Usage is not metered when it
+	// is nil; whoever provides it runs it.
+	Meter *usage.Meter
+
+	// Reports computes large reports in the background. Reports are only
+	// computed within requests when it is nil; whoever provides it runs it.
+	Reports *report.Runner
+
+	// Exports produces exports in the background. Only the synchronous
+	// exports are served when it is nil; whoever provides it runs it.
+	Exports *export.Runner
+
+	// Hooks are registered on the App.
+	Hooks []web.Hook
+
+	// SummedSales makes product queries sum the sales instead of reading
+	// the counters.
+	SummedSales bool
+}
+
+// New constructs the App serving the API. Servers should set web.ConnContext
+// as their ConnContext so exports are not cut short by their WriteTimeout.
+func New(cfg Config, deps Deps) (*web.App, error) {
+	if deps.DB == nil || deps.Log == nil || deps.Shutdown == nil {
+		return nil, errors.New("database, logger and shutdown channel are required")
+	}
+	log := deps.Log
+
+	clk := deps.Clock
+	if clk == nil {
+		clk = clock.System{}
+	}
+
+	authenticator := deps.Authenticator
+	if authenticator == nil {
+		var err error
+		authenticator, err = createAuth(
+			deps.DB,
+			cfg.Auth.KeySource,
+			cfg.Auth.PrivateKeyFile,
+			cfg.Auth.KeyID,
+			cfg.Auth.Algorithm,
+			cfg.Auth.KeyRefresh,
+		)
+		if err != nil {
+			return nil, errors.Wrap(err, "constructing authentication")
+		}
+		authenticator.SetLifetimes(cfg.Auth.AccessTTL, cfg.Auth.RefreshTTL)
+		authenticator.SetServiceLifetime(cfg.Auth.ServiceTTL)
+		authenticator.SetClockSkew(cfg.Auth.ClockSkew)
+		authenticator.SetIssuer(cfg.Auth.Issuer, cfg.Auth.Audience)
+		if cfg.Redis.Addr != "" {
+			client := redis.New(redis.Config{
+				Addr:     cfg.Redis.Addr,
+				Password: cfg.Redis.Password,
+				DB:       cfg.Redis.DB,
+				Timeout:  cfg.Redis.Timeout,
+				PoolSize: cfg.Redis.PoolSize,
+			})
+			authenticator.SetRevocations(auth.NewDenylist(client, cfg.Redis.DenylistSize, log))
+		} else {
+			authenticator.SetRevocations(user.Revocations{DB: deps.DB})
+		}
+	}
+
+	notifier := deps.Notifier
+	if notifier == nil {
+		var err error
+		notifier, err = NewNotifier(cfg, deps.DB, log)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	mailer, err := createMail(
+		log,
+		cfg.Mail.Provider,
+		cfg.Mail.SMTPAddr,
+		cfg.Mail.SMTPUser,
+		cfg.Mail.SMTPPassword,
+		cfg.Mail.From,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "constructing mailer")
+	}
+	accountMail := handlers.AccountMail{
+		Mailer:    mailer,
+		ResetURL:  cfg.Mail.ResetURL,
+		ResetTTL:  cfg.Auth.ResetTTL,
+		VerifyURL: cfg.Mail.VerifyURL,
+		VerifyTTL: cfg.Auth.VerifyTTL,
+	}
+	credentials := user.DefaultCredentials()
+	credentials.Hashing = user.HashParams{
+		Time:    cfg.Auth.HashTime,
+		Memory:  cfg.Auth.HashMemory,
+		Threads: cfg.Auth.HashThreads,
+	}
+	credentials.Passwords = user.PasswordPolicy{
+		MinLength: cfg.Passwords.MinLength,
+		Letter:    cfg.Passwords.Letter,
+		Upper:     cfg.Passwords.Upper,
+		Lower:     cfg.Passwords.Lower,
+		Digit:     cfg.Passwords.Digit,
+		Symbol:    cfg.Passwords.Symbol,
+		Common:    credentials.Passwords.Common,
+	}
+	if cfg.Passwords.CommonFile != "" {
+		contents, err := ioutil.ReadFile(cfg.Passwords.CommonFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading common passwords")
+		}
+		for pw := range user.ParseCommonPasswords(string(contents)) {
+			credentials.Passwords.Common[pw] = true
+		}
+	}
+
+	var policy auth.Policy = auth.RolePolicy{}
+	if cfg.Auth.PolicyURL != "" {
+		policy = auth.NewOPAPolicy(cfg.Auth.PolicyURL)
+	}
+
+	lockout := user.Lockout{
+		Threshold: cfg.Auth.LockoutAfter,
+		Cooldown:  cfg.Auth.LockoutFor,
+	}
+
+	size := func(max int) web.PageSize {
+		if max == 0 {
+			max = cfg.Pages.Max
+		}
+		return web.PageSize{Default: cfg.Pages.Default, Max: max}
+	}
+	pages := handlers.Pages{
+		Users:         size(cfg.Pages.MaxUsers),
+		Sales:         size(cfg.Pages.MaxSales),
+		Products:      size(cfg.Pages.MaxProducts),
+		Notifications: size(cfg.Pages.MaxNotifications),
+		ContentFlags:  size(cfg.Pages.MaxContentFlags),
+	}
+	cursorKey := []byte(cfg.Pages.CursorKey)
+	if len(cursorKey) == 0 {
+		cursorKey = make([]byte, 32)
+		if _, err := rand.Read(cursorKey); err != nil {
+			return nil, errors.Wrap(err, "generating cursor key")
+		}
+	}
+	pages.Cursors = web.NewCursors(cursorKey)
+
+	suggestions := handlers.Suggestions{
+		CacheTTL:  cfg.Suggest.CacheTTL,
+		RateLimit: cfg.Suggest.RateLimit,
+		Window:    cfg.Suggest.Window,
+	}
+
+	images := handlers.Images{
+		Store:         blob.Dir{Root: cfg.Images.Dir},
+		MatchDistance: cfg.Images.MatchDistance,
+	}
+
+	scanner, err := createScanner(cfg.Scan.Provider, cfg.Scan.ClamAVAddr, cfg.Scan.APIURL, cfg.Scan.APIKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "constructing malware scanner")
+	}
+	uploads := &upload.Screen{
+		Scanner:    scanner,
+		Quarantine: blob.Dir{Root: cfg.Scan.QuarantineDir},
+	}
+
+	var provider *oidc.Provider
+	if cfg.Auth.OIDCIssuer != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		provider, err = oidc.Discover(ctx, cfg.Auth.OIDCIssuer, cfg.Auth.OIDCClientID, cfg.Auth.OIDCClientSecret, cfg.Auth.OIDCRedirectURL)
+		if err != nil {
+			return nil, errors.Wrap(err, "constructing openid connect provider")
+		}
+	}
+
+	tenants := tenant.NewConfig(deps.DB, tenant.Settings{
+		Currency:       cfg.Payment.Currency,
+		Locale:         cfg.Tenant.Locale,
+		CommissionRate: cfg.Tenant.CommissionRate,
+		Features:       map[string]bool{tenant.FeatureInterestSignals: cfg.Interest.Show},
+	}, cfg.Tenant.SettingsTTL)
+
+	tmpls := templates.NewStore(deps.DB, cfg.Templates.ReloadInterval)
+
+	filter, err := createModeration(
+		cfg.Moderation.Mode,
+		cfg.Moderation.Words,
+		cfg.Moderation.WordsFile,
+		cfg.Moderation.APIURL,
+		cfg.Moderation.APIKey,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "constructing content filter")
+	}
+
+	payments, err := createPayment(cfg.Payment.Provider, cfg.Payment.StripeSecretKey, cfg.Payment.StripeWebhookSecret)
+	if err != nil {
+		return nil, errors.Wrap(err, "constructing payment provider")
+	}
+
+	bots, err := mid.BotGuard(log, mid.BotPolicy{
+		Block:       cfg.Bots.Block,
+		MaxRequests: cfg.Bots.MaxRequests,
+		Window:      cfg.Bots.Window,
+		Honeypot:    cfg.Bots.Honeypot,
+		Allow:       cfg.Bots.Allow,
+		AllowAgents: cfg.Bots.AllowAgents,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "constructing bot guard")
+	}
+
+	var enricher enrich.Enricher
+	if cfg.Enrich.URL != "" {
+		enricher = enrich.NewHTTP(cfg.Enrich.URL, cfg.Enrich.Key)
+	}
+
+	recommender, err := createRecommender(deps.DB, cfg.Recommend.Provider, cfg.Recommend.Window, cfg.Recommend.URL, cfg.Recommend.FeedbackURL, cfg.Recommend.Key)
+	if err != nil {
+		return nil, errors.Wrap(err, "constructing recommender")
+	}
+
+	scorer, err := createScorer(deps.DB, cfg.Fraud.Provider, cfg.Fraud.Window, cfg.Fraud.MaxOrders, cfg.Fraud.NewAccount, cfg.Fraud.APIURL, cfg.Fraud.APIKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "constructing fraud scorer")
+	}
+	risk := handlers.Risk{
+		Scorer:        scorer,
+		Thresholds:    fraud.Thresholds{Review: cfg.Fraud.Review, Reject: cfg.Fraud.Reject},
+		CountryHeader: cfg.Fraud.CountryHeader,
+	}
+
+	var rates *exchange.Rates
+	if cfg.Exchange.URL != "" {
+		rates = &exchange.Rates{DB: deps.DB, Provider: exchange.NewHTTP(cfg.Exchange.URL)}
+	}
+
+	var tracker *consent.Tracker
+	if cfg.Analytics.Track {
+		tracker = &consent.Tracker{DB: deps.DB, Log: log}
+	}
+
+	var compress web.Middleware
+	if cfg.CompressMin > 0 {
+		compress = web.Compress(cfg.CompressMin)
+	}
+
+	app := handlers.API(handlers.Config{
+		Shutdown:           deps.Shutdown,
+		Log:                log,
+		Clock:              clk,
+		DB:                 deps.DB,
+		Authenticator:      authenticator,
+		Notifier:           notifier,
+		Templates:          tmpls,
+		Moderation:         filter,
+		Enricher:           enricher,
+		Recommender:        recommender,
+		Tenants:            tenants,
+		Rates:              rates,
+		Risk:               risk,
+		AccountMail:        accountMail,
+		Lockout:            lockout,
+		Pages:              pages,
+		Suggestions:        suggestions,
+		ResetLimit:         cfg.Auth.ResetLimit,
+		ResetWindow:        cfg.Auth.ResetWindow,
+		Images:             images,
+		Uploads:            uploads,
+		Payments:           payments,
+		RestockChargebacks: cfg.Payment.RestockChargebacks,
+		TaxRate:            cfg.Payment.TaxRate,
+		InterestTTL:        cfg.Interest.CacheTTL,
+		Meter:              deps.Meter,
+		Tracker:            tracker,
+		Reports:            deps.Reports,
+		Exports:            deps.Exports,
+		OIDC:               provider,
+		Bots:               bots,
+		Compress:           compress,
+		Hooks:              deps.Hooks,
+		Credentials:        &credentials,
+		Policy:             policy,
+		SummedSales:        deps.SummedSales,
+	})
+	app.SetPathPrefix(cfg.PathPrefix)
+	app.SetJSONFastPath(cfg.JSON.FastPath)
+	app.SetStreamTimeout(cfg.StreamTimeout)
+
+	return app, nil
+}
+
+// NewNotifier constructs the Notifier delivering notifications through the
+// mail, SMS and push providers of the Config.
+func NewNotifier(cfg Config, db *sqlx.DB, log *log.Logger) (*notification.Notifier, error) {
+	mailer, err := createMail(
+		log,
+		cfg.Mail.Provider,
+		cfg.Mail.SMTPAddr,
+		cfg.Mail.SMTPUser,
+		cfg.Mail.SMTPPassword,
+		cfg.Mail.From,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "constructing mailer")
+	}
+	smsSender, err := createSMS(
+		log,
+		cfg.SMS.Provider,
+		cfg.SMS.TwilioAccountSID,
+		cfg.SMS.TwilioAuthToken,
+		cfg.SMS.From,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "constructing sms sender")
+	}
+	pushSenders, err := createPush(
+		log,
+		cfg.Push.Provider,
+		cfg.Push.FCMProjectID,
+		cfg.Push.FCMCredentials,
+		cfg.Push.APNsKeyFile,
+		cfg.Push.APNsKeyID,
+		cfg.Push.APNsTeamID,
+		cfg.Push.APNsTopic,
+		cfg.Push.APNsSandbox,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "constructing push senders")
+	}
+
+	notifier := notification.NewNotifier(db, log, notification.Senders{
+		Mail: mailer,
+		SMS:  smsSender,
+		Push: pushSenders,
+	})
+
+	return notifier, nil
+}
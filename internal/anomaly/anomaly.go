@@ -0,0 +1,165 @@
+package anomaly
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// ErrUnknownMetric is returned when a threshold is configured for a metric
+// that does not exist.
+var ErrUnknownMetric = errors.New("unknown metric")
+
+// queries computes each metric over the window [$1, $2).
+var queries = map[string]string{
+	MetricSales:    `SELECT COUNT(*) FROM sales WHERE status = 'paid' AND date_created >= $1 AND date_created < $2`,
+	MetricGMV:      `SELECT COALESCE(SUM(paid), 0) FROM sales WHERE status = 'paid' AND date_created >= $1 AND date_created < $2`,
+	MetricListings: `SELECT COUNT(*) FROM products WHERE date_created >= $1 AND date_created < $2`,
+}
+
+// Detect compares every metric with a threshold over the period ending at now
+// with the average of the baseline periods before it. Metrics without any
+// activity in the baseline are skipped since no deviation can be computed.
+func Detect(ctx context.Context, db *sqlx.DB, now time.Time, period time.Duration, baseline int, thresholds map[string]float64) ([]Alert, error) {
+	now = now.UTC()
+
+	var alerts []Alert
+	for metric, threshold := range thresholds {
+		q, ok := queries[metric]
+		if !ok {
+			return nil, errors.Wrap(ErrUnknownMetric, metric)
+		}
+
+		var current float64
+		if err := db.GetContext(ctx, &current, q, now.Add(-period), now); err != nil {
+			return nil, errors.Wrapf(err, "computing %s", metric)
+		}
+
+		var sum float64
+		for i := 1; i <= baseline; i++ {
+			var v float64
+			to := now.Add(-time.Duration(i) * period)
+			if err := db.GetContext(ctx, &v, q, to.Add(-period), to); err != nil {
+				return nil, errors.Wrapf(err, "computing %s baseline", metric)
+			}
+			sum += v
+		}
+
+		mean := sum / float64(baseline)
+		if mean == 0 {
+			continue
+		}
+
+		deviation := (current - mean) / mean
+		if math.Abs(deviation) <= threshold {
+			continue
+		}
+
+		alerts = append(alerts, Alert{
+			Metric:    metric,
+			Current:   current,
+			Baseline:  mean,
+			Deviation: deviation,
+			Threshold: threshold,
+			From:      now.Add(-period),
+			To:        now,
+		})
+	}
+
+	return alerts, nil
+}
+
+// Sink is somewhere alerts are delivered to.
+type Sink interface {
+	Send(ctx context.Context, alerts []Alert) error
+}
+
+// Detector periodically checks the metrics and delivers alerts to its sinks.
+// A metric which raised an alert stays quiet for one period so overlapping
+// checks do not repeat the same alert.
+type Detector struct {
+	db         *sqlx.DB
+	log        *log.Logger
+	period     time.Duration
+	baseline   int
+	thresholds map[string]float64
+	sinks      []Sink
+
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+// NewDetector constructs a Detector comparing each period with the average of
+// the baseline periods before it.
+func NewDetector(db *sqlx.DB, log *log.Logger, period time.Duration, baseline int, thresholds map[string]float64, sinks ...Sink) (*Detector, error) {
+	if period <= 0 {
+		return nil, errors.New("period must be positive")
+	}
+	if baseline < 1 {
+		return nil, errors.New("baseline must be at least one period")
+	}
+	for metric := range thresholds {
+		if _, ok := queries[metric]; !ok {
+			return nil, errors.Wrap(ErrUnknownMetric, metric)
+		}
+	}
+
+	d := Detector{
+		db:         db,
+		log:        log,
+		period:     period,
+		baseline:   baseline,
+		thresholds: thresholds,
+		sinks:      sinks,
+		last:       make(map[string]time.Time),
+	}
+	return &d, nil
+}
+
+// Check runs the detection once and delivers any new alerts.
+func (d *Detector) Check(ctx context.Context, now time.Time) error {
+	alerts, err := Detect(ctx, d.db, now, d.period, d.baseline, d.thresholds)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	fresh := alerts[:0]
+	for _, a := range alerts {
+		if last, ok := d.last[a.Metric]; ok && now.Sub(last) < d.period {
+			continue
+		}
+		d.last[a.Metric] = now
+		fresh = append(fresh, a)
+	}
+	d.mu.Unlock()
+
+	if len(fresh) == 0 {
+		return nil
+	}
+
+	for _, a := range fresh {
+		d.log.Printf("anomaly : %s", Describe(a))
+	}
+
+	var first error
+	for _, s := range d.sinks {
+		if err := s.Send(ctx, fresh); err != nil && first == nil {
+			first = err
+		}
+	}
+
+	return first
+}
+
+// Describe renders an alert as a single human readable line.
+func Describe(a Alert) string {
+	return fmt.Sprintf("%s was %.0f against a baseline of %.1f (%+.0f%%, threshold %.0f%%)",
+		a.Metric, a.Current, a.Baseline, a.Deviation*100, a.Threshold*100)
+}
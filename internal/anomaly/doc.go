@@ -0,0 +1,3 @@
+// Package anomaly detects unusual swings in business metrics by comparing the
+// most recent period against a rolling baseline of the periods before it.
+package anomaly
@@ -0,0 +1,31 @@
+package anomaly
+
+import "time"
+
+// These are the metrics which can be watched for anomalies.
+const (
+	MetricSales    = "sales"
+	MetricGMV      = "gmv"
+	MetricListings = "listings"
+)
+
+// DefaultThresholds are the relative deviations from the baseline which raise
+// an alert for each metric when no other thresholds are configured. A value of
+// 0.5 alerts when the current period is 50% above or below the baseline.
+var DefaultThresholds = map[string]float64{
+	MetricSales:    0.5,
+	MetricGMV:      0.5,
+	MetricListings: 0.75,
+}
+
+// Alert describes a metric whose value in the window [From, To) deviates from
+// its baseline by more than the configured threshold.
+type Alert struct {
+	Metric    string    `json:"metric"`
+	Current   float64   `json:"current"`
+	Baseline  float64   `json:"baseline"`
+	Deviation float64   `json:"deviation"`
+	Threshold float64   `json:"threshold"`
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+}
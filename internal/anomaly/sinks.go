@@ -0,0 +1,95 @@
+package anomaly
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/arammikayelyan/garagesale/internal/notification"
+	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// Webhook is a Sink which posts alerts as JSON to a URL.
+type Webhook struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewWebhook constructs a Webhook sink posting to url.
+func NewWebhook(url string) *Webhook {
+	return &Webhook{
+		URL:    url,
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Send implements the Sink interface.
+func (wh *Webhook) Send(ctx context.Context, alerts []Alert) error {
+	body := struct {
+		Alerts []Alert `json:"alerts"`
+	}{alerts}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return errors.Wrap(err, "encoding alerts")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wh.URL, bytes.NewReader(data))
+	if err != nil {
+		return errors.Wrap(err, "creating webhook request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := wh.Client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "calling alert webhook")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return errors.Errorf("alert webhook responded %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// Admins is a Sink which notifies every admin user over the channels they
+// chose for anomaly alerts.
+type Admins struct {
+	DB       *sqlx.DB
+	Notifier *notification.Notifier
+}
+
+// Send implements the Sink interface.
+func (a Admins) Send(ctx context.Context, alerts []Alert) error {
+	var ids []string
+	const q = `SELECT user_id FROM users WHERE $1 = ANY(roles)`
+	if err := a.DB.SelectContext(ctx, &ids, q, auth.RoleAdmin); err != nil {
+		return errors.Wrap(err, "selecting admins")
+	}
+
+	lines := make([]string, len(alerts))
+	for i, al := range alerts {
+		lines[i] = Describe(al)
+	}
+
+	m := notification.Message{
+		Event:   notification.EventAnomaly,
+		Subject: "Unusual activity detected",
+		Body:    strings.Join(lines, "\n"),
+	}
+
+	var first error
+	for _, id := range ids {
+		if err := a.Notifier.Notify(ctx, id, m); err != nil && first == nil {
+			first = err
+		}
+	}
+
+	return first
+}
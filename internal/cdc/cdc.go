@@ -0,0 +1,191 @@
+package cdc
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// tables are the tables whose changes are captured.
+const tables = "public.users,public.products,public.sales"
+
+// hidden are columns which are never published.
+var hidden = map[string]map[string]bool{
+	"users": {"password_hash": true},
+}
+
+// batch bounds the number of changes read from the slot in one poll. Whole
+// transactions are always read so a poll can return more.
+const batch = 1000
+
+// Consumer reads changes from a logical replication slot and publishes them.
+type Consumer struct {
+	db   *sqlx.DB
+	slot string
+	pub  Publisher
+}
+
+// NewConsumer constructs a Consumer reading from the named slot.
+func NewConsumer(db *sqlx.DB, slot string, pub Publisher) *Consumer {
+	return &Consumer{
+		db:   db,
+		slot: slot,
+		pub:  pub,
+	}
+}
+
+// Setup creates the replication slot unless it already exists. The database
+// must run with wal_level=logical and have the wal2json plugin installed.
+func (c *Consumer) Setup(ctx context.Context) error {
+	var exists bool
+	const qExists = `SELECT EXISTS (SELECT 1 FROM pg_replication_slots WHERE slot_name = $1)`
+	if err := c.db.GetContext(ctx, &exists, qExists, c.slot); err != nil {
+		return errors.Wrap(err, "checking replication slot")
+	}
+	if exists {
+		return nil
+	}
+
+	const qCreate = `SELECT pg_create_logical_replication_slot($1, 'wal2json')`
+	if _, err := c.db.ExecContext(ctx, qCreate, c.slot); err != nil {
+		return errors.Wrap(err, "creating replication slot")
+	}
+
+	return nil
+}
+
+// row is a row returned from reading the slot.
+type row struct {
+	LSN  string `db:"lsn"`
+	XID  int64  `db:"xid"`
+	Data string `db:"data"`
+}
+
+// Poll publishes the changes committed since the previous poll and returns
+// how many were published. Changes are only consumed from the slot once
+// their transaction has been published, so a failed publish is retried on
+// the next poll.
+func (c *Consumer) Poll(ctx context.Context) (int, error) {
+	const q = `
+		SELECT lsn::text AS lsn, xid::text::bigint AS xid, data
+		FROM pg_logical_slot_peek_changes($1, NULL, $2,
+			'format-version', '2',
+			'include-timestamp', '1',
+			'include-pk', '1',
+			'add-tables', $3)`
+
+	var rows []row
+	if err := c.db.SelectContext(ctx, &rows, q, c.slot, batch, tables); err != nil {
+		return 0, errors.Wrap(err, "reading replication slot")
+	}
+
+	var (
+		published int
+		changes   []Change
+		begun     time.Time
+	)
+	for _, r := range rows {
+		var m message
+		if err := json.Unmarshal([]byte(r.Data), &m); err != nil {
+			return published, errors.Wrapf(err, "decoding change at %s", r.LSN)
+		}
+
+		switch m.Action {
+		case "B":
+			changes = nil
+			begun = parseTime(m.Timestamp)
+
+		case "C":
+			if len(changes) > 0 {
+				if err := c.pub.Publish(ctx, changes); err != nil {
+					return published, errors.Wrapf(err, "publishing transaction %d", r.XID)
+				}
+			}
+			if err := c.advance(ctx, r.LSN); err != nil {
+				return published, err
+			}
+			published += len(changes)
+			changes = nil
+
+		case "I", "U", "D":
+			ch := normalize(m, r)
+			if ch.Time.IsZero() {
+				ch.Time = begun
+			}
+			changes = append(changes, ch)
+		}
+	}
+
+	return published, nil
+}
+
+// advance consumes the slot up to and including lsn.
+func (c *Consumer) advance(ctx context.Context, lsn string) error {
+	const q = `SELECT pg_replication_slot_advance($1, $2::pg_lsn)`
+	if _, err := c.db.ExecContext(ctx, q, c.slot, lsn); err != nil {
+		return errors.Wrap(err, "advancing replication slot")
+	}
+	return nil
+}
+
+// normalize turns a wal2json message into a Change. The key holds the
+// primary key columns, taken from the old row identity for deletes.
+func normalize(m message, r row) Change {
+	ch := Change{
+		LSN:   r.LSN,
+		XID:   r.XID,
+		Table: m.Table,
+		Time:  parseTime(m.Timestamp),
+		Key:   make(map[string]interface{}),
+	}
+
+	pk := make(map[string]bool, len(m.PK))
+	for _, col := range m.PK {
+		pk[col.Name] = true
+	}
+
+	switch m.Action {
+	case "I":
+		ch.Op = OpInsert
+	case "U":
+		ch.Op = OpUpdate
+	case "D":
+		ch.Op = OpDelete
+	}
+
+	if ch.Op == OpDelete {
+		for _, col := range m.Identity {
+			ch.Key[col.Name] = col.Value
+		}
+		return ch
+	}
+
+	ch.Row = make(map[string]interface{}, len(m.Columns))
+	for _, col := range m.Columns {
+		if hidden[m.Table][col.Name] {
+			continue
+		}
+		ch.Row[col.Name] = col.Value
+		if pk[col.Name] {
+			ch.Key[col.Name] = col.Value
+		}
+	}
+
+	return ch
+}
+
+// parseTime parses a wal2json timestamp, returning the zero time when it is
+// missing or malformed.
+func parseTime(s string) time.Time {
+	if s == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse("2006-01-02 15:04:05.999999-07", s)
+	if err != nil {
+		return time.Time{}
+	}
+	return t.UTC()
+}
@@ -0,0 +1,6 @@
+// Package cdc captures changes to the main tables from the Postgres
+// write-ahead log through a logical replication slot using the wal2json
+// output plugin, and re-publishes them as normalized change events. Reading
+// the log instead of writing events next to each change means the events can
+// never drift from what was actually committed.
+package cdc
@@ -0,0 +1,44 @@
+package cdc
+
+import (
+	"time"
+)
+
+// Op is the kind of change made to a row.
+type Op string
+
+// Operations a Change can describe.
+const (
+	OpInsert Op = "insert"
+	OpUpdate Op = "update"
+	OpDelete Op = "delete"
+)
+
+// Change is a single row change as it was committed to the database.
+type Change struct {
+	LSN   string                 `json:"lsn"`
+	XID   int64                  `json:"xid"`
+	Table string                 `json:"table"`
+	Op    Op                     `json:"op"`
+	Key   map[string]interface{} `json:"key"`
+	Row   map[string]interface{} `json:"row,omitempty"`
+	Time  time.Time              `json:"time"`
+}
+
+// message is one line of wal2json output in format version 2.
+type message struct {
+	Action    string   `json:"action"`
+	Schema    string   `json:"schema"`
+	Table     string   `json:"table"`
+	Timestamp string   `json:"timestamp"`
+	Columns   []column `json:"columns"`
+	Identity  []column `json:"identity"`
+	PK        []column `json:"pk"`
+}
+
+// column is a column name and value in a wal2json message.
+type column struct {
+	Name  string      `json:"name"`
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
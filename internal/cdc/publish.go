@@ -0,0 +1,79 @@
+package cdc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Publisher delivers change events to their consumers. Publish is called
+// once per committed transaction with its changes in commit order. Delivery
+// is at least once: changes are published again if the process stops before
+// the slot is advanced.
+type Publisher interface {
+	Publish(ctx context.Context, changes []Change) error
+}
+
+// Logger is a Publisher which writes change events to a log. It is useful
+// during development.
+type Logger struct {
+	Log *log.Logger
+}
+
+// Publish implements the Publisher interface.
+func (l Logger) Publish(ctx context.Context, changes []Change) error {
+	for _, c := range changes {
+		l.Log.Printf("cdc : %s %s %v", c.Op, c.Table, c.Key)
+	}
+	return nil
+}
+
+// Webhook is a Publisher which posts change events as JSON to a URL, such as
+// the HTTP endpoint of an event bus.
+type Webhook struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewWebhook constructs a Webhook publisher posting to url.
+func NewWebhook(url string) *Webhook {
+	return &Webhook{
+		URL:    url,
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Publish implements the Publisher interface.
+func (wh *Webhook) Publish(ctx context.Context, changes []Change) error {
+	body := struct {
+		Changes []Change `json:"changes"`
+	}{changes}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return errors.Wrap(err, "encoding changes")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wh.URL, bytes.NewReader(data))
+	if err != nil {
+		return errors.Wrap(err, "creating webhook request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := wh.Client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "calling change webhook")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return errors.Errorf("change webhook responded %d", resp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,176 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/arammikayelyan/garagesale/internal/platform/database"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// Predefined errors for known failure scenarios
+var (
+	ErrNotFound              = errors.New("client not found")
+	ErrInvalidID             = errors.New("ID is not in its proper UUID format")
+	ErrAuthenticationFailure = errors.New("client authentication failed")
+	ErrInvalidScope          = errors.New("requested scope was not granted to the client")
+	ErrUnknownScope          = errors.New("scopes must be known scopes")
+)
+
+// List gives the registered Clients of the tenant of the caller, newest
+// first.
+func List(ctx context.Context, db *sqlx.DB) ([]Client, error) {
+	list := []Client{}
+	q := `SELECT * FROM clients WHERE ` + database.InTenant("tenant_id", 1) + ` ORDER BY date_created DESC`
+	if err := db.SelectContext(ctx, &list, q, auth.TenantScope(ctx)); err != nil {
+		return nil, errors.Wrap(err, "selecting clients")
+	}
+	return list, nil
+}
+
+// Register creates a Client for a service in the tenant of the admin
+// registering it and generates its secret.
+func Register(ctx context.Context, db *sqlx.DB, admin auth.Claims, nc NewClient, now time.Time) (*Registered, error) {
+	if _, err := auth.ParseScopes(strings.Join(nc.Scopes, " ")); err != nil {
+		return nil, ErrUnknownScope
+	}
+	roles := nc.Roles
+	if len(roles) == 0 {
+		roles = []auth.Role{auth.RoleUser}
+	}
+
+	secret, err := newSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	c := Client{
+		ID:          uuid.New().String(),
+		Name:        nc.Name,
+		SecretHash:  hashSecret(secret),
+		Scopes:      nc.Scopes,
+		Roles:       auth.Strings(roles),
+		DateCreated: now.UTC(),
+	}
+	if admin.TenantID != "" {
+		c.TenantID = &admin.TenantID
+	}
+	if admin.Subject != "" {
+		c.CreatedBy = &admin.Subject
+	}
+
+	const q = `
+		INSERT INTO clients
+		(client_id, name, secret_hash, scopes, roles, tenant_id, created_by, date_created)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+	_, err = db.ExecContext(ctx, q, c.ID, c.Name, c.SecretHash, c.Scopes, c.Roles, c.TenantID, c.CreatedBy, c.DateCreated)
+	if err != nil {
+		return nil, errors.Wrap(err, "inserting client")
+	}
+
+	return &Registered{Client: c, Secret: secret}, nil
+}
+
+// Delete removes a Client of the tenant of the caller. Tokens it already
+// obtained stay valid until they expire.
+func Delete(ctx context.Context, db *sqlx.DB, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrInvalidID
+	}
+
+	q := `DELETE FROM clients WHERE client_id = $1 AND ` + database.InTenant("tenant_id", 2)
+	res, err := db.ExecContext(ctx, q, id, auth.TenantScope(ctx))
+	if err != nil {
+		return errors.Wrapf(err, "deleting client %s", id)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// Authenticate checks the secret of a Client and returns the claims of a
+// token for it lasting ttl. The token is restricted to scopes, which must
+// have been granted to the Client, or to all of its scopes when none are
+// requested.
+func Authenticate(ctx context.Context, db *sqlx.DB, id, secret string, scopes []string, ttl time.Duration, now time.Time) (auth.Claims, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return auth.Claims{}, ErrAuthenticationFailure
+	}
+
+	var c Client
+	const q = `SELECT * FROM clients WHERE client_id = $1`
+	if err := db.GetContext(ctx, &c, q, id); err != nil {
+		if err == sql.ErrNoRows {
+			return auth.Claims{}, ErrAuthenticationFailure
+		}
+		return auth.Claims{}, errors.Wrap(err, "selecting client")
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(c.SecretHash)) != 1 {
+		return auth.Claims{}, ErrAuthenticationFailure
+	}
+
+	for _, want := range scopes {
+		granted := false
+		for _, s := range c.Scopes {
+			if s == want {
+				granted = true
+				break
+			}
+		}
+		if !granted {
+			return auth.Claims{}, ErrInvalidScope
+		}
+	}
+	if len(scopes) == 0 {
+		scopes = c.Scopes
+	}
+
+	roles, err := auth.ParseRoles(c.Roles)
+	if err != nil {
+		return auth.Claims{}, errors.Wrap(err, "parsing client roles")
+	}
+
+	const qUsed = `UPDATE clients SET date_last_used = $2 WHERE client_id = $1`
+	if _, err := db.ExecContext(ctx, qUsed, c.ID, now.UTC()); err != nil {
+		return auth.Claims{}, errors.Wrap(err, "recording client use")
+	}
+
+	claims := auth.NewClaims(c.ID, roles, now, ttl)
+	claims.ClientID = c.ID
+	claims.Scopes = scopes
+	claims.Verified = true
+	if c.TenantID != nil {
+		claims.TenantID = *c.TenantID
+	}
+
+	return claims, nil
+}
+
+// newSecret generates a random client secret.
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "generating secret")
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashSecret gives the form a secret is stored in. Secrets are random so a
+// fast hash does not make them easier to guess.
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
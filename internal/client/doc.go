@@ -0,0 +1,4 @@
+// Package client manages the credentials background services use to call the
+// API without a user account. A service trades its client id and secret for a
+// short lived token restricted to the scopes it was granted.
+package client
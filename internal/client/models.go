@@ -0,0 +1,38 @@
+package client
+
+import (
+	"time"
+
+	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/lib/pq"
+)
+
+// Client is a service allowed to obtain tokens with its secret. Tokens carry
+// its roles, tenant and at most its scopes.
+type Client struct {
+	ID           string         `db:"client_id" json:"id"`
+	Name         string         `db:"name" json:"name"`
+	SecretHash   string         `db:"secret_hash" json:"-"`
+	Scopes       pq.StringArray `db:"scopes" json:"scopes"`
+	Roles        pq.StringArray `db:"roles" json:"roles"`
+	TenantID     *string        `db:"tenant_id" json:"tenant_id"`
+	CreatedBy    *string        `db:"created_by" json:"created_by"`
+	DateCreated  time.Time      `db:"date_created" json:"date_created"`
+	DateLastUsed *time.Time     `db:"date_last_used" json:"date_last_used"`
+}
+
+// NewClient is what we require from admins when registering a service. At
+// least one scope is required so its tokens are never unrestricted; roles
+// default to USER.
+type NewClient struct {
+	Name   string      `json:"name" validate:"required"`
+	Scopes []string    `json:"scopes" validate:"required,min=1"`
+	Roles  []auth.Role `json:"roles"`
+}
+
+// Registered is a newly registered Client along with its secret. The secret
+// is only stored hashed so this is the one time it can be seen.
+type Registered struct {
+	Client
+	Secret string `json:"secret"`
+}
@@ -0,0 +1,111 @@
+package consent
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// Predefined errors for known failure scenarios
+var (
+	ErrNotFound  = errors.New("consent not found")
+	ErrInvalidID = errors.New("ID is not in its proper UUID format")
+)
+
+// Retrieve gets the consent of an anonymous visitor by its ID.
+func Retrieve(ctx context.Context, db *sqlx.DB, id string) (*Consent, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	var c Consent
+	const q = `SELECT * FROM consents WHERE consent_id = $1 AND user_id IS NULL`
+	if err := db.GetContext(ctx, &c, q, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "selecting consent")
+	}
+
+	return &c, nil
+}
+
+// RetrieveForUser gets the consent of a user.
+func RetrieveForUser(ctx context.Context, db *sqlx.DB, userID string) (*Consent, error) {
+	var c Consent
+	const q = `SELECT * FROM consents WHERE user_id = $1`
+	if err := db.GetContext(ctx, &c, q, userID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "selecting consent")
+	}
+
+	return &c, nil
+}
+
+// Create records the choices of an anonymous visitor under a new ID, which
+// the visitor keeps to change them later.
+func Create(ctx context.Context, db *sqlx.DB, ch Choices, now time.Time) (*Consent, error) {
+	c := Consent{
+		ID:          uuid.New().String(),
+		Analytics:   ch.Analytics,
+		Marketing:   ch.Marketing,
+		DateCreated: now.UTC(),
+		DateUpdated: now.UTC(),
+	}
+
+	const q = `
+		INSERT INTO consents
+		(consent_id, analytics, marketing, date_created, date_updated)
+		VALUES ($1, $2, $3, $4, $5)`
+	if _, err := db.ExecContext(ctx, q, c.ID, c.Analytics, c.Marketing, c.DateCreated, c.DateUpdated); err != nil {
+		return nil, errors.Wrap(err, "inserting consent")
+	}
+
+	return &c, nil
+}
+
+// Update replaces the choices of an anonymous visitor.
+func Update(ctx context.Context, db *sqlx.DB, id string, ch Choices, now time.Time) (*Consent, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	var c Consent
+	const q = `
+		UPDATE consents SET analytics = $2, marketing = $3, date_updated = $4
+		WHERE consent_id = $1 AND user_id IS NULL
+		RETURNING *`
+	if err := db.GetContext(ctx, &c, q, id, ch.Analytics, ch.Marketing, now.UTC()); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "updating consent")
+	}
+
+	return &c, nil
+}
+
+// SaveForUser records the choices of a user, replacing earlier ones.
+func SaveForUser(ctx context.Context, db *sqlx.DB, userID string, ch Choices, now time.Time) (*Consent, error) {
+	var c Consent
+	const q = `
+		INSERT INTO consents
+		(consent_id, user_id, analytics, marketing, date_created, date_updated)
+		VALUES ($1, $2, $3, $4, $5, $5)
+		ON CONFLICT (user_id) DO UPDATE SET
+			analytics = EXCLUDED.analytics,
+			marketing = EXCLUDED.marketing,
+			date_updated = EXCLUDED.date_updated
+		RETURNING *`
+	if err := db.GetContext(ctx, &c, q, uuid.New().String(), userID, ch.Analytics, ch.Marketing, now.UTC()); err != nil {
+		return nil, errors.Wrap(err, "saving consent")
+	}
+
+	return &c, nil
+}
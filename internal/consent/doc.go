@@ -0,0 +1,4 @@
+// Package consent records the cookie and tracking choices of visitors of the
+// public site and of users, and tracks analytics events only for those who
+// agreed to it.
+package consent
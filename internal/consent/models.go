@@ -0,0 +1,29 @@
+package consent
+
+import "time"
+
+// Cookie is the name of the cookie holding the id of the consent of an
+// anonymous visitor. Clients which can not keep cookies send the id in the
+// Header instead.
+const (
+	Cookie = "consent_id"
+	Header = "X-Consent-ID"
+)
+
+// Consent is what a visitor or user agreed to. Anonymous visitors are known
+// by its ID only; the consent of a user also has their UserID.
+type Consent struct {
+	ID          string    `db:"consent_id" json:"id"`
+	UserID      *string   `db:"user_id" json:"-"`
+	Analytics   bool      `db:"analytics" json:"analytics"`
+	Marketing   bool      `db:"marketing" json:"marketing"`
+	DateCreated time.Time `db:"date_created" json:"date_created"`
+	DateUpdated time.Time `db:"date_updated" json:"date_updated"`
+}
+
+// Choices is what we require to record a consent. Categories left out are
+// not consented to.
+type Choices struct {
+	Analytics bool `json:"analytics"`
+	Marketing bool `json:"marketing"`
+}
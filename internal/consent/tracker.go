@@ -0,0 +1,68 @@
+package consent
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// Tracker records analytics events. Events of visitors and users who did not
+// consent to analytics, or made no choice yet, are dropped.
+type Tracker struct {
+	DB  *sqlx.DB
+	Log *log.Logger
+}
+
+// Track records a request made by the user userID or, for anonymous visitors,
+// under the consent consentID if they consented to analytics. Failures are
+// only logged as tracking must not fail requests.
+func (t *Tracker) Track(ctx context.Context, userID, consentID, method, path string, status int, now time.Time) {
+	id, err := t.consented(ctx, userID, consentID)
+	if err != nil {
+		t.Log.Printf("checking analytics consent : %v", err)
+		return
+	}
+	if id == "" {
+		return
+	}
+
+	const q = `
+		INSERT INTO analytics_events
+		(event_id, consent_id, method, path, status, date_created)
+		VALUES ($1, $2, $3, $4, $5, $6)`
+	if _, err := t.DB.ExecContext(ctx, q, uuid.New().String(), id, method, path, status, now.UTC()); err != nil {
+		t.Log.Printf("tracking %s %s : %v", method, path, err)
+	}
+}
+
+// consented returns the id of the consent to analytics of the user, or of the
+// anonymous visitor when there is no user. It is empty without such consent.
+func (t *Tracker) consented(ctx context.Context, userID, consentID string) (string, error) {
+	var id string
+	var err error
+	switch {
+	case userID != "":
+		const q = `SELECT consent_id FROM consents WHERE user_id = $1 AND analytics`
+		err = t.DB.GetContext(ctx, &id, q, userID)
+	case consentID != "":
+		if _, perr := uuid.Parse(consentID); perr != nil {
+			return "", nil
+		}
+		const q = `SELECT consent_id FROM consents WHERE consent_id = $1 AND user_id IS NULL AND analytics`
+		err = t.DB.GetContext(ctx, &id, q, consentID)
+	default:
+		return "", nil
+	}
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "selecting consent")
+	}
+	return id, nil
+}
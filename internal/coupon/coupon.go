@@ -0,0 +1,209 @@
+package coupon
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/arammikayelyan/garagesale/internal/platform/database"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// Predefined errors for known failure scenarios
+var (
+	ErrNotFound     = errors.New("coupon not found")
+	ErrInvalidID    = errors.New("ID is not in its proper UUID format")
+	ErrForbidden    = errors.New("attempted action is not allowed")
+	ErrCodeTaken    = errors.New("coupon code is already in use")
+	ErrInvalidValue = errors.New("percent coupons can not take more than 100 percent off")
+	ErrExpired      = errors.New("coupon has expired")
+	ErrExhausted    = errors.New("coupon has reached its usage limit")
+)
+
+// uniqueViolation is the Postgres error code for a unique constraint failing.
+const uniqueViolation = "23505"
+
+// normalizeCode makes codes case insensitive.
+func normalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// List gives the Coupons created by a seller, newest first.
+func List(ctx context.Context, db *sqlx.DB, userID string) ([]Coupon, error) {
+	list := []Coupon{}
+	q := `
+		SELECT c.* FROM coupons AS c
+		JOIN users AS u ON u.user_id = c.user_id
+		WHERE c.user_id = $1 AND ` + database.InTenant("u.tenant_id", 2) + `
+		ORDER BY c.date_created DESC`
+	if err := db.SelectContext(ctx, &list, q, userID, auth.TenantScope(ctx)); err != nil {
+		return nil, errors.Wrap(err, "selecting coupons")
+	}
+	return list, nil
+}
+
+// Retrieve gets a single Coupon. Only admins and the seller who created it may
+// see it.
+func Retrieve(ctx context.Context, db *sqlx.DB, user auth.Claims, id string) (*Coupon, error) {
+	return retrieve(ctx, db, user, id, auth.ActionRead)
+}
+
+// retrieve gets a single Coupon if the authorization policy lets user do
+// action to it.
+func retrieve(ctx context.Context, db *sqlx.DB, user auth.Claims, id string, action auth.Action) (*Coupon, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	var c Coupon
+	q := `
+		SELECT c.* FROM coupons AS c
+		JOIN users AS u ON u.user_id = c.user_id
+		WHERE c.coupon_id = $1 AND ` + database.InTenant("u.tenant_id", 2)
+	if err := db.GetContext(ctx, &c, q, id, auth.TenantScope(ctx)); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "selecting coupon")
+	}
+
+	ok, err := user.Can(ctx, action, auth.Resource{Kind: "coupon", ID: c.ID, Owner: c.UserID})
+	if err != nil {
+		return nil, errors.Wrap(err, "authorizing")
+	}
+	if !ok {
+		return nil, ErrForbidden
+	}
+
+	return &c, nil
+}
+
+// Create makes a new Coupon for the products of the calling seller.
+func Create(ctx context.Context, db *sqlx.DB, user auth.Claims, nc NewCoupon, now time.Time) (*Coupon, error) {
+	if nc.Kind == KindPercent && nc.Value > 100 {
+		return nil, ErrInvalidValue
+	}
+
+	c := Coupon{
+		ID:          uuid.New().String(),
+		UserID:      user.Subject,
+		Code:        normalizeCode(nc.Code),
+		Kind:        nc.Kind,
+		Value:       nc.Value,
+		ExpiresAt:   nc.ExpiresAt,
+		MaxUses:     nc.MaxUses,
+		DateCreated: now.UTC(),
+		DateUpdated: now.UTC(),
+	}
+
+	const q = `
+		INSERT INTO coupons
+		(coupon_id, user_id, code, kind, value, expires_at, max_uses, uses, date_created, date_updated)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, 0, $8, $9)`
+	_, err := db.ExecContext(ctx, q, c.ID, c.UserID, c.Code, c.Kind, c.Value, c.ExpiresAt, c.MaxUses, c.DateCreated, c.DateUpdated)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == uniqueViolation {
+			return nil, ErrCodeTaken
+		}
+		return nil, errors.Wrap(err, "inserting coupon")
+	}
+
+	return &c, nil
+}
+
+// Update modifies a Coupon. Only admins and the seller who created it may
+// change it.
+func Update(ctx context.Context, db *sqlx.DB, user auth.Claims, id string, uc UpdateCoupon, now time.Time) (*Coupon, error) {
+	c, err := retrieve(ctx, db, user, id, auth.ActionUpdate)
+	if err != nil {
+		return nil, err
+	}
+
+	if uc.Value != nil {
+		c.Value = *uc.Value
+	}
+	if uc.ExpiresAt != nil {
+		c.ExpiresAt = uc.ExpiresAt
+	}
+	if uc.MaxUses != nil {
+		c.MaxUses = uc.MaxUses
+	}
+	if c.Kind == KindPercent && c.Value > 100 {
+		return nil, ErrInvalidValue
+	}
+	c.DateUpdated = now.UTC()
+
+	const q = `UPDATE coupons SET
+		value = $2,
+		expires_at = $3,
+		max_uses = $4,
+		date_updated = $5
+		WHERE coupon_id = $1`
+	if _, err := db.ExecContext(ctx, q, id, c.Value, c.ExpiresAt, c.MaxUses, c.DateUpdated); err != nil {
+		return nil, errors.Wrap(err, "updating coupon")
+	}
+
+	return c, nil
+}
+
+// Delete removes a Coupon. Sales which already used it keep their discount.
+func Delete(ctx context.Context, db *sqlx.DB, user auth.Claims, id string) error {
+	if _, err := retrieve(ctx, db, user, id, auth.ActionDelete); err != nil {
+		return err
+	}
+
+	const q = `DELETE FROM coupons WHERE coupon_id = $1`
+	if _, err := db.ExecContext(ctx, q, id); err != nil {
+		return errors.Wrapf(err, "deleting coupon %s", id)
+	}
+
+	return nil
+}
+
+// Redeem applies the coupon with code to a sale of a product paying amount
+// and counts the use. It must be called within the transaction recording the
+// sale so the use is only counted when the sale is. The coupon must belong to
+// the seller of the product.
+func Redeem(ctx context.Context, tx *sqlx.Tx, code, productID string, amount int, now time.Time) (couponID string, discount int, err error) {
+	var c Coupon
+	const q = `
+		SELECT c.* FROM coupons AS c
+		JOIN products AS p ON p.user_id = c.user_id
+		WHERE p.product_id = $1 AND c.code = $2
+		FOR UPDATE OF c`
+	if err := tx.GetContext(ctx, &c, q, productID, normalizeCode(code)); err != nil {
+		if err == sql.ErrNoRows {
+			return "", 0, ErrNotFound
+		}
+		return "", 0, errors.Wrap(err, "selecting coupon")
+	}
+
+	if c.ExpiresAt != nil && !now.Before(*c.ExpiresAt) {
+		return "", 0, ErrExpired
+	}
+	if c.MaxUses != nil && c.Uses >= *c.MaxUses {
+		return "", 0, ErrExhausted
+	}
+
+	switch c.Kind {
+	case KindPercent:
+		discount = amount * c.Value / 100
+	case KindFixed:
+		discount = c.Value
+	}
+	if discount > amount {
+		discount = amount
+	}
+
+	const qUse = `UPDATE coupons SET uses = uses + 1 WHERE coupon_id = $1`
+	if _, err := tx.ExecContext(ctx, qUse, c.ID); err != nil {
+		return "", 0, errors.Wrap(err, "counting coupon use")
+	}
+
+	return c.ID, discount, nil
+}
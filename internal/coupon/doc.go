@@ -0,0 +1,3 @@
+// Package coupon implements discount codes sellers hand out and buyers redeem
+// when a sale is recorded.
+package coupon
@@ -0,0 +1,58 @@
+package coupon
+
+import "time"
+
+// Kind is the kind of discount a Coupon gives.
+type Kind string
+
+// These are the kinds of discount a Coupon can give.
+const (
+	KindPercent Kind = "percent"
+	KindFixed   Kind = "fixed"
+)
+
+// Kinds lists every Kind.
+var Kinds = []Kind{KindPercent, KindFixed}
+
+// Valid reports whether k is one of the known kinds.
+func (k Kind) Valid() bool {
+	switch k {
+	case KindPercent, KindFixed:
+		return true
+	}
+	return false
+}
+
+// Coupon is a code which discounts sales of the products of the seller who
+// created it. A percent coupon takes Value percent off the amount paid while
+// a fixed coupon takes Value off it. MaxUses and ExpiresAt are optional.
+type Coupon struct {
+	ID          string     `db:"coupon_id" json:"id"`
+	UserID      string     `db:"user_id" json:"user_id"`
+	Code        string     `db:"code" json:"code"`
+	Kind        Kind       `db:"kind" json:"kind"`
+	Value       int        `db:"value" json:"value"`
+	ExpiresAt   *time.Time `db:"expires_at" json:"expires_at"`
+	MaxUses     *int       `db:"max_uses" json:"max_uses"`
+	Uses        int        `db:"uses" json:"uses"`
+	DateCreated time.Time  `db:"date_created" json:"date_created"`
+	DateUpdated time.Time  `db:"date_updated" json:"date_updated"`
+}
+
+// NewCoupon is what we require from clients to create a Coupon.
+type NewCoupon struct {
+	Code      string     `json:"code" validate:"required,max=64"`
+	Kind      Kind       `json:"kind" validate:"oneof=percent fixed"`
+	Value     int        `json:"value" validate:"gt=0"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	MaxUses   *int       `json:"max_uses" validate:"omitempty,gte=1"`
+}
+
+// UpdateCoupon defines what information may be provided to modify an
+// existing Coupon. All fields are optional so clients can send just the
+// fields they want changed.
+type UpdateCoupon struct {
+	Value     *int       `json:"value" validate:"omitempty,gt=0"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	MaxUses   *int       `json:"max_uses" validate:"omitempty,gte=1"`
+}
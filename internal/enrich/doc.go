@@ -0,0 +1,3 @@
+// Package enrich asks external services to suggest content for new products
+// such as a category, tags and a description.
+package enrich
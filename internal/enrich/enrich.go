@@ -0,0 +1,74 @@
+package enrich
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/arammikayelyan/garagesale/internal/product"
+	"github.com/pkg/errors"
+)
+
+// Enricher suggests content for a Product. The ProductID and DateCreated of
+// the returned Suggestion are filled in by the caller.
+type Enricher interface {
+	Suggest(ctx context.Context, p product.Product) (*product.Suggestion, error)
+}
+
+// HTTP is an Enricher backed by an external service. It posts the name and
+// description of the product as JSON and expects a JSON object with any of the
+// category, tags and description fields in return.
+type HTTP struct {
+	URL    string
+	Key    string
+	Client *http.Client
+}
+
+// NewHTTP constructs an HTTP enricher calling url and authenticating with key
+// as a bearer token when it is not empty.
+func NewHTTP(url, key string) *HTTP {
+	return &HTTP{
+		URL:    url,
+		Key:    key,
+		Client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Suggest implements the Enricher interface.
+func (h *HTTP) Suggest(ctx context.Context, p product.Product) (*product.Suggestion, error) {
+	data, err := json.Marshal(struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	}{p.Name, p.Description})
+	if err != nil {
+		return nil, errors.Wrap(err, "encoding enrichment request")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.Wrap(err, "creating enrichment request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.Key != "" {
+		req.Header.Set("Authorization", "Bearer "+h.Key)
+	}
+
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "calling enrichment service")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("enrichment service responded %d", resp.StatusCode)
+	}
+
+	var s product.Suggestion
+	if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+		return nil, errors.Wrap(err, "decoding enrichment response")
+	}
+
+	return &s, nil
+}
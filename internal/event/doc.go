@@ -0,0 +1,2 @@
+// Package event implements all business logic regarding garage sale events.
+package event
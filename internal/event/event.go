@@ -0,0 +1,234 @@
+package event
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/arammikayelyan/garagesale/internal/platform/database"
+	"github.com/arammikayelyan/garagesale/internal/product"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// Predefined errors for known failure scenarios
+var (
+	ErrNotFound        = errors.New("event not found")
+	ErrProductNotFound = errors.New("product not found")
+	ErrInvalidID       = errors.New("id provided was not a valid UUID")
+	ErrForbidden       = errors.New("attempted action is not allowed")
+)
+
+// Create schedules a new Event owned by the calling user.
+func Create(ctx context.Context, db *sqlx.DB, user auth.Claims, ne NewEvent, now time.Time) (*Event, error) {
+	e := Event{
+		ID:          uuid.New().String(),
+		UserID:      user.Subject,
+		Name:        ne.Name,
+		Address:     ne.Address,
+		Latitude:    ne.Latitude,
+		Longitude:   ne.Longitude,
+		StartsAt:    ne.StartsAt.UTC(),
+		EndsAt:      ne.EndsAt.UTC(),
+		DateCreated: now.UTC(),
+		DateUpdated: now.UTC(),
+	}
+
+	const q = `
+		INSERT INTO events
+		(event_id, user_id, name, address, latitude, longitude, starts_at, ends_at, date_created, date_updated)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+
+	_, err := db.ExecContext(ctx, q,
+		e.ID, e.UserID, e.Name, e.Address,
+		e.Latitude, e.Longitude,
+		e.StartsAt, e.EndsAt,
+		e.DateCreated, e.DateUpdated,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "inserting event")
+	}
+
+	return &e, nil
+}
+
+// Retrieve gets a single Event from the DB.
+func Retrieve(ctx context.Context, db *sqlx.DB, id string) (*Event, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	var e Event
+
+	q := `
+		SELECT e.* FROM events AS e
+		JOIN users AS u ON u.user_id = e.user_id
+		WHERE e.event_id = $1 AND ` + database.InTenant("u.tenant_id", 2)
+
+	if err := db.GetContext(ctx, &e, q, id, auth.TenantScope(ctx)); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "selecting event")
+	}
+
+	return &e, nil
+}
+
+// ListUpcoming gives all Events which have not ended yet ordered by their
+// start time. If near is not nil only events within the requested radius are
+// returned.
+func ListUpcoming(ctx context.Context, db *sqlx.DB, now time.Time, near *Near) ([]Event, error) {
+	list := []Event{}
+
+	if near == nil {
+		q := `
+			SELECT e.* FROM events AS e
+			JOIN users AS u ON u.user_id = e.user_id
+			WHERE e.ends_at >= $1 AND ` + database.InTenant("u.tenant_id", 2) + `
+			ORDER BY e.starts_at`
+		if err := db.SelectContext(ctx, &list, q, now.UTC(), auth.TenantScope(ctx)); err != nil {
+			return nil, errors.Wrap(err, "selecting events")
+		}
+		return list, nil
+	}
+
+	// Great-circle distance using the spherical law of cosines. LEAST guards
+	// against floating point results slightly above 1 which ACOS rejects.
+	q := `
+		SELECT e.* FROM events AS e
+		JOIN users AS u ON u.user_id = e.user_id
+		WHERE e.ends_at >= $1
+		AND 6371 * ACOS(LEAST(1,
+			COS(RADIANS($2)) * COS(RADIANS(e.latitude)) * COS(RADIANS(e.longitude) - RADIANS($3)) +
+			SIN(RADIANS($2)) * SIN(RADIANS(e.latitude))
+		)) <= $4
+		AND ` + database.InTenant("u.tenant_id", 5) + `
+		ORDER BY e.starts_at`
+
+	if err := db.SelectContext(ctx, &list, q, now.UTC(), near.Latitude, near.Longitude, near.RadiusKM, auth.TenantScope(ctx)); err != nil {
+		return nil, errors.Wrap(err, "selecting events")
+	}
+
+	return list, nil
+}
+
+// ListUpcomingByUser gives all Events of a single seller which have not ended
+// yet ordered by their start time.
+func ListUpcomingByUser(ctx context.Context, db *sqlx.DB, now time.Time, userID string) ([]Event, error) {
+	if _, err := uuid.Parse(userID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	list := []Event{}
+
+	q := `
+		SELECT e.* FROM events AS e
+		JOIN users AS u ON u.user_id = e.user_id
+		WHERE e.user_id = $1 AND e.ends_at >= $2 AND ` + database.InTenant("u.tenant_id", 3) + `
+		ORDER BY e.starts_at`
+	if err := db.SelectContext(ctx, &list, q, userID, now.UTC(), auth.TenantScope(ctx)); err != nil {
+		return nil, errors.Wrap(err, "selecting events")
+	}
+
+	return list, nil
+}
+
+// AddProduct attaches a Product to an Event. The caller must be allowed to
+// change both, so sellers can only attach their own products to their own
+// events while admins may attach any.
+func AddProduct(ctx context.Context, db *sqlx.DB, user auth.Claims, eventID, productID string) error {
+	e, err := Retrieve(ctx, db, eventID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := uuid.Parse(productID); err != nil {
+		return ErrInvalidID
+	}
+
+	if err := authorize(ctx, user, auth.ActionUpdate, e); err != nil {
+		return err
+	}
+
+	p, err := product.Retrieve(ctx, db, productID)
+	if err != nil {
+		if err == product.ErrNotFound {
+			return ErrProductNotFound
+		}
+		return errors.Wrapf(err, "selecting product %q", productID)
+	}
+	ok, err := user.Can(ctx, auth.ActionUpdate, auth.Resource{Kind: "product", ID: p.ID, Owner: p.UserID})
+	if err != nil {
+		return errors.Wrap(err, "authorizing")
+	}
+	if !ok {
+		return ErrForbidden
+	}
+
+	const q = `
+		INSERT INTO event_products (event_id, product_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING`
+
+	if _, err := db.ExecContext(ctx, q, eventID, productID); err != nil {
+		return errors.Wrap(err, "attaching product to event")
+	}
+
+	return nil
+}
+
+// RemoveProduct detaches a Product from an Event. Only the owner of the event
+// or an admin may do so.
+func RemoveProduct(ctx context.Context, db *sqlx.DB, user auth.Claims, eventID, productID string) error {
+	e, err := Retrieve(ctx, db, eventID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := uuid.Parse(productID); err != nil {
+		return ErrInvalidID
+	}
+
+	if err := authorize(ctx, user, auth.ActionUpdate, e); err != nil {
+		return err
+	}
+
+	const q = `DELETE FROM event_products WHERE event_id = $1 AND product_id = $2`
+
+	if _, err := db.ExecContext(ctx, q, eventID, productID); err != nil {
+		return errors.Wrap(err, "detaching product from event")
+	}
+
+	return nil
+}
+
+// ProductIDs gives the ids of all Products attached to an Event.
+func ProductIDs(ctx context.Context, db *sqlx.DB, eventID string) ([]string, error) {
+	if _, err := uuid.Parse(eventID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	ids := []string{}
+
+	const q = `SELECT product_id FROM event_products WHERE event_id = $1`
+	if err := db.SelectContext(ctx, &ids, q, eventID); err != nil {
+		return nil, errors.Wrap(err, "selecting event products")
+	}
+
+	return ids, nil
+}
+
+// authorize checks the authorization policy lets user do action to e.
+func authorize(ctx context.Context, user auth.Claims, action auth.Action, e *Event) error {
+	ok, err := user.Can(ctx, action, auth.Resource{Kind: "event", ID: e.ID, Owner: e.UserID})
+	if err != nil {
+		return errors.Wrap(err, "authorizing")
+	}
+	if !ok {
+		return ErrForbidden
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package event
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// icalTime is the UTC date-time layout required by RFC 5545.
+const icalTime = "20060102T150405Z"
+
+// icalEscaper escapes the characters which have a special meaning inside
+// iCalendar TEXT values.
+var icalEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	`;`, `\;`,
+	`,`, `\,`,
+	"\r\n", `\n`,
+	"\n", `\n`,
+)
+
+// WriteICal writes the provided events to w as an iCalendar (RFC 5545)
+// document that calendar applications can import or subscribe to. The name is
+// shown by most applications as the title of a subscribed calendar and may be
+// left blank.
+func WriteICal(w io.Writer, name string, events []Event, now time.Time) error {
+	bw := bufio.NewWriter(w)
+
+	writeICalLine(bw, "BEGIN:VCALENDAR")
+	writeICalLine(bw, "VERSION:2.0")
+	writeICalLine(bw, "PRODID:-//garagesale//sales-api//EN")
+	writeICalLine(bw, "CALSCALE:GREGORIAN")
+	writeICalLine(bw, "METHOD:PUBLISH")
+	if name != "" {
+		writeICalLine(bw, "X-WR-CALNAME:"+icalEscaper.Replace(name))
+	}
+
+	// Hint subscribers to refresh the feed every hour (RFC 7986).
+	writeICalLine(bw, "REFRESH-INTERVAL;VALUE=DURATION:PT1H")
+	writeICalLine(bw, "X-PUBLISHED-TTL:PT1H")
+
+	for _, e := range events {
+		writeICalLine(bw, "BEGIN:VEVENT")
+		writeICalLine(bw, "UID:"+e.ID+"@garagesale")
+		writeICalLine(bw, "DTSTAMP:"+now.UTC().Format(icalTime))
+		writeICalLine(bw, "DTSTART:"+e.StartsAt.UTC().Format(icalTime))
+		writeICalLine(bw, "DTEND:"+e.EndsAt.UTC().Format(icalTime))
+		writeICalLine(bw, "LAST-MODIFIED:"+e.DateUpdated.UTC().Format(icalTime))
+		writeICalLine(bw, "SUMMARY:"+icalEscaper.Replace(e.Name))
+		writeICalLine(bw, "LOCATION:"+icalEscaper.Replace(e.Address))
+		writeICalLine(bw, fmt.Sprintf("GEO:%f;%f", e.Latitude, e.Longitude))
+		writeICalLine(bw, "END:VEVENT")
+	}
+
+	writeICalLine(bw, "END:VCALENDAR")
+
+	return bw.Flush()
+}
+
+// writeICalLine writes a single content line terminated by CRLF. Lines longer
+// than 75 octets are folded onto continuation lines beginning with a space
+// without splitting multi-byte characters.
+func writeICalLine(w *bufio.Writer, line string) {
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		w.WriteString(line[:cut])
+		w.WriteString("\r\n ")
+		line = line[cut:]
+
+		// The leading space of a continuation line counts toward its length.
+		limit = 74
+	}
+	w.WriteString(line)
+	w.WriteString("\r\n")
+}
@@ -0,0 +1,40 @@
+package event
+
+import "time"
+
+// Event is a physical garage sale day held by a seller at some address.
+type Event struct {
+	ID          string    `db:"event_id" json:"id"`
+	UserID      string    `db:"user_id" json:"user_id"`
+	Name        string    `db:"name" json:"name"`
+	Address     string    `db:"address" json:"address"`
+	Latitude    float64   `db:"latitude" json:"latitude"`
+	Longitude   float64   `db:"longitude" json:"longitude"`
+	StartsAt    time.Time `db:"starts_at" json:"starts_at"`
+	EndsAt      time.Time `db:"ends_at" json:"ends_at"`
+	DateCreated time.Time `db:"date_created" json:"date_created"`
+	DateUpdated time.Time `db:"date_updated" json:"date_updated"`
+}
+
+// NewEvent is what we require from clients when scheduling a new Event.
+type NewEvent struct {
+	Name      string    `json:"name" validate:"required"`
+	Address   string    `json:"address" validate:"required"`
+	Latitude  float64   `json:"latitude" validate:"gte=-90,lte=90"`
+	Longitude float64   `json:"longitude" validate:"gte=-180,lte=180"`
+	StartsAt  time.Time `json:"starts_at" validate:"required"`
+	EndsAt    time.Time `json:"ends_at" validate:"required,gtfield=StartsAt"`
+}
+
+// NewEventProduct identifies a Product to be offered at an Event.
+type NewEventProduct struct {
+	ProductID string `json:"product_id" validate:"required"`
+}
+
+// Near limits an Event listing to events within RadiusKM kilometers of a
+// geographic point.
+type Near struct {
+	Latitude  float64
+	Longitude float64
+	RadiusKM  float64
+}
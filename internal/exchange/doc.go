@@ -0,0 +1,4 @@
+// Package exchange converts amounts between currencies using daily exchange
+// rates. Rates are fetched from a Provider once per day and currency and kept
+// in the database so reports can convert in SQL.
+package exchange
@@ -0,0 +1,107 @@
+package exchange
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// Provider gives the rates of every currency it knows against base on a day.
+// A rate is how many units of the quoted currency one unit of base buys.
+type Provider interface {
+	Rates(ctx context.Context, day time.Time, base string) (map[string]float64, error)
+}
+
+// HTTP is a Provider backed by a Frankfurter compatible API which serves the
+// rates of a day at /<YYYY-MM-DD>?from=<base>.
+type HTTP struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewHTTP constructs an HTTP provider for the API at url.
+func NewHTTP(url string) *HTTP {
+	return &HTTP{
+		URL:    strings.TrimSuffix(url, "/"),
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Rates implements the Provider interface.
+func (h *HTTP) Rates(ctx context.Context, day time.Time, base string) (map[string]float64, error) {
+	url := fmt.Sprintf("%s/%s?from=%s", h.URL, day.Format("2006-01-02"), base)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating rates request")
+	}
+
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "calling rates provider")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("rates provider responded %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Rates map[string]float64 `json:"rates"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, errors.Wrap(err, "decoding rates")
+	}
+
+	return body.Rates, nil
+}
+
+// Rates keeps the daily rates fetched from a Provider in the database.
+type Rates struct {
+	DB       *sqlx.DB
+	Provider Provider
+}
+
+// Ensure makes sure the rates against base on day are stored, fetching them
+// from the provider when they are not.
+func (r *Rates) Ensure(ctx context.Context, day time.Time, base string) error {
+	day = day.UTC().Truncate(24 * time.Hour)
+	base = strings.ToUpper(base)
+
+	var stored bool
+	const qStored = `SELECT EXISTS (SELECT 1 FROM exchange_rates WHERE day = $1 AND base = $2)`
+	if err := r.DB.GetContext(ctx, &stored, qStored, day, base); err != nil {
+		return errors.Wrap(err, "checking stored rates")
+	}
+	if stored {
+		return nil
+	}
+
+	rates, err := r.Provider.Rates(ctx, day, base)
+	if err != nil {
+		return errors.Wrapf(err, "fetching %s rates for %s", base, day.Format("2006-01-02"))
+	}
+
+	tx, err := r.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	const q = `
+		INSERT INTO exchange_rates (day, base, quote, rate)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT DO NOTHING`
+	for quote, rate := range rates {
+		if _, err := tx.ExecContext(ctx, q, day, base, strings.ToUpper(quote), rate); err != nil {
+			return errors.Wrap(err, "storing rate")
+		}
+	}
+
+	return errors.Wrap(tx.Commit(), "committing rates")
+}
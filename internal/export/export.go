@@ -0,0 +1,383 @@
+// Package export produces large CSV exports in the background. Each export
+// is stored in blob storage once done and downloaded through a signed URL,
+// rather than holding a request open while it is produced.
+package export
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/arammikayelyan/garagesale/internal/platform/blob"
+	"github.com/arammikayelyan/garagesale/internal/platform/database"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrNotFound is returned when there is no export with an ID or it
+	// belongs to someone else.
+	ErrNotFound = errors.New("export not found")
+
+	// ErrUnknownKind is returned when an export of a kind nobody produces is
+	// requested.
+	ErrUnknownKind = errors.New("unknown export kind")
+
+	// ErrNotReady is returned when an export is downloaded before it is done.
+	ErrNotReady = errors.New("export is not done yet")
+
+	// ErrInvalidSignature is returned when a download URL was not signed by
+	// us or has expired.
+	ErrInvalidSignature = errors.New("download link is invalid or has expired")
+)
+
+// Status is the state of an export.
+type Status string
+
+// These are the states an export moves through.
+const (
+	StatusPending Status = "pending"
+	StatusRunning Status = "running"
+	StatusDone    Status = "done"
+	StatusFailed  Status = "failed"
+)
+
+// Export is a requested export. Params are the filters it was requested with
+// in query string form. Admin records whether it was requested by an admin,
+// who may export everyone's data within their tenant. TenantID is the tenant
+// of whoever requested it, which the export is produced for.
+type Export struct {
+	ID           string     `db:"export_id" json:"id"`
+	UserID       string     `db:"user_id" json:"user_id"`
+	TenantID     *string    `db:"tenant_id" json:"-"`
+	Admin        bool       `db:"admin" json:"-"`
+	Kind         string     `db:"kind" json:"kind"`
+	Params       string     `db:"params" json:"params"`
+	Status       Status     `db:"status" json:"status"`
+	Rows         int        `db:"rows" json:"rows"`
+	Error        *string    `db:"error" json:"error,omitempty"`
+	DateCreated  time.Time  `db:"date_created" json:"date_created"`
+	DateStarted  *time.Time `db:"date_started" json:"date_started,omitempty"`
+	DateFinished *time.Time `db:"date_finished" json:"date_finished,omitempty"`
+
+	// URL is where a finished export is downloaded from, valid until
+	// URLExpires. It is set by Runner.Retrieve.
+	URL        string     `db:"-" json:"url,omitempty"`
+	URLExpires *time.Time `db:"-" json:"url_expires,omitempty"`
+}
+
+// Query gives the filters of the export.
+func (e Export) Query() url.Values {
+	v, _ := url.ParseQuery(e.Params)
+	return v
+}
+
+// Producer writes the rows of an export to w as CSV. It returns the number of
+// rows written, not counting the header. Its context carries Claims of the
+// tenant of the export so the rows are limited to it as for a request.
+type Producer func(ctx context.Context, w io.Writer, e Export) (int, error)
+
+// Runner runs exports on a pool of workers. Exports are kept in the database
+// so they survive restarts and several processes can share the work.
+type Runner struct {
+	db        *sqlx.DB
+	store     blob.Store
+	signer    blob.Signer
+	workers   int
+	retention time.Duration
+	urlTTL    time.Duration
+
+	// Exports left running for longer than stale are assumed to belong to
+	// a process which died and are started again.
+	stale time.Duration
+
+	wake chan struct{}
+
+	mu        sync.RWMutex
+	producers map[string]Producer
+}
+
+// Config is how a Runner stores and hands out exports.
+type Config struct {
+	Store     blob.Store
+	Signer    blob.Signer
+	Workers   int
+	Retention time.Duration
+	URLTTL    time.Duration
+}
+
+// NewRunner constructs a Runner. Exports are deleted after the retention
+// period and download URLs stay valid for URLTTL.
+func NewRunner(db *sqlx.DB, cfg Config) *Runner {
+	if cfg.Workers < 1 {
+		cfg.Workers = 1
+	}
+	return &Runner{
+		db:        db,
+		store:     cfg.Store,
+		signer:    cfg.Signer,
+		workers:   cfg.Workers,
+		retention: cfg.Retention,
+		urlTTL:    cfg.URLTTL,
+		stale:     time.Hour,
+		wake:      make(chan struct{}, 1),
+		producers: make(map[string]Producer),
+	}
+}
+
+// Register makes exports of kind be produced by p.
+func (r *Runner) Register(kind string, p Producer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.producers[kind] = p
+}
+
+// producer gives the Producer of kind.
+func (r *Runner) producer(kind string) (Producer, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	p, ok := r.producers[kind]
+	return p, ok
+}
+
+// Start records an export of kind for a user to be produced by the workers.
+// The export belongs to the tenant of the caller.
+func (r *Runner) Start(ctx context.Context, userID string, admin bool, kind string, params url.Values, now time.Time) (*Export, error) {
+	if _, ok := r.producer(kind); !ok {
+		return nil, ErrUnknownKind
+	}
+
+	e := Export{
+		ID:          uuid.New().String(),
+		UserID:      userID,
+		TenantID:    tenantOf(ctx),
+		Admin:       admin,
+		Kind:        kind,
+		Params:      params.Encode(),
+		Status:      StatusPending,
+		DateCreated: now.UTC(),
+	}
+
+	const q = `
+		INSERT INTO exports
+		(export_id, user_id, tenant_id, admin, kind, params, status, rows, date_created)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, 0, $8)`
+	if _, err := r.db.ExecContext(ctx, q, e.ID, e.UserID, e.TenantID, e.Admin, e.Kind, e.Params, e.Status, e.DateCreated); err != nil {
+		return nil, errors.Wrap(err, "inserting export")
+	}
+
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+
+	return &e, nil
+}
+
+// Retrieve gets an export. Users only see their own exports unless all is
+// set, and never those of another tenant. A finished export comes with a
+// signed download URL under base.
+func (r *Runner) Retrieve(ctx context.Context, id, userID string, all bool, base string, now time.Time) (*Export, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrNotFound
+	}
+
+	var e Export
+	q := `SELECT * FROM exports WHERE export_id = $1 AND ` + database.InTenant("tenant_id", 2)
+	if err := r.db.GetContext(ctx, &e, q, id, auth.TenantScope(ctx)); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrapf(err, "selecting export %q", id)
+	}
+	if !all && e.UserID != userID {
+		return nil, ErrNotFound
+	}
+
+	if e.Status == StatusDone {
+		expires := now.Add(r.urlTTL).UTC().Truncate(time.Second)
+		v := url.Values{
+			"expires":   {fmt.Sprint(expires.Unix())},
+			"signature": {r.signer.Sign(e.ID, expires)},
+		}
+		e.URL = base + "/" + e.ID + "/download?" + v.Encode()
+		e.URLExpires = &expires
+	}
+
+	return &e, nil
+}
+
+// Open gives the content of a finished export when signature was made by
+// Retrieve for it and has not expired. Whoever holds the URL may download the
+// export.
+func (r *Runner) Open(ctx context.Context, id string, expires time.Time, signature string, now time.Time) (io.ReadCloser, error) {
+	if !r.signer.Verify(id, expires, signature, now) {
+		return nil, ErrInvalidSignature
+	}
+
+	rc, err := r.store.Open(ctx, key(id))
+	if err != nil {
+		if err == blob.ErrNotFound {
+			return nil, ErrNotReady
+		}
+		return nil, errors.Wrapf(err, "opening export %q", id)
+	}
+	return rc, nil
+}
+
+// Run produces pending exports until ctx is cancelled. Exports past the
+// retention period are deleted along the way.
+func (r *Runner) Run(ctx context.Context, interval time.Duration) {
+	var wg sync.WaitGroup
+	for i := 0; i < r.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.work(ctx, interval)
+		}()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case <-ticker.C:
+			r.prune(ctx, time.Now())
+		}
+	}
+}
+
+// work produces exports one at a time, waiting for new ones when there are
+// none pending.
+func (r *Runner) work(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			e, err := r.claim(ctx, time.Now())
+			if err != nil || e == nil {
+				break
+			}
+			r.produce(ctx, e)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// claim marks the oldest pending export as running and returns it, or nil
+// when there is none. Exports running since before the stale period are
+// claimed again.
+func (r *Runner) claim(ctx context.Context, now time.Time) (*Export, error) {
+	now = now.UTC()
+
+	var e Export
+	const q = `
+		UPDATE exports SET status = $1, date_started = $2
+		WHERE export_id = (
+			SELECT export_id FROM exports
+			WHERE status = $3 OR (status = $1 AND date_started < $4)
+			ORDER BY date_created
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`
+	if err := r.db.GetContext(ctx, &e, q, StatusRunning, now, StatusPending, now.Add(-r.stale)); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "claiming export")
+	}
+	return &e, nil
+}
+
+// produce writes an export to the store and records how it went.
+func (r *Runner) produce(ctx context.Context, e *Export) {
+	rows, err := r.write(ctx, e)
+
+	status, msg := StatusDone, (*string)(nil)
+	if err != nil {
+		status = StatusFailed
+		s := err.Error()
+		msg = &s
+	}
+
+	const q = `
+		UPDATE exports SET status = $2, rows = $3, error = $4, date_finished = $5
+		WHERE export_id = $1`
+	r.db.ExecContext(ctx, q, e.ID, status, rows, msg, time.Now().UTC())
+}
+
+// write runs the Producer of an export into the store.
+func (r *Runner) write(ctx context.Context, e *Export) (int, error) {
+	p, ok := r.producer(e.Kind)
+	if !ok {
+		return 0, ErrUnknownKind
+	}
+
+	// The workers run without a request, so the export is limited to the
+	// tenant it was requested in as the request would have been.
+	var claims auth.Claims
+	if e.TenantID != nil {
+		claims.TenantID = *e.TenantID
+	}
+	ctx = context.WithValue(ctx, auth.Key, claims)
+
+	// The export streams into the store. A failing producer fails the Put
+	// so nothing is left behind.
+	pr, pw := io.Pipe()
+	var rows int
+	go func() {
+		var err error
+		rows, err = p(ctx, pw, *e)
+		pw.CloseWithError(err)
+	}()
+
+	if err := r.store.Put(ctx, key(e.ID), pr); err != nil {
+		pr.CloseWithError(err)
+		return 0, errors.Wrap(err, "storing export")
+	}
+	return rows, nil
+}
+
+// prune deletes the exports created more than the retention period ago.
+func (r *Runner) prune(ctx context.Context, now time.Time) {
+	var ids []string
+	const q = `DELETE FROM exports WHERE date_created < $1 RETURNING export_id`
+	if err := r.db.SelectContext(ctx, &ids, q, now.UTC().Add(-r.retention)); err != nil {
+		return
+	}
+	for _, id := range ids {
+		r.store.Delete(ctx, key(id))
+	}
+}
+
+// tenantOf gives the tenant of the caller in ctx, or nil when they belong to
+// none.
+func tenantOf(ctx context.Context) *string {
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok || claims.TenantID == "" {
+		return nil
+	}
+	return &claims.TenantID
+}
+
+// key gives the blob an export is stored in.
+func key(id string) string {
+	return "exports/" + id + ".csv"
+}
@@ -0,0 +1,5 @@
+// Package fix implements the data corrections ops runs with sales-admin fix
+// instead of hand written SQL. Every correction runs in a transaction and is
+// reported before anything is kept: a dry run rolls it back, while applying
+// it commits and records an audit entry.
+package fix
@@ -0,0 +1,188 @@
+package fix
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/arammikayelyan/garagesale/internal/user"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// ErrNoActor is returned when a correction is applied without saying who
+// applies it.
+var ErrNoActor = errors.New("applying a fix requires naming who applies it")
+
+// Report tells what a correction changed, or would change in a dry run.
+type Report struct {
+	Kind    string   `json:"kind"`
+	Args    []string `json:"args"`
+	Changes []Change `json:"changes"`
+}
+
+// Change is the number of rows of some kind a correction changed.
+type Change struct {
+	What string `json:"what"`
+	Rows int64  `json:"rows"`
+}
+
+// Fix is a correction made within a transaction.
+type Fix func(ctx context.Context, tx *sqlx.Tx, now time.Time) (*Report, error)
+
+// Run makes the correction fix in a transaction and reports what it changed.
+// Unless apply is set the transaction is rolled back so nothing is kept.
+// Applied corrections are audited along with actor, who applied them.
+func Run(ctx context.Context, db *sqlx.DB, fix Fix, apply bool, actor string, now time.Time) (*Report, error) {
+	if apply && actor == "" {
+		return nil, ErrNoActor
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	r, err := fix(ctx, tx, now)
+	if err != nil {
+		return nil, err
+	}
+	if !apply {
+		return r, nil
+	}
+
+	changes, err := json.Marshal(r.Changes)
+	if err != nil {
+		return nil, errors.Wrap(err, "encoding changes")
+	}
+	const q = `
+		INSERT INTO data_fixes
+		(fix_id, kind, args, changes, actor, date_applied)
+		VALUES ($1, $2, $3, $4, $5, $6)`
+	if _, err := tx.ExecContext(ctx, q, uuid.New().String(), r.Kind, pq.StringArray(r.Args), changes, actor, now.UTC()); err != nil {
+		return nil, errors.Wrap(err, "auditing fix")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing fix")
+	}
+
+	return r, nil
+}
+
+// ReassignProducts moves products from the user fromID to the user toID. Only
+// the products in productIDs are moved when any are given, and they must all
+// belong to fromID. Both users must belong to the same tenant.
+func ReassignProducts(fromID, toID string, productIDs []string) Fix {
+	return func(ctx context.Context, tx *sqlx.Tx, now time.Time) (*Report, error) {
+		for _, id := range append([]string{fromID, toID}, productIDs...) {
+			if _, err := uuid.Parse(id); err != nil {
+				return nil, errors.Errorf("%q is not a UUID", id)
+			}
+		}
+
+		// Products only move between users of the same tenant so they never
+		// leave the tenant they were listed in.
+		var from, to struct {
+			TenantID *string `db:"tenant_id"`
+		}
+		const qUser = `SELECT tenant_id FROM users WHERE user_id = $1`
+		if err := tx.GetContext(ctx, &to, qUser, toID); err != nil {
+			if err == sql.ErrNoRows {
+				return nil, errors.Errorf("user %s not found", toID)
+			}
+			return nil, errors.Wrap(err, "selecting user")
+		}
+		if err := tx.GetContext(ctx, &from, qUser, fromID); err != nil {
+			if err == sql.ErrNoRows {
+				return nil, errors.Errorf("user %s not found", fromID)
+			}
+			return nil, errors.Wrap(err, "selecting user")
+		}
+		a, b := from.TenantID, to.TenantID
+		if (a == nil) != (b == nil) || (a != nil && *a != *b) {
+			return nil, errors.Errorf("users %s and %s belong to different tenants", fromID, toID)
+		}
+
+		const q = `
+			UPDATE products SET user_id = $2, date_updated = $3
+			WHERE user_id = $1 AND (CARDINALITY($4::UUID[]) = 0 OR product_id = ANY($4::UUID[]))`
+		res, err := tx.ExecContext(ctx, q, fromID, toID, now.UTC(), pq.StringArray(productIDs))
+		if err != nil {
+			return nil, errors.Wrap(err, "reassigning products")
+		}
+		moved, err := res.RowsAffected()
+		if err != nil {
+			return nil, errors.Wrap(err, "counting products")
+		}
+		if len(productIDs) > 0 && moved != int64(len(productIDs)) {
+			return nil, errors.Errorf("only %d of the %d products belong to user %s", moved, len(productIDs), fromID)
+		}
+
+		r := Report{
+			Kind:    "reassign-products",
+			Args:    append([]string{fromID, toID}, productIDs...),
+			Changes: []Change{{What: "products", Rows: moved}},
+		}
+		return &r, nil
+	}
+}
+
+// MergeUsers merges the user fromID into the user intoID as user.Merge does.
+func MergeUsers(fromID, intoID string) Fix {
+	return func(ctx context.Context, tx *sqlx.Tx, now time.Time) (*Report, error) {
+		mr, err := user.Merge(ctx, tx, fromID, intoID, now)
+		if err != nil {
+			return nil, err
+		}
+
+		r := Report{
+			Kind: "merge-users",
+			Args: []string{fromID, intoID},
+		}
+		for _, m := range mr.Moved {
+			r.Changes = append(r.Changes, Change{What: m.Kind, Rows: m.Rows})
+		}
+		r.Changes = append(r.Changes, Change{What: "deactivated users", Rows: 1})
+		return &r, nil
+	}
+}
+
+// RecomputeRollups recounts the units sold and revenue kept on products from
+// their sales, for when the counters drifted such as after sales were changed
+// with the triggers disabled.
+func RecomputeRollups() Fix {
+	return func(ctx context.Context, tx *sqlx.Tx, now time.Time) (*Report, error) {
+		const q = `
+			WITH actual AS (
+				SELECT p.product_id,
+					COALESCE(SUM(s.quantity) FILTER (WHERE s.status <> 'cancelled'), 0) AS sold,
+					COALESCE(SUM(s.paid) FILTER (WHERE s.status = 'paid'), 0) AS revenue
+				FROM products AS p
+				LEFT JOIN sales AS s ON s.product_id = p.product_id
+				GROUP BY p.product_id
+			)
+			UPDATE products AS p SET sold = a.sold, revenue = a.revenue
+			FROM actual AS a
+			WHERE a.product_id = p.product_id AND (a.sold <> p.sold OR a.revenue <> p.revenue)`
+		res, err := tx.ExecContext(ctx, q)
+		if err != nil {
+			return nil, errors.Wrap(err, "recounting product sales")
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return nil, errors.Wrap(err, "counting products")
+		}
+
+		r := Report{
+			Kind:    "rollups",
+			Args:    []string{},
+			Changes: []Change{{What: "product sales counters", Rows: n}},
+		}
+		return &r, nil
+	}
+}
@@ -0,0 +1,5 @@
+// Package fraud scores the risk of checkouts before they are completed. A
+// Scorer rates each checkout, thresholds decide whether it goes ahead, waits
+// for an admin to review it or is rejected, and every decision is recorded
+// for audit.
+package fraud
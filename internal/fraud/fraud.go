@@ -0,0 +1,211 @@
+package fraud
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// Predefined errors identify expected failure conditions.
+var (
+	// ErrNotFound is used when a specific Decision is requested but does
+	// not exist.
+	ErrNotFound = errors.New("decision not found")
+
+	// ErrInvalidID is used when an invalid UUID is provided.
+	ErrInvalidID = errors.New("ID is not in its proper UUID format")
+
+	// ErrReviewed is used when reviewing a Decision which is not waiting
+	// for a review.
+	ErrReviewed = errors.New("decision is not waiting for review")
+)
+
+// Scorer rates how risky an Order is.
+type Scorer interface {
+	Score(ctx context.Context, o Order, now time.Time) (Assessment, error)
+}
+
+// Thresholds turn scores into actions. Orders scoring at least Reject are
+// rejected and those scoring at least Review are held for review. A zero
+// threshold is never reached.
+type Thresholds struct {
+	Review int
+	Reject int
+}
+
+// Decide gives the action for score.
+func (t Thresholds) Decide(score int) string {
+	switch {
+	case t.Reject > 0 && score >= t.Reject:
+		return ActionReject
+	case t.Review > 0 && score >= t.Review:
+		return ActionReview
+	default:
+		return ActionAllow
+	}
+}
+
+// Assess scores an Order with s and records the Decision taken. An Order an
+// admin approved after review is let through once without scoring it again.
+func Assess(ctx context.Context, db *sqlx.DB, s Scorer, t Thresholds, o Order, now time.Time) (*Decision, error) {
+	var approved Decision
+	const qApproved = `
+		UPDATE fraud_decisions SET date_used = $3
+		WHERE decision_id = (
+			SELECT decision_id FROM fraud_decisions
+			WHERE user_id = $1 AND product_id = $2 AND outcome = 'approved' AND date_used IS NULL
+			ORDER BY date_created LIMIT 1
+		)
+		RETURNING *`
+	err := db.GetContext(ctx, &approved, qApproved, o.UserID, o.ProductID, now.UTC())
+	switch err {
+	case nil:
+		return &approved, nil
+	case sql.ErrNoRows:
+	default:
+		return nil, errors.Wrap(err, "looking for approved review")
+	}
+
+	a, err := s.Score(ctx, o, now)
+	if err != nil {
+		return nil, errors.Wrap(err, "scoring order")
+	}
+
+	d := Decision{
+		ID:          uuid.New().String(),
+		UserID:      o.UserID,
+		ProductID:   o.ProductID,
+		Quantity:    o.Quantity,
+		Amount:      o.Amount,
+		Currency:    o.Currency,
+		IP:          o.IP,
+		Country:     o.Country,
+		Score:       a.Score,
+		Reasons:     a.Reasons,
+		Action:      t.Decide(a.Score),
+		DateCreated: now.UTC(),
+	}
+	if d.Reasons == nil {
+		d.Reasons = []string{}
+	}
+
+	const q = `
+		INSERT INTO fraud_decisions
+		(decision_id, user_id, product_id, quantity, amount, currency, ip, country, score, reasons, action, date_created)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`
+	if _, err := db.ExecContext(ctx, q,
+		d.ID, d.UserID, d.ProductID, d.Quantity, d.Amount, d.Currency,
+		d.IP, d.Country, d.Score, d.Reasons, d.Action, d.DateCreated,
+	); err != nil {
+		return nil, errors.Wrap(err, "recording decision")
+	}
+
+	return &d, nil
+}
+
+// ListReviews gets the Decisions waiting for review, oldest first.
+func ListReviews(ctx context.Context, db *sqlx.DB, limit, offset int) ([]Decision, error) {
+	list := []Decision{}
+	const q = `
+		SELECT * FROM fraud_decisions
+		WHERE action = 'review' AND outcome IS NULL
+		ORDER BY date_created LIMIT $1 OFFSET $2`
+	if err := db.SelectContext(ctx, &list, q, limit, offset); err != nil {
+		return nil, errors.Wrap(err, "selecting decisions to review")
+	}
+	return list, nil
+}
+
+// Review records the outcome of the review of the Decision with the given id
+// by user.
+func Review(ctx context.Context, db *sqlx.DB, user auth.Claims, id string, nr NewReview, now time.Time) (*Decision, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	outcome := OutcomeDenied
+	if nr.Approve {
+		outcome = OutcomeApproved
+	}
+
+	var d Decision
+	const q = `
+		UPDATE fraud_decisions SET outcome = $2, reviewed_by = $3, date_reviewed = $4
+		WHERE decision_id = $1 AND action = 'review' AND outcome IS NULL
+		RETURNING *`
+	err := db.GetContext(ctx, &d, q, id, outcome, user.Subject, now.UTC())
+	if err == sql.ErrNoRows {
+		var exists bool
+		if err := db.GetContext(ctx, &exists, `SELECT EXISTS (SELECT 1 FROM fraud_decisions WHERE decision_id = $1)`, id); err != nil {
+			return nil, errors.Wrap(err, "looking for decision")
+		}
+		if !exists {
+			return nil, ErrNotFound
+		}
+		return nil, ErrReviewed
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "reviewing decision %q", id)
+	}
+
+	return &d, nil
+}
+
+// API is a Scorer backed by an external risk service. It posts the Order as
+// JSON and expects an Assessment in return.
+type API struct {
+	URL    string
+	Key    string
+	Client *http.Client
+}
+
+// NewAPI constructs an API scorer calling url and authenticating with key as
+// a bearer token when it is not empty.
+func NewAPI(url, key string) *API {
+	return &API{
+		URL:    url,
+		Key:    key,
+		Client: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// Score implements the Scorer interface.
+func (a *API) Score(ctx context.Context, o Order, now time.Time) (Assessment, error) {
+	data, err := json.Marshal(o)
+	if err != nil {
+		return Assessment{}, errors.Wrap(err, "encoding risk request")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.URL, bytes.NewReader(data))
+	if err != nil {
+		return Assessment{}, errors.Wrap(err, "creating risk request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if a.Key != "" {
+		req.Header.Set("Authorization", "Bearer "+a.Key)
+	}
+
+	resp, err := a.Client.Do(req)
+	if err != nil {
+		return Assessment{}, errors.Wrap(err, "calling risk service")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Assessment{}, errors.Errorf("risk service responded %d", resp.StatusCode)
+	}
+
+	var as Assessment
+	if err := json.NewDecoder(resp.Body).Decode(&as); err != nil {
+		return Assessment{}, errors.Wrap(err, "decoding risk response")
+	}
+	return as, nil
+}
@@ -0,0 +1,83 @@
+package fraud
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// Points each heuristic adds to the score. Any two of them are enough to
+// reach the default review threshold and all three the reject threshold.
+const (
+	velocityPoints   = 40
+	geographyPoints  = 30
+	newAccountPoints = 30
+)
+
+// usualCountryWindow is how far back the checkouts of a user tell where they
+// usually buy from.
+const usualCountryWindow = 90 * 24 * time.Hour
+
+// Heuristics is the default Scorer. It reads the earlier decisions of the
+// buyer to flag:
+//
+// - velocity: MaxOrders or more checkouts within Window before this one.
+// - geography: a country other than the one the buyer usually checks out from.
+// - new accounts: buyers who signed up less than NewAccount ago.
+type Heuristics struct {
+	DB         *sqlx.DB
+	Window     time.Duration
+	MaxOrders  int
+	NewAccount time.Duration
+}
+
+// Score implements the Scorer interface.
+func (h Heuristics) Score(ctx context.Context, o Order, now time.Time) (Assessment, error) {
+	a := Assessment{Reasons: []string{}}
+
+	if h.MaxOrders > 0 {
+		var n int
+		const q = `SELECT COUNT(*) FROM fraud_decisions WHERE user_id = $1 AND date_created >= $2`
+		if err := h.DB.GetContext(ctx, &n, q, o.UserID, now.Add(-h.Window).UTC()); err != nil {
+			return Assessment{}, errors.Wrap(err, "counting recent checkouts")
+		}
+		if n >= h.MaxOrders {
+			a.Score += velocityPoints
+			a.Reasons = append(a.Reasons, ReasonVelocity)
+		}
+	}
+
+	if o.Country != "" {
+		var usual string
+		const q = `
+			SELECT country FROM fraud_decisions
+			WHERE user_id = $1 AND country <> '' AND date_created >= $2
+			GROUP BY country ORDER BY COUNT(*) DESC LIMIT 1`
+		err := h.DB.GetContext(ctx, &usual, q, o.UserID, now.Add(-usualCountryWindow).UTC())
+		if err != nil && err != sql.ErrNoRows {
+			return Assessment{}, errors.Wrap(err, "looking for usual country")
+		}
+		if usual != "" && usual != o.Country {
+			a.Score += geographyPoints
+			a.Reasons = append(a.Reasons, ReasonGeography)
+		}
+	}
+
+	if h.NewAccount > 0 {
+		var created time.Time
+		const q = `SELECT date_created FROM users WHERE user_id = $1`
+		err := h.DB.GetContext(ctx, &created, q, o.UserID)
+		if err != nil && err != sql.ErrNoRows {
+			return Assessment{}, errors.Wrap(err, "looking for account age")
+		}
+		if err == nil && now.Sub(created) < h.NewAccount {
+			a.Score += newAccountPoints
+			a.Reasons = append(a.Reasons, ReasonNewAccount)
+		}
+	}
+
+	return a, nil
+}
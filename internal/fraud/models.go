@@ -0,0 +1,78 @@
+package fraud
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Order is a checkout about to be completed. Country is the ISO 3166 code of
+// where the request came from when it is known.
+type Order struct {
+	UserID    string `json:"user_id"`
+	ProductID string `json:"product_id"`
+	Quantity  int    `json:"quantity"`
+	Amount    int    `json:"amount"`
+	Currency  string `json:"currency"`
+	IP        string `json:"ip"`
+	Country   string `json:"country"`
+}
+
+// Assessment is how risky a Scorer found an Order on a scale from 0 to 100
+// along with why.
+type Assessment struct {
+	Score   int      `json:"score"`
+	Reasons []string `json:"reasons"`
+}
+
+// Reasons the Heuristics give for their scores.
+const (
+	ReasonVelocity   = "velocity"
+	ReasonGeography  = "geography"
+	ReasonNewAccount = "new_account"
+)
+
+// Actions taken on an Order.
+const (
+	ActionAllow  = "allow"
+	ActionReview = "review"
+	ActionReject = "reject"
+)
+
+// Outcomes of the review of an Order.
+const (
+	OutcomeApproved = "approved"
+	OutcomeDenied   = "denied"
+)
+
+// Decision records the Assessment of an Order and the Action taken. Orders
+// held for review get an Outcome once an admin reviewed them; an approved
+// one lets the buyer check out the product once, which sets DateUsed.
+type Decision struct {
+	ID           string         `db:"decision_id" json:"id"`
+	UserID       string         `db:"user_id" json:"user_id"`
+	ProductID    string         `db:"product_id" json:"product_id"`
+	Quantity     int            `db:"quantity" json:"quantity"`
+	Amount       int            `db:"amount" json:"amount"`
+	Currency     string         `db:"currency" json:"currency"`
+	IP           string         `db:"ip" json:"ip"`
+	Country      string         `db:"country" json:"country"`
+	Score        int            `db:"score" json:"score"`
+	Reasons      pq.StringArray `db:"reasons" json:"reasons"`
+	Action       string         `db:"action" json:"action"`
+	Outcome      *string        `db:"outcome" json:"outcome,omitempty"`
+	ReviewedBy   *string        `db:"reviewed_by" json:"reviewed_by,omitempty"`
+	DateReviewed *time.Time     `db:"date_reviewed" json:"date_reviewed,omitempty"`
+	DateUsed     *time.Time     `db:"date_used" json:"date_used,omitempty"`
+	DateCreated  time.Time      `db:"date_created" json:"date_created"`
+}
+
+// Allowed reports whether the Order may be completed.
+func (d *Decision) Allowed() bool {
+	return d.Action == ActionAllow || (d.Outcome != nil && *d.Outcome == OutcomeApproved)
+}
+
+// NewReview is what an admin sends to decide on an Order held for review.
+type NewReview struct {
+	Approve bool `json:"approve"`
+}
@@ -0,0 +1,2 @@
+// Package label renders printable sheets of price tags for products.
+package label
@@ -0,0 +1,136 @@
+package label
+
+import (
+	"io"
+	"strconv"
+
+	"github.com/arammikayelyan/garagesale/internal/platform/barcode"
+	"github.com/arammikayelyan/garagesale/internal/platform/pdf"
+	"github.com/pkg/errors"
+)
+
+// ErrUnknownLayout is returned when a sheet layout is requested which is not
+// defined in Layouts.
+var ErrUnknownLayout = errors.New("unknown label layout")
+
+// Layout describes the geometry of a sheet of label paper. All measures are
+// in points.
+type Layout struct {
+	PageWidth   float64
+	PageHeight  float64
+	MarginTop   float64
+	MarginLeft  float64
+	LabelWidth  float64
+	LabelHeight float64
+	GapX        float64
+	GapY        float64
+	Columns     int
+	Rows        int
+}
+
+// Layouts contains the supported label sheets keyed by their product code.
+var Layouts = map[string]Layout{
+
+	// Avery 5160 address labels on US Letter, 30 per sheet.
+	"avery5160": {
+		PageWidth:   pdf.LetterWidth,
+		PageHeight:  pdf.LetterHeight,
+		MarginTop:   36,
+		MarginLeft:  13.5,
+		LabelWidth:  189,
+		LabelHeight: 72,
+		GapX:        9,
+		Columns:     3,
+		Rows:        10,
+	},
+
+	// Avery L7160 address labels on A4, 21 per sheet.
+	"l7160": {
+		PageWidth:   pdf.A4Width,
+		PageHeight:  pdf.A4Height,
+		MarginTop:   42.9,
+		MarginLeft:  20.4,
+		LabelWidth:  180,
+		LabelHeight: 108,
+		GapX:        7.1,
+		Columns:     3,
+		Rows:        7,
+	},
+}
+
+// DefaultLayout is used when a client does not ask for a specific layout.
+const DefaultLayout = "avery5160"
+
+// Tag is the information printed on a single price tag.
+type Tag struct {
+	Name  string
+	Price int
+
+	// Code is encoded as a barcode so the item can be scanned at checkout.
+	Code string
+}
+
+// padding is the space kept empty around the content of each label.
+const padding = 6
+
+// Render lays out the tags on as many sheets as needed and writes the
+// resulting PDF document to w.
+func Render(w io.Writer, layout string, tags []Tag) error {
+	l, ok := Layouts[layout]
+	if !ok {
+		return ErrUnknownLayout
+	}
+
+	doc := pdf.New(l.PageWidth, l.PageHeight)
+	perPage := l.Columns * l.Rows
+
+	for i, t := range tags {
+		if i%perPage == 0 {
+			doc.AddPage()
+		}
+
+		n := i % perPage
+		x := l.MarginLeft + float64(n%l.Columns)*(l.LabelWidth+l.GapX) + padding
+		y := l.MarginTop + float64(n/l.Columns)*(l.LabelHeight+l.GapY) + padding
+		width := l.LabelWidth - 2*padding
+		height := l.LabelHeight - 2*padding
+
+		doc.Text(x, y+11, pdf.Bold, 11, fit(t.Name, 11, width))
+		doc.Text(x, y+26, pdf.Regular, 13, "Price: "+strconv.Itoa(t.Price))
+
+		modules, err := barcode.Code128(t.Code)
+		if err != nil {
+			return errors.Wrapf(err, "encoding barcode for %q", t.Name)
+		}
+
+		// The barcode takes the remaining height of the label with room
+		// left underneath for the human readable code.
+		top := y + 32
+		barHeight := height - 32 - 8
+		module := width / float64(len(modules))
+		for m, bar := range modules {
+			if bar {
+				doc.Rect(x+float64(m)*module, top, module, barHeight)
+			}
+		}
+		doc.Text(x, y+height, pdf.Regular, 6, t.Code)
+	}
+
+	if _, err := doc.WriteTo(w); err != nil {
+		return errors.Wrap(err, "writing label sheet")
+	}
+
+	return nil
+}
+
+// fit truncates s so it fits within width when drawn with the given size.
+func fit(s string, size, width float64) string {
+	if pdf.TextWidth(size, s) <= width {
+		return s
+	}
+	r := []rune(s)
+	for len(r) > 0 && pdf.TextWidth(size, string(r)+"...") > width {
+		r = r[:len(r)-1]
+	}
+	return string(r) + "..."
+}
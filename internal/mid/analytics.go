@@ -0,0 +1,42 @@
+package mid
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/arammikayelyan/garagesale/internal/consent"
+	"github.com/arammikayelyan/garagesale/internal/platform/web"
+)
+
+// Analytics tracks every request for analytics once it was handled. Requests
+// are attributed to the authenticated user or else to the anonymous visitor
+// whose consent id comes in the consent cookie or header. The tracker drops
+// the requests of whoever did not consent to analytics.
+func Analytics(tracker *consent.Tracker) web.Middleware {
+
+	f := func(after web.Handler) web.Handler {
+
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			err := after(ctx, w, r)
+
+			v, ok := ctx.Value(web.KeyValues).(*web.Values)
+			if !ok {
+				return err
+			}
+
+			consentID := r.Header.Get(consent.Header)
+			if c, cerr := r.Cookie(consent.Cookie); cerr == nil {
+				consentID = c.Value
+			}
+			if v.Subject != "" || consentID != "" {
+				tracker.Track(ctx, v.Subject, consentID, r.Method, r.URL.Path, v.StatusCode, web.Now(ctx))
+			}
+
+			return err
+		}
+
+		return h
+	}
+
+	return f
+}
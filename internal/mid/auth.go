@@ -18,7 +18,30 @@ var ErrForbidden = web.NewRequestError(
 	http.StatusForbidden,
 )
 
-// Authenticate validates a JWT from the Authorization header.
+// ErrInsufficientScope is returned when the token of a request was restricted
+// to scopes not covering an action.
+var ErrInsufficientScope = web.NewRequestError(
+	errors.New("token does not have the scope for that action"),
+	http.StatusForbidden,
+)
+
+// ErrImpersonated is returned when a token used to act as another user is
+// used for what only the user themselves may do.
+var ErrImpersonated = web.NewRequestError(
+	errors.New("impersonation tokens are not allowed for that action"),
+	http.StatusForbidden,
+)
+
+// ErrUnverified is returned when a user has not verified their email address
+// yet.
+var ErrUnverified = web.NewRequestError(
+	errors.New("email address is not verified"),
+	http.StatusForbidden,
+)
+
+// Authenticate validates a JWT from the Authorization header. Tokens minted
+// for another issuer or audience, revoked tokens and users who have not
+// verified their email address are rejected.
 func Authenticate(authenticator *auth.Authenticator) web.Middleware {
 
 	f := func(after web.Handler) web.Handler {
@@ -43,10 +66,33 @@ func Authenticate(authenticator *auth.Authenticator) web.Middleware {
 			}
 			span.End()
 
-			_ = claims
+			if err := authenticator.Validate(claims); err != nil {
+				return web.NewRequestError(err, http.StatusUnauthorized)
+			}
+
+			if !claims.Verified {
+				return ErrUnverified
+			}
+
+			revoked, err := authenticator.Revoked(ctx, claims)
+			if err != nil {
+				return err
+			}
+			if revoked {
+				err := errors.New("token was revoked")
+				return web.NewRequestError(err, http.StatusUnauthorized)
+			}
+
+			if v, ok := ctx.Value(web.KeyValues).(*web.Values); ok {
+				v.Tenant = claims.TenantID
+				v.Subject = claims.Subject
+				if claims.Act != nil {
+					v.Actor = claims.Act.Subject
+				}
+			}
 
 			// Add claims in the context so they can be retrieved later.
-			// ctx = context.WithValue(ctx, auth.Key, claims)
+			ctx = context.WithValue(ctx, auth.Key, claims)
 
 			return after(ctx, w, r)
 		}
@@ -57,9 +103,32 @@ func Authenticate(authenticator *auth.Authenticator) web.Middleware {
 	return f
 }
 
+// HasScope validates that the token of a request allows every scope from a
+// specified list. Tokens without scopes allow everything their subject may do.
+func HasScope(scopes ...string) web.Middleware {
+	f := func(after web.Handler) web.Handler {
+
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+			claims, ok := ctx.Value(auth.Key).(auth.Claims)
+			if !ok {
+				return errors.New("claims missing from context: HasScope called without/before Authenticate")
+			}
+			if !claims.HasScope(scopes...) {
+				return ErrInsufficientScope
+			}
+
+			return after(ctx, w, r)
+		}
+
+		return h
+	}
+	return f
+}
+
 // HasRole validates that an authenticated user has at least one role from a
 // specified list. This method constructs the actual function that is used.
-func HasRole(roles ...string) web.Middleware {
+func HasRole(roles ...auth.Role) web.Middleware {
 
 	// This is the actual middleware function to be executed.
 	f := func(after web.Handler) web.Handler {
@@ -82,3 +151,51 @@ func HasRole(roles ...string) web.Middleware {
 	}
 	return f
 }
+
+// Untenanted restricts a route to users who belong to no tenant, the
+// operators of the platform. Admins of a tenant hold the same admin role
+// but must not manage other tenants or what all of them share.
+func Untenanted() web.Middleware {
+	f := func(after web.Handler) web.Handler {
+
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+			claims, ok := ctx.Value(auth.Key).(auth.Claims)
+			if !ok {
+				return errors.New("claims missing from context: Untenanted called without/before Authenticate")
+			}
+			if claims.TenantID != "" {
+				return ErrForbidden
+			}
+
+			return after(ctx, w, r)
+		}
+
+		return h
+	}
+	return f
+}
+
+// NotImpersonated restricts a route to tokens of the users themselves. Admins
+// impersonating a user may look around as them but not change how they sign
+// in, such as their password, sessions or second factor.
+func NotImpersonated() web.Middleware {
+	f := func(after web.Handler) web.Handler {
+
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+			claims, ok := ctx.Value(auth.Key).(auth.Claims)
+			if !ok {
+				return errors.New("claims missing from context: NotImpersonated called without/before Authenticate")
+			}
+			if claims.Act != nil {
+				return ErrImpersonated
+			}
+
+			return after(ctx, w, r)
+		}
+
+		return h
+	}
+	return f
+}
@@ -0,0 +1,266 @@
+package mid
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arammikayelyan/garagesale/internal/platform/web"
+	"go.opencensus.io/trace"
+)
+
+// botKey is how the Verdict of BotGuard is stored in a request context.
+type botKey struct{}
+
+// botMetrics counts the requests BotGuard suspected and blocked.
+var botMetrics = struct {
+	suspected *expvar.Int
+	blocked   *expvar.Int
+}{
+	suspected: expvar.NewInt("bots_suspected"),
+	blocked:   expvar.NewInt("bots_blocked"),
+}
+
+// botAgents are fragments of the User-Agent of common scripts and crawlers.
+var botAgents = []string{
+	"bot", "crawler", "spider", "scrapy", "curl", "wget",
+	"python-requests", "go-http-client", "headless", "phantomjs",
+}
+
+// BotPolicy configures the BotGuard middleware.
+type BotPolicy struct {
+
+	// Block rejects suspected bots. Otherwise they are only tagged.
+	Block bool
+
+	// MaxRequests is how many requests one address may make per Window. Zero
+	// disables the velocity rule.
+	MaxRequests int
+	Window      time.Duration
+
+	// Honeypot is a query or body field hidden from people by the client. A
+	// request filling it in is from a bot.
+	Honeypot string
+
+	// Allow lists addresses or CIDR ranges which are never suspected.
+	Allow []string
+
+	// AllowAgents lists User-Agent fragments of welcome crawlers such as
+	// search engines.
+	AllowAgents []string
+}
+
+// Verdict is the outcome of BotGuard for a request.
+type Verdict struct {
+	Suspected bool
+	Reasons   []string
+}
+
+// BotVerdict returns the Verdict BotGuard reached for the request.
+func BotVerdict(ctx context.Context) Verdict {
+	v, _ := ctx.Value(botKey{}).(Verdict)
+	return v
+}
+
+// BotGuard inspects requests for signs of automation: headers browsers always
+// send being absent, User-Agents of scripts, too many requests from one
+// address and filled in honeypot fields. Suspected requests are tagged with a
+// Verdict in the context and logged, or rejected when the policy blocks them.
+func BotGuard(log *log.Logger, policy BotPolicy) (web.Middleware, error) {
+	var allow []*net.IPNet
+	for _, a := range policy.Allow {
+		a = strings.TrimSpace(a)
+		if a == "" {
+			continue
+		}
+		if !strings.Contains(a, "/") {
+			if strings.Contains(a, ":") {
+				a += "/128"
+			} else {
+				a += "/32"
+			}
+		}
+		_, n, err := net.ParseCIDR(a)
+		if err != nil {
+			return nil, err
+		}
+		allow = append(allow, n)
+	}
+
+	v := velocity{
+		max:    policy.MaxRequests,
+		window: policy.Window,
+		counts: make(map[string]int),
+	}
+
+	f := func(after web.Handler) web.Handler {
+
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			ctx, span := trace.StartSpan(ctx, "internal.mid.bots")
+			defer span.End()
+
+			ip := clientIP(r)
+			if allowed(ip, allow) || allowedAgent(r.UserAgent(), policy.AllowAgents) {
+				return after(ctx, w, r)
+			}
+
+			reasons := fingerprint(r)
+			if policy.Honeypot != "" && honeypotFilled(r, policy.Honeypot) {
+				reasons = append(reasons, "honeypot field filled")
+			}
+
+			tooFast := !v.allow(ip, time.Now())
+			if tooFast {
+				reasons = append(reasons, "too many requests")
+			}
+
+			if len(reasons) == 0 {
+				return after(ctx, w, r)
+			}
+
+			botMetrics.suspected.Add(1)
+			log.Printf("suspected bot %s %s %s : %s", ip, r.Method, r.URL.Path, strings.Join(reasons, ", "))
+
+			if policy.Block {
+				botMetrics.blocked.Add(1)
+				if tooFast {
+					return web.NewRequestError(errors.New("too many requests"), http.StatusTooManyRequests)
+				}
+				return web.NewRequestError(errors.New("request looks automated"), http.StatusForbidden)
+			}
+
+			ctx = context.WithValue(ctx, botKey{}, Verdict{Suspected: true, Reasons: reasons})
+			return after(ctx, w, r)
+		}
+
+		return h
+	}
+
+	return f, nil
+}
+
+// fingerprint checks the headers of a request against what browsers send.
+func fingerprint(r *http.Request) []string {
+	var reasons []string
+
+	ua := strings.ToLower(r.UserAgent())
+	switch {
+	case ua == "":
+		reasons = append(reasons, "no user agent")
+	default:
+		for _, a := range botAgents {
+			if strings.Contains(ua, a) {
+				reasons = append(reasons, "automated user agent")
+				break
+			}
+		}
+	}
+
+	if r.Header.Get("Accept") == "" {
+		reasons = append(reasons, "no accept header")
+	}
+	if strings.HasPrefix(ua, "mozilla/") && r.Header.Get("Accept-Language") == "" {
+		reasons = append(reasons, "browser without accept language")
+	}
+
+	return reasons
+}
+
+// maxHoneypotBody is the largest body inspected for the honeypot field.
+const maxHoneypotBody = 1 << 16
+
+// honeypotFilled reports whether the honeypot field has a value in the query
+// string or a JSON body. The body is left intact for the handler.
+func honeypotFilled(r *http.Request, field string) bool {
+	if r.URL.Query().Get(field) != "" {
+		return true
+	}
+
+	if r.Body == nil || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		return false
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, r.Body, maxHoneypotBody))
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return false
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return false
+	}
+	v, ok := fields[field]
+	return ok && v != nil && v != ""
+}
+
+// clientIP is the address the request came from.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// allowed reports whether ip is in one of the allowed ranges.
+func allowed(ip string, allow []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range allow {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedAgent reports whether the User-Agent belongs to a welcome crawler.
+func allowedAgent(ua string, agents []string) bool {
+	ua = strings.ToLower(ua)
+	for _, a := range agents {
+		if a != "" && strings.Contains(ua, strings.ToLower(a)) {
+			return true
+		}
+	}
+	return false
+}
+
+// velocity counts requests per address in fixed windows.
+type velocity struct {
+	max    int
+	window time.Duration
+
+	mu     sync.Mutex
+	start  time.Time
+	counts map[string]int
+}
+
+// allow counts a request from ip and reports whether it is within the limit.
+func (v *velocity) allow(ip string, now time.Time) bool {
+	if v.max <= 0 || v.window <= 0 {
+		return true
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if now.Sub(v.start) >= v.window {
+		v.start = now
+		v.counts = make(map[string]int)
+	}
+
+	v.counts[ip]++
+	return v.counts[ip] <= v.max
+}
@@ -11,7 +11,9 @@ import (
 )
 
 // Logger writes some information about the request to the logs in
-// the format: TraceID: (200) GET /foo -> IP ADDR (latency)
+// the format: TraceID : RequestID : (200) GET /foo -> IP ADDR (latency)
+// Requests made while impersonating a user are logged a second time as an
+// audit record naming who acted as whom.
 func Logger(log *log.Logger) web.Middleware {
 
 	// This is the actual middleware function to be executed.
@@ -32,12 +34,21 @@ func Logger(log *log.Logger) web.Middleware {
 			err := before(ctx, w, r)
 
 			log.Printf(
-				"%s : (%d) : %s %s -> %s (%s)",
-				v.TraceID, v.StatusCode,
+				"%s : %s : (%d) : %s %s -> %s (%s)",
+				v.TraceID, v.RequestID, v.StatusCode,
 				r.Method, r.URL.Path,
 				r.RemoteAddr, time.Since(v.Start),
 			)
 
+			// Everything done while impersonating a user is audited.
+			if v.Actor != "" {
+				log.Printf(
+					"%s : %s : audit : user %s acting as user %s : (%d) : %s %s",
+					v.TraceID, v.RequestID, v.Actor, v.Subject, v.StatusCode,
+					r.Method, r.URL.Path,
+				)
+			}
+
 			// Return the error to be handled further up the chain.
 			return err
 		}
@@ -0,0 +1,43 @@
+package mid
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/arammikayelyan/garagesale/internal/platform/web"
+	"go.opencensus.io/trace"
+)
+
+// RateLimit rejects requests from an address beyond max per window with a
+// 429 status, telling the client when to retry. Zero max or window disables
+// it. Unlike BotGuard it applies to everyone and only to the routes it wraps.
+func RateLimit(max int, window time.Duration) web.Middleware {
+	v := velocity{
+		max:    max,
+		window: window,
+		counts: make(map[string]int),
+	}
+
+	f := func(after web.Handler) web.Handler {
+
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			ctx, span := trace.StartSpan(ctx, "internal.mid.RateLimit")
+			defer span.End()
+
+			if !v.allow(clientIP(r), time.Now()) {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(window.Seconds()))))
+				return web.NewRequestError(errors.New("too many requests"), http.StatusTooManyRequests)
+			}
+
+			return after(ctx, w, r)
+		}
+
+		return h
+	}
+
+	return f
+}
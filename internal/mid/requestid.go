@@ -0,0 +1,57 @@
+package mid
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/arammikayelyan/garagesale/internal/platform/web"
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader carries the id of a request in both directions.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestID is the longest request id accepted from a client.
+const maxRequestID = 128
+
+// RequestID gives every request an id which support can match a report of a
+// user against the logs with. An id sent by the client or a proxy in front of
+// us is kept so a request can be followed across services; otherwise one is
+// generated. The id is echoed in the response header.
+func RequestID() web.Middleware {
+
+	f := func(after web.Handler) web.Handler {
+
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			id := r.Header.Get(RequestIDHeader)
+			if !validRequestID(id) {
+				id = uuid.New().String()
+			}
+
+			if v, ok := ctx.Value(web.KeyValues).(*web.Values); ok {
+				v.RequestID = id
+			}
+			w.Header().Set(RequestIDHeader, id)
+
+			return after(ctx, w, r)
+		}
+
+		return h
+	}
+
+	return f
+}
+
+// validRequestID reports whether id is safe to log and echo: short and made
+// of printable ASCII without spaces.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestID {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,31 @@
+package mid
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/arammikayelyan/garagesale/internal/platform/web"
+	"github.com/arammikayelyan/garagesale/internal/usage"
+)
+
+// Usage counts every request made by a user of a tenant towards the tenant's
+// metered API calls.
+func Usage(meter *usage.Meter) web.Middleware {
+
+	f := func(after web.Handler) web.Handler {
+
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			err := after(ctx, w, r)
+
+			if v, ok := ctx.Value(web.KeyValues).(*web.Values); ok && v.Tenant != "" {
+				meter.Count(v.Tenant)
+			}
+
+			return err
+		}
+
+		return h
+	}
+
+	return f
+}
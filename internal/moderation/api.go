@@ -0,0 +1,91 @@
+package moderation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// API is a Checker backed by an external moderation service. It posts the text
+// as {"input": text} and expects a response of the form
+//
+//	{"results": [{"flagged": true, "categories": {"harassment": true}}]}
+//
+// which is the format used by the common hosted moderation endpoints.
+type API struct {
+	URL    string
+	Key    string
+	Client *http.Client
+}
+
+// NewAPI constructs an API checker calling url and authenticating with key
+// as a bearer token when it is not empty.
+func NewAPI(url, key string) *API {
+	return &API{
+		URL:    url,
+		Key:    key,
+		Client: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// Check implements the Checker interface.
+func (a *API) Check(ctx context.Context, text string) ([]string, error) {
+	data, err := json.Marshal(struct {
+		Input string `json:"input"`
+	}{text})
+	if err != nil {
+		return nil, errors.Wrap(err, "encoding moderation request")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.URL, bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.Wrap(err, "creating moderation request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if a.Key != "" {
+		req.Header.Set("Authorization", "Bearer "+a.Key)
+	}
+
+	resp, err := a.Client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "calling moderation api")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("moderation api responded %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Results []struct {
+			Flagged    bool            `json:"flagged"`
+			Categories map[string]bool `json:"categories"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, errors.Wrap(err, "decoding moderation response")
+	}
+
+	var reasons []string
+	for _, r := range result.Results {
+		if !r.Flagged {
+			continue
+		}
+		for category, hit := range r.Categories {
+			if hit {
+				reasons = append(reasons, "flagged as "+category)
+			}
+		}
+		if len(reasons) == 0 {
+			reasons = append(reasons, "flagged by moderation")
+		}
+	}
+	sort.Strings(reasons)
+
+	return reasons, nil
+}
@@ -0,0 +1,4 @@
+// Package moderation screens user supplied text such as listing names and
+// descriptions for objectionable content. Depending on its mode a Filter has
+// violations rejected outright or accepted and flagged for an admin to review.
+package moderation
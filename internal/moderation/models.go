@@ -0,0 +1,30 @@
+package moderation
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// These are the modes a Filter operates in.
+const (
+	ModeReject = "reject"
+	ModeFlag   = "flag"
+)
+
+// Violation describes why the text of one field was objected to.
+type Violation struct {
+	Field   string   `json:"field"`
+	Reasons []string `json:"reasons"`
+}
+
+// Flag records content which was accepted despite violations so an admin can
+// review it.
+type Flag struct {
+	ID          string         `db:"flag_id" json:"id"`
+	SubjectType string         `db:"subject_type" json:"subject_type"`
+	SubjectID   string         `db:"subject_id" json:"subject_id"`
+	Field       string         `db:"field" json:"field"`
+	Reasons     pq.StringArray `db:"reasons" json:"reasons"`
+	DateCreated time.Time      `db:"date_created" json:"date_created"`
+}
@@ -0,0 +1,135 @@
+package moderation
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// ErrUnknownMode is returned when constructing a Filter with an unsupported
+// mode.
+var ErrUnknownMode = errors.New("moderation mode must be reject or flag")
+
+// Checker examines a piece of text and returns the reasons it is objectionable,
+// if any.
+type Checker interface {
+	Check(ctx context.Context, text string) ([]string, error)
+}
+
+// Filter runs text through a set of Checkers.
+type Filter struct {
+	mode     string
+	checkers []Checker
+}
+
+// NewFilter constructs a Filter in the provided mode. A Filter without any
+// checkers accepts everything.
+func NewFilter(mode string, checkers ...Checker) (*Filter, error) {
+	if mode != ModeReject && mode != ModeFlag {
+		return nil, ErrUnknownMode
+	}
+
+	f := Filter{
+		mode:     mode,
+		checkers: checkers,
+	}
+	return &f, nil
+}
+
+// Rejects reports whether violations should be refused rather than flagged.
+func (f *Filter) Rejects() bool {
+	return f.mode == ModeReject
+}
+
+// Check runs every field through the checkers. Fields are identified by their
+// name in the request so violations can be reported against them. The
+// violations are sorted by field.
+func (f *Filter) Check(ctx context.Context, fields map[string]string) ([]Violation, error) {
+	var violations []Violation
+	for field, text := range fields {
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+
+		var reasons []string
+		for _, c := range f.checkers {
+			r, err := c.Check(ctx, text)
+			if err != nil {
+				return nil, errors.Wrapf(err, "checking %s", field)
+			}
+			reasons = append(reasons, r...)
+		}
+
+		if len(reasons) > 0 {
+			violations = append(violations, Violation{Field: field, Reasons: reasons})
+		}
+	}
+
+	sort.Slice(violations, func(i, j int) bool { return violations[i].Field < violations[j].Field })
+	return violations, nil
+}
+
+// Wordlist is a Checker which objects to text containing any of a list of
+// words. Matching ignores case and only considers whole words.
+type Wordlist struct {
+	words map[string]bool
+}
+
+// NewWordlist constructs a Wordlist checker from the provided words.
+func NewWordlist(words []string) *Wordlist {
+	wl := Wordlist{words: make(map[string]bool)}
+	for _, w := range words {
+		w = strings.ToLower(strings.TrimSpace(w))
+		if w != "" {
+			wl.words[w] = true
+		}
+	}
+	return &wl
+}
+
+// Check implements the Checker interface.
+func (wl *Wordlist) Check(ctx context.Context, text string) ([]string, error) {
+	split := func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}
+
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), split) {
+		if wl.words[w] {
+			return []string{"contains a blocked word"}, nil
+		}
+	}
+	return nil, nil
+}
+
+// RecordFlags stores the violations of accepted content for review.
+func RecordFlags(ctx context.Context, db *sqlx.DB, subjectType, subjectID string, violations []Violation, now time.Time) error {
+	const q = `
+		INSERT INTO content_flags
+		(flag_id, subject_type, subject_id, field, reasons, date_created)
+		VALUES ($1, $2, $3, $4, $5, $6)`
+
+	for _, v := range violations {
+		if _, err := db.ExecContext(ctx, q, uuid.New().String(), subjectType, subjectID, v.Field, pq.Array(v.Reasons), now.UTC()); err != nil {
+			return errors.Wrap(err, "inserting content flag")
+		}
+	}
+
+	return nil
+}
+
+// ListFlags gives a page of the recorded flags, newest first.
+func ListFlags(ctx context.Context, db *sqlx.DB, limit, offset int) ([]Flag, error) {
+	list := []Flag{}
+	const q = `SELECT * FROM content_flags ORDER BY date_created DESC LIMIT $1 OFFSET $2`
+	if err := db.SelectContext(ctx, &list, q, limit, offset); err != nil {
+		return nil, errors.Wrap(err, "selecting content flags")
+	}
+	return list, nil
+}
@@ -0,0 +1,164 @@
+package notification
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// maxDigestLines is the number of messages listed in a digest before the
+// remainder is summarized as a count.
+const maxDigestLines = 10
+
+// digestItem is a low priority message waiting to be included in a digest.
+type digestItem struct {
+	ID          string    `db:"item_id"`
+	UserID      string    `db:"user_id"`
+	Event       string    `db:"event"`
+	Subject     string    `db:"subject"`
+	Body        string    `db:"body"`
+	DateCreated time.Time `db:"date_created"`
+}
+
+// RetrieveDigest gives how often a user receives digests. Users who never
+// chose get a daily digest.
+func RetrieveDigest(ctx context.Context, db *sqlx.DB, userID string) (DigestSetting, error) {
+	ds := DigestSetting{Frequency: DigestDaily}
+
+	const q = `SELECT frequency FROM notification_digests WHERE user_id = $1`
+	if err := db.GetContext(ctx, &ds, q, userID); err != nil && err != sql.ErrNoRows {
+		return ds, errors.Wrap(err, "selecting digest setting")
+	}
+
+	return ds, nil
+}
+
+// UpdateDigest sets how often a user receives digests.
+func UpdateDigest(ctx context.Context, db *sqlx.DB, userID string, ds DigestSetting) error {
+	const q = `
+		INSERT INTO notification_digests (user_id, frequency)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET
+			frequency = EXCLUDED.frequency`
+
+	if _, err := db.ExecContext(ctx, q, userID, ds.Frequency); err != nil {
+		return errors.Wrap(err, "saving digest setting")
+	}
+
+	return nil
+}
+
+// queue stores a low priority message until the next digest of the user.
+func (n *Notifier) queue(ctx context.Context, userID string, m Message) error {
+	const q = `
+		INSERT INTO notification_digest_items
+		(item_id, user_id, event, subject, body, date_created)
+		VALUES ($1, $2, $3, $4, $5, $6)`
+
+	if _, err := n.db.ExecContext(ctx, q, uuid.New().String(), userID, m.Event, m.Subject, m.Body, time.Now().UTC()); err != nil {
+		return errors.Wrap(err, "queueing digest item")
+	}
+
+	return nil
+}
+
+// SendDigests delivers a single digest notification to every user who has
+// queued messages and whose daily or weekly period has passed since their
+// last digest. It returns the number of digests sent.
+func (n *Notifier) SendDigests(ctx context.Context, now time.Time) (int, error) {
+	const q = `
+		SELECT DISTINCT i.user_id
+		FROM notification_digest_items AS i
+		LEFT JOIN notification_digests AS d ON d.user_id = i.user_id
+		WHERE d.last_sent IS NULL
+		OR (COALESCE(d.frequency, 'daily') = 'daily' AND d.last_sent <= $1)
+		OR (d.frequency = 'weekly' AND d.last_sent <= $2)`
+
+	now = now.UTC()
+	var users []string
+	if err := n.db.SelectContext(ctx, &users, q, now.AddDate(0, 0, -1), now.AddDate(0, 0, -7)); err != nil {
+		return 0, errors.Wrap(err, "selecting users due a digest")
+	}
+
+	var sent int
+	for _, userID := range users {
+		if err := n.sendDigest(ctx, userID, now); err != nil {
+			n.log.Printf("notification : digest for user %s failed : %v", userID, err)
+			continue
+		}
+		sent++
+	}
+
+	return sent, nil
+}
+
+// sendDigest combines the queued messages of a user into one notification.
+// Items are only removed once the digest went out so nothing is lost when
+// delivery fails.
+func (n *Notifier) sendDigest(ctx context.Context, userID string, now time.Time) error {
+	const q = `
+		SELECT * FROM notification_digest_items
+		WHERE user_id = $1 AND date_created <= $2
+		ORDER BY date_created`
+
+	var items []digestItem
+	if err := n.db.SelectContext(ctx, &items, q, userID, now); err != nil {
+		return errors.Wrap(err, "selecting digest items")
+	}
+	if len(items) == 0 {
+		return nil
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d updates since your last digest:\n", len(items))
+	for i, it := range items {
+		if i == maxDigestLines {
+			fmt.Fprintf(&b, "and %d more\n", len(items)-maxDigestLines)
+			break
+		}
+		fmt.Fprintf(&b, "- %s\n", it.Subject)
+	}
+
+	m := Message{
+		Event:   EventDigest,
+		Subject: fmt.Sprintf("Your digest: %d updates", len(items)),
+		Body:    b.String(),
+	}
+	if err := n.Notify(ctx, userID, m); err != nil {
+		return err
+	}
+
+	ids := make([]string, len(items))
+	for i, it := range items {
+		ids[i] = it.ID
+	}
+
+	tx, err := n.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	const qDelete = `DELETE FROM notification_digest_items WHERE item_id = ANY($1)`
+	if _, err := tx.ExecContext(ctx, qDelete, pq.Array(ids)); err != nil {
+		return errors.Wrap(err, "deleting digest items")
+	}
+
+	const qSent = `
+		INSERT INTO notification_digests (user_id, frequency, last_sent)
+		VALUES ($1, 'daily', $2)
+		ON CONFLICT (user_id) DO UPDATE SET
+			last_sent = EXCLUDED.last_sent`
+	if _, err := tx.ExecContext(ctx, qSent, userID, now); err != nil {
+		return errors.Wrap(err, "recording digest")
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,3 @@
+// Package notification implements all business logic regarding delivering
+// notifications to users over the channels they have configured.
+package notification
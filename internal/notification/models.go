@@ -0,0 +1,127 @@
+package notification
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Channel is a medium notifications can be delivered through.
+type Channel string
+
+// These are the channels notifications can be delivered through.
+const (
+	ChannelEmail Channel = "email"
+	ChannelSMS   Channel = "sms"
+	ChannelPush  Channel = "push"
+	ChannelInApp Channel = "in_app"
+)
+
+// channels contains every known channel so client input can be validated.
+// Users without preferences for an event are notified on all of them.
+var channels = []Channel{ChannelEmail, ChannelSMS, ChannelPush, ChannelInApp}
+
+// These are the events users can be notified about.
+const (
+	EventSaleRecorded   = "sale.recorded"
+	EventSaleReversed   = "sale.reversed"
+	EventProductWatched = "product.watched"
+	EventDigest         = "digest"
+	EventAnomaly        = "anomaly"
+)
+
+// events contains every known event so client input can be validated.
+var events = map[string]bool{
+	EventSaleRecorded:   true,
+	EventSaleReversed:   true,
+	EventProductWatched: true,
+	EventDigest:         true,
+	EventAnomaly:        true,
+}
+
+// Platform identifies the push service a mobile device is reached through.
+type Platform string
+
+// These are the supported device platforms.
+const (
+	PlatformAndroid Platform = "android"
+	PlatformIOS     Platform = "ios"
+)
+
+// ChannelSetting is the configuration of one channel for a single user.
+// Address is where messages are delivered, e.g. a phone number for SMS.
+type ChannelSetting struct {
+	UserID      string    `db:"user_id" json:"user_id"`
+	Channel     Channel   `db:"channel" json:"channel"`
+	Address     string    `db:"address" json:"address"`
+	Enabled     bool      `db:"enabled" json:"enabled"`
+	DateUpdated time.Time `db:"date_updated" json:"date_updated"`
+}
+
+// UpdateChannel is what we require from clients to configure a channel.
+type UpdateChannel struct {
+	Address string `json:"address" validate:"required"`
+	Enabled bool   `json:"enabled"`
+}
+
+// Device is a mobile device registered by a user to receive push
+// notifications. Events lists the events the user opted in to on this device.
+type Device struct {
+	Token       string         `db:"token" json:"token"`
+	UserID      string         `db:"user_id" json:"user_id"`
+	Platform    Platform       `db:"platform" json:"platform"`
+	Events      pq.StringArray `db:"events" json:"events"`
+	DateCreated time.Time      `db:"date_created" json:"date_created"`
+}
+
+// NewDevice is what we require from clients to register a device.
+type NewDevice struct {
+	Token    string   `json:"token" validate:"required"`
+	Platform Platform `json:"platform" validate:"required,oneof=android ios"`
+	Events   []string `json:"events"`
+}
+
+// Preferences maps each event to the channels a user wants to be notified
+// on about it.
+type Preferences map[string][]Channel
+
+// InApp is a notification stored for display inside the application.
+type InApp struct {
+	ID          string     `db:"notification_id" json:"id"`
+	UserID      string     `db:"user_id" json:"user_id"`
+	Event       string     `db:"event" json:"event"`
+	Subject     string     `db:"subject" json:"subject"`
+	Body        string     `db:"body" json:"body"`
+	DateCreated time.Time  `db:"date_created" json:"date_created"`
+	DateRead    *time.Time `db:"date_read" json:"date_read"`
+}
+
+// Message is a notification about an event to be delivered to a user.
+type Message struct {
+	Event    string
+	Subject  string
+	Body     string
+	Priority Priority
+}
+
+// Priority controls whether a Message is delivered right away or batched
+// into the periodic digest of the user.
+type Priority int
+
+// These are the supported message priorities.
+const (
+	PriorityNormal Priority = iota
+	PriorityLow
+)
+
+// Digest frequencies a user may choose from.
+const (
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
+// DigestSetting is how often a user receives the digest of their low priority
+// notifications.
+type DigestSetting struct {
+	Frequency string `db:"frequency" json:"frequency" validate:"required,oneof=daily weekly"`
+}
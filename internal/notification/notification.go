@@ -0,0 +1,405 @@
+package notification
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/mail"
+	"regexp"
+	"time"
+
+	mailer "github.com/arammikayelyan/garagesale/internal/platform/mail"
+	"github.com/arammikayelyan/garagesale/internal/platform/push"
+	"github.com/arammikayelyan/garagesale/internal/platform/sms"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// Predefined errors for known failure scenarios
+var (
+	ErrUnknownChannel = errors.New("unknown notification channel")
+	ErrInvalidAddress = errors.New("address is not valid for the channel")
+	ErrUnknownEvent   = errors.New("unknown notification event")
+	ErrNotFound       = errors.New("device not found")
+)
+
+// e164 matches phone numbers in the international E.164 format.
+var e164 = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+
+// ListChannels gives the channel settings of a single user.
+func ListChannels(ctx context.Context, db *sqlx.DB, userID string) ([]ChannelSetting, error) {
+	list := []ChannelSetting{}
+
+	const q = `SELECT * FROM notification_channels WHERE user_id = $1 ORDER BY channel`
+	if err := db.SelectContext(ctx, &list, q, userID); err != nil {
+		return nil, errors.Wrap(err, "selecting notification channels")
+	}
+
+	return list, nil
+}
+
+// SetChannel creates or replaces the configuration of a channel for a user.
+func SetChannel(ctx context.Context, db *sqlx.DB, userID string, ch Channel, uc UpdateChannel, now time.Time) (*ChannelSetting, error) {
+	switch ch {
+	case ChannelSMS:
+		if !e164.MatchString(uc.Address) {
+			return nil, ErrInvalidAddress
+		}
+	case ChannelEmail:
+		if _, err := mail.ParseAddress(uc.Address); err != nil {
+			return nil, ErrInvalidAddress
+		}
+	default:
+		return nil, ErrUnknownChannel
+	}
+
+	cs := ChannelSetting{
+		UserID:      userID,
+		Channel:     ch,
+		Address:     uc.Address,
+		Enabled:     uc.Enabled,
+		DateUpdated: now.UTC(),
+	}
+
+	const q = `
+		INSERT INTO notification_channels
+		(user_id, channel, address, enabled, date_updated)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id, channel) DO UPDATE SET
+			address = EXCLUDED.address,
+			enabled = EXCLUDED.enabled,
+			date_updated = EXCLUDED.date_updated`
+
+	if _, err := db.ExecContext(ctx, q, cs.UserID, cs.Channel, cs.Address, cs.Enabled, cs.DateUpdated); err != nil {
+		return nil, errors.Wrap(err, "saving notification channel")
+	}
+
+	return &cs, nil
+}
+
+// RetrievePreferences gives the channels a user is notified on for every
+// known event. Events the user has not configured default to all channels.
+func RetrievePreferences(ctx context.Context, db *sqlx.DB, userID string) (Preferences, error) {
+	var rows []struct {
+		Event    string         `db:"event"`
+		Channels pq.StringArray `db:"channels"`
+	}
+
+	const q = `SELECT event, channels FROM notification_preferences WHERE user_id = $1`
+	if err := db.SelectContext(ctx, &rows, q, userID); err != nil {
+		return nil, errors.Wrap(err, "selecting notification preferences")
+	}
+
+	p := make(Preferences, len(events))
+	for e := range events {
+		p[e] = channels
+	}
+	for _, row := range rows {
+		if !events[row.Event] {
+			continue
+		}
+		chs := make([]Channel, len(row.Channels))
+		for i, ch := range row.Channels {
+			chs[i] = Channel(ch)
+		}
+		p[row.Event] = chs
+	}
+
+	return p, nil
+}
+
+// UpdatePreferences replaces the channels of the events present in p. Events
+// which are not mentioned keep their current preferences.
+func UpdatePreferences(ctx context.Context, db *sqlx.DB, userID string, p Preferences, now time.Time) (Preferences, error) {
+	for e, chs := range p {
+		if !events[e] {
+			return nil, ErrUnknownEvent
+		}
+		for _, ch := range chs {
+			if !knownChannel(ch) {
+				return nil, ErrUnknownChannel
+			}
+		}
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	const q = `
+		INSERT INTO notification_preferences
+		(user_id, event, channels, date_updated)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, event) DO UPDATE SET
+			channels = EXCLUDED.channels,
+			date_updated = EXCLUDED.date_updated`
+
+	for e, chs := range p {
+		list := make(pq.StringArray, len(chs))
+		for i, ch := range chs {
+			list[i] = string(ch)
+		}
+		if _, err := tx.ExecContext(ctx, q, userID, e, list, now.UTC()); err != nil {
+			return nil, errors.Wrap(err, "saving notification preference")
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing notification preferences")
+	}
+
+	return RetrievePreferences(ctx, db, userID)
+}
+
+// ListInApp gives the in-app notifications of a user, newest first.
+func ListInApp(ctx context.Context, db *sqlx.DB, userID string, limit, offset int) ([]InApp, error) {
+	list := []InApp{}
+
+	const q = `
+		SELECT * FROM notifications
+		WHERE user_id = $1
+		ORDER BY date_created DESC
+		LIMIT $2 OFFSET $3`
+	if err := db.SelectContext(ctx, &list, q, userID, limit, offset); err != nil {
+		return nil, errors.Wrap(err, "selecting notifications")
+	}
+
+	return list, nil
+}
+
+// knownChannel reports whether ch is one of the supported channels.
+func knownChannel(ch Channel) bool {
+	for _, c := range channels {
+		if c == ch {
+			return true
+		}
+	}
+	return false
+}
+
+// RegisterDevice stores a device token for push notifications. Registering
+// a known token again moves it to the calling user and replaces its events.
+func RegisterDevice(ctx context.Context, db *sqlx.DB, userID string, nd NewDevice, now time.Time) (*Device, error) {
+	for _, e := range nd.Events {
+		if !events[e] {
+			return nil, ErrUnknownEvent
+		}
+	}
+
+	d := Device{
+		Token:       nd.Token,
+		UserID:      userID,
+		Platform:    nd.Platform,
+		Events:      nd.Events,
+		DateCreated: now.UTC(),
+	}
+	if d.Events == nil {
+		d.Events = pq.StringArray{}
+	}
+
+	const q = `
+		INSERT INTO devices
+		(token, user_id, platform, events, date_created)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (token) DO UPDATE SET
+			user_id = EXCLUDED.user_id,
+			platform = EXCLUDED.platform,
+			events = EXCLUDED.events`
+
+	if _, err := db.ExecContext(ctx, q, d.Token, d.UserID, d.Platform, d.Events, d.DateCreated); err != nil {
+		return nil, errors.Wrap(err, "registering device")
+	}
+
+	return &d, nil
+}
+
+// ListDevices gives the devices registered by a single user.
+func ListDevices(ctx context.Context, db *sqlx.DB, userID string) ([]Device, error) {
+	list := []Device{}
+
+	const q = `SELECT * FROM devices WHERE user_id = $1 ORDER BY date_created`
+	if err := db.SelectContext(ctx, &list, q, userID); err != nil {
+		return nil, errors.Wrap(err, "selecting devices")
+	}
+
+	return list, nil
+}
+
+// UnregisterDevice removes a device token of a user.
+func UnregisterDevice(ctx context.Context, db *sqlx.DB, userID, token string) error {
+	const q = `DELETE FROM devices WHERE user_id = $1 AND token = $2`
+	res, err := db.ExecContext(ctx, q, userID, token)
+	if err != nil {
+		return errors.Wrap(err, "deleting device")
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "checking deleted rows")
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// Senders holds the providers used to deliver notifications. A nil sender
+// disables its channel or platform.
+type Senders struct {
+	Mail mailer.Mailer
+	SMS  sms.Sender
+	Push map[Platform]push.Sender
+}
+
+// Notifier delivers notifications to users over every channel they have
+// enabled.
+type Notifier struct {
+	db      *sqlx.DB
+	log     *log.Logger
+	senders Senders
+}
+
+// NewNotifier constructs a Notifier using the provided senders.
+func NewNotifier(db *sqlx.DB, log *log.Logger, senders Senders) *Notifier {
+	n := Notifier{
+		db:      db,
+		log:     log,
+		senders: senders,
+	}
+	return &n
+}
+
+// Notify delivers m to the user over each channel they want to be notified
+// on about the event. Low priority messages are held back for the user's next
+// digest instead. A failing channel does not prevent delivery over the
+// others; the first error is returned after all channels were attempted.
+// Channels without a configured sender are skipped.
+func (n *Notifier) Notify(ctx context.Context, userID string, m Message) error {
+	if m.Priority == PriorityLow {
+		return n.queue(ctx, userID, m)
+	}
+
+	allowed, err := n.allowed(ctx, userID, m.Event)
+	if err != nil {
+		return err
+	}
+
+	var first error
+	fail := func(ch Channel, err error) {
+		n.log.Printf("notification : %s to user %s over %s failed : %v", m.Event, userID, ch, err)
+		if first == nil {
+			first = errors.Wrapf(err, "sending %s over %s", m.Event, ch)
+		}
+	}
+
+	if allowed[ChannelSMS] || allowed[ChannelEmail] {
+		const q = `SELECT * FROM notification_channels WHERE user_id = $1 AND enabled`
+
+		var settings []ChannelSetting
+		if err := n.db.SelectContext(ctx, &settings, q, userID); err != nil {
+			return errors.Wrap(err, "selecting notification channels")
+		}
+
+		for _, cs := range settings {
+			if !allowed[cs.Channel] {
+				continue
+			}
+			switch cs.Channel {
+			case ChannelEmail:
+				if n.senders.Mail == nil {
+					continue
+				}
+				em := mailer.Message{To: cs.Address, Subject: m.Subject, Body: m.Body}
+				if err := n.senders.Mail.Send(ctx, em); err != nil {
+					fail(cs.Channel, err)
+				}
+			case ChannelSMS:
+				if n.senders.SMS == nil {
+					continue
+				}
+				if err := n.senders.SMS.Send(ctx, cs.Address, m.Body); err != nil {
+					fail(cs.Channel, err)
+				}
+			}
+		}
+	}
+
+	if allowed[ChannelPush] {
+		if err := n.push(ctx, userID, m); err != nil {
+			fail(ChannelPush, err)
+		}
+	}
+
+	if allowed[ChannelInApp] {
+		const q = `
+			INSERT INTO notifications
+			(notification_id, user_id, event, subject, body, date_created)
+			VALUES ($1, $2, $3, $4, $5, $6)`
+		if _, err := n.db.ExecContext(ctx, q, uuid.New().String(), userID, m.Event, m.Subject, m.Body, time.Now().UTC()); err != nil {
+			fail(ChannelInApp, err)
+		}
+	}
+
+	return first
+}
+
+// allowed gives the set of channels the user wants to receive event on.
+func (n *Notifier) allowed(ctx context.Context, userID, event string) (map[Channel]bool, error) {
+	var list pq.StringArray
+
+	const q = `SELECT channels FROM notification_preferences WHERE user_id = $1 AND event = $2`
+	err := n.db.GetContext(ctx, &list, q, userID, event)
+	switch {
+	case err == sql.ErrNoRows:
+		set := make(map[Channel]bool, len(channels))
+		for _, ch := range channels {
+			set[ch] = true
+		}
+		return set, nil
+	case err != nil:
+		return nil, errors.Wrap(err, "selecting notification preference")
+	}
+
+	set := make(map[Channel]bool, len(list))
+	for _, ch := range list {
+		set[Channel(ch)] = true
+	}
+	return set, nil
+}
+
+// push delivers m to the devices of the user which opted in to its event.
+// Tokens the provider no longer recognizes are removed.
+func (n *Notifier) push(ctx context.Context, userID string, m Message) error {
+	const q = `SELECT * FROM devices WHERE user_id = $1 AND $2 = ANY(events)`
+
+	var devices []Device
+	if err := n.db.SelectContext(ctx, &devices, q, userID, m.Event); err != nil {
+		return errors.Wrap(err, "selecting devices")
+	}
+
+	var first error
+	for _, d := range devices {
+		sender := n.senders.Push[d.Platform]
+		if sender == nil {
+			continue
+		}
+
+		err := sender.Send(ctx, d.Token, push.Notification{Title: m.Subject, Body: m.Body})
+		switch {
+		case err == push.ErrUnregistered:
+			const q = `DELETE FROM devices WHERE token = $1`
+			if _, err := n.db.ExecContext(ctx, q, d.Token); err != nil {
+				n.log.Printf("notification : removing stale device : %v", err)
+			}
+		case err != nil && first == nil:
+			first = err
+		}
+	}
+
+	return first
+}
@@ -0,0 +1,3 @@
+// Package payment collects payments for products through an external payment
+// provider. Providers are behind an interface so they can be swapped.
+package payment
@@ -0,0 +1,46 @@
+package payment
+
+// Checkout is what we require from clients to start paying for a product.
+type Checkout struct {
+	ProductID string `json:"product_id" validate:"required"`
+	Quantity  int    `json:"quantity" validate:"gt=0"`
+}
+
+// Intent asks a Provider to collect Amount in the smallest unit of Currency.
+// Metadata is echoed back in the Events about the payment.
+type Intent struct {
+	Amount         int
+	Currency       string
+	Metadata       map[string]string
+	IdempotencyKey string
+}
+
+// Session is what a client needs to complete a payment with the provider.
+type Session struct {
+	ID           string `json:"id"`
+	ClientSecret string `json:"client_secret"`
+	Amount       int    `json:"amount"`
+	Currency     string `json:"currency"`
+}
+
+// These are the kinds of Event a Provider reports.
+const (
+	EventSucceeded = "succeeded"
+	EventCancelled = "cancelled"
+	EventDisputed  = "disputed"
+	EventOther     = "other"
+)
+
+// Event is a verified notification from a Provider about a payment. Events
+// about disputes carry the id of the dispute and the reason the buyer gave;
+// their Metadata is empty.
+type Event struct {
+	ID        string
+	Type      string
+	IntentID  string
+	Amount    int
+	Currency  string
+	Metadata  map[string]string
+	DisputeID string
+	Reason    string
+}
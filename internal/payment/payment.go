@@ -0,0 +1,23 @@
+package payment
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidSignature is returned when a webhook can not be verified as coming
+// from the provider.
+var ErrInvalidSignature = errors.New("webhook signature is invalid")
+
+// Provider collects payments.
+type Provider interface {
+
+	// CreateIntent starts collecting a payment and returns what the client
+	// needs to complete it.
+	CreateIntent(ctx context.Context, in Intent) (*Session, error)
+
+	// ParseEvent verifies and decodes a webhook sent by the provider.
+	ParseEvent(payload []byte, header http.Header) (*Event, error)
+}
@@ -0,0 +1,195 @@
+package payment
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// stripeURL is the base of the Stripe REST API.
+const stripeURL = "https://api.stripe.com/v1/"
+
+// stripeTolerance is how old a webhook may be before it is rejected to stop
+// replay attacks.
+const stripeTolerance = 5 * time.Minute
+
+// Stripe is a Provider which collects payments with Stripe payment intents.
+type Stripe struct {
+	secretKey     string
+	webhookSecret string
+	client        *http.Client
+}
+
+// NewStripe constructs a Stripe provider. The webhook secret is the signing
+// secret of the webhook endpoint configured in the Stripe dashboard.
+func NewStripe(secretKey, webhookSecret string) (*Stripe, error) {
+	if secretKey == "" {
+		return nil, errors.New("stripe secret key is required")
+	}
+	if webhookSecret == "" {
+		return nil, errors.New("stripe webhook secret is required")
+	}
+
+	s := Stripe{
+		secretKey:     secretKey,
+		webhookSecret: webhookSecret,
+		client:        &http.Client{Timeout: 30 * time.Second},
+	}
+
+	return &s, nil
+}
+
+// CreateIntent implements the Provider interface.
+func (s *Stripe) CreateIntent(ctx context.Context, in Intent) (*Session, error) {
+	form := url.Values{}
+	form.Set("amount", strconv.Itoa(in.Amount))
+	form.Set("currency", in.Currency)
+	form.Set("automatic_payment_methods[enabled]", "true")
+	for k, v := range in.Metadata {
+		form.Set("metadata["+k+"]", v)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, stripeURL+"payment_intents", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, errors.Wrap(err, "creating stripe request")
+	}
+	req.SetBasicAuth(s.secretKey, "")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if in.IdempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", in.IdempotencyKey)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "calling stripe")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var e struct {
+			Error struct {
+				Type    string `json:"type"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&e)
+		return nil, errors.Errorf("stripe responded %d: %s (%s)", resp.StatusCode, e.Error.Message, e.Error.Type)
+	}
+
+	var pi struct {
+		ID           string `json:"id"`
+		ClientSecret string `json:"client_secret"`
+		Amount       int    `json:"amount"`
+		Currency     string `json:"currency"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&pi); err != nil {
+		return nil, errors.Wrap(err, "decoding stripe payment intent")
+	}
+
+	ses := Session{
+		ID:           pi.ID,
+		ClientSecret: pi.ClientSecret,
+		Amount:       pi.Amount,
+		Currency:     pi.Currency,
+	}
+	return &ses, nil
+}
+
+// ParseEvent implements the Provider interface. It checks the Stripe-Signature
+// header as described in the Stripe webhook documentation.
+func (s *Stripe) ParseEvent(payload []byte, header http.Header) (*Event, error) {
+	if err := s.verify(payload, header.Get("Stripe-Signature"), time.Now()); err != nil {
+		return nil, err
+	}
+
+	var e struct {
+		ID   string `json:"id"`
+		Type string `json:"type"`
+		Data struct {
+			Object struct {
+				ID            string            `json:"id"`
+				Amount        int               `json:"amount"`
+				Currency      string            `json:"currency"`
+				Metadata      map[string]string `json:"metadata"`
+				PaymentIntent string            `json:"payment_intent"`
+				Reason        string            `json:"reason"`
+			} `json:"object"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(payload, &e); err != nil {
+		return nil, errors.Wrap(err, "decoding stripe event")
+	}
+
+	ev := Event{
+		ID:       e.ID,
+		Type:     EventOther,
+		IntentID: e.Data.Object.ID,
+		Amount:   e.Data.Object.Amount,
+		Currency: e.Data.Object.Currency,
+		Metadata: e.Data.Object.Metadata,
+	}
+	switch e.Type {
+	case "payment_intent.succeeded":
+		ev.Type = EventSucceeded
+	case "payment_intent.canceled":
+		ev.Type = EventCancelled
+	case "charge.dispute.created":
+		ev.Type = EventDisputed
+		ev.IntentID = e.Data.Object.PaymentIntent
+		ev.DisputeID = e.Data.Object.ID
+		ev.Reason = e.Data.Object.Reason
+		ev.Metadata = nil
+	}
+
+	return &ev, nil
+}
+
+// verify checks that payload was signed with the webhook secret recently.
+func (s *Stripe) verify(payload []byte, signature string, now time.Time) error {
+	var timestamp string
+	var sigs []string
+	for _, part := range strings.Split(signature, ",") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "t":
+			timestamp = kv[1]
+		case "v1":
+			sigs = append(sigs, kv[1])
+		}
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(sigs) == 0 {
+		return ErrInvalidSignature
+	}
+	if now.Sub(time.Unix(ts, 0)) > stripeTolerance {
+		return ErrInvalidSignature
+	}
+
+	mac := hmac.New(sha256.New, []byte(s.webhookSecret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	expected := mac.Sum(nil)
+
+	for _, sig := range sigs {
+		got, err := hex.DecodeString(sig)
+		if err == nil && hmac.Equal(got, expected) {
+			return nil
+		}
+	}
+
+	return ErrInvalidSignature
+}
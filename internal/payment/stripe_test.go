@@ -0,0 +1,64 @@
+package payment
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// sign makes the v1 signature Stripe sends for payload at ts with secret.
+func sign(secret string, ts int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", ts)
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// TestVerify checks webhook signatures are accepted only when made with the
+// webhook secret over the payload recently.
+func TestVerify(t *testing.T) {
+	s, err := NewStripe("sk_test", "whsec_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload := []byte(`{"id":"evt_1","type":"payment_intent.succeeded"}`)
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	ts := now.Add(-time.Minute).Unix()
+	good := sign("whsec_test", ts, payload)
+	other := sign("whsec_other", ts, payload)
+
+	tests := []struct {
+		name      string
+		payload   []byte
+		signature string
+		ok        bool
+	}{
+		{"valid", payload, fmt.Sprintf("t=%d,v1=%s", ts, good), true},
+		{"valid among several v1", payload, fmt.Sprintf("t=%d,v1=%s,v1=%s", ts, other, good), true},
+		{"valid next to v0", payload, fmt.Sprintf("t=%d,v0=%s,v1=%s", ts, other, good), true},
+		{"other secret", payload, fmt.Sprintf("t=%d,v1=%s", ts, other), false},
+		{"several wrong v1", payload, fmt.Sprintf("t=%d,v1=%s,v1=%s", ts, other, other), false},
+		{"altered payload", []byte(`{"id":"evt_2","type":"payment_intent.succeeded"}`), fmt.Sprintf("t=%d,v1=%s", ts, good), false},
+		{"other timestamp", payload, fmt.Sprintf("t=%d,v1=%s", ts+1, good), false},
+		{"stale timestamp", payload, fmt.Sprintf("t=%d,v1=%s", now.Add(-stripeTolerance-time.Second).Unix(), sign("whsec_test", now.Add(-stripeTolerance-time.Second).Unix(), payload)), false},
+		{"bad hex", payload, fmt.Sprintf("t=%d,v1=%sz", ts, good[:len(good)-1]), false},
+		{"no v1", payload, fmt.Sprintf("t=%d", ts), false},
+		{"no timestamp", payload, "v1=" + good, false},
+		{"bad timestamp", payload, "t=soon,v1=" + good, false},
+		{"empty", payload, "", false},
+	}
+
+	for _, tt := range tests {
+		err := s.verify(tt.payload, tt.signature, now)
+		if tt.ok && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if !tt.ok && err != ErrInvalidSignature {
+			t.Errorf("%s: error %v, want %v", tt.name, err, ErrInvalidSignature)
+		}
+	}
+}
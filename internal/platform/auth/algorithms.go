@@ -0,0 +1,178 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+// SigningMethodEdDSA signs tokens with Ed25519 keys (RFC 8037). jwt-go does
+// not come with it so it is registered under the name "EdDSA" here.
+var SigningMethodEdDSA jwt.SigningMethod = signingMethodEdDSA{}
+
+func init() {
+	jwt.RegisterSigningMethod("EdDSA", func() jwt.SigningMethod {
+		return SigningMethodEdDSA
+	})
+}
+
+type signingMethodEdDSA struct{}
+
+// Alg implements the jwt.SigningMethod interface.
+func (signingMethodEdDSA) Alg() string {
+	return "EdDSA"
+}
+
+// Sign implements the jwt.SigningMethod interface.
+func (signingMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok || len(priv) != ed25519.PrivateKeySize {
+		return "", jwt.ErrInvalidKeyType
+	}
+	return jwt.EncodeSegment(ed25519.Sign(priv, []byte(signingString))), nil
+}
+
+// Verify implements the jwt.SigningMethod interface.
+func (signingMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok || len(pub) != ed25519.PublicKeySize {
+		return jwt.ErrInvalidKeyType
+	}
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(pub, []byte(signingString), sig) {
+		return errors.New("ed25519: verification error")
+	}
+	return nil
+}
+
+// ErrKeyMismatch is returned when a key can not be used with the algorithm
+// tokens are signed with. Checking this keeps a token from picking how its
+// signature is verified, such as an HMAC keyed with a public key.
+var ErrKeyMismatch = errors.New("key does not match the signing algorithm")
+
+// checkKey verifies that pub is a public key of the type algorithm signs
+// with, including the curve for ECDSA.
+func checkKey(algorithm string, pub crypto.PublicKey) error {
+	switch {
+	case strings.HasPrefix(algorithm, "RS"), strings.HasPrefix(algorithm, "PS"):
+		if _, ok := pub.(*rsa.PublicKey); ok {
+			return nil
+		}
+
+	case strings.HasPrefix(algorithm, "ES"):
+		key, ok := pub.(*ecdsa.PublicKey)
+		if !ok {
+			break
+		}
+		if curve, ok := curves[algorithm]; ok && key.Curve == curve {
+			return nil
+		}
+
+	case algorithm == "EdDSA":
+		if _, ok := pub.(ed25519.PublicKey); ok {
+			return nil
+		}
+
+	default:
+		return errors.Errorf("unsupported algorithm %v", algorithm)
+	}
+
+	return errors.Wrapf(ErrKeyMismatch, "%T for %v", pub, algorithm)
+}
+
+// curves maps the ECDSA algorithms to the curve their keys must be on.
+var curves = map[string]elliptic.Curve{
+	"ES256": elliptic.P256(),
+	"ES384": elliptic.P384(),
+	"ES512": elliptic.P521(),
+}
+
+// GenerateKey creates a new private key for signing tokens with algorithm.
+func GenerateKey(algorithm string) (crypto.Signer, error) {
+	switch {
+	case strings.HasPrefix(algorithm, "RS"), strings.HasPrefix(algorithm, "PS"):
+		return rsa.GenerateKey(rand.Reader, 2048)
+
+	case strings.HasPrefix(algorithm, "ES"):
+		curve, ok := curves[algorithm]
+		if !ok {
+			break
+		}
+		return ecdsa.GenerateKey(curve, rand.Reader)
+
+	case algorithm == "EdDSA":
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	}
+
+	return nil, errors.Errorf("unsupported algorithm %v", algorithm)
+}
+
+// ParsePrivateKey decodes a PEM encoded RSA, ECDSA or Ed25519 private key.
+// RSA keys may be in PKCS #1, ECDSA keys in SEC 1 and any of them in PKCS #8
+// form.
+func ParsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("key must be PEM encoded")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		switch key := key.(type) {
+		case *rsa.PrivateKey:
+			return key, nil
+		case *ecdsa.PrivateKey:
+			return key, nil
+		case ed25519.PrivateKey:
+			return key, nil
+		}
+		return nil, errors.Errorf("unsupported private key type %T", key)
+	}
+
+	return nil, errors.Errorf("unsupported PEM block %q", block.Type)
+}
+
+// MarshalPrivateKey PEM encodes key so ParsePrivateKey can read it back. RSA
+// keys keep the PKCS #1 form used before other key types were supported.
+func MarshalPrivateKey(key crypto.Signer) ([]byte, error) {
+	if key, ok := key.(*rsa.PrivateKey); ok {
+		block := pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(key),
+		}
+		return pem.EncodeToMemory(&block), nil
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshaling private key")
+	}
+	block := pem.Block{
+		Type:  "PRIVATE KEY",
+		Bytes: der,
+	}
+	return pem.EncodeToMemory(&block), nil
+}
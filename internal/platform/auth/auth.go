@@ -1,8 +1,10 @@
 package auth
 
 import (
-	"crypto/rsa"
+	"context"
+	"crypto"
 	"fmt"
+	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/pkg/errors"
@@ -17,13 +19,13 @@ import (
 //
 // * Key-id-to-public-key resolution is usually accomplished via a public JWKS
 // endpoint. See https://auth0.com/docs/jwks for more details.
-type KeyLookupFunc func(kid string) (*rsa.PublicKey, error)
+type KeyLookupFunc func(kid string) (crypto.PublicKey, error)
 
 // NewSimpleKeyLookupFunc is a simple implementation of KeyFunc that only ever
 // supports one key. This is easy for development but in production should be
 // replaced with a caching layer that calls a JWKS endpoint.
-func NewSimpleKeyLookupFunc(activeKID string, publicKey *rsa.PublicKey) KeyLookupFunc {
-	f := func(kid string) (*rsa.PublicKey, error) {
+func NewSimpleKeyLookupFunc(activeKID string, publicKey crypto.PublicKey) KeyLookupFunc {
+	f := func(kid string) (crypto.PublicKey, error) {
 		if activeKID != kid {
 			return nil, fmt.Errorf("unrecognized key id %q", kid)
 		}
@@ -33,22 +35,70 @@ func NewSimpleKeyLookupFunc(activeKID string, publicKey *rsa.PublicKey) KeyLooku
 	return f
 }
 
+// ErrNotRevocable is returned when revoking a token issued without an ID.
+var ErrNotRevocable = errors.New("token has no id and can not be revoked")
+
+// Errors of tokens minted for somewhere else.
+var (
+	ErrWrongIssuer   = errors.New("token was issued by someone else")
+	ErrWrongAudience = errors.New("token is meant for someone else")
+)
+
 // Authenticator is used to authenticate clients. It can generate a token for a
 // set of user claims and recreate the claims by parsing the token.
 type Authenticator struct {
-	privateKey       *rsa.PrivateKey
+	privateKey       crypto.Signer
 	activeKID        string
 	algorithm        string
 	pubKeyLookupFunc KeyLookupFunc
 	parser           *jwt.Parser
+	accessTTL        time.Duration
+	refreshTTL       time.Duration
+	serviceTTL       time.Duration
+	skew             time.Duration
+	revocations      RevocationList
+	issuer           string
+	audience         string
+
+	// keys replaces privateKey and activeKID when the keys come from a
+	// KeyStore.
+	keys *keyring
 }
 
+// RevocationList knows the IDs (jti) of tokens revoked before they expired.
+type RevocationList interface {
+	Revoke(ctx context.Context, id string, expires time.Time) error
+	Revoked(ctx context.Context, id string) (bool, error)
+}
+
+// Default lifetimes of the tokens handed out to users and services.
+const (
+	DefaultAccessTTL  = time.Hour
+	DefaultRefreshTTL = 30 * 24 * time.Hour
+	DefaultServiceTTL = 15 * time.Minute
+)
+
+// DefaultClockSkew is how far the clocks of the hosts minting and checking
+// tokens may be apart by default.
+const DefaultClockSkew = 30 * time.Second
+
+// Errors of tokens used outside of their lifetime.
+var (
+	ErrTokenExpired  = errors.New("token has expired")
+	ErrTokenNotValid = errors.New("token is not valid yet")
+)
+
 // NewAuthenticator creates an *Authenticator for use. It will error if:
 // - The private key is nil.
 // - The public key func is nil.
 // - The key ID is blank.
 // - The specified algorithm is unsupported.
-func NewAuthenticator(privateKey *rsa.PrivateKey, activeKID, algorithm string, publicKeyLookupFunc KeyLookupFunc) (*Authenticator, error) {
+// - The private key is not of the type the algorithm signs with.
+//
+// RS256 and the other RSA algorithms take an *rsa.PrivateKey, ES256, ES384
+// and ES512 an *ecdsa.PrivateKey on the matching curve and EdDSA an
+// ed25519.PrivateKey.
+func NewAuthenticator(privateKey crypto.Signer, activeKID, algorithm string, publicKeyLookupFunc KeyLookupFunc) (*Authenticator, error) {
 	if privateKey == nil {
 		return nil, errors.New("private key cannot be nil")
 	}
@@ -58,6 +108,9 @@ func NewAuthenticator(privateKey *rsa.PrivateKey, activeKID, algorithm string, p
 	if jwt.GetSigningMethod(algorithm) == nil {
 		return nil, errors.Errorf("unknown algorithm %v", algorithm)
 	}
+	if err := checkKey(algorithm, privateKey.Public()); err != nil {
+		return nil, err
+	}
 	if publicKeyLookupFunc == nil {
 		return nil, errors.New("public key function cannot be nil")
 	}
@@ -65,8 +118,10 @@ func NewAuthenticator(privateKey *rsa.PrivateKey, activeKID, algorithm string, p
 	// Create the token parser to use. The algorithm used to sign the JWT must be
 	// validated to avoid a critical vulnerability:
 	// https://auth0.com/blog/critical-vulnerabilities-in-json-web-token-libraries/
+	// The time claims are checked by ParseClaims to allow for clock skew.
 	parser := jwt.Parser{
-		ValidMethods: []string{algorithm},
+		ValidMethods:         []string{algorithm},
+		SkipClaimsValidation: true,
 	}
 
 	a := Authenticator{
@@ -75,19 +130,131 @@ func NewAuthenticator(privateKey *rsa.PrivateKey, activeKID, algorithm string, p
 		algorithm:        algorithm,
 		pubKeyLookupFunc: publicKeyLookupFunc,
 		parser:           &parser,
+		accessTTL:        DefaultAccessTTL,
+		refreshTTL:       DefaultRefreshTTL,
+		serviceTTL:       DefaultServiceTTL,
+		skew:             DefaultClockSkew,
 	}
 
 	return &a, nil
 }
 
+// SetLifetimes changes how long access tokens and refresh tokens stay valid.
+func (a *Authenticator) SetLifetimes(access, refresh time.Duration) {
+	a.accessTTL = access
+	a.refreshTTL = refresh
+}
+
+// SetServiceLifetime changes how long tokens issued to services stay valid.
+func (a *Authenticator) SetServiceLifetime(service time.Duration) {
+	a.serviceTTL = service
+}
+
+// SetClockSkew changes how far the clock of whoever minted a token may be
+// apart from ours. Tokens are accepted for that long after they expire and
+// before they are issued.
+func (a *Authenticator) SetClockSkew(skew time.Duration) {
+	a.skew = skew
+}
+
+// SetIssuer makes the Authenticator mint tokens with the iss and aud claims
+// set to issuer and audience and reject tokens in Validate which were minted
+// for others, such as another environment. Empty values are neither set nor
+// checked.
+func (a *Authenticator) SetIssuer(issuer, audience string) {
+	a.issuer = issuer
+	a.audience = audience
+}
+
+// Validate checks the iss and aud claims of a parsed token against those set
+// with SetIssuer.
+func (a *Authenticator) Validate(claims Claims) error {
+	if a.issuer != "" && claims.Issuer != a.issuer {
+		return ErrWrongIssuer
+	}
+	if a.audience != "" && claims.Audience != a.audience {
+		return ErrWrongAudience
+	}
+	return nil
+}
+
+// SetRevocations makes the Authenticator check tokens against list.
+func (a *Authenticator) SetRevocations(list RevocationList) {
+	a.revocations = list
+}
+
+// Revocations returns the list tokens are checked against, which is nil when
+// none was set.
+func (a *Authenticator) Revocations() RevocationList {
+	return a.revocations
+}
+
+// Revoke stops the token with claims from being accepted before it expires.
+func (a *Authenticator) Revoke(ctx context.Context, claims Claims) error {
+	if a.revocations == nil {
+		return errors.New("tokens can not be revoked without a revocation list")
+	}
+	if claims.Id == "" {
+		return ErrNotRevocable
+	}
+	return a.revocations.Revoke(ctx, claims.Id, time.Unix(claims.ExpiresAt, 0))
+}
+
+// Revoked reports whether the token with claims was revoked. Tokens without
+// an ID predate revocation and are never revoked.
+func (a *Authenticator) Revoked(ctx context.Context, claims Claims) (bool, error) {
+	if a.revocations == nil || claims.Id == "" {
+		return false, nil
+	}
+	return a.revocations.Revoked(ctx, claims.Id)
+}
+
+// AccessTTL is how long access tokens stay valid.
+func (a *Authenticator) AccessTTL() time.Duration {
+	return a.accessTTL
+}
+
+// ServiceTTL is how long tokens issued to services stay valid. They can not
+// be refreshed; services ask for a new one with their credentials.
+func (a *Authenticator) ServiceTTL() time.Duration {
+	return a.serviceTTL
+}
+
+// RefreshTTL is how long refresh tokens stay valid. A refresh token is
+// replaced by a new one every time it is used.
+func (a *Authenticator) RefreshTTL() time.Duration {
+	return a.refreshTTL
+}
+
 // GenerateToken generates a signed JWT token string representing the user Claims.
+// Claims without an issue time are issued now and those without an expiry
+// expire after the access token lifetime.
 func (a *Authenticator) GenerateToken(claims Claims) (string, error) {
 	method := jwt.GetSigningMethod(a.algorithm)
 
+	kid, key := a.activeKID, a.privateKey
+	if a.keys != nil {
+		set := a.keys.current()
+		kid, key = set.ActiveKID, set.Active
+	}
+
+	if claims.IssuedAt == 0 {
+		claims.IssuedAt = time.Now().Unix()
+	}
+	if claims.ExpiresAt == 0 {
+		claims.ExpiresAt = time.Unix(claims.IssuedAt, 0).Add(a.accessTTL).Unix()
+	}
+	if a.issuer != "" {
+		claims.Issuer = a.issuer
+	}
+	if a.audience != "" {
+		claims.Audience = a.audience
+	}
+
 	tkn := jwt.NewWithClaims(method, claims)
-	tkn.Header["kid"] = a.activeKID
+	tkn.Header["kid"] = kid
 
-	str, err := tkn.SignedString(a.privateKey)
+	str, err := tkn.SignedString(key)
 	if err != nil {
 		return "", errors.Wrap(err, "signing token")
 	}
@@ -95,6 +262,9 @@ func (a *Authenticator) GenerateToken(claims Claims) (string, error) {
 	return str, nil
 }
 
+// ParseClaims verifies the signature of a token and returns its claims. Tokens
+// which expired or are issued in the future are rejected unless the clock skew
+// covers the difference.
 func (a *Authenticator) ParseClaims(tokenStr string) (Claims, error) {
 
 	// f is a function that return the public key for validating a token.
@@ -110,7 +280,17 @@ func (a *Authenticator) ParseClaims(tokenStr string) (Claims, error) {
 			return nil, errors.New("user roken key id (kid) must be string")
 		}
 
-		return a.pubKeyLookupFunc(userKID)
+		pub, err := a.pubKeyLookupFunc(userKID)
+		if err != nil {
+			return nil, err
+		}
+
+		// The parser only accepts tokens claiming our algorithm but the
+		// key must be checked too, lest a key of another type is used.
+		if err := checkKey(a.algorithm, pub); err != nil {
+			return nil, err
+		}
+		return pub, nil
 	}
 
 	var claims Claims
@@ -124,5 +304,13 @@ func (a *Authenticator) ParseClaims(tokenStr string) (Claims, error) {
 		return Claims{}, errors.Wrap(err, "invalid token")
 	}
 
+	now := time.Now()
+	if !claims.VerifyExpiresAt(now.Add(-a.skew).Unix(), false) {
+		return Claims{}, ErrTokenExpired
+	}
+	if !claims.VerifyIssuedAt(now.Add(a.skew).Unix(), false) || !claims.VerifyNotBefore(now.Add(a.skew).Unix(), false) {
+		return Claims{}, ErrTokenNotValid
+	}
+
 	return claims, nil
 }
@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/arammikayelyan/garagesale/internal/platform/cache"
+	"github.com/arammikayelyan/garagesale/internal/platform/redis"
+	"github.com/pkg/errors"
+)
+
+// denylistPrefix namespaces the keys of revoked tokens in Redis.
+const denylistPrefix = "denylist:"
+
+// Denylist is a RevocationList kept in Redis so a token revoked on one
+// instance is refused by every instance right away. Revoked tokens seen by
+// an instance are remembered in an LRU as well, which spares Redis a round
+// trip for tokens used again and answers alone while Redis is unreachable.
+type Denylist struct {
+	redis *redis.Client
+	local *cache.LRU
+	log   *log.Logger
+}
+
+// NewDenylist constructs a Denylist in client remembering up to size revoked
+// tokens locally. Failures to reach Redis are logged to log.
+func NewDenylist(client *redis.Client, size int, log *log.Logger) *Denylist {
+	return &Denylist{
+		redis: client,
+		local: cache.NewLRU(size),
+		log:   log,
+	}
+}
+
+// Revoke implements the RevocationList interface. The token stays on the
+// list until it expires. It is refused locally even if Redis fails.
+func (d *Denylist) Revoke(ctx context.Context, id string, expires time.Time) error {
+	d.local.Set(id, true, expires)
+
+	ttl := time.Until(expires)
+	if ttl <= 0 {
+		return nil
+	}
+	value := strconv.FormatInt(expires.Unix(), 10)
+	if err := d.redis.Set(ctx, denylistPrefix+id, value, ttl); err != nil {
+		return errors.Wrap(err, "adding token to denylist")
+	}
+	return nil
+}
+
+// Revoked implements the RevocationList interface. While Redis can not be
+// reached only the tokens in the local cache are reported revoked, rather
+// than locking every user out.
+func (d *Denylist) Revoked(ctx context.Context, id string) (bool, error) {
+	if _, ok := d.local.Get(id); ok {
+		return true, nil
+	}
+
+	value, ok, err := d.redis.Get(ctx, denylistPrefix+id)
+	if err != nil {
+		d.log.Printf("checking denylist, falling back to local cache : %v", err)
+		return false, nil
+	}
+	if !ok {
+		return false, nil
+	}
+
+	// Redis expires the key with the token; a value which can not be read
+	// is cached briefly.
+	expires := time.Now().Add(time.Minute)
+	if unix, err := strconv.ParseInt(value, 10, 64); err == nil {
+		expires = time.Unix(unix, 0)
+	}
+	d.local.Set(id, true, expires)
+
+	return true, nil
+}
@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// KeySet is the keys of an Authenticator: the private key new tokens are
+// signed with and the public keys of every key tokens may still be signed
+// with, by key id (kid).
+type KeySet struct {
+	ActiveKID string
+	Active    crypto.Signer
+	Public    map[string]crypto.PublicKey
+}
+
+// KeyStore is where an Authenticator loads its keys from. Stores shared by
+// several instances, such as a database table or a cloud KMS, let every
+// instance verify the tokens the others signed and pick up rotated keys.
+type KeyStore interface {
+	Keys(ctx context.Context) (KeySet, error)
+}
+
+// FileKeys is a KeyStore holding a single key in a PEM file. Replacing the
+// file rotates the key, though tokens signed with the old one are no longer
+// accepted once it is reloaded.
+type FileKeys struct {
+	File  string
+	KeyID string
+}
+
+// Keys implements the KeyStore interface.
+func (f FileKeys) Keys(ctx context.Context) (KeySet, error) {
+	contents, err := ioutil.ReadFile(f.File)
+	if err != nil {
+		return KeySet{}, errors.Wrap(err, "reading auth private key")
+	}
+
+	key, err := ParsePrivateKey(contents)
+	if err != nil {
+		return KeySet{}, errors.Wrap(err, "parsing auth private key")
+	}
+
+	set := KeySet{
+		ActiveKID: f.KeyID,
+		Active:    key,
+		Public:    map[string]crypto.PublicKey{f.KeyID: key.Public()},
+	}
+	return set, nil
+}
+
+// minReload is how often a token signed with an unknown key may make the
+// keys be reloaded, so forged key ids can not hammer the store.
+const minReload = 10 * time.Second
+
+// keyring holds the keys loaded from a KeyStore and reloads them when they
+// get older than refresh. Reloading happens in the background so requests
+// keep using the keys at hand, except when a token is signed with a key
+// which is not known yet.
+type keyring struct {
+	store     KeyStore
+	algorithm string
+	refresh   time.Duration
+
+	mu        sync.RWMutex
+	set       KeySet
+	loaded    time.Time
+	reloading bool
+}
+
+// load replaces the keys with those in the store.
+func (k *keyring) load(ctx context.Context) error {
+	set, err := k.store.Keys(ctx)
+	if err != nil {
+		return err
+	}
+	if set.Active == nil || set.ActiveKID == "" {
+		return errors.New("key store has no active key")
+	}
+	if err := checkKey(k.algorithm, set.Active.Public()); err != nil {
+		return errors.Wrapf(err, "active key %q", set.ActiveKID)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.set = set
+	k.loaded = time.Now()
+	return nil
+}
+
+// current returns the keys at hand, reloading them in the background when
+// they are stale.
+func (k *keyring) current() KeySet {
+	k.mu.Lock()
+	set := k.set
+	stale := k.refresh > 0 && time.Since(k.loaded) >= k.refresh && !k.reloading
+	if stale {
+		k.reloading = true
+	}
+	k.mu.Unlock()
+
+	if stale {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			// A failed reload keeps the keys at hand and is tried again
+			// on the next use.
+			k.load(ctx)
+
+			k.mu.Lock()
+			k.reloading = false
+			k.mu.Unlock()
+		}()
+	}
+
+	return set
+}
+
+// lookup is the KeyLookupFunc of the keyring. A key id which is not known
+// reloads the keys first in case another instance rotated them.
+func (k *keyring) lookup(kid string) (crypto.PublicKey, error) {
+	if pub, ok := k.current().Public[kid]; ok {
+		return pub, nil
+	}
+
+	k.mu.RLock()
+	recent := time.Since(k.loaded) < minReload
+	k.mu.RUnlock()
+
+	if !recent {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := k.load(ctx); err != nil {
+			return nil, errors.Wrap(err, "reloading keys")
+		}
+		if pub, ok := k.current().Public[kid]; ok {
+			return pub, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unrecognized key id %q", kid)
+}
+
+// NewStoreAuthenticator creates an *Authenticator whose keys come from store.
+// They are reloaded every refresh so keys rotated in the store are picked up
+// without a restart; zero loads them once.
+func NewStoreAuthenticator(ctx context.Context, store KeyStore, algorithm string, refresh time.Duration) (*Authenticator, error) {
+	k := keyring{store: store, algorithm: algorithm, refresh: refresh}
+	if err := k.load(ctx); err != nil {
+		return nil, errors.Wrap(err, "loading keys")
+	}
+
+	a, err := NewAuthenticator(k.set.Active, k.set.ActiveKID, algorithm, k.lookup)
+	if err != nil {
+		return nil, err
+	}
+	a.keys = &k
+
+	return a, nil
+}
@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Action is something done to a Resource.
+type Action string
+
+// These are the actions a Policy is asked about.
+const (
+	ActionRead   Action = "read"
+	ActionUpdate Action = "update"
+	ActionDelete Action = "delete"
+)
+
+// Resource is what an Action is done to. Kind names the type of resource,
+// such as product, and Owner is the subject of the user it belongs to.
+type Resource struct {
+	Kind  string `json:"kind"`
+	ID    string `json:"id"`
+	Owner string `json:"owner"`
+}
+
+// Policy decides whether the holder of claims may do an action to a resource.
+// It holds the authorization rules of the domain, such as sellers updating
+// their own products, in one place.
+type Policy interface {
+	Allow(ctx context.Context, c Claims, action Action, res Resource) (bool, error)
+}
+
+// keyPolicy is how the Policy of a service is stored in a context.
+const keyPolicy ctxKey = 2
+
+// WithPolicy gives a copy of ctx under which Claims.Can consults p.
+func WithPolicy(ctx context.Context, p Policy) context.Context {
+	return context.WithValue(ctx, keyPolicy, p)
+}
+
+// Can reports whether the claims allow action on res under the Policy of ctx,
+// which is a RolePolicy unless WithPolicy set another.
+func (c Claims) Can(ctx context.Context, action Action, res Resource) (bool, error) {
+	p, ok := ctx.Value(keyPolicy).(Policy)
+	if !ok {
+		p = RolePolicy{}
+	}
+	return p.Allow(ctx, c, action, res)
+}
+
+// RolePolicy is the Policy based on roles: admins may do anything and
+// everyone else may do anything to what they own.
+type RolePolicy struct{}
+
+// Allow implements the Policy interface.
+func (RolePolicy) Allow(ctx context.Context, c Claims, action Action, res Resource) (bool, error) {
+	if c.HasRole(RoleAdmin) {
+		return true, nil
+	}
+	return res.Owner != "" && res.Owner == c.Subject, nil
+}
+
+// OPAPolicy is a Policy deciding through an Open Policy Agent. It posts
+//
+//	{"input": {"subject": "...", "roles": ["USER"], "action": "update", "resource": {...}}}
+//
+// to the URL of a rule of the agent, such as /v1/data/garagesale/allow, and
+// expects {"result": true} for allowed actions. An undefined result denies.
+type OPAPolicy struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewOPAPolicy constructs an OPAPolicy asking the rule at url.
+func NewOPAPolicy(url string) *OPAPolicy {
+	return &OPAPolicy{
+		URL:    url,
+		Client: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// Allow implements the Policy interface.
+func (o *OPAPolicy) Allow(ctx context.Context, c Claims, action Action, res Resource) (bool, error) {
+	var in struct {
+		Input struct {
+			Subject  string   `json:"subject"`
+			Roles    []Role   `json:"roles"`
+			Scopes   []string `json:"scopes,omitempty"`
+			TenantID string   `json:"tenant_id,omitempty"`
+			Action   Action   `json:"action"`
+			Resource Resource `json:"resource"`
+		} `json:"input"`
+	}
+	in.Input.Subject = c.Subject
+	in.Input.Roles = c.Roles
+	in.Input.Scopes = c.Scopes
+	in.Input.TenantID = c.TenantID
+	in.Input.Action = action
+	in.Input.Resource = res
+
+	data, err := json.Marshal(in)
+	if err != nil {
+		return false, errors.Wrap(err, "encoding policy input")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.URL, bytes.NewReader(data))
+	if err != nil {
+		return false, errors.Wrap(err, "creating policy request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := o.Client.Do(req)
+	if err != nil {
+		return false, errors.Wrap(err, "calling policy agent")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, errors.Errorf("policy agent responded %d", resp.StatusCode)
+	}
+
+	var out struct {
+		Result bool `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return false, errors.Wrap(err, "decoding policy decision")
+	}
+
+	return out.Result, nil
+}
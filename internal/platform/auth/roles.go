@@ -1,9 +1,11 @@
 package auth
 
 import (
+	"fmt"
 	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
 )
 
 // ctxKey represents the type of value for the context key.
@@ -12,22 +14,97 @@ type ctxKey int
 // Key is used to store/retrieve a Claims value from a context.Context.
 const Key ctxKey = 1
 
+// Role is what a user is allowed to do.
+type Role string
+
 // These are the expected values for Claims.Roles
 const (
-	RoleAdmin = "ADMIN"
-	RoleUser  = "USER"
+	RoleAdmin Role = "ADMIN"
+	RoleUser  Role = "USER"
 )
 
-// Claims represents the authorization claims transmitted via a JWT
+// Roles lists every Role.
+var Roles = []Role{RoleAdmin, RoleUser}
+
+// Valid reports whether r is one of the known roles.
+func (r Role) Valid() bool {
+	switch r {
+	case RoleAdmin, RoleUser:
+		return true
+	}
+	return false
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler so unknown roles are
+// rejected when decoding.
+func (r *Role) UnmarshalText(text []byte) error {
+	role, err := ParseRole(string(text))
+	if err != nil {
+		return err
+	}
+	*r = role
+	return nil
+}
+
+// ParseRole converts s to a Role, failing when it is not a known role.
+func ParseRole(s string) (Role, error) {
+	r := Role(s)
+	if !r.Valid() {
+		return "", fmt.Errorf("unknown role %q", s)
+	}
+	return r, nil
+}
+
+// ParseRoles converts every element of list to a Role.
+func ParseRoles(list []string) ([]Role, error) {
+	roles := make([]Role, len(list))
+	for i, s := range list {
+		r, err := ParseRole(s)
+		if err != nil {
+			return nil, err
+		}
+		roles[i] = r
+	}
+	return roles, nil
+}
+
+// Strings converts roles to plain strings, such as for storing them.
+func Strings(roles []Role) []string {
+	list := make([]string, len(roles))
+	for i, r := range roles {
+		list[i] = string(r)
+	}
+	return list
+}
+
+// Claims represents the authorization claims transmitted via a JWT. SessionID
+// names the sign in the token was issued for. Act is set when someone else
+// acts as the subject, such as an admin impersonating a user. Scopes restrict
+// the token to some of what its subject may do, such as for a service which
+// only reads sales. ClientID is set on tokens issued to services, whose
+// subject is the client rather than a user.
 type Claims struct {
-	Roles []string `json:"roles"`
+	Roles     []Role   `json:"roles"`
+	Scopes    []string `json:"scopes,omitempty"`
+	Verified  bool     `json:"verified"`
+	TenantID  string   `json:"tenant_id,omitempty"`
+	SessionID string   `json:"sid,omitempty"`
+	Act       *Actor   `json:"act,omitempty"`
+	ClientID  string   `json:"client_id,omitempty"`
 	jwt.StandardClaims
 }
 
-func NewClaims(subject string, roles []string, now time.Time, expires time.Duration) Claims {
+// Actor is who acts on behalf of the subject of a token, in the form of the
+// act claim of RFC 8693.
+type Actor struct {
+	Subject string `json:"sub"`
+}
+
+func NewClaims(subject string, roles []Role, now time.Time, expires time.Duration) Claims {
 	c := Claims{
 		Roles: roles,
 		StandardClaims: jwt.StandardClaims{
+			Id:        uuid.New().String(),
 			Subject:   subject,
 			IssuedAt:  now.Unix(),
 			ExpiresAt: now.Add(expires).Unix(),
@@ -38,7 +115,7 @@ func NewClaims(subject string, roles []string, now time.Time, expires time.Durat
 }
 
 // HasRole returns true if the claims has at least one of the provided roles.
-func (c Claims) HasRole(roles ...string) bool {
+func (c Claims) HasRole(roles ...Role) bool {
 	for _, has := range c.Roles {
 		for _, want := range roles {
 			if has == want {
@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+)
+
+// These are the scopes a token may be restricted to. A token without scopes
+// is only limited by the roles of its subject.
+const (
+	ScopeAccount       = "account"
+	ScopeAdmin         = "admin"
+	ScopeProductsRead  = "products:read"
+	ScopeProductsWrite = "products:write"
+	ScopeSalesRead     = "sales:read"
+	ScopeSalesWrite    = "sales:write"
+	ScopeCouponsRead   = "coupons:read"
+	ScopeCouponsWrite  = "coupons:write"
+	ScopeEventsWrite   = "events:write"
+	ScopeReportsRead   = "reports:read"
+)
+
+// Scopes lists every scope.
+var Scopes = []string{
+	ScopeAccount, ScopeAdmin,
+	ScopeProductsRead, ScopeProductsWrite,
+	ScopeSalesRead, ScopeSalesWrite,
+	ScopeCouponsRead, ScopeCouponsWrite,
+	ScopeEventsWrite, ScopeReportsRead,
+}
+
+// ParseScopes converts a space separated list of scopes, the form of the
+// scope parameter of OAuth 2, failing on scopes which are not known.
+func ParseScopes(s string) ([]string, error) {
+	var scopes []string
+	for _, scope := range strings.Fields(s) {
+		known := false
+		for _, k := range Scopes {
+			if scope == k {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown scope %q", scope)
+		}
+		scopes = append(scopes, scope)
+	}
+	return scopes, nil
+}
+
+// HasScope returns true if the claims allow every one of the provided scopes.
+// Claims without any scopes allow them all.
+func (c Claims) HasScope(scopes ...string) bool {
+	if len(c.Scopes) == 0 {
+		return true
+	}
+	for _, want := range scopes {
+		found := false
+		for _, has := range c.Scopes {
+			if has == want {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
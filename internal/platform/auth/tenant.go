@@ -0,0 +1,16 @@
+package auth
+
+import "context"
+
+// TenantScope gives the tenant the rows a request may reach are limited to:
+// the tenant of the Claims in ctx, or an empty string for users who belong to
+// no tenant. Contexts without Claims, such as those of background jobs and
+// public pages, are not limited and give nil. The result is meant as the
+// argument of the condition built by database.InTenant.
+func TenantScope(ctx context.Context) interface{} {
+	claims, ok := ctx.Value(Key).(Claims)
+	if !ok {
+		return nil
+	}
+	return claims.TenantID
+}
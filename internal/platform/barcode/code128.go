@@ -0,0 +1,64 @@
+// Package barcode encodes data as one dimensional barcodes which can be
+// printed by any renderer able to draw rectangles.
+package barcode
+
+import (
+	"github.com/pkg/errors"
+)
+
+// code128 holds the bar and space widths of every Code 128 symbol indexed by
+// its value. The last entry is the stop pattern.
+var code128 = [...]string{
+	"212222", "222122", "222221", "121223", "121322", "131222", "122213", "122312", "132212", "221213",
+	"221312", "231212", "112232", "122132", "122231", "113222", "123122", "123221", "223211", "221132",
+	"221231", "213212", "223112", "312131", "311222", "321122", "321221", "312212", "322112", "322211",
+	"212123", "212321", "232121", "111323", "131123", "131321", "112313", "132113", "132311", "211313",
+	"231113", "231311", "112133", "112331", "132131", "113123", "113321", "133121", "313121", "211331",
+	"231131", "213113", "213311", "213131", "311123", "311321", "331121", "312113", "312311", "332111",
+	"314111", "221411", "431111", "111224", "111422", "121124", "121421", "141122", "141221", "112214",
+	"112412", "122114", "122411", "142112", "142211", "241211", "221114", "413111", "241112", "134111",
+	"111242", "121142", "121241", "114212", "124112", "124211", "411212", "421112", "421211", "212141",
+	"214121", "412121", "111143", "111341", "131141", "114113", "114311", "411113", "411311", "113141",
+	"114131", "311141", "411131", "211412", "211214", "211232", "2331112",
+}
+
+// Values of the special Code 128 symbols.
+const (
+	startB = 104
+	stop   = 106
+)
+
+// Code128 encodes data using Code 128 code set B. It returns the modules of
+// the barcode from left to right where true is a bar and false is a space.
+// Quiet zones are not included. Only printable ASCII characters are supported.
+func Code128(data string) ([]bool, error) {
+	if data == "" {
+		return nil, errors.New("barcode data cannot be empty")
+	}
+
+	values := []int{startB}
+	checksum := startB
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		if c < 32 || c > 126 {
+			return nil, errors.Errorf("character %q can not be encoded", c)
+		}
+		v := int(c) - 32
+		values = append(values, v)
+		checksum += v * (i + 1)
+	}
+	values = append(values, checksum%103, stop)
+
+	var modules []bool
+	for _, v := range values {
+		bar := true
+		for _, w := range code128[v] {
+			for n := 0; n < int(w-'0'); n++ {
+				modules = append(modules, bar)
+			}
+			bar = !bar
+		}
+	}
+
+	return modules, nil
+}
@@ -0,0 +1,130 @@
+// Package blob stores files too large to keep in the database, such as
+// export artifacts, and signs the URLs they are downloaded from.
+package blob
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNotFound is returned when there is no blob with a key.
+var ErrNotFound = errors.New("blob not found")
+
+// Store keeps blobs under keys. Keys are slash separated paths.
+type Store interface {
+	Put(ctx context.Context, key string, r io.Reader) error
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// Dir is a Store keeping each blob as a file under Root.
+type Dir struct {
+	Root string
+}
+
+// Put implements the Store interface. The file is written under a temporary
+// name and renamed so readers never see a partial blob.
+func (d Dir) Put(ctx context.Context, key string, r io.Reader) error {
+	name, err := d.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return errors.Wrap(err, "creating blob directory")
+	}
+
+	tmp := name + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return errors.Wrap(err, "creating blob file")
+	}
+	defer os.Remove(tmp)
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return errors.Wrap(err, "writing blob file")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "closing blob file")
+	}
+
+	if err := os.Rename(tmp, name); err != nil {
+		return errors.Wrap(err, "publishing blob file")
+	}
+	return nil
+}
+
+// Open implements the Store interface.
+func (d Dir) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	name, err := d.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "opening blob file")
+	}
+	return f, nil
+}
+
+// Delete implements the Store interface. Deleting a missing blob is not an
+// error.
+func (d Dir) Delete(ctx context.Context, key string) error {
+	name, err := d.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "deleting blob file")
+	}
+	return nil
+}
+
+// path gives the file a key is kept in, refusing keys which would escape
+// Root.
+func (d Dir) path(key string) (string, error) {
+	clean := filepath.Clean("/" + key)
+	if clean == "/" || strings.Contains(key, "..") {
+		return "", errors.Errorf("invalid blob key %q", key)
+	}
+	return filepath.Join(d.Root, filepath.FromSlash(clean)), nil
+}
+
+// Signer signs download URLs so whoever holds one may download a blob until
+// it expires, without authenticating otherwise.
+type Signer struct {
+	Key []byte
+}
+
+// Sign gives the signature of a download of the blob named name valid until
+// expires.
+func (s Signer) Sign(name string, expires time.Time) string {
+	mac := hmac.New(sha256.New, s.Key)
+	mac.Write([]byte(name))
+	mac.Write([]byte{0})
+	mac.Write([]byte(strconv.FormatInt(expires.Unix(), 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature was made by Sign for name and expires and
+// has not expired at now.
+func (s Signer) Verify(name string, expires time.Time, signature string, now time.Time) bool {
+	if !now.Before(expires) {
+		return false
+	}
+	want := s.Sign(name, expires)
+	return hmac.Equal([]byte(want), []byte(signature))
+}
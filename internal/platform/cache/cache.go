@@ -0,0 +1,72 @@
+// Package cache provides a small in-memory cache whose entries expire after a
+// fixed time to live.
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+// item is a cached value along with when it expires.
+type item struct {
+	value   interface{}
+	expires time.Time
+}
+
+// Cache holds values for a fixed duration. It is safe for concurrent use.
+type Cache struct {
+	ttl time.Duration
+
+	mu    sync.Mutex
+	items map[string]item
+}
+
+// New constructs a Cache whose entries live for ttl.
+func New(ttl time.Duration) *Cache {
+	c := Cache{
+		ttl:   ttl,
+		items: make(map[string]item),
+	}
+	return &c
+}
+
+// Get returns the value stored under key if it has not expired yet.
+func (c *Cache) Get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	it, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(it.expires) {
+		delete(c.items, key)
+		return nil, false
+	}
+	return it.value, true
+}
+
+// Set stores value under key replacing any previous value.
+func (c *Cache) Set(key string, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Sweep expired entries while we hold the lock so keys which are never
+	// read again do not accumulate.
+	now := time.Now()
+	for k, it := range c.items {
+		if now.After(it.expires) {
+			delete(c.items, k)
+		}
+	}
+
+	c.items[key] = item{value: value, expires: now.Add(c.ttl)}
+}
+
+// Delete removes the value stored under key.
+func (c *Cache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.items, key)
+}
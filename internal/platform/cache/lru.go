@@ -0,0 +1,73 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// LRU holds up to a fixed number of values, each until its own expiry. When
+// it is full the least recently used value makes room for a new one. It is
+// safe for concurrent use.
+type LRU struct {
+	size int
+
+	mu    sync.Mutex
+	order *list.List
+	items map[string]*list.Element
+}
+
+// entry is a value of an LRU along with its key and when it expires.
+type entry struct {
+	key     string
+	value   interface{}
+	expires time.Time
+}
+
+// NewLRU constructs an LRU holding up to size values.
+func NewLRU(size int) *LRU {
+	l := LRU{
+		size:  size,
+		order: list.New(),
+		items: make(map[string]*list.Element),
+	}
+	return &l
+}
+
+// Get returns the value stored under key if it has not expired yet.
+func (l *LRU) Get(key string) (interface{}, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	el, ok := l.items[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*entry)
+	if time.Now().After(e.expires) {
+		l.order.Remove(el)
+		delete(l.items, key)
+		return nil, false
+	}
+	l.order.MoveToFront(el)
+	return e.value, true
+}
+
+// Set stores value under key until expires, replacing any previous value.
+func (l *LRU) Set(key string, value interface{}, expires time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if el, ok := l.items[key]; ok {
+		el.Value = &entry{key: key, value: value, expires: expires}
+		l.order.MoveToFront(el)
+		return
+	}
+
+	for l.order.Len() >= l.size && l.order.Len() > 0 {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.items, oldest.Value.(*entry).key)
+	}
+	l.items[key] = l.order.PushFront(&entry{key: key, value: value, expires: expires})
+}
@@ -0,0 +1,86 @@
+// Package clock abstracts reading the current time so time dependent logic
+// can run against a frozen or shifted clock in tests, and so the service can
+// follow a trusted time source instead of the host clock.
+package clock
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// Clock tells the current time.
+type Clock interface {
+	Now() time.Time
+}
+
+// System is the host clock.
+type System struct{}
+
+// Now implements the Clock interface.
+func (System) Now() time.Time {
+	return time.Now()
+}
+
+// Offset is a Clock running D ahead of Base, or behind it when D is negative.
+type Offset struct {
+	Base Clock
+	D    time.Duration
+}
+
+// Now implements the Clock interface.
+func (o Offset) Now() time.Time {
+	return o.Base.Now().Add(o.D)
+}
+
+// Frozen is a Clock which stands still until it is moved. It is safe for
+// concurrent use.
+type Frozen struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+// NewFrozen constructs a Frozen clock stopped at t.
+func NewFrozen(t time.Time) *Frozen {
+	return &Frozen{t: t}
+}
+
+// Now implements the Clock interface.
+func (f *Frozen) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.t
+}
+
+// Set stops the clock at t.
+func (f *Frozen) Set(t time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.t = t
+}
+
+// Advance moves the clock forward by d.
+func (f *Frozen) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.t = f.t.Add(d)
+}
+
+// Drift measures how far the database clock is ahead of c. It is negative
+// when the database is behind. The round trip is split evenly between the
+// request and the response.
+func Drift(ctx context.Context, db *sqlx.DB, c Clock) (time.Duration, error) {
+	var dbNow time.Time
+
+	before := c.Now()
+	if err := db.GetContext(ctx, &dbNow, `SELECT clock_timestamp()`); err != nil {
+		return 0, errors.Wrap(err, "reading database clock")
+	}
+	after := c.Now()
+
+	mid := before.Add(after.Sub(before) / 2)
+	return dbNow.Sub(mid), nil
+}
@@ -0,0 +1,311 @@
+// Package contract verifies consumer contracts against the API. Client teams
+// publish what they send and what they rely on in the response as Pact (v2)
+// files; the API's test suite replays every interaction against its handler
+// and fails when a response no longer satisfies a consumer.
+package contract
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// Pact is a contract between one consumer and the API.
+type Pact struct {
+	Consumer     Party         `json:"consumer"`
+	Provider     Party         `json:"provider"`
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Party names a side of a contract.
+type Party struct {
+	Name string `json:"name"`
+}
+
+// Interaction is one request a consumer makes and the response it expects.
+type Interaction struct {
+	Description   string   `json:"description"`
+	ProviderState string   `json:"providerState"`
+	Request       Request  `json:"request"`
+	Response      Response `json:"response"`
+}
+
+// Request is the request made by the consumer.
+type Request struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Query   string            `json:"query"`
+	Headers map[string]string `json:"headers"`
+	Body    json.RawMessage   `json:"body"`
+}
+
+// Response is what the consumer relies on in the response. Only the headers
+// and body fields listed are checked; matching rules loosen the check for
+// values the consumer does not care about exactly.
+type Response struct {
+	Status        int                  `json:"status"`
+	Headers       map[string]string    `json:"headers"`
+	Body          json.RawMessage      `json:"body"`
+	MatchingRules map[string]MatchRule `json:"matchingRules"`
+}
+
+// MatchRule loosens how the value at a path is matched. Match "type" only
+// requires the same JSON type while Regex requires a string matching it.
+type MatchRule struct {
+	Match string `json:"match"`
+	Regex string `json:"regex"`
+}
+
+// Load reads the pact file at path.
+func Load(path string) (Pact, error) {
+	var p Pact
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return p, errors.Wrap(err, "reading pact")
+	}
+	if err := json.Unmarshal(data, &p); err != nil {
+		return p, errors.Wrapf(err, "decoding pact %s", path)
+	}
+
+	return p, nil
+}
+
+// LoadDir reads every pact file in dir.
+func LoadDir(dir string) ([]Pact, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, errors.Wrap(err, "listing pacts")
+	}
+	sort.Strings(paths)
+
+	pacts := make([]Pact, 0, len(paths))
+	for _, path := range paths {
+		p, err := Load(path)
+		if err != nil {
+			return nil, err
+		}
+		pacts = append(pacts, p)
+	}
+
+	return pacts, nil
+}
+
+// Verifier replays the interactions of pacts against a handler.
+type Verifier struct {
+	Handler http.Handler
+
+	// States sets up the provider states interactions depend on, such as
+	// "a product exists". Interactions with an unknown state fail.
+	States map[string]func(ctx context.Context) error
+
+	// Prepare is called with every request before it is sent, for example to
+	// add an authorization header.
+	Prepare func(r *http.Request)
+}
+
+// Verify runs every interaction of pacts as a subtest of t.
+func (v *Verifier) Verify(t *testing.T, pacts ...Pact) {
+	t.Helper()
+
+	for _, p := range pacts {
+		for _, in := range p.Interactions {
+			in := in
+			t.Run(p.Consumer.Name+"/"+in.Description, func(t *testing.T) {
+				for _, problem := range v.check(in) {
+					t.Error(problem)
+				}
+			})
+		}
+	}
+}
+
+// check replays in and returns how the response fails to satisfy it.
+func (v *Verifier) check(in Interaction) []string {
+	if in.ProviderState != "" {
+		setup, ok := v.States[in.ProviderState]
+		if !ok {
+			return []string{fmt.Sprintf("unknown provider state %q", in.ProviderState)}
+		}
+		if err := setup(context.Background()); err != nil {
+			return []string{fmt.Sprintf("setting up state %q: %v", in.ProviderState, err)}
+		}
+	}
+
+	target := in.Request.Path
+	if in.Request.Query != "" {
+		target += "?" + in.Request.Query
+	}
+	var body []byte
+	if len(in.Request.Body) > 0 {
+		body = in.Request.Body
+	}
+	r := httptest.NewRequest(in.Request.Method, target, bytes.NewReader(body))
+	for k, val := range in.Request.Headers {
+		r.Header.Set(k, val)
+	}
+	if len(body) > 0 && r.Header.Get("Content-Type") == "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	if v.Prepare != nil {
+		v.Prepare(r)
+	}
+
+	w := httptest.NewRecorder()
+	v.Handler.ServeHTTP(w, r)
+
+	var problems []string
+	if w.Code != in.Response.Status {
+		problems = append(problems, fmt.Sprintf("status: got %d, want %d", w.Code, in.Response.Status))
+	}
+
+	for k, want := range in.Response.Headers {
+		if got := w.Header().Get(k); !strings.EqualFold(strings.TrimSpace(got), strings.TrimSpace(want)) {
+			problems = append(problems, fmt.Sprintf("header %s: got %q, want %q", k, got, want))
+		}
+	}
+
+	if len(in.Response.Body) == 0 {
+		return problems
+	}
+
+	var want, got interface{}
+	if err := json.Unmarshal(in.Response.Body, &want); err != nil {
+		return append(problems, fmt.Sprintf("decoding expected body: %v", err))
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		return append(problems, fmt.Sprintf("decoding response body: %v", err))
+	}
+
+	m := matcher{rules: in.Response.MatchingRules}
+	m.match("$.body", want, got, false)
+
+	return append(problems, m.problems...)
+}
+
+// index finds array indexes in paths so rules written for [*] apply to every
+// element.
+var index = regexp.MustCompile(`\[\d+\]`)
+
+// matcher compares an expected JSON value with an actual one.
+type matcher struct {
+	rules    map[string]MatchRule
+	problems []string
+}
+
+// rule returns the matching rule for path, if any.
+func (m *matcher) rule(path string) (MatchRule, bool) {
+	if r, ok := m.rules[path]; ok {
+		return r, true
+	}
+	r, ok := m.rules[index.ReplaceAllString(path, "[*]")]
+	return r, ok
+}
+
+func (m *matcher) fail(path, format string, args ...interface{}) {
+	m.problems = append(m.problems, path+": "+fmt.Sprintf(format, args...))
+}
+
+// match compares want and got at path. Objects may have fields the consumer
+// does not use; arrays must have the same length unless matched by type. A
+// type match applies to everything below it, so only the JSON types are
+// compared when loose is set.
+func (m *matcher) match(path string, want, got interface{}, loose bool) {
+	r, ok := m.rule(path)
+	if ok && r.Match == "type" {
+		loose = true
+	}
+
+	switch {
+	case ok && r.Regex != "":
+		s, isString := got.(string)
+		if !isString {
+			m.fail(path, "got %s, want a string", kind(got))
+			return
+		}
+		if matched, err := regexp.MatchString(r.Regex, s); err != nil || !matched {
+			m.fail(path, "%q does not match %q", s, r.Regex)
+		}
+		return
+
+	case loose:
+		if kind(want) != kind(got) {
+			m.fail(path, "got %s, want %s", kind(got), kind(want))
+			return
+		}
+		if w, isArray := want.([]interface{}); isArray && len(w) > 0 {
+			for i, g := range got.([]interface{}) {
+				m.match(fmt.Sprintf("%s[%d]", path, i), w[0], g, true)
+			}
+			return
+		}
+		if _, isObject := want.(map[string]interface{}); !isObject {
+			return
+		}
+	}
+
+	switch w := want.(type) {
+	case map[string]interface{}:
+		g, isObject := got.(map[string]interface{})
+		if !isObject {
+			m.fail(path, "got %s, want object", kind(got))
+			return
+		}
+		for k, wv := range w {
+			gv, found := g[k]
+			if !found {
+				m.fail(path+"."+k, "missing")
+				continue
+			}
+			m.match(path+"."+k, wv, gv, loose)
+		}
+
+	case []interface{}:
+		g, isArray := got.([]interface{})
+		if !isArray {
+			m.fail(path, "got %s, want array", kind(got))
+			return
+		}
+		if len(g) != len(w) {
+			m.fail(path, "got %d elements, want %d", len(g), len(w))
+			return
+		}
+		for i := range w {
+			m.match(fmt.Sprintf("%s[%d]", path, i), w[i], g[i], loose)
+		}
+
+	default:
+		if want != got {
+			m.fail(path, "got %v, want %v", got, want)
+		}
+	}
+}
+
+// kind names the JSON type of v.
+func kind(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
@@ -0,0 +1,95 @@
+package contract
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// product serves a product the way the API does.
+func product(w http.ResponseWriter, r *http.Request, body string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if r.URL.Path != "/v1/products/1" {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":"product not found"}`))
+		return
+	}
+	w.Write([]byte(body))
+}
+
+func TestVerify(t *testing.T) {
+	pacts, err := LoadDir("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pacts) != 1 || len(pacts[0].Interactions) != 1 {
+		t.Fatalf("loaded %d pacts, want 1 with 1 interaction", len(pacts))
+	}
+
+	var prepared bool
+	v := Verifier{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			product(w, r, `{"id":"1","name":"Comic Books","cost":75,"tags":["comics","vintage"],"quantity":3,"date_created":"2020-05-01T10:00:00Z"}`)
+		}),
+		States: map[string]func(context.Context) error{
+			"a product exists": func(context.Context) error { return nil },
+		},
+		Prepare: func(r *http.Request) { prepared = true },
+	}
+	v.Verify(t, pacts...)
+
+	if !prepared {
+		t.Error("Prepare was not called")
+	}
+}
+
+func TestCheckFailures(t *testing.T) {
+	pacts, err := LoadDir("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := pacts[0].Interactions[0]
+
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"renamed field", `{"id":"1","title":"Comic Books","cost":50,"tags":[],"date_created":"2020-05-01T10:00:00Z"}`, "$.body.name: missing"},
+		{"changed value", `{"id":"1","name":"Comics","cost":50,"tags":[],"date_created":"2020-05-01T10:00:00Z"}`, "$.body.name: got Comics"},
+		{"changed type", `{"id":"1","name":"Comic Books","cost":"50","tags":[],"date_created":"2020-05-01T10:00:00Z"}`, "$.body.cost: got string, want number"},
+		{"bad format", `{"id":"1","name":"Comic Books","cost":50,"tags":[],"date_created":"yesterday"}`, "$.body.date_created: \"yesterday\" does not match"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := Verifier{
+				Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					product(w, r, tt.body)
+				}),
+				States: map[string]func(context.Context) error{
+					"a product exists": func(context.Context) error { return nil },
+				},
+			}
+
+			problems := v.check(in)
+			if len(problems) != 1 || !strings.HasPrefix(problems[0], tt.want) {
+				t.Fatalf("got problems %q, want one starting with %q", problems, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckUnknownState(t *testing.T) {
+	pacts, err := LoadDir("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := Verifier{Handler: http.NotFoundHandler()}
+	problems := v.check(pacts[0].Interactions[0])
+	if len(problems) != 1 || !strings.Contains(problems[0], "unknown provider state") {
+		t.Fatalf("got problems %q, want an unknown provider state", problems)
+	}
+}
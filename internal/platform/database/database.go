@@ -2,18 +2,26 @@ package database
 
 import (
 	"context"
+	"database/sql"
 	"net/url"
+	"strconv"
 
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq" // Register the postgres database/sql driver.
+	"github.com/lib/pq" // Also registers the postgres database/sql driver.
 )
 
+// Config is how to reach the database. AppName is the application_name of
+// connections. When TagTraces is set connections are also tagged with the
+// trace ID of the request using them so what Postgres logs and shows in
+// pg_stat_activity can be matched to traces.
 type Config struct {
 	User       string
 	Password   string
 	Host       string
 	Name       string
 	DisableTLS bool
+	AppName    string
+	TagTraces  bool
 }
 
 // Open function opens a database connection
@@ -25,6 +33,9 @@ func Open(cfg Config) (*sqlx.DB, error) {
 		q.Set("sslmode", "disable")
 	}
 	q.Set("timezone", "utc")
+	if cfg.AppName != "" {
+		q.Set("application_name", cfg.AppName)
+	}
 
 	u := url.URL{
 		Scheme:   "postgres",
@@ -34,7 +45,16 @@ func Open(cfg Config) (*sqlx.DB, error) {
 		RawQuery: q.Encode(),
 	}
 
-	return sqlx.Open("postgres", u.String())
+	if !cfg.TagTraces {
+		return sqlx.Open("postgres", u.String())
+	}
+
+	c, err := pq.NewConnector(u.String())
+	if err != nil {
+		return nil, err
+	}
+	db := sql.OpenDB(sessionConnector{Connector: c, appName: cfg.AppName})
+	return sqlx.NewDb(db, "postgres"), nil
 }
 
 // StatusCheck returns nil if it can successfully talk to the database. It
@@ -49,3 +69,12 @@ func StatusCheck(ctx context.Context, db *sqlx.DB) error {
 	var tmp bool
 	return db.QueryRowContext(ctx, q).Scan(&tmp)
 }
+
+// InTenant builds the condition limiting rows to a tenant. Column is the
+// tenant_id column of the rows, such as p.tenant_id, and n the number of the
+// parameter holding the scope from auth.TenantScope: nil matches every row,
+// an empty string rows of no tenant and otherwise rows of that tenant.
+func InTenant(column string, n int) string {
+	p := "$" + strconv.Itoa(n) + "::TEXT"
+	return "(" + p + " IS NULL OR " + column + " IS NOT DISTINCT FROM NULLIF(" + p + ", '')::UUID)"
+}
@@ -0,0 +1,157 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+
+	"go.opencensus.io/trace"
+)
+
+// TraceSetting is the session setting holding the trace ID of the request
+// a connection was last used for. It can be read with
+// current_setting('garagesale.trace_id', true).
+const TraceSetting = "garagesale.trace_id"
+
+// sessionConnector makes connections which are tagged with the trace ID of
+// the request using them.
+type sessionConnector struct {
+	driver.Connector
+	appName string
+}
+
+// Connect implements the driver.Connector interface.
+func (c sessionConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &sessionConn{Conn: conn, appName: c.appName}, nil
+}
+
+// sessionConn is a connection which sets application_name and TraceSetting
+// whenever it is used for another trace than before. Statements run within
+// a transaction are left alone since they belong to the trace which began it.
+type sessionConn struct {
+	driver.Conn
+	appName string
+	traceID string
+	inTx    bool
+}
+
+// tag updates the session settings when ctx is of another trace than the
+// one the connection was last used for. Connections used without a trace
+// go back to the plain application name.
+func (c *sessionConn) tag(ctx context.Context) error {
+	if c.inTx {
+		return nil
+	}
+
+	var traceID string
+	if span := trace.FromContext(ctx); span != nil {
+		if id := span.SpanContext().TraceID; id != (trace.TraceID{}) {
+			traceID = id.String()
+		}
+	}
+	if traceID == c.traceID {
+		return nil
+	}
+
+	q, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil
+	}
+
+	// application_name is what pg_stat_activity and the %a of log_line_prefix
+	// show, so it carries the trace ID as well.
+	name := c.appName
+	if traceID != "" {
+		name = strings.TrimSpace(c.appName + " " + traceID)
+	}
+	const stmt = `SELECT set_config('application_name', $1, false), set_config('` + TraceSetting + `', $2, false)`
+	args := []driver.NamedValue{
+		{Ordinal: 1, Value: name},
+		{Ordinal: 2, Value: traceID},
+	}
+	rows, err := q.QueryContext(ctx, stmt, args)
+	if err != nil {
+		return err
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+
+	c.traceID = traceID
+	return nil
+}
+
+// QueryContext implements the driver.QueryerContext interface.
+func (c *sessionConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	if err := c.tag(ctx); err != nil {
+		return nil, err
+	}
+	return q.QueryContext(ctx, query, args)
+}
+
+// ExecContext implements the driver.ExecerContext interface.
+func (c *sessionConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	if err := c.tag(ctx); err != nil {
+		return nil, err
+	}
+	return e.ExecContext(ctx, query, args)
+}
+
+// BeginTx implements the driver.ConnBeginTx interface.
+func (c *sessionConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if err := c.tag(ctx); err != nil {
+		return nil, err
+	}
+
+	var tx driver.Tx
+	var err error
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		tx, err = b.BeginTx(ctx, opts)
+	} else {
+		tx, err = c.Conn.Begin()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c.inTx = true
+	return sessionTx{Tx: tx, conn: c}, nil
+}
+
+// Ping implements the driver.Pinger interface.
+func (c *sessionConn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+// sessionTx lets its connection know when the transaction is over.
+type sessionTx struct {
+	driver.Tx
+	conn *sessionConn
+}
+
+// Commit implements the driver.Tx interface.
+func (t sessionTx) Commit() error {
+	t.conn.inTx = false
+	return t.Tx.Commit()
+}
+
+// Rollback implements the driver.Tx interface.
+func (t sessionTx) Rollback() error {
+	t.conn.inTx = false
+	return t.Tx.Rollback()
+}
@@ -0,0 +1,113 @@
+// Package imagehash computes perceptual hashes of images. Unlike checksums
+// they change little when an image is resized, recompressed or slightly
+// edited, so the number of bits two hashes differ by tells how alike the
+// images look.
+package imagehash
+
+import (
+	"image"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+// side is the width and height images are shrunk to before hashing.
+const side = 32
+
+// PHash computes the DCT based perceptual hash of img. The image is reduced
+// to a 32x32 grayscale, transformed to frequencies and each of the 64 lowest
+// frequencies gives a bit telling whether it is above their median.
+func PHash(img image.Image) uint64 {
+	gray := shrink(img)
+
+	// Only the 8x8 lowest frequencies are needed so the transform of the
+	// rows and then the columns stops there.
+	var rows [side][8]float64
+	for y := 0; y < side; y++ {
+		for u := 0; u < 8; u++ {
+			var sum float64
+			for x := 0; x < side; x++ {
+				sum += gray[y][x] * cosines[u][x]
+			}
+			rows[y][u] = sum
+		}
+	}
+	var freqs [64]float64
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			var sum float64
+			for y := 0; y < side; y++ {
+				sum += rows[y][u] * cosines[v][y]
+			}
+			freqs[v*8+u] = sum
+		}
+	}
+
+	// The first frequency is the average brightness and would dominate the
+	// median, so it is left out of it.
+	sorted := make([]float64, 63)
+	copy(sorted, freqs[1:])
+	sort.Float64s(sorted)
+	median := (sorted[31] + sorted[32]) / 2
+
+	var hash uint64
+	for i, f := range freqs {
+		if f > median {
+			hash |= 1 << uint(63-i)
+		}
+	}
+	return hash
+}
+
+// Distance is the number of bits hashes a and b differ by. Hashes of the
+// same picture are usually within a few bits of each other while those of
+// unrelated ones differ by about half of them.
+func Distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// cosines holds the DCT-II basis for the lowest 8 frequencies.
+var cosines = func() [8][side]float64 {
+	var c [8][side]float64
+	for u := range c {
+		for x := range c[u] {
+			c[u][x] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / (2 * side))
+		}
+	}
+	return c
+}()
+
+// shrink reduces img to side by side luminance values by averaging the
+// pixels covered by each cell.
+func shrink(img image.Image) [side][side]float64 {
+	var sums, counts [side][side]float64
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w == 0 || h == 0 {
+		return sums
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		cy := (y - b.Min.Y) * side / h
+		for x := b.Min.X; x < b.Max.X; x++ {
+			cx := (x - b.Min.X) * side / w
+			r, g, bl, _ := img.At(x, y).RGBA()
+			sums[cy][cx] += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(bl)
+			counts[cy][cx]++
+		}
+	}
+
+	// Images smaller than the grid leave cells empty; they take the value
+	// of the pixel they fall on.
+	for cy := 0; cy < side; cy++ {
+		for cx := 0; cx < side; cx++ {
+			if counts[cy][cx] > 0 {
+				sums[cy][cx] /= counts[cy][cx]
+				continue
+			}
+			r, g, bl, _ := img.At(b.Min.X+cx*w/side, b.Min.Y+cy*h/side).RGBA()
+			sums[cy][cx] = 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(bl)
+		}
+	}
+	return sums
+}
@@ -0,0 +1,104 @@
+// Package mail provides support for delivering email.
+package mail
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Message is a plain text email to a single recipient.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers an email.
+type Mailer interface {
+	Send(ctx context.Context, m Message) error
+}
+
+// SMTP is a Mailer which delivers messages through an SMTP server.
+type SMTP struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+// NewSMTP constructs an SMTP mailer for the server at addr in host:port form.
+// Credentials are optional; when given they are only sent over TLS.
+func NewSMTP(addr, username, password, from string) (*SMTP, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing smtp address")
+	}
+	if _, err := mail.ParseAddress(from); err != nil {
+		return nil, errors.Wrap(err, "parsing from address")
+	}
+
+	s := SMTP{
+		addr: addr,
+		from: from,
+	}
+	if username != "" {
+		s.auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return &s, nil
+}
+
+// Send implements the Mailer interface. The server is not told about ctx
+// but Send gives up waiting when it is done.
+func (s *SMTP) Send(ctx context.Context, m Message) error {
+	to, err := mail.ParseAddress(m.To)
+	if err != nil {
+		return errors.Wrap(err, "parsing recipient")
+	}
+	from, _ := mail.ParseAddress(s.from)
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(m.Body)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(s.addr, s.auth, from.Address, []string{to.Address}, msg.Bytes())
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return errors.Wrap(err, "sending mail")
+		}
+		return nil
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "sending mail")
+	}
+}
+
+// Logger is a Mailer which only logs messages. It is useful for development
+// where no real email should go out.
+type Logger struct {
+	Log *log.Logger
+}
+
+// Send implements the Mailer interface.
+func (l Logger) Send(ctx context.Context, m Message) error {
+	l.Log.Printf("mail : to %s : %s\n%s", m.To, m.Subject, m.Body)
+	return nil
+}
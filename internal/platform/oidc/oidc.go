@@ -0,0 +1,299 @@
+// Package oidc signs users in with an external OpenID Connect provider using
+// the authorization code flow.
+package oidc
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidToken is returned when the ID token of a provider can not be
+// trusted.
+var ErrInvalidToken = errors.New("id token is invalid")
+
+// Identity is who the provider says signed in.
+type Identity struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// Provider is an OpenID Connect provider the API is registered with as a
+// client.
+type Provider struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Client       *http.Client
+
+	authURL  string
+	tokenURL string
+	jwksURL  string
+
+	mu   sync.Mutex
+	keys map[string]*rsa.PublicKey
+}
+
+// Discover constructs a Provider from the discovery document of issuer.
+func Discover(ctx context.Context, issuer, clientID, clientSecret, redirectURL string) (*Provider, error) {
+	p := Provider{
+		Issuer:       strings.TrimSuffix(issuer, "/"),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		Client:       &http.Client{Timeout: 10 * time.Second},
+	}
+
+	var doc struct {
+		Issuer   string `json:"issuer"`
+		AuthURL  string `json:"authorization_endpoint"`
+		TokenURL string `json:"token_endpoint"`
+		JWKSURL  string `json:"jwks_uri"`
+	}
+	if err := p.get(ctx, p.Issuer+"/.well-known/openid-configuration", &doc); err != nil {
+		return nil, errors.Wrap(err, "discovering provider")
+	}
+	if strings.TrimSuffix(doc.Issuer, "/") != p.Issuer {
+		return nil, errors.Errorf("provider reports issuer %q", doc.Issuer)
+	}
+	if doc.AuthURL == "" || doc.TokenURL == "" || doc.JWKSURL == "" {
+		return nil, errors.New("discovery document is missing endpoints")
+	}
+	p.authURL = doc.AuthURL
+	p.tokenURL = doc.TokenURL
+	p.jwksURL = doc.JWKSURL
+
+	return &p, nil
+}
+
+// AuthURL is where the user is sent to sign in. state is returned to the
+// callback unchanged and nonce is embedded in the ID token.
+func (p *Provider) AuthURL(state, nonce string) string {
+	v := url.Values{
+		"response_type": {"code"},
+		"client_id":     {p.ClientID},
+		"redirect_uri":  {p.RedirectURL},
+		"scope":         {"openid email profile"},
+		"state":         {state},
+		"nonce":         {nonce},
+	}
+
+	sep := "?"
+	if strings.Contains(p.authURL, "?") {
+		sep = "&"
+	}
+	return p.authURL + sep + v.Encode()
+}
+
+// Exchange redeems the code the callback received for the identity of the
+// user. The ID token must carry nonce.
+func (p *Provider) Exchange(ctx context.Context, code, nonce string, now time.Time) (Identity, error) {
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {p.RedirectURL},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Identity{}, errors.Wrap(err, "creating token request")
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return Identity{}, errors.Wrap(err, "calling token endpoint")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Identity{}, errors.Errorf("token endpoint responded %d", resp.StatusCode)
+	}
+
+	var body struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return Identity{}, errors.Wrap(err, "decoding token response")
+	}
+
+	return p.verify(ctx, body.IDToken, nonce, now)
+}
+
+// idClaims are the claims of an ID token we rely on.
+type idClaims struct {
+	Issuer        string      `json:"iss"`
+	Subject       string      `json:"sub"`
+	Audience      audience    `json:"aud"`
+	ExpiresAt     int64       `json:"exp"`
+	Nonce         string      `json:"nonce"`
+	Email         string      `json:"email"`
+	EmailVerified interface{} `json:"email_verified"`
+	Name          string      `json:"name"`
+}
+
+// Valid implements jwt.Claims. The claims are checked by verify.
+func (idClaims) Valid() error {
+	return nil
+}
+
+// audience is the aud claim, which may be a single string or a list.
+type audience []string
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*a = audience{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+// has reports whether the audience includes id.
+func (a audience) has(id string) bool {
+	for _, v := range a {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+// verify checks the signature and claims of an ID token.
+func (p *Provider) verify(ctx context.Context, token, nonce string, now time.Time) (Identity, error) {
+	parser := jwt.Parser{
+		ValidMethods: []string{"RS256", "RS384", "RS512"},
+	}
+
+	var claims idClaims
+	keyFunc := func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.key(ctx, kid)
+	}
+	if _, err := parser.ParseWithClaims(token, &claims, keyFunc); err != nil {
+		return Identity{}, errors.Wrap(ErrInvalidToken, err.Error())
+	}
+
+	switch {
+	case strings.TrimSuffix(claims.Issuer, "/") != p.Issuer:
+		return Identity{}, errors.Wrap(ErrInvalidToken, "issuer does not match")
+	case !claims.Audience.has(p.ClientID):
+		return Identity{}, errors.Wrap(ErrInvalidToken, "audience does not match")
+	case claims.ExpiresAt <= now.Unix():
+		return Identity{}, errors.Wrap(ErrInvalidToken, "token has expired")
+	case claims.Nonce != nonce:
+		return Identity{}, errors.Wrap(ErrInvalidToken, "nonce does not match")
+	case claims.Subject == "":
+		return Identity{}, errors.Wrap(ErrInvalidToken, "token has no subject")
+	}
+
+	// Some providers send email_verified as a string.
+	verified := false
+	switch v := claims.EmailVerified.(type) {
+	case bool:
+		verified = v
+	case string:
+		verified = v == "true"
+	}
+
+	id := Identity{
+		Issuer:        p.Issuer,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: verified,
+		Name:          claims.Name,
+	}
+	return id, nil
+}
+
+// key gives the public key with kid, fetching the provider's keys again when
+// it is not known so rotated keys are picked up.
+func (p *Provider) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if k, ok := p.keys[kid]; ok {
+		return k, nil
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := p.get(ctx, p.jwksURL, &set); err != nil {
+		return nil, errors.Wrap(err, "fetching provider keys")
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	p.keys = keys
+
+	k, ok := keys[kid]
+	if !ok {
+		return nil, errors.Errorf("unknown key id %q", kid)
+	}
+	return k, nil
+}
+
+// get decodes the JSON document at url into v.
+func (p *Provider) get(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return errors.Wrap(err, "creating request")
+	}
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "calling provider")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("provider responded %d", resp.StatusCode)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return errors.Wrap(err, "decoding response")
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+// TestVerify checks ID tokens are only trusted when signed by the provider
+// for this client, unexpired and carrying the nonce of the sign in.
+func TestVerify(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := Provider{
+		Issuer:   "https://accounts.example.com",
+		ClientID: "garagesale",
+		keys:     map[string]*rsa.PublicKey{"k1": &key.PublicKey},
+	}
+	now := time.Now()
+
+	// token signs claims, which start from valid ones and are changed by
+	// edit, with signer.
+	token := func(signer *rsa.PrivateKey, method jwt.SigningMethod, edit func(jwt.MapClaims)) string {
+		claims := jwt.MapClaims{
+			"iss":            "https://accounts.example.com",
+			"sub":            "248289761001",
+			"aud":            "garagesale",
+			"exp":            now.Add(time.Hour).Unix(),
+			"nonce":          "n-0S6_WzA2Mj",
+			"email":          "jane@example.com",
+			"email_verified": true,
+			"name":           "Jane Doe",
+		}
+		if edit != nil {
+			edit(claims)
+		}
+		tok := jwt.NewWithClaims(method, claims)
+		tok.Header["kid"] = "k1"
+		var k interface{} = signer
+		if method == jwt.SigningMethodHS256 {
+			k = []byte("secret")
+		}
+		s, err := tok.SignedString(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	rs := jwt.SigningMethodRS256
+
+	want := Identity{
+		Issuer:        "https://accounts.example.com",
+		Subject:       "248289761001",
+		Email:         "jane@example.com",
+		EmailVerified: true,
+		Name:          "Jane Doe",
+	}
+
+	valid := []struct {
+		name  string
+		token string
+		want  Identity
+	}{
+		{"valid", token(key, rs, nil), want},
+		{"issuer with slash", token(key, rs, func(c jwt.MapClaims) { c["iss"] = "https://accounts.example.com/" }), want},
+		{"audience list", token(key, rs, func(c jwt.MapClaims) { c["aud"] = []string{"other", "garagesale"} }), want},
+		{"verified as string", token(key, rs, func(c jwt.MapClaims) { c["email_verified"] = "true" }), want},
+	}
+	for _, tt := range valid {
+		id, err := p.verify(context.Background(), tt.token, "n-0S6_WzA2Mj", now)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if id != tt.want {
+			t.Errorf("%s: identity %+v, want %+v", tt.name, id, tt.want)
+		}
+	}
+
+	invalid := []struct {
+		name  string
+		token string
+	}{
+		{"other issuer", token(key, rs, func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" })},
+		{"other audience", token(key, rs, func(c jwt.MapClaims) { c["aud"] = "other" })},
+		{"other audiences", token(key, rs, func(c jwt.MapClaims) { c["aud"] = []string{"other", "another"} })},
+		{"expired", token(key, rs, func(c jwt.MapClaims) { c["exp"] = now.Add(-time.Minute).Unix() })},
+		{"expiring now", token(key, rs, func(c jwt.MapClaims) { c["exp"] = now.Unix() })},
+		{"no expiry", token(key, rs, func(c jwt.MapClaims) { delete(c, "exp") })},
+		{"other nonce", token(key, rs, func(c jwt.MapClaims) { c["nonce"] = "replayed" })},
+		{"no nonce", token(key, rs, func(c jwt.MapClaims) { delete(c, "nonce") })},
+		{"no subject", token(key, rs, func(c jwt.MapClaims) { delete(c, "sub") })},
+		{"other key", token(otherKey, rs, nil)},
+		{"symmetric", token(nil, jwt.SigningMethodHS256, nil)},
+		{"garbage", "not.a.token"},
+	}
+	for _, tt := range invalid {
+		_, err := p.verify(context.Background(), tt.token, "n-0S6_WzA2Mj", now)
+		if errors.Cause(err) != ErrInvalidToken {
+			t.Errorf("%s: error %v, want %v", tt.name, err, ErrInvalidToken)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+// Package pdf provides a minimal PDF writer for simple printable documents
+// made of text and filled rectangles. It uses the standard Helvetica fonts so
+// no font data has to be embedded.
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Standard page sizes in points.
+const (
+	LetterWidth  = 612.0
+	LetterHeight = 792.0
+	A4Width      = 595.28
+	A4Height     = 841.89
+)
+
+// Font selects one of the standard fonts available to every PDF reader.
+type Font int
+
+// These are the fonts supported by a Document.
+const (
+	Regular Font = iota
+	Bold
+)
+
+// Document is a PDF document under construction. All coordinates are in
+// points measured from the top left corner of the page.
+type Document struct {
+	width  float64
+	height float64
+	pages  []*bytes.Buffer
+}
+
+// New constructs an empty Document whose pages have the provided size.
+func New(width, height float64) *Document {
+	return &Document{
+		width:  width,
+		height: height,
+	}
+}
+
+// AddPage starts a new page. Subsequent drawing happens on this page.
+func (d *Document) AddPage() {
+	d.pages = append(d.pages, new(bytes.Buffer))
+}
+
+// current returns the page being drawn, starting the first one if needed.
+func (d *Document) current() *bytes.Buffer {
+	if len(d.pages) == 0 {
+		d.AddPage()
+	}
+	return d.pages[len(d.pages)-1]
+}
+
+// Text draws s with its baseline starting at x, y.
+func (d *Document) Text(x, y float64, font Font, size float64, s string) {
+	fmt.Fprintf(d.current(), "BT /F%d %.2f Tf %.2f %.2f Td (%s) Tj ET\n", font+1, size, x, d.height-y, escape(s))
+}
+
+// Rect draws a filled black rectangle whose top left corner is at x, y.
+func (d *Document) Rect(x, y, w, h float64) {
+	fmt.Fprintf(d.current(), "%.3f %.3f %.3f %.3f re f\n", x, d.height-y-h, w, h)
+}
+
+// Line draws a thin black line from x1, y1 to x2, y2.
+func (d *Document) Line(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(d.current(), "0.5 w %.2f %.2f m %.2f %.2f l S\n", x1, d.height-y1, x2, d.height-y2)
+}
+
+// TextWidth estimates the width of s when drawn with the provided size. It
+// uses the average glyph width of Helvetica which is good enough to lay out
+// and truncate short labels.
+func TextWidth(size float64, s string) float64 {
+	return float64(len([]rune(s))) * size * 0.5
+}
+
+// WriteTo serializes the document and writes it to w.
+func (d *Document) WriteTo(w io.Writer) (int64, error) {
+	d.current()
+
+	var buf bytes.Buffer
+	var offsets []int
+
+	obj := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// Objects 1-4 are fixed. Each page then takes two objects, the page
+	// itself followed by its content stream.
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+i*2)
+	}
+	obj("<< /Type /Catalog /Pages 2 0 R >>")
+	obj(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+
+	for i, p := range d.pages {
+		obj(fmt.Sprintf(
+			"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			d.width, d.height, 6+i*2,
+		))
+		obj(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", p.Len(), p.String()))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	n, err := w.Write(buf.Bytes())
+	return int64(n), err
+}
+
+// escape converts s to a PDF literal string. Characters outside of Latin-1
+// can not be represented by the standard fonts and are replaced.
+func escape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 32:
+			b.WriteByte(' ')
+		case r < 256:
+			b.WriteByte(byte(r))
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,156 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+// Hosts of the Apple Push Notification service.
+const (
+	apnsProduction = "https://api.push.apple.com"
+	apnsSandbox    = "https://api.sandbox.push.apple.com"
+)
+
+// apnsTokenTTL is how long a provider token is reused. Apple rejects tokens
+// older than an hour and throttles tokens refreshed more than every 20 minutes.
+const apnsTokenTTL = 50 * time.Minute
+
+// APNs is a Sender which delivers notifications through the Apple Push
+// Notification service using token based authentication.
+type APNs struct {
+	keyID  string
+	teamID string
+	topic  string
+	host   string
+	key    *ecdsa.PrivateKey
+	client *http.Client
+
+	mu     sync.Mutex
+	token  string
+	issued time.Time
+}
+
+// NewAPNs constructs an APNs sender from the contents of a .p8 signing key.
+// The topic is the bundle ID of the app. Set sandbox to deliver to
+// development builds.
+func NewAPNs(keyPEM []byte, keyID, teamID, topic string, sandbox bool) (*APNs, error) {
+	if keyID == "" || teamID == "" || topic == "" {
+		return nil, errors.New("apns key id, team id and topic are required")
+	}
+
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("apns key must be PEM encoded")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing apns key")
+	}
+	key, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("apns key must be an ECDSA key")
+	}
+
+	a := APNs{
+		keyID:  keyID,
+		teamID: teamID,
+		topic:  topic,
+		host:   apnsProduction,
+		key:    key,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+	if sandbox {
+		a.host = apnsSandbox
+	}
+
+	return &a, nil
+}
+
+// Send implements the Sender interface.
+func (a *APNs) Send(ctx context.Context, token string, n Notification) error {
+	bearer, err := a.providerToken()
+	if err != nil {
+		return err
+	}
+
+	var payload struct {
+		APS struct {
+			Alert struct {
+				Title string `json:"title"`
+				Body  string `json:"body"`
+			} `json:"alert"`
+		} `json:"aps"`
+	}
+	payload.APS.Alert.Title = n.Title
+	payload.APS.Alert.Body = n.Body
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return errors.Wrap(err, "encoding apns payload")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.host+"/3/device/"+token, bytes.NewReader(data))
+	if err != nil {
+		return errors.Wrap(err, "creating apns request")
+	}
+	req.Header.Set("Authorization", "bearer "+bearer)
+	req.Header.Set("apns-topic", a.topic)
+	req.Header.Set("apns-push-type", "alert")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "calling apns")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var e struct {
+			Reason string `json:"reason"`
+		}
+		json.NewDecoder(resp.Body).Decode(&e)
+		if resp.StatusCode == http.StatusGone || e.Reason == "BadDeviceToken" || e.Reason == "Unregistered" {
+			return ErrUnregistered
+		}
+		return errors.Errorf("apns responded %d: %s", resp.StatusCode, e.Reason)
+	}
+
+	return nil
+}
+
+// providerToken returns the signed JWT identifying us to APNs, generating a
+// new one when the cached token is about to expire.
+func (a *APNs) providerToken() (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	if a.token != "" && now.Sub(a.issued) < apnsTokenTTL {
+		return a.token, nil
+	}
+
+	tkn := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"iss": a.teamID,
+		"iat": now.Unix(),
+	})
+	tkn.Header["kid"] = a.keyID
+
+	str, err := tkn.SignedString(a.key)
+	if err != nil {
+		return "", errors.Wrap(err, "signing apns token")
+	}
+
+	a.token = str
+	a.issued = now
+
+	return a.token, nil
+}
@@ -0,0 +1,178 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+// fcmScope is the OAuth2 scope required to send messages.
+const fcmScope = "https://www.googleapis.com/auth/firebase.messaging"
+
+// FCM is a Sender which delivers notifications through the Firebase Cloud
+// Messaging HTTP v1 API using a Google service account.
+type FCM struct {
+	projectID   string
+	clientEmail string
+	tokenURI    string
+	key         interface{}
+	client      *http.Client
+
+	mu          sync.Mutex
+	accessToken string
+	expires     time.Time
+}
+
+// NewFCM constructs an FCM sender from the JSON credentials file of a service
+// account allowed to send messages for the project.
+func NewFCM(projectID string, credentials []byte) (*FCM, error) {
+	if projectID == "" {
+		return nil, errors.New("fcm project id is required")
+	}
+
+	var sa struct {
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+		TokenURI    string `json:"token_uri"`
+	}
+	if err := json.Unmarshal(credentials, &sa); err != nil {
+		return nil, errors.Wrap(err, "decoding fcm credentials")
+	}
+
+	key, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(sa.PrivateKey))
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing fcm private key")
+	}
+
+	f := FCM{
+		projectID:   projectID,
+		clientEmail: sa.ClientEmail,
+		tokenURI:    sa.TokenURI,
+		key:         key,
+		client:      &http.Client{Timeout: 10 * time.Second},
+	}
+
+	return &f, nil
+}
+
+// Send implements the Sender interface.
+func (f *FCM) Send(ctx context.Context, token string, n Notification) error {
+	access, err := f.token(ctx)
+	if err != nil {
+		return err
+	}
+
+	var msg struct {
+		Message struct {
+			Token        string `json:"token"`
+			Notification struct {
+				Title string `json:"title"`
+				Body  string `json:"body"`
+			} `json:"notification"`
+		} `json:"message"`
+	}
+	msg.Message.Token = token
+	msg.Message.Notification.Title = n.Title
+	msg.Message.Notification.Body = n.Body
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return errors.Wrap(err, "encoding fcm message")
+	}
+
+	u := "https://fcm.googleapis.com/v1/projects/" + f.projectID + "/messages:send"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(data))
+	if err != nil {
+		return errors.Wrap(err, "creating fcm request")
+	}
+	req.Header.Set("Authorization", "Bearer "+access)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "calling fcm")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var e struct {
+			Error struct {
+				Status  string `json:"status"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&e)
+		if resp.StatusCode == http.StatusNotFound || e.Error.Status == "UNREGISTERED" {
+			return ErrUnregistered
+		}
+		return errors.Errorf("fcm responded %d: %s", resp.StatusCode, e.Error.Message)
+	}
+
+	return nil
+}
+
+// token returns an OAuth2 access token for the service account. Tokens are
+// cached until shortly before they expire.
+func (f *FCM) token(ctx context.Context) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	if f.accessToken != "" && now.Before(f.expires) {
+		return f.accessToken, nil
+	}
+
+	claims := jwt.MapClaims{
+		"iss":   f.clientEmail,
+		"scope": fcmScope,
+		"aud":   f.tokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}
+	assertion, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(f.key)
+	if err != nil {
+		return "", errors.Wrap(err, "signing fcm assertion")
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "urn:ietf:params:oauth:grant-type:jwt-bearer")
+	form.Set("assertion", assertion)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.tokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", errors.Wrap(err, "creating token request")
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "requesting fcm access token")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return "", errors.Errorf("token endpoint responded %d", resp.StatusCode)
+	}
+
+	var tkn struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tkn); err != nil {
+		return "", errors.Wrap(err, "decoding fcm access token")
+	}
+
+	// Refresh a minute early so a token never expires in flight.
+	f.accessToken = tkn.AccessToken
+	f.expires = now.Add(time.Duration(tkn.ExpiresIn)*time.Second - time.Minute)
+
+	return f.accessToken, nil
+}
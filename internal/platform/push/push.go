@@ -0,0 +1,38 @@
+// Package push provides support for delivering push notifications to mobile
+// devices through Firebase Cloud Messaging and the Apple Push Notification
+// service.
+package push
+
+import (
+	"context"
+	"log"
+
+	"github.com/pkg/errors"
+)
+
+// ErrUnregistered is returned when the provider reports that a device token
+// is no longer valid. The token should be forgotten.
+var ErrUnregistered = errors.New("device token is no longer registered")
+
+// Notification is the content of a push notification.
+type Notification struct {
+	Title string
+	Body  string
+}
+
+// Sender delivers a notification to a single device token.
+type Sender interface {
+	Send(ctx context.Context, token string, n Notification) error
+}
+
+// Logger is a Sender which only logs notifications. It is useful for
+// development where no real notifications should go out.
+type Logger struct {
+	Log *log.Logger
+}
+
+// Send implements the Sender interface.
+func (l Logger) Send(ctx context.Context, token string, n Notification) error {
+	l.Log.Printf("push : to %s : %s : %s", token, n.Title, n.Body)
+	return nil
+}
@@ -0,0 +1,242 @@
+// Package redis is a small client for the few Redis commands the API needs.
+// It speaks the RESP protocol over pooled TCP connections.
+package redis
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Config is how to reach a Redis server. Zero Timeout and PoolSize fall back
+// to a second and ten idle connections.
+type Config struct {
+	Addr     string
+	Password string
+	DB       int
+	Timeout  time.Duration
+	PoolSize int
+}
+
+// Client sends commands to a Redis server. It is safe for concurrent use.
+type Client struct {
+	cfg  Config
+	idle chan *conn
+}
+
+// conn is a connection along with the reader of its replies.
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// Error is an error reply of the server.
+type Error string
+
+func (e Error) Error() string {
+	return "redis: " + string(e)
+}
+
+// New constructs a Client for the server in cfg. Connections are made when
+// commands are sent.
+func New(cfg Config) *Client {
+	if cfg.Timeout == 0 {
+		cfg.Timeout = time.Second
+	}
+	if cfg.PoolSize == 0 {
+		cfg.PoolSize = 10
+	}
+	return &Client{cfg: cfg, idle: make(chan *conn, cfg.PoolSize)}
+}
+
+// Do sends a command and returns its reply: a string, an int64, nil or a
+// []interface{} of those.
+func (c *Client) Do(ctx context.Context, args ...string) (interface{}, error) {
+	cn, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := cn.do(ctx, c.cfg.Timeout, args...)
+	if err != nil {
+		// An error reply leaves the connection usable; anything else may
+		// leave part of a reply behind.
+		if _, ok := err.(Error); !ok {
+			cn.Close()
+			return nil, err
+		}
+	}
+	c.put(cn)
+	return reply, err
+}
+
+// Ping checks the server answers.
+func (c *Client) Ping(ctx context.Context) error {
+	_, err := c.Do(ctx, "PING")
+	return err
+}
+
+// Get returns the value of key and whether there is one.
+func (c *Client) Get(ctx context.Context, key string) (string, bool, error) {
+	reply, err := c.Do(ctx, "GET", key)
+	if err != nil || reply == nil {
+		return "", false, err
+	}
+	s, ok := reply.(string)
+	if !ok {
+		return "", false, errors.Errorf("redis: unexpected reply %T to GET", reply)
+	}
+	return s, true, nil
+}
+
+// Set stores value under key until ttl passes. Zero ttl keeps it forever.
+func (c *Client) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	args := []string{"SET", key, value}
+	if ttl > 0 {
+		ms := ttl.Milliseconds()
+		if ms == 0 {
+			ms = 1
+		}
+		args = append(args, "PX", strconv.FormatInt(ms, 10))
+	}
+	_, err := c.Do(ctx, args...)
+	return err
+}
+
+// Close closes the idle connections.
+func (c *Client) Close() error {
+	for {
+		select {
+		case cn := <-c.idle:
+			cn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// get takes an idle connection or dials a new one.
+func (c *Client) get(ctx context.Context) (*conn, error) {
+	select {
+	case cn := <-c.idle:
+		return cn, nil
+	default:
+	}
+
+	d := net.Dialer{Timeout: c.cfg.Timeout}
+	nc, err := d.DialContext(ctx, "tcp", c.cfg.Addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "connecting to redis")
+	}
+	cn := &conn{Conn: nc, r: bufio.NewReader(nc)}
+
+	if c.cfg.Password != "" {
+		if _, err := cn.do(ctx, c.cfg.Timeout, "AUTH", c.cfg.Password); err != nil {
+			cn.Close()
+			return nil, errors.Wrap(err, "authenticating to redis")
+		}
+	}
+	if c.cfg.DB != 0 {
+		if _, err := cn.do(ctx, c.cfg.Timeout, "SELECT", strconv.Itoa(c.cfg.DB)); err != nil {
+			cn.Close()
+			return nil, errors.Wrap(err, "selecting redis database")
+		}
+	}
+	return cn, nil
+}
+
+// put returns a connection to the pool or closes it when the pool is full.
+func (c *Client) put(cn *conn) {
+	select {
+	case c.idle <- cn:
+	default:
+		cn.Close()
+	}
+}
+
+// do writes a command and reads its reply within timeout, or sooner when the
+// context has an earlier deadline.
+func (cn *conn) do(ctx context.Context, timeout time.Duration, args ...string) (interface{}, error) {
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	cn.SetDeadline(deadline)
+
+	buf := make([]byte, 0, 64)
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, '\r', '\n')
+	for _, arg := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, arg...)
+		buf = append(buf, '\r', '\n')
+	}
+	if _, err := cn.Write(buf); err != nil {
+		return nil, errors.Wrap(err, "writing redis command")
+	}
+
+	return readReply(cn.r)
+}
+
+// readReply reads a RESP reply.
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, errors.Wrap(err, "reading redis reply")
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.Errorf("redis: malformed reply %q", line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return body, nil
+
+	case '-':
+		return nil, Error(body)
+
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, errors.Errorf("redis: malformed bulk length %q", body)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, errors.Wrap(err, "reading redis reply")
+		}
+		return string(data[:n]), nil
+
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, errors.Errorf("redis: malformed array length %q", body)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		list := make([]interface{}, n)
+		for i := range list {
+			if list[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	}
+
+	return nil, errors.Errorf("redis: unknown reply type %q", kind)
+}
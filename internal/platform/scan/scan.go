@@ -0,0 +1,145 @@
+// Package scan checks files for viruses and other malware with an antivirus
+// engine such as ClamAV or a hosted scanning API.
+package scan
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Result is the verdict on a file. Threat names what was found in a file
+// which is not clean.
+type Result struct {
+	Clean  bool
+	Threat string
+}
+
+// Scanner checks the contents of a file for malware.
+type Scanner interface {
+	Scan(ctx context.Context, data []byte) (Result, error)
+}
+
+// ClamAV is a Scanner backed by a clamd daemon listening on Addr, such as
+// localhost:3310.
+type ClamAV struct {
+	Addr    string
+	Timeout time.Duration
+}
+
+// clamChunk is the size of the chunks files are streamed to clamd in.
+const clamChunk = 64 << 10
+
+// Scan implements the Scanner interface with the INSTREAM command of clamd.
+func (c ClamAV) Scan(ctx context.Context, data []byte) (Result, error) {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", c.Addr)
+	if err != nil {
+		return Result{}, errors.Wrap(err, "connecting to clamd")
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	w := bufio.NewWriter(conn)
+	w.WriteString("zINSTREAM\x00")
+	for len(data) > 0 {
+		n := len(data)
+		if n > clamChunk {
+			n = clamChunk
+		}
+		var size [4]byte
+		binary.BigEndian.PutUint32(size[:], uint32(n))
+		w.Write(size[:])
+		w.Write(data[:n])
+		data = data[n:]
+	}
+	w.Write([]byte{0, 0, 0, 0})
+	if err := w.Flush(); err != nil {
+		return Result{}, errors.Wrap(err, "streaming to clamd")
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil {
+		return Result{}, errors.Wrap(err, "reading clamd reply")
+	}
+	return parseClamReply(strings.TrimRight(reply, "\x00"))
+}
+
+// parseClamReply interprets replies such as "stream: OK" and
+// "stream: Eicar-Signature FOUND".
+func parseClamReply(reply string) (Result, error) {
+	reply = strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case reply == "OK":
+		return Result{Clean: true}, nil
+	case strings.HasSuffix(reply, " FOUND"):
+		return Result{Threat: strings.TrimSuffix(reply, " FOUND")}, nil
+	default:
+		return Result{}, errors.Errorf("clamd replied %q", reply)
+	}
+}
+
+// API is a Scanner backed by an HTTP scanning service. It posts the file as
+// the request body and expects a response of the form
+//
+//	{"clean": false, "threat": "Eicar-Test-Signature"}
+type API struct {
+	URL    string
+	Key    string
+	Client *http.Client
+}
+
+// NewAPI constructs an API scanner calling url and authenticating with key as
+// a bearer token when it is not empty.
+func NewAPI(url, key string) *API {
+	return &API{
+		URL:    url,
+		Key:    key,
+		Client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Scan implements the Scanner interface.
+func (a *API) Scan(ctx context.Context, data []byte) (Result, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.URL, bytes.NewReader(data))
+	if err != nil {
+		return Result{}, errors.Wrap(err, "creating scan request")
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if a.Key != "" {
+		req.Header.Set("Authorization", "Bearer "+a.Key)
+	}
+
+	resp, err := a.Client.Do(req)
+	if err != nil {
+		return Result{}, errors.Wrap(err, "calling scan api")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Result{}, errors.Errorf("scan api responded %d", resp.StatusCode)
+	}
+
+	var res struct {
+		Clean  bool   `json:"clean"`
+		Threat string `json:"threat"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return Result{}, errors.Wrap(err, "decoding scan response")
+	}
+
+	return Result{Clean: res.Clean, Threat: res.Threat}, nil
+}
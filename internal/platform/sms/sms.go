@@ -0,0 +1,95 @@
+// Package sms provides support for delivering text messages to phones.
+package sms
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Sender delivers a text message to a phone number in E.164 format.
+type Sender interface {
+	Send(ctx context.Context, to, body string) error
+}
+
+// twilioURL is the base of the Twilio REST API.
+const twilioURL = "https://api.twilio.com/2010-04-01/Accounts/"
+
+// Twilio is a Sender which delivers messages through the Twilio REST API.
+type Twilio struct {
+	accountSID string
+	authToken  string
+	from       string
+	client     *http.Client
+}
+
+// NewTwilio constructs a Twilio sender. The from number must be a number
+// owned by the Twilio account.
+func NewTwilio(accountSID, authToken, from string) (*Twilio, error) {
+	if accountSID == "" || authToken == "" {
+		return nil, errors.New("twilio account sid and auth token are required")
+	}
+	if from == "" {
+		return nil, errors.New("twilio from number is required")
+	}
+
+	t := Twilio{
+		accountSID: accountSID,
+		authToken:  authToken,
+		from:       from,
+		client:     &http.Client{Timeout: 10 * time.Second},
+	}
+
+	return &t, nil
+}
+
+// Send implements the Sender interface.
+func (t *Twilio) Send(ctx context.Context, to, body string) error {
+	form := url.Values{}
+	form.Set("To", to)
+	form.Set("From", t.from)
+	form.Set("Body", body)
+
+	u := twilioURL + t.accountSID + "/Messages.json"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, strings.NewReader(form.Encode()))
+	if err != nil {
+		return errors.Wrap(err, "creating twilio request")
+	}
+	req.SetBasicAuth(t.accountSID, t.authToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "calling twilio")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var e struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&e)
+		return errors.Errorf("twilio responded %d: %s (code %d)", resp.StatusCode, e.Message, e.Code)
+	}
+
+	return nil
+}
+
+// Logger is a Sender which only logs messages. It is useful for development
+// where no real messages should go out.
+type Logger struct {
+	Log *log.Logger
+}
+
+// Send implements the Sender interface.
+func (l Logger) Send(ctx context.Context, to, body string) error {
+	l.Log.Printf("sms : to %s : %s", to, body)
+	return nil
+}
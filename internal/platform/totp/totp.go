@@ -0,0 +1,98 @@
+// Package totp implements the time-based one-time passwords of RFC 6238 as
+// used by authenticator apps: six digit codes from HMAC-SHA1 over 30 second
+// steps.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Period is how long each code is valid.
+const Period = 30 * time.Second
+
+// digits is the length of a code.
+const digits = 6
+
+// encoding is how secrets are shown to users and stored.
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewSecret generates a random secret, base32 encoded.
+func NewSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "generating secret")
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+// URL gives the otpauth URL authenticator apps enroll secret with, usually
+// shown as a QR code. issuer names the service and account the user.
+func URL(issuer, account, secret string) string {
+	v := url.Values{
+		"secret": {secret},
+		"issuer": {issuer},
+	}
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: v.Encode(),
+	}
+	return u.String()
+}
+
+// Step gives the number of the step t falls in.
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period/time.Second)
+}
+
+// Code gives the code of secret for a step.
+func Code(secret string, step int64) (string, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", errors.Wrap(err, "decoding secret")
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	off := sum[len(sum)-1] & 0x0f
+	n := binary.BigEndian.Uint32(sum[off:off+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", digits, n%1000000), nil
+}
+
+// Validate checks code against secret at now, allowing for the clock of the
+// user's device being off by up to skew steps either way. It returns the step
+// the code matched so callers can refuse a code being used twice.
+func Validate(secret, code string, now time.Time, skew int) (int64, bool) {
+	if len(code) != digits {
+		return 0, false
+	}
+
+	step := Step(now)
+	for i := -skew; i <= skew; i++ {
+		want, err := Code(secret, step+int64(i))
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(want), []byte(code)) == 1 {
+			return step + int64(i), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,74 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// secret is the SHA-1 seed of the RFC 6238 test vectors, "12345678901234567890",
+// base32 encoded.
+const secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// vectors are the SHA-1 test vectors of RFC 6238 appendix B cut to six digits.
+var vectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+// TestCode checks codes against the RFC 6238 test vectors.
+func TestCode(t *testing.T) {
+	for _, v := range vectors {
+		got, err := Code(secret, Step(time.Unix(v.unix, 0)))
+		if err != nil {
+			t.Fatalf("at %d: %v", v.unix, err)
+		}
+		if got != v.code {
+			t.Errorf("at %d: code %s, want %s", v.unix, got, v.code)
+		}
+	}
+}
+
+// TestValidate checks codes are accepted within the skew, in lower case
+// secrets too, and refused outside it.
+func TestValidate(t *testing.T) {
+	for _, v := range vectors {
+		now := time.Unix(v.unix, 0)
+		step := Step(now)
+
+		if got, ok := Validate(secret, v.code, now, 0); !ok || got != step {
+			t.Errorf("at %d: step %d and %v, want %d and true", v.unix, got, ok, step)
+		}
+		if got, ok := Validate(secret, v.code, now.Add(Period), 1); !ok || got != step {
+			t.Errorf("a step later than %d: step %d and %v, want %d and true", v.unix, got, ok, step)
+		}
+		if _, ok := Validate(secret, v.code, now.Add(2*Period), 1); ok {
+			t.Errorf("two steps later than %d: accepted", v.unix)
+		}
+	}
+
+	now := time.Unix(59, 0)
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		ok     bool
+	}{
+		{"lower case secret", "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", "287082", true},
+		{"wrong code", secret, "287083", false},
+		{"short code", secret, "28708", false},
+		{"eight digits", secret, "94287082", false},
+		{"bad secret", "not base32!", "287082", false},
+	}
+	for _, tt := range tests {
+		if _, ok := Validate(tt.secret, tt.code, now, 1); ok != tt.ok {
+			t.Errorf("%s: ok %v, want %v", tt.name, ok, tt.ok)
+		}
+	}
+}
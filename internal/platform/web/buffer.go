@@ -0,0 +1,82 @@
+package web
+
+import (
+	"io"
+	"sync"
+)
+
+const (
+	// spillSize is how much of a response is held before it starts going
+	// out to the client.
+	spillSize = 64 << 10
+
+	// maxPooledSize is the capacity above which buffers are dropped instead
+	// of pooled so one large response does not pin its memory.
+	maxPooledSize = 256 << 10
+)
+
+// buffer collects a JSON response. When out is set, what was collected is
+// written to it each time it grows past spillSize so large responses are not
+// held in memory whole. The first error writing to out is kept in err.
+type buffer struct {
+	b   []byte
+	out io.Writer
+	err error
+}
+
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return &buffer{b: make([]byte, 0, 4<<10)}
+	},
+}
+
+// getBuffer takes an empty buffer from the pool.
+func getBuffer() *buffer {
+	return bufferPool.Get().(*buffer)
+}
+
+// putBuffer returns buf to the pool.
+func putBuffer(buf *buffer) {
+	if cap(buf.b) > maxPooledSize {
+		return
+	}
+	buf.b = buf.b[:0]
+	buf.out = nil
+	buf.err = nil
+	bufferPool.Put(buf)
+}
+
+// Write implements the io.Writer interface.
+func (buf *buffer) Write(p []byte) (int, error) {
+	buf.b = append(buf.b, p...)
+	buf.spill()
+	return len(p), nil
+}
+
+// WriteString appends s.
+func (buf *buffer) WriteString(s string) {
+	buf.b = append(buf.b, s...)
+	buf.spill()
+}
+
+// WriteByte appends c. It never fails.
+func (buf *buffer) WriteByte(c byte) error {
+	buf.b = append(buf.b, c)
+	return nil
+}
+
+// spill writes what was collected to out once there is enough of it.
+func (buf *buffer) spill() {
+	if buf.out == nil || len(buf.b) < spillSize {
+		return
+	}
+	buf.flush()
+}
+
+// flush writes what was collected to out.
+func (buf *buffer) flush() {
+	if buf.err == nil && len(buf.b) > 0 {
+		_, buf.err = buf.out.Write(buf.b)
+	}
+	buf.b = buf.b[:0]
+}
@@ -0,0 +1,244 @@
+package web
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Compress is middleware compressing responses with gzip or deflate for
+// clients accepting either in Accept-Encoding. Responses are held back until
+// they reach minSize bytes so tiny ones, where compressing costs more than it
+// saves, go out as they are. Responses already encoded and media which is
+// compressed by itself, such as images, are never compressed.
+func Compress(minSize int) Middleware {
+	f := func(after Handler) Handler {
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+			if encoding == "" || r.Method == http.MethodHead {
+				return after(ctx, w, r)
+			}
+
+			cw := compressWriter{ResponseWriter: w, encoding: encoding, minSize: minSize}
+			err := after(ctx, &cw, r)
+			if cerr := cw.close(); cerr != nil && err == nil {
+				err = cerr
+			}
+			return err
+		}
+		return h
+	}
+	return f
+}
+
+// negotiateEncoding picks gzip or deflate from the Accept-Encoding header,
+// preferring gzip. It returns an empty string when neither is accepted.
+func negotiateEncoding(header string) string {
+	var gzipQ, deflateQ float64
+	for _, part := range strings.Split(header, ",") {
+		name, q := part, 1.0
+		if i := strings.Index(part, ";"); i >= 0 {
+			name = part[:i]
+			param := strings.TrimSpace(part[i+1:])
+			if strings.HasPrefix(param, "q=") {
+				v, err := strconv.ParseFloat(param[2:], 64)
+				if err != nil {
+					continue
+				}
+				q = v
+			}
+		}
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "gzip", "*":
+			if q > gzipQ {
+				gzipQ = q
+			}
+		case "deflate":
+			deflateQ = q
+		}
+	}
+
+	switch {
+	case gzipQ > 0 && gzipQ >= deflateQ:
+		return "gzip"
+	case deflateQ > 0:
+		return "deflate"
+	}
+	return ""
+}
+
+// incompressible lists the prefixes of content types which are compressed
+// already.
+var incompressible = []string{"image/", "video/", "audio/", "application/zip", "application/gzip", "application/pdf"}
+
+// Pools of compressors reused across responses.
+var (
+	gzipPool = sync.Pool{New: func() interface{} { return gzip.NewWriter(nil) }}
+	zlibPool = sync.Pool{New: func() interface{} { return zlib.NewWriter(nil) }}
+)
+
+// resetWriteCloser is implemented by both gzip and zlib writers.
+type resetWriteCloser interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+// compressWriter buffers the start of a response until it is large enough to
+// be worth compressing and then compresses the rest as it is written.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int
+
+	status  int
+	buf     []byte
+	decided bool
+	cz      resetWriteCloser
+}
+
+// WriteHeader implements the http.ResponseWriter interface. The status is
+// held back until it is known whether the response is compressed.
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.decided || cw.status != 0 {
+		return
+	}
+	cw.status = status
+}
+
+// Write implements the io.Writer interface.
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.decided {
+		if len(cw.buf)+len(p) < cw.minSize {
+			cw.buf = append(cw.buf, p...)
+			return len(p), nil
+		}
+		if err := cw.decide(true); err != nil {
+			return 0, err
+		}
+	}
+
+	if cw.cz != nil {
+		return cw.cz.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// Flush implements the http.Flusher interface. Flushing means the response
+// is streamed so it is compressed whatever its size so far.
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		if err := cw.decide(true); err != nil {
+			return
+		}
+	}
+	if cw.cz != nil {
+		cw.cz.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements the http.Hijacker interface for protocols taking over
+// the connection.
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := cw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer can not be hijacked")
+	}
+	return h.Hijack()
+}
+
+// decide sends the headers, compressing the response when compress is set
+// and its content allows, and writes out what was buffered.
+func (cw *compressWriter) decide(compress bool) error {
+	cw.decided = true
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+
+	hdr := cw.Header()
+	if compress && cw.compressible() {
+		hdr.Del("Content-Length")
+		hdr.Set("Content-Encoding", cw.encoding)
+		hdr.Add("Vary", "Accept-Encoding")
+
+		switch cw.encoding {
+		case "gzip":
+			cw.cz = gzipPool.Get().(*gzip.Writer)
+		default:
+			// The deflate content coding is a zlib stream (RFC 9110), not
+			// raw deflate data.
+			cw.cz = zlibPool.Get().(*zlib.Writer)
+		}
+		cw.cz.Reset(cw.ResponseWriter)
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	buf := cw.buf
+	cw.buf = nil
+	var err error
+	if cw.cz != nil {
+		_, err = cw.cz.Write(buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// compressible reports whether the response may be compressed judging by its
+// status and headers.
+func (cw *compressWriter) compressible() bool {
+	if cw.status < http.StatusOK || cw.status == http.StatusNoContent || cw.status == http.StatusNotModified {
+		return false
+	}
+	hdr := cw.Header()
+	if hdr.Get("Content-Encoding") != "" {
+		return false
+	}
+	ct := hdr.Get("Content-Type")
+	for _, prefix := range incompressible {
+		if strings.HasPrefix(ct, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// close finishes the response. Responses which stayed smaller than minSize go
+// out uncompressed.
+func (cw *compressWriter) close() error {
+	if !cw.decided {
+		if cw.status == 0 && len(cw.buf) == 0 {
+			return nil
+		}
+		return cw.decide(false)
+	}
+	if cw.cz == nil {
+		return nil
+	}
+
+	err := cw.cz.Close()
+	cw.cz.Reset(nil)
+	switch z := cw.cz.(type) {
+	case *gzip.Writer:
+		gzipPool.Put(z)
+	case *zlib.Writer:
+		zlibPool.Put(z)
+	}
+	cw.cz = nil
+	return errors.Wrap(err, "finishing compressed response")
+}
@@ -0,0 +1,74 @@
+package web
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestCompress checks large responses are compressed for clients accepting
+// gzip while small ones and those for other clients are sent as they are.
+func TestCompress(t *testing.T) {
+	app := NewApp(make(chan os.Signal, 1), log.New(ioutil.Discard, "", 0), Compress(100))
+
+	large := strings.Repeat("garage sale ", 50)
+	respond := func(body string) Handler {
+		return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(http.StatusOK)
+			_, err := w.Write([]byte(body))
+			return err
+		}
+	}
+	app.Handle(http.MethodGet, "/large", respond(large))
+	app.Handle(http.MethodGet, "/small", respond("tiny"))
+
+	tests := []struct {
+		path, accept, encoding, body string
+	}{
+		{"/large", "gzip, deflate", "gzip", large},
+		{"/large", "deflate, gzip;q=0", "deflate", large},
+		{"/large", "identity", "", large},
+		{"/large", "", "", large},
+		{"/small", "gzip", "", "tiny"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		r.Header.Set("Accept-Encoding", tt.accept)
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+
+		if got := w.Header().Get("Content-Encoding"); got != tt.encoding {
+			t.Errorf("%s with %q: encoding %q, want %q", tt.path, tt.accept, got, tt.encoding)
+			continue
+		}
+
+		var body []byte
+		switch tt.encoding {
+		case "gzip":
+			zr, err := gzip.NewReader(w.Body)
+			if err != nil {
+				t.Fatalf("%s with %q: %v", tt.path, tt.accept, err)
+			}
+			body, _ = ioutil.ReadAll(zr)
+		case "deflate":
+			zr, err := zlib.NewReader(w.Body)
+			if err != nil {
+				t.Fatalf("%s with %q: %v", tt.path, tt.accept, err)
+			}
+			body, _ = ioutil.ReadAll(zr)
+		default:
+			body = w.Body.Bytes()
+		}
+		if string(body) != tt.body {
+			t.Errorf("%s with %q: body %q, want %q", tt.path, tt.accept, body, tt.body)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package web
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidCursor is returned for cursors which were not made by Cursors
+// with the same key and listing, including any which were tampered with.
+var ErrInvalidCursor = errors.New("cursor is not valid")
+
+// Cursors makes and reads the cursors of keyset paginated listings. A cursor
+// holds the sort key values of the last row of a page, such as its creation
+// date and ID, signed with an HMAC so clients can not alter them. They are
+// tamper-evident but not encrypted, so clients can read them and they may only
+// hold what the listing shows anyway. Cursors are bound to their listing so
+// one made for products is refused when listing users.
+type Cursors struct {
+	key []byte
+}
+
+// NewCursors constructs Cursors signing with key. Cursors made with one key
+// are refused by Cursors with another, so rotating it invalidates those
+// clients hold.
+func NewCursors(key []byte) *Cursors {
+	return &Cursors{key: key}
+}
+
+// Encode makes a cursor for listing holding values, which must be encodable
+// as JSON.
+func (c *Cursors) Encode(listing string, values ...interface{}) (string, error) {
+	payload, err := json.Marshal(values)
+	if err != nil {
+		return "", errors.Wrap(err, "encoding cursor")
+	}
+	b := append(payload, c.sign(listing, payload)...)
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Decode reads the values of a cursor made for listing into dst, which are
+// pointers in the order the values were given to Encode. Cursors which are
+// not valid fail with a request error wrapping ErrInvalidCursor.
+func (c *Cursors) Decode(listing, cursor string, dst ...interface{}) error {
+	invalid := NewRequestError(ErrInvalidCursor, http.StatusBadRequest)
+
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || len(b) < sha256.Size {
+		return invalid
+	}
+	payload, mac := b[:len(b)-sha256.Size], b[len(b)-sha256.Size:]
+	if !hmac.Equal(mac, c.sign(listing, payload)) {
+		return invalid
+	}
+
+	var values []json.RawMessage
+	if err := json.Unmarshal(payload, &values); err != nil || len(values) != len(dst) {
+		return invalid
+	}
+	for i, v := range values {
+		if err := json.Unmarshal(v, dst[i]); err != nil {
+			return invalid
+		}
+	}
+	return nil
+}
+
+// sign computes the HMAC of payload for listing. The listing is followed by
+// a zero byte so it can not run into the payload.
+func (c *Cursors) sign(listing string, payload []byte) []byte {
+	h := hmac.New(sha256.New, c.key)
+	h.Write([]byte(listing))
+	h.Write([]byte{0})
+	h.Write(payload)
+	return h.Sum(nil)
+}
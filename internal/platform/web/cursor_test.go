@@ -0,0 +1,58 @@
+package web
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestCursors checks cursors read back what they were made with and that
+// altered ones, or ones of another listing or key, are refused.
+func TestCursors(t *testing.T) {
+	c := NewCursors([]byte("secret"))
+
+	created := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	cursor, err := c.Encode("products", created, "a2b0639f-2cc6-44b8-b97b-15d69dbb511e")
+	if err != nil {
+		t.Fatalf("encoding: %v", err)
+	}
+
+	var gotCreated time.Time
+	var gotID string
+	if err := c.Decode("products", cursor, &gotCreated, &gotID); err != nil {
+		t.Fatalf("decoding: %v", err)
+	}
+	if !gotCreated.Equal(created) || gotID != "a2b0639f-2cc6-44b8-b97b-15d69dbb511e" {
+		t.Errorf("decoded %v and %q", gotCreated, gotID)
+	}
+
+	// Flip one character in the middle of the cursor.
+	i := len(cursor) / 2
+	flipped := "A"
+	if cursor[i] == 'A' {
+		flipped = "B"
+	}
+	tampered := cursor[:i] + flipped + cursor[i+1:]
+
+	tests := []struct {
+		name     string
+		cursors  *Cursors
+		listing  string
+		cursor   string
+		dstCount int
+	}{
+		{"tampered", c, "products", tampered, 2},
+		{"other listing", c, "users", cursor, 2},
+		{"other key", NewCursors([]byte("other")), "products", cursor, 2},
+		{"truncated", c, "products", cursor[:10], 2},
+		{"garbage", c, "products", strings.Repeat("!", 60), 2},
+		{"fewer values", c, "products", cursor, 1},
+	}
+	for _, tt := range tests {
+		dst := []interface{}{&gotCreated, &gotID}[:tt.dstCount]
+		err := tt.cursors.Decode(tt.listing, tt.cursor, dst...)
+		if e, ok := err.(*Error); !ok || e.Err != ErrInvalidCursor {
+			t.Errorf("%s: got %v, want %v", tt.name, err, ErrInvalidCursor)
+		}
+	}
+}
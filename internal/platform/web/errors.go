@@ -8,10 +8,12 @@ type FieldError struct {
 	Error string `json:"error"`
 }
 
-// ErrorResponse is custom error struct that will be used when something will go wrong
+// ErrorResponse is custom error struct that will be used when something will go wrong.
+// RequestID lets a user quote the request when reporting the error.
 type ErrorResponse struct {
-	Error  string       `json:"error"`
-	Fields []FieldError `json:"fields,omitempty"`
+	Error     string       `json:"error"`
+	Fields    []FieldError `json:"fields,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
 }
 
 // Error is used to add an information to a request error
@@ -0,0 +1,105 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// Stage is the point in handling a request at which a Hook runs.
+type Stage int
+
+// The stages hooks can run at. PreAuth hooks run after the App's middleware
+// and before the route's own middleware, such as authentication, and may
+// stop the request by returning an error. PostHandler hooks run after the
+// handler succeeded and OnError hooks run when it, or a PreAuth hook, failed
+// and are passed the error. Errors returned by PostHandler and OnError hooks
+// are logged and do not change the response.
+const (
+	PreAuth Stage = iota
+	PostHandler
+	OnError
+)
+
+// String implements fmt.Stringer.
+func (s Stage) String() string {
+	switch s {
+	case PreAuth:
+		return "pre-auth"
+	case PostHandler:
+		return "post-handler"
+	case OnError:
+		return "on-error"
+	}
+	return fmt.Sprintf("Stage(%d)", int(s))
+}
+
+// HookFunc is the code a Hook runs. err is the error the request failed with
+// for OnError hooks and nil otherwise.
+type HookFunc func(ctx context.Context, w http.ResponseWriter, r *http.Request, err error) error
+
+// Hook extends the handling of every request of an App without changing its
+// handlers, such as to add headers required in some regions.
+type Hook struct {
+	Name  string
+	Stage Stage
+	Func  HookFunc
+}
+
+// Register adds a hook to every route of the App, whether the route was
+// handled before or after. Hooks of a stage run in the order they were
+// registered. Register must not be called once the App serves requests and
+// panics if the hook has no name or function, if its stage is unknown or if a
+// hook with the same name was registered.
+func (a *App) Register(h Hook) {
+	if h.Name == "" || h.Func == nil {
+		panic("web: hook needs a name and a function")
+	}
+	if h.Stage < PreAuth || h.Stage > OnError {
+		panic(fmt.Sprintf("web: hook %s has unknown stage %v", h.Name, h.Stage))
+	}
+	for _, hooks := range a.hooks {
+		for _, other := range hooks {
+			if other.Name == h.Name {
+				panic(fmt.Sprintf("web: hook %s registered twice", h.Name))
+			}
+		}
+	}
+
+	a.hooks[h.Stage] = append(a.hooks[h.Stage], h)
+}
+
+// runHooks wraps the registered hooks around a handler.
+func (a *App) runHooks(handler Handler) Handler {
+
+	h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		var err error
+		for _, hook := range a.hooks[PreAuth] {
+			if err = hook.Func(ctx, w, r, nil); err != nil {
+				break
+			}
+		}
+
+		if err == nil {
+			err = handler(ctx, w, r)
+		}
+
+		stage := PostHandler
+		if err != nil {
+			stage = OnError
+		}
+		for _, hook := range a.hooks[stage] {
+			if herr := hook.Func(ctx, w, r, err); herr != nil {
+				var traceID string
+				if v, ok := ctx.Value(KeyValues).(*Values); ok {
+					traceID = v.TraceID
+				}
+				a.log.Printf("%s : %s hook %s : %v", traceID, stage, hook.Name, herr)
+			}
+		}
+
+		return err
+	}
+
+	return h
+}
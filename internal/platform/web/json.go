@@ -0,0 +1,405 @@
+package web
+
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// JSONOptions controls how null and empty values are rendered in responses.
+// The zero value renders exactly like encoding/json.
+type JSONOptions struct {
+
+	// EmptyCollections renders nil slices and maps as [] and {} instead of
+	// null.
+	EmptyCollections bool
+
+	// ZeroNumbers renders nil pointers to numbers as 0 instead of null.
+	ZeroNumbers bool
+
+	// OmitNulls leaves out struct fields which would otherwise render as
+	// null, whether or not they are tagged omitempty.
+	OmitNulls bool
+
+	// FastPath renders values implementing Appender with their own hand
+	// written encoder instead of by reflection.
+	FastPath bool
+}
+
+// Appender is implemented by values of hot responses which can render
+// themselves without reflection. AppendJSON appends the same JSON Marshal
+// would produce for the value under o to dst.
+type Appender interface {
+	AppendJSON(dst []byte, o JSONOptions) []byte
+}
+
+// Marshal renders val as JSON following the options. Struct tags are honoured
+// the same way encoding/json does and values implementing json.Marshaler or
+// encoding.TextMarshaler render themselves.
+func (o JSONOptions) Marshal(val interface{}) ([]byte, error) {
+	if o == (JSONOptions{}) {
+		return json.Marshal(val)
+	}
+
+	var buf buffer
+	if err := o.encodeTo(&buf, val); err != nil {
+		return nil, err
+	}
+	return buf.b, nil
+}
+
+// encodeTo renders val as JSON into buf following the options.
+func (o JSONOptions) encodeTo(buf *buffer, val interface{}) error {
+	if o.FastPath {
+		if a, ok := val.(Appender); ok {
+			buf.b = a.AppendJSON(buf.b, o)
+			buf.spill()
+			return buf.err
+		}
+		o.FastPath = false
+	}
+
+	if o == (JSONOptions{}) {
+
+		// The encoder ends its output with a newline which has to be taken
+		// off before any of it goes out.
+		out := buf.out
+		buf.out = nil
+		if err := json.NewEncoder(buf).Encode(val); err != nil {
+			buf.out = out
+			return errors.Wrap(err, "marshaling value to json")
+		}
+		buf.b = buf.b[:len(buf.b)-1]
+		buf.out = out
+		buf.spill()
+		return buf.err
+	}
+
+	if err := o.encode(buf, reflect.ValueOf(val)); err != nil {
+		return err
+	}
+	return buf.err
+}
+
+var (
+	marshalerType     = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// encode writes v to buf.
+func (o JSONOptions) encode(buf *buffer, v reflect.Value) error {
+	if !v.IsValid() {
+		buf.WriteString("null")
+		return nil
+	}
+
+	t := v.Type()
+	if t.Implements(marshalerType) || t.Implements(textMarshalerType) {
+		if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+			buf.WriteString("null")
+			return nil
+		}
+		return marshal(buf, v.Interface())
+	}
+	if v.CanAddr() {
+		pt := reflect.PtrTo(t)
+		if pt.Implements(marshalerType) || pt.Implements(textMarshalerType) {
+			return marshal(buf, v.Addr().Interface())
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			o.encodeNil(buf, t.Elem())
+			return nil
+		}
+		return o.encode(buf, v.Elem())
+
+	case reflect.Interface:
+		if v.IsNil() {
+			buf.WriteString("null")
+			return nil
+		}
+		return o.encode(buf, v.Elem())
+
+	case reflect.Struct:
+		return o.encodeStruct(buf, v)
+
+	case reflect.Map:
+		if v.IsNil() {
+			o.encodeNil(buf, t)
+			return nil
+		}
+		if t.Key().Kind() != reflect.String {
+			return marshal(buf, v.Interface())
+		}
+		return o.encodeMap(buf, v)
+
+	case reflect.Slice:
+		if v.IsNil() {
+			o.encodeNil(buf, t)
+			return nil
+		}
+		if t.Elem().Kind() == reflect.Uint8 {
+			return marshal(buf, v.Interface())
+		}
+		fallthrough
+
+	case reflect.Array:
+		buf.WriteByte('[')
+		for i := 0; i < v.Len(); i++ {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := o.encode(buf, v.Index(i)); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+		return nil
+
+	case reflect.String:
+		buf.b = AppendString(buf.b, v.String())
+		buf.spill()
+		return nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		buf.b = strconv.AppendInt(buf.b, v.Int(), 10)
+		return nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		buf.b = strconv.AppendUint(buf.b, v.Uint(), 10)
+		return nil
+
+	case reflect.Bool:
+		buf.b = strconv.AppendBool(buf.b, v.Bool())
+		return nil
+
+	default:
+		return marshal(buf, v.Interface())
+	}
+}
+
+// encodeNil writes the rendering of a nil value of type t.
+func (o JSONOptions) encodeNil(buf *buffer, t reflect.Type) {
+	switch {
+	case o.EmptyCollections && t.Kind() == reflect.Slice:
+		buf.WriteString("[]")
+	case o.EmptyCollections && t.Kind() == reflect.Map:
+		buf.WriteString("{}")
+	case o.ZeroNumbers && isNumber(t):
+		buf.WriteString("0")
+	default:
+		buf.WriteString("null")
+	}
+}
+
+// encodeMap writes a map with string keys, sorted like encoding/json does.
+func (o JSONOptions) encodeMap(buf *buffer, v reflect.Value) error {
+	keys := v.MapKeys()
+	sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+
+	buf.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.b = AppendString(buf.b, k.String())
+		buf.WriteByte(':')
+		if err := o.encode(buf, v.MapIndex(k)); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+// encodeStruct writes the exported fields of a struct.
+func (o JSONOptions) encodeStruct(buf *buffer, v reflect.Value) error {
+	buf.WriteByte('{')
+	first := true
+	for _, f := range fields(v.Type()) {
+		fv, ok := fieldByIndex(v, f.index)
+		if !ok {
+			continue
+		}
+		if f.omitEmpty && isEmpty(fv) {
+			continue
+		}
+		if o.OmitNulls && o.rendersNull(fv) {
+			continue
+		}
+
+		if !first {
+			buf.WriteByte(',')
+		}
+		first = false
+
+		buf.b = AppendString(buf.b, f.name)
+		buf.WriteByte(':')
+		if err := o.encode(buf, fv); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+// rendersNull reports whether v would be rendered as null.
+func (o JSONOptions) rendersNull(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Interface:
+		return v.IsNil()
+	case reflect.Ptr:
+		return v.IsNil() && !(o.ZeroNumbers && isNumber(v.Type().Elem()))
+	case reflect.Slice, reflect.Map:
+		return v.IsNil() && !o.EmptyCollections
+	}
+	return false
+}
+
+// field is a struct field rendered in JSON.
+type field struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+// fieldCache holds the fields of every struct type rendered so far.
+var fieldCache sync.Map
+
+// fields lists the fields of t in the order encoding/json renders them,
+// promoting the fields of embedded structs. When names clash the shallowest
+// field wins.
+func fields(t reflect.Type) []field {
+	if list, ok := fieldCache.Load(t); ok {
+		return list.([]field)
+	}
+	list := typeFields(t)
+	fieldCache.Store(t, list)
+	return list
+}
+
+// typeFields works out the fields of t for fields.
+func typeFields(t reflect.Type) []field {
+	var list []field
+	depth := make(map[string]int)
+
+	var walk func(t reflect.Type, index []int)
+	walk = func(t reflect.Type, index []int) {
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			tag := sf.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			name, opts := tag, ""
+			if j := strings.Index(tag, ","); j >= 0 {
+				name, opts = tag[:j], tag[j:]
+			}
+
+			idx := append(append([]int(nil), index...), i)
+
+			ft := sf.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if sf.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+				walk(ft, idx)
+				continue
+			}
+			if sf.PkgPath != "" {
+				continue
+			}
+
+			if name == "" {
+				name = sf.Name
+			}
+			if d, ok := depth[name]; ok && d <= len(idx) {
+				continue
+			}
+			depth[name] = len(idx)
+
+			f := field{
+				name:      name,
+				index:     idx,
+				omitEmpty: strings.Contains(opts, ",omitempty"),
+			}
+			replaced := false
+			for k := range list {
+				if list[k].name == name {
+					list[k] = f
+					replaced = true
+				}
+			}
+			if !replaced {
+				list = append(list, f)
+			}
+		}
+	}
+	walk(t, nil)
+
+	return list
+}
+
+// fieldByIndex is reflect.Value.FieldByIndex which reports false instead of
+// panicking when it passes through a nil embedded pointer.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// isEmpty reports whether v is empty in the omitempty sense.
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// isNumber reports whether t is a numeric type.
+func isNumber(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// marshal writes val encoded by encoding/json to buf.
+func marshal(buf *buffer, val interface{}) error {
+	data, err := json.Marshal(val)
+	if err != nil {
+		return errors.Wrap(err, "marshaling value to json")
+	}
+	buf.Write(data)
+	return nil
+}
@@ -0,0 +1,128 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// money renders itself through a pointer receiver.
+type money int
+
+func (m *money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(float64(*m) / 100)
+}
+
+type base struct {
+	ID          string    `json:"id"`
+	DateCreated time.Time `json:"date_created"`
+}
+
+type fixture struct {
+	base
+	Name      string            `json:"name"`
+	Tags      []string          `json:"tags"`
+	Sold      *int              `json:"sold"`
+	Rating    *float64          `json:"rating"`
+	Note      *string           `json:"note"`
+	Coupon    *string           `json:"coupon,omitempty"`
+	Attrs     map[string]string `json:"attrs"`
+	Price     money             `json:"price"`
+	Raw       json.RawMessage   `json:"raw"`
+	Secret    string            `json:"-"`
+	Language  string            `json:"language,omitempty"`
+	Children  []fixture         `json:"children,omitempty"`
+	Anything  interface{}       `json:"anything"`
+	unexposed string
+}
+
+func newFixture() *fixture {
+	sold := 3
+	return &fixture{
+		base: base{
+			ID:          "a2b0639f-2cc6-44b8-b97b-15d69dbb511e",
+			DateCreated: time.Date(2019, 1, 1, 0, 0, 1, 0, time.UTC),
+		},
+		Name:      "Comic Books",
+		Price:     5000,
+		Raw:       json.RawMessage(`{"ok":true}`),
+		Secret:    "hidden",
+		unexposed: "hidden",
+		Children: []fixture{
+			{Name: "Issue #1", Sold: &sold, Tags: []string{"comics"}, Raw: json.RawMessage(`null`)},
+		},
+	}
+}
+
+func TestJSONOptionsGolden(t *testing.T) {
+	tests := []struct {
+		name string
+		opts JSONOptions
+	}{
+		{"default", JSONOptions{}},
+		{"empty_collections", JSONOptions{EmptyCollections: true}},
+		{"zero_numbers", JSONOptions{ZeroNumbers: true}},
+		{"omit_nulls", JSONOptions{OmitNulls: true}},
+		{"all", JSONOptions{EmptyCollections: true, ZeroNumbers: true, OmitNulls: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.opts.Marshal(newFixture())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var pretty bytes.Buffer
+			if err := json.Indent(&pretty, got, "", "  "); err != nil {
+				t.Fatalf("invalid json %s: %v", got, err)
+			}
+			pretty.WriteByte('\n')
+
+			golden := filepath.Join("testdata", tt.name+".golden")
+			if *update {
+				if err := ioutil.WriteFile(golden, pretty.Bytes(), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(pretty.Bytes(), want) {
+				t.Errorf("output does not match %s\ngot:\n%s\nwant:\n%s", golden, pretty.Bytes(), want)
+			}
+		})
+	}
+}
+
+// TestJSONOptionsMatchStdlib checks that with only EmptyCollections set,
+// anything which is not a nil collection renders like encoding/json.
+func TestJSONOptionsMatchStdlib(t *testing.T) {
+	f := newFixture()
+	f.Tags = []string{}
+	f.Attrs = map[string]string{"b": "2", "a": "1"}
+	f.Anything = []int{1, 2}
+	f.Children[0].Tags = []string{}
+	f.Children[0].Attrs = map[string]string{}
+
+	want, err := json.Marshal(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := JSONOptions{EmptyCollections: true}.Marshal(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got, want) {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
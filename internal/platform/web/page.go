@@ -0,0 +1,88 @@
+package web
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// Page sizes used when a PageSize leaves them unset.
+const (
+	DefaultLimit = 100
+	MaxLimit     = 1000
+)
+
+// PageSize sets how many rows a listing returns when the client does not ask
+// for a number and the most it may ask for. Zero fields fall back to
+// DefaultLimit and MaxLimit. Only listings paginated by keyset set Keyset;
+// the others refuse a cursor rather than ignore it.
+type PageSize struct {
+	Default int
+	Max     int
+	Keyset  bool
+}
+
+// Page selects a window of rows from a listing. Listings paginated by keyset
+// use Cursor, read with Cursors, instead of Offset.
+type Page struct {
+	Limit  int
+	Offset int
+	Cursor string
+}
+
+// ParsePage reads the limit, offset and cursor query parameters of a request. When a
+// parameter is missing the limit defaults to the default of size and the
+// offset to zero. Asking for more rows than the maximum of size fails rather
+// than quietly returning fewer, as does a cursor for a listing which is not
+// paginated by keyset.
+func ParsePage(r *http.Request, size PageSize) (Page, error) {
+	max := size.Max
+	if max <= 0 {
+		max = MaxLimit
+	}
+	def := size.Default
+	if def <= 0 {
+		def = DefaultLimit
+	}
+	if def > max {
+		def = max
+	}
+
+	p := Page{Limit: def}
+	q := r.URL.Query()
+
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			err := errors.New("limit must be a positive number")
+			return p, NewRequestError(err, http.StatusBadRequest)
+		}
+		if n > max {
+			err := errors.Errorf("limit must be at most %d", max)
+			return p, NewRequestError(err, http.StatusBadRequest)
+		}
+		p.Limit = n
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			err := errors.New("offset must be a non-negative number")
+			return p, NewRequestError(err, http.StatusBadRequest)
+		}
+		p.Offset = n
+	}
+	if v := q.Get("cursor"); v != "" {
+		if !size.Keyset {
+			err := errors.New("cursor is not supported by this listing")
+			return p, NewRequestError(err, http.StatusBadRequest)
+		}
+		if p.Offset != 0 {
+			err := errors.New("offset and cursor can not be used together")
+			return p, NewRequestError(err, http.StatusBadRequest)
+		}
+		p.Cursor = v
+	}
+
+	return p, nil
+}
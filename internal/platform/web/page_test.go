@@ -0,0 +1,47 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestParsePage checks the defaults and caps of page sizes.
+func TestParsePage(t *testing.T) {
+	tests := []struct {
+		query string
+		size  PageSize
+		limit int
+		fail  bool
+	}{
+		{"", PageSize{}, DefaultLimit, false},
+		{"", PageSize{Default: 20, Max: 50}, 20, false},
+		{"", PageSize{Default: 100, Max: 50}, 50, false},
+		{"limit=50", PageSize{Default: 20, Max: 50}, 50, false},
+		{"limit=51", PageSize{Default: 20, Max: 50}, 0, true},
+		{"limit=1000000", PageSize{}, 0, true},
+		{"limit=0", PageSize{}, 0, true},
+		{"limit=ten", PageSize{}, 0, true},
+		{"cursor=abc", PageSize{Keyset: true}, DefaultLimit, false},
+		{"cursor=abc", PageSize{}, 0, true},
+		{"offset=5&cursor=abc", PageSize{Keyset: true}, 0, true},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/?"+tt.query, nil)
+		p, err := ParsePage(r, tt.size)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%q with %+v: expected an error", tt.query, tt.size)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q with %+v: %v", tt.query, tt.size, err)
+			continue
+		}
+		if p.Limit != tt.limit {
+			t.Errorf("%q with %+v: limit %d, want %d", tt.query, tt.size, p.Limit, tt.limit)
+		}
+	}
+}
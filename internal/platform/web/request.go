@@ -8,6 +8,8 @@ import (
 	"strings"
 
 	en "github.com/go-playground/locales/en"
+	hy "github.com/go-playground/locales/hy"
+	ru "github.com/go-playground/locales/ru"
 	ut "github.com/go-playground/universal-translator"
 	validator "gopkg.in/go-playground/validator.v9"
 	en_translations "gopkg.in/go-playground/validator.v9/translations/en"
@@ -19,6 +21,10 @@ var validate = validator.New()
 // translator is a cache of locale and translation information
 var translator *ut.UniversalTranslator
 
+// languages lists the languages validation messages are available in. The
+// first is the fallback.
+var languages = []string{"en", "hy", "ru"}
+
 func init() {
 
 	// Instantiate the english locale for the validator library
@@ -26,12 +32,18 @@ func init() {
 
 	// Create a value using English as the fallback locale (first argument)
 	// Provide one or more arguments for additional supported locales
-	translator = ut.New(enLocale, enLocale)
+	translator = ut.New(enLocale, enLocale, hy.New(), ru.New())
 
 	// Register the english error messages for validation errors
 	lang, _ := translator.GetTranslator("en")
 	en_translations.RegisterDefaultTranslations(validate, lang)
 
+	// The validator has no messages in the other languages.
+	for _, locale := range languages[1:] {
+		lang, _ := translator.GetTranslator(locale)
+		registerMessages(validate, lang, locale)
+	}
+
 	// Use JSON tag names for errors instead of Go struct names
 	validate.RegisterTagNameFunc(func(fld reflect.StructField) string {
 		name := strings.SplitN(fld.Tag.Get("json"), ",", 2)[0]
@@ -42,6 +54,38 @@ func init() {
 	})
 }
 
+// messageLanguage picks the language of validation messages for a request.
+// The first language of the Accept-Language header which is available wins,
+// then the locale of the tenant making the request, then English.
+func messageLanguage(r *http.Request) string {
+	available := func(tag string) string {
+		tag = strings.ToLower(tag)
+		if i := strings.IndexAny(tag, "-_"); i >= 0 {
+			tag = tag[:i]
+		}
+		for _, l := range languages {
+			if l == tag {
+				return l
+			}
+		}
+		return ""
+	}
+
+	for _, tag := range AcceptLanguages(r) {
+		if l := available(tag); l != "" {
+			return l
+		}
+	}
+
+	if v, ok := r.Context().Value(KeyValues).(*Values); ok && v.Tenant != "" && v.locale != nil {
+		if l := available(v.locale(r.Context(), v.Tenant)); l != "" {
+			return l
+		}
+	}
+
+	return languages[0]
+}
+
 // Decode reads the body of an HTTP request looking for a  JSON document
 // The body is decoded into the provided value
 //
@@ -53,6 +97,11 @@ func Decode(r *http.Request, val interface{}) error {
 		return NewRequestError(err, http.StatusBadRequest)
 	}
 
+	// Only structs carry validation tags.
+	if v := reflect.Indirect(reflect.ValueOf(val)); v.Kind() != reflect.Struct {
+		return nil
+	}
+
 	if err := validate.Struct(val); err != nil {
 
 		// Use a type assertion to get the real error value
@@ -61,15 +110,21 @@ func Decode(r *http.Request, val interface{}) error {
 			return err
 		}
 
-		// lang controls the language of the error messages. You could look at the
-		// Accept-Language header if you intend to support multiple languages
-		lang, _ := translator.GetTranslator("en")
+		// lang controls the language of the error messages. Messages missing
+		// in the language are given in English.
+		locale := messageLanguage(r)
+		lang, _ := translator.GetTranslator(locale)
+		fallback, _ := translator.GetTranslator(languages[0])
 
 		var fields []FieldError
 		for _, verror := range verrors {
+			trans := lang
+			if _, ok := messages[locale][verror.Tag()]; !ok {
+				trans = fallback
+			}
 			field := FieldError{
 				Field: verror.Field(),
-				Error: verror.Translate(lang),
+				Error: verror.Translate(trans),
 			}
 			fields = append(fields, field)
 		}
@@ -2,13 +2,15 @@ package web
 
 import (
 	"context"
-	"encoding/json"
 	"net/http"
 
 	"github.com/pkg/errors"
 )
 
-// Respond marshals to a JSON and sends it to the client
+// Respond marshals to a JSON and sends it to the client. The JSON is encoded
+// into a pooled buffer. Responses larger than the buffer holds start going
+// out while the rest is encoded; when encoding fails after that the response
+// is cut short.
 func Respond(ctx context.Context, w http.ResponseWriter, val interface{}, statusCode int) error {
 
 	v, ok := ctx.Value(KeyValues).(*Values)
@@ -17,34 +19,65 @@ func Respond(ctx context.Context, w http.ResponseWriter, val interface{}, status
 	}
 	v.StatusCode = statusCode
 
-	if statusCode == http.StatusNoContent {
+	if statusCode == http.StatusNoContent || statusCode == http.StatusNotModified {
 		w.WriteHeader(statusCode)
 		return nil
 	}
 
-	data, err := json.Marshal(val)
-	if err != nil {
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	buf.out = &streamWriter{
+		w:           w,
+		values:      v,
+		contentType: "application/json; charset=utf-8",
+		statusCode:  statusCode,
+	}
+
+	if err := v.JSON.encodeTo(buf, val); err != nil {
 		return errors.Wrap(err, "marshaling value to json")
 	}
+	buf.flush()
+	if buf.err != nil {
+		return buf.err
+	}
 
-	w.Header().Set("content-type", "application/json; charset=utf-8")
-	w.WriteHeader(statusCode)
-	if _, err := w.Write(data); err != nil {
-		return errors.Wrap(err, "writing to client")
+	return nil
+}
+
+// Redirect sends the client to url with a redirect status code.
+func Redirect(ctx context.Context, w http.ResponseWriter, r *http.Request, url string, statusCode int) error {
+	v, ok := ctx.Value(KeyValues).(*Values)
+	if !ok {
+		return errors.New("web values missing from context")
 	}
+	v.StatusCode = statusCode
 
+	http.Redirect(w, r, url, statusCode)
 	return nil
 }
 
 // RespondError knows how to handle errors going to the client
 func RespondError(ctx context.Context, w http.ResponseWriter, err error) error {
 
+	// A streamed response which already started can not be replaced by an
+	// error response. The client notices the body being cut short.
+	v, ok := ctx.Value(KeyValues).(*Values)
+	if ok && v.Committed {
+		return nil
+	}
+	var requestID string
+	if ok {
+		requestID = v.RequestID
+	}
+
 	// if the error was of the type *Error, the handler has
 	// a specific status code an error to return.
 	if webErr, ok := errors.Cause(err).(*Error); ok {
 		er := ErrorResponse{
-			Error:  webErr.Err.Error(),
-			Fields: webErr.Fields,
+			Error:     webErr.Err.Error(),
+			Fields:    webErr.Fields,
+			RequestID: requestID,
 		}
 
 		if err := Respond(ctx, w, er, webErr.Status); err != nil {
@@ -54,7 +87,8 @@ func RespondError(ctx context.Context, w http.ResponseWriter, err error) error {
 	}
 
 	er := ErrorResponse{
-		Error: http.StatusText(http.StatusInternalServerError),
+		Error:     http.StatusText(http.StatusInternalServerError),
+		RequestID: requestID,
 	}
 
 	if err := Respond(ctx, w, er, http.StatusInternalServerError); err != nil {
@@ -62,3 +96,22 @@ func RespondError(ctx context.Context, w http.ResponseWriter, err error) error {
 	}
 	return nil
 }
+
+// RespondRaw sends data to the client as is using the provided content type.
+// It is used for responses that are not JSON documents.
+func RespondRaw(ctx context.Context, w http.ResponseWriter, data []byte, contentType string, statusCode int) error {
+
+	v, ok := ctx.Value(KeyValues).(*Values)
+	if !ok {
+		return errors.New("web values missing from context")
+	}
+	v.StatusCode = statusCode
+
+	w.Header().Set("content-type", contentType)
+	w.WriteHeader(statusCode)
+	if _, err := w.Write(data); err != nil {
+		return errors.Wrap(err, "writing to client")
+	}
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// discard is a ResponseWriter which throws the response away so benchmarks
+// measure Respond alone.
+type discard struct {
+	header http.Header
+}
+
+func (d *discard) Header() http.Header         { return d.header }
+func (d *discard) Write(p []byte) (int, error) { return len(p), nil }
+func (d *discard) WriteHeader(int)             {}
+
+// TestRespondSpills checks responses larger than the buffer come out whole.
+func TestRespondSpills(t *testing.T) {
+	list := make([]fixture, 2000)
+	for i := range list {
+		list[i] = *newFixture()
+	}
+
+	for _, opts := range []JSONOptions{{}, {EmptyCollections: true}} {
+		want, err := opts.Marshal(list)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(want) < 2*spillSize {
+			t.Fatalf("response of %d bytes does not spill", len(want))
+		}
+
+		w := httptest.NewRecorder()
+		ctx := context.WithValue(context.Background(), KeyValues, &Values{JSON: opts})
+		if err := Respond(ctx, w, list, http.StatusOK); err != nil {
+			t.Fatal(err)
+		}
+
+		if w.Code != http.StatusOK {
+			t.Errorf("status %d", w.Code)
+		}
+		if !bytes.Equal(w.Body.Bytes(), want) {
+			t.Errorf("with %+v the body does not match Marshal", opts)
+		}
+	}
+}
+
+func benchmarkRespond(b *testing.B, opts JSONOptions, n int) {
+	list := make([]fixture, n)
+	for i := range list {
+		list[i] = *newFixture()
+		list[i].Name = fmt.Sprintf("Comic Books #%d", i)
+	}
+
+	w := discard{header: make(http.Header)}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ctx := context.WithValue(context.Background(), KeyValues, &Values{JSON: opts})
+		if err := Respond(ctx, &w, list, http.StatusOK); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRespondSmall(b *testing.B) {
+	benchmarkRespond(b, JSONOptions{}, 1)
+}
+
+func BenchmarkRespondLarge(b *testing.B) {
+	benchmarkRespond(b, JSONOptions{}, 1000)
+}
+
+func BenchmarkRespondOptionsSmall(b *testing.B) {
+	benchmarkRespond(b, JSONOptions{EmptyCollections: true, ZeroNumbers: true}, 1)
+}
+
+func BenchmarkRespondOptionsLarge(b *testing.B) {
+	benchmarkRespond(b, JSONOptions{EmptyCollections: true, ZeroNumbers: true}, 1000)
+}
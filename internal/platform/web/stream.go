@@ -0,0 +1,84 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// RespondStream sends the output of write to the client as it is produced
+// rather than buffering the whole response. It is meant for large downloads
+// such as exports. When filename is not empty the client is asked to save the
+// response under that name.
+//
+// The status and headers are only sent with the first bytes written, so when
+// write fails before producing any output the error is returned and reported
+// to the client as usual. Once output has started a failure can only cut the
+// response short.
+func RespondStream(ctx context.Context, w http.ResponseWriter, contentType, filename string, statusCode int, write func(io.Writer) error) error {
+
+	v, ok := ctx.Value(KeyValues).(*Values)
+	if !ok {
+		return errors.New("web values missing from context")
+	}
+
+	sw := streamWriter{
+		w:           w,
+		values:      v,
+		contentType: contentType,
+		filename:    filename,
+		statusCode:  statusCode,
+		flush:       true,
+	}
+
+	if err := write(&sw); err != nil {
+		return err
+	}
+
+	// Make sure the headers go out even when nothing was written.
+	sw.commit()
+	return nil
+}
+
+// streamWriter delays sending the response headers until the first write.
+// With flush set every write is flushed to the client.
+type streamWriter struct {
+	w           http.ResponseWriter
+	values      *Values
+	contentType string
+	filename    string
+	statusCode  int
+	flush       bool
+}
+
+// commit sends the status and headers if they were not sent yet.
+func (sw *streamWriter) commit() {
+	if sw.values.Committed {
+		return
+	}
+	sw.values.Committed = true
+	sw.values.StatusCode = sw.statusCode
+
+	sw.w.Header().Set("content-type", sw.contentType)
+	if sw.filename != "" {
+		sw.w.Header().Set("content-disposition", fmt.Sprintf("attachment; filename=%q", sw.filename))
+	}
+	sw.w.WriteHeader(sw.statusCode)
+}
+
+// Write implements the io.Writer interface.
+func (sw *streamWriter) Write(p []byte) (int, error) {
+	sw.commit()
+
+	n, err := sw.w.Write(p)
+	if err != nil {
+		return n, errors.Wrap(err, "writing to client")
+	}
+	if f, ok := sw.w.(http.Flusher); ok && sw.flush {
+		f.Flush()
+	}
+	return n, nil
+}
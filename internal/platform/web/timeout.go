@@ -0,0 +1,54 @@
+package web
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+)
+
+// keyConn is how the connection of a request is stored.
+const keyConn ctxKey = 2
+
+// ConnContext is meant to be the ConnContext of the http.Server serving an
+// App. It makes the connection of each request reachable so routes handled
+// with HandleStream can move its write deadline.
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, keyConn, c)
+}
+
+// SetStreamTimeout sets how long the responses of routes handled with
+// HandleStream may take to write, overriding the WriteTimeout of the server.
+// Zero lets them take as long as they need.
+func (a *App) SetStreamTimeout(d time.Duration) {
+	a.streamTimeout = d
+}
+
+// HandleStream is Handle for routes with long lived responses, such as
+// downloads and event streams, which the WriteTimeout of the server would cut
+// short. Their write deadline is the stream timeout of the App instead. This
+// requires the server to use ConnContext; otherwise the route behaves as if
+// registered with Handle.
+func (a *App) HandleStream(method, pattern string, h Handler, mw ...Middleware) {
+	a.Handle(method, pattern, h, append([]Middleware{a.extendWrites}, mw...)...)
+}
+
+// extendWrites moves the write deadline of the connection to the stream
+// timeout from now.
+func (a *App) extendWrites(after Handler) Handler {
+	h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		if c, ok := ctx.Value(keyConn).(net.Conn); ok {
+			var deadline time.Time
+			if a.streamTimeout > 0 {
+				deadline = time.Now().Add(a.streamTimeout)
+			}
+			c.SetWriteDeadline(deadline)
+
+			// The server sets the deadline of the next request on the
+			// connection only when it has a WriteTimeout of its own.
+			defer c.SetWriteDeadline(time.Time{})
+		}
+		return after(ctx, w, r)
+	}
+	return h
+}
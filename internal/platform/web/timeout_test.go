@@ -0,0 +1,55 @@
+package web
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestHandleStream checks streamed routes outlive the WriteTimeout of the
+// server while other routes are still cut off by it.
+func TestHandleStream(t *testing.T) {
+	app := NewApp(make(chan os.Signal, 1), log.New(ioutil.Discard, "", 0))
+	app.SetStreamTimeout(time.Minute)
+
+	slow := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		time.Sleep(200 * time.Millisecond)
+		write := func(out io.Writer) error {
+			_, err := io.WriteString(out, "done")
+			return err
+		}
+		return RespondStream(ctx, w, "text/plain", "", http.StatusOK, write)
+	}
+	app.Handle(http.MethodGet, "/plain", slow)
+	app.HandleStream(http.MethodGet, "/stream", slow)
+
+	srv := httptest.NewUnstartedServer(app)
+	srv.Config.WriteTimeout = 50 * time.Millisecond
+	srv.Config.ConnContext = ConnContext
+	srv.Start()
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/stream")
+	if err != nil {
+		t.Fatalf("streamed route: %v", err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(body) != "done" {
+		t.Errorf("streamed route gave %q, %v", body, err)
+	}
+
+	if resp, err := http.Get(srv.URL + "/plain"); err == nil {
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err == nil && string(body) == "done" {
+			t.Error("plain route outlived the write timeout")
+		}
+	}
+}
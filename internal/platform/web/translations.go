@@ -0,0 +1,66 @@
+package web
+
+import (
+	ut "github.com/go-playground/universal-translator"
+	validator "gopkg.in/go-playground/validator.v9"
+)
+
+// messages are the validation messages of the languages the validator has
+// no translations for. {0} is the field and {1} the parameter of the tag.
+// Tags without a message here fall back to English.
+var messages = map[string]map[string]string{
+	"hy": {
+		"required":      "{0} դաշտը պարտադիր է",
+		"required_with": "{0} դաշտը պարտադիր է, երբ նշված է {1}",
+		"email":         "{0} դաշտը պետք է լինի վավեր էլ. փոստի հասցե",
+		"url":           "{0} դաշտը պետք է լինի վավեր URL",
+		"uuid":          "{0} դաշտը պետք է լինի վավեր UUID",
+		"e164":          "{0} դաշտը պետք է լինի E.164 ձևաչափով հեռախոսահամար",
+		"alpha":         "{0} դաշտը կարող է պարունակել միայն տառեր",
+		"eqfield":       "{0} դաշտը պետք է համընկնի {1} դաշտի հետ",
+		"gtfield":       "{0} դաշտը պետք է մեծ լինի {1} դաշտից",
+		"oneof":         "{0} դաշտը պետք է լինի [{1}]-ից մեկը",
+		"len":           "{0} դաշտի երկարությունը պետք է լինի {1}",
+		"min":           "{0} դաշտը պետք է լինի առնվազն {1}",
+		"max":           "{0} դաշտը պետք է լինի առավելագույնը {1}",
+		"gt":            "{0} դաշտը պետք է մեծ լինի {1}-ից",
+		"gte":           "{0} դաշտը պետք է լինի {1} կամ ավելի",
+		"lte":           "{0} դաշտը պետք է լինի {1} կամ պակաս",
+	},
+	"ru": {
+		"required":      "{0} обязательное поле",
+		"required_with": "{0} обязательное поле, если указано {1}",
+		"email":         "{0} должен быть действительным адресом электронной почты",
+		"url":           "{0} должен быть действительным URL",
+		"uuid":          "{0} должен быть действительным UUID",
+		"e164":          "{0} должен быть номером телефона в формате E.164",
+		"alpha":         "{0} может содержать только буквы",
+		"eqfield":       "{0} должно совпадать с {1}",
+		"gtfield":       "{0} должно быть больше {1}",
+		"oneof":         "{0} должно быть одним из [{1}]",
+		"len":           "{0} должно иметь длину {1}",
+		"min":           "{0} должно быть не меньше {1}",
+		"max":           "{0} должно быть не больше {1}",
+		"gt":            "{0} должно быть больше {1}",
+		"gte":           "{0} должно быть больше или равно {1}",
+		"lte":           "{0} должно быть меньше или равно {1}",
+	},
+}
+
+// registerMessages registers the messages of locale with the validator.
+func registerMessages(v *validator.Validate, trans ut.Translator, locale string) {
+	for tag, msg := range messages[locale] {
+		msg := msg
+		register := func(trans ut.Translator) error {
+			return trans.Add(tag, msg, true)
+		}
+		translate := func(trans ut.Translator, fe validator.FieldError) string {
+			t, err := trans.T(fe.Tag(), fe.Field(), fe.Param())
+			if err != nil {
+				return fe.(error).Error()
+			}
+			return t
+		}
+		v.RegisterTranslation(tag, trans, register, translate)
+	}
+}
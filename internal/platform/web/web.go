@@ -5,9 +5,11 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/arammikayelyan/garagesale/internal/platform/clock"
 	"github.com/go-chi/chi"
 	"go.opencensus.io/plugin/ochttp"
 	_ "go.opencensus.io/plugin/ochttp"
@@ -22,13 +24,34 @@ type ctxKey int
 // KeyValues is how request values or stored/retreived.
 const KeyValues ctxKey = 1
 
-// Values carries information about each request.
+// Values carries information about each request. Committed is set once a
+// streamed response has started and its status can no longer change. JSON
+// holds the App's options for rendering JSON responses.
 type Values struct {
 	StatusCode int
 	Start      time.Time
 	TraceID    string
+	RequestID  string
+	Committed  bool
+	JSON       JSONOptions
+
+	// Tenant is the tenant of the user making the request once it is
+	// authenticated.
+	Tenant string
+
+	// Subject is the user making the request once it is authenticated and
+	// Actor the admin acting as them, if any.
+	Subject string
+	Actor   string
+
+	// locale gives the default locale of a tenant.
+	locale LocaleFunc
 }
 
+// LocaleFunc gives the default locale of a tenant, or an empty string when it
+// is not known.
+type LocaleFunc func(ctx context.Context, tenantID string) string
+
 // Handler is the signature that all application handlers will implement
 type Handler func(context.Context, http.ResponseWriter, *http.Request) error
 
@@ -38,6 +61,13 @@ type App struct {
 	mw       []Middleware
 	och      *ochttp.Handler
 	shutdown chan os.Signal
+	json     JSONOptions
+	clock    clock.Clock
+	hooks    map[Stage][]Hook
+	prefix   string
+	locale   LocaleFunc
+
+	streamTimeout time.Duration
 }
 
 // NewApp constructs an App to handle a set of routes. Any middleware
@@ -48,6 +78,8 @@ func NewApp(shutdown chan os.Signal, logger *log.Logger, mw ...Middleware) *App
 		log:      logger,
 		mw:       mw,
 		shutdown: shutdown,
+		clock:    clock.System{},
+		hooks:    make(map[Stage][]Hook),
 	}
 
 	// Create an OpenCensus HTTP Handler which wraps the router. This will
@@ -73,6 +105,9 @@ func (a *App) Handle(method, pattern string, h Handler, mw ...Middleware) {
 	// First wrap handler specific middleware around this handler.
 	h = wrapMiddleware(mw, h)
 
+	// Run the registered hooks around the route.
+	h = a.runHooks(h)
+
 	// Add the application's general middleware to the handler chain.
 	h = wrapMiddleware(a.mw, h)
 
@@ -88,12 +123,15 @@ func (a *App) Handle(method, pattern string, h Handler, mw ...Middleware) {
 		// address in the request's context so it is sent down the call chain.
 		v := Values{
 			TraceID: span.SpanContext().TraceID.String(),
-			Start:   time.Now(),
+			Start:   a.clock.Now(),
+			JSON:    a.json,
+			locale:  a.locale,
 		}
 		ctx = context.WithValue(ctx, KeyValues, &v)
 
-		// Run the handler chain and catch any propagated error.
-		if err := h(ctx, w, r); err != nil {
+		// Run the handler chain and catch any propagated error. The request
+		// carries the values too so Decode can read them.
+		if err := h(ctx, w, r.WithContext(ctx)); err != nil {
 			a.log.Printf("%s : Unhandled error %+v", v.TraceID, err)
 			if IsShutdown(err) {
 				a.SignalShutdown()
@@ -104,7 +142,51 @@ func (a *App) Handle(method, pattern string, h Handler, mw ...Middleware) {
 	a.mux.MethodFunc(method, pattern, fn)
 }
 
+// SetClock sets the clock the start time of every request is read from.
+func (a *App) SetClock(c clock.Clock) {
+	a.clock = c
+}
+
+// Now returns the time the current request started, read from the App's
+// clock. Handlers use it instead of time.Now so the whole request sees one
+// consistent time which tests can control.
+func Now(ctx context.Context) time.Time {
+	if v, ok := ctx.Value(KeyValues).(*Values); ok {
+		return v.Start
+	}
+	return time.Now()
+}
+
+// SetJSONOptions sets how null and empty values are rendered in the JSON
+// responses of every route.
+func (a *App) SetJSONOptions(opts JSONOptions) {
+	a.json = opts
+}
+
+// SetJSONFastPath turns the hand written encoders of Appender values on or
+// off while keeping the other JSON options.
+func (a *App) SetJSONFastPath(on bool) {
+	a.json.FastPath = on
+}
+
+// SetTenantLocales sets how the default locale of a tenant is found. It is
+// the language of validation messages for requests which do not ask for one.
+func (a *App) SetTenantLocales(f LocaleFunc) {
+	a.locale = f
+}
+
+// SetPathPrefix serves the App under prefix, such as /sales, when it is
+// mounted in a larger program. Routes are handled without the prefix and
+// requests outside of it are not found.
+func (a *App) SetPathPrefix(prefix string) {
+	a.prefix = strings.TrimSuffix(prefix, "/")
+}
+
 func (a *App) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if a.prefix != "" {
+		http.StripPrefix(a.prefix, a.och).ServeHTTP(w, r)
+		return
+	}
 	a.och.ServeHTTP(w, r)
 }
 
@@ -0,0 +1,88 @@
+package product
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// ReverseSale reverses the paid Sale whose payment the provider reports as
+// disputed and records the Chargeback taking its amount back from the seller.
+// When restock is true the units of the Sale go back into stock.
+//
+// Each dispute is processed once. Reporting one which was already recorded
+// has no effect and changed is false so webhooks may be delivered more than
+// once.
+func ReverseSale(ctx context.Context, db *sqlx.DB, nc NewChargeback, restock bool, now time.Time) (s *Sale, changed bool, err error) {
+	if nc.ID == "" || nc.PaymentID == "" {
+		return nil, false, ErrSaleNotFound
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	var sale Sale
+	const qSale = `SELECT * FROM sales WHERE payment_id = $1 FOR UPDATE`
+	if err := tx.GetContext(ctx, &sale, qSale, nc.PaymentID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, false, ErrSaleNotFound
+		}
+		return nil, false, errors.Wrap(err, "selecting sale")
+	}
+
+	cb := Chargeback{
+		ID:          nc.ID,
+		SaleID:      sale.ID,
+		Amount:      nc.Amount,
+		Currency:    nc.Currency,
+		Reason:      nc.Reason,
+		Restocked:   restock,
+		DateCreated: now.UTC(),
+	}
+	const qInsert = `
+		INSERT INTO chargebacks
+		(chargeback_id, sale_id, seller_id, amount, currency, reason, restocked, date_created)
+		SELECT $1, $2, user_id, $3, UPPER($4), $5, $6, $7 FROM products WHERE product_id = $8
+		ON CONFLICT (chargeback_id) DO NOTHING`
+	res, err := tx.ExecContext(ctx, qInsert, cb.ID, cb.SaleID, cb.Amount, cb.Currency, cb.Reason, cb.Restocked, cb.DateCreated, sale.ProductID)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "inserting chargeback")
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, false, errors.Wrap(err, "inserting chargeback")
+	} else if n == 0 {
+		return &sale, false, nil
+	}
+
+	if !canTransition(sale.Status, SaleReversed) {
+		return &sale, false, ErrInvalidTransition
+	}
+	sale.Status = SaleReversed
+	sale.DateUpdated = now
+
+	const qUpdate = `UPDATE sales SET "status" = $2, "date_updated" = $3 WHERE sale_id = $1`
+	if _, err := tx.ExecContext(ctx, qUpdate, sale.ID, sale.Status, sale.DateUpdated); err != nil {
+		return nil, false, errors.Wrap(err, "updating sale status")
+	}
+
+	// Reversed sales still count as sold so their units are given back by
+	// adding to the stock of the product.
+	if restock {
+		const qStock = `UPDATE products SET quantity = quantity + $2 WHERE product_id = $1`
+		if _, err := tx.ExecContext(ctx, qStock, sale.ProductID, sale.Quantity); err != nil {
+			return nil, false, errors.Wrap(err, "restocking product")
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, false, errors.Wrap(err, "committing chargeback")
+	}
+
+	return &sale, true, nil
+}
@@ -0,0 +1,86 @@
+package product
+
+import (
+	"context"
+	"strings"
+	"unicode"
+
+	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/arammikayelyan/garagesale/internal/platform/database"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// Kinds of completions.
+const (
+	CompleteProduct  = "product"
+	CompleteCategory = "category"
+)
+
+// Completion is a suggestion of what someone typing into a search box may be
+// looking for.
+type Completion struct {
+	Text string `json:"text"`
+	Kind string `json:"kind"`
+}
+
+// likeEscaper escapes the characters LIKE patterns treat specially so prefixes
+// match them literally.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// Complete completes the search text typed so far with up to limit product
+// names and categories. The last word is taken as the start of a word, so
+// "red ch" suggests "Red Chair". Category suggestions come first, then the
+// names of the best selling matching products.
+func Complete(ctx context.Context, db *sqlx.DB, text string, limit int) ([]Completion, error) {
+	list := []Completion{}
+
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) == 0 || limit <= 0 {
+		return list, nil
+	}
+
+	var categories []string
+	tenant := auth.TenantScope(ctx)
+	qCategories := `
+		SELECT category FROM products
+		WHERE NOT hidden AND category ILIKE $1 || '%' AND ` + database.InTenant("tenant_id", 3) + `
+		GROUP BY category
+		ORDER BY COUNT(*) DESC, category
+		LIMIT $2`
+	prefix := likeEscaper.Replace(strings.TrimSpace(text))
+	if err := db.SelectContext(ctx, &categories, qCategories, prefix, limit, tenant); err != nil {
+		return nil, errors.Wrap(err, "selecting category completions")
+	}
+	for _, c := range categories {
+		list = append(list, Completion{Text: c, Kind: CompleteCategory})
+	}
+	if len(list) >= limit {
+		return list, nil
+	}
+
+	// The words only hold letters and digits so they can not break out of
+	// the query syntax.
+	query := strings.Join(words, " & ") + ":*"
+
+	var names []string
+	qNames := `
+		SELECT p.name
+		FROM products AS p, to_tsquery('english', $1) AS query
+		WHERE NOT p.hidden AND ` + database.InTenant("p.tenant_id", 3) + `
+			AND product_document(p.name, p.description, p.category) @@ query
+			AND to_tsvector('english', p.name) @@ query
+		GROUP BY p.name
+		ORDER BY SUM(p.sold) DESC, p.name
+		LIMIT $2`
+	if err := db.SelectContext(ctx, &names, qNames, query, limit-len(list), tenant); err != nil {
+		return nil, errors.Wrap(err, "selecting product completions")
+	}
+	for _, n := range names {
+		list = append(list, Completion{Text: n, Kind: CompleteProduct})
+	}
+
+	return list, nil
+}
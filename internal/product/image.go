@@ -0,0 +1,249 @@
+package product
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"image"
+	"io"
+	"time"
+
+	// Register the formats images may be uploaded in.
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/arammikayelyan/garagesale/internal/platform/blob"
+	"github.com/arammikayelyan/garagesale/internal/platform/database"
+	"github.com/arammikayelyan/garagesale/internal/platform/imagehash"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// Predefined errors for images.
+var (
+	ErrImageNotFound    = errors.New("image not found")
+	ErrUnsupportedImage = errors.New("image must be a JPEG, PNG or GIF")
+)
+
+// AddImage stores a picture of a Product. Only admins and the owner of the
+// Product may add pictures to it.
+func AddImage(ctx context.Context, db *sqlx.DB, store blob.Store, user auth.Claims, productID string, data []byte, now time.Time) (*Image, error) {
+	p, err := Retrieve(ctx, db, productID)
+	if err != nil {
+		return nil, err
+	}
+	if err := authorize(ctx, user, auth.ActionUpdate, p); err != nil {
+		return nil, err
+	}
+
+	decoded, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedImage
+	}
+
+	img := Image{
+		ID:          uuid.New().String(),
+		ProductID:   productID,
+		Key:         imageKey(data),
+		ContentType: "image/" + format,
+		Width:       decoded.Bounds().Dx(),
+		Height:      decoded.Bounds().Dy(),
+		Hash:        int64(imagehash.PHash(decoded)),
+		DateCreated: now.UTC(),
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	// Only the first upload of a file stores it. Uploads of the same file
+	// wait here until the first one is committed.
+	const qBlob = `
+		INSERT INTO image_blobs (blob_key, size, date_created)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (blob_key) DO NOTHING`
+	res, err := tx.ExecContext(ctx, qBlob, img.Key, len(data), img.DateCreated)
+	if err != nil {
+		return nil, errors.Wrap(err, "inserting image blob")
+	}
+	stored := false
+	if n, err := res.RowsAffected(); err == nil && n == 1 {
+		if err := store.Put(ctx, img.Key, bytes.NewReader(data)); err != nil {
+			return nil, errors.Wrap(err, "storing image")
+		}
+		stored = true
+	}
+
+	const q = `
+		INSERT INTO product_images
+		(image_id, product_id, blob_key, content_type, width, height, hash, date_created)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+	_, err = tx.ExecContext(ctx, q, img.ID, img.ProductID, img.Key, img.ContentType, img.Width, img.Height, img.Hash, img.DateCreated)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		if stored {
+			store.Delete(ctx, img.Key)
+		}
+		return nil, errors.Wrap(err, "inserting image")
+	}
+
+	return &img, nil
+}
+
+// imageKey is the key of the blob holding data. Images are stored by the
+// hash of their contents so identical files are stored once.
+func imageKey(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "images/" + hex.EncodeToString(sum[:])
+}
+
+// DeleteImage removes a picture of a Product. Its contents are deleted once
+// no product uses them anymore. Only admins and the owner of the Product may
+// remove its pictures.
+func DeleteImage(ctx context.Context, db *sqlx.DB, store blob.Store, user auth.Claims, productID, imageID string) error {
+	p, err := Retrieve(ctx, db, productID)
+	if err != nil {
+		return err
+	}
+	if err := authorize(ctx, user, auth.ActionUpdate, p); err != nil {
+		return err
+	}
+	if _, err := uuid.Parse(imageID); err != nil {
+		return ErrImageNotFound
+	}
+
+	var key string
+	const q = `DELETE FROM product_images WHERE product_id = $1 AND image_id = $2 RETURNING blob_key`
+	if err := db.GetContext(ctx, &key, q, productID, imageID); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrImageNotFound
+		}
+		return errors.Wrap(err, "deleting image")
+	}
+
+	return pruneImage(ctx, db, store, key)
+}
+
+// PruneImages deletes the contents of every picture no product uses anymore,
+// such as those of deleted products.
+func PruneImages(ctx context.Context, db *sqlx.DB, store blob.Store) error {
+	var keys []string
+	const q = `SELECT blob_key FROM image_blobs WHERE refs = 0`
+	if err := db.SelectContext(ctx, &keys, q); err != nil {
+		return errors.Wrap(err, "selecting unused images")
+	}
+
+	for _, key := range keys {
+		if err := pruneImage(ctx, db, store, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pruneImage deletes the blob under key if no picture refers to it. The blob
+// is deleted while its row is locked so a concurrent upload of the same file
+// stores it again rather than relying on it.
+func pruneImage(ctx context.Context, db *sqlx.DB, store blob.Store, key string) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	const q = `DELETE FROM image_blobs WHERE blob_key = $1 AND refs = 0`
+	res, err := tx.ExecContext(ctx, q, key)
+	if err != nil {
+		return errors.Wrap(err, "deleting image blob")
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return nil
+	}
+
+	if err := store.Delete(ctx, key); err != nil && err != blob.ErrNotFound {
+		return errors.Wrap(err, "deleting image contents")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing transaction")
+	}
+	return nil
+}
+
+// ListImages gives the pictures of a Product in the order they were added.
+func ListImages(ctx context.Context, db *sqlx.DB, productID string) ([]Image, error) {
+	if _, err := Retrieve(ctx, db, productID); err != nil {
+		return nil, err
+	}
+
+	list := []Image{}
+	q := `
+		SELECT i.* FROM product_images AS i
+		JOIN products AS p ON p.product_id = i.product_id
+		WHERE i.product_id = $1 AND ` + database.InTenant("p.tenant_id", 2) + `
+		ORDER BY i.date_created, i.image_id`
+	if err := db.SelectContext(ctx, &list, q, productID, auth.TenantScope(ctx)); err != nil {
+		return nil, errors.Wrap(err, "selecting images")
+	}
+
+	return list, nil
+}
+
+// OpenImage gives a picture of a Product along with its contents, which the
+// caller must close.
+func OpenImage(ctx context.Context, db *sqlx.DB, store blob.Store, productID, imageID string) (*Image, io.ReadCloser, error) {
+	if _, err := uuid.Parse(imageID); err != nil {
+		return nil, nil, ErrImageNotFound
+	}
+
+	var img Image
+	q := `
+		SELECT i.* FROM product_images AS i
+		JOIN products AS p ON p.product_id = i.product_id
+		WHERE i.product_id = $1 AND i.image_id = $2 AND ` + database.InTenant("p.tenant_id", 3)
+	if err := db.GetContext(ctx, &img, q, productID, imageID, auth.TenantScope(ctx)); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil, ErrImageNotFound
+		}
+		return nil, nil, errors.Wrap(err, "selecting image")
+	}
+
+	rc, err := store.Open(ctx, img.Key)
+	if err != nil {
+		if err == blob.ErrNotFound {
+			return nil, nil, ErrImageNotFound
+		}
+		return nil, nil, errors.Wrap(err, "opening image")
+	}
+
+	return &img, rc, nil
+}
+
+// ImageMatches finds the images of products of other sellers whose hash is
+// within maxDistance bits of the hash of img, closest first. Reusing the
+// pictures of someone else's listing is a common sign of a scam.
+func ImageMatches(ctx context.Context, db *sqlx.DB, img Image, maxDistance int) ([]ImageMatch, error) {
+	list := []ImageMatch{}
+	const q = `
+		SELECT i.image_id, i.product_id, p.user_id, m.distance
+		FROM product_images AS i
+		JOIN products AS p ON p.product_id = i.product_id,
+		LATERAL (SELECT length(replace((i.hash # $1)::bit(64)::text, '0', '')) AS distance) AS m
+		WHERE p.user_id <> (SELECT user_id FROM products WHERE product_id = $2)
+			AND m.distance <= $3
+		ORDER BY m.distance, i.date_created`
+	if err := db.SelectContext(ctx, &list, q, img.Hash, img.ProductID, maxDistance); err != nil {
+		return nil, errors.Wrap(err, "selecting matching images")
+	}
+
+	return list, nil
+}
@@ -0,0 +1,79 @@
+package product
+
+import (
+	"context"
+	"time"
+
+	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// Watch adds a Product to the watch list of user. Watching a product twice
+// is not an error; it reports whether user was not watching it yet.
+func Watch(ctx context.Context, db *sqlx.DB, user auth.Claims, productID string, now time.Time) (bool, error) {
+	if _, err := Retrieve(ctx, db, productID); err != nil {
+		return false, err
+	}
+
+	const q = `
+		INSERT INTO product_watches (product_id, user_id, date_created)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING`
+	res, err := db.ExecContext(ctx, q, productID, user.Subject, now.UTC())
+	if err != nil {
+		return false, errors.Wrap(err, "watching product")
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.Wrap(err, "counting watches")
+	}
+	return n > 0, nil
+}
+
+// Unwatch removes a Product from the watch list of user.
+func Unwatch(ctx context.Context, db *sqlx.DB, user auth.Claims, productID string) error {
+	if _, err := Retrieve(ctx, db, productID); err != nil {
+		return err
+	}
+
+	const q = `DELETE FROM product_watches WHERE product_id = $1 AND user_id = $2`
+	if _, err := db.ExecContext(ctx, q, productID, user.Subject); err != nil {
+		return errors.Wrap(err, "unwatching product")
+	}
+	return nil
+}
+
+// RecordView counts a view of a Product. Views are counted per hour.
+func RecordView(ctx context.Context, db *sqlx.DB, productID string, now time.Time) error {
+	const q = `
+		INSERT INTO product_views (product_id, hour, views)
+		VALUES ($1, $2, 1)
+		ON CONFLICT (product_id, hour) DO UPDATE SET views = product_views.views + 1`
+	if _, err := db.ExecContext(ctx, q, productID, now.UTC().Truncate(time.Hour)); err != nil {
+		return errors.Wrap(err, "recording view")
+	}
+	return nil
+}
+
+// RetrieveInterest gives the Interest in the Products identified by ids as of
+// now. Views are counted over the last 24 hours.
+func RetrieveInterest(ctx context.Context, db *sqlx.DB, ids []string, now time.Time) ([]Interest, error) {
+	list := []Interest{}
+	const q = `
+		SELECT p.product_id, COALESCE(u.tenant_id::TEXT, '') AS tenant_id,
+			(SELECT COUNT(*) FROM product_watches AS w WHERE w.product_id = p.product_id) AS watchers,
+			(SELECT COALESCE(SUM(v.views), 0) FROM product_views AS v
+				WHERE v.product_id = p.product_id AND v.hour >= $2) AS views,
+			(SELECT COUNT(DISTINCT COALESCE(s.buyer_id::TEXT, s.sale_id::TEXT)) FROM sales AS s
+				WHERE s.product_id = p.product_id AND s.status = 'pending') AS in_cart
+		FROM products AS p
+		LEFT JOIN users AS u ON u.user_id = p.user_id
+		WHERE p.product_id = ANY($1)`
+	since := now.UTC().Add(-24 * time.Hour).Truncate(time.Hour)
+	if err := db.SelectContext(ctx, &list, q, pq.Array(ids), since); err != nil {
+		return nil, errors.Wrap(err, "selecting product interest")
+	}
+	return list, nil
+}
@@ -1,25 +1,38 @@
 package product
 
-import "time"
+import (
+	"time"
 
-// Product is something we sell
+	"github.com/lib/pq"
+)
+
+// Product is something we sell. Language is set when the Name and
+// Description were replaced by a translation and names its language.
 type Product struct {
-	ID          string    `db:"product_id" json:"id"`
-	Name        string    `db:"name" json:"name"`
-	Cost        int       `db:"cost" json:"cost"`
-	Quantity    int       `db:"quantity" json:"quantity"`
-	Sold        int       `db:"sold" json:"sold"`
-	Revenue     int       `db:"revenue" json:"revenue"`
-	UserID      string    `db:"user_id" json:"user_id"`
-	DateCreated time.Time `db:"date_created" json:"date_created"`
-	DateUpdated time.Time `db:"date_updated" json:"date_updated"`
+	ID          string         `db:"product_id" json:"id"`
+	Name        string         `db:"name" json:"name"`
+	Description string         `db:"description" json:"description"`
+	Language    string         `db:"-" json:"language,omitempty"`
+	Interest    *Interest      `db:"-" json:"interest,omitempty"`
+	Category    string         `db:"category" json:"category"`
+	Tags        pq.StringArray `db:"tags" json:"tags"`
+	Cost        int            `db:"cost" json:"cost"`
+	Quantity    int            `db:"quantity" json:"quantity"`
+	Sold        int            `db:"sold" json:"sold"`
+	Revenue     int            `db:"revenue" json:"revenue"`
+	UserID      string         `db:"user_id" json:"user_id"`
+	DateCreated time.Time      `db:"date_created" json:"date_created"`
+	DateUpdated time.Time      `db:"date_updated" json:"date_updated"`
 }
 
 // NewProduct is something we sell
 type NewProduct struct {
-	Name     string `json:"name" validate:"required"`
-	Cost     int    `json:"cost" validate:"gte=0"`
-	Quantity int    `json:"quantity" validate:"gte=1"`
+	Name        string   `json:"name" validate:"required"`
+	Description string   `json:"description"`
+	Category    string   `json:"category"`
+	Tags        []string `json:"tags"`
+	Cost        int      `json:"cost" validate:"gte=0"`
+	Quantity    int      `json:"quantity" validate:"gte=1"`
 }
 
 // UpdateProduct defines what information may be provided to modify an
@@ -29,25 +42,239 @@ type NewProduct struct {
 // explicitly blank. Normally we do not want to use pointers to basic types but
 // we make exceptions around marshalling/unmarshalling.
 type UpdateProduct struct {
-	Name     *string `json:"name"`
-	Cost     *int    `json:"cost" validate:"omitempty,gte=0"`
-	Quantity *int    `json:"quantity" validate:"omitempty,gte=1"`
+	Name        *string   `json:"name"`
+	Description *string   `json:"description"`
+	Category    *string   `json:"category"`
+	Tags        *[]string `json:"tags"`
+	Cost        *int      `json:"cost" validate:"omitempty,gte=0"`
+	Quantity    *int      `json:"quantity" validate:"omitempty,gte=1"`
+}
+
+// SaleStatus is the state of a Sale.
+type SaleStatus string
+
+// These are the states a Sale moves through.
+const (
+	SalePending   SaleStatus = "pending"
+	SalePaid      SaleStatus = "paid"
+	SaleCancelled SaleStatus = "cancelled"
+	SaleReversed  SaleStatus = "reversed"
+)
+
+// SaleStatuses lists every SaleStatus.
+var SaleStatuses = []SaleStatus{SalePending, SalePaid, SaleCancelled, SaleReversed}
+
+// Valid reports whether s is one of the known states.
+func (s SaleStatus) Valid() bool {
+	switch s {
+	case SalePending, SalePaid, SaleCancelled, SaleReversed:
+		return true
+	}
+	return false
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler so unknown states are
+// rejected when decoding.
+func (s *SaleStatus) UnmarshalText(text []byte) error {
+	status, err := ParseSaleStatus(string(text))
+	if err != nil {
+		return err
+	}
+	*s = status
+	return nil
+}
+
+// ParseSaleStatus converts v to a SaleStatus, failing when it is not a known
+// state.
+func ParseSaleStatus(v string) (SaleStatus, error) {
+	s := SaleStatus(v)
+	if !s.Valid() {
+		return "", ErrInvalidStatus
+	}
+	return s, nil
+}
+
+// DefaultCurrency is the currency of sales recorded without one.
+const DefaultCurrency = "USD"
+
+// Availability is the stock of a Product. Reserved units belong to pending
+// sales whose payment is still being collected.
+type Availability struct {
+	ProductID string `db:"product_id" json:"product_id"`
+	Quantity  int    `db:"quantity" json:"quantity"`
+	Sold      int    `db:"sold" json:"sold"`
+	Reserved  int    `db:"reserved" json:"reserved"`
+	Available int    `db:"available" json:"available"`
 }
 
 // Sale represents one item of a transaction where some amount of a
 // product was sold. Quantity is the number of units sold and Paid is the
 // total price paid. Note that due to haggling the Paid value might not
 // equal Quantity sold * Product cost
+//
+// A Sale starts out pending while its payment is collected or paid when it
+// was recorded after the fact. Pending and paid sales hold their units of
+// stock; cancelling a sale releases them. A paid sale whose payment the buyer
+// disputed is reversed.
 type Sale struct {
-	ID          string    `db:"sale_id" json:"id"`
-	ProductID   string    `db:"product_id" json:"product_id"`
-	Quantity    int       `db:"quantity" json:"quantity"`
-	Paid        int       `db:"paid" json:"paid"`
+	ID             string     `db:"sale_id" json:"id"`
+	ProductID      string     `db:"product_id" json:"product_id"`
+	Quantity       int        `db:"quantity" json:"quantity"`
+	Paid           int        `db:"paid" json:"paid"`
+	IdempotencyKey *string    `db:"idempotency_key" json:"-"`
+	CouponID       *string    `db:"coupon_id" json:"coupon_id,omitempty"`
+	Discount       int        `db:"discount" json:"discount"`
+	Currency       string     `db:"currency" json:"currency"`
+	Status         SaleStatus `db:"status" json:"status"`
+	BuyerID        *string    `db:"buyer_id" json:"buyer_id"`
+	BuyerName      *string    `db:"buyer_name" json:"buyer_name"`
+	BuyerEmail     *string    `db:"buyer_email" json:"buyer_email"`
+	TenantID       *string    `db:"tenant_id" json:"-"`
+	PaymentID      *string    `db:"payment_id" json:"-"`
+	DateCreated    time.Time  `db:"date_created" json:"date_created"`
+	DateUpdated    time.Time  `db:"date_updated" json:"date_updated"`
+}
+
+// NewChargeback is a dispute the payment provider reports about the payment
+// of a Sale. ID is the provider's id of the dispute.
+type NewChargeback struct {
+	ID        string
+	PaymentID string
+	Amount    int
+	Currency  string
+	Reason    string
+}
+
+// Chargeback records a Sale reversed by a dispute. Amount is taken back from
+// the payout balance of the seller. Restocked tells whether the units of the
+// Sale went back into stock.
+type Chargeback struct {
+	ID          string    `db:"chargeback_id" json:"id"`
+	SaleID      string    `db:"sale_id" json:"sale_id"`
+	SellerID    string    `db:"seller_id" json:"seller_id"`
+	Amount      int       `db:"amount" json:"amount"`
+	Currency    string    `db:"currency" json:"currency"`
+	Reason      string    `db:"reason" json:"reason"`
+	Restocked   bool      `db:"restocked" json:"restocked"`
 	DateCreated time.Time `db:"date_created" json:"date_created"`
 }
 
+// SaleDetail is a Sale along with information about the Product it sold.
+type SaleDetail struct {
+	Sale
+	ProductName string `db:"product_name" json:"product_name"`
+}
+
 // NewSale is what we require from clients for recording new transaction.
+// When a Coupon code is provided its discount is taken off Paid and recorded
+// on the Sale. The buyer fields are optional and let the seller get in touch
+// with the buyer; BuyerID identifies a registered buyer. Currency is the ISO
+// 4217 code Paid is in and defaults to DefaultCurrency.
 type NewSale struct {
-	Quantity int `json:"quantity"`
-	Paid     int `json:"paid"`
+	Quantity   int     `json:"quantity" validate:"gt=0"`
+	Paid       int     `json:"paid" validate:"gte=0"`
+	Currency   string  `json:"currency" validate:"omitempty,len=3,alpha"`
+	Coupon     string  `json:"coupon"`
+	BuyerID    *string `json:"buyer_id" validate:"omitempty,uuid"`
+	BuyerName  *string `json:"buyer_name"`
+	BuyerEmail *string `json:"buyer_email" validate:"omitempty,email"`
+}
+
+// SaleUpdate defines what information may be provided to correct an existing
+// Sale. All fields are optional so clients can send just the fields they want
+// changed.
+type SaleUpdate struct {
+	Quantity *int `json:"quantity" validate:"omitempty,gte=1"`
+	Paid     *int `json:"paid" validate:"omitempty,gte=0"`
+}
+
+// SaleFilter narrows down a listing of sales. Fields left at their zero value
+// are not applied. From is inclusive and To is exclusive. BuyerEmail matches
+// regardless of case. After continues a listing past the sale with that ID,
+// which stays correct when sales are added while paging unlike Offset. Past
+// does the same from the key of the last sale of a page without looking the
+// sale up. Limit and Offset select a page of the matching sales.
+type SaleFilter struct {
+	From       time.Time
+	To         time.Time
+	Status     SaleStatus
+	BuyerID    string
+	BuyerEmail string
+	After      string
+	Past       *Key
+	Limit      int
+	Offset     int
+}
+
+// Key is the position of a row in a listing ordered by creation date then ID,
+// as kept in page cursors. The next page starts past it.
+type Key struct {
+	Date time.Time `json:"date"`
+	ID   string    `json:"id"`
+}
+
+// Translation is the name and description of a Product in another language.
+type Translation struct {
+	ProductID   string    `db:"product_id" json:"product_id"`
+	Language    string    `db:"language" json:"language"`
+	Name        string    `db:"name" json:"name"`
+	Description string    `db:"description" json:"description"`
+	DateUpdated time.Time `db:"date_updated" json:"date_updated"`
+}
+
+// UpdateTranslation is what we require from clients to translate a Product.
+type UpdateTranslation struct {
+	Name        string `json:"name" validate:"required"`
+	Description string `json:"description"`
+}
+
+// Suggestion is content proposed for a Product by an enrichment service. The
+// seller decides which parts of it to accept.
+type Suggestion struct {
+	ProductID   string         `db:"product_id" json:"product_id"`
+	Category    string         `db:"category" json:"category"`
+	Tags        pq.StringArray `db:"tags" json:"tags"`
+	Description string         `db:"description" json:"description"`
+	DateCreated time.Time      `db:"date_created" json:"date_created"`
+}
+
+// SuggestionAccept selects which parts of a Suggestion to apply to the
+// Product. An empty list accepts every part.
+type SuggestionAccept struct {
+	Fields []string `json:"fields" validate:"dive,oneof=category tags description"`
+}
+
+// Image is a picture of a Product. Its contents are kept in blob storage
+// under Key. Hash is its perceptual hash, used to find listings showing the
+// same picture.
+type Image struct {
+	ID          string    `db:"image_id" json:"id"`
+	ProductID   string    `db:"product_id" json:"product_id"`
+	Key         string    `db:"blob_key" json:"-"`
+	ContentType string    `db:"content_type" json:"content_type"`
+	Width       int       `db:"width" json:"width"`
+	Height      int       `db:"height" json:"height"`
+	Hash        int64     `db:"hash" json:"-"`
+	DateCreated time.Time `db:"date_created" json:"date_created"`
+}
+
+// ImageMatch is an image of another seller's Product looking like an image
+// being checked. Distance is the number of bits their hashes differ by.
+type ImageMatch struct {
+	ImageID   string `db:"image_id" json:"image_id"`
+	ProductID string `db:"product_id" json:"product_id"`
+	UserID    string `db:"user_id" json:"user_id"`
+	Distance  int    `db:"distance" json:"distance"`
+}
+
+// Interest is how much attention a Product gets: how many users watch it, how
+// many times it was viewed over the last day and how many buyers have a
+// pending sale of it. TenantID is the tenant of its seller, whose settings
+// decide whether it is shown.
+type Interest struct {
+	ProductID string `db:"product_id" json:"-"`
+	TenantID  string `db:"tenant_id" json:"-"`
+	Watchers  int    `db:"watchers" json:"watchers"`
+	Views     int    `db:"views" json:"views_24h"`
+	InCart    int    `db:"in_cart" json:"in_cart"`
 }
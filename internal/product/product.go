@@ -6,8 +6,10 @@ import (
 	"time"
 
 	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/arammikayelyan/garagesale/internal/platform/database"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/pkg/errors"
 )
 
@@ -16,31 +18,118 @@ var (
 	ErrNotFound  = errors.New("product not found")
 	ErrInvalidID = errors.New("id provided was not a valid UUID")
 	ErrForbidden = errors.New("attempted action is not allowed")
+
+	ErrSaleNotFound      = errors.New("sale not found")
+	ErrInsufficientStock = errors.New("not enough stock available")
+	ErrKeyReused         = errors.New("idempotency key was already used for a different sale")
+	ErrInvalidTransition = errors.New("sale can not move to the requested status")
+	ErrInvalidStatus     = errors.New("status must be one of pending, paid, cancelled or reversed")
 )
 
-// List gets all the Products from the DB
-func List(ctx context.Context, db *sqlx.DB) ([]Product, error) {
+// ctxKey represents the type of context key.
+type ctxKey int
 
-	list := []Product{}
+// keySummed marks a context whose product queries sum the sales.
+const keySummed ctxKey = 1
 
-	const q = `
-		SELECT 
-			p.product_id, p.name, p.cost, p.quantity, 
+// WithSummedSales gives a copy of ctx under which product queries sum the
+// sales for sold and revenue instead of reading the counters kept on each
+// product row by a trigger on sales. The counters are always maintained, in
+// the same transaction as the sale; this only selects how they are read.
+// Summing is kept to check the counters against.
+func WithSummedSales(ctx context.Context) context.Context {
+	return context.WithValue(ctx, keySummed, true)
+}
+
+// Queries reading products start with one of these depending on whether the
+// context sums the sales.
+// The summed form must be followed by a GROUP BY p.product_id.
+const (
+	selectSummed = `
+		SELECT
+			p.product_id, p.name, p.description, p.category, p.tags, p.cost, p.quantity, p.user_id,
 			COALESCE(SUM(s.quantity), 0) AS sold,
-			COALESCE(SUM(s.paid), 0) AS revenue,
-			p.date_created, p.date_updated 
+			COALESCE(SUM(s.paid) FILTER (WHERE s.status = 'paid'), 0) AS revenue,
+			p.date_created, p.date_updated
 		FROM products AS p
-		LEFT JOIN sales AS s ON p.product_id = s.product_id
-		GROUP BY p.product_id
-	`
+		LEFT JOIN sales AS s ON p.product_id = s.product_id AND s.status <> 'cancelled'`
+
+	selectCounted = `
+		SELECT
+			p.product_id, p.name, p.description, p.category, p.tags, p.cost, p.quantity, p.user_id,
+			p.sold, p.revenue,
+			p.date_created, p.date_updated
+		FROM products AS p`
+)
 
-	if err := db.SelectContext(ctx, &list, q); err != nil {
+// selectProducts builds a query reading products which match where.
+func selectProducts(ctx context.Context, where string) string {
+	if summed, _ := ctx.Value(keySummed).(bool); summed {
+		return selectSummed + "\n" + where + "\nGROUP BY p.product_id"
+	}
+	return selectCounted + "\n" + where
+}
+
+// List gets all the Products from the DB. Products of deactivated sellers
+// which were hidden are left out.
+func List(ctx context.Context, db *sqlx.DB) ([]Product, error) {
+
+	list := []Product{}
+
+	q := selectProducts(ctx, "WHERE NOT p.hidden AND "+database.InTenant("p.tenant_id", 1))
+
+	if err := db.SelectContext(ctx, &list, q, auth.TenantScope(ctx)); err != nil {
 		return nil, err
 	}
 
 	return list, nil
 }
 
+// ListPage gets a page of Products ordered by when they were created along
+// with the number of Products there are in total. Hidden Products are left
+// out as in List. When past is not nil the page starts after the Product at
+// that key instead of at offset.
+func ListPage(ctx context.Context, db *sqlx.DB, limit, offset int, past *Key) ([]Product, int, error) {
+	tenant := auth.TenantScope(ctx)
+
+	var total int
+	qCount := `SELECT COUNT(*) FROM products WHERE NOT hidden AND ` + database.InTenant("tenant_id", 1)
+	if err := db.GetContext(ctx, &total, qCount, tenant); err != nil {
+		return nil, 0, errors.Wrap(err, "counting products")
+	}
+
+	list := []Product{}
+
+	where := "WHERE NOT p.hidden AND " + database.InTenant("p.tenant_id", 3)
+	args := []interface{}{limit, offset, tenant}
+	if past != nil {
+		args = append(args, past.Date.UTC(), past.ID)
+		where += " AND (p.date_created, p.product_id) > ($4, $5)"
+	}
+	q := selectProducts(ctx, where) + "\nORDER BY p.date_created, p.product_id LIMIT $1 OFFSET $2"
+
+	if err := db.SelectContext(ctx, &list, q, args...); err != nil {
+		return nil, 0, errors.Wrap(err, "selecting products")
+	}
+
+	return list, total, nil
+}
+
+// ListSince gets the Products updated after since. Deleted products are not
+// reported.
+func ListSince(ctx context.Context, db *sqlx.DB, since time.Time) ([]Product, error) {
+
+	list := []Product{}
+
+	q := selectProducts(ctx, "WHERE p.date_updated > $1 AND "+database.InTenant("p.tenant_id", 2))
+
+	if err := db.SelectContext(ctx, &list, q, since.UTC(), auth.TenantScope(ctx)); err != nil {
+		return nil, errors.Wrap(err, "selecting updated products")
+	}
+
+	return list, nil
+}
+
 // Retrieve gets a single Product from the DB
 func Retrieve(ctx context.Context, db *sqlx.DB, id string) (*Product, error) {
 	if _, err := uuid.Parse(id); err != nil {
@@ -49,19 +138,9 @@ func Retrieve(ctx context.Context, db *sqlx.DB, id string) (*Product, error) {
 
 	var p Product
 
-	const q = `
-		SELECT 
-			p.product_id, p.name, p.cost, p.quantity, 
-			COALESCE(SUM(s.quantity), 0) AS sold,
-			COALESCE(SUM(s.paid), 0) AS revenue,
-			p.date_created, p.date_updated 
-		FROM products AS p
-		LEFT JOIN sales AS s ON p.product_id = s.product_id
-		WHERE p.product_id = $1
-		GROUP BY p.product_id
-	`
+	q := selectProducts(ctx, "WHERE p.product_id = $1 AND "+database.InTenant("p.tenant_id", 2))
 
-	if err := db.GetContext(ctx, &p, q, id); err != nil {
+	if err := db.GetContext(ctx, &p, q, id, auth.TenantScope(ctx)); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, ErrNotFound
 		}
@@ -71,11 +150,44 @@ func Retrieve(ctx context.Context, db *sqlx.DB, id string) (*Product, error) {
 	return &p, nil
 }
 
-// Create makes a new Product
+// RetrieveAvailability gets the stock of a single Product without the rest of
+// its details.
+func RetrieveAvailability(ctx context.Context, db *sqlx.DB, id string) (*Availability, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	var a Availability
+
+	q := `
+		SELECT
+			p.product_id, p.quantity,
+			COALESCE(SUM(s.quantity) FILTER (WHERE s.status = 'paid'), 0) AS sold,
+			COALESCE(SUM(s.quantity) FILTER (WHERE s.status = 'pending'), 0) AS reserved,
+			p.quantity - COALESCE(SUM(s.quantity), 0) AS available
+		FROM products AS p
+		LEFT JOIN sales AS s ON p.product_id = s.product_id AND s.status <> 'cancelled'
+		WHERE p.product_id = $1 AND ` + database.InTenant("p.tenant_id", 2) + `
+		GROUP BY p.product_id`
+
+	if err := db.GetContext(ctx, &a, q, id, auth.TenantScope(ctx)); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrapf(err, "selecting availability of product %q", id)
+	}
+
+	return &a, nil
+}
+
+// Create makes a new Product in the tenant of user.
 func Create(ctx context.Context, db *sqlx.DB, user auth.Claims, np NewProduct, now time.Time) (*Product, error) {
 	p := Product{
 		ID:          uuid.New().String(),
 		Name:        np.Name,
+		Description: np.Description,
+		Category:    np.Category,
+		Tags:        append(pq.StringArray{}, np.Tags...),
 		Cost:        np.Cost,
 		Quantity:    np.Quantity,
 		UserID:      user.Subject,
@@ -85,10 +197,10 @@ func Create(ctx context.Context, db *sqlx.DB, user auth.Claims, np NewProduct, n
 
 	const q = `
 		INSERT INTO products 
-		(product_id, name, cost, quantity, user_id, date_created, date_updated)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+		(product_id, name, description, category, tags, cost, quantity, user_id, tenant_id, date_created, date_updated)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, '')::UUID, $10, $11)`
 
-	if _, err := db.ExecContext(ctx, q, p.ID, p.Name, p.Cost, p.Quantity, p.UserID, p.DateCreated, p.DateUpdated); err != nil {
+	if _, err := db.ExecContext(ctx, q, p.ID, p.Name, p.Description, p.Category, p.Tags, p.Cost, p.Quantity, p.UserID, user.TenantID, p.DateCreated, p.DateUpdated); err != nil {
 		return nil, errors.Wrapf(err, "inserting product: %v", np)
 	}
 
@@ -103,16 +215,22 @@ func Update(ctx context.Context, db *sqlx.DB, user auth.Claims, id string, updat
 		return err
 	}
 
-	// If you do not have the admin role...
-	// and you are not the owner of this product...
-	// then get outta here!
-	if !user.HasRole(auth.RoleAdmin) && p.UserID != user.Subject {
-		return ErrForbidden
+	if err := authorize(ctx, user, auth.ActionUpdate, p); err != nil {
+		return err
 	}
 
 	if update.Name != nil {
 		p.Name = *update.Name
 	}
+	if update.Description != nil {
+		p.Description = *update.Description
+	}
+	if update.Category != nil {
+		p.Category = *update.Category
+	}
+	if update.Tags != nil {
+		p.Tags = pq.StringArray(*update.Tags)
+	}
 	if update.Cost != nil {
 		p.Cost = *update.Cost
 	}
@@ -123,13 +241,16 @@ func Update(ctx context.Context, db *sqlx.DB, user auth.Claims, id string, updat
 
 	const q = `UPDATE products SET
 		"name" = $2,
-		"cost" = $3,
-		"quantity" = $4,
-		"date_updated" = $5
+		"description" = $3,
+		"category" = $4,
+		"tags" = $5,
+		"cost" = $6,
+		"quantity" = $7,
+		"date_updated" = $8
 		WHERE product_id = $1`
 	_, err = db.ExecContext(ctx, q, id,
-		p.Name, p.Cost,
-		p.Quantity, p.DateUpdated,
+		p.Name, p.Description, p.Category, p.Tags,
+		p.Cost, p.Quantity, p.DateUpdated,
 	)
 	if err != nil {
 		return errors.Wrap(err, "updating product")
@@ -144,11 +265,44 @@ func Delete(ctx context.Context, db *sqlx.DB, id string) error {
 		return ErrInvalidID
 	}
 
-	const q = `DELETE FROM products WHERE product_id = $1`
-	_, err := db.ExecContext(ctx, q, id)
+	q := `DELETE FROM products WHERE product_id = $1 AND ` + database.InTenant("tenant_id", 2)
+	_, err := db.ExecContext(ctx, q, id, auth.TenantScope(ctx))
 	if err != nil {
 		return errors.Wrapf(err, "deleting product %s", id)
 	}
 
 	return nil
 }
+
+// RetrieveMany gets the Products identified by ids in a single query. IDs that
+// do not match an existing Product are silently skipped so the result may be
+// shorter than the input.
+func RetrieveMany(ctx context.Context, db *sqlx.DB, ids []string) ([]Product, error) {
+	for _, id := range ids {
+		if _, err := uuid.Parse(id); err != nil {
+			return nil, ErrInvalidID
+		}
+	}
+
+	list := []Product{}
+
+	q := selectProducts(ctx, "WHERE p.product_id = ANY($1) AND "+database.InTenant("p.tenant_id", 2))
+
+	if err := db.SelectContext(ctx, &list, q, pq.Array(ids), auth.TenantScope(ctx)); err != nil {
+		return nil, errors.Wrap(err, "selecting products")
+	}
+
+	return list, nil
+}
+
+// authorize checks the authorization policy lets user do action to p.
+func authorize(ctx context.Context, user auth.Claims, action auth.Action, p *Product) error {
+	ok, err := user.Can(ctx, action, auth.Resource{Kind: "product", ID: p.ID, Owner: p.UserID})
+	if err != nil {
+		return errors.Wrap(err, "authorizing")
+	}
+	if !ok {
+		return ErrForbidden
+	}
+	return nil
+}
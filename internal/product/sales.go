@@ -2,43 +2,505 @@ package product
 
 import (
 	"context"
+	"database/sql"
+	"fmt"
+	"strings"
 	"time"
 
+	"github.com/arammikayelyan/garagesale/internal/coupon"
+	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/arammikayelyan/garagesale/internal/platform/database"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
 )
 
-// AddSale records a sales transaction for a single Product
-func AddSale(ctx context.Context, db *sqlx.DB, ns NewSale, productID string, now time.Time) (*Sale, error) {
-	s := Sale{
+// saleTransitions lists the states a Sale in each state may move to.
+var saleTransitions = map[SaleStatus][]SaleStatus{
+	SalePending: {SalePaid, SaleCancelled},
+	SalePaid:    {SaleCancelled, SaleReversed},
+}
+
+// canTransition reports whether a Sale may move from one state to another.
+func canTransition(from, to SaleStatus) bool {
+	for _, s := range saleTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// AddSale records a paid sales transaction for a single Product. It will error
+// if the quantity sold exceeds the stock still available. A coupon in the
+// NewSale is redeemed as part of the same transaction and errors with the
+// reason from the coupon package when it can not be applied.
+//
+// When idempotencyKey is not empty a retried request with the same key does
+// not record the sale again; the original sale is returned instead and created
+// is false.
+func AddSale(ctx context.Context, db *sqlx.DB, ns NewSale, productID, idempotencyKey string, now time.Time) (s *Sale, created bool, err error) {
+	return addSale(ctx, db, ns, productID, idempotencyKey, SalePaid, now)
+}
+
+// ReserveSale records a pending Sale whose payment is still being collected.
+// Its units are held until the Sale is paid or cancelled. It behaves like
+// AddSale otherwise.
+func ReserveSale(ctx context.Context, db *sqlx.DB, ns NewSale, productID, idempotencyKey string, now time.Time) (s *Sale, created bool, err error) {
+	return addSale(ctx, db, ns, productID, idempotencyKey, SalePending, now)
+}
+
+// addSale records a Sale in the provided state.
+func addSale(ctx context.Context, db *sqlx.DB, ns NewSale, productID, idempotencyKey string, status SaleStatus, now time.Time) (s *Sale, created bool, err error) {
+	if _, err := uuid.Parse(productID); err != nil {
+		return nil, false, ErrInvalidID
+	}
+
+	sale := Sale{
 		ID:          uuid.New().String(),
 		ProductID:   productID,
 		Quantity:    ns.Quantity,
 		Paid:        ns.Paid,
+		Currency:    strings.ToUpper(ns.Currency),
+		Status:      status,
+		BuyerID:     ns.BuyerID,
+		BuyerName:   ns.BuyerName,
+		BuyerEmail:  ns.BuyerEmail,
 		DateCreated: now,
+		DateUpdated: now,
+	}
+	if sale.Currency == "" {
+		sale.Currency = DefaultCurrency
+	}
+	if idempotencyKey != "" {
+		sale.IdempotencyKey = &idempotencyKey
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	// The product row is locked from here on which also serializes retries
+	// racing each other with the same key.
+	available, err := lockStock(ctx, tx, productID, "")
+	if err != nil {
+		return nil, false, err
+	}
+
+	if idempotencyKey != "" {
+		var prev Sale
+		const q = `SELECT * FROM sales WHERE product_id = $1 AND idempotency_key = $2`
+		err := tx.GetContext(ctx, &prev, q, productID, idempotencyKey)
+		switch {
+		case err == nil:
+			if prev.Quantity != ns.Quantity || prev.Paid+prev.Discount != ns.Paid {
+				return nil, false, ErrKeyReused
+			}
+			return &prev, false, nil
+		case err != sql.ErrNoRows:
+			return nil, false, errors.Wrap(err, "looking up idempotency key")
+		}
+	}
+
+	if sale.Quantity > available {
+		return nil, false, ErrInsufficientStock
 	}
 
+	if ns.Coupon != "" {
+		couponID, discount, err := coupon.Redeem(ctx, tx, ns.Coupon, productID, ns.Paid, now)
+		if err != nil {
+			return nil, false, err
+		}
+		sale.CouponID = &couponID
+		sale.Discount = discount
+		sale.Paid -= discount
+	}
+
+	// Sales belong to the tenant of their product.
 	const q = `INSERT INTO sales
-		(sale_id, product_id, quantity, paid, date_created)
-		VALUES ($1, $2, $3, $4, $5)`
+		(sale_id, product_id, quantity, paid, idempotency_key, coupon_id, discount, currency, status,
+		buyer_id, buyer_name, buyer_email, tenant_id, date_created, date_updated)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12,
+		(SELECT tenant_id FROM products WHERE product_id = $2), $13, $14)`
 
-	_, err := db.ExecContext(ctx, q, s.ID, s.ProductID, s.Quantity, s.Paid, s.DateCreated)
+	_, err = tx.ExecContext(ctx, q, sale.ID, sale.ProductID, sale.Quantity, sale.Paid, sale.IdempotencyKey, sale.CouponID, sale.Discount, sale.Currency, sale.Status,
+		sale.BuyerID, sale.BuyerName, sale.BuyerEmail, sale.DateCreated, sale.DateUpdated)
 	if err != nil {
-		return nil, errors.Wrap(err, "inserting sale")
+		return nil, false, errors.Wrap(err, "inserting sale")
 	}
 
-	return &s, nil
+	if err := tx.Commit(); err != nil {
+		return nil, false, errors.Wrap(err, "committing sale")
+	}
+
+	return &sale, true, nil
+}
+
+// lockStock locks the row of a Product for the rest of the transaction so
+// concurrent sales can not oversell it, and returns how many units remain
+// unsold. Cancelled sales and the sale identified by exceptSaleID, if any, are
+// not counted. Products of other tenants are not found.
+func lockStock(ctx context.Context, tx *sqlx.Tx, productID, exceptSaleID string) (int, error) {
+	var stock int
+	qStock := `SELECT quantity FROM products WHERE product_id = $1 AND ` + database.InTenant("tenant_id", 2) + ` FOR UPDATE`
+	if err := tx.GetContext(ctx, &stock, qStock, productID, auth.TenantScope(ctx)); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, ErrNotFound
+		}
+		return 0, errors.Wrap(err, "locking product")
+	}
+
+	var except interface{}
+	if exceptSaleID != "" {
+		except = exceptSaleID
+	}
+
+	var sold int
+	const qSold = `
+		SELECT COALESCE(SUM(quantity), 0) FROM sales
+		WHERE product_id = $1 AND status <> 'cancelled'
+		AND ($2::UUID IS NULL OR sale_id <> $2)`
+	if err := tx.GetContext(ctx, &sold, qSold, productID, except); err != nil {
+		return 0, errors.Wrap(err, "summing sales")
+	}
+
+	return stock - sold, nil
 }
 
-// ListSales gives all Sales for a Product
-func ListSales(ctx context.Context, db *sqlx.DB, productID string) ([]Sale, error) {
+// ListSales gives all Sales for a Product which match the filter, oldest
+// first.
+func ListSales(ctx context.Context, db *sqlx.DB, productID string, filter SaleFilter) ([]Sale, error) {
+	if err := filter.checkAfter(ctx, db); err != nil {
+		return nil, err
+	}
+
 	sales := []Sale{}
 
-	const q = `SELECT * FROM sales WHERE product_id = $1`
-	if err := db.SelectContext(ctx, &sales, q, productID); err != nil {
+	q := `SELECT s.* FROM sales AS s WHERE s.product_id = $1 AND ` + database.InTenant("s.tenant_id", 2)
+	args := []interface{}{productID, auth.TenantScope(ctx)}
+	q += filter.where(&args)
+	q += " ORDER BY s.date_created, s.sale_id"
+	q += filter.page(&args)
+
+	if err := db.SelectContext(ctx, &sales, q, args...); err != nil {
+		return nil, errors.Wrap(err, "selecting sales")
+	}
+
+	return sales, nil
+}
+
+// ListAllSales gives the Sales of every Product which match the filter, oldest
+// first, along with the name of the Product. If sellerID is not empty only
+// sales of products owned by that user are included.
+func ListAllSales(ctx context.Context, db *sqlx.DB, sellerID string, filter SaleFilter) ([]SaleDetail, error) {
+	if err := filter.checkAfter(ctx, db); err != nil {
+		return nil, err
+	}
+
+	sales := []SaleDetail{}
+
+	q := `
+		SELECT s.*, p.name AS product_name
+		FROM sales AS s
+		JOIN products AS p ON p.product_id = s.product_id
+		WHERE ` + database.InTenant("s.tenant_id", 1)
+	args := []interface{}{auth.TenantScope(ctx)}
+	if sellerID != "" {
+		args = append(args, sellerID)
+		q += fmt.Sprintf(" AND p.user_id = $%d", len(args))
+	}
+	q += filter.where(&args)
+	q += " ORDER BY s.date_created, s.sale_id"
+	q += filter.page(&args)
+
+	if err := db.SelectContext(ctx, &sales, q, args...); err != nil {
 		return nil, errors.Wrap(err, "selecting sales")
 	}
 
 	return sales, nil
 }
+
+// StreamAllSales calls fn with each Sale of every Product which matches the
+// filter, oldest first, without holding them all in memory. It is otherwise
+// the same as ListAllSales. When fn returns an error streaming stops and the
+// error is returned.
+func StreamAllSales(ctx context.Context, db *sqlx.DB, sellerID string, filter SaleFilter, fn func(SaleDetail) error) error {
+	if err := filter.checkAfter(ctx, db); err != nil {
+		return err
+	}
+
+	q := `
+		SELECT s.*, p.name AS product_name
+		FROM sales AS s
+		JOIN products AS p ON p.product_id = s.product_id
+		WHERE ` + database.InTenant("s.tenant_id", 1)
+	args := []interface{}{auth.TenantScope(ctx)}
+	if sellerID != "" {
+		args = append(args, sellerID)
+		q += fmt.Sprintf(" AND p.user_id = $%d", len(args))
+	}
+	q += filter.where(&args)
+	q += " ORDER BY s.date_created, s.sale_id"
+	q += filter.page(&args)
+
+	rows, err := db.QueryxContext(ctx, q, args...)
+	if err != nil {
+		return errors.Wrap(err, "selecting sales")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s SaleDetail
+		if err := rows.StructScan(&s); err != nil {
+			return errors.Wrap(err, "scanning sale")
+		}
+		if err := fn(s); err != nil {
+			return err
+		}
+	}
+
+	return errors.Wrap(rows.Err(), "reading sales")
+}
+
+// where renders the conditions of the filter against the sales table aliased
+// as s, appending their values to args.
+func (f SaleFilter) where(args *[]interface{}) string {
+	var q string
+	if !f.From.IsZero() {
+		*args = append(*args, f.From.UTC())
+		q += fmt.Sprintf(" AND s.date_created >= $%d", len(*args))
+	}
+	if !f.To.IsZero() {
+		*args = append(*args, f.To.UTC())
+		q += fmt.Sprintf(" AND s.date_created < $%d", len(*args))
+	}
+	if f.Status != "" {
+		*args = append(*args, f.Status)
+		q += fmt.Sprintf(" AND s.status = $%d", len(*args))
+	}
+	if f.BuyerID != "" {
+		*args = append(*args, f.BuyerID)
+		q += fmt.Sprintf(" AND s.buyer_id = $%d", len(*args))
+	}
+	if f.BuyerEmail != "" {
+		*args = append(*args, f.BuyerEmail)
+		q += fmt.Sprintf(" AND LOWER(s.buyer_email) = LOWER($%d)", len(*args))
+	}
+	if f.After != "" {
+		// Sales are listed by date_created then sale_id, so the rows past
+		// a sale are the ones after it in that order.
+		*args = append(*args, f.After)
+		q += fmt.Sprintf(" AND (s.date_created, s.sale_id) > (SELECT a.date_created, a.sale_id FROM sales AS a WHERE a.sale_id = $%d)", len(*args))
+	}
+	if f.Past != nil {
+		*args = append(*args, f.Past.Date.UTC(), f.Past.ID)
+		q += fmt.Sprintf(" AND (s.date_created, s.sale_id) > ($%d, $%d)", len(*args)-1, len(*args))
+	}
+	return q
+}
+
+// checkAfter makes sure the sale a listing continues after exists, as no sale
+// would be listed past an unknown one.
+func (f SaleFilter) checkAfter(ctx context.Context, db *sqlx.DB) error {
+	if f.After == "" {
+		return nil
+	}
+	if _, err := uuid.Parse(f.After); err != nil {
+		return ErrInvalidID
+	}
+
+	var exists bool
+	const q = `SELECT EXISTS (SELECT 1 FROM sales WHERE sale_id = $1)`
+	if err := db.GetContext(ctx, &exists, q, f.After); err != nil {
+		return errors.Wrap(err, "checking sale to list after")
+	}
+	if !exists {
+		return ErrSaleNotFound
+	}
+	return nil
+}
+
+// page renders the LIMIT and OFFSET clauses of the filter, appending their
+// values to args.
+func (f SaleFilter) page(args *[]interface{}) string {
+	var q string
+	if f.Limit > 0 {
+		*args = append(*args, f.Limit)
+		q += fmt.Sprintf(" LIMIT $%d", len(*args))
+	}
+	if f.Offset > 0 {
+		*args = append(*args, f.Offset)
+		q += fmt.Sprintf(" OFFSET $%d", len(*args))
+	}
+	return q
+}
+
+// UpdateSale corrects the quantity or paid amount of an existing Sale. Stock
+// is derived from the recorded sales so the product's availability follows the
+// change. It will error if the new quantity exceeds the stock available.
+func UpdateSale(ctx context.Context, db *sqlx.DB, productID, saleID string, update SaleUpdate, now time.Time) (*Sale, error) {
+	if _, err := uuid.Parse(productID); err != nil {
+		return nil, ErrInvalidID
+	}
+	if _, err := uuid.Parse(saleID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	available, err := lockStock(ctx, tx, productID, saleID)
+	if err != nil {
+		return nil, err
+	}
+
+	var s Sale
+	const qSale = `SELECT * FROM sales WHERE sale_id = $1 AND product_id = $2`
+	if err := tx.GetContext(ctx, &s, qSale, saleID, productID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrSaleNotFound
+		}
+		return nil, errors.Wrap(err, "selecting sale")
+	}
+
+	if update.Quantity != nil {
+		if *update.Quantity > available {
+			return nil, ErrInsufficientStock
+		}
+		s.Quantity = *update.Quantity
+	}
+	if update.Paid != nil {
+		s.Paid = *update.Paid
+	}
+	s.DateUpdated = now
+
+	const q = `UPDATE sales SET
+		"quantity" = $2,
+		"paid" = $3,
+		"date_updated" = $4
+		WHERE sale_id = $1`
+	if _, err := tx.ExecContext(ctx, q, saleID, s.Quantity, s.Paid, s.DateUpdated); err != nil {
+		return nil, errors.Wrap(err, "updating sale")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing sale update")
+	}
+
+	return &s, nil
+}
+
+// DeleteSale removes a Sale recorded for a Product. The units of the sale
+// become available again.
+func DeleteSale(ctx context.Context, db *sqlx.DB, productID, saleID string) error {
+	if _, err := uuid.Parse(productID); err != nil {
+		return ErrInvalidID
+	}
+	if _, err := uuid.Parse(saleID); err != nil {
+		return ErrInvalidID
+	}
+
+	q := `DELETE FROM sales WHERE sale_id = $1 AND product_id = $2 AND ` + database.InTenant("tenant_id", 3)
+	res, err := db.ExecContext(ctx, q, saleID, productID, auth.TenantScope(ctx))
+	if err != nil {
+		return errors.Wrapf(err, "deleting sale %s", saleID)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "checking deleted rows")
+	}
+	if n == 0 {
+		return ErrSaleNotFound
+	}
+
+	return nil
+}
+
+// MarkSalePaid moves a pending Sale to paid recording the amount collected
+// and the provider's id of the payment. Marking a Sale which is already paid
+// has no effect and changed is false so payment notifications may be
+// delivered more than once.
+func MarkSalePaid(ctx context.Context, db *sqlx.DB, saleID, paymentID string, paid int, now time.Time) (s *Sale, changed bool, err error) {
+	change := func(s *Sale) {
+		s.Paid = paid
+		if paymentID != "" {
+			s.PaymentID = &paymentID
+		}
+	}
+	s, err = transitionSale(ctx, db, "", saleID, SalePaid, change, now)
+	if err == ErrInvalidTransition && s.Status == SalePaid {
+		return s, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return s, true, nil
+}
+
+// CancelSale cancels a pending or paid Sale of a Product releasing its units
+// back into stock. Refunding a paid Sale is left to the caller.
+func CancelSale(ctx context.Context, db *sqlx.DB, productID, saleID string, now time.Time) (*Sale, error) {
+	if _, err := uuid.Parse(productID); err != nil {
+		return nil, ErrInvalidID
+	}
+	return transitionSale(ctx, db, productID, saleID, SaleCancelled, nil, now)
+}
+
+// transitionSale moves a Sale to the status to, applying change to it first
+// when provided. When productID is not empty the Sale must belong to that
+// Product. On ErrInvalidTransition the Sale is returned unchanged.
+func transitionSale(ctx context.Context, db *sqlx.DB, productID, saleID string, to SaleStatus, change func(*Sale), now time.Time) (*Sale, error) {
+	if _, err := uuid.Parse(saleID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	var s Sale
+	qSale := `SELECT * FROM sales WHERE sale_id = $1 AND ` + database.InTenant("tenant_id", 2) + ` FOR UPDATE`
+	if err := tx.GetContext(ctx, &s, qSale, saleID, auth.TenantScope(ctx)); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrSaleNotFound
+		}
+		return nil, errors.Wrap(err, "selecting sale")
+	}
+	if productID != "" && s.ProductID != productID {
+		return nil, ErrSaleNotFound
+	}
+
+	if !canTransition(s.Status, to) {
+		return &s, ErrInvalidTransition
+	}
+
+	if change != nil {
+		change(&s)
+	}
+	s.Status = to
+	s.DateUpdated = now
+
+	const q = `UPDATE sales SET
+		"status" = $2,
+		"paid" = $3,
+		"date_updated" = $4,
+		"payment_id" = $5
+		WHERE sale_id = $1`
+	if _, err := tx.ExecContext(ctx, q, saleID, s.Status, s.Paid, s.DateUpdated, s.PaymentID); err != nil {
+		return nil, errors.Wrap(err, "updating sale status")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing sale status")
+	}
+
+	return &s, nil
+}
@@ -0,0 +1,215 @@
+package product
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/arammikayelyan/garagesale/internal/platform/database"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// ErrEmptySearch is returned when a search has no text to look for.
+var ErrEmptySearch = errors.New("search text is required")
+
+// PriceBuckets are the bounds of the price ranges search results are counted
+// in, in the smallest unit of the currency. Each range includes its lower
+// bound and excludes its upper one.
+var PriceBuckets = []int{1000, 2500, 5000, 10000, 25000}
+
+// SearchQuery is what to search products for. Text is matched against the
+// name, description and category of products in the manner of web search
+// engines, supporting quoted phrases, or and -word. Zero filters are not
+// applied.
+type SearchQuery struct {
+	Text     string
+	Category string
+	MinCost  int
+	MaxCost  int
+	Limit    int
+	Offset   int
+
+	// tenant is the scope of the search set by Search.
+	tenant interface{}
+}
+
+// SearchResult is a page of the products matching a search, best matches
+// first, along with the number of matches and how they are spread over
+// categories and prices.
+type SearchResult struct {
+	Products []Hit  `json:"products"`
+	Total    int    `json:"total"`
+	Facets   Facets `json:"facets"`
+
+	// DidYouMean is a correction of misspelled search text. Corrected
+	// reports that the results are those of the correction.
+	DidYouMean string `json:"did_you_mean,omitempty"`
+	Corrected  bool   `json:"corrected,omitempty"`
+}
+
+// Hit is a product matching a search. Highlights hold its name and the
+// passages of its description which matched with the matching words wrapped
+// in <mark> tags. They are HTML escaped otherwise.
+type Hit struct {
+	Product
+	Highlights Highlights `json:"highlights"`
+}
+
+// Highlights are the parts of a product matching a search.
+type Highlights struct {
+	Name        string `db:"name_highlight" json:"name"`
+	Description string `db:"description_highlight" json:"description"`
+}
+
+// Facets count the matches of a search by category and price. Each facet
+// ignores its own filter so the counts show what choosing another value
+// would give.
+type Facets struct {
+	Categories []CategoryCount `json:"categories"`
+	Prices     []PriceCount    `json:"prices"`
+}
+
+// CategoryCount is how many matches are in a category.
+type CategoryCount struct {
+	Category string `db:"category" json:"category"`
+	Count    int    `db:"count" json:"count"`
+}
+
+// PriceCount is how many matches cost at least Min and less than Max. The
+// most expensive range has no Max.
+type PriceCount struct {
+	Min   int  `json:"min"`
+	Max   *int `json:"max,omitempty"`
+	Count int  `json:"count"`
+}
+
+// searchDocument is the text search document of the product aliased as p. It
+// must match the expression of the products_search_idx index.
+const searchDocument = `product_document(p.name, p.description, p.category)`
+
+// escapeHTML escapes a text column for inclusion in HTML.
+func escapeHTML(column string) string {
+	return fmt.Sprintf(`replace(replace(replace(COALESCE(%s, ''), '&', '&amp;'), '<', '&lt;'), '>', '&gt;')`, column)
+}
+
+// Search finds the products matching sq. Hidden products are left out.
+func Search(ctx context.Context, db *sqlx.DB, sq SearchQuery) (*SearchResult, error) {
+	if strings.TrimSpace(sq.Text) == "" {
+		return nil, ErrEmptySearch
+	}
+
+	sq.tenant = auth.TenantScope(ctx)
+	res := SearchResult{Products: []Hit{}}
+
+	args := []interface{}{sq.Text}
+	q := `SELECT COUNT(*) FROM products AS p, websearch_to_tsquery('english', $1) AS query ` + sq.where(&args, true, true)
+	if err := db.GetContext(ctx, &res.Total, q, args...); err != nil {
+		return nil, errors.Wrap(err, "counting matches")
+	}
+
+	var hits []struct {
+		ID string `db:"product_id"`
+		Highlights
+	}
+	args = []interface{}{sq.Text}
+	q = `
+		SELECT p.product_id,
+			ts_headline('english', ` + escapeHTML("p.name") + `, query,
+				'HighlightAll=true, StartSel=<mark>, StopSel=</mark>') AS name_highlight,
+			ts_headline('english', ` + escapeHTML("p.description") + `, query,
+				'MaxFragments=2, MaxWords=20, MinWords=5, StartSel=<mark>, StopSel=</mark>') AS description_highlight
+		FROM products AS p, websearch_to_tsquery('english', $1) AS query ` + sq.where(&args, true, true)
+	args = append(args, sq.Limit, sq.Offset)
+	q += fmt.Sprintf(" ORDER BY ts_rank(%s, query) DESC, p.date_created, p.product_id LIMIT $%d OFFSET $%d", searchDocument, len(args)-1, len(args))
+	if err := db.SelectContext(ctx, &hits, q, args...); err != nil {
+		return nil, errors.Wrap(err, "selecting matches")
+	}
+
+	ids := make([]string, len(hits))
+	for i, h := range hits {
+		ids[i] = h.ID
+	}
+	list, err := RetrieveMany(ctx, db, ids)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]Product, len(list))
+	for _, p := range list {
+		byID[p.ID] = p
+	}
+	for _, h := range hits {
+		if p, ok := byID[h.ID]; ok {
+			res.Products = append(res.Products, Hit{Product: p, Highlights: h.Highlights})
+		}
+	}
+
+	res.Facets.Categories = []CategoryCount{}
+	args = []interface{}{sq.Text}
+	q = `
+		SELECT p.category, COUNT(*) AS count
+		FROM products AS p, websearch_to_tsquery('english', $1) AS query ` + sq.where(&args, false, true) + `
+			AND COALESCE(p.category, '') <> ''
+		GROUP BY p.category
+		ORDER BY count DESC, p.category`
+	if err := db.SelectContext(ctx, &res.Facets.Categories, q, args...); err != nil {
+		return nil, errors.Wrap(err, "counting matches by category")
+	}
+
+	var buckets []struct {
+		Bucket int `db:"bucket"`
+		Count  int `db:"count"`
+	}
+	args = []interface{}{sq.Text}
+	q = `
+		SELECT width_bucket(p.cost, $2::INT[]) AS bucket, COUNT(*) AS count
+		FROM products AS p, websearch_to_tsquery('english', $1) AS query `
+	args = append(args, pq.Array(PriceBuckets))
+	q += sq.where(&args, true, false) + ` GROUP BY bucket`
+	if err := db.SelectContext(ctx, &buckets, q, args...); err != nil {
+		return nil, errors.Wrap(err, "counting matches by price")
+	}
+
+	res.Facets.Prices = make([]PriceCount, len(PriceBuckets)+1)
+	for i := range res.Facets.Prices {
+		pc := &res.Facets.Prices[i]
+		if i > 0 {
+			pc.Min = PriceBuckets[i-1]
+		}
+		if i < len(PriceBuckets) {
+			max := PriceBuckets[i]
+			pc.Max = &max
+		}
+	}
+	for _, b := range buckets {
+		if b.Bucket >= 0 && b.Bucket < len(res.Facets.Prices) {
+			res.Facets.Prices[b.Bucket].Count = b.Count
+		}
+	}
+
+	return &res, nil
+}
+
+// where renders the conditions of a search against the products table
+// aliased as p and the query aliased as query, appending their values to
+// args. The category and price filters are only applied when asked for so
+// facets can leave their own out.
+func (sq SearchQuery) where(args *[]interface{}, category, price bool) string {
+	*args = append(*args, sq.tenant)
+	q := "WHERE NOT p.hidden AND " + searchDocument + " @@ query AND " + database.InTenant("p.tenant_id", len(*args))
+	if category && sq.Category != "" {
+		*args = append(*args, sq.Category)
+		q += fmt.Sprintf(" AND p.category = $%d", len(*args))
+	}
+	if price && sq.MinCost > 0 {
+		*args = append(*args, sq.MinCost)
+		q += fmt.Sprintf(" AND p.cost >= $%d", len(*args))
+	}
+	if price && sq.MaxCost > 0 {
+		*args = append(*args, sq.MaxCost)
+		q += fmt.Sprintf(" AND p.cost < $%d", len(*args))
+	}
+	return q
+}
@@ -0,0 +1,172 @@
+package product
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/arammikayelyan/garagesale/internal/platform/database"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// Speller corrects misspelled search text against the words of product names
+// and categories. The words are read from the database when first needed
+// and again once they are older than a time to live. Each tenant has words
+// of its own products only. It is safe for concurrent use.
+type Speller struct {
+	db  *sqlx.DB
+	ttl time.Duration
+
+	mu    sync.Mutex
+	vocab map[string]vocabulary
+}
+
+// vocabulary is the known words of a tenant with the number of products
+// using them.
+type vocabulary struct {
+	words  map[string]int
+	loaded time.Time
+}
+
+// NewSpeller constructs a Speller whose words are reread after ttl.
+func NewSpeller(db *sqlx.DB, ttl time.Duration) *Speller {
+	return &Speller{db: db, ttl: ttl, vocab: make(map[string]vocabulary)}
+}
+
+// Correct returns text with each word which is not known replaced by the
+// closest known one, favouring words used by more products. It reports
+// false when no word was replaced.
+func (s *Speller) Correct(ctx context.Context, text string) (string, bool, error) {
+	words, err := s.vocabulary(ctx)
+	if err != nil {
+		return "", false, err
+	}
+
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	var changed bool
+	for i, f := range fields {
+		if _, ok := words[f]; ok {
+			continue
+		}
+		if c := closest(f, words); c != "" {
+			fields[i] = c
+			changed = true
+		}
+	}
+	if !changed {
+		return "", false, nil
+	}
+
+	return strings.Join(fields, " "), true, nil
+}
+
+// vocabulary returns the known words of the tenant of the caller with the
+// number of products using them, rereading them when they are stale.
+func (s *Speller) vocabulary(ctx context.Context) (map[string]int, error) {
+	scope := auth.TenantScope(ctx)
+	key, _ := scope.(string)
+	if scope == nil {
+		key = "*"
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if v, ok := s.vocab[key]; ok && time.Since(v.loaded) < s.ttl {
+		return v.words, nil
+	}
+
+	var rows []struct {
+		Word string `db:"word"`
+		Docs int    `db:"ndoc"`
+	}
+	q := `
+		SELECT word, COUNT(*) AS ndoc FROM (
+			SELECT unnest(tsvector_to_array(to_tsvector('simple', COALESCE(name, '') || ' ' || COALESCE(category, '')))) AS word
+			FROM products WHERE NOT hidden AND ` + database.InTenant("tenant_id", 1) + `
+		) AS w
+		GROUP BY word`
+	if err := s.db.SelectContext(ctx, &rows, q, scope); err != nil {
+		return nil, errors.Wrap(err, "selecting search vocabulary")
+	}
+
+	words := make(map[string]int, len(rows))
+	for _, r := range rows {
+		words[r.Word] = r.Docs
+	}
+	s.vocab[key] = vocabulary{words: words, loaded: time.Now()}
+
+	return words, nil
+}
+
+// closest finds the known word nearest to word. Short words may be one edit
+// away and longer ones two. It returns "" when none is close enough.
+func closest(word string, words map[string]int) string {
+	w := []rune(word)
+	max := 1
+	if len(w) > 4 {
+		max = 2
+	}
+
+	var best string
+	bestDist, bestDocs := max+1, 0
+	for candidate, docs := range words {
+		c := []rune(candidate)
+		if diff := len(c) - len(w); diff > max || -diff > max {
+			continue
+		}
+		d := distance(w, c)
+		if d < bestDist || d == bestDist && (docs > bestDocs || docs == bestDocs && candidate < best) {
+			best, bestDist, bestDocs = candidate, d, docs
+		}
+	}
+	if bestDist > max {
+		return ""
+	}
+	return best
+}
+
+// distance is the number of insertions, deletions, substitutions and
+// transpositions of adjacent letters turning a into b.
+func distance(a, b []rune) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = smallest(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = smallest(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+
+	return d[len(a)][len(b)]
+}
+
+// smallest returns the smallest of its arguments.
+func smallest(n int, rest ...int) int {
+	for _, m := range rest {
+		if m < n {
+			n = m
+		}
+	}
+	return n
+}
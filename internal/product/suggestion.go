@@ -0,0 +1,107 @@
+package product
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// ErrNoSuggestion is returned when a Product has no pending Suggestion.
+var ErrNoSuggestion = errors.New("product has no suggestion")
+
+// SaveSuggestion stores a Suggestion for a Product replacing any earlier one.
+func SaveSuggestion(ctx context.Context, db *sqlx.DB, s Suggestion) error {
+	if s.Tags == nil {
+		s.Tags = pq.StringArray{}
+	}
+
+	const q = `
+		INSERT INTO product_suggestions
+		(product_id, category, tags, description, date_created)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (product_id) DO UPDATE SET
+			category = EXCLUDED.category,
+			tags = EXCLUDED.tags,
+			description = EXCLUDED.description,
+			date_created = EXCLUDED.date_created`
+	if _, err := db.ExecContext(ctx, q, s.ProductID, s.Category, s.Tags, s.Description, s.DateCreated.UTC()); err != nil {
+		return errors.Wrap(err, "saving suggestion")
+	}
+
+	return nil
+}
+
+// RetrieveSuggestion gets the pending Suggestion for a Product. Only admins
+// and the owner of the Product may see it.
+func RetrieveSuggestion(ctx context.Context, db *sqlx.DB, user auth.Claims, productID string) (*Suggestion, error) {
+	p, err := Retrieve(ctx, db, productID)
+	if err != nil {
+		return nil, err
+	}
+	if err := authorize(ctx, user, auth.ActionRead, p); err != nil {
+		return nil, err
+	}
+
+	var s Suggestion
+	const q = `SELECT * FROM product_suggestions WHERE product_id = $1`
+	if err := db.GetContext(ctx, &s, q, productID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNoSuggestion
+		}
+		return nil, errors.Wrap(err, "selecting suggestion")
+	}
+
+	return &s, nil
+}
+
+// AcceptSuggestion applies the selected parts of the pending Suggestion to the
+// Product and discards the Suggestion.
+func AcceptSuggestion(ctx context.Context, db *sqlx.DB, user auth.Claims, productID string, as SuggestionAccept, now time.Time) error {
+	s, err := RetrieveSuggestion(ctx, db, user, productID)
+	if err != nil {
+		return err
+	}
+
+	fields := as.Fields
+	if len(fields) == 0 {
+		fields = []string{"category", "tags", "description"}
+	}
+
+	var update UpdateProduct
+	for _, f := range fields {
+		switch f {
+		case "category":
+			update.Category = &s.Category
+		case "tags":
+			tags := []string(s.Tags)
+			update.Tags = &tags
+		case "description":
+			update.Description = &s.Description
+		}
+	}
+
+	if err := Update(ctx, db, user, productID, update, now); err != nil {
+		return err
+	}
+
+	return DismissSuggestion(ctx, db, user, productID)
+}
+
+// DismissSuggestion discards the pending Suggestion for a Product.
+func DismissSuggestion(ctx context.Context, db *sqlx.DB, user auth.Claims, productID string) error {
+	if _, err := RetrieveSuggestion(ctx, db, user, productID); err != nil {
+		return err
+	}
+
+	const q = `DELETE FROM product_suggestions WHERE product_id = $1`
+	if _, err := db.ExecContext(ctx, q, productID); err != nil {
+		return errors.Wrap(err, "deleting suggestion")
+	}
+
+	return nil
+}
@@ -0,0 +1,185 @@
+package product
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// Predefined errors for translations.
+var (
+	ErrTranslationNotFound = errors.New("translation not found")
+	ErrInvalidLanguage     = errors.New("language must be a language tag such as en or hy-AM")
+)
+
+// languageTag loosely matches BCP 47 language tags.
+var languageTag = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})*$`)
+
+// normalizeLanguage lower cases a language tag and checks its format.
+func normalizeLanguage(lang string) (string, error) {
+	lang = strings.ToLower(strings.TrimSpace(lang))
+	if !languageTag.MatchString(lang) {
+		return "", ErrInvalidLanguage
+	}
+	return lang, nil
+}
+
+// ListTranslations gives every translation of a Product.
+func ListTranslations(ctx context.Context, db *sqlx.DB, productID string) ([]Translation, error) {
+	if _, err := Retrieve(ctx, db, productID); err != nil {
+		return nil, err
+	}
+
+	list := []Translation{}
+	const q = `
+		SELECT product_id, language, name, description, date_updated
+		FROM product_translations
+		WHERE product_id = $1
+		ORDER BY language`
+	if err := db.SelectContext(ctx, &list, q, productID); err != nil {
+		return nil, errors.Wrap(err, "selecting translations")
+	}
+
+	return list, nil
+}
+
+// SetTranslation adds or replaces the translation of a Product into lang.
+// Only admins and the owner of the Product may translate it.
+func SetTranslation(ctx context.Context, db *sqlx.DB, user auth.Claims, productID, lang string, ut UpdateTranslation, now time.Time) (*Translation, error) {
+	lang, err := normalizeLanguage(lang)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := Retrieve(ctx, db, productID)
+	if err != nil {
+		return nil, err
+	}
+	if err := authorize(ctx, user, auth.ActionUpdate, p); err != nil {
+		return nil, err
+	}
+
+	t := Translation{
+		ProductID:   productID,
+		Language:    lang,
+		Name:        ut.Name,
+		Description: ut.Description,
+		DateUpdated: now.UTC(),
+	}
+
+	const q = `
+		INSERT INTO product_translations
+		(product_id, language, name, description, date_updated)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (product_id, language) DO UPDATE SET
+			name = EXCLUDED.name,
+			description = EXCLUDED.description,
+			date_updated = EXCLUDED.date_updated`
+	if _, err := db.ExecContext(ctx, q, t.ProductID, t.Language, t.Name, t.Description, t.DateUpdated); err != nil {
+		return nil, errors.Wrap(err, "saving translation")
+	}
+
+	return &t, nil
+}
+
+// DeleteTranslation removes the translation of a Product into lang. Only
+// admins and the owner of the Product may remove it.
+func DeleteTranslation(ctx context.Context, db *sqlx.DB, user auth.Claims, productID, lang string) error {
+	lang, err := normalizeLanguage(lang)
+	if err != nil {
+		return err
+	}
+
+	p, err := Retrieve(ctx, db, productID)
+	if err != nil {
+		return err
+	}
+	if err := authorize(ctx, user, auth.ActionUpdate, p); err != nil {
+		return err
+	}
+
+	const q = `DELETE FROM product_translations WHERE product_id = $1 AND language = $2`
+	res, err := db.ExecContext(ctx, q, productID, lang)
+	if err != nil {
+		return errors.Wrap(err, "deleting translation")
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrTranslationNotFound
+	}
+
+	return nil
+}
+
+// Localize replaces the name and description of each Product with its best
+// translation for the preferred languages, most preferred first. Products
+// without a matching translation keep their original content.
+func Localize(ctx context.Context, db *sqlx.DB, list []Product, langs []string) error {
+	if len(list) == 0 || len(langs) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(list))
+	for i := range list {
+		if _, err := uuid.Parse(list[i].ID); err != nil {
+			return ErrInvalidID
+		}
+		ids[i] = list[i].ID
+	}
+
+	var translations []Translation
+	const q = `
+		SELECT product_id, language, name, description, date_updated
+		FROM product_translations
+		WHERE product_id = ANY($1)`
+	if err := db.SelectContext(ctx, &translations, q, pq.Array(ids)); err != nil {
+		return errors.Wrap(err, "selecting translations")
+	}
+
+	byProduct := make(map[string]map[string]Translation)
+	for _, t := range translations {
+		if byProduct[t.ProductID] == nil {
+			byProduct[t.ProductID] = make(map[string]Translation)
+		}
+		byProduct[t.ProductID][t.Language] = t
+	}
+
+	for i := range list {
+		available := byProduct[list[i].ID]
+		if t, ok := matchLanguage(available, langs); ok {
+			list[i].Name = t.Name
+			list[i].Description = t.Description
+			list[i].Language = t.Language
+		}
+	}
+
+	return nil
+}
+
+// matchLanguage finds the translation best matching the preferred languages.
+// A preference matches a translation in the same language or, failing that,
+// one in its base language so hy-AM falls back to hy.
+func matchLanguage(available map[string]Translation, langs []string) (Translation, bool) {
+	if len(available) == 0 {
+		return Translation{}, false
+	}
+
+	for _, lang := range langs {
+		if t, ok := available[lang]; ok {
+			return t, true
+		}
+		if i := strings.Index(lang, "-"); i > 0 {
+			if t, ok := available[lang[:i]]; ok {
+				return t, true
+			}
+		}
+	}
+
+	return Translation{}, false
+}
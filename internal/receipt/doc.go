@@ -0,0 +1,4 @@
+// Package receipt produces receipts for recorded sales. A Receipt gathers the
+// details of a sale and a Renderer turns it into a document such as HTML or
+// PDF.
+package receipt
@@ -0,0 +1,30 @@
+package receipt
+
+import "time"
+
+// Receipt holds everything printed on the receipt of a single sale.
+type Receipt struct {
+	SaleID      string    `db:"sale_id" json:"sale_id"`
+	Date        time.Time `db:"date_created" json:"date"`
+	ProductID   string    `db:"product_id" json:"product_id"`
+	ProductName string    `db:"product_name" json:"product_name"`
+	UnitPrice   int       `db:"unit_price" json:"unit_price"`
+	Quantity    int       `db:"quantity" json:"quantity"`
+	Paid        int       `db:"paid" json:"paid"`
+	SellerID    string    `db:"seller_id" json:"seller_id"`
+	SellerName  string    `db:"seller_name" json:"seller_name"`
+	SellerEmail string    `db:"seller_email" json:"seller_email"`
+	BuyerName   *string   `db:"buyer_name" json:"buyer_name"`
+	BuyerEmail  *string   `db:"buyer_email" json:"buyer_email"`
+}
+
+// Subtotal is the list price of the units sold. It may differ from Paid due
+// to haggling.
+func (r Receipt) Subtotal() int {
+	return r.UnitPrice * r.Quantity
+}
+
+// Discount is how much less than the list price was paid.
+func (r Receipt) Discount() int {
+	return r.Subtotal() - r.Paid
+}
@@ -0,0 +1,60 @@
+package receipt
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/arammikayelyan/garagesale/internal/platform/database"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// Predefined errors for known failure scenarios
+var (
+	ErrNotFound  = errors.New("sale not found")
+	ErrInvalidID = errors.New("ID is not in its proper UUID format")
+	ErrForbidden = errors.New("attempted action is not allowed")
+)
+
+// Retrieve gathers the receipt for a sale. Only admins and the seller of the
+// product may see it.
+func Retrieve(ctx context.Context, db *sqlx.DB, user auth.Claims, saleID string) (*Receipt, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.receipt.Retrieve")
+	defer span.End()
+
+	if _, err := uuid.Parse(saleID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	var r Receipt
+	q := `SELECT
+			s.sale_id, s.date_created, s.quantity, s.paid,
+			p.product_id, p.name AS product_name, p.cost AS unit_price,
+			u.user_id AS seller_id, u.name AS seller_name, u.email AS seller_email,
+			COALESCE(s.buyer_name, b.name) AS buyer_name,
+			COALESCE(s.buyer_email, b.email) AS buyer_email
+		FROM sales AS s
+		JOIN products AS p ON p.product_id = s.product_id
+		JOIN users AS u ON u.user_id = p.user_id
+		LEFT JOIN users AS b ON b.user_id = s.buyer_id
+		WHERE s.sale_id = $1 AND ` + database.InTenant("s.tenant_id", 2)
+	if err := db.GetContext(ctx, &r, q, saleID, auth.TenantScope(ctx)); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "selecting receipt")
+	}
+
+	ok, err := user.Can(ctx, auth.ActionRead, auth.Resource{Kind: "sale", ID: r.SaleID, Owner: r.SellerID})
+	if err != nil {
+		return nil, errors.Wrap(err, "authorizing")
+	}
+	if !ok {
+		return nil, ErrForbidden
+	}
+
+	return &r, nil
+}
@@ -0,0 +1,111 @@
+package receipt
+
+import (
+	"context"
+	"io"
+	"strconv"
+
+	"github.com/arammikayelyan/garagesale/internal/platform/pdf"
+	"github.com/arammikayelyan/garagesale/internal/templates"
+	"github.com/pkg/errors"
+)
+
+// Renderer turns a Receipt into a document. Implementations can be swapped to
+// change how receipts are produced without touching the callers.
+type Renderer interface {
+	ContentType() string
+	Render(ctx context.Context, w io.Writer, r Receipt) error
+}
+
+// HTML renders receipts using the receipt template from a template store so
+// the layout can be customized at runtime.
+type HTML struct {
+	Templates *templates.Store
+}
+
+// ContentType implements the Renderer interface.
+func (HTML) ContentType() string {
+	return "text/html; charset=utf-8"
+}
+
+// Render implements the Renderer interface.
+func (h HTML) Render(ctx context.Context, w io.Writer, r Receipt) error {
+	data, err := h.Templates.Render(ctx, templates.Receipt, r)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// PDF renders receipts as a single Letter sized page.
+type PDF struct{}
+
+// ContentType implements the Renderer interface.
+func (PDF) ContentType() string {
+	return "application/pdf"
+}
+
+// Render implements the Renderer interface.
+func (PDF) Render(ctx context.Context, w io.Writer, r Receipt) error {
+	const (
+		left  = 72.0
+		right = pdf.LetterWidth - 72
+	)
+
+	doc := pdf.New(pdf.LetterWidth, pdf.LetterHeight)
+
+	doc.Text(left, 90, pdf.Bold, 22, "Receipt")
+	doc.Text(left, 115, pdf.Regular, 10, "Receipt number: "+r.SaleID)
+	doc.Text(left, 130, pdf.Regular, 10, "Date: "+r.Date.Format("January 2, 2006"))
+
+	doc.Text(left, 165, pdf.Bold, 12, "Sold by")
+	doc.Text(left, 182, pdf.Regular, 11, r.SellerName)
+	doc.Text(left, 197, pdf.Regular, 11, r.SellerEmail)
+
+	if r.BuyerName != nil || r.BuyerEmail != nil {
+		const buyerX = 320.0
+		doc.Text(buyerX, 165, pdf.Bold, 12, "Sold to")
+		y := 182.0
+		for _, v := range []*string{r.BuyerName, r.BuyerEmail} {
+			if v != nil {
+				doc.Text(buyerX, y, pdf.Regular, 11, *v)
+				y += 15
+			}
+		}
+	}
+
+	y := 240.0
+	doc.Text(left, y, pdf.Bold, 11, "Item")
+	amount(doc, 360, y, pdf.Bold, "Unit price")
+	amount(doc, 430, y, pdf.Bold, "Qty")
+	amount(doc, right, y, pdf.Bold, "Amount")
+	doc.Line(left, y+6, right, y+6)
+
+	y += 24
+	doc.Text(left, y, pdf.Regular, 11, r.ProductName)
+	amount(doc, 360, y, pdf.Regular, strconv.Itoa(r.UnitPrice))
+	amount(doc, 430, y, pdf.Regular, strconv.Itoa(r.Quantity))
+	amount(doc, right, y, pdf.Regular, strconv.Itoa(r.Subtotal()))
+	doc.Line(left, y+10, right, y+10)
+
+	y += 30
+	if d := r.Discount(); d > 0 {
+		amount(doc, 430, y, pdf.Regular, "Discount")
+		amount(doc, right, y, pdf.Regular, strconv.Itoa(-d))
+		y += 18
+	}
+	amount(doc, 430, y, pdf.Bold, "Total paid")
+	amount(doc, right, y, pdf.Bold, strconv.Itoa(r.Paid))
+
+	if _, err := doc.WriteTo(w); err != nil {
+		return errors.Wrap(err, "writing receipt")
+	}
+	return nil
+}
+
+// amount draws s right aligned so that it ends at x.
+func amount(doc *pdf.Document, x, y float64, font pdf.Font, s string) {
+	const size = 11
+	doc.Text(x-pdf.TextWidth(size, s), y, font, size, s)
+}
@@ -0,0 +1,5 @@
+// Package recommend suggests products users may want to buy. Suggestions come
+// from a Recommender, by default one ranking products by how well they sell
+// and how much the user showed interest in their category, and the clicks
+// and purchases following them are recorded as feedback to train on.
+package recommend
@@ -0,0 +1,41 @@
+package recommend
+
+import (
+	"context"
+	"time"
+
+	"github.com/arammikayelyan/garagesale/internal/product"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// RecordFeedback stores that the user with the id userID reacted to a product
+// in the way kind says.
+func RecordFeedback(ctx context.Context, db *sqlx.DB, userID, productID, kind string, now time.Time) (*Feedback, error) {
+	if _, err := uuid.Parse(productID); err != nil {
+		return nil, product.ErrInvalidID
+	}
+
+	f := Feedback{
+		ID:          uuid.New().String(),
+		UserID:      userID,
+		ProductID:   productID,
+		Kind:        kind,
+		DateCreated: now.UTC(),
+	}
+
+	const q = `
+		INSERT INTO recommendation_feedback
+		(feedback_id, user_id, product_id, kind, date_created)
+		SELECT $1, $2, product_id, $4, $5 FROM products WHERE product_id = $3`
+	res, err := db.ExecContext(ctx, q, f.ID, f.UserID, f.ProductID, f.Kind, f.DateCreated)
+	if err != nil {
+		return nil, errors.Wrap(err, "inserting feedback")
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return nil, product.ErrNotFound
+	}
+
+	return &f, nil
+}
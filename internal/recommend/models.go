@@ -0,0 +1,36 @@
+package recommend
+
+import (
+	"time"
+
+	"github.com/arammikayelyan/garagesale/internal/product"
+)
+
+// Recommendation is a Product suggested to a user. Higher scores are better
+// matches; they only compare within the recommendations of one request.
+type Recommendation struct {
+	ProductID string           `db:"product_id" json:"product_id"`
+	Score     float64          `db:"score" json:"score"`
+	Product   *product.Product `db:"-" json:"product,omitempty"`
+}
+
+// Kinds of Feedback.
+const (
+	KindClick    = "click"
+	KindPurchase = "purchase"
+)
+
+// Feedback records how a user reacted to a product, such as clicking on a
+// recommendation or buying it.
+type Feedback struct {
+	ID          string    `db:"feedback_id" json:"id"`
+	UserID      string    `db:"user_id" json:"user_id"`
+	ProductID   string    `db:"product_id" json:"product_id"`
+	Kind        string    `db:"kind" json:"kind"`
+	DateCreated time.Time `db:"date_created" json:"date_created"`
+}
+
+// NewClick is what clients send when a user clicks on a recommendation.
+type NewClick struct {
+	ProductID string `json:"product_id" validate:"required,uuid"`
+}
@@ -0,0 +1,153 @@
+package recommend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// Recommender suggests at most limit products to the user with the id
+// userID, best matches first.
+type Recommender interface {
+	Recommend(ctx context.Context, userID string, limit int) ([]Recommendation, error)
+}
+
+// Learner is implemented by Recommenders which are trained on feedback as it
+// comes in rather than reading it from the database.
+type Learner interface {
+	Learn(ctx context.Context, f Feedback) error
+}
+
+// affinityWeight is how much a user only ever interested in one category
+// favors its products over the best sellers. Popularity grows with the
+// logarithm of the sales so it stays within a few points too.
+const affinityWeight = 2
+
+// Popular is the Recommender computed in SQL. Products score by the sales
+// they made over Window and by the share of the feedback of the user which
+// was about products in the same category. Products the user sells or
+// already bought, and hidden ones, are never recommended.
+type Popular struct {
+	DB     *sqlx.DB
+	Window time.Duration
+}
+
+// Recommend implements the Recommender interface. Only products of the
+// tenant of the user are recommended.
+func (p Popular) Recommend(ctx context.Context, userID string, limit int) ([]Recommendation, error) {
+	list := []Recommendation{}
+	const q = `
+		WITH affinity AS (
+			SELECT p.category, COUNT(*)::FLOAT / SUM(COUNT(*)) OVER () AS share
+			FROM recommendation_feedback AS f
+			JOIN products AS p ON p.product_id = f.product_id
+			WHERE f.user_id = $1 AND p.category <> ''
+			GROUP BY p.category
+		), popularity AS (
+			SELECT product_id, SUM(quantity) AS sold
+			FROM sales
+			WHERE status <> 'cancelled' AND date_created >= $2
+			GROUP BY product_id
+		)
+		SELECT p.product_id,
+			LN(1 + COALESCE(pop.sold, 0)) + $3 * COALESCE(a.share, 0) AS score
+		FROM products AS p
+		LEFT JOIN popularity AS pop ON pop.product_id = p.product_id
+		LEFT JOIN affinity AS a ON a.category = p.category
+		WHERE NOT p.hidden AND p.user_id <> $1
+			AND p.tenant_id IS NOT DISTINCT FROM (SELECT tenant_id FROM users WHERE user_id = $1)
+			AND NOT EXISTS (
+				SELECT 1 FROM sales AS s WHERE s.product_id = p.product_id AND s.buyer_id = $1
+			)
+		ORDER BY score DESC, p.date_created DESC
+		LIMIT $4`
+	since := time.Now().Add(-p.Window).UTC()
+	if err := p.DB.SelectContext(ctx, &list, q, userID, since, affinityWeight, limit); err != nil {
+		return nil, errors.Wrap(err, "selecting recommendations")
+	}
+	return list, nil
+}
+
+// HTTP is a Recommender backed by an external service. It posts the user id
+// and limit as JSON and expects a JSON array of objects with the product_id
+// and score fields in return. Feedback is posted to FeedbackURL when set.
+type HTTP struct {
+	URL         string
+	FeedbackURL string
+	Key         string
+	Client      *http.Client
+}
+
+// NewHTTP constructs an HTTP recommender calling url, and feedbackURL with
+// feedback when it is not empty, authenticating with key as a bearer token
+// when it is not empty.
+func NewHTTP(url, feedbackURL, key string) *HTTP {
+	return &HTTP{
+		URL:         url,
+		FeedbackURL: feedbackURL,
+		Key:         key,
+		Client:      &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// Recommend implements the Recommender interface.
+func (h *HTTP) Recommend(ctx context.Context, userID string, limit int) ([]Recommendation, error) {
+	body := struct {
+		UserID string `json:"user_id"`
+		Limit  int    `json:"limit"`
+	}{userID, limit}
+
+	list := []Recommendation{}
+	if err := h.post(ctx, h.URL, body, &list); err != nil {
+		return nil, errors.Wrap(err, "asking for recommendations")
+	}
+	if len(list) > limit {
+		list = list[:limit]
+	}
+	return list, nil
+}
+
+// Learn implements the Learner interface.
+func (h *HTTP) Learn(ctx context.Context, f Feedback) error {
+	if h.FeedbackURL == "" {
+		return nil
+	}
+	return errors.Wrap(h.post(ctx, h.FeedbackURL, f, nil), "sending feedback")
+}
+
+// post sends body as JSON to url and decodes the response into out unless it
+// is nil.
+func (h *HTTP) post(ctx context.Context, url string, body, out interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return errors.Wrap(err, "encoding request")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return errors.Wrap(err, "creating request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.Key != "" {
+		req.Header.Set("Authorization", "Bearer "+h.Key)
+	}
+
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "calling recommendation service")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.Errorf("recommendation service responded %d", resp.StatusCode)
+	}
+	if out == nil {
+		return nil
+	}
+	return errors.Wrap(json.NewDecoder(resp.Body).Decode(out), "decoding response")
+}
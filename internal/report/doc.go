@@ -0,0 +1,2 @@
+// Package report implements read-only business reports computed over sales.
+package report
@@ -0,0 +1,315 @@
+package report
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// ErrJobNotFound is returned when there is no report job with an ID or it
+// belongs to someone else.
+var ErrJobNotFound = errors.New("report job not found")
+
+// ErrMissingFrom is returned when a report job is started without the start
+// of its date range.
+var ErrMissingFrom = errors.New("from is required for report jobs")
+
+// JobStatus is the state of a report job.
+type JobStatus string
+
+// These are the states a report job moves through.
+const (
+	JobRunning JobStatus = "running"
+	JobDone    JobStatus = "done"
+	JobFailed  JobStatus = "failed"
+)
+
+// Job is a revenue report computed in the background. The report is split
+// into one chunk per period of its grouping; Done counts the chunks computed
+// so far and Result holds the report once every chunk is.
+type Job struct {
+	ID           string          `json:"id"`
+	Status       JobStatus       `json:"status"`
+	Chunks       int             `json:"chunks"`
+	Done         int             `json:"chunks_done"`
+	Error        string          `json:"error,omitempty"`
+	Result       []RevenueBucket `json:"result,omitempty"`
+	DateCreated  time.Time       `json:"date_created"`
+	DateFinished *time.Time      `json:"date_finished,omitempty"`
+}
+
+// job is a Job along with what the Runner needs to finish it. Tenant is the
+// scope from auth.TenantScope of whoever started it.
+type job struct {
+	Job
+	owner    string
+	tenant   interface{}
+	key      string
+	groupBy  string
+	currency string
+	parts    [][]RevenueBucket
+}
+
+// task is one chunk of a job.
+type task struct {
+	job    *job
+	index  int
+	filter Filter
+}
+
+// Runner computes large revenue reports on a pool of workers. Finished
+// reports are kept for a while and handed out again to whoever asks for the
+// same report.
+type Runner struct {
+	db      *sqlx.DB
+	workers int
+	ttl     time.Duration
+
+	tasks chan task
+	stop  chan struct{}
+
+	mu   sync.Mutex
+	jobs map[string]*job
+}
+
+// NewRunner constructs a Runner with workers computing chunks in parallel
+// which keeps finished reports for ttl.
+func NewRunner(db *sqlx.DB, workers int, ttl time.Duration) *Runner {
+	if workers < 1 {
+		workers = 1
+	}
+	return &Runner{
+		db:      db,
+		workers: workers,
+		ttl:     ttl,
+		tasks:   make(chan task),
+		stop:    make(chan struct{}),
+		jobs:    make(map[string]*job),
+	}
+}
+
+// Run computes the chunks of started jobs until ctx is cancelled.
+func (r *Runner) Run(ctx context.Context) {
+	defer close(r.stop)
+
+	var wg sync.WaitGroup
+	for i := 0; i < r.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case t := <-r.tasks:
+					r.compute(ctx, t)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Start begins computing the revenue report Revenue would give for owner. A
+// job computing the same report which is still running or finished less
+// than the ttl ago is returned instead of starting another. When filter has
+// no end the report runs up to now. The report only covers the tenant of the
+// caller in ctx.
+func (r *Runner) Start(ctx context.Context, groupBy, currency string, filter Filter, owner string, now time.Time) (Job, error) {
+	switch groupBy {
+	case GroupByDay, GroupByWeek, GroupByMonth:
+	default:
+		return Job{}, ErrInvalidGrouping
+	}
+	currency = strings.ToUpper(currency)
+	if currency != "" && !isCurrency(currency) {
+		return Job{}, ErrInvalidCurrency
+	}
+	if filter.From.IsZero() {
+		return Job{}, ErrMissingFrom
+	}
+	if filter.To.IsZero() {
+		filter.To = now
+	}
+	filter.From, filter.To = filter.From.UTC(), filter.To.UTC()
+
+	tenant := auth.TenantScope(ctx)
+	key := fmt.Sprintf("%s|%s|%s|%s|%s|%v", groupBy, currency, filter.From.Format(time.RFC3339Nano), filter.To.Format(time.RFC3339Nano), filter.UserID, tenant)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.prune(now)
+	for _, j := range r.jobs {
+		if j.key == key && j.owner == owner && j.Status != JobFailed {
+			return j.Job, nil
+		}
+	}
+
+	chunks := split(groupBy, filter)
+	j := job{
+		Job: Job{
+			ID:          uuid.New().String(),
+			Status:      JobRunning,
+			Chunks:      len(chunks),
+			DateCreated: now.UTC(),
+		},
+		owner:    owner,
+		tenant:   tenant,
+		key:      key,
+		groupBy:  groupBy,
+		currency: currency,
+		parts:    make([][]RevenueBucket, len(chunks)),
+	}
+	r.jobs[j.ID] = &j
+
+	if len(chunks) == 0 {
+		r.finish(&j, now)
+		return j.Job, nil
+	}
+
+	go func() {
+		for i, f := range chunks {
+			select {
+			case r.tasks <- task{job: &j, index: i, filter: f}:
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+
+	return j.Job, nil
+}
+
+// Job gives the state of the job with id. Jobs can only be seen by the user
+// who started them unless all is set, and never from another tenant.
+func (r *Runner) Job(ctx context.Context, id, owner string, all bool) (Job, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	j, ok := r.jobs[id]
+	if !ok || (!all && j.owner != owner) || !sameTenant(j.tenant, auth.TenantScope(ctx)) {
+		return Job{}, ErrJobNotFound
+	}
+	return j.Job, nil
+}
+
+// compute runs the query of one chunk and records its result. The workers
+// have no request, so the query is limited to the tenant of the job through
+// claims of their own.
+func (r *Runner) compute(ctx context.Context, t task) {
+	r.mu.Lock()
+	failed := t.job.Status == JobFailed
+	r.mu.Unlock()
+	if failed {
+		return
+	}
+
+	if tenant, ok := t.job.tenant.(string); ok {
+		ctx = context.WithValue(ctx, auth.Key, auth.Claims{TenantID: tenant})
+	}
+
+	list, err := Revenue(ctx, r.db, t.job.groupBy, t.job.currency, t.filter)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if t.job.Status != JobRunning {
+		return
+	}
+	if err != nil {
+		now := time.Now().UTC()
+		t.job.Status = JobFailed
+		t.job.Error = err.Error()
+		t.job.DateFinished = &now
+		t.job.parts = nil
+		return
+	}
+
+	t.job.parts[t.index] = list
+	t.job.Done++
+	if t.job.Done == t.job.Chunks {
+		r.finish(t.job, time.Now())
+	}
+}
+
+// finish merges the chunks of a job into its result. The chunks follow each
+// other in time so the result is in the order Revenue gives.
+func (r *Runner) finish(j *job, now time.Time) {
+	result := []RevenueBucket{}
+	for _, part := range j.parts {
+		result = append(result, part...)
+	}
+	now = now.UTC()
+	j.Result = result
+	j.Status = JobDone
+	j.DateFinished = &now
+	j.parts = nil
+}
+
+// sameTenant reports whether a job of tenant may be seen in scope, both as
+// given by auth.TenantScope. Callers without a scope see every job.
+func sameTenant(tenant, scope interface{}) bool {
+	return scope == nil || tenant == scope
+}
+
+// prune forgets the jobs which finished more than the ttl ago.
+func (r *Runner) prune(now time.Time) {
+	for id, j := range r.jobs {
+		if j.DateFinished != nil && now.Sub(*j.DateFinished) > r.ttl {
+			delete(r.jobs, id)
+		}
+	}
+}
+
+// split divides the date range of filter at the boundaries of the periods of
+// groupBy so no period is split between chunks.
+func split(groupBy string, filter Filter) []Filter {
+	var chunks []Filter
+	for start := truncate(groupBy, filter.From); start.Before(filter.To); start = next(groupBy, start) {
+		f := filter
+		if start.After(f.From) {
+			f.From = start
+		}
+		if end := next(groupBy, start); end.Before(f.To) {
+			f.To = end
+		}
+		chunks = append(chunks, f)
+	}
+	return chunks
+}
+
+// truncate gives the start of the period of groupBy t falls in, the way
+// DATE_TRUNC does in UTC. Weeks start on Monday.
+func truncate(groupBy string, t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	switch groupBy {
+	case GroupByWeek:
+		day := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	case GroupByMonth:
+		return time.Date(y, m, 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	}
+}
+
+// next gives the start of the period of groupBy following the one starting
+// at start.
+func next(groupBy string, start time.Time) time.Time {
+	switch groupBy {
+	case GroupByWeek:
+		return start.AddDate(0, 0, 7)
+	case GroupByMonth:
+		return start.AddDate(0, 1, 0)
+	default:
+		return start.AddDate(0, 0, 1)
+	}
+}
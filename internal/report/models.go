@@ -0,0 +1,63 @@
+package report
+
+import "time"
+
+// Grouping sizes supported by the revenue report.
+const (
+	GroupByDay   = "day"
+	GroupByWeek  = "week"
+	GroupByMonth = "month"
+)
+
+// Filter narrows down the sales a report is computed from. Fields left at
+// their zero value are not applied. From is inclusive and To is exclusive.
+// When UserID is set only products owned by that user are included.
+type Filter struct {
+	From   time.Time
+	To     time.Time
+	UserID string
+}
+
+// Ways products can be ranked by the top products report.
+const (
+	RankByUnits   = "units"
+	RankByRevenue = "revenue"
+)
+
+// RateDay is a day on which sales were made in Currency.
+type RateDay struct {
+	Day      time.Time `db:"day"`
+	Currency string    `db:"currency"`
+}
+
+// ProductRank is a product's paid sales within the report window.
+type ProductRank struct {
+	Rank        int    `db:"rank" json:"rank"`
+	ProductID   string `db:"product_id" json:"product_id"`
+	ProductName string `db:"product_name" json:"product_name"`
+	Units       int    `db:"units" json:"units"`
+	Revenue     int    `db:"revenue" json:"revenue"`
+}
+
+// RevenueBucket is the revenue and units sold of a single product within one
+// period of time. Period is the start of the bucket.
+type RevenueBucket struct {
+	Period      time.Time `db:"period" json:"period"`
+	ProductID   string    `db:"product_id" json:"product_id"`
+	ProductName string    `db:"product_name" json:"product_name"`
+	Units       int       `db:"units" json:"units"`
+	Revenue     int       `db:"revenue" json:"revenue"`
+}
+
+// Stats are the key performance indicators of the platform over a window of
+// time. Conversion is the share of products listed in the window which sold
+// at least one unit.
+type Stats struct {
+	From          time.Time `json:"from"`
+	To            time.Time `json:"to"`
+	ActiveSellers int       `db:"active_sellers" json:"active_sellers"`
+	NewListings   int       `db:"new_listings" json:"new_listings"`
+	GMV           int       `db:"gmv" json:"gmv"`
+	UnitsSold     int       `db:"units_sold" json:"units_sold"`
+	Conversion    float64   `db:"conversion" json:"conversion"`
+}
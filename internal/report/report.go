@@ -0,0 +1,234 @@
+package report
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/arammikayelyan/garagesale/internal/platform/database"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidGrouping is returned when a report is requested with an
+// unsupported bucket size.
+var ErrInvalidGrouping = errors.New("group_by must be one of day, week or month")
+
+// ErrInvalidCurrency is returned when a report is requested in something
+// which is not an ISO 4217 currency code.
+var ErrInvalidCurrency = errors.New("currency must be a three letter currency code")
+
+// ErrMissingRate is returned when a sale can not be converted to the report
+// currency because the exchange rate of its day is not stored.
+var ErrMissingRate = errors.New("exchange rate missing for some sales")
+
+// ErrInvalidRanking is returned when top products are requested ranked by an
+// unsupported measure.
+var ErrInvalidRanking = errors.New("by must be one of units or revenue")
+
+// Revenue gives the revenue and units sold per product bucketed by day, week
+// or month, ordered by period. When currency is not empty every sale is
+// converted to it at the exchange rate of the day it was made; the rates must
+// already be stored, see RateDays.
+func Revenue(ctx context.Context, db *sqlx.DB, groupBy, currency string, filter Filter) ([]RevenueBucket, error) {
+	switch groupBy {
+	case GroupByDay, GroupByWeek, GroupByMonth:
+	default:
+		return nil, ErrInvalidGrouping
+	}
+
+	if currency == "" {
+		return revenue(ctx, db, groupBy, `SUM(s.paid)`, "", filter)
+	}
+
+	currency = strings.ToUpper(currency)
+	if !isCurrency(currency) {
+		return nil, ErrInvalidCurrency
+	}
+
+	days, err := RateDays(ctx, db, currency, filter)
+	if err != nil {
+		return nil, err
+	}
+	var missing int
+	const qMissing = `
+		SELECT COUNT(*) FROM UNNEST($1::DATE[], $2::TEXT[]) AS d(day, base)
+		WHERE NOT EXISTS (
+			SELECT 1 FROM exchange_rates AS r
+			WHERE r.day = d.day AND r.base = d.base AND r.quote = $3
+		)`
+	dates, bases := make([]time.Time, len(days)), make([]string, len(days))
+	for i, d := range days {
+		dates[i], bases[i] = d.Day, d.Currency
+	}
+	if err := db.GetContext(ctx, &missing, qMissing, pq.Array(dates), pq.Array(bases), currency); err != nil {
+		return nil, errors.Wrap(err, "checking exchange rates")
+	}
+	if missing > 0 {
+		return nil, ErrMissingRate
+	}
+
+	const join = `
+		LEFT JOIN exchange_rates AS r
+			ON r.day = s.date_created::DATE AND r.base = s.currency AND r.quote = $2`
+	const sum = `ROUND(SUM(s.paid * CASE WHEN s.currency = $2 THEN 1 ELSE r.rate END))::INT`
+	return revenue(ctx, db, groupBy, sum, join, filter, currency)
+}
+
+// revenue runs the revenue query summing the paid amounts with sum. The join
+// and its extra arguments, which follow the grouping, make conversion rates
+// available to sum.
+func revenue(ctx context.Context, db *sqlx.DB, groupBy, sum, join string, filter Filter, extra ...interface{}) ([]RevenueBucket, error) {
+	q := `
+		SELECT
+			DATE_TRUNC($1, s.date_created) AS period,
+			p.product_id, p.name AS product_name,
+			SUM(s.quantity) AS units,
+			` + sum + ` AS revenue
+		FROM sales AS s
+		JOIN products AS p ON p.product_id = s.product_id` + join + `
+		WHERE s.status = 'paid'`
+	args := append([]interface{}{groupBy}, extra...)
+	q += filter.where(ctx, &args)
+	q += `
+		GROUP BY 1, p.product_id, p.name
+		ORDER BY 1, p.name`
+
+	list := []RevenueBucket{}
+	if err := db.SelectContext(ctx, &list, q, args...); err != nil {
+		return nil, errors.Wrap(err, "selecting revenue")
+	}
+
+	return list, nil
+}
+
+// RateDays lists the days and currencies of the paid sales matching the
+// filter which are not in currency, so their rates can be fetched before
+// converting.
+func RateDays(ctx context.Context, db *sqlx.DB, currency string, filter Filter) ([]RateDay, error) {
+	q := `
+		SELECT DISTINCT s.date_created::DATE AS day, s.currency
+		FROM sales AS s
+		JOIN products AS p ON p.product_id = s.product_id
+		WHERE s.status = 'paid'`
+	args := []interface{}{strings.ToUpper(currency)}
+	q += ` AND s.currency <> $1`
+	q += filter.where(ctx, &args)
+	q += ` ORDER BY 1, 2`
+
+	list := []RateDay{}
+	if err := db.SelectContext(ctx, &list, q, args...); err != nil {
+		return nil, errors.Wrap(err, "selecting sale currencies")
+	}
+
+	return list, nil
+}
+
+// isCurrency reports whether s looks like an ISO 4217 code.
+func isCurrency(s string) bool {
+	if len(s) != 3 {
+		return false
+	}
+	for _, r := range s {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// TopProducts ranks the products with paid sales matching the filter by units
+// sold or revenue and returns the first limit of them.
+func TopProducts(ctx context.Context, db *sqlx.DB, by string, limit int, filter Filter) ([]ProductRank, error) {
+	var order string
+	switch by {
+	case RankByUnits:
+		order = "units DESC, revenue DESC"
+	case RankByRevenue:
+		order = "revenue DESC, units DESC"
+	default:
+		return nil, ErrInvalidRanking
+	}
+
+	q := `
+		SELECT
+			p.product_id, p.name AS product_name,
+			SUM(s.quantity) AS units,
+			SUM(s.paid) AS revenue
+		FROM sales AS s
+		JOIN products AS p ON p.product_id = s.product_id
+		WHERE s.status = 'paid'`
+	var args []interface{}
+	q += filter.where(ctx, &args)
+	args = append(args, limit)
+	q += fmt.Sprintf(`
+		GROUP BY p.product_id, p.name
+		ORDER BY %s, p.name
+		LIMIT $%d`, order, len(args))
+
+	list := []ProductRank{}
+	if err := db.SelectContext(ctx, &list, q, args...); err != nil {
+		return nil, errors.Wrap(err, "selecting top products")
+	}
+	for i := range list {
+		list[i].Rank = i + 1
+	}
+
+	return list, nil
+}
+
+// where renders the conditions of the filter against the sales (s) and
+// products (p) tables, appending their values to args. Sales of other tenants
+// than the caller are always left out.
+func (f Filter) where(ctx context.Context, args *[]interface{}) string {
+	*args = append(*args, auth.TenantScope(ctx))
+	q := " AND " + database.InTenant("s.tenant_id", len(*args))
+	if !f.From.IsZero() {
+		*args = append(*args, f.From.UTC())
+		q += fmt.Sprintf(" AND s.date_created >= $%d", len(*args))
+	}
+	if !f.To.IsZero() {
+		*args = append(*args, f.To.UTC())
+		q += fmt.Sprintf(" AND s.date_created < $%d", len(*args))
+	}
+	if f.UserID != "" {
+		*args = append(*args, f.UserID)
+		q += fmt.Sprintf(" AND p.user_id = $%d", len(*args))
+	}
+	return q
+}
+
+// PlatformStats computes the platform KPIs for sales and listings created in
+// the window [from, to).
+func PlatformStats(ctx context.Context, db *sqlx.DB, from, to time.Time) (*Stats, error) {
+	st := Stats{
+		From: from.UTC(),
+		To:   to.UTC(),
+	}
+
+	const q = `
+		SELECT
+			(SELECT COUNT(DISTINCT p.user_id)
+				FROM sales AS s JOIN products AS p ON p.product_id = s.product_id
+				WHERE s.status = 'paid' AND s.date_created >= $1 AND s.date_created < $2) AS active_sellers,
+			(SELECT COUNT(*) FROM products
+				WHERE date_created >= $1 AND date_created < $2) AS new_listings,
+			(SELECT COALESCE(SUM(paid), 0) FROM sales
+				WHERE status = 'paid' AND date_created >= $1 AND date_created < $2) AS gmv,
+			(SELECT COALESCE(SUM(quantity), 0) FROM sales
+				WHERE status = 'paid' AND date_created >= $1 AND date_created < $2) AS units_sold,
+			(SELECT COALESCE(AVG(CASE WHEN EXISTS (
+					SELECT 1 FROM sales AS s WHERE s.product_id = p.product_id AND s.status = 'paid'
+				) THEN 1.0 ELSE 0.0 END), 0)
+				FROM products AS p
+				WHERE p.date_created >= $1 AND p.date_created < $2) AS conversion`
+
+	if err := db.GetContext(ctx, &st, q, st.From, st.To); err != nil {
+		return nil, errors.Wrap(err, "selecting platform stats")
+	}
+
+	return &st, nil
+}
@@ -0,0 +1,139 @@
+package schema
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// scratch is the schema migrations are replayed into while checking. It is
+// created inside a transaction which is always rolled back.
+const scratch = "schema_check"
+
+// Column describes a column as it is built by the migrations.
+type Column struct {
+	Table      string `db:"table_name"`
+	Name       string `db:"column_name"`
+	Type       string `db:"data_type"`
+	Nullable   bool   `db:"nullable"`
+	HasDefault bool   `db:"has_default"`
+}
+
+// Problem is an incompatibility between the schema this binary expects and
+// the schema at another version.
+type Problem struct {
+	Table  string
+	Column string
+	Reason string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s.%s: %s", p.Table, p.Column, p.Reason)
+}
+
+// Latest returns the version of the newest migration.
+func Latest() int {
+	return int(migrations[len(migrations)-1].Version)
+}
+
+// Check compares the schema this binary expects, built by all of its
+// migrations, with the schema at version against so a blue/green deploy can
+// run both sides on the same database. Columns the binary needs that are
+// missing at the other version, columns the other version still uses that are
+// gone, required columns the other version does not know about and changed
+// types are all reported.
+func Check(ctx context.Context, db *sqlx.DB, against int) ([]Problem, error) {
+	if against < 1 || against > Latest() {
+		return nil, errors.Errorf("version must be between 1 and %d", Latest())
+	}
+
+	want, err := columns(ctx, db, Latest())
+	if err != nil {
+		return nil, errors.Wrapf(err, "building version %d", Latest())
+	}
+	have, err := columns(ctx, db, against)
+	if err != nil {
+		return nil, errors.Wrapf(err, "building version %d", against)
+	}
+
+	tables := make(map[string]bool)
+	for _, h := range have {
+		tables[h.Table] = true
+	}
+
+	var problems []Problem
+	for key, w := range want {
+		h, ok := have[key]
+		switch {
+		case !ok && tables[w.Table] && !w.Nullable && !w.HasDefault:
+			problems = append(problems, Problem{w.Table, w.Name, fmt.Sprintf("required but unknown to version %d, its inserts fail", against)})
+		case !ok:
+			problems = append(problems, Problem{w.Table, w.Name, fmt.Sprintf("missing at version %d", against)})
+		case h.Type != w.Type:
+			problems = append(problems, Problem{w.Table, w.Name, fmt.Sprintf("type is %s at version %d but %s here", h.Type, against, w.Type)})
+		}
+	}
+	for key, h := range have {
+		if _, ok := want[key]; !ok {
+			problems = append(problems, Problem{h.Table, h.Name, fmt.Sprintf("used at version %d but dropped here", against)})
+		}
+	}
+
+	sort.Slice(problems, func(i, j int) bool {
+		if problems[i].Table != problems[j].Table {
+			return problems[i].Table < problems[j].Table
+		}
+		return problems[i].Column < problems[j].Column
+	})
+
+	return problems, nil
+}
+
+// columns replays the migrations up to version into the scratch schema and
+// returns its columns keyed by table and column name.
+func columns(ctx context.Context, db *sqlx.DB, version int) (map[string]Column, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `CREATE SCHEMA `+scratch); err != nil {
+		return nil, errors.Wrap(err, "creating scratch schema")
+	}
+	if _, err := tx.ExecContext(ctx, `SET LOCAL search_path TO `+scratch); err != nil {
+		return nil, errors.Wrap(err, "selecting scratch schema")
+	}
+
+	for _, m := range migrations {
+		if int(m.Version) > version {
+			break
+		}
+		if _, err := tx.ExecContext(ctx, m.Script); err != nil {
+			return nil, errors.Wrapf(err, "applying migration %v", m.Version)
+		}
+	}
+
+	const q = `
+		SELECT
+			table_name, column_name, data_type,
+			is_nullable = 'YES' AS nullable,
+			column_default IS NOT NULL AS has_default
+		FROM information_schema.columns
+		WHERE table_schema = $1`
+
+	var list []Column
+	if err := tx.SelectContext(ctx, &list, q, scratch); err != nil {
+		return nil, errors.Wrap(err, "selecting columns")
+	}
+
+	cols := make(map[string]Column, len(list))
+	for _, c := range list {
+		cols[c.Table+"."+c.Name] = c
+	}
+
+	return cols, nil
+}
@@ -68,6 +68,942 @@ var migrations = []darwin.Migration{
 					ADD COLUMN user_id UUID DEFAULT '00000000-0000-0000-0000-000000000000'
 				`,
 	},
+	{
+		Version:     5,
+		Description: "Add events",
+		Script: `
+				CREATE TABLE events (
+					event_id     UUID,
+					user_id      UUID,
+					name         TEXT,
+					address      TEXT,
+					latitude     DOUBLE PRECISION,
+					longitude    DOUBLE PRECISION,
+					starts_at    TIMESTAMP,
+					ends_at      TIMESTAMP,
+					date_created TIMESTAMP,
+					date_updated TIMESTAMP,
+
+					PRIMARY KEY (event_id)
+				);
+
+				CREATE TABLE event_products (
+					event_id   UUID,
+					product_id UUID,
+
+					PRIMARY KEY (event_id, product_id),
+					FOREIGN KEY (event_id) REFERENCES events(event_id) ON DELETE CASCADE,
+					FOREIGN KEY (product_id) REFERENCES products(product_id) ON DELETE CASCADE
+				);`,
+	},
+	{
+		Version:     6,
+		Description: "Add notification channels",
+		Script: `
+				CREATE TABLE notification_channels (
+					user_id      UUID,
+					channel      TEXT,
+					address      TEXT,
+					enabled      BOOLEAN DEFAULT TRUE,
+					date_updated TIMESTAMP,
+
+					PRIMARY KEY (user_id, channel),
+					FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+				);`,
+	},
+	{
+		Version:     7,
+		Description: "Add devices",
+		Script: `
+				CREATE TABLE devices (
+					token        TEXT,
+					user_id      UUID,
+					platform     TEXT,
+					events       TEXT[],
+					date_created TIMESTAMP,
+
+					PRIMARY KEY (token),
+					FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+				);`,
+	},
+	{
+		Version:     8,
+		Description: "Add notification preferences and in-app notifications",
+		Script: `
+				CREATE TABLE notification_preferences (
+					user_id      UUID,
+					event        TEXT,
+					channels     TEXT[],
+					date_updated TIMESTAMP,
+
+					PRIMARY KEY (user_id, event),
+					FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+				);
+
+				CREATE TABLE notifications (
+					notification_id UUID,
+					user_id         UUID,
+					event           TEXT,
+					subject         TEXT,
+					body            TEXT,
+					date_created    TIMESTAMP,
+					date_read       TIMESTAMP,
+
+					PRIMARY KEY (notification_id),
+					FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+				);
+
+				CREATE INDEX notifications_user_idx ON notifications (user_id, date_created);`,
+	},
+	{
+		Version:     9,
+		Description: "Add notification digests",
+		Script: `
+				CREATE TABLE notification_digests (
+					user_id   UUID,
+					frequency TEXT DEFAULT 'daily',
+					last_sent TIMESTAMP,
+
+					PRIMARY KEY (user_id),
+					FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+				);
+
+				CREATE TABLE notification_digest_items (
+					item_id      UUID,
+					user_id      UUID,
+					event        TEXT,
+					subject      TEXT,
+					body         TEXT,
+					date_created TIMESTAMP,
+
+					PRIMARY KEY (item_id),
+					FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+				);`,
+	},
+	{
+		Version:     10,
+		Description: "Add template overrides",
+		Script: `
+				CREATE TABLE templates (
+					name         TEXT,
+					source       TEXT,
+					date_updated TIMESTAMP,
+
+					PRIMARY KEY (name)
+				);`,
+	},
+	{
+		Version:     11,
+		Description: "Add idempotency key to sales",
+		Script: `
+				ALTER TABLE sales
+					ADD COLUMN idempotency_key TEXT;
+
+				CREATE UNIQUE INDEX sales_idempotency_key_idx
+					ON sales (product_id, idempotency_key)
+					WHERE idempotency_key IS NOT NULL;`,
+	},
+	{
+		Version:     12,
+		Description: "Add product descriptions and translations",
+		Script: `
+				ALTER TABLE products
+					ADD COLUMN description TEXT NOT NULL DEFAULT '';
+
+				CREATE TABLE product_translations (
+					product_id   UUID,
+					language     TEXT,
+					name         TEXT,
+					description  TEXT,
+					date_updated TIMESTAMP,
+
+					PRIMARY KEY (product_id, language),
+					FOREIGN KEY (product_id) REFERENCES products(product_id) ON DELETE CASCADE
+				);`,
+	},
+	{
+		Version:     13,
+		Description: "Add coupons",
+		Script: `
+				CREATE TABLE coupons (
+					coupon_id    UUID,
+					user_id      UUID,
+					code         TEXT,
+					kind         TEXT,
+					value        INT,
+					expires_at   TIMESTAMP,
+					max_uses     INT,
+					uses         INT DEFAULT 0,
+					date_created TIMESTAMP,
+					date_updated TIMESTAMP,
+
+					PRIMARY KEY (coupon_id),
+					UNIQUE (user_id, code),
+					FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+				);
+
+				ALTER TABLE sales
+					ADD COLUMN coupon_id UUID REFERENCES coupons(coupon_id) ON DELETE SET NULL,
+					ADD COLUMN discount  INT NOT NULL DEFAULT 0;`,
+	},
+	{
+		Version:     14,
+		Description: "Add content flags",
+		Script: `
+				CREATE TABLE content_flags (
+					flag_id      UUID,
+					subject_type TEXT,
+					subject_id   TEXT,
+					field        TEXT,
+					reasons      TEXT[],
+					date_created TIMESTAMP,
+
+					PRIMARY KEY (flag_id)
+				);
+
+				CREATE INDEX content_flags_date_idx ON content_flags (date_created);`,
+	},
+	{
+		Version:     15,
+		Description: "Add product categories, tags and suggestions",
+		Script: `
+				ALTER TABLE products
+					ADD COLUMN category TEXT NOT NULL DEFAULT '',
+					ADD COLUMN tags     TEXT[] NOT NULL DEFAULT '{}';
+
+				CREATE TABLE product_suggestions (
+					product_id   UUID,
+					category     TEXT,
+					tags         TEXT[],
+					description  TEXT,
+					date_created TIMESTAMP,
+
+					PRIMARY KEY (product_id),
+					FOREIGN KEY (product_id) REFERENCES products(product_id) ON DELETE CASCADE
+				);`,
+	},
+	{
+		Version:     16,
+		Description: "Add sale status",
+		Script: `
+				ALTER TABLE sales
+					ADD COLUMN status TEXT NOT NULL DEFAULT 'paid';`,
+	},
+	{
+		Version:     17,
+		Description: "Add buyer to sales",
+		Script: `
+				ALTER TABLE sales
+					ADD COLUMN buyer_id    UUID REFERENCES users(user_id) ON DELETE SET NULL,
+					ADD COLUMN buyer_name  TEXT,
+					ADD COLUMN buyer_email TEXT;
+
+				CREATE INDEX sales_buyer_idx ON sales (buyer_id);`,
+	},
+	{
+		Version:     18,
+		Description: "Add sale update time and warehouse export watermarks",
+		Script: `
+				ALTER TABLE sales
+					ADD COLUMN date_updated TIMESTAMP;
+
+				UPDATE sales SET date_updated = date_created;
+
+				CREATE INDEX sales_updated_idx ON sales (date_updated);
+				CREATE INDEX products_updated_idx ON products (date_updated);
+				CREATE INDEX users_updated_idx ON users (date_updated);
+
+				CREATE TABLE warehouse_exports (
+					table_name     TEXT,
+					exported_until TIMESTAMP,
+
+					PRIMARY KEY (table_name)
+				);`,
+	},
+	{
+		Version:     19,
+		Description: "Add sold and revenue counters to products",
+		Script: `
+				ALTER TABLE products
+					ADD COLUMN sold    INT NOT NULL DEFAULT 0,
+					ADD COLUMN revenue INT NOT NULL DEFAULT 0;
+
+				CREATE FUNCTION count_product_sales(id UUID) RETURNS VOID AS $$
+					UPDATE products SET
+						sold = (
+							SELECT COALESCE(SUM(quantity), 0) FROM sales
+							WHERE product_id = id AND status <> 'cancelled'
+						),
+						revenue = (
+							SELECT COALESCE(SUM(paid), 0) FROM sales
+							WHERE product_id = id AND status = 'paid'
+						)
+					WHERE product_id = id;
+				$$ LANGUAGE SQL;
+
+				CREATE FUNCTION sales_counters() RETURNS TRIGGER AS $$
+				BEGIN
+					IF TG_OP <> 'INSERT' THEN
+						PERFORM count_product_sales(OLD.product_id);
+					END IF;
+					IF TG_OP = 'INSERT' OR (TG_OP = 'UPDATE' AND NEW.product_id <> OLD.product_id) THEN
+						PERFORM count_product_sales(NEW.product_id);
+					END IF;
+					RETURN NULL;
+				END;
+				$$ LANGUAGE plpgsql;
+
+				CREATE TRIGGER sales_counters
+					AFTER INSERT OR UPDATE OR DELETE ON sales
+					FOR EACH ROW EXECUTE PROCEDURE sales_counters();
+
+				SELECT count_product_sales(product_id) FROM products;`,
+	},
+	{
+		Version:     20,
+		Description: "Add index for ranking products by paid sales",
+		Script: `
+				CREATE INDEX sales_paid_created_idx ON sales (date_created, product_id)
+					INCLUDE (quantity, paid)
+					WHERE status = 'paid';`,
+	},
+	{
+		Version:     21,
+		Description: "Add sale currency and exchange rates",
+		Script: `
+				ALTER TABLE sales
+					ADD COLUMN currency TEXT NOT NULL DEFAULT 'USD';
+
+				CREATE TABLE exchange_rates (
+					day   DATE,
+					base  TEXT,
+					quote TEXT,
+					rate  DOUBLE PRECISION,
+
+					PRIMARY KEY (day, base, quote)
+				);`,
+	},
+	{
+		Version:     22,
+		Description: "Constrain enumerated columns",
+		Script: `
+				ALTER TABLE sales
+					ADD CONSTRAINT sales_status_check
+					CHECK (status IN ('pending', 'paid', 'cancelled'));
+
+				ALTER TABLE coupons
+					ADD CONSTRAINT coupons_kind_check
+					CHECK (kind IN ('percent', 'fixed'));
+
+				ALTER TABLE users
+					ADD CONSTRAINT users_roles_check
+					CHECK (roles <@ ARRAY['ADMIN', 'USER']);`,
+	},
+	{
+		Version:     23,
+		Description: "Index products by last update",
+		Script: `
+				CREATE INDEX products_date_updated_idx ON products (date_updated);`,
+	},
+	{
+		Version:     24,
+		Description: "Add password reset tokens",
+		Script: `
+				CREATE TABLE password_resets (
+					token_hash   TEXT,
+					user_id      UUID NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+					date_expires TIMESTAMP NOT NULL,
+					date_used    TIMESTAMP,
+					date_created TIMESTAMP NOT NULL,
+
+					PRIMARY KEY (token_hash)
+				);
+
+				CREATE INDEX password_resets_user_idx ON password_resets (user_id);`,
+	},
+	{
+		Version:     25,
+		Description: "Add email verification",
+		Script: `
+				ALTER TABLE users
+					ADD COLUMN date_verified TIMESTAMP;
+
+				UPDATE users SET date_verified = date_created;
+
+				CREATE TABLE email_verifications (
+					token_hash   TEXT,
+					user_id      UUID NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+					date_expires TIMESTAMP NOT NULL,
+					date_created TIMESTAMP NOT NULL,
+
+					PRIMARY KEY (token_hash)
+				);
+
+				CREATE INDEX email_verifications_user_idx ON email_verifications (user_id);`,
+	},
+	{
+		Version:     26,
+		Description: "Add refresh tokens",
+		Script: `
+				CREATE TABLE refresh_tokens (
+					token_hash   TEXT,
+					user_id      UUID NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+					family_id    UUID NOT NULL,
+					date_expires TIMESTAMP NOT NULL,
+					date_used    TIMESTAMP,
+					date_created TIMESTAMP NOT NULL,
+
+					PRIMARY KEY (token_hash)
+				);
+
+				CREATE INDEX refresh_tokens_user_idx ON refresh_tokens (user_id);
+				CREATE INDEX refresh_tokens_family_idx ON refresh_tokens (family_id);`,
+	},
+	{
+		Version:     27,
+		Description: "Add tenants",
+		Script: `
+				CREATE TABLE tenants (
+					tenant_id     UUID,
+					name          TEXT NOT NULL,
+					slug          TEXT NOT NULL UNIQUE,
+					currency      TEXT NOT NULL DEFAULT 'USD',
+					signing_scope TEXT NOT NULL UNIQUE,
+					date_created  TIMESTAMP NOT NULL,
+					date_updated  TIMESTAMP NOT NULL,
+
+					PRIMARY KEY (tenant_id)
+				);
+
+				CREATE TABLE tenant_categories (
+					tenant_id UUID REFERENCES tenants(tenant_id) ON DELETE CASCADE,
+					name      TEXT,
+
+					PRIMARY KEY (tenant_id, name)
+				);
+
+				CREATE TABLE tenant_templates (
+					tenant_id    UUID REFERENCES tenants(tenant_id) ON DELETE CASCADE,
+					name         TEXT,
+					source       TEXT NOT NULL,
+					date_updated TIMESTAMP NOT NULL,
+
+					PRIMARY KEY (tenant_id, name)
+				);
+
+				ALTER TABLE users
+					ADD COLUMN tenant_id UUID REFERENCES tenants(tenant_id) ON DELETE CASCADE;`,
+	},
+	{
+		Version:     28,
+		Description: "Add tenant settings",
+		Script: `
+				CREATE TABLE tenant_settings (
+					tenant_id       UUID REFERENCES tenants(tenant_id) ON DELETE CASCADE,
+					currency        TEXT,
+					locale          TEXT,
+					commission_rate NUMERIC(5, 2),
+					features        JSONB NOT NULL DEFAULT '{}',
+					date_updated    TIMESTAMP NOT NULL,
+
+					PRIMARY KEY (tenant_id)
+				);`,
+	},
+	{
+		Version:     29,
+		Description: "Add revoked tokens",
+		Script: `
+				CREATE TABLE revoked_tokens (
+					token_id     TEXT,
+					date_expires TIMESTAMP NOT NULL,
+
+					PRIMARY KEY (token_id)
+				);
+
+				CREATE INDEX revoked_tokens_expires_idx ON revoked_tokens (date_expires);`,
+	},
+	{
+		Version:     30,
+		Description: "Add role change audit trail",
+		Script: `
+				CREATE TABLE role_changes (
+					change_id    UUID,
+					user_id      UUID NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+					changed_by   UUID NOT NULL,
+					old_roles    TEXT[] NOT NULL,
+					new_roles    TEXT[] NOT NULL,
+					date_created TIMESTAMP NOT NULL,
+
+					PRIMARY KEY (change_id)
+				);
+
+				CREATE INDEX role_changes_user_idx ON role_changes (user_id, date_created);`,
+	},
+	{
+		Version:     31,
+		Description: "Add tenant usage metering",
+		Script: `
+				CREATE TABLE tenant_usage (
+					tenant_id     UUID REFERENCES tenants(tenant_id) ON DELETE CASCADE,
+					day           DATE,
+					api_calls     BIGINT NOT NULL DEFAULT 0,
+					storage_bytes BIGINT NOT NULL DEFAULT 0,
+					gmv           BIGINT NOT NULL DEFAULT 0,
+					currency      TEXT NOT NULL DEFAULT 'USD',
+
+					PRIMARY KEY (tenant_id, day)
+				);
+
+				CREATE INDEX users_tenant_idx ON users (tenant_id);`,
+	},
+	{
+		Version:     32,
+		Description: "Add user profile fields",
+		Script: `
+				ALTER TABLE users
+					ADD COLUMN display_name TEXT,
+					ADD COLUMN phone        TEXT,
+					ADD COLUMN avatar_url   TEXT;`,
+	},
+	{
+		Version:     33,
+		Description: "Add account lockout",
+		Script: `
+				ALTER TABLE users
+					ADD COLUMN failed_logins INT NOT NULL DEFAULT 0,
+					ADD COLUMN locked_until  TIMESTAMP;`,
+	},
+	{
+		Version:     34,
+		Description: "Add external identities",
+		Script: `
+				CREATE TABLE user_identities (
+					issuer       TEXT,
+					subject      TEXT,
+					user_id      UUID NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+					date_created TIMESTAMP,
+
+					PRIMARY KEY (issuer, subject)
+				);
+
+				CREATE INDEX user_identities_user_idx ON user_identities (user_id);`,
+	},
+	{
+		Version:     35,
+		Description: "Add user deactivation",
+		Script: `
+				ALTER TABLE users
+					ADD COLUMN active           BOOLEAN NOT NULL DEFAULT TRUE,
+					ADD COLUMN date_deactivated TIMESTAMP;
+
+				ALTER TABLE products ADD COLUMN hidden BOOLEAN NOT NULL DEFAULT FALSE;`,
+	},
+	{
+		Version:     36,
+		Description: "Update product sales counters incrementally",
+		Script: `
+				CREATE OR REPLACE FUNCTION sales_counters() RETURNS TRIGGER AS $$
+				BEGIN
+					IF TG_OP <> 'INSERT' THEN
+						UPDATE products SET
+							sold    = sold - CASE WHEN OLD.status <> 'cancelled' THEN OLD.quantity ELSE 0 END,
+							revenue = revenue - CASE WHEN OLD.status = 'paid' THEN OLD.paid ELSE 0 END
+						WHERE product_id = OLD.product_id;
+					END IF;
+					IF TG_OP <> 'DELETE' THEN
+						UPDATE products SET
+							sold    = sold + CASE WHEN NEW.status <> 'cancelled' THEN NEW.quantity ELSE 0 END,
+							revenue = revenue + CASE WHEN NEW.status = 'paid' THEN NEW.paid ELSE 0 END
+						WHERE product_id = NEW.product_id;
+					END IF;
+					RETURN NULL;
+				END;
+				$$ LANGUAGE plpgsql;
+
+				SELECT count_product_sales(product_id) FROM products;`,
+	},
+	{
+		Version:     37,
+		Description: "Add sessions",
+		Script: `
+				CREATE TABLE sessions (
+					session_id     UUID,
+					user_id        UUID NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+					user_agent     TEXT NOT NULL DEFAULT '',
+					ip             TEXT NOT NULL DEFAULT '',
+					token_id       TEXT,
+					token_expires  TIMESTAMP,
+					date_created   TIMESTAMP NOT NULL,
+					date_last_used TIMESTAMP NOT NULL,
+					date_expires   TIMESTAMP NOT NULL,
+
+					PRIMARY KEY (session_id)
+				);
+
+				CREATE INDEX sessions_user_idx ON sessions (user_id);
+
+				INSERT INTO sessions (session_id, user_id, date_created, date_last_used, date_expires)
+				SELECT family_id, user_id, MIN(date_created), MAX(date_created), MAX(date_expires)
+				FROM refresh_tokens
+				GROUP BY family_id, user_id;`,
+	},
+	{
+		Version:     38,
+		Description: "Add last login of users",
+		Script: `
+				ALTER TABLE users ADD COLUMN last_login TIMESTAMP;
+
+				CREATE INDEX users_last_login_idx ON users (last_login NULLS FIRST);`,
+	},
+	{
+		Version:     39,
+		Description: "Add two-factor authentication",
+		Script: `
+				CREATE TABLE user_mfa (
+					user_id      UUID REFERENCES users(user_id) ON DELETE CASCADE,
+					secret       TEXT NOT NULL,
+					last_step    BIGINT NOT NULL DEFAULT 0,
+					date_created TIMESTAMP NOT NULL,
+					date_enabled TIMESTAMP,
+
+					PRIMARY KEY (user_id)
+				);
+
+				CREATE TABLE mfa_recovery_codes (
+					user_id   UUID REFERENCES users(user_id) ON DELETE CASCADE,
+					code_hash TEXT,
+					date_used TIMESTAMP,
+
+					PRIMARY KEY (user_id, code_hash)
+				);`,
+	},
+	{
+		Version:     40,
+		Description: "Add impersonation audit trail",
+		Script: `
+				CREATE TABLE impersonations (
+					impersonation_id UUID,
+					admin_id         UUID NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+					user_id          UUID NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+					token_id         TEXT NOT NULL,
+					date_created     TIMESTAMP NOT NULL,
+					date_expires     TIMESTAMP NOT NULL,
+
+					PRIMARY KEY (impersonation_id)
+				);
+
+				CREATE INDEX impersonations_user_idx ON impersonations (user_id, date_created);`,
+	},
+	{
+		Version:     41,
+		Description: "Add exports",
+		Script: `
+				CREATE TABLE exports (
+					export_id     UUID,
+					user_id       UUID NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+					admin         BOOLEAN NOT NULL DEFAULT FALSE,
+					kind          TEXT NOT NULL,
+					params        TEXT NOT NULL DEFAULT '',
+					status        TEXT NOT NULL,
+					rows          INT NOT NULL DEFAULT 0,
+					error         TEXT,
+					date_created  TIMESTAMP NOT NULL,
+					date_started  TIMESTAMP,
+					date_finished TIMESTAMP,
+
+					PRIMARY KEY (export_id)
+				);
+
+				CREATE INDEX exports_status_idx ON exports (status, date_created);`,
+	},
+	{
+		Version:     42,
+		Description: "Add product search",
+		Script: `
+				CREATE FUNCTION product_document(name TEXT, description TEXT, category TEXT) RETURNS tsvector AS $$
+					SELECT setweight(to_tsvector('english', COALESCE(name, '')), 'A') ||
+						setweight(to_tsvector('english', COALESCE(description, '')), 'B') ||
+						setweight(to_tsvector('english', COALESCE(category, '')), 'C')
+				$$ LANGUAGE sql IMMUTABLE;
+
+				CREATE INDEX products_search_idx ON products USING GIN (product_document(name, description, category));`,
+	},
+	{
+		Version:     43,
+		Description: "Add signing keys",
+		Script: `
+				CREATE TABLE signing_keys (
+					key_id       TEXT,
+					private_key  TEXT NOT NULL,
+					date_created TIMESTAMP NOT NULL,
+					date_retired TIMESTAMP,
+
+					PRIMARY KEY (key_id)
+				);`,
+	},
+	{
+		Version:     44,
+		Description: "Add product images",
+		Script: `
+				CREATE TABLE product_images (
+					image_id     UUID,
+					product_id   UUID NOT NULL REFERENCES products(product_id) ON DELETE CASCADE,
+					blob_key     TEXT NOT NULL,
+					content_type TEXT NOT NULL,
+					width        INT NOT NULL,
+					height       INT NOT NULL,
+					hash         BIGINT NOT NULL,
+					date_created TIMESTAMP NOT NULL,
+
+					PRIMARY KEY (image_id)
+				);
+
+				CREATE INDEX product_images_product_idx ON product_images (product_id, date_created);`,
+	},
+	{
+		Version:     45,
+		Description: "Add upload scans",
+		Script: `
+				CREATE TABLE upload_scans (
+					scan_id        UUID,
+					kind           TEXT NOT NULL,
+					user_id        UUID NOT NULL,
+					sha256         TEXT NOT NULL,
+					size           INT NOT NULL,
+					clean          BOOLEAN NOT NULL,
+					threat         TEXT NOT NULL DEFAULT '',
+					quarantine_key TEXT NOT NULL DEFAULT '',
+					date_created   TIMESTAMP NOT NULL,
+
+					PRIMARY KEY (scan_id)
+				);
+
+				CREATE INDEX upload_scans_infected_idx ON upload_scans (date_created) WHERE NOT clean;`,
+	},
+	{
+		Version:     46,
+		Description: "Store product images by content",
+		Script: `
+				CREATE TABLE image_blobs (
+					blob_key     TEXT,
+					refs         INT NOT NULL DEFAULT 0,
+					size         INT NOT NULL,
+					date_created TIMESTAMP NOT NULL,
+
+					PRIMARY KEY (blob_key)
+				);
+
+				INSERT INTO image_blobs (blob_key, refs, size, date_created)
+				SELECT blob_key, COUNT(*), 0, MIN(date_created)
+				FROM product_images
+				GROUP BY blob_key;
+
+				CREATE FUNCTION image_refs() RETURNS TRIGGER AS $$
+				BEGIN
+					IF TG_OP = 'INSERT' THEN
+						UPDATE image_blobs SET refs = refs + 1 WHERE blob_key = NEW.blob_key;
+					ELSE
+						UPDATE image_blobs SET refs = refs - 1 WHERE blob_key = OLD.blob_key;
+					END IF;
+					RETURN NULL;
+				END;
+				$$ LANGUAGE plpgsql;
+
+				CREATE TRIGGER image_refs
+					AFTER INSERT OR DELETE ON product_images
+					FOR EACH ROW EXECUTE PROCEDURE image_refs();
+
+				CREATE INDEX image_blobs_unused_idx ON image_blobs (blob_key) WHERE refs = 0;`,
+	},
+	{
+		Version:     47,
+		Description: "Add product watches and views",
+		Script: `
+				CREATE TABLE product_watches (
+					product_id   UUID REFERENCES products(product_id) ON DELETE CASCADE,
+					user_id      UUID REFERENCES users(user_id) ON DELETE CASCADE,
+					date_created TIMESTAMP NOT NULL,
+
+					PRIMARY KEY (product_id, user_id)
+				);
+
+				CREATE TABLE product_views (
+					product_id UUID REFERENCES products(product_id) ON DELETE CASCADE,
+					hour       TIMESTAMP,
+					views      INT NOT NULL,
+
+					PRIMARY KEY (product_id, hour)
+				);
+
+				CREATE INDEX sales_pending_idx ON sales (product_id) WHERE status = 'pending';`,
+	},
+	{
+		Version:     48,
+		Description: "Add recommendation feedback",
+		Script: `
+				CREATE TABLE recommendation_feedback (
+					feedback_id  UUID,
+					user_id      UUID REFERENCES users(user_id) ON DELETE CASCADE,
+					product_id   UUID REFERENCES products(product_id) ON DELETE CASCADE,
+					kind         TEXT NOT NULL,
+					date_created TIMESTAMP NOT NULL,
+
+					PRIMARY KEY (feedback_id)
+				);
+
+				CREATE INDEX recommendation_feedback_user_idx ON recommendation_feedback (user_id);
+				CREATE INDEX recommendation_feedback_date_idx ON recommendation_feedback (date_created);`,
+	},
+	{
+		Version:     49,
+		Description: "Add fraud decisions",
+		Script: `
+				CREATE TABLE fraud_decisions (
+					decision_id   UUID,
+					user_id       UUID REFERENCES users(user_id) ON DELETE SET NULL,
+					product_id    UUID REFERENCES products(product_id) ON DELETE SET NULL,
+					quantity      INT NOT NULL,
+					amount        INT NOT NULL,
+					currency      TEXT NOT NULL,
+					ip            TEXT NOT NULL,
+					country       TEXT NOT NULL,
+					score         INT NOT NULL,
+					reasons       TEXT[] NOT NULL,
+					action        TEXT NOT NULL,
+					outcome       TEXT,
+					reviewed_by   UUID REFERENCES users(user_id) ON DELETE SET NULL,
+					date_reviewed TIMESTAMP,
+					date_used     TIMESTAMP,
+					date_created  TIMESTAMP NOT NULL,
+
+					PRIMARY KEY (decision_id)
+				);
+
+				CREATE INDEX fraud_decisions_user_idx ON fraud_decisions (user_id, date_created);
+				CREATE INDEX fraud_decisions_review_idx ON fraud_decisions (date_created)
+					WHERE action = 'review' AND outcome IS NULL;`,
+	},
+	{
+		Version:     50,
+		Description: "Add tenants to products and sales",
+		Script: `
+				ALTER TABLE products
+					ADD COLUMN tenant_id UUID REFERENCES tenants(tenant_id) ON DELETE CASCADE;
+
+				UPDATE products AS p SET tenant_id = u.tenant_id
+					FROM users AS u WHERE u.user_id = p.user_id;
+
+				ALTER TABLE sales
+					ADD COLUMN tenant_id UUID REFERENCES tenants(tenant_id) ON DELETE CASCADE;
+
+				UPDATE sales AS s SET tenant_id = p.tenant_id
+					FROM products AS p WHERE p.product_id = s.product_id;
+
+				CREATE INDEX products_tenant_idx ON products (tenant_id);
+				CREATE INDEX sales_tenant_idx ON sales (tenant_id);`,
+	},
+	{
+		Version:     51,
+		Description: "Add chargebacks",
+		Script: `
+				ALTER TABLE sales
+					ADD COLUMN payment_id TEXT;
+
+				CREATE UNIQUE INDEX sales_payment_idx ON sales (payment_id);
+
+				ALTER TABLE sales
+					DROP CONSTRAINT sales_status_check,
+					ADD CONSTRAINT sales_status_check
+					CHECK (status IN ('pending', 'paid', 'cancelled', 'reversed'));
+
+				CREATE TABLE chargebacks (
+					chargeback_id TEXT,
+					sale_id       UUID REFERENCES sales(sale_id) ON DELETE CASCADE,
+					seller_id     UUID REFERENCES users(user_id) ON DELETE SET NULL,
+					amount        INT NOT NULL,
+					currency      TEXT NOT NULL,
+					reason        TEXT NOT NULL,
+					restocked     BOOLEAN NOT NULL,
+					date_created  TIMESTAMP NOT NULL,
+
+					PRIMARY KEY (chargeback_id)
+				);
+
+				CREATE INDEX chargebacks_seller_idx ON chargebacks (seller_id, date_created);`,
+	},
+	{
+		Version:     52,
+		Description: "Add service clients",
+		Script: `
+				CREATE TABLE clients (
+					client_id      UUID,
+					name           TEXT NOT NULL,
+					secret_hash    TEXT NOT NULL,
+					scopes         TEXT[] NOT NULL,
+					roles          TEXT[] NOT NULL,
+					tenant_id      UUID REFERENCES tenants(tenant_id) ON DELETE CASCADE,
+					created_by     UUID REFERENCES users(user_id) ON DELETE SET NULL,
+					date_created   TIMESTAMP NOT NULL,
+					date_last_used TIMESTAMP,
+
+					PRIMARY KEY (client_id)
+				);
+
+				CREATE INDEX clients_tenant_idx ON clients (tenant_id);`,
+	},
+	{
+		Version:     53,
+		Description: "Add consents and analytics events",
+		Script: `
+				CREATE TABLE consents (
+					consent_id   UUID,
+					user_id      UUID UNIQUE REFERENCES users(user_id) ON DELETE CASCADE,
+					analytics    BOOLEAN NOT NULL,
+					marketing    BOOLEAN NOT NULL,
+					date_created TIMESTAMP NOT NULL,
+					date_updated TIMESTAMP NOT NULL,
+
+					PRIMARY KEY (consent_id)
+				);
+
+				CREATE TABLE analytics_events (
+					event_id     UUID,
+					consent_id   UUID REFERENCES consents(consent_id) ON DELETE CASCADE,
+					method       TEXT NOT NULL,
+					path         TEXT NOT NULL,
+					status       INT NOT NULL,
+					date_created TIMESTAMP NOT NULL,
+
+					PRIMARY KEY (event_id)
+				);
+
+				CREATE INDEX analytics_events_consent_idx ON analytics_events (consent_id);`,
+	},
+	{
+		Version:     54,
+		Description: "Add data fix audit trail",
+		Script: `
+				CREATE TABLE data_fixes (
+					fix_id       UUID,
+					kind         TEXT NOT NULL,
+					args         TEXT[] NOT NULL,
+					changes      JSONB NOT NULL,
+					actor        TEXT NOT NULL,
+					date_applied TIMESTAMP NOT NULL,
+
+					PRIMARY KEY (fix_id)
+				);`,
+	},
+	{
+		Version:     55,
+		Description: "Add tenants to exports",
+		Script: `
+				ALTER TABLE exports
+					ADD COLUMN tenant_id UUID REFERENCES tenants(tenant_id) ON DELETE CASCADE;
+
+				UPDATE exports AS e SET tenant_id = u.tenant_id
+					FROM users AS u WHERE u.user_id = e.user_id;`,
+	},
 }
 
 // Migrate attempts to bring the schema for db up to date with the migrations
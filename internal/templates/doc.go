@@ -0,0 +1,4 @@
+// Package templates manages the templates used to render emails, messages and
+// documents. Defaults are compiled into the binary and may be overridden at
+// runtime by versions stored in the database, separately for each tenant.
+package templates
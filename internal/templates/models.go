@@ -0,0 +1,24 @@
+package templates
+
+import "time"
+
+// Template describes a named template and whether its default is currently
+// overridden.
+type Template struct {
+	Name        string     `json:"name"`
+	Default     string     `json:"default"`
+	Override    *string    `json:"override"`
+	DateUpdated *time.Time `json:"date_updated"`
+}
+
+// UpdateTemplate is what we require from clients to override a template.
+type UpdateTemplate struct {
+	Source string `json:"source" validate:"required"`
+}
+
+// Preview is what we require from clients to render a template without
+// saving it. When Source is empty the active version of the template is used.
+type Preview struct {
+	Source string                 `json:"source"`
+	Data   map[string]interface{} `json:"data"`
+}
@@ -0,0 +1,339 @@
+package templates
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"embed"
+	htmltemplate "html/template"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// These are the templates compiled into the binary.
+const (
+	SaleRecorded   = "sale_recorded.txt"
+	SaleReversed   = "sale_reversed.txt"
+	ProductWatched = "product_watched.txt"
+	Receipt        = "receipt.html"
+)
+
+//go:embed defaults
+var defaults embed.FS
+
+// Predefined errors for known failure scenarios
+var (
+	ErrNotFound = errors.New("template not found")
+	ErrInvalid  = errors.New("template could not be parsed")
+)
+
+// executor is implemented by both text and html templates.
+type executor interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+// cached is a parsed template along with when it was loaded.
+type cached struct {
+	tmpl     executor
+	loadedAt time.Time
+}
+
+// Store renders templates by name. Each tenant may override templates for
+// itself and those who belong to no tenant for the rest of the deployment.
+// Overrides stored in the database take precedence over the compiled in
+// defaults. Parsed templates are cached for ttl so changes made by other
+// instances are picked up without a redeploy.
+type Store struct {
+	db  *sqlx.DB
+	ttl time.Duration
+
+	mu    sync.Mutex
+	cache map[string]cached
+}
+
+// NewStore constructs a Store which reloads templates older than ttl.
+func NewStore(db *sqlx.DB, ttl time.Duration) *Store {
+	s := Store{
+		db:    db,
+		ttl:   ttl,
+		cache: make(map[string]cached),
+	}
+	return &s
+}
+
+// Render executes the version of the named template active for the tenant
+// of the caller with data.
+func (s *Store) Render(ctx context.Context, name string, data interface{}) ([]byte, error) {
+	return s.RenderFor(ctx, tenantOf(ctx), name, data)
+}
+
+// RenderFor executes the version of the named template active for tenantID
+// with data, for when the template is rendered for someone other than the
+// caller such as the seller of a product. An empty tenantID means no tenant.
+func (s *Store) RenderFor(ctx context.Context, tenantID, name string, data interface{}) ([]byte, error) {
+	t, err := s.load(ctx, tenantID, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return nil, errors.Wrapf(err, "executing template %q", name)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Preview executes source as if it was the named template without saving it.
+// When source is empty the version active for the tenant of the caller is
+// used.
+func (s *Store) Preview(ctx context.Context, name string, p Preview) ([]byte, error) {
+	if p.Source == "" {
+		return s.Render(ctx, name, p.Data)
+	}
+
+	if _, err := defaults.ReadFile(path.Join("defaults", name)); err != nil {
+		return nil, ErrNotFound
+	}
+
+	t, err := parse(name, p.Source)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, p.Data); err != nil {
+		return nil, errors.Wrap(ErrInvalid, err.Error())
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Defaults gives the source of every template compiled into the binary keyed
+// by name.
+func Defaults() (map[string]string, error) {
+	entries, err := fs.ReadDir(defaults, "defaults")
+	if err != nil {
+		return nil, errors.Wrap(err, "reading default templates")
+	}
+
+	m := make(map[string]string, len(entries))
+	for _, e := range entries {
+		def, err := defaults.ReadFile(path.Join("defaults", e.Name()))
+		if err != nil {
+			return nil, errors.Wrap(err, "reading default template")
+		}
+		m[e.Name()] = string(def)
+	}
+
+	return m, nil
+}
+
+// List gives every known template along with the override of the tenant of
+// the caller, if any.
+func (s *Store) List(ctx context.Context) ([]Template, error) {
+	entries, err := fs.ReadDir(defaults, "defaults")
+	if err != nil {
+		return nil, errors.Wrap(err, "reading default templates")
+	}
+
+	var rows []struct {
+		Name        string    `db:"name"`
+		Source      string    `db:"source"`
+		DateUpdated time.Time `db:"date_updated"`
+	}
+	if tenantID := tenantOf(ctx); tenantID == "" {
+		const q = `SELECT name, source, date_updated FROM templates`
+		err = s.db.SelectContext(ctx, &rows, q)
+	} else {
+		const q = `SELECT name, source, date_updated FROM tenant_templates WHERE tenant_id = $1`
+		err = s.db.SelectContext(ctx, &rows, q, tenantID)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "selecting templates")
+	}
+
+	list := make([]Template, 0, len(entries))
+	for _, e := range entries {
+		def, err := defaults.ReadFile(path.Join("defaults", e.Name()))
+		if err != nil {
+			return nil, errors.Wrap(err, "reading default template")
+		}
+
+		t := Template{Name: e.Name(), Default: string(def)}
+		for i := range rows {
+			if rows[i].Name == t.Name {
+				t.Override = &rows[i].Source
+				t.DateUpdated = &rows[i].DateUpdated
+			}
+		}
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	return list, nil
+}
+
+// Retrieve gives a single template along with the override of the tenant of
+// the caller, if any.
+func (s *Store) Retrieve(ctx context.Context, name string) (*Template, error) {
+	list, err := s.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range list {
+		if list[i].Name == name {
+			return &list[i], nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Override replaces the named template with source for the tenant of the
+// caller. The source must parse.
+func (s *Store) Override(ctx context.Context, name string, ut UpdateTemplate, now time.Time) error {
+	if _, err := defaults.ReadFile(path.Join("defaults", name)); err != nil {
+		return ErrNotFound
+	}
+	if _, err := parse(name, ut.Source); err != nil {
+		return err
+	}
+
+	tenantID := tenantOf(ctx)
+	var err error
+	if tenantID == "" {
+		const q = `
+			INSERT INTO templates (name, source, date_updated)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (name) DO UPDATE SET
+				source = EXCLUDED.source,
+				date_updated = EXCLUDED.date_updated`
+		_, err = s.db.ExecContext(ctx, q, name, ut.Source, now.UTC())
+	} else {
+		const q = `
+			INSERT INTO tenant_templates (tenant_id, name, source, date_updated)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (tenant_id, name) DO UPDATE SET
+				source = EXCLUDED.source,
+				date_updated = EXCLUDED.date_updated`
+		_, err = s.db.ExecContext(ctx, q, tenantID, name, ut.Source, now.UTC())
+	}
+	if err != nil {
+		return errors.Wrap(err, "saving template")
+	}
+
+	s.forget(tenantID, name)
+	return nil
+}
+
+// Reset removes the override of the named template of the tenant of the
+// caller restoring its default.
+func (s *Store) Reset(ctx context.Context, name string) error {
+	tenantID := tenantOf(ctx)
+	var err error
+	if tenantID == "" {
+		const q = `DELETE FROM templates WHERE name = $1`
+		_, err = s.db.ExecContext(ctx, q, name)
+	} else {
+		const q = `DELETE FROM tenant_templates WHERE tenant_id = $1 AND name = $2`
+		_, err = s.db.ExecContext(ctx, q, tenantID, name)
+	}
+	if err != nil {
+		return errors.Wrap(err, "deleting template")
+	}
+
+	s.forget(tenantID, name)
+	return nil
+}
+
+// load returns the parsed version of the named template active for
+// tenantID, using the cache while it is fresh.
+func (s *Store) load(ctx context.Context, tenantID, name string) (executor, error) {
+	key := cacheKey(tenantID, name)
+
+	s.mu.Lock()
+	c, ok := s.cache[key]
+	s.mu.Unlock()
+	if ok && time.Since(c.loadedAt) < s.ttl {
+		return c.tmpl, nil
+	}
+
+	var source string
+	var err error
+	if tenantID == "" {
+		const q = `SELECT source FROM templates WHERE name = $1`
+		err = s.db.GetContext(ctx, &source, q, name)
+	} else {
+		const q = `SELECT source FROM tenant_templates WHERE tenant_id = $1 AND name = $2`
+		err = s.db.GetContext(ctx, &source, q, tenantID, name)
+	}
+	switch {
+	case err == sql.ErrNoRows:
+		def, err := defaults.ReadFile(path.Join("defaults", name))
+		if err != nil {
+			return nil, ErrNotFound
+		}
+		source = string(def)
+	case err != nil:
+		return nil, errors.Wrap(err, "selecting template")
+	}
+
+	t, err := parse(name, source)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing template %q", name)
+	}
+
+	s.mu.Lock()
+	s.cache[key] = cached{tmpl: t, loadedAt: time.Now()}
+	s.mu.Unlock()
+
+	return t, nil
+}
+
+// forget drops the cached version of a template of tenantID so the next
+// render reloads it.
+func (s *Store) forget(tenantID, name string) {
+	s.mu.Lock()
+	delete(s.cache, cacheKey(tenantID, name))
+	s.mu.Unlock()
+}
+
+// cacheKey is the key of the named template of tenantID in the cache.
+func cacheKey(tenantID, name string) string {
+	return tenantID + "/" + name
+}
+
+// tenantOf gives the tenant of the caller in ctx, or an empty string for
+// callers of no tenant and contexts without claims.
+func tenantOf(ctx context.Context) string {
+	claims, _ := ctx.Value(auth.Key).(auth.Claims)
+	return claims.TenantID
+}
+
+// parse compiles source as an HTML template when the name ends in .html and
+// as a text template otherwise.
+func parse(name, source string) (executor, error) {
+	var (
+		t   executor
+		err error
+	)
+	if strings.HasSuffix(name, ".html") {
+		t, err = htmltemplate.New(name).Parse(source)
+	} else {
+		t, err = texttemplate.New(name).Parse(source)
+	}
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalid, err.Error())
+	}
+	return t, nil
+}
@@ -0,0 +1,3 @@
+// Package tenant implements provisioning of tenants, the separate communities
+// hosted by one deployment.
+package tenant
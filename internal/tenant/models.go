@@ -0,0 +1,72 @@
+package tenant
+
+import (
+	"time"
+
+	"github.com/arammikayelyan/garagesale/internal/user"
+)
+
+// Tenant is a community hosted by the deployment.
+type Tenant struct {
+	ID           string    `db:"tenant_id" json:"id"`
+	Name         string    `db:"name" json:"name"`
+	Slug         string    `db:"slug" json:"slug"`
+	Currency     string    `db:"currency" json:"currency"`
+	SigningScope string    `db:"signing_scope" json:"signing_scope"`
+	DateCreated  time.Time `db:"date_created" json:"date_created"`
+	DateUpdated  time.Time `db:"date_updated" json:"date_updated"`
+}
+
+// NewTenant is what we require to provision a tenant. Categories default to
+// DefaultCategories when left out.
+type NewTenant struct {
+	Name       string   `json:"name" validate:"required"`
+	Slug       string   `json:"slug" validate:"required"`
+	Currency   string   `json:"currency" validate:"omitempty,len=3,alpha"`
+	Categories []string `json:"categories"`
+	Admin      NewAdmin `json:"admin"`
+}
+
+// NewAdmin is the first administrator of a new tenant.
+type NewAdmin struct {
+	Name            string `json:"name" validate:"required"`
+	Email           string `json:"email" validate:"required,email"`
+	Password        string `json:"password" validate:"required"`
+	PasswordConfirm string `json:"password_confirm" validate:"eqfield=Password"`
+}
+
+// Provisioned is everything created for a new tenant.
+type Provisioned struct {
+	Tenant     Tenant    `json:"tenant"`
+	Admin      user.User `json:"admin"`
+	Categories []string  `json:"categories"`
+	Templates  []string  `json:"templates"`
+}
+
+// Settings configure how the API behaves for a tenant. Tenants without an
+// override of a setting get the deployment default.
+type Settings struct {
+	Currency       string          `json:"currency"`
+	Locale         string          `json:"locale"`
+	CommissionRate float64         `json:"commission_rate"`
+	Features       map[string]bool `json:"features"`
+}
+
+// FeatureInterestSignals shows how many people watch, view and are buying the
+// products of a tenant on its public pages.
+const FeatureInterestSignals = "interest_signals"
+
+// Enabled reports whether the feature toggle name is on.
+func (s Settings) Enabled(name string) bool {
+	return s.Features[name]
+}
+
+// UpdateSettings is what we require to override settings of a tenant. Fields
+// left out keep their current value; features are merged with the current
+// toggles.
+type UpdateSettings struct {
+	Currency       *string         `json:"currency" validate:"omitempty,len=3,alpha"`
+	Locale         *string         `json:"locale" validate:"omitempty,min=2"`
+	CommissionRate *float64        `json:"commission_rate" validate:"omitempty,gte=0,lte=100"`
+	Features       map[string]bool `json:"features"`
+}
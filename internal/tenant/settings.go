@@ -0,0 +1,173 @@
+package tenant
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/arammikayelyan/garagesale/internal/platform/cache"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// ErrNotFound is returned when a tenant does not exist.
+var ErrNotFound = errors.New("tenant not found")
+
+// override is a row of tenant_settings. Null columns fall back to the
+// deployment defaults.
+type override struct {
+	Currency       *string  `db:"currency"`
+	Locale         *string  `db:"locale"`
+	CommissionRate *float64 `db:"commission_rate"`
+	Features       []byte   `db:"features"`
+}
+
+// RetrieveSettings gives the settings of a tenant with its overrides applied
+// on top of defaults.
+func RetrieveSettings(ctx context.Context, db *sqlx.DB, tenantID string, defaults Settings) (Settings, error) {
+	if _, err := uuid.Parse(tenantID); err != nil {
+		return Settings{}, ErrNotFound
+	}
+
+	var exists bool
+	const qTenant = `SELECT EXISTS (SELECT 1 FROM tenants WHERE tenant_id = $1)`
+	if err := db.GetContext(ctx, &exists, qTenant, tenantID); err != nil {
+		return Settings{}, errors.Wrap(err, "selecting tenant")
+	}
+	if !exists {
+		return Settings{}, ErrNotFound
+	}
+
+	s := defaults
+	s.Features = make(map[string]bool, len(defaults.Features))
+	for k, v := range defaults.Features {
+		s.Features[k] = v
+	}
+
+	var o override
+	const q = `SELECT currency, locale, commission_rate, features FROM tenant_settings WHERE tenant_id = $1`
+	if err := db.GetContext(ctx, &o, q, tenantID); err != nil {
+		if err == sql.ErrNoRows {
+			return s, nil
+		}
+		return Settings{}, errors.Wrap(err, "selecting tenant settings")
+	}
+
+	if o.Currency != nil {
+		s.Currency = *o.Currency
+	}
+	if o.Locale != nil {
+		s.Locale = *o.Locale
+	}
+	if o.CommissionRate != nil {
+		s.CommissionRate = *o.CommissionRate
+	}
+	var features map[string]bool
+	if err := json.Unmarshal(o.Features, &features); err != nil {
+		return Settings{}, errors.Wrap(err, "decoding features")
+	}
+	for k, v := range features {
+		s.Features[k] = v
+	}
+
+	return s, nil
+}
+
+// SaveSettings changes the overrides of a tenant. Fields left out of us are
+// kept; features are merged into the existing toggles.
+func SaveSettings(ctx context.Context, db *sqlx.DB, tenantID string, us UpdateSettings, now time.Time) error {
+	if _, err := uuid.Parse(tenantID); err != nil {
+		return ErrNotFound
+	}
+
+	var currency *string
+	if us.Currency != nil {
+		c := strings.ToUpper(*us.Currency)
+		currency = &c
+	}
+
+	features := []byte("{}")
+	if len(us.Features) > 0 {
+		var err error
+		if features, err = json.Marshal(us.Features); err != nil {
+			return errors.Wrap(err, "encoding features")
+		}
+	}
+
+	const q = `
+		INSERT INTO tenant_settings
+		(tenant_id, currency, locale, commission_rate, features, date_updated)
+		SELECT $1, $2, $3, $4, $5, $6
+		WHERE EXISTS (SELECT 1 FROM tenants WHERE tenant_id = $1)
+		ON CONFLICT (tenant_id) DO UPDATE SET
+			currency        = COALESCE(EXCLUDED.currency, tenant_settings.currency),
+			locale          = COALESCE(EXCLUDED.locale, tenant_settings.locale),
+			commission_rate = COALESCE(EXCLUDED.commission_rate, tenant_settings.commission_rate),
+			features        = tenant_settings.features || EXCLUDED.features,
+			date_updated    = EXCLUDED.date_updated`
+
+	res, err := db.ExecContext(ctx, q, tenantID, currency, us.Locale, us.CommissionRate, features, now.UTC())
+	if err != nil {
+		return errors.Wrap(err, "saving tenant settings")
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// Config reads tenant settings through a short lived cache so the hot paths
+// using them do not query the database on every request.
+type Config struct {
+	db       *sqlx.DB
+	defaults Settings
+	cache    *cache.Cache
+}
+
+// NewConfig constructs a Config. Settings of users without a tenant are
+// defaults; changes made on other instances are seen after ttl.
+func NewConfig(db *sqlx.DB, defaults Settings, ttl time.Duration) *Config {
+	c := Config{
+		db:       db,
+		defaults: defaults,
+		cache:    cache.New(ttl),
+	}
+	return &c
+}
+
+// Defaults gives the settings of the deployment.
+func (c *Config) Defaults() Settings {
+	return c.defaults
+}
+
+// For gives the settings of a tenant, or the defaults when tenantID is empty.
+func (c *Config) For(ctx context.Context, tenantID string) (Settings, error) {
+	if tenantID == "" {
+		return c.defaults, nil
+	}
+	if s, ok := c.cache.Get(tenantID); ok {
+		return s.(Settings), nil
+	}
+
+	s, err := RetrieveSettings(ctx, c.db, tenantID, c.defaults)
+	if err != nil {
+		return Settings{}, err
+	}
+	c.cache.Set(tenantID, s)
+
+	return s, nil
+}
+
+// Update changes the overrides of a tenant and forgets its cached settings.
+func (c *Config) Update(ctx context.Context, tenantID string, us UpdateSettings, now time.Time) (Settings, error) {
+	if err := SaveSettings(ctx, c.db, tenantID, us, now); err != nil {
+		return Settings{}, err
+	}
+	c.cache.Delete(tenantID)
+
+	return c.For(ctx, tenantID)
+}
@@ -0,0 +1,147 @@
+package tenant
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/arammikayelyan/garagesale/internal/product"
+	"github.com/arammikayelyan/garagesale/internal/templates"
+	"github.com/arammikayelyan/garagesale/internal/user"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// Predefined errors for known failure scenarios
+var (
+	ErrInvalidSlug = errors.New("slug must be lowercase letters, digits and dashes")
+	ErrSlugTaken   = errors.New("slug is already in use")
+)
+
+// DefaultCategories are the product categories of a tenant which does not
+// choose its own.
+var DefaultCategories = []string{
+	"Books",
+	"Clothing",
+	"Electronics",
+	"Furniture",
+	"Garden",
+	"Household",
+	"Sports",
+	"Toys",
+}
+
+// uniqueViolation is the Postgres error code for a unique constraint failing.
+const uniqueViolation = "23505"
+
+// slugPattern is what a slug must look like to be used in URLs and scopes.
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// Provision creates a tenant along with its categories, its first admin and
+// its own copy of the default templates. Everything is created in one
+// transaction so a failing step leaves nothing behind. The admin is an admin
+// of the tenant only: their claims carry its ID, which keeps them to its rows
+// and out of the routes of the platform operators.
+func Provision(ctx context.Context, db *sqlx.DB, nt NewTenant, now time.Time) (*Provisioned, error) {
+	if !slugPattern.MatchString(nt.Slug) {
+		return nil, ErrInvalidSlug
+	}
+
+	now = now.UTC()
+
+	t := Tenant{
+		ID:           uuid.New().String(),
+		Name:         nt.Name,
+		Slug:         nt.Slug,
+		Currency:     strings.ToUpper(nt.Currency),
+		SigningScope: "tenants/" + nt.Slug,
+		DateCreated:  now,
+		DateUpdated:  now,
+	}
+	if t.Currency == "" {
+		t.Currency = product.DefaultCurrency
+	}
+
+	categories := nt.Categories
+	if len(categories) == 0 {
+		categories = DefaultCategories
+	}
+
+	defaults, err := templates.Defaults()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	const q = `
+		INSERT INTO tenants
+		(tenant_id, name, slug, currency, signing_scope, date_created, date_updated)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	if _, err := tx.ExecContext(ctx, q, t.ID, t.Name, t.Slug, t.Currency, t.SigningScope, t.DateCreated, t.DateUpdated); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == uniqueViolation {
+			return nil, ErrSlugTaken
+		}
+		return nil, errors.Wrap(err, "inserting tenant")
+	}
+
+	const qCategory = `
+		INSERT INTO tenant_categories (tenant_id, name)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING`
+	for _, c := range categories {
+		if _, err := tx.ExecContext(ctx, qCategory, t.ID, c); err != nil {
+			return nil, errors.Wrapf(err, "inserting category %q", c)
+		}
+	}
+
+	nu := user.NewUser{
+		Name:            nt.Admin.Name,
+		Email:           nt.Admin.Email,
+		Roles:           []auth.Role{auth.RoleAdmin, auth.RoleUser},
+		Password:        nt.Admin.Password,
+		PasswordConfirm: nt.Admin.PasswordConfirm,
+		TenantID:        t.ID,
+	}
+	admin, err := user.Create(ctx, tx, nu, now)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(defaults))
+	for name := range defaults {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	const qTemplate = `
+		INSERT INTO tenant_templates (tenant_id, name, source, date_updated)
+		VALUES ($1, $2, $3, $4)`
+	for _, name := range names {
+		if _, err := tx.ExecContext(ctx, qTemplate, t.ID, name, defaults[name], now); err != nil {
+			return nil, errors.Wrapf(err, "inserting template %q", name)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing tenant")
+	}
+
+	p := Provisioned{
+		Tenant:     t,
+		Admin:      *admin,
+		Categories: categories,
+		Templates:  names,
+	}
+
+	return &p, nil
+}
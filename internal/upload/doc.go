@@ -0,0 +1,5 @@
+// Package upload screens files uploaded by users, such as product pictures
+// and CSV imports, for malware before they are accepted. Every scan is
+// recorded and infected files are kept in quarantine for investigation
+// rather than being stored with the content they were meant for.
+package upload
@@ -0,0 +1,18 @@
+package upload
+
+import "time"
+
+// Scan records the scan of an uploaded file. Kind says what the file was
+// uploaded as, such as product_image. QuarantineKey is where an infected file
+// was kept in the quarantine store.
+type Scan struct {
+	ID            string    `db:"scan_id" json:"id"`
+	Kind          string    `db:"kind" json:"kind"`
+	UserID        string    `db:"user_id" json:"user_id"`
+	SHA256        string    `db:"sha256" json:"sha256"`
+	Size          int       `db:"size" json:"size"`
+	Clean         bool      `db:"clean" json:"clean"`
+	Threat        string    `db:"threat" json:"threat,omitempty"`
+	QuarantineKey string    `db:"quarantine_key" json:"-"`
+	DateCreated   time.Time `db:"date_created" json:"date_created"`
+}
@@ -0,0 +1,81 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/arammikayelyan/garagesale/internal/platform/blob"
+	"github.com/arammikayelyan/garagesale/internal/platform/scan"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// ErrInfected is returned for files in which the scanner found malware.
+var ErrInfected = errors.New("file was rejected by the malware scanner")
+
+// Screen scans uploads before they are accepted. A Screen without a Scanner
+// accepts everything without recording anything.
+type Screen struct {
+	Scanner    scan.Scanner
+	Quarantine blob.Store
+}
+
+// Check scans data uploaded by userID as kind and records the result. Infected
+// files are put in quarantine and ErrInfected is returned. Files are rejected
+// when they can not be scanned.
+func (s *Screen) Check(ctx context.Context, db *sqlx.DB, kind, userID string, data []byte, now time.Time) error {
+	if s == nil || s.Scanner == nil {
+		return nil
+	}
+
+	res, err := s.Scanner.Scan(ctx, data)
+	if err != nil {
+		return errors.Wrapf(err, "scanning %s", kind)
+	}
+
+	sum := sha256.Sum256(data)
+	sc := Scan{
+		ID:          uuid.New().String(),
+		Kind:        kind,
+		UserID:      userID,
+		SHA256:      hex.EncodeToString(sum[:]),
+		Size:        len(data),
+		Clean:       res.Clean,
+		Threat:      res.Threat,
+		DateCreated: now.UTC(),
+	}
+
+	if !sc.Clean && s.Quarantine != nil {
+		sc.QuarantineKey = kind + "/" + sc.ID
+		if err := s.Quarantine.Put(ctx, sc.QuarantineKey, bytes.NewReader(data)); err != nil {
+			return errors.Wrap(err, "quarantining upload")
+		}
+	}
+
+	const q = `
+		INSERT INTO upload_scans
+		(scan_id, kind, user_id, sha256, size, clean, threat, quarantine_key, date_created)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+	if _, err := db.ExecContext(ctx, q, sc.ID, sc.Kind, sc.UserID, sc.SHA256, sc.Size, sc.Clean, sc.Threat, sc.QuarantineKey, sc.DateCreated); err != nil {
+		return errors.Wrap(err, "recording scan")
+	}
+
+	if !sc.Clean {
+		return ErrInfected
+	}
+	return nil
+}
+
+// ListInfected gives a page of the uploads found infected, newest first.
+func ListInfected(ctx context.Context, db *sqlx.DB, limit, offset int) ([]Scan, error) {
+	list := []Scan{}
+	const q = `SELECT * FROM upload_scans WHERE NOT clean ORDER BY date_created DESC LIMIT $1 OFFSET $2`
+	if err := db.SelectContext(ctx, &list, q, limit, offset); err != nil {
+		return nil, errors.Wrap(err, "selecting infected uploads")
+	}
+	return list, nil
+}
@@ -0,0 +1,4 @@
+// Package usage meters what each tenant uses of the platform so it can be
+// billed: API calls, storage and the gross merchandise value (GMV) of its
+// sales.
+package usage
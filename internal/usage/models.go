@@ -0,0 +1,25 @@
+package usage
+
+import "time"
+
+// Usage is what a tenant used on one day. GMV is in the smallest unit of
+// Currency, the tenant's currency; sales in other currencies are not counted.
+type Usage struct {
+	TenantID     string    `db:"tenant_id" json:"tenant_id"`
+	Day          time.Time `db:"day" json:"day"`
+	APICalls     int64     `db:"api_calls" json:"api_calls"`
+	StorageBytes int64     `db:"storage_bytes" json:"storage_bytes"`
+	GMV          int64     `db:"gmv" json:"gmv"`
+	Currency     string    `db:"currency" json:"currency"`
+}
+
+// InvoiceLine is the usage of a tenant over a month. Storage is the most the
+// tenant stored on any day of the month.
+type InvoiceLine struct {
+	TenantID     string `db:"tenant_id" json:"tenant_id"`
+	TenantName   string `db:"tenant_name" json:"tenant_name"`
+	APICalls     int64  `db:"api_calls" json:"api_calls"`
+	StorageBytes int64  `db:"storage_bytes" json:"storage_bytes"`
+	GMV          int64  `db:"gmv" json:"gmv"`
+	Currency     string `db:"currency" json:"currency"`
+}
@@ -0,0 +1,182 @@
+package usage
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// Meter counts API calls per tenant in memory and periodically writes them
+// to the database along with the storage and GMV of every tenant.
+type Meter struct {
+	db *sqlx.DB
+
+	mu    sync.Mutex
+	calls map[string]int64
+}
+
+// NewMeter constructs a Meter.
+func NewMeter(db *sqlx.DB) *Meter {
+	m := Meter{
+		db:    db,
+		calls: make(map[string]int64),
+	}
+	return &m
+}
+
+// Count records an API call made for a tenant.
+func (m *Meter) Count(tenantID string) {
+	m.mu.Lock()
+	m.calls[tenantID]++
+	m.mu.Unlock()
+}
+
+// Run writes the API calls counted since the last run and measures storage
+// and GMV of the day of now for every tenant. Calls which could not be
+// written are kept for the next run.
+func (m *Meter) Run(ctx context.Context, now time.Time) error {
+	day := now.UTC().Truncate(24 * time.Hour)
+
+	m.mu.Lock()
+	calls := m.calls
+	m.calls = make(map[string]int64)
+	m.mu.Unlock()
+
+	if err := m.flush(ctx, day, calls); err != nil {
+		m.mu.Lock()
+		for t, n := range calls {
+			m.calls[t] += n
+		}
+		m.mu.Unlock()
+		return err
+	}
+
+	return m.aggregate(ctx, day)
+}
+
+// flush adds calls to the usage of day.
+func (m *Meter) flush(ctx context.Context, day time.Time, calls map[string]int64) error {
+	if len(calls) == 0 {
+		return nil
+	}
+
+	tx, err := m.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	const q = `
+		INSERT INTO tenant_usage (tenant_id, day, api_calls)
+		SELECT $1, $2, $3
+		WHERE EXISTS (SELECT 1 FROM tenants WHERE tenant_id = $1)
+		ON CONFLICT (tenant_id, day) DO UPDATE SET
+			api_calls = tenant_usage.api_calls + EXCLUDED.api_calls`
+	for tenantID, n := range calls {
+		if _, err := tx.ExecContext(ctx, q, tenantID, day, n); err != nil {
+			return errors.Wrapf(err, "recording api calls of tenant %q", tenantID)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing api calls")
+	}
+
+	return nil
+}
+
+// aggregate measures the storage used by the products of each tenant's users
+// and the GMV of their paid sales on day.
+func (m *Meter) aggregate(ctx context.Context, day time.Time) error {
+	const q = `
+		INSERT INTO tenant_usage (tenant_id, day, storage_bytes, gmv, currency)
+		SELECT
+			t.tenant_id, $1,
+			COALESCE((
+				SELECT SUM(pg_column_size(p.*))
+				FROM products AS p
+				JOIN users AS u ON u.user_id = p.user_id
+				WHERE u.tenant_id = t.tenant_id
+			), 0),
+			COALESCE((
+				SELECT SUM(s.paid)
+				FROM sales AS s
+				JOIN products AS p ON p.product_id = s.product_id
+				JOIN users AS u ON u.user_id = p.user_id
+				WHERE u.tenant_id = t.tenant_id
+					AND s.status = 'paid'
+					AND s.currency = COALESCE(ts.currency, t.currency)
+					AND s.date_created >= $1 AND s.date_created < $1 + INTERVAL '1 day'
+			), 0),
+			COALESCE(ts.currency, t.currency)
+		FROM tenants AS t
+		LEFT JOIN tenant_settings AS ts ON ts.tenant_id = t.tenant_id
+		ON CONFLICT (tenant_id, day) DO UPDATE SET
+			storage_bytes = EXCLUDED.storage_bytes,
+			gmv           = EXCLUDED.gmv,
+			currency      = EXCLUDED.currency`
+
+	if _, err := m.db.ExecContext(ctx, q, day); err != nil {
+		return errors.Wrap(err, "aggregating tenant usage")
+	}
+
+	return nil
+}
+
+// List gives the daily usage of a tenant between from and to. Either bound
+// may be zero to leave it open.
+func List(ctx context.Context, db *sqlx.DB, tenantID string, from, to time.Time) ([]Usage, error) {
+	list := []Usage{}
+
+	const q = `
+		SELECT tenant_id, day, api_calls, storage_bytes, gmv, currency
+		FROM tenant_usage
+		WHERE tenant_id = $1
+			AND ($2::timestamp IS NULL OR day >= $2)
+			AND ($3::timestamp IS NULL OR day < $3)
+		ORDER BY day`
+
+	if err := db.SelectContext(ctx, &list, q, tenantID, nullTime(from), nullTime(to)); err != nil {
+		return nil, errors.Wrap(err, "selecting tenant usage")
+	}
+
+	return list, nil
+}
+
+// Invoice gives the usage of every tenant over the month month falls in.
+func Invoice(ctx context.Context, db *sqlx.DB, month time.Time) ([]InvoiceLine, error) {
+	start := time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 1, 0)
+
+	list := []InvoiceLine{}
+
+	const q = `
+		SELECT
+			t.tenant_id, t.name AS tenant_name,
+			COALESCE(SUM(u.api_calls), 0) AS api_calls,
+			COALESCE(MAX(u.storage_bytes), 0) AS storage_bytes,
+			COALESCE(SUM(u.gmv), 0) AS gmv,
+			COALESCE(MAX(u.currency), t.currency) AS currency
+		FROM tenants AS t
+		LEFT JOIN tenant_usage AS u ON u.tenant_id = t.tenant_id
+			AND u.day >= $1 AND u.day < $2
+		GROUP BY t.tenant_id
+		ORDER BY t.name`
+
+	if err := db.SelectContext(ctx, &list, q, start, end); err != nil {
+		return nil, errors.Wrap(err, "selecting invoice lines")
+	}
+
+	return list, nil
+}
+
+// nullTime turns the zero time into NULL.
+func nullTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UTC()
+}
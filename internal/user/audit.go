@@ -0,0 +1,75 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/arammikayelyan/garagesale/internal/platform/database"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// Audit actions.
+const (
+	AuditRolesChanged = "roles_changed"
+	AuditImpersonated = "impersonated"
+)
+
+// AuditEvent is something an admin did to a user: changing their roles or
+// impersonating them.
+type AuditEvent struct {
+	Date    time.Time `db:"date" json:"date"`
+	Action  string    `db:"action" json:"action"`
+	ActorID string    `db:"actor_id" json:"actor_id"`
+	UserID  string    `db:"user_id" json:"user_id"`
+	Detail  string    `db:"detail" json:"detail"`
+}
+
+// StreamAudit calls fn with each audit event from from up to to, oldest first.
+// A zero from or to leaves that end of the range open. When fn returns an
+// error streaming stops and the error is returned. Only events about users of
+// the tenant of the caller are included.
+func StreamAudit(ctx context.Context, db *sqlx.DB, from, to time.Time, fn func(AuditEvent) error) error {
+	q := `
+		SELECT * FROM (
+			SELECT date_created AS date, $1::text AS action, changed_by::text AS actor_id, user_id::text AS user_id,
+				array_to_string(old_roles, ' ') || ' -> ' || array_to_string(new_roles, ' ') AS detail
+			FROM role_changes
+			UNION ALL
+			SELECT date_created, $2::text, admin_id::text, user_id::text,
+				'until ' || to_char(date_expires, 'YYYY-MM-DD"T"HH24:MI:SS"Z"')
+			FROM impersonations
+		) AS a
+		JOIN users AS u ON u.user_id::text = a.user_id
+		WHERE ` + database.InTenant("u.tenant_id", 3)
+	args := []interface{}{AuditRolesChanged, AuditImpersonated, auth.TenantScope(ctx)}
+	if !from.IsZero() {
+		args = append(args, from.UTC())
+		q += fmt.Sprintf(" AND a.date >= $%d", len(args))
+	}
+	if !to.IsZero() {
+		args = append(args, to.UTC())
+		q += fmt.Sprintf(" AND a.date < $%d", len(args))
+	}
+	q += " ORDER BY a.date"
+
+	rows, err := db.QueryxContext(ctx, q, args...)
+	if err != nil {
+		return errors.Wrap(err, "selecting audit events")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e AuditEvent
+		if err := rows.StructScan(&e); err != nil {
+			return errors.Wrap(err, "scanning audit event")
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+
+	return errors.Wrap(rows.Err(), "reading audit events")
+}
@@ -0,0 +1,97 @@
+package user
+
+import (
+	"context"
+	"time"
+
+	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/arammikayelyan/garagesale/internal/platform/database"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// Deactivate stops a user from signing in while keeping their account and
+// history. They are signed out everywhere, their access tokens being added to
+// revocations. When hideProducts is set the products they sell are left out
+// of listings until they are reactivated.
+func Deactivate(ctx context.Context, db *sqlx.DB, revocations auth.RevocationList, id string, hideProducts bool, now time.Time) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrInvalidID
+	}
+	now = now.UTC()
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	q := `
+		UPDATE users SET active = FALSE, date_deactivated = $2, date_updated = $2
+		WHERE user_id = $1 AND ` + database.InTenant("tenant_id", 3)
+	res, err := tx.ExecContext(ctx, q, id, now, auth.TenantScope(ctx))
+	if err != nil {
+		return errors.Wrapf(err, "deactivating user %q", id)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return errors.Wrap(err, "counting deactivated users")
+	} else if n == 0 {
+		return ErrNotFound
+	}
+
+	if hideProducts {
+		const qHide = `UPDATE products SET hidden = TRUE WHERE user_id = $1`
+		if _, err := tx.ExecContext(ctx, qHide, id); err != nil {
+			return errors.Wrap(err, "hiding products")
+		}
+	}
+
+	if err := RevokeRefresh(ctx, tx, revocations, id, now); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing deactivation")
+	}
+
+	return nil
+}
+
+// Reactivate lets a deactivated user sign in again and lists the products
+// hidden when they were deactivated again.
+func Reactivate(ctx context.Context, db *sqlx.DB, id string, now time.Time) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrInvalidID
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	q := `
+		UPDATE users SET active = TRUE, date_deactivated = NULL, date_updated = $2
+		WHERE user_id = $1 AND ` + database.InTenant("tenant_id", 3)
+	res, err := tx.ExecContext(ctx, q, id, now.UTC(), auth.TenantScope(ctx))
+	if err != nil {
+		return errors.Wrapf(err, "reactivating user %q", id)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return errors.Wrap(err, "counting reactivated users")
+	} else if n == 0 {
+		return ErrNotFound
+	}
+
+	const qShow = `UPDATE products SET hidden = FALSE WHERE user_id = $1`
+	if _, err := tx.ExecContext(ctx, qShow, id); err != nil {
+		return errors.Wrap(err, "showing products")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing reactivation")
+	}
+
+	return nil
+}
@@ -0,0 +1,125 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/arammikayelyan/garagesale/internal/platform/oidc"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// ErrIdentityConflict occurs when an external identity has the email address
+// of an existing user but the provider has not verified the address, so the
+// two can not be linked.
+var ErrIdentityConflict = errors.New("email is in use by an account not linked to this identity")
+
+// AuthenticateExternal signs in the user an external identity belongs to. An
+// identity seen for the first time is linked to the user with the same
+// verified email address, or else a new user is created for it. Users with
+// two-factor authentication enabled must also provide a code, as when signing
+// in with a password, and wrong codes count following lock. It returns claims
+// which expire after ttl.
+func AuthenticateExternal(ctx context.Context, db *sqlx.DB, now time.Time, ttl time.Duration, lock Lockout, id oidc.Identity, code string) (auth.Claims, error) {
+	now = now.UTC()
+
+	var u User
+	const q = `
+		SELECT u.* FROM user_identities AS i
+		JOIN users AS u ON u.user_id = i.user_id
+		WHERE i.issuer = $1 AND i.subject = $2`
+	err := db.GetContext(ctx, &u, q, id.Issuer, id.Subject)
+	switch {
+	case err == sql.ErrNoRows:
+		lu, err := linkIdentity(ctx, db, id, now)
+		if err != nil {
+			return auth.Claims{}, err
+		}
+		u = *lu
+	case err != nil:
+		return auth.Claims{}, errors.Wrap(err, "selecting identity")
+	}
+
+	if u.LockedUntil != nil && now.Before(*u.LockedUntil) {
+		return auth.Claims{}, ErrAccountLocked
+	}
+	if !u.Active {
+		return auth.Claims{}, ErrAccountDeactivated
+	}
+
+	if err := verifyMFA(ctx, db, u.ID, code, lock, now); err != nil {
+		return auth.Claims{}, err
+	}
+
+	if err := recordLogin(ctx, db, u.ID, now); err != nil {
+		return auth.Claims{}, err
+	}
+
+	return claimsFor(u, now, ttl)
+}
+
+// linkIdentity links an external identity to a user, creating the user when
+// none has its email address.
+func linkIdentity(ctx context.Context, db *sqlx.DB, id oidc.Identity, now time.Time) (*User, error) {
+	if id.Email == "" {
+		return nil, ErrAuthenticationFailure
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	var u User
+	err = tx.GetContext(ctx, &u, `SELECT * FROM users WHERE email = $1 FOR UPDATE`, id.Email)
+	switch {
+	case err == sql.ErrNoRows:
+		password, err := GeneratePassword(ctx)
+		if err != nil {
+			return nil, err
+		}
+		name := id.Name
+		if name == "" {
+			name = id.Email
+		}
+		nu := NewUser{
+			Name:            name,
+			Email:           id.Email,
+			Roles:           []auth.Role{auth.RoleUser},
+			Password:        password,
+			PasswordConfirm: password,
+		}
+		created, err := Create(ctx, tx, nu, now)
+		if err != nil {
+			return nil, err
+		}
+		u = *created
+	case err != nil:
+		return nil, errors.Wrap(err, "selecting user")
+	case !id.EmailVerified:
+		return nil, ErrIdentityConflict
+	}
+
+	if id.EmailVerified && u.DateVerified == nil {
+		if err := MarkVerified(ctx, tx, u.ID, now); err != nil {
+			return nil, err
+		}
+		u.DateVerified = &now
+	}
+
+	const q = `
+		INSERT INTO user_identities (issuer, subject, user_id, date_created)
+		VALUES ($1, $2, $3, $4)`
+	if _, err := tx.ExecContext(ctx, q, id.Issuer, id.Subject, u.ID, now); err != nil {
+		return nil, errors.Wrap(err, "inserting identity")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing identity")
+	}
+
+	return &u, nil
+}
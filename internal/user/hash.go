@@ -0,0 +1,97 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// HashParams are the cost parameters of argon2id password hashes. Memory is
+// in KiB.
+type HashParams struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+}
+
+// defaultHashing is how new password hashes are made unless the Credentials
+// of a context say otherwise. Hashes made with other parameters, or with
+// bcrypt before argon2id was used, still verify and are replaced when their
+// user next signs in.
+var defaultHashing = HashParams{Time: 1, Memory: 64 * 1024, Threads: 2}
+
+const (
+	argon2Prefix  = "$argon2id$"
+	argon2SaltLen = 16
+	argon2KeyLen  = 32
+)
+
+// hashPassword hashes password with argon2id following the hashing of the
+// Credentials in ctx. The hash is in the PHC string format, which records the
+// parameters used.
+func hashPassword(ctx context.Context, password string) ([]byte, error) {
+	p := credentialsOf(ctx).Hashing
+
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, errors.Wrap(err, "generating salt")
+	}
+	key := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, argon2KeyLen)
+
+	enc := base64.RawStdEncoding
+	hash := fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2Prefix, argon2.Version, p.Memory, p.Time, p.Threads,
+		enc.EncodeToString(salt), enc.EncodeToString(key))
+
+	return []byte(hash), nil
+}
+
+// checkHash reports whether password matches hash and, when it does, whether
+// hash should be replaced by one following the hashing of the Credentials in
+// ctx.
+func checkHash(ctx context.Context, hash []byte, password string) (ok, rehash bool) {
+	if !bytes.HasPrefix(hash, []byte(argon2Prefix)) {
+		if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+			return false, false
+		}
+		return true, true
+	}
+
+	parts := strings.Split(string(hash[len(argon2Prefix):]), "$")
+	if len(parts) != 4 {
+		return false, false
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[0], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, false
+	}
+	var p HashParams
+	if _, err := fmt.Sscanf(parts[1], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil {
+		return false, false
+	}
+
+	enc := base64.RawStdEncoding
+	salt, err := enc.DecodeString(parts[2])
+	if err != nil {
+		return false, false
+	}
+	key, err := enc.DecodeString(parts[3])
+	if err != nil {
+		return false, false
+	}
+
+	other := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return false, false
+	}
+	return true, p != credentialsOf(ctx).Hashing
+}
@@ -0,0 +1,48 @@
+package user
+
+import (
+	"context"
+	"time"
+
+	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// ErrImpersonateAdmin occurs when an admin tries to act as another admin,
+// which would hide who did what without giving them any more access.
+var ErrImpersonateAdmin = errors.New("admins can not be impersonated")
+
+// Impersonate gives claims letting the admin with adminID act as the user
+// with id for ttl. The admin is named in the act claim and every
+// impersonation is recorded.
+func Impersonate(ctx context.Context, db *sqlx.DB, adminID, id string, ttl time.Duration, now time.Time) (auth.Claims, error) {
+	u, err := Retrieve(ctx, db, id)
+	if err != nil {
+		return auth.Claims{}, err
+	}
+	if !u.Active {
+		return auth.Claims{}, ErrAccountDeactivated
+	}
+
+	now = now.UTC()
+	claims, err := claimsFor(*u, now, ttl)
+	if err != nil {
+		return auth.Claims{}, err
+	}
+	if claims.HasRole(auth.RoleAdmin) || u.ID == adminID {
+		return auth.Claims{}, ErrImpersonateAdmin
+	}
+	claims.Act = &auth.Actor{Subject: adminID}
+
+	const q = `
+		INSERT INTO impersonations
+		(impersonation_id, admin_id, user_id, token_id, date_created, date_expires)
+		VALUES ($1, $2, $3, $4, $5, $6)`
+	if _, err := db.ExecContext(ctx, q, uuid.New().String(), adminID, u.ID, claims.Id, now, now.Add(ttl)); err != nil {
+		return auth.Claims{}, errors.Wrap(err, "recording impersonation")
+	}
+
+	return claims, nil
+}
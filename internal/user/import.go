@@ -0,0 +1,210 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math/big"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	platformmail "github.com/arammikayelyan/garagesale/internal/platform/mail"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// tempPasswordLength is the length of the passwords generated for imported
+// users.
+const tempPasswordLength = 16
+
+// ParseImport reads users to import from CSV with a header row. The name and
+// email columns are required; the optional roles column lists roles separated
+// by spaces or semicolons and defaults to USER. Rows which can not be read are
+// returned as failures.
+func ParseImport(r io.Reader) ([]ImportRow, []ImportFailure, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "reading header")
+	}
+	cols := make(map[string]int)
+	for i, h := range header {
+		cols[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	for _, c := range []string{"name", "email"} {
+		if _, ok := cols[c]; !ok {
+			return nil, nil, errors.Errorf("header is missing the %s column", c)
+		}
+	}
+	cr.FieldsPerRecord = -1
+
+	var rows []ImportRow
+	var failed []ImportFailure
+	for line := 2; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "reading line %d", line)
+		}
+
+		// Trailing empty columns may be left out.
+		field := func(name string) string {
+			if i, ok := cols[name]; ok && i < len(rec) {
+				return strings.TrimSpace(rec[i])
+			}
+			return ""
+		}
+
+		row := ImportRow{
+			Line:  line,
+			Name:  field("name"),
+			Email: field("email"),
+			Roles: []auth.Role{auth.RoleUser},
+		}
+
+		if v := field("roles"); v != "" {
+			names := strings.FieldsFunc(v, func(r rune) bool { return r == ';' || r == ' ' })
+			roles, err := auth.ParseRoles(names)
+			if err != nil {
+				failed = append(failed, ImportFailure{Line: line, Email: row.Email, Reason: err.Error()})
+				continue
+			}
+			row.Roles = roles
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, failed, nil
+}
+
+// Import creates a user for every row with a generated temporary password.
+// Each user is created on its own so a failing row, such as one with an email
+// already in use, is reported without stopping the import and the import can
+// be run again after fixing it. The users belong to tenantID, or to no tenant
+// when it is empty.
+func Import(ctx context.Context, db *sqlx.DB, tenantID string, rows []ImportRow, now time.Time) ([]Imported, []ImportFailure, error) {
+	var created []Imported
+	var failed []ImportFailure
+
+	for _, row := range rows {
+		if row.Name == "" {
+			failed = append(failed, ImportFailure{Line: row.Line, Email: row.Email, Reason: "name is required"})
+			continue
+		}
+		if _, err := mail.ParseAddress(row.Email); err != nil {
+			failed = append(failed, ImportFailure{Line: row.Line, Email: row.Email, Reason: "email is not valid"})
+			continue
+		}
+
+		password, err := GeneratePassword(ctx)
+		if err != nil {
+			return created, failed, err
+		}
+
+		nu := NewUser{
+			Name:            row.Name,
+			Email:           row.Email,
+			Roles:           row.Roles,
+			Password:        password,
+			PasswordConfirm: password,
+			TenantID:        tenantID,
+		}
+		u, err := Create(ctx, db, nu, now)
+		if err != nil {
+			if err == ErrEmailTaken {
+				failed = append(failed, ImportFailure{Line: row.Line, Email: row.Email, Reason: err.Error()})
+				continue
+			}
+			return created, failed, errors.Wrapf(err, "importing line %d", row.Line)
+		}
+
+		created = append(created, Imported{User: *u, Password: password})
+	}
+
+	return created, failed, nil
+}
+
+// GeneratePassword makes a random password which passes CheckPassword under
+// the Credentials in ctx.
+func GeneratePassword(ctx context.Context) (string, error) {
+	policy := credentialsOf(ctx).Passwords
+
+	const (
+		upper   = "ABCDEFGHJKLMNPQRSTUVWXYZ"
+		lower   = "abcdefghijkmnopqrstuvwxyz"
+		digits  = "23456789"
+		symbols = "!#%+-=?@"
+	)
+
+	pick := func(set string) (byte, error) {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(set))))
+		if err != nil {
+			return 0, errors.Wrap(err, "generating password")
+		}
+		return set[n.Int64()], nil
+	}
+
+	// The password starts with a character of every kind the policy may
+	// require and is filled up with letters and digits.
+	guaranteed := []string{upper, lower, digits}
+	if policy.Symbol {
+		guaranteed = append(guaranteed, symbols)
+	}
+
+	length := tempPasswordLength
+	if policy.MinLength > length {
+		length = policy.MinLength
+	}
+
+	b := make([]byte, length)
+	for i := range b {
+		set := upper + lower + digits
+		if i < len(guaranteed) {
+			set = guaranteed[i]
+		}
+		c, err := pick(set)
+		if err != nil {
+			return "", err
+		}
+		b[i] = c
+	}
+
+	// Move the guaranteed characters away from the front.
+	for i := len(b) - 1; i > 0; i-- {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
+		if err != nil {
+			return "", errors.Wrap(err, "generating password")
+		}
+		j := n.Int64()
+		b[i], b[j] = b[j], b[i]
+	}
+
+	return string(b), nil
+}
+
+// Welcome builds the email telling an imported user about their account and
+// temporary password. The account is activated by following verifyLink.
+func Welcome(imp Imported, verifyLink string) platformmail.Message {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Hi %s,\n\n", imp.User.Name)
+	b.WriteString("An account was created for you on Garage Sale.\n\n")
+	fmt.Fprintf(&b, "Email: %s\n", imp.User.Email)
+	fmt.Fprintf(&b, "Temporary password: %s\n\n", imp.Password)
+	fmt.Fprintf(&b, "Activate your account by following this link:\n\n%s\n\n", verifyLink)
+	b.WriteString("Then sign in and change your password right away.\n")
+
+	return platformmail.Message{
+		To:      imp.User.Email,
+		Subject: "Welcome to Garage Sale",
+		Body:    b.String(),
+	}
+}
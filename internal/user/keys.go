@@ -0,0 +1,82 @@
+package user
+
+import (
+	"context"
+	"crypto"
+	"time"
+
+	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// SigningKeys is the keys access tokens are signed with kept in the database
+// so every instance of the API shares them. The newest key which is not
+// retired signs new tokens. It implements auth.KeyStore.
+type SigningKeys struct {
+	DB *sqlx.DB
+}
+
+// Keys implements the auth.KeyStore interface.
+func (s SigningKeys) Keys(ctx context.Context) (auth.KeySet, error) {
+	var rows []struct {
+		ID         string     `db:"key_id"`
+		PrivateKey string     `db:"private_key"`
+		Retired    *time.Time `db:"date_retired"`
+	}
+	const q = `
+		SELECT key_id, private_key, date_retired FROM signing_keys
+		WHERE date_retired IS NULL OR date_retired > $1
+		ORDER BY date_created DESC`
+	if err := s.DB.SelectContext(ctx, &rows, q, time.Now().UTC()); err != nil {
+		return auth.KeySet{}, errors.Wrap(err, "selecting signing keys")
+	}
+
+	set := auth.KeySet{Public: make(map[string]crypto.PublicKey)}
+	for _, row := range rows {
+		key, err := auth.ParsePrivateKey([]byte(row.PrivateKey))
+		if err != nil {
+			return auth.KeySet{}, errors.Wrapf(err, "parsing signing key %q", row.ID)
+		}
+		set.Public[row.ID] = key.Public()
+		if set.Active == nil && row.Retired == nil {
+			set.ActiveKID = row.ID
+			set.Active = key
+		}
+	}
+	if set.Active == nil {
+		return auth.KeySet{}, errors.New("no signing key; run sales-admin keys rotate")
+	}
+
+	return set, nil
+}
+
+// RotateSigningKey makes key with the id kid the one new tokens are signed
+// with. The keys used until now are retired after retire, once the tokens
+// they signed expired.
+func RotateSigningKey(ctx context.Context, db *sqlx.DB, kid string, key crypto.Signer, retire time.Duration, now time.Time) error {
+	pemKey, err := auth.MarshalPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting key rotation")
+	}
+	defer tx.Rollback()
+
+	const qRetire = `UPDATE signing_keys SET date_retired = $1 WHERE date_retired IS NULL`
+	if _, err := tx.ExecContext(ctx, qRetire, now.Add(retire).UTC()); err != nil {
+		return errors.Wrap(err, "retiring signing keys")
+	}
+
+	const qInsert = `
+		INSERT INTO signing_keys (key_id, private_key, date_created)
+		VALUES ($1, $2, $3)`
+	if _, err := tx.ExecContext(ctx, qInsert, kid, string(pemKey), now.UTC()); err != nil {
+		return errors.Wrap(err, "inserting signing key")
+	}
+
+	return errors.Wrap(tx.Commit(), "committing key rotation")
+}
@@ -0,0 +1,110 @@
+package user
+
+import (
+	"context"
+	"time"
+
+	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/arammikayelyan/garagesale/internal/platform/database"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// Predefined errors for merging users.
+var (
+	ErrSameUser       = errors.New("a user can not be merged into themselves")
+	ErrTenantMismatch = errors.New("users of different tenants can not be merged")
+)
+
+// MergeReport tells how many records of each kind Merge moved from one user
+// to the other.
+type MergeReport struct {
+	FromID string       `json:"from_id"`
+	IntoID string       `json:"into_id"`
+	Moved  []MergeCount `json:"moved"`
+}
+
+// MergeCount is the number of records of a kind moved by Merge.
+type MergeCount struct {
+	Kind string `json:"kind"`
+	Rows int64  `json:"rows"`
+}
+
+// mergeMoves are the statements moving each kind of record from the user $1
+// to the user $2. Watches the other user already has are dropped instead.
+var mergeMoves = []struct {
+	kind string
+	q    string
+}{
+	{"products", `UPDATE products SET user_id = $2 WHERE user_id = $1`},
+	{"purchases", `UPDATE sales SET buyer_id = $2 WHERE buyer_id = $1`},
+	{"coupons", `UPDATE coupons SET user_id = $2 WHERE user_id = $1`},
+	{"events", `UPDATE events SET user_id = $2 WHERE user_id = $1`},
+	{"watches", `
+		UPDATE product_watches AS w SET user_id = $2
+		WHERE w.user_id = $1 AND NOT EXISTS (
+			SELECT 1 FROM product_watches WHERE product_id = w.product_id AND user_id = $2
+		)`},
+	{"sessions", `UPDATE sessions SET user_id = $2 WHERE user_id = $1`},
+	{"refresh tokens", `UPDATE refresh_tokens SET user_id = $2 WHERE user_id = $1`},
+	{"identities", `UPDATE user_identities SET user_id = $2 WHERE user_id = $1`},
+	{"recommendation feedback", `UPDATE recommendation_feedback SET user_id = $2 WHERE user_id = $1`},
+	{"fraud decisions", `UPDATE fraud_decisions SET user_id = $2 WHERE user_id = $1`},
+	{"chargebacks", `UPDATE chargebacks SET seller_id = $2 WHERE seller_id = $1`},
+}
+
+// Merge moves what the user fromID sells, bought, watches and is signed in
+// with to the user intoID and deactivates fromID, such as when a typo in an
+// email address left someone with two accounts. Both users must be of the
+// same tenant, and the tenant of the caller when they are scoped to one. It
+// runs within tx so the caller decides whether it commits.
+func Merge(ctx context.Context, tx *sqlx.Tx, fromID, intoID string, now time.Time) (*MergeReport, error) {
+	if _, err := uuid.Parse(fromID); err != nil {
+		return nil, ErrInvalidID
+	}
+	if _, err := uuid.Parse(intoID); err != nil {
+		return nil, ErrInvalidID
+	}
+	if fromID == intoID {
+		return nil, ErrSameUser
+	}
+
+	var users []struct {
+		ID       string  `db:"user_id"`
+		TenantID *string `db:"tenant_id"`
+	}
+	qLock := `SELECT user_id, tenant_id FROM users WHERE user_id IN ($1, $2) AND ` + database.InTenant("tenant_id", 3) + ` FOR UPDATE`
+	if err := tx.SelectContext(ctx, &users, qLock, fromID, intoID, auth.TenantScope(ctx)); err != nil {
+		return nil, errors.Wrap(err, "locking users")
+	}
+	if len(users) != 2 {
+		return nil, ErrNotFound
+	}
+	a, b := users[0].TenantID, users[1].TenantID
+	if (a == nil) != (b == nil) || (a != nil && *a != *b) {
+		return nil, ErrTenantMismatch
+	}
+
+	r := MergeReport{FromID: fromID, IntoID: intoID}
+	for _, m := range mergeMoves {
+		res, err := tx.ExecContext(ctx, m.q, fromID, intoID)
+		if err != nil {
+			return nil, errors.Wrapf(err, "moving %s", m.kind)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return nil, errors.Wrapf(err, "counting %s", m.kind)
+		}
+		r.Moved = append(r.Moved, MergeCount{Kind: m.kind, Rows: n})
+	}
+
+	const qDeactivate = `
+		UPDATE users SET active = FALSE, date_deactivated = $2, date_updated = $2
+		WHERE user_id = $1`
+	if _, err := tx.ExecContext(ctx, qDeactivate, fromID, now.UTC()); err != nil {
+		return nil, errors.Wrapf(err, "deactivating user %q", fromID)
+	}
+
+	return &r, nil
+}
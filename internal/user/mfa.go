@@ -0,0 +1,285 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/arammikayelyan/garagesale/internal/platform/totp"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrMFARequired occurs when a user with two-factor authentication
+	// enabled authenticates without a code.
+	ErrMFARequired = errors.New("two-factor code is required")
+
+	// ErrInvalidMFACode occurs when a two-factor code is wrong, expired or
+	// was already used.
+	ErrInvalidMFACode = errors.New("two-factor code is invalid")
+
+	// ErrMFAEnabled occurs when a user enrolls in two-factor authentication
+	// which is already enabled for them.
+	ErrMFAEnabled = errors.New("two-factor authentication is already enabled")
+
+	// ErrMFANotEnrolled occurs when a user confirms or disables two-factor
+	// authentication without having enrolled.
+	ErrMFANotEnrolled = errors.New("two-factor authentication is not enrolled")
+)
+
+// recoveryCodes is how many recovery codes are issued on enrollment.
+const recoveryCodes = 10
+
+// mfaSkew is how many steps the clock of a device may be off.
+const mfaSkew = 1
+
+// mfa is the two-factor authentication a user enrolled in.
+type mfa struct {
+	UserID      string     `db:"user_id"`
+	Secret      string     `db:"secret"`
+	LastStep    int64      `db:"last_step"`
+	DateCreated time.Time  `db:"date_created"`
+	DateEnabled *time.Time `db:"date_enabled"`
+}
+
+// EnrollMFA generates a new TOTP secret and recovery codes for a user. They
+// are returned to be shown to the user once; two-factor authentication is
+// only enabled once ConfirmMFA is given a code generated with the secret.
+// Enrolling again before confirming replaces the secret and codes.
+func EnrollMFA(ctx context.Context, db *sqlx.DB, id, issuer string, now time.Time) (*MFAEnrollment, error) {
+	u, err := Retrieve(ctx, db, id)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodes)
+	for i := range codes {
+		if codes[i], err = newRecoveryCode(); err != nil {
+			return nil, err
+		}
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	// Only an enrollment which was not confirmed yet may be replaced.
+	const qUpsert = `
+		INSERT INTO user_mfa (user_id, secret, last_step, date_created)
+		VALUES ($1, $2, 0, $3)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = $2, last_step = 0, date_created = $3
+		WHERE user_mfa.date_enabled IS NULL`
+	res, err := tx.ExecContext(ctx, qUpsert, id, secret, now.UTC())
+	if err != nil {
+		return nil, errors.Wrap(err, "inserting mfa secret")
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, errors.Wrap(err, "checking mfa secret")
+	} else if n == 0 {
+		return nil, ErrMFAEnabled
+	}
+
+	const qDelete = `DELETE FROM mfa_recovery_codes WHERE user_id = $1`
+	if _, err := tx.ExecContext(ctx, qDelete, id); err != nil {
+		return nil, errors.Wrap(err, "deleting recovery codes")
+	}
+	const qInsert = `INSERT INTO mfa_recovery_codes (user_id, code_hash) VALUES ($1, $2)`
+	for _, c := range codes {
+		if _, err := tx.ExecContext(ctx, qInsert, id, hashToken(c)); err != nil {
+			return nil, errors.Wrap(err, "inserting recovery code")
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing mfa enrollment")
+	}
+
+	e := MFAEnrollment{
+		Secret:        secret,
+		URL:           totp.URL(issuer, u.Email, secret),
+		RecoveryCodes: codes,
+	}
+	return &e, nil
+}
+
+// ConfirmMFA enables the two-factor authentication a user enrolled in once
+// they prove their device generates codes for the secret. Wrong codes count
+// as failed attempts following lock.
+func ConfirmMFA(ctx context.Context, db *sqlx.DB, id, code string, lock Lockout, now time.Time) error {
+	if err := checkLocked(ctx, db, id, now); err != nil {
+		return err
+	}
+
+	m, err := retrieveMFA(ctx, db, id)
+	if err != nil {
+		return err
+	}
+	if m.DateEnabled != nil {
+		return ErrMFAEnabled
+	}
+
+	step, ok := totp.Validate(m.Secret, code, now, mfaSkew)
+	if !ok {
+		return failAttempt(ctx, db, id, lock, now, ErrInvalidMFACode)
+	}
+
+	const q = `UPDATE user_mfa SET date_enabled = $2, last_step = $3 WHERE user_id = $1`
+	if _, err := db.ExecContext(ctx, q, id, now.UTC(), step); err != nil {
+		return errors.Wrap(err, "enabling mfa")
+	}
+	return nil
+}
+
+// DisableMFA turns two-factor authentication off for a user. It takes a
+// current code or a recovery code so a stolen access token is not enough.
+// Wrong codes count as failed attempts following lock so they can not be
+// guessed.
+func DisableMFA(ctx context.Context, db *sqlx.DB, id, code string, lock Lockout, now time.Time) error {
+	if err := checkLocked(ctx, db, id, now); err != nil {
+		return err
+	}
+
+	m, err := retrieveMFA(ctx, db, id)
+	if err != nil {
+		return err
+	}
+	if m.DateEnabled != nil {
+		if err := checkMFACode(ctx, db, m, code, now); err != nil {
+			if err == ErrInvalidMFACode {
+				return failAttempt(ctx, db, id, lock, now, err)
+			}
+			return err
+		}
+	}
+
+	const q = `DELETE FROM user_mfa WHERE user_id = $1`
+	if _, err := db.ExecContext(ctx, q, id); err != nil {
+		return errors.Wrap(err, "deleting mfa")
+	}
+	const qCodes = `DELETE FROM mfa_recovery_codes WHERE user_id = $1`
+	if _, err := db.ExecContext(ctx, qCodes, id); err != nil {
+		return errors.Wrap(err, "deleting recovery codes")
+	}
+	return nil
+}
+
+// verifyMFA checks the second factor of a user signing in. Users without
+// two-factor authentication enabled pass without a code. A wrong code counts
+// as a failed attempt following lock so codes can not be guessed either.
+func verifyMFA(ctx context.Context, db *sqlx.DB, id, code string, lock Lockout, now time.Time) error {
+	m, err := retrieveMFA(ctx, db, id)
+	switch {
+	case err == ErrMFANotEnrolled:
+		return nil
+	case err != nil:
+		return err
+	case m.DateEnabled == nil:
+		return nil
+	case code == "":
+		return ErrMFARequired
+	}
+	if err := checkMFACode(ctx, db, m, code, now); err != nil {
+		if err == ErrInvalidMFACode {
+			return failAttempt(ctx, db, id, lock, now, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// checkLocked fails with ErrAccountLocked while the account of a user is
+// locked after too many failed attempts.
+func checkLocked(ctx context.Context, db *sqlx.DB, id string, now time.Time) error {
+	var until *time.Time
+	const q = `SELECT locked_until FROM users WHERE user_id = $1`
+	if err := db.GetContext(ctx, &until, q, id); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		return errors.Wrapf(err, "selecting user %q", id)
+	}
+	if until != nil && now.Before(*until) {
+		return ErrAccountLocked
+	}
+	return nil
+}
+
+// checkMFACode accepts a TOTP code which was not used before or an unused
+// recovery code, which is spent.
+func checkMFACode(ctx context.Context, db *sqlx.DB, m *mfa, code string, now time.Time) error {
+	code = strings.TrimSpace(code)
+
+	if step, ok := totp.Validate(m.Secret, code, now, mfaSkew); ok {
+		// Moving last_step forward fails when the code or a later one was
+		// already used, so a code can not be replayed.
+		const q = `UPDATE user_mfa SET last_step = $2 WHERE user_id = $1 AND last_step < $2`
+		res, err := db.ExecContext(ctx, q, m.UserID, step)
+		if err != nil {
+			return errors.Wrap(err, "recording mfa code")
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return errors.Wrap(err, "recording mfa code")
+		}
+		if n == 0 {
+			return ErrInvalidMFACode
+		}
+		return nil
+	}
+
+	const q = `
+		UPDATE mfa_recovery_codes SET date_used = $3
+		WHERE user_id = $1 AND code_hash = $2 AND date_used IS NULL`
+	res, err := db.ExecContext(ctx, q, m.UserID, hashToken(strings.ToLower(code)), now.UTC())
+	if err != nil {
+		return errors.Wrap(err, "using recovery code")
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "using recovery code")
+	}
+	if n == 0 {
+		return ErrInvalidMFACode
+	}
+	return nil
+}
+
+// retrieveMFA gets the two-factor authentication of a user.
+func retrieveMFA(ctx context.Context, db *sqlx.DB, id string) (*mfa, error) {
+	var m mfa
+	const q = `SELECT * FROM user_mfa WHERE user_id = $1`
+	if err := db.GetContext(ctx, &m, q, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrMFANotEnrolled
+		}
+		return nil, errors.Wrap(err, "selecting mfa")
+	}
+	return &m, nil
+}
+
+// newRecoveryCode generates a recovery code in the form xxxxx-xxxxx.
+func newRecoveryCode() (string, error) {
+	const alphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+	b := make([]byte, 10)
+	for i := range b {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(alphabet))))
+		if err != nil {
+			return "", errors.Wrap(err, "generating recovery code")
+		}
+		b[i] = alphabet[n.Int64()]
+	}
+	return string(b[:5]) + "-" + string(b[5:]), nil
+}
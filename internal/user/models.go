@@ -3,6 +3,7 @@ package user
 import (
 	"time"
 
+	"github.com/arammikayelyan/garagesale/internal/platform/auth"
 	"github.com/lib/pq"
 )
 
@@ -11,17 +12,194 @@ type User struct {
 	ID           string         `db:"user_id" json:"id"`
 	Name         string         `db:"name" json:"name"`
 	Email        string         `db:"email" json:"email"`
+	DisplayName  *string        `db:"display_name" json:"display_name"`
+	Phone        *string        `db:"phone" json:"phone"`
+	AvatarURL    *string        `db:"avatar_url" json:"avatar_url"`
 	Roles        pq.StringArray `db:"roles" json:"roles"`
 	PasswordHash []byte         `db:"password_hash" json:"-"`
+	DateVerified *time.Time     `db:"date_verified" json:"date_verified"`
+	TenantID     *string        `db:"tenant_id" json:"tenant_id,omitempty"`
+	FailedLogins int            `db:"failed_logins" json:"-"`
+	LockedUntil  *time.Time     `db:"locked_until" json:"-"`
+	Active       bool           `db:"active" json:"active"`
+	LastLogin    *time.Time     `db:"last_login" json:"last_login"`
 	DateCreated  time.Time      `db:"date_created" json:"date_created"`
 	DateUpdated  time.Time      `db:"date_updated" json:"date_updated"`
+
+	DateDeactivated *time.Time `db:"date_deactivated" json:"date_deactivated,omitempty"`
+}
+
+// UpdateUser defines what information may be provided to modify an existing
+// User. All fields are optional so clients can send just the fields they want
+// changed. Roles are changed with SetRoles so every change is audited.
+type UpdateUser struct {
+	Name            *string `json:"name"`
+	Email           *string `json:"email" validate:"omitempty,email"`
+	Password        *string `json:"password"`
+	PasswordConfirm *string `json:"password_confirm" validate:"required_with=Password,omitempty,eqfield=Password"`
+}
+
+// UpdateProfile is what a user may change of their own profile. Fields left
+// out are kept and an empty string clears a field. Phone numbers are in E.164
+// form, such as +14155550123.
+type UpdateProfile struct {
+	DisplayName *string `json:"display_name" validate:"omitempty,max=100"`
+	Phone       *string `json:"phone" validate:"omitempty,e164"`
+	AvatarURL   *string `json:"avatar_url" validate:"omitempty,url,max=2048"`
+}
+
+// ChangePasswordRequest is what a user provides to change their own
+// password.
+type ChangePasswordRequest struct {
+	CurrentPassword    string `json:"current_password" validate:"required"`
+	NewPassword        string `json:"new_password" validate:"required"`
+	NewPasswordConfirm string `json:"new_password_confirm" validate:"eqfield=NewPassword"`
+}
+
+// MFAEnrollment is what a user needs to set up two-factor authentication:
+// the TOTP secret, also as an otpauth URL for authenticator apps, and the
+// recovery codes to use when the device is lost. It is only shown once.
+type MFAEnrollment struct {
+	Secret        string   `json:"secret"`
+	URL           string   `json:"url"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// MFACode is a code a user provides to confirm or disable two-factor
+// authentication. It is a TOTP code or one of their recovery codes.
+type MFACode struct {
+	Code string `json:"code" validate:"required"`
+}
+
+// ForgotPassword is what a user provides to be sent a password reset token.
+type ForgotPassword struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+// PasswordReset is what a user provides to set a new password with a reset
+// token they were sent.
+type PasswordReset struct {
+	Token              string `json:"token" validate:"required"`
+	NewPassword        string `json:"new_password" validate:"required"`
+	NewPasswordConfirm string `json:"new_password_confirm" validate:"eqfield=NewPassword"`
+}
+
+// Filter selects the users to list. Zero fields select everyone.
+type Filter struct {
+	// Search matches users whose email or name contains it, ignoring case.
+	Search string
+
+	// Role matches users who have it.
+	Role auth.Role
+
+	// LastLoginBefore matches users who have not signed in since then.
+	LastLoginBefore time.Time
+
+	// Past starts the listing after the user at that key, the last one of
+	// the previous page.
+	Past *Key
+}
+
+// Key is the position of a user in a listing, as kept in page cursors. Users
+// are listed by email then ID, or by when they last signed in first when
+// LastLoginBefore is set.
+type Key struct {
+	LastLogin *time.Time `json:"last_login,omitempty"`
+	Email     string     `json:"email"`
+	ID        string     `json:"id"`
+}
+
+// ImportRow is a user to create in a bulk import. Line is where the row was
+// read from, for reporting.
+type ImportRow struct {
+	Line  int
+	Name  string
+	Email string
+	Roles []auth.Role
+}
+
+// Imported is a user created by an import along with the temporary password
+// it was given.
+type Imported struct {
+	User     User
+	Password string
+}
+
+// ImportFailure explains why a row of an import was skipped.
+type ImportFailure struct {
+	Line   int    `json:"line"`
+	Email  string `json:"email,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// Verification is the token a user was emailed to verify their address.
+type Verification struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// RefreshRequest is a refresh token exchanged for a new access token.
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+// Device is what is known about the client a user signs in from.
+type Device struct {
+	UserAgent string
+	IP        string
+}
+
+// Session is a sign in of a user on a device. It lasts as long as its
+// refresh tokens can be used.
+type Session struct {
+	ID           string     `db:"session_id" json:"id"`
+	UserID       string     `db:"user_id" json:"-"`
+	UserAgent    string     `db:"user_agent" json:"user_agent"`
+	IP           string     `db:"ip" json:"ip"`
+	TokenID      *string    `db:"token_id" json:"-"`
+	TokenExpires *time.Time `db:"token_expires" json:"-"`
+	Current      bool       `db:"-" json:"current"`
+	DateCreated  time.Time  `db:"date_created" json:"date_created"`
+	DateLastUsed time.Time  `db:"date_last_used" json:"date_last_used"`
+	DateExpires  time.Time  `db:"date_expires" json:"date_expires"`
+}
+
+// UpdateRoles is what we require to replace the roles of a user.
+type UpdateRoles struct {
+	Roles []auth.Role `json:"roles" validate:"required,min=1"`
+}
+
+// NewMerge is what we require to merge a user into another.
+type NewMerge struct {
+	IntoID string `json:"into_id" validate:"required"`
+	DryRun bool   `json:"dry_run"`
+}
+
+// RoleChange is an entry of the audit trail of role changes.
+type RoleChange struct {
+	ID          string         `db:"change_id" json:"id"`
+	UserID      string         `db:"user_id" json:"user_id"`
+	ChangedBy   string         `db:"changed_by" json:"changed_by"`
+	OldRoles    pq.StringArray `db:"old_roles" json:"old_roles"`
+	NewRoles    pq.StringArray `db:"new_roles" json:"new_roles"`
+	DateCreated time.Time      `db:"date_created" json:"date_created"`
+}
+
+// ChangeKey is the position of a role change in the audit trail of a user, as
+// kept in page cursors.
+type ChangeKey struct {
+	Date time.Time `json:"date"`
+	ID   string    `json:"id"`
 }
 
 // NewUser contains information needed to create a new User.
 type NewUser struct {
-	Name            string   `json:"name" validate:"required"`
-	Email           string   `json:"email" validate:"required"`
-	Roles           []string `json:"roles" validate:"required"`
-	Password        string   `json:"password" validate:"required"`
-	PasswordConfirm string   `json:"password_confirm" validate:"eqfield=Password"`
+	Name            string      `json:"name" validate:"required"`
+	Email           string      `json:"email" validate:"required"`
+	Roles           []auth.Role `json:"roles" validate:"required"`
+	Password        string      `json:"password" validate:"required"`
+	PasswordConfirm string      `json:"password_confirm" validate:"eqfield=Password"`
+
+	// TenantID is the tenant the user belongs to. It is never read from
+	// clients but set from the tenant of whoever creates the user.
+	TenantID string `json:"-"`
 }
@@ -0,0 +1,219 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// PasswordPolicy is what new passwords must satisfy. Letter, Upper, Lower,
+// Digit and Symbol each require at least one character of their kind.
+type PasswordPolicy struct {
+	MinLength int
+	Letter    bool
+	Upper     bool
+	Lower     bool
+	Digit     bool
+	Symbol    bool
+
+	// Common holds lower cased passwords too common to be used.
+	Common map[string]bool
+}
+
+// Credentials is how a service treats passwords: how new hashes are made and
+// the policy new passwords are checked against. Services put theirs in the
+// context of their requests with WithCredentials; contexts without any get
+// DefaultCredentials.
+type Credentials struct {
+	Hashing   HashParams
+	Passwords PasswordPolicy
+}
+
+// ctxKey represents the type of context key.
+type ctxKey int
+
+// keyCredentials is how the Credentials of a service are stored in a context.
+const keyCredentials ctxKey = 1
+
+// WithCredentials gives a copy of ctx under which passwords are hashed and
+// checked following c.
+func WithCredentials(ctx context.Context, c Credentials) context.Context {
+	return context.WithValue(ctx, keyCredentials, c)
+}
+
+// credentialsOf gives the Credentials in ctx or the defaults.
+func credentialsOf(ctx context.Context) Credentials {
+	if c, ok := ctx.Value(keyCredentials).(Credentials); ok {
+		return c
+	}
+	return defaultCredentials
+}
+
+// defaultCredentials are the Credentials of contexts without any. They are
+// never changed; DefaultCredentials hands out copies.
+var defaultCredentials = Credentials{
+	Hashing: defaultHashing,
+	Passwords: PasswordPolicy{
+		MinLength: 8,
+		Letter:    true,
+		Digit:     true,
+		Common:    ParseCommonPasswords(commonPasswords),
+	},
+}
+
+// DefaultCredentials gives the Credentials used when a context has none. The
+// common passwords are a copy the caller may add to.
+func DefaultCredentials() Credentials {
+	c := defaultCredentials
+	c.Passwords.Common = make(map[string]bool, len(defaultCredentials.Passwords.Common))
+	for pw := range defaultCredentials.Passwords.Common {
+		c.Passwords.Common[pw] = true
+	}
+	return c
+}
+
+// commonPasswords is the list of common passwords refused by default.
+//
+//go:embed common_passwords.txt
+var commonPasswords string
+
+// ParseCommonPasswords reads a list of common passwords with one per line.
+// Blank lines are skipped.
+func ParseCommonPasswords(list string) map[string]bool {
+	common := make(map[string]bool)
+	for _, line := range strings.Split(list, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			common[strings.ToLower(line)] = true
+		}
+	}
+	return common
+}
+
+// WeakPasswordError is returned when a new password does not meet the
+// policy. Reasons lists every rule it breaks.
+type WeakPasswordError struct {
+	Reasons []string
+}
+
+func (e *WeakPasswordError) Error() string {
+	return "password " + strings.Join(e.Reasons, "; ")
+}
+
+// CheckPassword verifies that password is strong enough to be set for a user
+// with the given email under the password policy of the Credentials in ctx.
+// It returns a *WeakPasswordError when it is not.
+func CheckPassword(ctx context.Context, password, email string) error {
+	p := credentialsOf(ctx).Passwords
+
+	var reasons []string
+	if len([]rune(password)) < p.MinLength {
+		reasons = append(reasons, fmt.Sprintf("must be at least %d characters long", p.MinLength))
+	}
+
+	var letter, upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLetter(r):
+			letter = true
+			upper = upper || unicode.IsUpper(r)
+			lower = lower || unicode.IsLower(r)
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+	classes := []struct {
+		required, present bool
+		name              string
+	}{
+		{p.Letter, letter, "a letter"},
+		{p.Upper, upper, "an uppercase letter"},
+		{p.Lower, lower, "a lowercase letter"},
+		{p.Digit, digit, "a digit"},
+		{p.Symbol, symbol, "a symbol"},
+	}
+	for _, c := range classes {
+		if c.required && !c.present {
+			reasons = append(reasons, "must contain "+c.name)
+		}
+	}
+
+	if p.Common[strings.ToLower(password)] {
+		reasons = append(reasons, "is too common")
+	}
+	if email != "" && strings.EqualFold(password, email) {
+		reasons = append(reasons, "must not be the email address")
+	}
+
+	if len(reasons) > 0 {
+		return &WeakPasswordError{Reasons: reasons}
+	}
+	return nil
+}
+
+// ChangePassword replaces the password of a user after verifying their
+// current one. Wrong current passwords count as failed attempts following
+// lock so they can not be guessed with a stolen token. Every session of the
+// user ends along with its refresh tokens and its last access token is added
+// to revocations.
+func ChangePassword(ctx context.Context, db *sqlx.DB, revocations auth.RevocationList, id string, cp ChangePasswordRequest, lock Lockout, now time.Time) error {
+	var u User
+	const q = `SELECT * FROM users WHERE user_id = $1`
+	if err := db.GetContext(ctx, &u, q, id); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		return errors.Wrapf(err, "selecting user %q", id)
+	}
+
+	now = now.UTC()
+	if u.LockedUntil != nil && now.Before(*u.LockedUntil) {
+		return ErrAccountLocked
+	}
+
+	if ok, _ := checkHash(ctx, u.PasswordHash, cp.CurrentPassword); !ok {
+		return failAttempt(ctx, db, u.ID, lock, now, ErrAuthenticationFailure)
+	}
+
+	if err := CheckPassword(ctx, cp.NewPassword, u.Email); err != nil {
+		return err
+	}
+
+	hash, err := hashPassword(ctx, cp.NewPassword)
+	if err != nil {
+		return errors.Wrap(err, "generating password hash")
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	const qUpdate = `
+		UPDATE users SET password_hash = $2, date_updated = $3, failed_logins = 0
+		WHERE user_id = $1`
+	if _, err := tx.ExecContext(ctx, qUpdate, id, hash, now); err != nil {
+		return errors.Wrap(err, "updating password")
+	}
+
+	// Whoever knew the old password may hold refresh tokens.
+	if err := RevokeRefresh(ctx, tx, revocations, id, now); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing password change")
+	}
+
+	return nil
+}
@@ -0,0 +1,45 @@
+package user
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// SaveProfile changes the profile of a user. Fields left out are kept and
+// fields set to an empty string are cleared. It returns the updated user.
+func SaveProfile(ctx context.Context, db *sqlx.DB, id string, up UpdateProfile, now time.Time) (*User, error) {
+	u, err := Retrieve(ctx, db, id)
+	if err != nil {
+		return nil, err
+	}
+
+	set := func(dst **string, src *string) {
+		switch {
+		case src == nil:
+		case *src == "":
+			*dst = nil
+		default:
+			v := *src
+			*dst = &v
+		}
+	}
+	set(&u.DisplayName, up.DisplayName)
+	set(&u.Phone, up.Phone)
+	set(&u.AvatarURL, up.AvatarURL)
+	u.DateUpdated = now.UTC()
+
+	const q = `UPDATE users SET
+		"display_name" = $2,
+		"phone" = $3,
+		"avatar_url" = $4,
+		"date_updated" = $5
+		WHERE user_id = $1`
+	if _, err := db.ExecContext(ctx, q, id, u.DisplayName, u.Phone, u.AvatarURL, u.DateUpdated); err != nil {
+		return nil, errors.Wrap(err, "updating profile")
+	}
+
+	return u, nil
+}
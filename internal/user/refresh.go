@@ -0,0 +1,194 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidRefreshToken is returned when a refresh token is unknown, expired
+// or was already used.
+var ErrInvalidRefreshToken = errors.New("refresh token is invalid or has expired")
+
+// IssueRefresh creates a refresh token for a user who just signed in on dev.
+// It starts a session with a new family of tokens which Refresh rotates
+// through and records the session in claims, which must be the claims of the
+// access token issued along with it.
+func IssueRefresh(ctx context.Context, db *sqlx.DB, claims *auth.Claims, dev Device, ttl time.Duration, now time.Time) (string, error) {
+	now = now.UTC()
+	family := uuid.New().String()
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	token, err := issueRefresh(ctx, tx, claims.Subject, family, ttl, now)
+	if err != nil {
+		return "", err
+	}
+
+	const q = `
+		INSERT INTO sessions
+		(session_id, user_id, user_agent, ip, token_id, token_expires, date_created, date_last_used, date_expires)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $7, $8)`
+	_, err = tx.ExecContext(
+		ctx, q,
+		family, claims.Subject, dev.UserAgent, dev.IP,
+		claims.Id, time.Unix(claims.ExpiresAt, 0).UTC(),
+		now, now.Add(ttl),
+	)
+	if err != nil {
+		return "", errors.Wrap(err, "inserting session")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", errors.Wrap(err, "committing session")
+	}
+
+	claims.SessionID = family
+	return token, nil
+}
+
+// issueRefresh stores a new refresh token of family.
+func issueRefresh(ctx context.Context, db sqlx.ExecerContext, userID, family string, ttl time.Duration, now time.Time) (string, error) {
+	token, err := newToken()
+	if err != nil {
+		return "", err
+	}
+
+	const q = `
+		INSERT INTO refresh_tokens
+		(token_hash, user_id, family_id, date_expires, date_created)
+		VALUES ($1, $2, $3, $4, $5)`
+
+	now = now.UTC()
+	if _, err := db.ExecContext(ctx, q, hashToken(token), userID, family, now.Add(ttl), now); err != nil {
+		return "", errors.Wrap(err, "inserting refresh token")
+	}
+
+	return token, nil
+}
+
+// Refresh exchanges a refresh token for claims lasting accessTTL and a new
+// refresh token lasting refreshTTL. Every refresh token can be used once; a
+// token used a second time was likely stolen so its whole family is revoked
+// and the user has to sign in again.
+func Refresh(ctx context.Context, db *sqlx.DB, token string, accessTTL, refreshTTL time.Duration, now time.Time) (auth.Claims, string, error) {
+	now = now.UTC()
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return auth.Claims{}, "", errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	var rt struct {
+		UserID      string     `db:"user_id"`
+		FamilyID    string     `db:"family_id"`
+		DateExpires time.Time  `db:"date_expires"`
+		DateUsed    *time.Time `db:"date_used"`
+	}
+	const q = `
+		SELECT user_id, family_id, date_expires, date_used
+		FROM refresh_tokens
+		WHERE token_hash = $1
+		FOR UPDATE`
+	if err := tx.GetContext(ctx, &rt, q, hashToken(token)); err != nil {
+		if err == sql.ErrNoRows {
+			return auth.Claims{}, "", ErrInvalidRefreshToken
+		}
+		return auth.Claims{}, "", errors.Wrap(err, "selecting refresh token")
+	}
+
+	if rt.DateUsed != nil {
+		if err := endSession(ctx, tx, rt.FamilyID); err != nil {
+			return auth.Claims{}, "", errors.Wrap(err, "revoking reused refresh tokens")
+		}
+		if err := tx.Commit(); err != nil {
+			return auth.Claims{}, "", errors.Wrap(err, "committing revocation")
+		}
+		return auth.Claims{}, "", ErrInvalidRefreshToken
+	}
+	if !rt.DateExpires.After(now) {
+		return auth.Claims{}, "", ErrInvalidRefreshToken
+	}
+
+	const qUse = `UPDATE refresh_tokens SET date_used = $2 WHERE token_hash = $1`
+	if _, err := tx.ExecContext(ctx, qUse, hashToken(token), now); err != nil {
+		return auth.Claims{}, "", errors.Wrap(err, "using refresh token")
+	}
+
+	var u User
+	const qUser = `SELECT * FROM users WHERE user_id = $1`
+	if err := tx.GetContext(ctx, &u, qUser, rt.UserID); err != nil {
+		return auth.Claims{}, "", errors.Wrap(err, "selecting user")
+	}
+	if !u.Active {
+		return auth.Claims{}, "", ErrInvalidRefreshToken
+	}
+
+	claims, err := claimsFor(u, now, accessTTL)
+	if err != nil {
+		return auth.Claims{}, "", err
+	}
+
+	next, err := issueRefresh(ctx, tx, u.ID, rt.FamilyID, refreshTTL, now)
+	if err != nil {
+		return auth.Claims{}, "", err
+	}
+	claims.SessionID = rt.FamilyID
+
+	const qSession = `
+		UPDATE sessions SET token_id = $2, token_expires = $3, date_last_used = $4, date_expires = $5
+		WHERE session_id = $1`
+	if _, err := tx.ExecContext(ctx, qSession, rt.FamilyID, claims.Id, time.Unix(claims.ExpiresAt, 0).UTC(), now, now.Add(refreshTTL)); err != nil {
+		return auth.Claims{}, "", errors.Wrap(err, "updating session")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return auth.Claims{}, "", errors.Wrap(err, "committing refresh")
+	}
+
+	return claims, next, nil
+}
+
+// RevokeRefresh removes every refresh token and session of a user, signing
+// them out everywhere. The access tokens last issued for the sessions are
+// added to revocations, the list the Authenticator checks tokens against.
+func RevokeRefresh(ctx context.Context, db sqlx.ExtContext, revocations auth.RevocationList, userID string, now time.Time) error {
+	var sessions []Session
+	const qTokens = `
+		SELECT * FROM sessions
+		WHERE user_id = $1 AND token_id IS NOT NULL AND token_expires > $2`
+	if err := sqlx.SelectContext(ctx, db, &sessions, qTokens, userID, now.UTC()); err != nil {
+		return errors.Wrap(err, "selecting sessions")
+	}
+
+	// The tokens are revoked before the sessions end so a failure leaves
+	// the sessions in place to be revoked again.
+	for _, s := range sessions {
+		if revocations == nil {
+			return errors.New("access tokens can not be revoked without a revocation list")
+		}
+		if err := revocations.Revoke(ctx, *s.TokenID, *s.TokenExpires); err != nil {
+			return errors.Wrap(err, "revoking access token")
+		}
+	}
+
+	const q = `DELETE FROM refresh_tokens WHERE user_id = $1`
+	if _, err := db.ExecContext(ctx, q, userID); err != nil {
+		return errors.Wrap(err, "revoking refresh tokens")
+	}
+	const qSessions = `DELETE FROM sessions WHERE user_id = $1`
+	if _, err := db.ExecContext(ctx, qSessions, userID); err != nil {
+		return errors.Wrap(err, "ending sessions")
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+
+	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidResetToken is returned when a password reset token is unknown,
+// expired or was already used.
+var ErrInvalidResetToken = errors.New("reset token is invalid or has expired")
+
+// RequestReset issues a password reset token for the user with email. The
+// token is returned to be sent to the user; only its hash is stored and it
+// expires after ttl.
+func RequestReset(ctx context.Context, db *sqlx.DB, email string, ttl time.Duration, now time.Time) (*User, string, error) {
+	u, err := RetrieveByEmail(ctx, db, email)
+	if err != nil {
+		return nil, "", err
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	const qInsert = `
+		INSERT INTO password_resets
+		(token_hash, user_id, date_expires, date_created)
+		VALUES ($1, $2, $3, $4)`
+
+	now = now.UTC()
+	if _, err := db.ExecContext(ctx, qInsert, hashToken(token), u.ID, now.Add(ttl), now); err != nil {
+		return nil, "", errors.Wrap(err, "inserting reset token")
+	}
+
+	return u, token, nil
+}
+
+// ResetPassword sets a new password for the user a reset token was issued
+// to. The token and every other outstanding token of the user can not be used
+// again and the user is signed out everywhere, their access tokens being added
+// to revocations.
+func ResetPassword(ctx context.Context, db *sqlx.DB, revocations auth.RevocationList, pr PasswordReset, now time.Time) error {
+	now = now.UTC()
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	var u User
+	const q = `
+		SELECT u.* FROM password_resets AS r
+		JOIN users AS u ON u.user_id = r.user_id
+		WHERE r.token_hash = $1 AND r.date_used IS NULL AND r.date_expires > $2
+		FOR UPDATE OF r`
+	if err := tx.GetContext(ctx, &u, q, hashToken(pr.Token), now); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrInvalidResetToken
+		}
+		return errors.Wrap(err, "selecting reset token")
+	}
+
+	if err := CheckPassword(ctx, pr.NewPassword, u.Email); err != nil {
+		return err
+	}
+
+	hash, err := hashPassword(ctx, pr.NewPassword)
+	if err != nil {
+		return errors.Wrap(err, "generating password hash")
+	}
+
+	// Proving ownership of the email address also unlocks the account.
+	const qUpdate = `
+		UPDATE users SET password_hash = $2, date_updated = $3, failed_logins = 0, locked_until = NULL
+		WHERE user_id = $1`
+	if _, err := tx.ExecContext(ctx, qUpdate, u.ID, hash, now); err != nil {
+		return errors.Wrap(err, "updating password")
+	}
+
+	const qUse = `UPDATE password_resets SET date_used = $2 WHERE user_id = $1 AND date_used IS NULL`
+	if _, err := tx.ExecContext(ctx, qUse, u.ID, now); err != nil {
+		return errors.Wrap(err, "using reset tokens")
+	}
+
+	// Whoever knew the old password may hold refresh tokens.
+	if err := RevokeRefresh(ctx, tx, revocations, u.ID, now); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing password reset")
+	}
+
+	return nil
+}
+
+// newToken generates a random token to be sent to a user by email.
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "generating token")
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken gives the form an emailed token is stored in.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
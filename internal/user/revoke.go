@@ -0,0 +1,44 @@
+package user
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// Revocations is the list of revoked access tokens kept in the database. It
+// implements auth.RevocationList.
+type Revocations struct {
+	DB *sqlx.DB
+}
+
+// Revoke adds the token id to the list until it expires. Tokens which expired
+// already are removed at the same time.
+func (r Revocations) Revoke(ctx context.Context, id string, expires time.Time) error {
+	const q = `
+		INSERT INTO revoked_tokens (token_id, date_expires)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING`
+	if _, err := r.DB.ExecContext(ctx, q, id, expires.UTC()); err != nil {
+		return errors.Wrap(err, "revoking token")
+	}
+
+	const qPrune = `DELETE FROM revoked_tokens WHERE date_expires < $1`
+	if _, err := r.DB.ExecContext(ctx, qPrune, time.Now().UTC()); err != nil {
+		return errors.Wrap(err, "pruning revoked tokens")
+	}
+
+	return nil
+}
+
+// Revoked reports whether the token id is on the list.
+func (r Revocations) Revoked(ctx context.Context, id string) (bool, error) {
+	var revoked bool
+	const q = `SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE token_id = $1)`
+	if err := r.DB.GetContext(ctx, &revoked, q, id); err != nil {
+		return false, errors.Wrap(err, "checking revoked tokens")
+	}
+	return revoked, nil
+}
@@ -0,0 +1,120 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/arammikayelyan/garagesale/internal/platform/database"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// ErrLastAdmin is returned when a change would leave no admin.
+var ErrLastAdmin = errors.New("the last admin can not lose the ADMIN role")
+
+// SetRoles replaces the roles of a user and records who changed them from
+// what in the audit trail. Taking ADMIN away from the last admin of the
+// tenant of the user is refused.
+func SetRoles(ctx context.Context, db *sqlx.DB, id string, roles []auth.Role, changedBy string, now time.Time) (*RoleChange, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	var u struct {
+		Roles    pq.StringArray `db:"roles"`
+		TenantID *string        `db:"tenant_id"`
+	}
+	q := `SELECT roles, tenant_id FROM users WHERE user_id = $1 AND ` + database.InTenant("tenant_id", 2) + ` FOR UPDATE`
+	if err := tx.GetContext(ctx, &u, q, id, auth.TenantScope(ctx)); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrapf(err, "selecting roles of user %q", id)
+	}
+	old := u.Roles
+
+	// Lock every admin of the tenant so concurrent changes can not demote
+	// them all.
+	var admins []string
+	const qAdmins = `SELECT user_id FROM users WHERE 'ADMIN' = ANY(roles) AND tenant_id IS NOT DISTINCT FROM $1 FOR UPDATE`
+	if err := tx.SelectContext(ctx, &admins, qAdmins, u.TenantID); err != nil {
+		return nil, errors.Wrap(err, "locking admins")
+	}
+
+	isAdmin := false
+	for _, r := range roles {
+		if r == auth.RoleAdmin {
+			isAdmin = true
+		}
+	}
+	if !isAdmin && len(admins) == 1 && admins[0] == id {
+		return nil, ErrLastAdmin
+	}
+
+	rc := RoleChange{
+		ID:          uuid.New().String(),
+		UserID:      id,
+		ChangedBy:   changedBy,
+		OldRoles:    old,
+		NewRoles:    auth.Strings(roles),
+		DateCreated: now.UTC(),
+	}
+
+	const qUpdate = `UPDATE users SET roles = $2, date_updated = $3 WHERE user_id = $1`
+	if _, err := tx.ExecContext(ctx, qUpdate, id, rc.NewRoles, rc.DateCreated); err != nil {
+		return nil, errors.Wrap(err, "updating roles")
+	}
+
+	const qAudit = `
+		INSERT INTO role_changes
+		(change_id, user_id, changed_by, old_roles, new_roles, date_created)
+		VALUES ($1, $2, $3, $4, $5, $6)`
+	if _, err := tx.ExecContext(ctx, qAudit, rc.ID, rc.UserID, rc.ChangedBy, rc.OldRoles, rc.NewRoles, rc.DateCreated); err != nil {
+		return nil, errors.Wrap(err, "recording role change")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing role change")
+	}
+
+	return &rc, nil
+}
+
+// RoleChanges gives a page of the audit trail of role changes of a user,
+// newest first. When past is set the page starts after the change at that key,
+// the last one of the previous page.
+func RoleChanges(ctx context.Context, db *sqlx.DB, id string, limit, offset int, past *ChangeKey) ([]RoleChange, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	args := []interface{}{id, auth.TenantScope(ctx)}
+	q := `
+		SELECT rc.* FROM role_changes AS rc
+		JOIN users AS u ON u.user_id = rc.user_id
+		WHERE rc.user_id = $1 AND ` + database.InTenant("u.tenant_id", 2)
+	if past != nil {
+		args = append(args, past.Date.UTC(), past.ID)
+		q += fmt.Sprintf(" AND (rc.date_created, rc.change_id) < ($%d, $%d)", len(args)-1, len(args))
+	}
+	args = append(args, limit, offset)
+	q += fmt.Sprintf(" ORDER BY rc.date_created DESC, rc.change_id DESC LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+
+	list := []RoleChange{}
+	if err := db.SelectContext(ctx, &list, q, args...); err != nil {
+		return nil, errors.Wrap(err, "selecting role changes")
+	}
+
+	return list, nil
+}
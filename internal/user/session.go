@@ -0,0 +1,118 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// ErrSessionNotFound is returned when a user has no session with an ID.
+var ErrSessionNotFound = errors.New("session not found")
+
+// Sessions lists the sessions of a user which have not expired, most
+// recently used first. The session with the ID current is marked as the one
+// the request was made in.
+func Sessions(ctx context.Context, db *sqlx.DB, userID, current string, now time.Time) ([]Session, error) {
+	list := []Session{}
+	const q = `
+		SELECT * FROM sessions
+		WHERE user_id = $1 AND date_expires > $2
+		ORDER BY date_last_used DESC`
+	if err := db.SelectContext(ctx, &list, q, userID, now.UTC()); err != nil {
+		return nil, errors.Wrap(err, "selecting sessions")
+	}
+
+	for i := range list {
+		list[i].Current = list[i].ID == current
+	}
+
+	return list, nil
+}
+
+// RevokeSession ends a session of a user. Its refresh tokens can not be used
+// any more and the last access token issued for it is added to revocations,
+// the list the Authenticator checks tokens against.
+func RevokeSession(ctx context.Context, db *sqlx.DB, revocations auth.RevocationList, userID, id string, now time.Time) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrSessionNotFound
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	var s Session
+	const q = `SELECT * FROM sessions WHERE session_id = $1 AND user_id = $2 FOR UPDATE`
+	if err := tx.GetContext(ctx, &s, q, id, userID); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrSessionNotFound
+		}
+		return errors.Wrapf(err, "selecting session %q", id)
+	}
+
+	// The token is revoked before the session ends so a failure leaves the
+	// session in place to be revoked again.
+	if s.TokenID != nil && s.TokenExpires != nil && s.TokenExpires.After(now.UTC()) {
+		if revocations == nil {
+			return errors.New("access token can not be revoked without a revocation list")
+		}
+		if err := revocations.Revoke(ctx, *s.TokenID, *s.TokenExpires); err != nil {
+			return errors.Wrap(err, "revoking access token")
+		}
+	}
+
+	if err := endSession(ctx, tx, id); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing session revocation")
+	}
+
+	return nil
+}
+
+// EndSession ends the session id, such as when its user logs out, so its
+// refresh tokens can not be used any more. Ending a session which already
+// ended does nothing.
+func EndSession(ctx context.Context, db *sqlx.DB, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrSessionNotFound
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	if err := endSession(ctx, tx, id); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing session end")
+	}
+
+	return nil
+}
+
+// endSession removes a session along with its family of refresh tokens.
+func endSession(ctx context.Context, db sqlx.ExecerContext, id string) error {
+	const q = `DELETE FROM refresh_tokens WHERE family_id = $1`
+	if _, err := db.ExecContext(ctx, q, id); err != nil {
+		return errors.Wrap(err, "revoking refresh tokens")
+	}
+	const qSession = `DELETE FROM sessions WHERE session_id = $1`
+	if _, err := db.ExecContext(ctx, qSession, id); err != nil {
+		return errors.Wrap(err, "ending session")
+	}
+	return nil
+}
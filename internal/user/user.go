@@ -3,25 +3,117 @@ package user
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/arammikayelyan/garagesale/internal/platform/auth"
+	"github.com/arammikayelyan/garagesale/internal/platform/database"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/pkg/errors"
-	"golang.org/x/crypto/bcrypt"
 )
 
 var (
 	// ErrAuthenticationFailure occurs when a user attempts to authenticate
 	// but anything goes wrong.
 	ErrAuthenticationFailure = errors.New("Authentication failed")
+
+	ErrNotFound   = errors.New("user not found")
+	ErrInvalidID  = errors.New("ID is not in its proper UUID format")
+	ErrEmailTaken = errors.New("email is already in use")
+
+	// ErrAccountLocked occurs when a user attempts to authenticate while
+	// their account is locked after too many failed attempts.
+	ErrAccountLocked = errors.New("account is temporarily locked")
+
+	// ErrAccountDeactivated occurs when a user attempts to authenticate
+	// after their account was deactivated.
+	ErrAccountDeactivated = errors.New("account is deactivated")
 )
 
-// Create inserts a new user into the database.
-func Create(ctx context.Context, db *sqlx.DB, n NewUser, now time.Time) (*User, error) {
+// uniqueViolation is the Postgres error code for a unique constraint failing.
+const uniqueViolation = "23505"
+
+// List gets a page of the users matching filter ordered by email. When the
+// filter selects dormant users those who never signed in come first and then
+// the longest dormant. Only users of the tenant of the caller are listed.
+func List(ctx context.Context, db *sqlx.DB, filter Filter, limit, offset int) ([]User, error) {
+	list := []User{}
+
+	q := `SELECT * FROM users WHERE ` + database.InTenant("tenant_id", 1)
+	args := []interface{}{auth.TenantScope(ctx)}
+	if filter.Search != "" {
+		args = append(args, "%"+likeEscaper.Replace(filter.Search)+"%")
+		q += fmt.Sprintf(" AND (email ILIKE $%[1]d OR name ILIKE $%[1]d)", len(args))
+	}
+	if filter.Role != "" {
+		args = append(args, string(filter.Role))
+		q += fmt.Sprintf(" AND $%d = ANY(roles)", len(args))
+	}
+	if !filter.LastLoginBefore.IsZero() {
+		args = append(args, filter.LastLoginBefore.UTC())
+		q += fmt.Sprintf(" AND (last_login IS NULL OR last_login < $%d)", len(args))
+		if k := filter.Past; k != nil {
+			// Users who never signed in come first, so past one of them
+			// are the rest of them and everyone who did.
+			args = append(args, k.Email, k.ID)
+			if k.LastLogin == nil {
+				q += fmt.Sprintf(" AND (last_login IS NOT NULL OR (email, user_id) > ($%d, $%d))", len(args)-1, len(args))
+			} else {
+				args = append(args, k.LastLogin.UTC())
+				q += fmt.Sprintf(" AND last_login IS NOT NULL AND (last_login, email, user_id) > ($%d, $%d, $%d)", len(args), len(args)-2, len(args)-1)
+			}
+		}
+		q += " ORDER BY last_login NULLS FIRST, email, user_id"
+	} else {
+		if k := filter.Past; k != nil {
+			args = append(args, k.Email, k.ID)
+			q += fmt.Sprintf(" AND (email, user_id) > ($%d, $%d)", len(args)-1, len(args))
+		}
+		q += " ORDER BY email, user_id"
+	}
+	args = append(args, limit, offset)
+	q += fmt.Sprintf(" LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+
+	if err := db.SelectContext(ctx, &list, q, args...); err != nil {
+		return nil, errors.Wrap(err, "selecting users")
+	}
+	return list, nil
+}
+
+// likeEscaper escapes the characters LIKE patterns treat specially so searches
+// match them literally.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// Retrieve gets a single user by ID. Users of other tenants than the caller
+// are not found.
+func Retrieve(ctx context.Context, db *sqlx.DB, id string) (*User, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	var u User
+	q := `SELECT * FROM users WHERE user_id = $1 AND ` + database.InTenant("tenant_id", 2)
+	if err := db.GetContext(ctx, &u, q, id, auth.TenantScope(ctx)); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrapf(err, "selecting user %q", id)
+	}
 
-	hash, err := bcrypt.GenerateFromPassword([]byte(n.Password), bcrypt.DefaultCost)
+	return &u, nil
+}
+
+// Create inserts a new user into the database. It may run inside a
+// transaction.
+func Create(ctx context.Context, db sqlx.ExecerContext, n NewUser, now time.Time) (*User, error) {
+	if err := CheckPassword(ctx, n.Password, n.Email); err != nil {
+		return nil, err
+	}
+
+	hash, err := hashPassword(ctx, n.Password)
 	if err != nil {
 		return nil, errors.Wrap(err, "generating password hash")
 	}
@@ -31,31 +123,108 @@ func Create(ctx context.Context, db *sqlx.DB, n NewUser, now time.Time) (*User,
 		Name:         n.Name,
 		Email:        n.Email,
 		PasswordHash: hash,
-		Roles:        n.Roles,
+		Roles:        auth.Strings(n.Roles),
+		Active:       true,
 		DateCreated:  now.UTC(),
 		DateUpdated:  now.UTC(),
 	}
+	if n.TenantID != "" {
+		u.TenantID = &n.TenantID
+	}
 
 	const q = `INSERT INTO users
-		(user_id, name, email, password_hash, roles, date_created, date_updated)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+		(user_id, name, email, password_hash, roles, tenant_id, date_created, date_updated)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
 	_, err = db.ExecContext(
 		ctx, q,
 		u.ID, u.Name, u.Email,
-		u.PasswordHash, u.Roles,
+		u.PasswordHash, u.Roles, u.TenantID,
 		u.DateCreated, u.DateUpdated,
 	)
 	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == uniqueViolation {
+			return nil, ErrEmailTaken
+		}
 		return nil, errors.Wrap(err, "inserting user")
 	}
 
 	return &u, nil
 }
 
+// Update modifies data about a user. It errors if the specified ID is invalid
+// or does not reference an existing user.
+func Update(ctx context.Context, db *sqlx.DB, id string, upd UpdateUser, now time.Time) error {
+	u, err := Retrieve(ctx, db, id)
+	if err != nil {
+		return err
+	}
+
+	if upd.Name != nil {
+		u.Name = *upd.Name
+	}
+	if upd.Email != nil {
+		u.Email = *upd.Email
+	}
+	if upd.Password != nil {
+		if err := CheckPassword(ctx, *upd.Password, u.Email); err != nil {
+			return err
+		}
+		hash, err := hashPassword(ctx, *upd.Password)
+		if err != nil {
+			return errors.Wrap(err, "generating password hash")
+		}
+		u.PasswordHash = hash
+	}
+	u.DateUpdated = now.UTC()
+
+	const q = `UPDATE users SET
+		"name" = $2,
+		"email" = $3,
+		"roles" = $4,
+		"password_hash" = $5,
+		"date_updated" = $6
+		WHERE user_id = $1`
+	_, err = db.ExecContext(ctx, q, id, u.Name, u.Email, u.Roles, u.PasswordHash, u.DateUpdated)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == uniqueViolation {
+			return ErrEmailTaken
+		}
+		return errors.Wrap(err, "updating user")
+	}
+
+	return nil
+}
+
+// Delete removes a user from the database along with what belongs to them.
+// Sales they made as a buyer are kept without the link to them. Users are
+// normally deactivated instead so their history stays intact.
+func Delete(ctx context.Context, db *sqlx.DB, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrInvalidID
+	}
+
+	q := `DELETE FROM users WHERE user_id = $1 AND ` + database.InTenant("tenant_id", 2)
+	if _, err := db.ExecContext(ctx, q, id, auth.TenantScope(ctx)); err != nil {
+		return errors.Wrapf(err, "deleting user %s", id)
+	}
+
+	return nil
+}
+
+// Lockout is when accounts are locked after failed attempts to authenticate.
+// After Threshold failures in a row the account is locked for Cooldown. A
+// Threshold of zero never locks accounts.
+type Lockout struct {
+	Threshold int
+	Cooldown  time.Duration
+}
+
 // Authenticate finds a user by their email and verifies their password.
 // On success it returns a Claims value representing this user. The claims
-// can be used to generate a token for future authentication.
-func Authenticate(ctx context.Context, db *sqlx.DB, now time.Time, email, password string) (auth.Claims, error) {
+// can be used to generate a token for future authentication and expire after
+// ttl. Failed attempts are counted and lock the account following lock.
+// Users who enabled two-factor authentication must also provide a code.
+func Authenticate(ctx context.Context, db *sqlx.DB, now time.Time, ttl time.Duration, lock Lockout, email, password, code string) (auth.Claims, error) {
 
 	const q = `SELECT * FROM users WHERE email = $1`
 
@@ -71,14 +240,105 @@ func Authenticate(ctx context.Context, db *sqlx.DB, now time.Time, email, passwo
 		return auth.Claims{}, errors.Wrap(err, "selecting single user")
 	}
 
-	// Compare the provided password with the saved hash. Use the bcrypt
-	// comparison function so it is cryptographically secure.
-	if err := bcrypt.CompareHashAndPassword(u.PasswordHash, []byte(password)); err != nil {
-		return auth.Claims{}, ErrAuthenticationFailure
+	now = now.UTC()
+	if u.LockedUntil != nil && now.Before(*u.LockedUntil) {
+		return auth.Claims{}, ErrAccountLocked
+	}
+
+	// Compare the provided password with the saved hash. The comparison
+	// takes constant time so it is cryptographically secure.
+	ok, rehash := checkHash(ctx, u.PasswordHash, password)
+	if !ok {
+		return auth.Claims{}, failAttempt(ctx, db, u.ID, lock, now, ErrAuthenticationFailure)
+	}
+
+	// Only someone knowing the password learns the account is deactivated.
+	if !u.Active {
+		return auth.Claims{}, ErrAccountDeactivated
+	}
+
+	if err := verifyMFA(ctx, db, u.ID, code, lock, now); err != nil {
+		return auth.Claims{}, err
+	}
+
+	// Knowing the password lets us upgrade its hash to the current one.
+	if rehash {
+		hash, err := hashPassword(ctx, password)
+		if err != nil {
+			return auth.Claims{}, errors.Wrap(err, "generating password hash")
+		}
+		const q = `UPDATE users SET password_hash = $2 WHERE user_id = $1`
+		if _, err := db.ExecContext(ctx, q, u.ID, hash); err != nil {
+			return auth.Claims{}, errors.Wrap(err, "upgrading password hash")
+		}
+	}
+
+	if err := recordLogin(ctx, db, u.ID, now); err != nil {
+		return auth.Claims{}, err
 	}
 
 	// If we are this far the request is valid. Create some claims for the user
 	// and generate their token.
-	claims := auth.NewClaims(u.ID, u.Roles, now, time.Hour)
+	return claimsFor(u, now, ttl)
+}
+
+// recordLogin notes that a user signed in at now, which also starts the count
+// of failed attempts over.
+func recordLogin(ctx context.Context, db *sqlx.DB, id string, now time.Time) error {
+	const q = `UPDATE users SET last_login = $2, failed_logins = 0, locked_until = NULL WHERE user_id = $1`
+	if _, err := db.ExecContext(ctx, q, id, now); err != nil {
+		return errors.Wrap(err, "recording login")
+	}
+	return nil
+}
+
+// failLogin counts a failed attempt to authenticate as a user and locks the
+// account once the threshold is reached. The count starts over once the
+// account is locked. It reports whether the account was locked.
+func failLogin(ctx context.Context, db *sqlx.DB, id string, lock Lockout, now time.Time) (bool, error) {
+	if lock.Threshold <= 0 {
+		return false, nil
+	}
+
+	const q = `
+		UPDATE users SET
+			failed_logins = CASE WHEN failed_logins + 1 >= $2 THEN 0 ELSE failed_logins + 1 END,
+			locked_until  = CASE WHEN failed_logins + 1 >= $2 THEN $3::timestamp ELSE locked_until END
+		WHERE user_id = $1
+		RETURNING locked_until`
+
+	var until *time.Time
+	if err := db.GetContext(ctx, &until, q, id, lock.Threshold, now.Add(lock.Cooldown)); err != nil {
+		return false, errors.Wrap(err, "counting failed login")
+	}
+
+	return until != nil && now.Before(*until), nil
+}
+
+// failAttempt counts a failed attempt to prove who a user is, such as with a
+// wrong password or code. It gives ErrAccountLocked when this locks the
+// account and err otherwise.
+func failAttempt(ctx context.Context, db *sqlx.DB, id string, lock Lockout, now time.Time, err error) error {
+	locked, ferr := failLogin(ctx, db, id, lock, now)
+	if ferr != nil {
+		return ferr
+	}
+	if locked {
+		return ErrAccountLocked
+	}
+	return err
+}
+
+// claimsFor creates the claims representing u.
+func claimsFor(u User, now time.Time, ttl time.Duration) (auth.Claims, error) {
+	roles, err := auth.ParseRoles(u.Roles)
+	if err != nil {
+		return auth.Claims{}, errors.Wrap(err, "reading roles")
+	}
+	claims := auth.NewClaims(u.ID, roles, now, ttl)
+	claims.Verified = u.DateVerified != nil
+	if u.TenantID != nil {
+		claims.TenantID = *u.TenantID
+	}
 	return claims, nil
 }
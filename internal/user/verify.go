@@ -0,0 +1,104 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// Predefined errors for verifying email addresses.
+var (
+	ErrInvalidVerifyToken = errors.New("verification token is invalid or has expired")
+	ErrAlreadyVerified    = errors.New("email address is already verified")
+)
+
+// RequestVerification issues a token proving the user owns their email
+// address once they send it back. Only its hash is stored and it expires
+// after ttl.
+func RequestVerification(ctx context.Context, db *sqlx.DB, u *User, ttl time.Duration, now time.Time) (string, error) {
+	if u.DateVerified != nil {
+		return "", ErrAlreadyVerified
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return "", err
+	}
+
+	const q = `
+		INSERT INTO email_verifications
+		(token_hash, user_id, date_expires, date_created)
+		VALUES ($1, $2, $3, $4)`
+
+	now = now.UTC()
+	if _, err := db.ExecContext(ctx, q, hashToken(token), u.ID, now.Add(ttl), now); err != nil {
+		return "", errors.Wrap(err, "inserting verification token")
+	}
+
+	return token, nil
+}
+
+// RetrieveByEmail gets a single user by email address.
+func RetrieveByEmail(ctx context.Context, db *sqlx.DB, email string) (*User, error) {
+	var u User
+	const q = `SELECT * FROM users WHERE email = $1`
+	if err := db.GetContext(ctx, &u, q, email); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "selecting user")
+	}
+	return &u, nil
+}
+
+// Verify marks the email address of the user a verification token was
+// issued to as verified. All of the user's verification tokens are removed.
+func Verify(ctx context.Context, db *sqlx.DB, token string, now time.Time) error {
+	now = now.UTC()
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	var userID string
+	const q = `
+		SELECT user_id FROM email_verifications
+		WHERE token_hash = $1 AND date_expires > $2
+		FOR UPDATE`
+	if err := tx.GetContext(ctx, &userID, q, hashToken(token), now); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrInvalidVerifyToken
+		}
+		return errors.Wrap(err, "selecting verification token")
+	}
+
+	if err := MarkVerified(ctx, tx, userID, now); err != nil {
+		return err
+	}
+
+	const qDelete = `DELETE FROM email_verifications WHERE user_id = $1`
+	if _, err := tx.ExecContext(ctx, qDelete, userID); err != nil {
+		return errors.Wrap(err, "removing verification tokens")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing verification")
+	}
+
+	return nil
+}
+
+// MarkVerified records that the email address of a user is verified without
+// a token, such as for accounts created by an operator.
+func MarkVerified(ctx context.Context, db sqlx.ExecerContext, id string, now time.Time) error {
+	const q = `UPDATE users SET date_verified = $2 WHERE user_id = $1 AND date_verified IS NULL`
+	if _, err := db.ExecContext(ctx, q, id, now.UTC()); err != nil {
+		return errors.Wrap(err, "marking user verified")
+	}
+	return nil
+}
@@ -0,0 +1,4 @@
+// Package warehouse exports the rows of the main tables incrementally to a
+// data warehouse. Rows changed since the previous export are picked up by
+// their date_updated column and handed to a Sink.
+package warehouse
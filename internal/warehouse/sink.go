@@ -0,0 +1,66 @@
+package warehouse
+
+import (
+	"context"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Batch is a set of rows of one table changed up to Until.
+type Batch struct {
+	Table   string
+	Columns []string
+	Rows    [][]string
+	Until   time.Time
+}
+
+// Sink receives exported batches. A Sink must finish writing a batch before
+// returning since the export moves past the batch once it does.
+type Sink interface {
+	Write(ctx context.Context, b Batch) error
+}
+
+// Dir is a Sink writing each batch as a CSV file with a header row to
+// <root>/<table>/<table>-<until>.csv where warehouse loaders can pick it up.
+type Dir struct {
+	Root string
+}
+
+// Write implements the Sink interface. The file is written under a temporary
+// name and renamed so loaders never see a partial file.
+func (d Dir) Write(ctx context.Context, b Batch) error {
+	dir := filepath.Join(d.Root, b.Table)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.Wrap(err, "creating export directory")
+	}
+
+	name := filepath.Join(dir, b.Table+"-"+b.Until.UTC().Format("20060102T150405Z")+".csv")
+	tmp := name + ".tmp"
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return errors.Wrap(err, "creating export file")
+	}
+	defer os.Remove(tmp)
+
+	w := csv.NewWriter(f)
+	w.Write(b.Columns)
+	w.WriteAll(b.Rows)
+	if err := w.Error(); err != nil {
+		f.Close()
+		return errors.Wrap(err, "writing export file")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "closing export file")
+	}
+
+	if err := os.Rename(tmp, name); err != nil {
+		return errors.Wrap(err, "publishing export file")
+	}
+
+	return nil
+}
@@ -0,0 +1,157 @@
+package warehouse
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// lag keeps exports a little behind the clock so rows written by transactions
+// that started before the export but commit after it are not skipped.
+const lag = time.Minute
+
+// table describes what is exported from one table.
+type table struct {
+	name    string
+	columns []string
+}
+
+// tables are exported in this order. Secrets such as password hashes are
+// never exported.
+var tables = []table{
+	{"users", []string{"user_id", "name", "email", "roles", "date_created", "date_updated"}},
+	{"products", []string{"product_id", "user_id", "name", "description", "category", "tags", "cost", "quantity", "date_created", "date_updated"}},
+	{"sales", []string{"sale_id", "product_id", "quantity", "paid", "discount", "currency", "coupon_id", "status", "buyer_id", "date_created", "date_updated"}},
+}
+
+// Exporter exports the rows changed since its previous run.
+type Exporter struct {
+	db   *sqlx.DB
+	log  *log.Logger
+	sink Sink
+}
+
+// NewExporter constructs an Exporter writing to sink.
+func NewExporter(db *sqlx.DB, log *log.Logger, sink Sink) *Exporter {
+	return &Exporter{
+		db:   db,
+		log:  log,
+		sink: sink,
+	}
+}
+
+// Run exports every table. The point each table was exported up to is stored
+// so the next run continues from there. A table failing does not stop the
+// others from being exported.
+func (e *Exporter) Run(ctx context.Context, now time.Time) error {
+	until := now.UTC().Add(-lag)
+
+	var first error
+	for _, t := range tables {
+		n, err := e.export(ctx, t, until)
+		if err != nil {
+			if first == nil {
+				first = errors.Wrapf(err, "exporting %s", t.name)
+			}
+			continue
+		}
+		if n > 0 {
+			e.log.Printf("warehouse : exported %d %s", n, t.name)
+		}
+	}
+
+	return first
+}
+
+// export writes the rows of t changed after its watermark and up to until.
+func (e *Exporter) export(ctx context.Context, t table, until time.Time) (int, error) {
+	var since time.Time
+	const qMark = `SELECT exported_until FROM warehouse_exports WHERE table_name = $1`
+	err := e.db.GetContext(ctx, &since, qMark, t.name)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, errors.Wrap(err, "selecting watermark")
+	}
+	if !until.After(since) {
+		return 0, nil
+	}
+
+	// The table and column names come from the tables list, not user input.
+	q := fmt.Sprintf(
+		`SELECT %s FROM %s WHERE date_updated > $1 AND date_updated <= $2 ORDER BY date_updated`,
+		strings.Join(t.columns, ", "), t.name,
+	)
+	rows, err := e.db.QueryContext(ctx, q, since, until)
+	if err != nil {
+		return 0, errors.Wrap(err, "selecting rows")
+	}
+	defer rows.Close()
+
+	b := Batch{
+		Table:   t.name,
+		Columns: t.columns,
+		Until:   until,
+	}
+	for rows.Next() {
+		vals := make([]interface{}, len(t.columns))
+		ptrs := make([]interface{}, len(t.columns))
+		for i := range vals {
+			ptrs[i] = &vals[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return 0, errors.Wrap(err, "scanning row")
+		}
+
+		row := make([]string, len(vals))
+		for i, v := range vals {
+			row[i] = format(v)
+		}
+		b.Rows = append(b.Rows, row)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, errors.Wrap(err, "reading rows")
+	}
+
+	if len(b.Rows) > 0 {
+		if err := e.sink.Write(ctx, b); err != nil {
+			return 0, err
+		}
+	}
+
+	const qSave = `
+		INSERT INTO warehouse_exports (table_name, exported_until)
+		VALUES ($1, $2)
+		ON CONFLICT (table_name) DO UPDATE SET exported_until = EXCLUDED.exported_until`
+	if _, err := e.db.ExecContext(ctx, qSave, t.name, until); err != nil {
+		return 0, errors.Wrap(err, "saving watermark")
+	}
+
+	return len(b.Rows), nil
+}
+
+// format renders a column value for export. Times use RFC 3339 and NULL is
+// an empty string.
+func format(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case []byte:
+		return string(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}